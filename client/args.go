@@ -0,0 +1,36 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// MarshalProtoArgs encodes the invocation of a chaincode function whose arguments are declared as protobuf
+// message (see ecc_enclave/enclave/dispatcher.h). The result is the (not yet encrypted) argument string
+// passed to the enclave.
+func MarshalProtoArgs(function string, args proto.Message) ([]byte, error) {
+	argsAsBytes, err := proto.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("Can not marshal arguments of %s: %s", function, err)
+	}
+	return json.Marshal([]string{function, base64.StdEncoding.EncodeToString(argsAsBytes)})
+}
@@ -0,0 +1,52 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+)
+
+func TestMarshalProtoArgs(t *testing.T) {
+	args := &timestamp.Timestamp{Seconds: 42, Nanos: 7}
+
+	argsAsBytes, err := MarshalProtoArgs("submit", args)
+	if err != nil {
+		t.Fatalf("MarshalProtoArgs failed: %s", err)
+	}
+
+	var argss []string
+	if err := json.Unmarshal(argsAsBytes, &argss); err != nil {
+		t.Fatalf("Can not unmarshal args: %s", err)
+	}
+	if len(argss) != 2 || argss[0] != "submit" {
+		t.Fatalf("Unexpected args %v", argss)
+	}
+
+	msgAsBytes, _ := base64.StdEncoding.DecodeString(argss[1])
+	decoded := &timestamp.Timestamp{}
+	if err := proto.Unmarshal(msgAsBytes, decoded); err != nil {
+		t.Fatalf("Can not unmarshal message: %s", err)
+	}
+	if !proto.Equal(args, decoded) {
+		t.Fatalf("Expected %v but got %v", args, decoded)
+	}
+}
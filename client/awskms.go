@@ -0,0 +1,209 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSKMSConfig locates a key in AWS Key Management Service. Empty credentials and region are taken from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION environment variables.
+type AWSKMSConfig struct {
+	Region string
+	// KeyID is the id, ARN or alias of an ECC_NIST_P256 key with key usage SIGN_VERIFY
+	KeyID           string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint overrides the KMS endpoint of the region, e.g., for a VPC endpoint
+	Endpoint string
+	// HTTPClient sends the requests to KMS; a client with a timeout of 10 seconds is used if nil
+	HTTPClient *http.Client
+}
+
+// AWSKMSSigner is a crypto.Signer for the certificate key of an Identity held in AWS KMS. The key never leaves
+// KMS; each request of the client is signed by KMS.
+type AWSKMSSigner struct {
+	config AWSKMSConfig
+	client *http.Client
+	pub    crypto.PublicKey
+	// now returns the time requests are signed at
+	now func() time.Time
+}
+
+type awsGetPublicKeyRequest struct {
+	KeyID string `json:"KeyId"`
+}
+
+type awsGetPublicKeyResponse struct {
+	KeySpec   string
+	KeyUsage  string
+	PublicKey []byte
+}
+
+type awsSignRequest struct {
+	KeyID            string `json:"KeyId"`
+	Message          []byte
+	MessageType      string
+	SigningAlgorithm string
+}
+
+type awsSignResponse struct {
+	Signature []byte
+}
+
+// NewAWSKMSSigner returns a signer for the KMS key in config
+func NewAWSKMSSigner(config AWSKMSConfig) (*AWSKMSSigner, error) {
+	if config.Region == "" {
+		config.Region = os.Getenv("AWS_REGION")
+	}
+	if config.AccessKeyID == "" && config.SecretAccessKey == "" {
+		config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if config.Region == "" || config.KeyID == "" || config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, errors.New("AWS region, key id and credentials must not be empty")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://kms." + config.Region + ".amazonaws.com"
+	}
+	k := &AWSKMSSigner{config: config, client: defaultKMSClient(config.HTTPClient), now: time.Now}
+
+	response := &awsGetPublicKeyResponse{}
+	if err := k.do("GetPublicKey", &awsGetPublicKeyRequest{KeyID: config.KeyID}, response); err != nil {
+		return nil, err
+	}
+	if response.KeySpec != "ECC_NIST_P256" || response.KeyUsage != "SIGN_VERIFY" {
+		return nil, fmt.Errorf("KMS key %s must be an ECC_NIST_P256 signing key, got %s for %s", config.KeyID, response.KeySpec, response.KeyUsage)
+	}
+	pub, err := x509.ParsePKIXPublicKey(response.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("Can not parse KMS public key: %s", err)
+	}
+	if k.pub, err = checkKMSKey(pub); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Public returns the public key of the signer
+func (k *AWSKMSSigner) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Sign signs a SHA-256 digest in KMS and returns the ASN.1 encoded ECDSA signature; rand is not used
+func (k *AWSKMSSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 || len(digest) != crypto.SHA256.Size() {
+		return nil, errors.New("AWS KMS signer supports SHA-256 digests only")
+	}
+
+	request := &awsSignRequest{
+		KeyID:            k.config.KeyID,
+		Message:          digest,
+		MessageType:      "DIGEST",
+		SigningAlgorithm: "ECDSA_SHA_256",
+	}
+	response := &awsSignResponse{}
+	if err := k.do("Sign", request, response); err != nil {
+		return nil, err
+	}
+	return response.Signature, nil
+}
+
+// do calls an action of the KMS JSON API
+func (k *AWSKMSSigner) do(action string, request, result interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(k.config.Endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("KMS connection error: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	if k.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", k.config.SessionToken)
+	}
+	signV4(req, body, k.config.AccessKeyID, k.config.SecretAccessKey, k.config.Region, "kms", k.now())
+	return doKMSRequest(k.client, req, result)
+}
+
+// signV4 authenticates a request with AWS signature version 4 over all of its headers and the host
+func signV4(req *http.Request, body []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,97 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignV4(t *testing.T) {
+	// get-vanilla of the AWS signature version 4 test suite
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Fatalf("Unexpected authorization %s", auth)
+	}
+}
+
+func TestAWSKMSSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			json.NewEncoder(w).Encode(&awsGetPublicKeyResponse{KeySpec: "ECC_NIST_P256", KeyUsage: "SIGN_VERIFY", PublicKey: pk})
+		case "TrentService.Sign":
+			request := &awsSignRequest{}
+			if err := json.NewDecoder(r.Body).Decode(request); err != nil || request.KeyID != "alias/fpc" || request.MessageType != "DIGEST" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sig, _ := key.Sign(rand.Reader, request.Message, crypto.SHA256)
+			json.NewEncoder(w).Encode(&awsSignResponse{Signature: sig})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	config := AWSKMSConfig{Region: "us-east-1", KeyID: "alias/fpc", AccessKeyID: "AKID", SecretAccessKey: "secret", Endpoint: server.URL}
+	signer, err := NewAWSKMSSigner(config)
+	if err != nil {
+		t.Fatalf("Can not create KMS signer: %s", err)
+	}
+
+	digest := sha256.Sum256([]byte("request"))
+	sig, err := signer.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("KMS signing failed: %s", err)
+	}
+	if !verifyRaw(t, &key.PublicKey, digest[:], sig) {
+		t.Fatalf("Invalid KMS signature")
+	}
+	if _, err := NewIdentityWithSigner("Org1MSP", newClientCert(t, key, "alice"), signer); err != nil {
+		t.Fatalf("Can not create identity with KMS signer: %s", err)
+	}
+
+	config.AccessKeyID = "other"
+	if _, err := NewAWSKMSSigner(config); err == nil {
+		t.Fatalf("KMS signer with invalid credentials should fail")
+	}
+}
@@ -0,0 +1,213 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// Replica is a peer hosting an enclave of the chaincode
+type Replica interface {
+	Endorser
+	// EnclavePk returns the public key of the enclave hosted by the peer, e.g., as returned by getEnclavePk of ecc
+	EnclavePk() ([]byte, error)
+}
+
+// BalancingStrategy selects the replica of the next invocation
+type BalancingStrategy int
+
+const (
+	// RoundRobin cycles through the healthy replicas
+	RoundRobin BalancingStrategy = iota
+	// LeastLatency picks the healthy replica with the lowest average latency; replicas not invoked yet first
+	LeastLatency
+)
+
+// DefaultUnhealthyPeriod is how long a replica is excluded after it failed or was reported unhealthy
+const DefaultUnhealthyPeriod = 30 * time.Second
+
+// latencyWeight is the weight of the latest latency in the moving average of a replica
+const latencyWeight = 0.2
+
+// LoadBalancer distributes invocations across the replicas hosting active enclaves of a chaincode. Replicas
+// whose enclave is not registered for the chaincode in ercc, or not trusted, are not used. Replicas that fail
+// or return invalid endorsements are excluded for the unhealthy period, and so are replicas reported with
+// ReportUnhealthy.
+type LoadBalancer struct {
+	verifier        *ResponseVerifier
+	chaincodeID     string
+	strategy        BalancingStrategy
+	UnhealthyPeriod time.Duration
+
+	mutex    sync.Mutex
+	replicas []*balancedReplica
+	next     int
+	now      func() time.Time
+}
+
+type balancedReplica struct {
+	replica        Replica
+	enclavePkHash  string
+	latency        time.Duration
+	unhealthyUntil time.Time
+}
+
+// NewLoadBalancer creates a load balancer for the enclaves registered for chaincodeID; verifier checks that
+// the enclaves are trusted and verifies their endorsements
+func NewLoadBalancer(verifier *ResponseVerifier, chaincodeID string, strategy BalancingStrategy) *LoadBalancer {
+	return &LoadBalancer{
+		verifier:        verifier,
+		chaincodeID:     chaincodeID,
+		strategy:        strategy,
+		UnhealthyPeriod: DefaultUnhealthyPeriod,
+		now:             time.Now,
+	}
+}
+
+// Refresh discovers the active enclaves of the chaincode in ercc and balances across the given replicas that
+// host one of them. Call it periodically to pick up new, retired and revoked enclaves.
+func (b *LoadBalancer) Refresh(replicas []Replica) error {
+	enclaves, err := b.verifier.ercc.GetChaincodeEnclaves(b.chaincodeID)
+	if err != nil {
+		return err
+	}
+	active := make(map[string]bool)
+	for _, enclave := range enclaves.Enclaves {
+		active[enclave.EnclavePkHash] = true
+	}
+
+	b.mutex.Lock()
+	previous := make(map[string]*balancedReplica)
+	for _, r := range b.replicas {
+		previous[r.enclavePkHash] = r
+	}
+	b.mutex.Unlock()
+
+	var balanced []*balancedReplica
+	var skipped []error
+	for _, replica := range replicas {
+		enclavePk, err := replica.EnclavePk()
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("Can not get enclave pk of replica: %s", err))
+			continue
+		}
+		enclavePkHash := registry.EnclavePkHash(enclavePk)
+		if !active[enclavePkHash] {
+			skipped = append(skipped, fmt.Errorf("Enclave %s is not registered for %s", enclavePkHash, b.chaincodeID))
+			continue
+		}
+		if err := b.verifier.checkEnclave(enclavePk); err != nil {
+			skipped = append(skipped, fmt.Errorf("Enclave %s is not trusted: %s", enclavePkHash, err))
+			continue
+		}
+		r := &balancedReplica{replica: replica, enclavePkHash: enclavePkHash}
+		// keep latency and health across refreshes
+		if old, ok := previous[enclavePkHash]; ok {
+			r.latency, r.unhealthyUntil = old.latency, old.unhealthyUntil
+		}
+		balanced = append(balanced, r)
+	}
+	if len(balanced) == 0 {
+		return fmt.Errorf("No replica hosts an active enclave of %s: %v", b.chaincodeID, skipped)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.replicas = balanced
+	b.next = 0
+	return nil
+}
+
+// ReportUnhealthy excludes the replica hosting the enclave with the given pk hash for the unhealthy period
+func (b *LoadBalancer) ReportUnhealthy(enclavePkHash string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, r := range b.replicas {
+		if r.enclavePkHash == enclavePkHash {
+			r.unhealthyUntil = b.now().Add(b.UnhealthyPeriod)
+		}
+	}
+}
+
+// Endorse sends args to a healthy replica and returns its verified endorsement. If the replica fails, it is
+// excluded and the next one is tried.
+func (b *LoadBalancer) Endorse(args []byte) (*Endorsement, error) {
+	tried := make(map[*balancedReplica]bool)
+	var errs []error
+	for {
+		r := b.pick(tried)
+		if r == nil {
+			if len(errs) == 0 {
+				return nil, errors.New("No healthy replica")
+			}
+			return nil, fmt.Errorf("All healthy replicas failed: %v", errs)
+		}
+		tried[r] = true
+
+		start := b.now()
+		endorsement, err := r.replica.Endorse(args)
+		if err == nil {
+			_, err = b.verifier.VerifyResponse(args, endorsement.Payload, endorsement.RWSet)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", r.enclavePkHash, err))
+			b.ReportUnhealthy(r.enclavePkHash)
+			continue
+		}
+		b.observe(r, b.now().Sub(start))
+		return endorsement, nil
+	}
+}
+
+// pick returns the next healthy replica not tried yet according to the strategy, nil if there is none
+func (b *LoadBalancer) pick(tried map[*balancedReplica]bool) *balancedReplica {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	var picked *balancedReplica
+	for i := range b.replicas {
+		r := b.replicas[(b.next+i)%len(b.replicas)]
+		if tried[r] || now.Before(r.unhealthyUntil) {
+			continue
+		}
+		if b.strategy == RoundRobin {
+			b.next = (b.next + i + 1) % len(b.replicas)
+			return r
+		}
+		if picked == nil || r.latency < picked.latency {
+			picked = r
+		}
+	}
+	return picked
+}
+
+// observe updates the moving average latency of the replica
+func (b *LoadBalancer) observe(r *balancedReplica, latency time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if r.latency == 0 {
+		r.latency = latency
+		return
+	}
+	r.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(r.latency))
+}
@@ -0,0 +1,134 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// chaincodeQuerier serves getChaincodeEnclaves for the given enclaves and getEnclaveByPk from the registry
+type chaincodeQuerier struct {
+	*registryQuerier
+	enclaves registry.ChaincodeEnclaves
+}
+
+func (q *chaincodeQuerier) Query(chaincodeName string, args [][]byte) ([]byte, error) {
+	if string(args[0]) == "getChaincodeEnclaves" {
+		return json.Marshal(q.enclaves)
+	}
+	return q.registryQuerier.Query(chaincodeName, args)
+}
+
+// countingReplica counts the endorsements of a replica and fails on demand
+type countingReplica struct {
+	*replica
+	count int
+	fail  bool
+}
+
+func (r *countingReplica) Endorse(args []byte) (*Endorsement, error) {
+	r.count++
+	if r.fail {
+		return nil, errors.New("peer unavailable")
+	}
+	return r.replica.Endorse(args)
+}
+
+func (r *countingReplica) EnclavePk() ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(&r.key.PublicKey)
+}
+
+func TestLoadBalancer_Endorse(t *testing.T) {
+	querier := &chaincodeQuerier{registryQuerier: &registryQuerier{records: make(map[string]*registry.EnclaveRecord)}}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+	args := []byte(`["close","auction"]`)
+
+	var replicas []Replica
+	var counting []*countingReplica
+	for i := 0; i < 3; i++ {
+		r := &countingReplica{replica: newReplica(t, querier.registryQuerier, "OK", "ciphertext")}
+		pk, _ := r.EnclavePk()
+		querier.enclaves.Enclaves = append(querier.enclaves.Enclaves, registry.ChaincodeEnclave{ChaincodeID: "ecc", EnclavePkHash: registry.EnclavePkHash(pk)})
+		replicas = append(replicas, r)
+		counting = append(counting, r)
+	}
+	// a replica whose enclave is not registered for the chaincode
+	unregistered := &countingReplica{replica: newReplica(t, querier.registryQuerier, "OK", "ciphertext")}
+	replicas = append(replicas, unregistered)
+
+	now := time.Unix(1000, 0)
+	balancer := NewLoadBalancer(verifier, "ecc", RoundRobin)
+	balancer.now = func() time.Time { return now }
+	if err := balancer.Refresh(replicas); err != nil {
+		t.Fatalf("Refresh failed: %s", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		if _, err := balancer.Endorse(args); err != nil {
+			t.Fatalf("Endorse failed: %s", err)
+		}
+	}
+	for i, r := range counting {
+		if r.count != 2 {
+			t.Fatalf("Replica %d endorsed %d times, expected 2", i, r.count)
+		}
+	}
+	if unregistered.count != 0 {
+		t.Fatalf("Replica of unregistered enclave should not be used")
+	}
+
+	// a failing replica is excluded until the unhealthy period ends
+	counting[0].fail = true
+	for i := 0; i < 4; i++ {
+		if _, err := balancer.Endorse(args); err != nil {
+			t.Fatalf("Endorse should fail over: %s", err)
+		}
+	}
+	if counting[0].count != 3 {
+		t.Fatalf("Failed replica should be excluded, but was invoked %d times", counting[0].count)
+	}
+	counting[0].fail = false
+	now = now.Add(DefaultUnhealthyPeriod)
+	for i := 0; i < 3; i++ {
+		balancer.Endorse(args)
+	}
+	if counting[0].count != 4 {
+		t.Fatalf("Replica should be used again after the unhealthy period")
+	}
+
+	// reported replicas are excluded; without healthy replicas endorsing fails
+	for _, r := range counting {
+		pk, _ := r.EnclavePk()
+		balancer.ReportUnhealthy(registry.EnclavePkHash(pk))
+	}
+	if _, err := balancer.Endorse(args); err == nil {
+		t.Fatalf("Endorse should fail without healthy replicas")
+	}
+
+	querier.enclaves.Enclaves = nil
+	if err := balancer.Refresh(replicas); err == nil {
+		t.Fatalf("Refresh should fail without active enclaves")
+	}
+}
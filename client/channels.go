@@ -0,0 +1,96 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"sync"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// ChannelQuerier evaluates a chaincode function on a channel without submitting a transaction, e.g., for
+// applications connected to a peer that joined many channels. args[0] is the function name followed by its
+// arguments.
+type ChannelQuerier interface {
+	QueryChannel(channelID, chaincodeName string, args [][]byte) ([]byte, error)
+}
+
+// channelQuerier scopes a ChannelQuerier to a single channel
+type channelQuerier struct {
+	querier   ChannelQuerier
+	channelID string
+}
+
+func (q *channelQuerier) Query(chaincodeName string, args [][]byte) ([]byte, error) {
+	return q.querier.QueryChannel(q.channelID, chaincodeName, args)
+}
+
+// NewChannelErccClient creates a client for the enclave registry deployed as chaincodeName on a channel. Unlike
+// clients created with NewErccClient, response verifiers using this client also reject enclaves whose quote is
+// bound to another channel.
+func NewChannelErccClient(querier ChannelQuerier, channelID, chaincodeName string) *ErccClient {
+	c := NewErccClient(&channelQuerier{querier: querier, channelID: channelID}, chaincodeName)
+	c.channelID = channelID
+	return c
+}
+
+// ChannelRegistries gives access to the enclave registries of many channels. The registries are independent:
+// an enclave registered on one channel is unknown on all others, and so are its secrets and policies.
+type ChannelRegistries struct {
+	querier       ChannelQuerier
+	chaincodeName string
+
+	lock    sync.Mutex
+	clients map[string]*ErccClient
+}
+
+// NewChannelRegistries creates access to the enclave registries deployed as chaincodeName on any channel
+func NewChannelRegistries(querier ChannelQuerier, chaincodeName string) *ChannelRegistries {
+	return &ChannelRegistries{querier: querier, chaincodeName: chaincodeName, clients: make(map[string]*ErccClient)}
+}
+
+// Channel returns the client for the registry of a channel
+func (r *ChannelRegistries) Channel(channelID string) *ErccClient {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	c, ok := r.clients[channelID]
+	if !ok {
+		c = NewChannelErccClient(r.querier, channelID, r.chaincodeName)
+		r.clients[channelID] = c
+	}
+	return c
+}
+
+// Verifier returns a verifier for responses of enclaves registered on a channel
+func (r *ChannelRegistries) Verifier(channelID string) *ResponseVerifier {
+	return NewResponseVerifier(r.Channel(channelID))
+}
+
+// ForEachEnclave calls fn for every enclave registered on the given channels, channel by channel. It stops at
+// the first error returned by fn.
+func (r *ChannelRegistries) ForEachEnclave(channelIDs []string, pageSize int32, fn func(channelID string, record registry.EnclaveRecord) error) error {
+	for _, channelID := range channelIDs {
+		err := r.Channel(channelID).ForEachEnclave(pageSize, func(record registry.EnclaveRecord) error {
+			return fn(channelID, record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
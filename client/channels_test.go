@@ -0,0 +1,98 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+)
+
+// channelRecords serves getEnclaveByPk and listEnclaves from the registries of several channels
+type channelRecords map[string]*registry.EnclaveRecord
+
+func (c channelRecords) QueryChannel(channelID, chaincodeName string, args [][]byte) ([]byte, error) {
+	record, ok := c[channelID]
+	if !ok {
+		return nil, errors.New("Enclave does not exist")
+	}
+	if string(args[0]) == "listEnclaves" {
+		return json.Marshal(&registry.EnclavePage{Enclaves: []registry.EnclaveRecord{*record}})
+	}
+	return json.Marshal(record)
+}
+
+func TestChannelRegistries(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	// the registry of channel2 holds a registration replayed from channel1
+	record := &registry.EnclaveRecord{
+		EnclavePkHash:     registry.EnclavePkHash(pk),
+		AttestationReport: attestation.IASAttestationReport{EnclavePk: pk},
+		Binding:           attestation.NewReportDataBinding("tx1", "channel1", "ecc"),
+	}
+	registries := NewChannelRegistries(channelRecords{"channel1": record, "channel2": record}, "ercc")
+	if registries.Channel("channel1") != registries.Channel("channel1") {
+		t.Fatalf("Clients should be reused")
+	}
+
+	var channels []string
+	err = registries.ForEachEnclave([]string{"channel1", "channel2"}, 10, func(channelID string, r registry.EnclaveRecord) error {
+		channels = append(channels, channelID)
+		return nil
+	})
+	if err != nil || len(channels) != 2 || channels[0] != "channel1" || channels[1] != "channel2" {
+		t.Fatalf("Unexpected channels %v: %v", channels, err)
+	}
+
+	args := []byte(`["submit","auction","alice","3"]`)
+	rwset := &kvrwset.KVRWSet{}
+	result := []byte("OK")
+	payload, _ := json.Marshal(&sgxutils.Response{ResponseData: result, Signature: signResponse(t, key, args, result, rwset), PublicKey: pk})
+
+	verifier := registries.Verifier("channel1")
+	verifier.ra = &mock.MockVerifier{}
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err != nil {
+		t.Fatalf("Response should be valid on channel1: %s", err)
+	}
+
+	verifier = registries.Verifier("channel2")
+	verifier.ra = &mock.MockVerifier{}
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err == nil {
+		t.Fatalf("Response of enclave bound to channel1 should be invalid on channel2")
+	}
+
+	verifier = registries.Verifier("channel3")
+	verifier.ra = &mock.MockVerifier{}
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err == nil {
+		t.Fatalf("Response of enclave unknown on channel3 should be invalid")
+	}
+}
@@ -0,0 +1,103 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// compressionZlib is the capability of enclaves to inflate args and deflate responses, see
+	// ecc_enclave/enclave/compress.h
+	compressionZlib = "zlib"
+	// compressionThreshold is the size of args from which on they are compressed
+	compressionThreshold = 256
+	// maxInflatedResponseSize bounds what a compressed response may inflate to
+	maxInflatedResponseSize = 1024 * 1024
+)
+
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// compressEnvelope marks a request envelope as accepting compressed responses and, if that makes them smaller,
+// replaces the args by base64(zlib(args)). The args are compressed after a pseudonym or identity signed them, so
+// the enclave inflates them before it checks the signature.
+func compressEnvelope(envelope []byte) ([]byte, error) {
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(envelope, &fields); err != nil {
+		return nil, err
+	}
+	fields["accept_compression"] = compressionZlib
+
+	args, _ := fields["args"].(string)
+	if len(args) >= compressionThreshold {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		if _, err := w.Write([]byte(args)); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		if compressed := base64.StdEncoding.EncodeToString(buf.Bytes()); len(compressed) < len(args) {
+			fields["args"] = compressed
+			fields["compression"] = compressionZlib
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// unframeResponse returns the response of an enclave to a request accepting compressed responses: a byte 1
+// followed by the zlib compressed response or a byte 0 followed by the response as is
+func unframeResponse(framed []byte) ([]byte, error) {
+	if len(framed) == 0 {
+		return nil, errors.New("Empty response to request accepting compression")
+	}
+	switch framed[0] {
+	case 0:
+		return framed[1:], nil
+	case 1:
+		r, err := zlib.NewReader(bytes.NewReader(framed[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("Can not inflate response: %s", err)
+		}
+		defer r.Close()
+		response, err := ioutil.ReadAll(io.LimitReader(r, maxInflatedResponseSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("Can not inflate response: %s", err)
+		}
+		if len(response) > maxInflatedResponseSize {
+			return nil, errors.New("Response inflates beyond limit")
+		}
+		return response, nil
+	default:
+		return nil, fmt.Errorf("Unknown response framing %d", framed[0])
+	}
+}
@@ -0,0 +1,136 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+)
+
+// compressingEnclave is an enclave contract capable of zlib compression that echoes the args of an invocation
+type compressingEnclave struct {
+	*enclaveContract
+	// compressedArgs counts the invocations with compressed args
+	compressedArgs int
+}
+
+func (c *compressingEnclave) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	pk, _ := x509.MarshalPKIXPublicKey(&c.key.PublicKey)
+	if name == "getEnclavePk" {
+		return json.Marshal(&sgxutils.Response{PublicKey: pk, Capabilities: []string{compressionZlib}})
+	}
+
+	ciphertext, _ := base64.StdEncoding.DecodeString(name)
+	ephemeralPk, _ := base64.StdEncoding.DecodeString(args[0])
+	pub, err := crypto.EnclavePk2ECDSAPK(ephemeralPk)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := crypto.GenSharedKey(pub, c.key)
+	plaintext, err := crypto.Decrypt(ciphertext, key)
+	if err != nil {
+		return nil, err
+	}
+	envelope := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope["accept_compression"] != compressionZlib {
+		return nil, errors.New("compression not accepted")
+	}
+	invocation := envelope["args"]
+	if envelope["compression"] == compressionZlib {
+		compressed, _ := base64.StdEncoding.DecodeString(invocation)
+		r, err := zlib.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		inflated, _ := ioutil.ReadAll(r)
+		invocation = string(inflated)
+		c.compressedArgs++
+	}
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(invocation))
+	w.Close()
+	return json.Marshal(&sgxutils.Response{ResponseData: append([]byte{1}, buf.Bytes()...), PublicKey: pk})
+}
+
+func (c *compressingEnclave) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return c.EvaluateTransaction(name, args...)
+}
+
+func TestSecureContract_Compression(t *testing.T) {
+	querier := &registryQuerier{records: make(map[string]*registry.EnclaveRecord)}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+	contract := &compressingEnclave{enclaveContract: newRegisteredContract(t, querier)}
+	secureContract := NewSecureContract(contract, verifier).WithCompression()
+
+	// small args are sent as they are
+	result, err := secureContract.SubmitTransaction("create", "MyAuction")
+	if err != nil {
+		t.Fatalf("Invocation failed: %s", err)
+	}
+	if string(result) != `["create","MyAuction"]` || contract.compressedArgs != 0 {
+		t.Fatalf("Unexpected result %s", result)
+	}
+
+	document := strings.Repeat(`{"bidder":"alice","value":3},`, 100)
+	result, err = secureContract.SubmitTransaction("store", document)
+	if err != nil {
+		t.Fatalf("Invocation failed: %s", err)
+	}
+	expected, _ := json.Marshal([]string{"store", document})
+	if !bytes.Equal(result, expected) || contract.compressedArgs != 1 {
+		t.Fatalf("Compressed invocation did not round trip")
+	}
+}
+
+func TestUnframeResponse(t *testing.T) {
+	if response, err := unframeResponse([]byte("\x00raw")); err != nil || string(response) != "raw" {
+		t.Fatalf("Uncompressed response not unframed: %s", err)
+	}
+	if _, err := unframeResponse([]byte("\x01garbage")); err == nil {
+		t.Fatalf("Malformed compressed response should be rejected")
+	}
+	if _, err := unframeResponse([]byte("\x02raw")); err == nil {
+		t.Fatalf("Unknown framing should be rejected")
+	}
+
+	// a small response may inflate to a lot
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(make([]byte, maxInflatedResponseSize+1))
+	w.Close()
+	if _, err := unframeResponse(append([]byte{1}, buf.Bytes()...)); err == nil {
+		t.Fatalf("Response inflating beyond the limit should be rejected")
+	}
+}
@@ -0,0 +1,149 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/fips"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+)
+
+// Endorsement is the proposal response of a chaincode enclave
+type Endorsement struct {
+	// Payload is the response payload, i.e., the serialized enclave response
+	Payload []byte
+	// RWSet is the read/write set of the chaincode namespace
+	RWSet *kvrwset.KVRWSet
+}
+
+// NewEndorsement creates an endorsement from the response payload and the serialized read/write set (results)
+// of a proposal response. namespace is the name of the chaincode.
+func NewEndorsement(namespace string, payload, results []byte) (*Endorsement, error) {
+	txRWSet := &rwsetutil.TxRwSet{}
+	if err := txRWSet.FromProtoBytes(results); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal read/write set: %s", err)
+	}
+
+	endorsement := &Endorsement{Payload: payload, RWSet: &kvrwset.KVRWSet{}}
+	for _, ns := range txRWSet.NsRwSets {
+		if ns.NameSpace == namespace {
+			endorsement.RWSet = ns.KvRwSet
+		}
+	}
+	return endorsement, nil
+}
+
+// Endorser sends a proposal with the invocation argument args to one peer hosting a chaincode enclave,
+// e.g., using the fabric sdk
+type Endorser interface {
+	Endorse(args []byte) (*Endorsement, error)
+}
+
+// ConsistencyChecker sends the same proposal to multiple enclave replicas and verifies that their signed
+// results and read/write sets match before the client submits the transaction. This detects divergent or
+// malicious replicas.
+type ConsistencyChecker struct {
+	verifier *ResponseVerifier
+}
+
+// NewConsistencyChecker creates a checker that verifies each endorsement with verifier
+func NewConsistencyChecker(verifier *ResponseVerifier) *ConsistencyChecker {
+	return &ConsistencyChecker{verifier: verifier}
+}
+
+// Check sends args to all endorsers and returns their endorsements if all are valid, come from distinct
+// enclaves and are consistent. Replicas sharing the state key encrypt state deterministically; they are
+// consistent if they return the same result and read the same keys and write the same values.
+func (c *ConsistencyChecker) Check(args []byte, endorsers []Endorser) ([]*Endorsement, error) {
+	if len(endorsers) == 0 {
+		return nil, fmt.Errorf("No endorsers")
+	}
+
+	endorsements := make([]*Endorsement, len(endorsers))
+	errs := make([]error, len(endorsers))
+	var wg sync.WaitGroup
+	for i, endorser := range endorsers {
+		wg.Add(1)
+		go func(i int, endorser Endorser) {
+			defer wg.Done()
+			endorsements[i], errs[i] = endorser.Endorse(args)
+		}(i, endorser)
+	}
+	wg.Wait()
+
+	var result, rwsetDigest []byte
+	enclaves := make(map[string]bool)
+	for i, endorsement := range endorsements {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("Endorser %d failed: %s", i, errs[i])
+		}
+
+		response, err := c.verifier.VerifyResponse(args, endorsement.Payload, endorsement.RWSet)
+		if err != nil {
+			return nil, fmt.Errorf("Endorsement %d invalid: %s", i, err)
+		}
+
+		enclavePkHash := string(response.PublicKey)
+		if enclaves[enclavePkHash] {
+			return nil, fmt.Errorf("Endorsement %d comes from the same enclave as another endorsement", i)
+		}
+		enclaves[enclavePkHash] = true
+
+		digest := rwsetDigestOf(endorsement.RWSet)
+		if i == 0 {
+			result, rwsetDigest = response.ResponseData, digest
+			continue
+		}
+		if !bytes.Equal(response.ResponseData, result) {
+			return nil, fmt.Errorf("Endorsement %d diverges: result does not match", i)
+		}
+		if !bytes.Equal(digest, rwsetDigest) {
+			return nil, fmt.Errorf("Endorsement %d diverges: read/write set does not match", i)
+		}
+	}
+	return endorsements, nil
+}
+
+// rwsetDigestOf returns a digest of the keys read and the keys and values written as signed by the enclave. In FIPS
+// mode, enclaves encrypt state with random IVs, so only the keys written are compared.
+func rwsetDigestOf(rwset *kvrwset.KVRWSet) []byte {
+	readset, writeset := sgxutils.SignedRWSet(rwset)
+	if fips.Enabled {
+		writeKeys := make([][]byte, 0, len(writeset)/2)
+		for i := 0; i < len(writeset); i += 2 {
+			writeKeys = append(writeKeys, writeset[i])
+		}
+		writeset = writeKeys
+	}
+
+	h := sha256.New()
+	for _, keys := range [][][]byte{readset, writeset} {
+		binary.Write(h, binary.BigEndian, uint32(len(keys)))
+		for _, k := range keys {
+			binary.Write(h, binary.BigEndian, uint32(len(k)))
+			h.Write(k)
+		}
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,111 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/fips"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+)
+
+// registryQuerier serves getEnclaveByPk for the registered enclaves
+type registryQuerier struct {
+	records map[string]*registry.EnclaveRecord
+}
+
+func (q *registryQuerier) Query(chaincodeName string, args [][]byte) ([]byte, error) {
+	record, ok := q.records[string(args[1])]
+	if !ok {
+		return nil, errors.New("Enclave does not exist")
+	}
+	return json.Marshal(record)
+}
+
+// replica is an enclave replica that writes value to key and returns result
+type replica struct {
+	t      *testing.T
+	key    *ecdsa.PrivateKey
+	result string
+	value  string
+}
+
+func (r *replica) Endorse(args []byte) (*Endorsement, error) {
+	pk, _ := x509.MarshalPKIXPublicKey(&r.key.PublicKey)
+	rwset := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "k", Value: []byte(r.value)}}}
+	signature := signResponse(r.t, r.key, args, []byte(r.result), rwset)
+	payload, _ := json.Marshal(&sgxutils.Response{ResponseData: []byte(r.result), Signature: signature, PublicKey: pk})
+	return &Endorsement{Payload: payload, RWSet: rwset}, nil
+}
+
+func newReplica(t *testing.T, querier *registryQuerier, result, value string) *replica {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	querier.records[base64.StdEncoding.EncodeToString(pk)] = &registry.EnclaveRecord{
+		EnclavePkHash:     registry.EnclavePkHash(pk),
+		AttestationReport: attestation.IASAttestationReport{EnclavePk: pk},
+	}
+	return &replica{t: t, key: key, result: result, value: value}
+}
+
+func TestConsistencyChecker_Check(t *testing.T) {
+	querier := &registryQuerier{records: make(map[string]*registry.EnclaveRecord)}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+	checker := NewConsistencyChecker(verifier)
+	args := []byte(`["close","auction"]`)
+
+	// replicas sharing the state key write the same ciphertext
+	a := newReplica(t, querier, "OK", "ciphertext")
+	b := newReplica(t, querier, "OK", "ciphertext")
+	endorsements, err := checker.Check(args, []Endorser{a, b})
+	if err != nil {
+		t.Fatalf("Replicas should be consistent: %s", err)
+	}
+	if len(endorsements) != 2 {
+		t.Fatalf("Expected two endorsements but got %d", len(endorsements))
+	}
+
+	divergent := newReplica(t, querier, "AUCTION_ALREADY_CLOSED", "ciphertext")
+	if _, err := checker.Check(args, []Endorser{a, divergent}); err == nil {
+		t.Fatalf("Divergent replica should be detected")
+	}
+
+	// in FIPS mode, enclaves encrypt state with random IVs, so values are not compared
+	otherValue := newReplica(t, querier, "OK", "other ciphertext")
+	if _, err := checker.Check(args, []Endorser{a, otherValue}); (err == nil) != fips.Enabled {
+		t.Fatalf("Replica writing another value should be detected outside FIPS mode: %v", err)
+	}
+
+	if _, err := checker.Check(args, []Endorser{a, a}); err == nil {
+		t.Fatalf("Endorsements of the same enclave should be rejected")
+	}
+}
@@ -0,0 +1,23 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Package client lets applications work with secure chaincode from outside the peer: it verifies enclave
+// responses against the registry (ResponseVerifier), compares the results of enclave replicas (ConsistencyChecker)
+// and builds the arguments of ecc and ercc invocations.
+//
+// The exported API of this package is stable: within a major release, exported identifiers are neither removed
+// nor changed incompatibly. Identifiers to be removed are marked as deprecated for at least one minor release first.
+package client
@@ -0,0 +1,218 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// Querier evaluates a chaincode function without submitting a transaction, e.g., using the fabric sdk.
+// args[0] is the function name followed by its arguments.
+type Querier interface {
+	Query(chaincodeName string, args [][]byte) ([]byte, error)
+}
+
+// ErccClient queries the enclave registry
+type ErccClient struct {
+	querier       Querier
+	chaincodeName string
+	// channelID is set if the client is scoped to a channel, see NewChannelErccClient
+	channelID string
+}
+
+// NewErccClient creates a client for the enclave registry deployed as chaincodeName
+func NewErccClient(querier Querier, chaincodeName string) *ErccClient {
+	return &ErccClient{querier: querier, chaincodeName: chaincodeName}
+}
+
+// ListEnclaves returns a page of registered enclaves starting at bookmark. Use an empty bookmark for the first page.
+// The returned page contains the bookmark for the next page; it is empty if there are no more enclaves.
+func (c *ErccClient) ListEnclaves(pageSize int32, bookmark string) (*registry.EnclavePage, error) {
+	args := [][]byte{[]byte("listEnclaves"), []byte(strconv.Itoa(int(pageSize)))}
+	if bookmark != "" {
+		args = append(args, []byte(bookmark))
+	}
+
+	resp, err := c.querier.Query(c.chaincodeName, args)
+	if err != nil {
+		return nil, fmt.Errorf("listEnclaves failed: %s", err)
+	}
+
+	page := &registry.EnclavePage{}
+	if err := json.Unmarshal(resp, page); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal enclave page: %s", err)
+	}
+	return page, nil
+}
+
+// ForEachEnclave calls fn for every registered enclave by traversing the registry page by page.
+// It stops at the first error returned by fn.
+func (c *ErccClient) ForEachEnclave(pageSize int32, fn func(record registry.EnclaveRecord) error) error {
+	bookmark := ""
+	for {
+		page, err := c.ListEnclaves(pageSize, bookmark)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range page.Enclaves {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+
+		if page.Bookmark == "" || page.Bookmark == bookmark {
+			return nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+// GetEnclaveByPk returns the registration record of the enclave with the given public key (DER-encoded PKIX),
+// e.g., as included in an enclave response
+func (c *ErccClient) GetEnclaveByPk(enclavePk []byte) (*registry.EnclaveRecord, error) {
+	args := [][]byte{[]byte("getEnclaveByPk"), []byte(base64.StdEncoding.EncodeToString(enclavePk))}
+
+	resp, err := c.querier.Query(c.chaincodeName, args)
+	if err != nil {
+		return nil, fmt.Errorf("getEnclaveByPk failed: %s", err)
+	}
+
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(resp, record); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal enclave record: %s", err)
+	}
+	return record, nil
+}
+
+// GetEnclaveIdentity returns the stable identity of an enclave including the history of its keys
+func (c *ErccClient) GetEnclaveIdentity(enclaveID string) (*registry.EnclaveIdentity, error) {
+	args := [][]byte{[]byte("getEnclaveIdentity"), []byte(enclaveID)}
+
+	resp, err := c.querier.Query(c.chaincodeName, args)
+	if err != nil {
+		return nil, fmt.Errorf("getEnclaveIdentity failed: %s", err)
+	}
+
+	identity := &registry.EnclaveIdentity{}
+	if err := json.Unmarshal(resp, identity); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal enclave identity: %s", err)
+	}
+	return identity, nil
+}
+
+// GetChaincodeEnclaves returns the enclaves registered for the chaincode with the given chaincode id
+func (c *ErccClient) GetChaincodeEnclaves(chaincodeID string) (*registry.ChaincodeEnclaves, error) {
+	args := [][]byte{[]byte("getChaincodeEnclaves"), []byte(chaincodeID)}
+
+	resp, err := c.querier.Query(c.chaincodeName, args)
+	if err != nil {
+		return nil, fmt.Errorf("getChaincodeEnclaves failed: %s", err)
+	}
+
+	enclaves := &registry.ChaincodeEnclaves{}
+	if err := json.Unmarshal(resp, enclaves); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal chaincode enclaves: %s", err)
+	}
+	return enclaves, nil
+}
+
+// GetStateCommitment returns the latest Merkle commitment published for the state of chaincode eccName
+func (c *ErccClient) GetStateCommitment(eccName string) (*registry.StateCommitment, error) {
+	args := [][]byte{[]byte("getStateCommitment"), []byte(eccName)}
+
+	resp, err := c.querier.Query(c.chaincodeName, args)
+	if err != nil {
+		return nil, fmt.Errorf("getStateCommitment failed: %s", err)
+	}
+
+	commitment := &registry.StateCommitment{}
+	if err := json.Unmarshal(resp, commitment); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal state commitment: %s", err)
+	}
+	return commitment, nil
+}
+
+// GetRegistryStats returns aggregate counts over all registrations, e.g., for dashboards, without traversing the
+// registry on the client side
+func (c *ErccClient) GetRegistryStats() (*registry.RegistryStats, error) {
+	args := [][]byte{[]byte("getRegistryStats")}
+
+	resp, err := c.querier.Query(c.chaincodeName, args)
+	if err != nil {
+		return nil, fmt.Errorf("getRegistryStats failed: %s", err)
+	}
+
+	stats := &registry.RegistryStats{}
+	if err := json.Unmarshal(resp, stats); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal registry stats: %s", err)
+	}
+	return stats, nil
+}
+
+// GetAlgorithmPolicy returns the algorithms of enclave keys the channel accepts
+func (c *ErccClient) GetAlgorithmPolicy() (*registry.AlgorithmPolicy, error) {
+	args := [][]byte{[]byte("getAlgorithmPolicy")}
+
+	resp, err := c.querier.Query(c.chaincodeName, args)
+	if err != nil {
+		return nil, fmt.Errorf("getAlgorithmPolicy failed: %s", err)
+	}
+
+	policy := &registry.AlgorithmPolicy{}
+	if err := json.Unmarshal(resp, policy); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal algorithm policy: %s", err)
+	}
+	return policy, nil
+}
+
+// NegotiateAlgorithms returns the algorithms an enclave offering the given algorithms should create its key with,
+// i.e., those the channel prefers most
+func (c *ErccClient) NegotiateAlgorithms(offered []algorithm.Algorithms) (algorithm.Algorithms, error) {
+	policy, err := c.GetAlgorithmPolicy()
+	if err != nil {
+		return algorithm.Algorithms{}, err
+	}
+	return policy.Negotiate(offered)
+}
+
+// VerifyStateProof checks an inclusion proof returned by getStateProof of ecc against the latest commitment
+// published for eccName. The proof only verifies if the state did not change since the commitment.
+func (c *ErccClient) VerifyStateProof(eccName string, proof *crypto.MerkleProof) error {
+	commitment, err := c.GetStateCommitment(eccName)
+	if err != nil {
+		return err
+	}
+	root, err := base64.StdEncoding.DecodeString(commitment.Root)
+	if err != nil {
+		return fmt.Errorf("Invalid state commitment root: %s", err)
+	}
+	if proof.Size != commitment.Size {
+		return fmt.Errorf("Proof is for a state of %d keys, commitment %d covers %d keys", proof.Size, commitment.Sequence, commitment.Size)
+	}
+	if err := proof.Verify(root); err != nil {
+		return fmt.Errorf("Invalid state proof for %s: %s", proof.Key, err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// mockQuerier serves listEnclaves from a sorted list of enclave pk hashes
+type mockQuerier struct {
+	keys []string
+}
+
+func (q *mockQuerier) Query(chaincodeName string, args [][]byte) ([]byte, error) {
+	pageSize, _ := strconv.Atoi(string(args[1]))
+
+	start := 0
+	if len(args) == 3 {
+		for i, k := range q.keys {
+			if k == string(args[2]) {
+				start = i
+			}
+		}
+	}
+
+	page := &registry.EnclavePage{}
+	for i := start; i < len(q.keys) && i < start+pageSize; i++ {
+		page.Enclaves = append(page.Enclaves, registry.EnclaveRecord{EnclavePkHash: q.keys[i]})
+		page.FetchedRecordsCount++
+	}
+	if start+pageSize < len(q.keys) {
+		page.Bookmark = q.keys[start+pageSize]
+	}
+	return json.Marshal(page)
+}
+
+func TestErccClient_ForEachEnclave(t *testing.T) {
+	querier := &mockQuerier{keys: []string{"a", "b", "c", "d", "e"}}
+	client := NewErccClient(querier, "ercc")
+
+	var visited []string
+	err := client.ForEachEnclave(2, func(record registry.EnclaveRecord) error {
+		visited = append(visited, record.EnclavePkHash)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachEnclave returned error %s", err)
+	}
+
+	if len(visited) != len(querier.keys) {
+		t.Fatalf("Expected %v but visited %v", querier.keys, visited)
+	}
+	for i := range visited {
+		if visited[i] != querier.keys[i] {
+			t.Fatalf("Expected %v but visited %v", querier.keys, visited)
+		}
+	}
+}
+
+// commitmentQuerier serves getStateCommitment
+type commitmentQuerier struct {
+	commitment registry.StateCommitment
+}
+
+func (q *commitmentQuerier) Query(chaincodeName string, args [][]byte) ([]byte, error) {
+	return json.Marshal(q.commitment)
+}
+
+func TestErccClient_VerifyStateProof(t *testing.T) {
+	tree, _ := crypto.NewMerkleTree([]crypto.MerkleLeaf{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("2")}, {Key: "c", Value: []byte("3")}})
+	querier := &commitmentQuerier{registry.StateCommitment{Root: base64.StdEncoding.EncodeToString(tree.Root()), Size: tree.Size()}}
+	client := NewErccClient(querier, "ercc")
+
+	proof, _ := tree.Proof("b")
+	if err := client.VerifyStateProof("ecc", proof); err != nil {
+		t.Fatalf("VerifyStateProof rejected valid proof: %s", err)
+	}
+
+	proof.Value = []byte("other")
+	if err := client.VerifyStateProof("ecc", proof); err == nil {
+		t.Fatalf("VerifyStateProof accepted proof of a modified value")
+	}
+
+	changed, _ := crypto.NewMerkleTree([]crypto.MerkleLeaf{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("2")}})
+	proof, _ = changed.Proof("b")
+	if err := client.VerifyStateProof("ecc", proof); err == nil {
+		t.Fatalf("VerifyStateProof accepted proof against another state")
+	}
+}
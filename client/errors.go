@@ -0,0 +1,67 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+)
+
+// InvocationError is an invocation the enclave refused or the chaincode failed, with one of the codes of
+// utils.ErrorResponse, e.g., utils.ErrorAccessDenied. The enclave encrypts the message to the client; the peer only
+// learns the code. Message is empty if the enclave could not decrypt the request.
+type InvocationError struct {
+	Code    int
+	Message string
+}
+
+func (e *InvocationError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Invocation failed with code %d", e.Code)
+	}
+	return fmt.Sprintf("Invocation failed with code %d: %s", e.Code, e.Message)
+}
+
+// invocationError returns the InvocationError in the error of a call, its details decrypted with the key of the
+// request, or err itself if the call failed before it reached the enclave
+func invocationError(err error, key []byte) error {
+	response, ok := sgxutils.ParseErrorResponse(err.Error())
+	if !ok {
+		return err
+	}
+	invocationErr := &InvocationError{Code: response.Code}
+	if len(response.Details) == 0 {
+		return invocationErr
+	}
+	// details that do not decrypt are not from the enclave; the code still is what the peer reported
+	plaintext, decryptErr := crypto.Decrypt(response.Details, key)
+	if decryptErr != nil {
+		return invocationErr
+	}
+	details := struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{}
+	if json.Unmarshal(plaintext, &details) == nil {
+		invocationErr.Code = details.Code
+		invocationErr.Message = details.Message
+	}
+	return invocationErr
+}
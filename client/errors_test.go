@@ -0,0 +1,109 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+)
+
+// failingEnclave is an enclave contract whose chaincode fails every invocation with the function name as reason,
+// wrapped like the error of a fabric sdk
+type failingEnclave struct {
+	*enclaveContract
+	// tampered replaces the encrypted details by garbage
+	tampered bool
+}
+
+func (c *failingEnclave) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	if name == "getEnclavePk" {
+		return c.enclaveContract.EvaluateTransaction(name, args...)
+	}
+	if c.down {
+		return nil, errors.New("enclave unavailable")
+	}
+
+	ciphertext, _ := base64.StdEncoding.DecodeString(name)
+	ephemeralPk, _ := base64.StdEncoding.DecodeString(args[0])
+	pub, err := crypto.EnclavePk2ECDSAPK(ephemeralPk)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := crypto.GenSharedKey(pub, c.key)
+	plaintext, err := crypto.Decrypt(ciphertext, key)
+	if err != nil {
+		return nil, err
+	}
+	envelope := &requestEnvelope{}
+	json.Unmarshal(plaintext, envelope)
+	var invocation []string
+	json.Unmarshal([]byte(envelope.Args), &invocation)
+
+	details, _ := json.Marshal(map[string]interface{}{"code": sgxutils.ErrorChaincode, "message": invocation[0] + " failed"})
+	response := &sgxutils.ErrorResponse{Code: sgxutils.ErrorChaincode}
+	if response.Details, err = crypto.Encrypt(details, key); err != nil {
+		return nil, err
+	}
+	if c.tampered {
+		response.Details = []byte("garbage")
+	}
+	return nil, fmt.Errorf("transaction returned with failure: %s (status 500)", response)
+}
+
+func (c *failingEnclave) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return c.EvaluateTransaction(name, args...)
+}
+
+func TestSecureContract_InvocationError(t *testing.T) {
+	querier := &registryQuerier{records: make(map[string]*registry.EnclaveRecord)}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+	contract := &failingEnclave{enclaveContract: newRegisteredContract(t, querier)}
+	secureContract := NewSecureContract(contract, verifier)
+
+	_, err := secureContract.SubmitTransaction("close", "MyAuction")
+	invocationErr, ok := err.(*InvocationError)
+	if !ok {
+		t.Fatalf("Expected an invocation error, got %v", err)
+	}
+	if invocationErr.Code != sgxutils.ErrorChaincode || invocationErr.Message != "close failed" {
+		t.Fatalf("Unexpected invocation error %v", invocationErr)
+	}
+
+	// details the client can not decrypt are dropped
+	contract.tampered = true
+	_, err = secureContract.SubmitTransaction("close", "MyAuction")
+	invocationErr, ok = err.(*InvocationError)
+	if !ok || invocationErr.Code != sgxutils.ErrorChaincode || invocationErr.Message != "" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// errors before the enclave are passed on as they are
+	contract.down = true
+	_, err = secureContract.EvaluateTransaction("close", "MyAuction")
+	if _, ok := err.(*InvocationError); ok || err == nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
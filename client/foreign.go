@@ -0,0 +1,75 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// VerifyForeignEnclave returns the enclave imported from channelID with the given public key (DER-encoded PKIX) if
+// the registry of this channel trusts it, as chaincodes of this channel see it via verifyForeignEnclave
+func (c *ErccClient) VerifyForeignEnclave(channelID string, enclavePk []byte) (*registry.ForeignEnclave, error) {
+	resp, err := c.querier.Query(c.chaincodeName, queryArgs("verifyForeignEnclave", []string{channelID, base64.StdEncoding.EncodeToString(enclavePk)}))
+	if err != nil {
+		return nil, fmt.Errorf("verifyForeignEnclave failed: %s", err)
+	}
+
+	foreign := &registry.ForeignEnclave{}
+	if err := json.Unmarshal(resp, foreign); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal foreign enclave: %s", err)
+	}
+	return foreign, nil
+}
+
+// ImportForeignEnclave relays the registration of an enclave on the channel of source, a client scoped to that
+// channel (see NewChannelErccClient), to the registry target submits transactions to. The registry of the target
+// channel verifies the attestation evidence of the registration itself and applies its own policies. Relaying the
+// registration again refreshes the import and carries over revocations.
+func ImportForeignEnclave(source *ErccClient, target Contract, enclavePk []byte) (*registry.ForeignEnclave, error) {
+	if source.channelID == "" {
+		return nil, errors.New("Source registry is not scoped to a channel")
+	}
+
+	record, err := source.GetEnclaveByPk(enclavePk)
+	if err != nil {
+		return nil, err
+	}
+	// check the record ourselves first; an invalid one is rejected by the target channel anyway
+	if err := registry.CheckForeignRecord(source.channelID, record); err != nil {
+		return nil, fmt.Errorf("Invalid enclave record of channel %s: %s", source.channelID, err)
+	}
+
+	recordAsBytes, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := target.SubmitTransaction("importForeignEnclave", source.channelID, string(recordAsBytes))
+	if err != nil {
+		return nil, fmt.Errorf("importForeignEnclave failed: %s", err)
+	}
+
+	foreign := &registry.ForeignEnclave{}
+	if err := json.Unmarshal(resp, foreign); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal foreign enclave: %s", err)
+	}
+	return foreign, nil
+}
@@ -0,0 +1,90 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// enclaveChannels serves getEnclaveByPk of the ercc of each channel
+type enclaveChannels struct {
+	records map[string]*registry.EnclaveRecord
+}
+
+func (q *enclaveChannels) QueryChannel(channelID, chaincodeName string, args [][]byte) ([]byte, error) {
+	return json.Marshal(q.records[channelID])
+}
+
+// importingChannel records imports like ercc on the target channel
+type importingChannel struct {
+	imported []registry.ForeignEnclave
+}
+
+func (c *importingChannel) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return nil, nil
+}
+
+func (c *importingChannel) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	foreign := registry.ForeignEnclave{ChannelID: args[0], ImportedBy: "Org1MSP"}
+	if err := json.Unmarshal([]byte(args[1]), &foreign.Record); err != nil {
+		return nil, err
+	}
+	c.imported = append(c.imported, foreign)
+	return json.Marshal(&foreign)
+}
+
+func TestImportForeignEnclave(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	enclavePk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	channels := &enclaveChannels{records: map[string]*registry.EnclaveRecord{
+		"ch2": {
+			EnclavePkHash:     registry.EnclavePkHash(enclavePk),
+			AttestationReport: attestation.IASAttestationReport{EnclavePk: enclavePk},
+			Binding:           &attestation.ReportDataBinding{ChannelID: "ch2", ChaincodeID: "cc"},
+		},
+	}}
+	registries := NewChannelRegistries(channels, "ercc")
+	target := &importingChannel{}
+
+	foreign, err := ImportForeignEnclave(registries.Channel("ch2"), target, enclavePk)
+	if err != nil {
+		t.Fatalf("ImportForeignEnclave failed: %s", err)
+	}
+	if foreign.ChannelID != "ch2" || foreign.Record.EnclavePkHash != registry.EnclavePkHash(enclavePk) || len(target.imported) != 1 {
+		t.Fatalf("Unexpected import %+v", foreign)
+	}
+
+	// records of a quote bound to another channel are not relayed
+	channels.records["ch3"] = channels.records["ch2"]
+	if _, err := ImportForeignEnclave(registries.Channel("ch3"), target, enclavePk); err == nil {
+		t.Fatalf("ImportForeignEnclave should reject records bound to another channel")
+	}
+	if _, err := ImportForeignEnclave(NewErccClient(nil, "ercc"), target, enclavePk); err == nil {
+		t.Fatalf("ImportForeignEnclave should require a source scoped to a channel")
+	}
+	if len(target.imported) != 1 {
+		t.Fatalf("Invalid records should not be submitted")
+	}
+}
@@ -0,0 +1,401 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+)
+
+// Contract is the part of the fabric-sdk-go gateway programming model used to invoke a chaincode, i.e.,
+// a *gateway.Contract obtained with network.GetContract can be passed as is
+type Contract interface {
+	EvaluateTransaction(name string, args ...string) ([]byte, error)
+	SubmitTransaction(name string, args ...string) ([]byte, error)
+}
+
+// SecureContract wraps a gateway contract of a chaincode running in an enclave. It offers the same
+// EvaluateTransaction and SubmitTransaction functions but encrypts the invocation to the enclave and only
+// returns results produced by that enclave. The enclave key is checked against ercc before first use.
+type SecureContract struct {
+	verifier *ResponseVerifier
+
+	mutex     sync.Mutex
+	contract  Contract
+	enclavePk []byte
+	// failover holds the contracts of all replicas, see WithFailover
+	failover []Contract
+	// revoked is set if ercc reported that the enclave in use lost trust
+	revoked    bool
+	idempotent map[string]bool
+	// pseudonym authenticates pseudonymous requests, see WithPseudonym
+	pseudonym *Pseudonym
+	// identity authenticates requests with the enrollment identity of the client, see WithIdentity
+	identity *Identity
+	// lastSeq is the sequence number of the latest request, see requestEnvelope
+	lastSeq uint64
+	// compression enables compression if the enclave in use has the capability, see WithCompression
+	compression  bool
+	capabilities []string
+}
+
+// requestEnvelope is the plaintext of a request without identity as opened by the enclave. Each request carries a
+// sequence number, the time of the client in nanoseconds since the epoch as decimal string, by which the enclave
+// refuses replays of pseudonymous requests and requests with identity (see ecc_enclave/enclave/replay.h).
+type requestEnvelope struct {
+	Args string `json:"args"`
+	Seq  string `json:"seq"`
+}
+
+// NewSecureContract wraps contract; verifier checks that the chaincode enclave is registered and trusted
+func NewSecureContract(contract Contract, verifier *ResponseVerifier) *SecureContract {
+	return &SecureContract{contract: contract, verifier: verifier, idempotent: make(map[string]bool)}
+}
+
+// WithFailover lets the contract fail over to the enclaves behind alternates, e.g., gateway contracts targeting
+// other peers, once the enclave in use gets revoked. Evaluations and submissions of idempotent functions are
+// then retried with the new enclave instead of failing.
+func (c *SecureContract) WithFailover(alternates ...Contract) *SecureContract {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failover = append([]Contract{c.contract}, alternates...)
+	return c
+}
+
+// WithPseudonym makes all invocations pseudonymous requests of nym. Results are encrypted to the pseudonym by the
+// enclave and decrypted by the contract.
+func (c *SecureContract) WithPseudonym(nym *Pseudonym) *SecureContract {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pseudonym = nym
+	return c
+}
+
+// WithIdentity signs all invocations with the enrollment identity of the client, so that the enclave can enforce
+// the access policies of the chaincode. A contract can not use both an identity and a pseudonym.
+func (c *SecureContract) WithIdentity(identity *Identity) *SecureContract {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.identity = identity
+	return c
+}
+
+// WithCompression compresses large args and accepts compressed responses if the enclave in use is capable of it,
+// e.g., to reduce the size of transactions with JSON documents. Compression happens before encryption.
+func (c *SecureContract) WithCompression() *SecureContract {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.compression = true
+	return c
+}
+
+// SetIdempotent marks chaincode functions whose submission may be repeated safely after a failover
+func (c *SecureContract) SetIdempotent(names ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, name := range names {
+		c.idempotent[name] = true
+	}
+}
+
+// HandleRegistryEvent processes a chaincode event of ercc, e.g., received with the event service of the fabric
+// sdk. If the event tells that the enclave in use was flagged, revoked or pruned, the next invocation fails
+// over to another enclave.
+func (c *SecureContract) HandleRegistryEvent(eventName string, payload []byte) error {
+	var affected []string
+	switch eventName {
+	case registry.TrustChangedEventName:
+		var changes []registry.TrustChange
+		if err := json.Unmarshal(payload, &changes); err != nil {
+			return fmt.Errorf("Can not unmarshal trust changes: %s", err)
+		}
+		for _, change := range changes {
+			affected = append(affected, change.EnclavePkHash)
+		}
+	case registry.RegistryPrunedEventName:
+		var tombstones []registry.Tombstone
+		if err := json.Unmarshal(payload, &tombstones); err != nil {
+			return fmt.Errorf("Can not unmarshal tombstones: %s", err)
+		}
+		for _, tombstone := range tombstones {
+			affected = append(affected, tombstone.EnclavePkHash)
+		}
+	default:
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.enclavePk == nil {
+		return nil
+	}
+	enclavePkHash := registry.EnclavePkHash(c.enclavePk)
+	for _, pkHash := range affected {
+		if pkHash == enclavePkHash {
+			c.revoked = true
+		}
+	}
+	return nil
+}
+
+// EvaluateTransaction evaluates the chaincode function name with args in the enclave and returns its result
+func (c *SecureContract) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return c.invokeWithFailover(Contract.EvaluateTransaction, true, name, args)
+}
+
+// SubmitTransaction invokes the chaincode function name with args in the enclave, submits the transaction
+// and returns its result once committed. The enclave signature over the read/write set is checked by the
+// ecc vscc at commit time.
+func (c *SecureContract) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	c.mutex.Lock()
+	idempotent := c.idempotent[name]
+	c.mutex.Unlock()
+	return c.invokeWithFailover(Contract.SubmitTransaction, idempotent, name, args)
+}
+
+// Reset drops the cached enclave key, e.g., after the enclave was upgraded
+func (c *SecureContract) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.enclavePk = nil
+	c.capabilities = nil
+	c.revoked = false
+}
+
+// invokeWithFailover invokes the enclave in use, failing over first if it was revoked. If the invocation fails
+// and ercc no longer trusts the enclave, a retryable invocation is repeated once with another enclave.
+func (c *SecureContract) invokeWithFailover(call func(Contract, string, ...string) ([]byte, error), retryable bool, name string, args []string) ([]byte, error) {
+	c.mutex.Lock()
+	revoked := c.revoked
+	c.mutex.Unlock()
+	if revoked {
+		if err := c.failOver(); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := c.invoke(call, name, args)
+	if err == nil || !retryable || !c.canFailOver() {
+		return result, err
+	}
+	if trustErr := c.checkEnclaveInUse(); trustErr == nil {
+		return nil, err
+	}
+	if err := c.failOver(); err != nil {
+		return nil, err
+	}
+	return c.invoke(call, name, args)
+}
+
+// canFailOver returns true if alternate contracts are configured
+func (c *SecureContract) canFailOver() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.failover) > 1
+}
+
+// checkEnclaveInUse checks the enclave in use against ercc again
+func (c *SecureContract) checkEnclaveInUse() error {
+	c.mutex.Lock()
+	enclavePk := c.enclavePk
+	c.mutex.Unlock()
+	if enclavePk == nil {
+		return nil
+	}
+	return c.verifier.checkEnclave(enclavePk)
+}
+
+// failOver switches to the first contract whose enclave is trusted and not the one in use
+func (c *SecureContract) failOver() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var errs []error
+	for _, contract := range c.failover {
+		enclavePk, capabilities, err := c.fetchEnclavePk(contract)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if bytes.Equal(enclavePk, c.enclavePk) {
+			continue
+		}
+		c.contract, c.enclavePk, c.capabilities, c.revoked = contract, enclavePk, capabilities, false
+		return nil
+	}
+	return fmt.Errorf("No trusted enclave to fail over to: %v", errs)
+}
+
+func (c *SecureContract) invoke(call func(Contract, string, ...string) ([]byte, error), name string, args []string) ([]byte, error) {
+	enclavePk, err := c.getEnclavePk()
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	contract := c.contract
+	c.mutex.Unlock()
+
+	ephemeralPk, key, err := crypto.DeriveEnclaveKey(enclavePk)
+	if err != nil {
+		return nil, fmt.Errorf("Can not encrypt arguments: %s", err)
+	}
+	request, err := c.sealRequest(name, args, ephemeralPk)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := crypto.Encrypt(request.plaintext, key)
+	if err != nil {
+		return nil, fmt.Errorf("Can not encrypt arguments: %s", err)
+	}
+
+	// ecc expects the encrypted arguments followed by the client pk in sgx format
+	payload, err := call(contract, base64.StdEncoding.EncodeToString(ciphertext), base64.StdEncoding.EncodeToString(ephemeralPk))
+	if err != nil {
+		return nil, invocationError(err, key)
+	}
+	result, err := openResponse(payload, enclavePk)
+	if err != nil {
+		return nil, err
+	}
+	return request.open(result, enclavePk)
+}
+
+// sealedRequest is the plaintext of a request and what it takes to open the response
+type sealedRequest struct {
+	plaintext []byte
+	// pseudonym, if any, to which the enclave encrypts the response
+	pseudonym *Pseudonym
+	// compressed is set if the client accepted a compressed response, see compressEnvelope
+	compressed bool
+}
+
+// open returns the result of the enclave response data to the request
+func (r *sealedRequest) open(result, enclavePk []byte) ([]byte, error) {
+	var err error
+	if r.pseudonym != nil {
+		if result, err = r.pseudonym.open(result, enclavePk); err != nil {
+			return nil, err
+		}
+	}
+	if r.compressed {
+		return unframeResponse(result)
+	}
+	return result, nil
+}
+
+// sealRequest returns the plaintext of a request in its envelope, bound to clientPk (sgx format) if the contract
+// uses a pseudonym or an identity
+func (c *SecureContract) sealRequest(name string, args []string, clientPk []byte) (*sealedRequest, error) {
+	c.mutex.Lock()
+	pseudonym := c.pseudonym
+	identity := c.identity
+	compressed := c.compression && hasCapability(c.capabilities, compressionZlib)
+	c.mutex.Unlock()
+	if pseudonym != nil && identity != nil {
+		return nil, errors.New("Requests can not be both pseudonymous and with identity")
+	}
+
+	plaintext, err := json.Marshal(append([]string{name}, args...))
+	if err != nil {
+		return nil, err
+	}
+	seq := strconv.FormatUint(c.nextSeq(), 10)
+	if pseudonym != nil {
+		plaintext, err = pseudonym.seal(plaintext, clientPk, seq)
+	} else if identity != nil {
+		plaintext, err = identity.seal(plaintext, clientPk, seq)
+	} else {
+		plaintext, err = json.Marshal(&requestEnvelope{Args: string(plaintext), Seq: seq})
+	}
+	if err == nil && compressed {
+		plaintext, err = compressEnvelope(plaintext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sealedRequest{plaintext: plaintext, pseudonym: pseudonym, compressed: compressed}, nil
+}
+
+// openResponse returns the response data of an enclave response, which must come from the enclave with enclavePk
+func openResponse(payload, enclavePk []byte) ([]byte, error) {
+	response := &sgxutils.Response{}
+	if err := json.Unmarshal(payload, response); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal enclave response: %s", err)
+	}
+	if !consttime.Equal(response.PublicKey, enclavePk) {
+		return nil, errors.New("Response not produced by the attested enclave")
+	}
+	return response.ResponseData, nil
+}
+
+// nextSeq returns the sequence number of a new request, the current time in nanoseconds unless requests follow
+// each other faster than the clock moves
+func (c *SecureContract) nextSeq() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	seq := uint64(time.Now().UnixNano())
+	if seq <= c.lastSeq {
+		seq = c.lastSeq + 1
+	}
+	c.lastSeq = seq
+	return seq
+}
+
+// getEnclavePk returns the key of the chaincode enclave once checked against ercc
+func (c *SecureContract) getEnclavePk() ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.enclavePk != nil {
+		return c.enclavePk, nil
+	}
+
+	enclavePk, capabilities, err := c.fetchEnclavePk(c.contract)
+	if err != nil {
+		return nil, err
+	}
+	c.enclavePk, c.capabilities = enclavePk, capabilities
+	return c.enclavePk, nil
+}
+
+// fetchEnclavePk returns the key of the enclave behind contract once checked against ercc, and its capabilities
+func (c *SecureContract) fetchEnclavePk(contract Contract) ([]byte, []string, error) {
+	payload, err := contract.EvaluateTransaction("getEnclavePk")
+	if err != nil {
+		return nil, nil, fmt.Errorf("getEnclavePk failed: %s", err)
+	}
+	response := &sgxutils.Response{}
+	if err := json.Unmarshal(payload, response); err != nil {
+		return nil, nil, fmt.Errorf("Can not unmarshal enclave response: %s", err)
+	}
+	if err := c.verifier.checkEnclave(response.PublicKey); err != nil {
+		return nil, nil, err
+	}
+	return response.PublicKey, response.Capabilities, nil
+}
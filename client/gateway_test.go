@@ -0,0 +1,195 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+)
+
+// enclaveContract is an ecc instance that decrypts the invocation and echoes the function name
+type enclaveContract struct {
+	key         *ecdsa.PrivateKey
+	responseKey *ecdsa.PrivateKey
+	// invocations counts the invocations; if down is set, they fail
+	invocations int
+	down        bool
+	// invoked holds the decrypted invocations
+	invoked [][]string
+}
+
+func (c *enclaveContract) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	if name == "getEnclavePk" {
+		pk, _ := x509.MarshalPKIXPublicKey(&c.key.PublicKey)
+		return json.Marshal(&sgxutils.Response{PublicKey: pk})
+	}
+
+	c.invocations++
+	if c.down {
+		return nil, errors.New("enclave unavailable")
+	}
+	ciphertext, _ := base64.StdEncoding.DecodeString(name)
+	ephemeralPk, _ := base64.StdEncoding.DecodeString(args[0])
+	pub, err := crypto.EnclavePk2ECDSAPK(ephemeralPk)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := crypto.GenSharedKey(pub, c.key)
+	plaintext, err := crypto.Decrypt(ciphertext, key)
+	if err != nil {
+		return nil, fmt.Errorf("Can not decrypt: %s", err)
+	}
+	envelope := &requestEnvelope{}
+	if err := json.Unmarshal(plaintext, envelope); err != nil {
+		return nil, err
+	}
+	if _, err := strconv.ParseUint(envelope.Seq, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid sequence number: %s", err)
+	}
+	var invocation []string
+	if err := json.Unmarshal([]byte(envelope.Args), &invocation); err != nil {
+		return nil, err
+	}
+	c.invoked = append(c.invoked, invocation)
+
+	pk, _ := x509.MarshalPKIXPublicKey(&c.responseKey.PublicKey)
+	return json.Marshal(&sgxutils.Response{ResponseData: []byte(invocation[0] + ":" + invocation[1]), PublicKey: pk})
+}
+
+func (c *enclaveContract) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return c.EvaluateTransaction(name, args...)
+}
+
+func TestSecureContract(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	querier := &registryQuerier{records: make(map[string]*registry.EnclaveRecord)}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+	contract := &enclaveContract{key: key, responseKey: key}
+	secureContract := NewSecureContract(contract, verifier)
+
+	// the enclave is not registered
+	if _, err := secureContract.EvaluateTransaction("create", "MyAuction"); err == nil {
+		t.Fatalf("Invocation of unregistered enclave should fail")
+	}
+
+	querier.records[base64.StdEncoding.EncodeToString(pk)] = &registry.EnclaveRecord{
+		EnclavePkHash:     registry.EnclavePkHash(pk),
+		AttestationReport: attestation.IASAttestationReport{EnclavePk: pk},
+	}
+	result, err := secureContract.SubmitTransaction("create", "MyAuction")
+	if err != nil {
+		t.Fatalf("Invocation failed: %s", err)
+	}
+	if string(result) != "create:MyAuction" {
+		t.Fatalf("Unexpected result %s", result)
+	}
+
+	// the peer returns a response of another enclave
+	contract.responseKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := secureContract.EvaluateTransaction("eval", "MyAuction"); err == nil {
+		t.Fatalf("Response of another enclave should be rejected")
+	}
+}
+
+// newRegisteredContract returns an enclave contract with its enclave registered at querier
+func newRegisteredContract(t *testing.T, querier *registryQuerier) *enclaveContract {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	querier.records[base64.StdEncoding.EncodeToString(pk)] = &registry.EnclaveRecord{
+		EnclavePkHash:     registry.EnclavePkHash(pk),
+		AttestationReport: attestation.IASAttestationReport{EnclavePk: pk},
+	}
+	return &enclaveContract{key: key, responseKey: key}
+}
+
+func TestSecureContract_Failover(t *testing.T) {
+	querier := &registryQuerier{records: make(map[string]*registry.EnclaveRecord)}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+
+	a := newRegisteredContract(t, querier)
+	b := newRegisteredContract(t, querier)
+	c := newRegisteredContract(t, querier)
+	secureContract := NewSecureContract(a, verifier).WithFailover(b, c)
+	secureContract.SetIdempotent("getBid")
+
+	if _, err := secureContract.EvaluateTransaction("eval", "MyAuction"); err != nil || a.invocations != 1 {
+		t.Fatalf("Invocation should go to the first enclave: %s", err)
+	}
+
+	// a fails; as it is still trusted, the failure is surfaced
+	a.down = true
+	if _, err := secureContract.EvaluateTransaction("eval", "MyAuction"); err == nil {
+		t.Fatalf("Failure of a trusted enclave should be surfaced")
+	}
+
+	// a gets revoked; evaluations and idempotent submissions are retried with b
+	pkA, _ := x509.MarshalPKIXPublicKey(&a.key.PublicKey)
+	querier.records[base64.StdEncoding.EncodeToString(pkA)].Status = &registry.EnclaveStatus{QuoteStatus: registry.QuoteStatusKeyRevoked}
+	result, err := secureContract.EvaluateTransaction("eval", "MyAuction")
+	if err != nil {
+		t.Fatalf("Evaluation should fail over: %s", err)
+	}
+	if string(result) != "eval:MyAuction" || b.invocations != 1 {
+		t.Fatalf("Evaluation should be answered by b")
+	}
+
+	// b gets revoked too; a non-idempotent submission is not retried
+	b.down = true
+	pkB, _ := x509.MarshalPKIXPublicKey(&b.key.PublicKey)
+	querier.records[base64.StdEncoding.EncodeToString(pkB)].Status = &registry.EnclaveStatus{QuoteStatus: registry.QuoteStatusKeyRevoked}
+	if _, err := secureContract.SubmitTransaction("submit", "MyAuction"); err == nil {
+		t.Fatalf("Non-idempotent submission should not be retried")
+	}
+	if _, err := secureContract.SubmitTransaction("getBid", "MyAuction"); err != nil || c.invocations != 1 {
+		t.Fatalf("Idempotent submission should fail over to c: %s", err)
+	}
+
+	// ercc reports that c was flagged; the next invocation fails over before invoking c, but no other
+	// enclave is trusted
+	pkC, _ := x509.MarshalPKIXPublicKey(&c.key.PublicKey)
+	event, _ := json.Marshal([]registry.TrustChange{{EnclavePkHash: registry.EnclavePkHash(pkC), Action: registry.AdvisoryActionFlag}})
+	if err := secureContract.HandleRegistryEvent(registry.TrustChangedEventName, event); err != nil {
+		t.Fatalf("HandleRegistryEvent failed: %s", err)
+	}
+	if _, err := secureContract.EvaluateTransaction("eval", "MyAuction"); err == nil || c.invocations != 1 {
+		t.Fatalf("Flagged enclave should not be invoked")
+	}
+}
@@ -0,0 +1,144 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// Identity lets a client prove its enrollment identity, i.e., its MSP ID and certificate, to chaincode enclaves.
+// The certificate key signs each request inside the encryption to the enclave, binding it to the request, so the
+// peer can neither forge nor replay it with other arguments. The enclave verifies the signature and evaluates the
+// access policies of the chaincode over the certificate attributes (see ecc_enclave/enclave/abac.h) before the
+// chaincode runs.
+type Identity struct {
+	mspID   string
+	certPEM []byte
+	signer  crypto.Signer
+}
+
+// creatorEnvelope is the plaintext of a request with creator identity as opened by the enclave
+type creatorEnvelope struct {
+	Args       string `json:"args"`
+	MspID      string `json:"msp_id"`
+	Creator    string `json:"creator"`
+	CreatorSig string `json:"creator_sig"`
+	Seq        string `json:"seq"`
+}
+
+// NewIdentity returns the identity of a client enrolled at mspID with the PEM encoded certificate and its key.
+// Enclaves support P-256 keys only.
+func NewIdentity(mspID string, certPEM []byte, key *ecdsa.PrivateKey) (*Identity, error) {
+	return NewIdentityWithSigner(mspID, certPEM, key)
+}
+
+// NewIdentityWithSigner is like NewIdentity for a certificate key held by signer, e.g., a key in a key management
+// service (see NewVaultSigner and NewAWSKMSSigner), so the application never holds the private key. The signer
+// signs SHA-256 digests and returns ASN.1 encoded ECDSA signatures like ecdsa.PrivateKey.
+func NewIdentityWithSigner(mspID string, certPEM []byte, signer crypto.Signer) (*Identity, error) {
+	if mspID == "" {
+		return nil, errors.New("MSP ID must not be empty")
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("Can not decode certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Can not parse certificate: %s", err)
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, errors.New("Certificate key must be a P-256 key")
+	}
+	key, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		return nil, errors.New("Key does not match certificate")
+	}
+
+	return &Identity{mspID: mspID, certPEM: certPEM, signer: signer}, nil
+}
+
+// seal wraps the json encoded invocation args in an envelope signed with the certificate key. The signature binds
+// the args to ephemeralPk (sgx format), the key the request is encrypted with; seq is the sequence number of the
+// request, see requestEnvelope.
+func (i *Identity) seal(args, ephemeralPk []byte, seq string) ([]byte, error) {
+	h := sha256.Sum256(append(append([]byte{}, args...), ephemeralPk...))
+	der, err := i.signer.Sign(rand.Reader, h[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("Can not sign with identity: %s", err)
+	}
+	sig, err := rawSignature(der)
+	if err != nil {
+		return nil, fmt.Errorf("Can not sign with identity: %s", err)
+	}
+
+	return json.Marshal(&creatorEnvelope{
+		Args:       string(args),
+		MspID:      i.mspID,
+		Creator:    base64.StdEncoding.EncodeToString(i.certPEM),
+		CreatorSig: base64.StdEncoding.EncodeToString(sig),
+		Seq:        seq,
+	})
+}
+
+// Approve signs a statement, e.g., registry.SecretStatement of a secret to provision, for enclaves to verify.
+// The approval is passed to ercc by the creator of the identity; enclaves check the attributes of its certificate.
+func (i *Identity) Approve(statement []byte) (*registry.Approval, error) {
+	h := sha256.Sum256(statement)
+	der, err := i.signer.Sign(rand.Reader, h[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("Can not sign with identity: %s", err)
+	}
+	sig, err := rawSignature(der)
+	if err != nil {
+		return nil, fmt.Errorf("Can not sign with identity: %s", err)
+	}
+	return &registry.Approval{MspID: i.mspID, Certificate: i.certPEM, Signature: sig}, nil
+}
+
+// rawSignature converts an ASN.1 encoded P-256 signature to r || s, 32 bytes each, as expected by the enclave
+func rawSignature(der []byte) ([]byte, error) {
+	var rs struct {
+		R, S *big.Int
+	}
+	if rest, err := asn1.Unmarshal(der, &rs); err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed ECDSA signature")
+	}
+	if rs.R.Sign() <= 0 || rs.S.Sign() <= 0 || rs.R.BitLen() > 256 || rs.S.BitLen() > 256 {
+		return nil, errors.New("malformed ECDSA signature")
+	}
+	sig := make([]byte, 64)
+	copy(sig[32-len(rs.R.Bytes()):32], rs.R.Bytes())
+	copy(sig[64-len(rs.S.Bytes()):], rs.S.Bytes())
+	return sig, nil
+}
@@ -0,0 +1,182 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/fips"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+)
+
+// identityEnclaveContract opens requests with creator identity like the enclave and returns the msp id, the
+// subject of the certificate and the function name
+type identityEnclaveContract struct {
+	key *ecdsa.PrivateKey
+}
+
+func (c *identityEnclaveContract) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	pk, _ := x509.MarshalPKIXPublicKey(&c.key.PublicKey)
+	if name == "getEnclavePk" {
+		return json.Marshal(&sgxutils.Response{PublicKey: pk})
+	}
+
+	ciphertext, _ := base64.StdEncoding.DecodeString(name)
+	ephemeralPk, _ := base64.StdEncoding.DecodeString(args[0])
+	pub, err := crypto.EnclavePk2ECDSAPK(ephemeralPk)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := crypto.GenSharedKey(pub, c.key)
+	plaintext, err := crypto.Decrypt(ciphertext, key)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &creatorEnvelope{}
+	if err := json.Unmarshal(plaintext, envelope); err != nil {
+		return nil, err
+	}
+	certPEM, _ := base64.StdEncoding.DecodeString(envelope.Creator)
+	sig, _ := base64.StdEncoding.DecodeString(envelope.CreatorSig)
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(append([]byte(envelope.Args), ephemeralPk...))
+	if !ecdsa.Verify(cert.PublicKey.(*ecdsa.PublicKey), h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, errors.New("invalid creator signature")
+	}
+
+	var invocation []string
+	if err := json.Unmarshal([]byte(envelope.Args), &invocation); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&sgxutils.Response{ResponseData: []byte(envelope.MspID + ":" + cert.Subject.CommonName + ":" + invocation[0]), PublicKey: pk})
+}
+
+func (c *identityEnclaveContract) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return c.EvaluateTransaction(name, args...)
+}
+
+// newClientCert returns a self-signed certificate (PEM) of key with the given common name
+func newClientCert(t *testing.T, key *ecdsa.PrivateKey, commonName string) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, OrganizationalUnit: []string{"client"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Can not create certificate: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestIdentity(t *testing.T) {
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	certPEM := newClientCert(t, clientKey, "alice")
+
+	if _, err := NewIdentity("Org1MSP", certPEM, otherKey); err == nil {
+		t.Fatalf("Key not matching the certificate should be rejected")
+	}
+	if _, err := NewIdentity("", certPEM, clientKey); err == nil {
+		t.Fatalf("Empty MSP ID should be rejected")
+	}
+	identity, err := NewIdentity("Org1MSP", certPEM, clientKey)
+	if err != nil {
+		t.Fatalf("Can not create identity: %s", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	querier := &registryQuerier{records: map[string]*registry.EnclaveRecord{
+		base64.StdEncoding.EncodeToString(pk): {
+			EnclavePkHash:     registry.EnclavePkHash(pk),
+			AttestationReport: attestation.IASAttestationReport{EnclavePk: pk},
+		},
+	}}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+
+	contract := NewSecureContract(&identityEnclaveContract{key: key}, verifier).WithIdentity(identity)
+	result, err := contract.EvaluateTransaction("bid", "42")
+	if err != nil {
+		t.Fatalf("Invocation with identity failed: %s", err)
+	}
+	if string(result) != "Org1MSP:alice:bid" {
+		t.Fatalf("Unexpected result %s", result)
+	}
+
+	if !fips.Enabled {
+		nym, _ := NewPseudonym(make([]byte, 32), "auction")
+		if _, err := contract.WithPseudonym(nym).EvaluateTransaction("bid", "42"); err == nil {
+			t.Fatalf("Invocation with both identity and pseudonym should fail")
+		}
+	}
+}
+
+func TestIdentity_Approve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	identity, err := NewIdentity("Org1MSP", newClientCert(t, key, "provisioner"), key)
+	if err != nil {
+		t.Fatalf("Can not create identity: %s", err)
+	}
+
+	statement := registry.SecretStatement("apiKey", []byte("ciphertext"))
+	approval, err := identity.Approve(statement)
+	if err != nil {
+		t.Fatalf("Can not approve: %s", err)
+	}
+	if approval.MspID != "Org1MSP" {
+		t.Fatalf("Unexpected MSP ID %s", approval.MspID)
+	}
+	if _, err := approval.Verify(statement); err != nil {
+		t.Fatalf("Approval should be valid: %s", err)
+	}
+	if _, err := approval.Verify(registry.SecretStatement("otherKey", []byte("ciphertext"))); err == nil {
+		t.Fatalf("Approval should not cover another secret")
+	}
+}
@@ -0,0 +1,78 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// kmsTimeout bounds requests to a key management service if the caller does not provide an HTTP client
+const kmsTimeout = 10 * time.Second
+
+// maxKMSResponseSize bounds the responses of a key management service read by the client
+const maxKMSResponseSize = 1 << 20
+
+// defaultKMSClient returns client or, if nil, an HTTP client with kmsTimeout
+func defaultKMSClient(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+	return &http.Client{Timeout: kmsTimeout}
+}
+
+// doKMSRequest sends a request to a key management service and decodes the JSON response into result. Errors
+// returned by the service are reported with the status and body of the response.
+func doKMSRequest(client *http.Client, req *http.Request, result interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("KMS connection error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxKMSResponseSize+1))
+	if err != nil {
+		return fmt.Errorf("Can not read KMS response: %s", err)
+	}
+	if len(body) > maxKMSResponseSize {
+		return errors.New("KMS response too large")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("KMS returned error: Code %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("Can not parse KMS response: %s", err)
+	}
+	return nil
+}
+
+// checkKMSKey returns the P-256 public key of a key management service key or an error if it is of another type
+func checkKMSKey(pub interface{}) (*ecdsa.PublicKey, error) {
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, errors.New("KMS key must be a P-256 key")
+	}
+	return key, nil
+}
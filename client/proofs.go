@@ -0,0 +1,59 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"fmt"
+
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+)
+
+// ProofVerifier verifies proofs of one type attached to enclave responses, e.g., a zk-SNARK verifier for the
+// chaincode, as defense in depth beyond the enclave signature
+type ProofVerifier interface {
+	// Verify returns an error unless proof shows that responseData is the correct result of args
+	Verify(args, responseData, proof []byte) error
+}
+
+// SetProofVerifier registers the verifier of proofs of proofType. If required is true, responses without a proof
+// of this type are rejected. Proofs of types without a verifier are ignored.
+func (v *ResponseVerifier) SetProofVerifier(proofType string, verifier ProofVerifier, required bool) {
+	v.proofs[proofType] = verifier
+	v.requiredProofs[proofType] = required
+}
+
+// verifyProofs verifies all proofs attached to response that have a verifier and checks that the required ones
+// are present
+func (v *ResponseVerifier) verifyProofs(args []byte, response *sgxutils.Response) error {
+	verified := make(map[string]bool)
+	for _, proof := range response.Proofs {
+		verifier, ok := v.proofs[proof.Type]
+		if !ok {
+			continue
+		}
+		if err := verifier.Verify(args, response.ResponseData, proof.Data); err != nil {
+			return fmt.Errorf("Verification of %s proof failed: %s", proof.Type, err)
+		}
+		verified[proof.Type] = true
+	}
+	for proofType, required := range v.requiredProofs {
+		if required && !verified[proofType] {
+			return fmt.Errorf("Response lacks required %s proof", proofType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+)
+
+// echoProofVerifier accepts proofs equal to the response data
+type echoProofVerifier struct{}
+
+func (v *echoProofVerifier) Verify(args, responseData, proof []byte) error {
+	if !bytes.Equal(responseData, proof) {
+		return errors.New("proof does not match")
+	}
+	return nil
+}
+
+func TestResponseVerifier_VerifyProofs(t *testing.T) {
+	verifier := NewResponseVerifier(nil)
+	args := []byte(`["eval","auction"]`)
+	response := &sgxutils.Response{ResponseData: []byte("alice")}
+
+	// no verifiers, no proofs
+	if err := verifier.verifyProofs(args, response); err != nil {
+		t.Fatalf("Response without proofs should be valid: %s", err)
+	}
+
+	verifier.SetProofVerifier("echo", &echoProofVerifier{}, false)
+	response.Proofs = []sgxutils.Proof{{Type: "unknown", Data: []byte("x")}}
+	if err := verifier.verifyProofs(args, response); err != nil {
+		t.Fatalf("Proofs without verifier should be ignored: %s", err)
+	}
+
+	verifier.SetProofVerifier("echo", &echoProofVerifier{}, true)
+	if err := verifier.verifyProofs(args, response); err == nil {
+		t.Fatalf("Response without required proof should be invalid")
+	}
+
+	response.Proofs = append(response.Proofs, sgxutils.Proof{Type: "echo", Data: []byte("bob")})
+	if err := verifier.verifyProofs(args, response); err == nil {
+		t.Fatalf("Response with invalid proof should be invalid")
+	}
+
+	response.Proofs[1].Data = []byte("alice")
+	if err := verifier.verifyProofs(args, response); err != nil {
+		t.Fatalf("Response with valid proof should be valid: %s", err)
+	}
+}
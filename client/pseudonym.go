@@ -0,0 +1,131 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/fips"
+)
+
+// pseudonymLabel separates pseudonym keys from other keys derived from the same secret
+const pseudonymLabel = "FPC client pseudonym"
+
+// minPseudonymSecretSize is the minimum size of the secret pseudonyms are derived from
+const minPseudonymSecretSize = 16
+
+// Pseudonym lets a client authenticate to chaincode enclaves without revealing who it is, e.g., a client
+// authenticated to fabric with an Identity Mixer (idemix) credential whose transactions are unlinkable. The
+// pseudonym key signs each request inside the encryption to the enclave, so neither the peer nor the ledger see
+// it; the enclave verifies the signature, exposes the pseudonym to the chaincode for access control (see
+// get_creator_nym in ecc_enclave/enclave/shim.h) and encrypts the response to the pseudonym key.
+//
+// A pseudonym is derived from a client secret, e.g., the user secret of the idemix credential, and a scope, e.g.,
+// the chaincode name. The same secret and scope always give the same pseudonym, so chaincodes can recognize the
+// client across requests, whereas pseudonyms of different scopes can not be linked to each other.
+type Pseudonym struct {
+	key *ecdsa.PrivateKey
+}
+
+// nymEnvelope is the plaintext of a pseudonymous request as opened by the enclave
+type nymEnvelope struct {
+	Args   string `json:"args"`
+	Nym    string `json:"nym"`
+	NymSig string `json:"nym_sig"`
+	Seq    string `json:"seq"`
+}
+
+// NewPseudonym derives the pseudonym of the client with the given secret in scope. Pseudonyms are not available in
+// FIPS mode, as their keys are not generated as approved by FIPS 186.
+func NewPseudonym(secret []byte, scope string) (*Pseudonym, error) {
+	if err := fips.CheckFeature("Pseudonym key derivation"); err != nil {
+		return nil, err
+	}
+	if len(secret) < minPseudonymSecretSize {
+		return nil, fmt.Errorf("Pseudonym secret must have at least %d bytes", minPseudonymSecretSize)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(pseudonymLabel))
+	mac.Write([]byte(scope))
+
+	// d = h mod (n-1) + 1 is a valid private key; the bias is negligible for a 256 bit h
+	curve := elliptic.P256()
+	n := new(big.Int).Sub(curve.Params().N, big.NewInt(1))
+	d := new(big.Int).SetBytes(mac.Sum(nil))
+	d.Mod(d, n).Add(d, big.NewInt(1))
+
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	return &Pseudonym{key: key}, nil
+}
+
+// ID returns the pseudonym as seen by the chaincode, i.e., the base64 encoded SHA-256 hash of the pseudonym key in
+// sgx format. Use it, e.g., to grant the pseudonym access to an asset.
+func (p *Pseudonym) ID() string {
+	h := sha256.Sum256(crypto.MarshalSgxPk(&p.key.PublicKey))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// seal wraps the json encoded invocation args in an envelope signed with the pseudonym key. The signature binds
+// the args to ephemeralPk (sgx format), the key the request is encrypted with; seq is the sequence number of the
+// request, see requestEnvelope.
+func (p *Pseudonym) seal(args, ephemeralPk []byte, seq string) ([]byte, error) {
+	h := sha256.Sum256(append(append([]byte{}, args...), ephemeralPk...))
+	r, s, err := ecdsa.Sign(rand.Reader, p.key, h[:])
+	if err != nil {
+		return nil, fmt.Errorf("Can not sign with pseudonym: %s", err)
+	}
+	sig := make([]byte, 64)
+	copy(sig[32-len(r.Bytes()):32], r.Bytes())
+	copy(sig[64-len(s.Bytes()):], s.Bytes())
+
+	return json.Marshal(&nymEnvelope{
+		Args:   string(args),
+		Nym:    base64.StdEncoding.EncodeToString(crypto.MarshalSgxPk(&p.key.PublicKey)),
+		NymSig: base64.StdEncoding.EncodeToString(sig),
+		Seq:    seq,
+	})
+}
+
+// open decrypts a response the enclave with the given public key (DER-encoded PKIX) encrypted to the pseudonym
+func (p *Pseudonym) open(ciphertext, enclavePk []byte) ([]byte, error) {
+	enclavePub, err := crypto.ParseECDSAPubKey(enclavePk)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.GenSharedKey(enclavePub, p.key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := crypto.Decrypt(ciphertext, key)
+	if err != nil {
+		return nil, errors.New("Can not decrypt response to pseudonym: " + err.Error())
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,139 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/fips"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+)
+
+// nymEnclaveContract opens pseudonymous requests like the enclave and returns the pseudonym and function name
+// encrypted to the pseudonym
+type nymEnclaveContract struct {
+	key *ecdsa.PrivateKey
+}
+
+func (c *nymEnclaveContract) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	pk, _ := x509.MarshalPKIXPublicKey(&c.key.PublicKey)
+	if name == "getEnclavePk" {
+		return json.Marshal(&sgxutils.Response{PublicKey: pk})
+	}
+
+	ciphertext, _ := base64.StdEncoding.DecodeString(name)
+	ephemeralPk, _ := base64.StdEncoding.DecodeString(args[0])
+	pub, err := crypto.EnclavePk2ECDSAPK(ephemeralPk)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := crypto.GenSharedKey(pub, c.key)
+	plaintext, err := crypto.Decrypt(ciphertext, key)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &nymEnvelope{}
+	if err := json.Unmarshal(plaintext, envelope); err != nil {
+		return nil, err
+	}
+	nym, _ := base64.StdEncoding.DecodeString(envelope.Nym)
+	sig, _ := base64.StdEncoding.DecodeString(envelope.NymSig)
+	nymPub, err := crypto.EnclavePk2ECDSAPK(nym)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(append([]byte(envelope.Args), ephemeralPk...))
+	if !ecdsa.Verify(nymPub, h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, errors.New("invalid pseudonym signature")
+	}
+
+	var invocation []string
+	if err := json.Unmarshal([]byte(envelope.Args), &invocation); err != nil {
+		return nil, err
+	}
+	nymHash := sha256.Sum256(nym)
+	responseKey, _ := crypto.GenSharedKey(nymPub, c.key)
+	responseData, _ := crypto.Encrypt([]byte(base64.StdEncoding.EncodeToString(nymHash[:])+":"+invocation[0]), responseKey)
+	return json.Marshal(&sgxutils.Response{ResponseData: responseData, PublicKey: pk})
+}
+
+func (c *nymEnclaveContract) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return c.EvaluateTransaction(name, args...)
+}
+
+func TestPseudonym(t *testing.T) {
+	secret := bytes.Repeat([]byte{7}, 32)
+	if fips.Enabled {
+		if _, err := NewPseudonym(secret, "auction"); err == nil {
+			t.Fatalf("Pseudonyms should not be available in FIPS mode")
+		}
+		return
+	}
+
+	nym, err := NewPseudonym(secret, "auction")
+	if err != nil {
+		t.Fatalf("Can not create pseudonym: %s", err)
+	}
+	again, _ := NewPseudonym(secret, "auction")
+	other, _ := NewPseudonym(secret, "voting")
+	if nym.ID() != again.ID() {
+		t.Fatalf("Pseudonym must be stable per secret and scope")
+	}
+	if nym.ID() == other.ID() {
+		t.Fatalf("Pseudonyms of different scopes must differ")
+	}
+	if _, err := NewPseudonym([]byte("short"), "auction"); err == nil {
+		t.Fatalf("Short secret should be rejected")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	querier := &registryQuerier{records: map[string]*registry.EnclaveRecord{
+		base64.StdEncoding.EncodeToString(pk): {
+			EnclavePkHash:     registry.EnclavePkHash(pk),
+			AttestationReport: attestation.IASAttestationReport{EnclavePk: pk},
+		},
+	}}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+
+	result, err := NewSecureContract(&nymEnclaveContract{key: key}, verifier).WithPseudonym(nym).EvaluateTransaction("bid", "42")
+	if err != nil {
+		t.Fatalf("Pseudonymous invocation failed: %s", err)
+	}
+	if string(result) != nym.ID()+":bid" {
+		t.Fatalf("Unexpected result %s", result)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// QuorumAggregator sends the same proposal to multiple enclave replicas and proceeds once a quorum of distinct
+// platforms returns the same signed result. Unlike the ConsistencyChecker, it tolerates failed, invalid and
+// divergent endorsements, e.g., of compromised SGX hosts. To tolerate f faulty hosts, the quorum must be at
+// least f+1, and at least 2f+1 endorsers on distinct platforms must be asked to still reach it.
+type QuorumAggregator struct {
+	verifier *ResponseVerifier
+	quorum   int
+	// platformOf returns the platform of a registered enclave; replaced in tests
+	platformOf func(record *registry.EnclaveRecord) (string, error)
+}
+
+// NewQuorumAggregator creates an aggregator that verifies each endorsement with verifier and requires quorum
+// distinct platforms to agree
+func NewQuorumAggregator(verifier *ResponseVerifier, quorum int) *QuorumAggregator {
+	return &QuorumAggregator{verifier: verifier, quorum: quorum, platformOf: platformOf}
+}
+
+// Aggregate sends args to all endorsers and returns the endorsements of the quorum, one per platform. Enclaves
+// on the same platform, as identified by the EPID pseudonym, count once, as they share the same host. As in
+// ConsistencyChecker, endorsements agree if they return the same result and read/write set.
+func (a *QuorumAggregator) Aggregate(args []byte, endorsers []Endorser) ([]*Endorsement, error) {
+	if a.quorum < 1 {
+		return nil, fmt.Errorf("Invalid quorum %d", a.quorum)
+	}
+	if len(endorsers) < a.quorum {
+		return nil, fmt.Errorf("%d endorsers can not reach a quorum of %d", len(endorsers), a.quorum)
+	}
+
+	endorsements := make([]*Endorsement, len(endorsers))
+	errs := make([]error, len(endorsers))
+	var wg sync.WaitGroup
+	for i, endorser := range endorsers {
+		wg.Add(1)
+		go func(i int, endorser Endorser) {
+			defer wg.Done()
+			endorsements[i], errs[i] = endorser.Endorse(args)
+		}(i, endorser)
+	}
+	wg.Wait()
+
+	// endorsements grouped by result and read/write set, one per platform
+	groups := make(map[string]*quorumGroup)
+	var order []string
+	var failures []error
+	for i, endorsement := range endorsements {
+		if errs[i] != nil {
+			failures = append(failures, fmt.Errorf("Endorser %d failed: %s", i, errs[i]))
+			continue
+		}
+		response, err := a.verifier.VerifyResponse(args, endorsement.Payload, endorsement.RWSet)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("Endorsement %d invalid: %s", i, err))
+			continue
+		}
+		record, err := a.verifier.ercc.GetEnclaveByPk(response.PublicKey)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("Endorsement %d: %s", i, err))
+			continue
+		}
+		platform, err := a.platformOf(record)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("Endorsement %d: %s", i, err))
+			continue
+		}
+
+		group := string(response.ResponseData) + "\x00" + string(rwsetDigestOf(endorsement.RWSet))
+		if groups[group] == nil {
+			groups[group] = &quorumGroup{platforms: make(map[string]bool)}
+			order = append(order, group)
+		}
+		if !groups[group].platforms[platform] {
+			groups[group].platforms[platform] = true
+			groups[group].endorsements = append(groups[group].endorsements, endorsement)
+		}
+	}
+
+	var agreed []*Endorsement
+	for _, group := range order {
+		if len(groups[group].endorsements) < a.quorum {
+			continue
+		}
+		if agreed != nil {
+			return nil, fmt.Errorf("Conflicting results reach a quorum of %d; the quorum is too small", a.quorum)
+		}
+		agreed = groups[group].endorsements
+	}
+	if agreed == nil {
+		return nil, fmt.Errorf("No quorum of %d distinct platforms agrees; %d endorsements failed: %v", a.quorum, len(failures), failures)
+	}
+	return agreed, nil
+}
+
+// quorumGroup holds agreeing endorsements from distinct platforms
+type quorumGroup struct {
+	platforms    map[string]bool
+	endorsements []*Endorsement
+}
+
+// platformOf returns the platform id of the enclave registered with record, see registry.PlatformID
+func platformOf(record *registry.EnclaveRecord) (string, error) {
+	quote, err := attestation.QuoteFromAttestionReport(record.AttestationReport)
+	if err != nil {
+		return "", fmt.Errorf("Can not parse quote: %s", err)
+	}
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(record.AttestationReport.IASReportBody, &reportBody); err != nil {
+		return "", fmt.Errorf("Can not parse report body: %s", err)
+	}
+	return registry.PlatformID(quote, reportBody), nil
+}
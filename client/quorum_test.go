@@ -0,0 +1,87 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// failingEndorser is a peer that does not respond
+type failingEndorser struct{}
+
+func (e *failingEndorser) Endorse(args []byte) (*Endorsement, error) {
+	return nil, errors.New("timeout")
+}
+
+func TestQuorumAggregator_Aggregate(t *testing.T) {
+	querier := &registryQuerier{records: make(map[string]*registry.EnclaveRecord)}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+	args := []byte(`["close","auction"]`)
+
+	a := newReplica(t, querier, "OK", "ciphertext")
+	b := newReplica(t, querier, "OK", "ciphertext")
+	c := newReplica(t, querier, "OK", "ciphertext")
+	divergent := newReplica(t, querier, "AUCTION_ALREADY_CLOSED", "ciphertext")
+
+	// every replica runs on its own platform, except sameHost which shares the platform of a
+	sameHost := newReplica(t, querier, "OK", "ciphertext")
+	pkHashOf := func(r *replica) string {
+		pk, _ := x509.MarshalPKIXPublicKey(&r.key.PublicKey)
+		return registry.EnclavePkHash(pk)
+	}
+
+	// tolerate one faulty host
+	aggregator := NewQuorumAggregator(verifier, 2)
+	aggregator.platformOf = func(record *registry.EnclaveRecord) (string, error) {
+		if record.EnclavePkHash == pkHashOf(sameHost) {
+			return pkHashOf(a), nil
+		}
+		return record.EnclavePkHash, nil
+	}
+
+	endorsements, err := aggregator.Aggregate(args, []Endorser{a, divergent, b})
+	if err != nil {
+		t.Fatalf("Quorum should be reached despite a divergent replica: %s", err)
+	}
+	if len(endorsements) != 2 {
+		t.Fatalf("Expected two endorsements but got %d", len(endorsements))
+	}
+
+	if _, err := aggregator.Aggregate(args, []Endorser{a, &failingEndorser{}, c}); err != nil {
+		t.Fatalf("Quorum should be reached despite a failed endorser: %s", err)
+	}
+
+	if _, err := aggregator.Aggregate(args, []Endorser{a, sameHost, divergent}); err == nil {
+		t.Fatalf("Enclaves on the same platform should count once")
+	}
+
+	if _, err := aggregator.Aggregate(args, []Endorser{a}); err == nil {
+		t.Fatalf("Too few endorsers should be rejected")
+	}
+
+	// with a quorum of one, conflicting results both reach it
+	aggregator.quorum = 1
+	if _, err := aggregator.Aggregate(args, []Endorser{a, divergent}); err == nil {
+		t.Fatalf("Conflicting quorums should be rejected")
+	}
+}
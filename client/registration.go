@@ -0,0 +1,51 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+// RegistrationArgs requests the attestation report for the quote of an enclave from IAS, or an IAS proxy (see
+// iasproxy.NewClient), and returns the ercc function and arguments registering the enclave with that report.
+// If chaincodeID is set, the quote must be bound to the registration transaction (see
+// attestation.ReportDataBinding). Fetching the report on the client keeps IAS out of the endorsement.
+func RegistrationArgs(ias attestation.IntelAttestationService, cert tls.Certificate, enclavePk, quote []byte, chaincodeID string) (string, []string, error) {
+	report, err := ias.RequestAttestationReport(cert, quote)
+	if err != nil {
+		return "", nil, fmt.Errorf("Can not retrieve attestation report: %s", err)
+	}
+	reportAsBytes, err := json.Marshal(report)
+	if err != nil {
+		return "", nil, err
+	}
+
+	args := []string{
+		base64.StdEncoding.EncodeToString(enclavePk),
+		base64.StdEncoding.EncodeToString(quote),
+		string(reportAsBytes),
+	}
+	if chaincodeID == "" {
+		return "registerEnclaveWithReport", args, nil
+	}
+	return "registerBoundEnclaveWithReport", append(args, chaincodeID), nil
+}
@@ -0,0 +1,60 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+)
+
+func TestRegistrationArgs(t *testing.T) {
+	_, pk, err := mock.EnclaveKey()
+	if err != nil {
+		t.Fatalf("Can not create enclave key: %s", err)
+	}
+	quote, err := mock.NewQuote(pk, nil, [32]byte{}, 1)
+	if err != nil {
+		t.Fatalf("Can not create quote: %s", err)
+	}
+
+	function, args, err := RegistrationArgs(&mock.MockIAS{}, tls.Certificate{}, pk, quote, "")
+	if err != nil {
+		t.Fatalf("RegistrationArgs failed: %s", err)
+	}
+	if function != "registerEnclaveWithReport" || len(args) != 3 {
+		t.Fatalf("Unexpected registration %s %v", function, args)
+	}
+	report := attestation.IASAttestationReport{}
+	if err := json.Unmarshal([]byte(args[2]), &report); err != nil {
+		t.Fatalf("Can not unmarshal attestation report: %s", err)
+	}
+	if !attestation.ReportContainsQuote(report, quote) {
+		t.Fatalf("Attestation report does not contain quote")
+	}
+
+	function, args, err = RegistrationArgs(&mock.MockIAS{}, tls.Certificate{}, pk, quote, "ecc")
+	if err != nil {
+		t.Fatalf("RegistrationArgs failed: %s", err)
+	}
+	if function != "registerBoundEnclaveWithReport" || len(args) != 4 || args[3] != "ecc" {
+		t.Fatalf("Unexpected bound registration %s %v", function, args)
+	}
+}
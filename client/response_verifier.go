@@ -0,0 +1,191 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+)
+
+// ResponseVerifier verifies enclave responses on the client side. It detects responses forged by a
+// compromised peer outside the enclave by checking the enclave signature over the result and read/write set
+// against the enclave key registered in ercc.
+type ResponseVerifier struct {
+	ercc     *ErccClient
+	verifier crypto.Verifier
+	ra       attestation.Verifier
+	// proofs holds the verifiers of proofs attached to responses by type, see SetProofVerifier
+	proofs         map[string]ProofVerifier
+	requiredProofs map[string]bool
+	// chaincodeID and mrEnclaves restrict the accepted enclaves, see ForChaincode
+	chaincodeID string
+	mrEnclaves  []string
+}
+
+// NewResponseVerifier creates a verifier that looks up enclave registrations with the given ercc client
+func NewResponseVerifier(ercc *ErccClient) *ResponseVerifier {
+	return &ResponseVerifier{
+		ercc:           ercc,
+		verifier:       &crypto.ECDSAVerifier{},
+		ra:             &attestation.VerifierImpl{},
+		proofs:         make(map[string]ProofVerifier),
+		requiredProofs: make(map[string]bool),
+	}
+}
+
+// ForChaincode restricts the accepted enclaves to those bound to the chaincode that run one of the given
+// measurements (mrenclave, base64). Without measurements, enclaves of any measurement are accepted.
+func (v *ResponseVerifier) ForChaincode(chaincodeID string, mrEnclaves ...string) *ResponseVerifier {
+	v.chaincodeID = chaincodeID
+	v.mrEnclaves = mrEnclaves
+	return v
+}
+
+// VerifyProposalResponse verifies the proposal response of an ecc invocation given the invocation argument
+// (args[0] of the invocation), the response payload and the serialized read/write set (results) of the
+// proposal response. namespace is the name of the chaincode, the enclave must be bound to it. Returns the
+// verified enclave response.
+func (v *ResponseVerifier) VerifyProposalResponse(namespace string, args, payload, results []byte) (*sgxutils.Response, error) {
+	if v.chaincodeID != "" && v.chaincodeID != namespace {
+		return nil, fmt.Errorf("Verifier is restricted to chaincode %s, not %s", v.chaincodeID, namespace)
+	}
+	endorsement, err := NewEndorsement(namespace, payload, results)
+	if err != nil {
+		return nil, err
+	}
+	return v.verifyResponse(namespace, args, endorsement.Payload, endorsement.RWSet)
+}
+
+// VerifyResponse verifies the enclave response (payload) to the invocation argument args with the read/write
+// set of the chaincode namespace. Returns the verified enclave response.
+func (v *ResponseVerifier) VerifyResponse(args, payload []byte, rwset *kvrwset.KVRWSet) (*sgxutils.Response, error) {
+	return v.verifyResponse(v.chaincodeID, args, payload, rwset)
+}
+
+func (v *ResponseVerifier) verifyResponse(chaincodeID string, args, payload []byte, rwset *kvrwset.KVRWSet) (*sgxutils.Response, error) {
+	response := &sgxutils.Response{}
+	if err := json.Unmarshal(payload, response); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal enclave response: %s", err)
+	}
+
+	if err := v.checkChaincodeEnclave(chaincodeID, response.PublicKey); err != nil {
+		return nil, err
+	}
+
+	readset, writeset := sgxutils.SignedRWSet(rwset)
+	isValid, err := v.verifier.Verify(args, response.ResponseData, readset, writeset, response.Signature, response.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("Signature verification failed: %s", err)
+	}
+	if !isValid {
+		return nil, errors.New("Response invalid! Signature verification failed!")
+	}
+	if err := v.verifyProofs(args, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// checkEnclave checks the enclave as checkChaincodeEnclave does for the chaincode of ForChaincode, if any
+func (v *ResponseVerifier) checkEnclave(enclavePk []byte) error {
+	return v.checkChaincodeEnclave(v.chaincodeID, enclavePk)
+}
+
+// checkChaincodeEnclave checks that the enclave pk is registered, bound by the registered attestation evidence
+// and still trusted. Unless chaincodeID is empty, the enclave must be bound to the chaincode; if measurements
+// are set with ForChaincode, it must run one of them.
+func (v *ResponseVerifier) checkChaincodeEnclave(chaincodeID string, enclavePk []byte) error {
+	record, err := v.ercc.GetEnclaveByPk(enclavePk)
+	if err != nil {
+		return fmt.Errorf("Enclave PK not found in registry: %s", err)
+	}
+
+	report := record.AttestationReport
+	if !consttime.Equal(report.EnclavePk, enclavePk) {
+		return errors.New("Enclave PK does not match attestation report")
+	}
+
+	verificationPK, err := attestation.PublicKeyFromPem([]byte(attestation.IntelPubPEM))
+	if err != nil {
+		return err
+	}
+	isValid, err := v.ra.VerifyAttestionReport(verificationPK, report)
+	if err != nil {
+		return fmt.Errorf("Attestation report verification failed: %s", err)
+	}
+	if !isValid {
+		return errors.New("Attestation report is not valid")
+	}
+	isValid, err = v.ra.CheckReportData(enclavePk, record.Binding, report)
+	if err != nil {
+		return fmt.Errorf("Error while checking enclave PK: %s", err)
+	}
+	if !isValid {
+		return errors.New("Enclave PK is not bound by quote")
+	}
+	// the key must have the algorithms it was registered with
+	if err := algorithm.CheckKey(record.Algorithms, enclavePk); err != nil {
+		return err
+	}
+	// registries are per channel; an enclave bound to another channel must not serve this one
+	if channelID := v.ercc.channelID; channelID != "" && record.Binding != nil && record.Binding.ChannelID != channelID {
+		return fmt.Errorf("Enclave is bound to channel %s", record.Binding.ChannelID)
+	}
+	// an enclave of another chaincode could sign any result for this one
+	if chaincodeID != "" {
+		if record.Binding == nil {
+			return fmt.Errorf("Enclave is not bound to chaincode %s", chaincodeID)
+		}
+		if record.Binding.ChaincodeID != chaincodeID {
+			return fmt.Errorf("Enclave is bound to chaincode %s", record.Binding.ChaincodeID)
+		}
+	}
+	if len(v.mrEnclaves) > 0 {
+		quote, err := attestation.QuoteFromAttestionReport(report)
+		if err != nil {
+			return fmt.Errorf("Can not parse quote: %s", err)
+		}
+		mrEnclave, accepted := registry.MrEnclave(quote), false
+		for _, expected := range v.mrEnclaves {
+			accepted = accepted || expected == mrEnclave
+		}
+		if !accepted {
+			return fmt.Errorf("Enclave runs unexpected mrenclave %s", mrEnclave)
+		}
+	}
+
+	if record.SuccessorPkHash != "" {
+		return errors.New("Enclave PK has been retired")
+	}
+	if record.Status != nil && record.Status.IsRevoked() {
+		return fmt.Errorf("Enclave platform has been revoked: %s", record.Status.QuoteStatus)
+	}
+	if record.ExpiresAt != 0 && time.Now().Unix() >= record.ExpiresAt {
+		return errors.New("Enclave registration has expired")
+	}
+	return nil
+}
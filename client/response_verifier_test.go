@@ -0,0 +1,171 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+)
+
+// recordQuerier serves getEnclaveByPk for a single registered enclave
+type recordQuerier struct {
+	record *registry.EnclaveRecord
+}
+
+func (q *recordQuerier) Query(chaincodeName string, args [][]byte) ([]byte, error) {
+	if q.record == nil {
+		return nil, errors.New("Enclave does not exist")
+	}
+	return json.Marshal(q.record)
+}
+
+// signResponse signs as the enclave does, i.e., sha256(sha256(args || result || rwset digest))
+func signResponse(t *testing.T, key *ecdsa.PrivateKey, args, result []byte, rwset *kvrwset.KVRWSet) []byte {
+	h := sha256.New()
+	h.Write(args)
+	h.Write(result)
+	h.Write(sgxutils.RWSetDigest(sgxutils.SignedRWSet(rwset)))
+	hash := sha256.Sum256(h.Sum(nil))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatalf("Can not sign: %s", err)
+	}
+	sig, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	return sig
+}
+
+func TestResponseVerifier_VerifyResponse(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	querier := &recordQuerier{record: &registry.EnclaveRecord{
+		EnclavePkHash:     registry.EnclavePkHash(pk),
+		AttestationReport: attestation.IASAttestationReport{EnclavePk: pk},
+	}}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+
+	args := []byte(`["submit","auction","alice","3"]`)
+	rwset := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "\x00somePrefix\x00auction\x00", Value: []byte("bid")}}}
+	result := []byte("OK")
+	payload, _ := json.Marshal(&sgxutils.Response{ResponseData: result, Signature: signResponse(t, key, args, result, rwset), PublicKey: pk})
+
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err != nil {
+		t.Fatalf("Response should be valid: %s", err)
+	}
+
+	// the peer altered the write set
+	rwset.Writes[0].Value = []byte("other bid")
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err == nil {
+		t.Fatalf("Response with altered write set should be invalid")
+	}
+
+	// the enclave got retired
+	rwset.Writes[0].Value = []byte("bid")
+	querier.record.SuccessorPkHash = "successor"
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err == nil {
+		t.Fatalf("Response of retired enclave should be invalid")
+	}
+
+	// the enclave is not registered
+	querier.record = nil
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err == nil {
+		t.Fatalf("Response of unregistered enclave should be invalid")
+	}
+}
+
+// reportForMrEnclave returns an attestation report for pk whose quote carries the measurement mrEnclave
+func reportForMrEnclave(t *testing.T, pk []byte, mrEnclave [32]byte) attestation.IASAttestationReport {
+	quote := attestation.EnclaveQuote{Version: attestation.QuoteVersion2, SignType: attestation.SignTypeLinkable, MrEnclave: mrEnclave}
+	quoteAsBytes := new(bytes.Buffer)
+	if err := binary.Write(quoteAsBytes, binary.LittleEndian, &quote); err != nil {
+		t.Fatalf("Can not encode quote: %s", err)
+	}
+	reportBody, _ := json.Marshal(&attestation.IASReportBody{IsvEnclaveQuoteBody: base64.StdEncoding.EncodeToString(quoteAsBytes.Bytes())})
+	return attestation.IASAttestationReport{EnclavePk: pk, IASReportBody: reportBody}
+}
+
+func TestResponseVerifier_ForChaincode(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	mrEnclave := [32]byte{1}
+
+	querier := &recordQuerier{record: &registry.EnclaveRecord{
+		EnclavePkHash:     registry.EnclavePkHash(pk),
+		AttestationReport: reportForMrEnclave(t, pk, mrEnclave),
+		Binding:           &attestation.ReportDataBinding{ChaincodeID: "auction"},
+	}}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc")).ForChaincode("auction", base64.StdEncoding.EncodeToString(mrEnclave[:]))
+	verifier.ra = &mock.MockVerifier{}
+
+	args := []byte(`["submit","auction","alice","3"]`)
+	rwset := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "\x00somePrefix\x00auction\x00", Value: []byte("bid")}}}
+	result := []byte("OK")
+	payload, _ := json.Marshal(&sgxutils.Response{ResponseData: result, Signature: signResponse(t, key, args, result, rwset), PublicKey: pk})
+
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err != nil {
+		t.Fatalf("Response should be valid: %s", err)
+	}
+
+	// the enclave runs another measurement
+	querier.record.AttestationReport = reportForMrEnclave(t, pk, [32]byte{2})
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err == nil {
+		t.Fatalf("Response of enclave with other mrenclave should be invalid")
+	}
+
+	// the enclave is bound to another chaincode
+	querier.record.AttestationReport = reportForMrEnclave(t, pk, mrEnclave)
+	querier.record.Binding.ChaincodeID = "other"
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err == nil {
+		t.Fatalf("Response of enclave bound to other chaincode should be invalid")
+	}
+
+	// the enclave is not bound to a chaincode
+	querier.record.Binding = nil
+	if _, err := verifier.VerifyResponse(args, payload, rwset); err == nil {
+		t.Fatalf("Response of unbound enclave should be invalid")
+	}
+
+	// the queried namespace differs from the chaincode of the verifier
+	if _, err := verifier.VerifyProposalResponse("other", args, payload, nil); err == nil {
+		t.Fatalf("Response for other namespace should be invalid")
+	}
+}
@@ -0,0 +1,219 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+)
+
+const (
+	// sessionPkPrefix marks the client pk of a session message, see ecc_enclave/enclave/session.h
+	sessionPkPrefix = "session:"
+	// sessionMaxMessages is the number of messages after which the enclave ends a session
+	sessionMaxMessages = 4096
+)
+
+// Session is a conversation with the enclave in use by a SecureContract, for workflows with many requests. Rather
+// than deriving a key from the enclave key for each request, the client and the enclave agree on fresh keys when
+// the session is opened and derive a new key for each message from a chain they ratchet forward, forgetting the
+// previous one. A leaked message key thus exposes neither earlier nor later messages, and a leaked enclave key
+// none of them. Sessions stay with the enclave they were opened with, expire after an hour of ledger time or 4096
+// messages, and carry one message at a time.
+type Session struct {
+	contract  *SecureContract
+	target    Contract
+	enclavePk []byte
+	// clientPk is the session pk of the client in sgx format, to which pseudonyms and identities bind requests
+	clientPk []byte
+	id       string
+	expires  time.Time
+
+	mutex sync.Mutex
+	// chain derives the key of message next; nil once the session is closed
+	chain []byte
+	next  uint64
+}
+
+// sessionOpenEnvelope is the plaintext of a request opening a session
+type sessionOpenEnvelope struct {
+	SessionPk string `json:"session_pk"`
+}
+
+// sessionOpenResponse is the response of the enclave to sessionOpenEnvelope, encrypted with the key of the request
+type sessionOpenResponse struct {
+	ID      string `json:"id"`
+	Pk      string `json:"pk"`
+	Expires int64  `json:"expires"`
+}
+
+// OpenSession opens a session with the enclave in use. Evaluations and submissions through the session use the
+// pseudonym or identity of the contract like its own.
+func (c *SecureContract) OpenSession() (*Session, error) {
+	enclavePk, err := c.getEnclavePk()
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	target := c.contract
+	c.mutex.Unlock()
+
+	sessionSk, sessionPub, err := crypto.GenKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	clientPk := crypto.MarshalSgxPk(sessionPub)
+	plaintext, err := json.Marshal(&sessionOpenEnvelope{SessionPk: base64.StdEncoding.EncodeToString(clientPk)})
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPk, key, err := crypto.DeriveEnclaveKey(enclavePk)
+	if err != nil {
+		return nil, fmt.Errorf("Can not encrypt session request: %s", err)
+	}
+	ciphertext, err := crypto.Encrypt(plaintext, key)
+	if err != nil {
+		return nil, fmt.Errorf("Can not encrypt session request: %s", err)
+	}
+
+	payload, err := target.EvaluateTransaction(base64.StdEncoding.EncodeToString(ciphertext), base64.StdEncoding.EncodeToString(ephemeralPk))
+	if err != nil {
+		return nil, err
+	}
+	result, err := openResponse(payload, enclavePk)
+	if err != nil {
+		return nil, err
+	}
+	result, err = crypto.Decrypt(result, key)
+	if err != nil {
+		return nil, fmt.Errorf("Can not decrypt session response: %s", err)
+	}
+	response := &sessionOpenResponse{}
+	if err := json.Unmarshal(result, response); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal session response: %s", err)
+	}
+	enclaveSessionPk, err := base64.StdEncoding.DecodeString(response.Pk)
+	if err != nil {
+		return nil, err
+	}
+	enclaveSessionPub, err := crypto.EnclavePk2ECDSAPK(enclaveSessionPk)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid enclave session pk: %s", err)
+	}
+	root, err := crypto.GenSharedKey(enclaveSessionPub, sessionSk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		contract:  c,
+		target:    target,
+		enclavePk: enclavePk,
+		clientPk:  clientPk,
+		id:        response.ID,
+		expires:   time.Unix(response.Expires, 0),
+		chain:     sessionChain(root),
+	}, nil
+}
+
+// sessionChain returns the first chain key of a session from the key the client and the enclave agreed on
+func sessionChain(root []byte) []byte {
+	chain := sha256.Sum256(append([]byte("fpc.session"), root...))
+	return chain[:]
+}
+
+// ratchet returns the message key of chain and the chain key of the next message
+func ratchet(chain []byte) ([]byte, []byte) {
+	key := sha256.Sum256(append([]byte{0x01}, chain...))
+	next := sha256.Sum256(append([]byte{0x02}, chain...))
+	return key[:16], next[:]
+}
+
+// Expires returns the ledger time at which the enclave ends the session
+func (s *Session) Expires() time.Time {
+	return s.expires
+}
+
+// EvaluateTransaction evaluates a transaction within the session
+func (s *Session) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return s.invoke(Contract.EvaluateTransaction, name, args)
+}
+
+// SubmitTransaction submits a transaction within the session
+func (s *Session) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return s.invoke(Contract.SubmitTransaction, name, args)
+}
+
+// Close forgets the keys of the session. The enclave forgets them once the session expires.
+func (s *Session) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.chain {
+		s.chain[i] = 0
+	}
+	s.chain = nil
+}
+
+func (s *Session) invoke(call func(Contract, string, ...string) ([]byte, error), name string, args []string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.chain == nil {
+		return nil, errors.New("Session closed")
+	}
+	if s.next >= sessionMaxMessages {
+		return nil, errors.New("Session exhausted")
+	}
+
+	// the key of each message is used once, whether or not the message reaches the enclave
+	counter := s.next
+	key, chain := ratchet(s.chain)
+	for i := range s.chain {
+		s.chain[i] = 0
+	}
+	s.chain = chain
+	s.next++
+
+	request, err := s.contract.sealRequest(name, args, s.clientPk)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := crypto.Encrypt(request.plaintext, key)
+	if err != nil {
+		return nil, fmt.Errorf("Can not encrypt arguments: %s", err)
+	}
+
+	payload, err := call(s.target, base64.StdEncoding.EncodeToString(ciphertext), fmt.Sprintf("%s%s:%d", sessionPkPrefix, s.id, counter))
+	if err != nil {
+		return nil, invocationError(err, key)
+	}
+	result, err := openResponse(payload, s.enclavePk)
+	if err != nil {
+		return nil, err
+	}
+	result, err = crypto.Decrypt(result, key)
+	if err != nil {
+		return nil, fmt.Errorf("Can not decrypt session response: %s", err)
+	}
+	return request.open(result, s.enclavePk)
+}
@@ -0,0 +1,164 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+)
+
+// sessionEnclave is an enclave contract that also speaks the session protocol of ecc_enclave/enclave/session.h
+type sessionEnclave struct {
+	*enclaveContract
+	id    string
+	chain []byte
+	next  uint64
+	// last holds the args of the latest invocation
+	last []string
+}
+
+func (c *sessionEnclave) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	c.last = append([]string{name}, args...)
+	if name == "getEnclavePk" || !strings.HasPrefix(args[0], sessionPkPrefix) {
+		return c.open(name, args...)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(args[0], sessionPkPrefix), ":")
+	counter, _ := strconv.ParseUint(parts[1], 10, 64)
+	if parts[0] != c.id || counter < c.next {
+		return nil, errors.New("unknown session or counter used")
+	}
+	var key []byte
+	for ; c.next <= counter; c.next++ {
+		key, c.chain = ratchet(c.chain)
+	}
+	ciphertext, _ := base64.StdEncoding.DecodeString(name)
+	plaintext, err := crypto.Decrypt(ciphertext, key)
+	if err != nil {
+		return nil, err
+	}
+	envelope := &requestEnvelope{}
+	var invocation []string
+	if err := json.Unmarshal(plaintext, envelope); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(envelope.Args), &invocation); err != nil {
+		return nil, err
+	}
+	return c.respond(key, []byte(strings.Join(invocation, ":")))
+}
+
+// open answers a request opening a session and passes all other requests on to the enclave contract
+func (c *sessionEnclave) open(name string, args ...string) ([]byte, error) {
+	if name == "getEnclavePk" {
+		return c.enclaveContract.EvaluateTransaction(name, args...)
+	}
+	ciphertext, _ := base64.StdEncoding.DecodeString(name)
+	ephemeralPk, _ := base64.StdEncoding.DecodeString(args[0])
+	pub, err := crypto.EnclavePk2ECDSAPK(ephemeralPk)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := crypto.GenSharedKey(pub, c.key)
+	plaintext, err := crypto.Decrypt(ciphertext, key)
+	if err != nil {
+		return nil, err
+	}
+	envelope := &sessionOpenEnvelope{}
+	if err := json.Unmarshal(plaintext, envelope); err != nil || envelope.SessionPk == "" {
+		return c.enclaveContract.EvaluateTransaction(name, args...)
+	}
+
+	clientPk, _ := base64.StdEncoding.DecodeString(envelope.SessionPk)
+	clientPub, err := crypto.EnclavePk2ECDSAPK(clientPk)
+	if err != nil {
+		return nil, err
+	}
+	sessionSk, sessionPub, _ := crypto.GenKeyPair()
+	root, _ := crypto.GenSharedKey(clientPub, sessionSk)
+	c.id, c.chain, c.next = "s1", sessionChain(root), 0
+	response, _ := json.Marshal(&sessionOpenResponse{
+		ID:      c.id,
+		Pk:      base64.StdEncoding.EncodeToString(crypto.MarshalSgxPk(sessionPub)),
+		Expires: 3600,
+	})
+	return c.respond(key, response)
+}
+
+func (c *sessionEnclave) respond(key, data []byte) ([]byte, error) {
+	ciphertext, err := crypto.Encrypt(data, key)
+	if err != nil {
+		return nil, err
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&c.responseKey.PublicKey)
+	return json.Marshal(&sgxutils.Response{ResponseData: ciphertext, PublicKey: pk})
+}
+
+func (c *sessionEnclave) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return c.EvaluateTransaction(name, args...)
+}
+
+func TestSession(t *testing.T) {
+	querier := &registryQuerier{records: make(map[string]*registry.EnclaveRecord)}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+	contract := &sessionEnclave{enclaveContract: newRegisteredContract(t, querier)}
+	secureContract := NewSecureContract(contract, verifier)
+
+	session, err := secureContract.OpenSession()
+	if err != nil {
+		t.Fatalf("OpenSession failed: %s", err)
+	}
+	if session.Expires().Unix() != 3600 {
+		t.Fatalf("Unexpected expiry %s", session.Expires())
+	}
+	for _, auction := range []string{"MyAuction", "OtherAuction"} {
+		result, err := session.SubmitTransaction("create", auction)
+		if err != nil {
+			t.Fatalf("Invocation within session failed: %s", err)
+		}
+		if string(result) != "create:"+auction {
+			t.Fatalf("Unexpected result %s", result)
+		}
+	}
+
+	// the peer replays the latest message
+	if _, err := contract.EvaluateTransaction(contract.last[0], contract.last[1:]...); err == nil {
+		t.Fatalf("Replayed session message should be rejected")
+	}
+	// a message the enclave never saw is skipped
+	session.next++
+	_, session.chain = ratchet(session.chain)
+	if _, err := session.EvaluateTransaction("eval", "MyAuction"); err != nil {
+		t.Fatalf("Invocation after skipped message failed: %s", err)
+	}
+
+	session.Close()
+	if _, err := session.EvaluateTransaction("eval", "MyAuction"); err == nil {
+		t.Fatalf("Invocation within closed session should fail")
+	}
+}
@@ -0,0 +1,190 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// GetTranscriptHead returns the head of the attestation transcript of the channel
+func (c *ErccClient) GetTranscriptHead() (*registry.TranscriptHead, error) {
+	return c.getTranscriptHead("getTranscriptHead")
+}
+
+// GetTranscript returns up to limit entries of the attestation transcript starting at fromSeq; a limit of 0
+// returns a page of the default size. Entries kept in a private data collection are only returned by peers that
+// are members of the collection.
+func (c *ErccClient) GetTranscript(fromSeq uint64, limit int) (*registry.TranscriptPage, error) {
+	return c.getTranscriptPage("getTranscript", strconv.FormatUint(fromSeq, 10), strconv.Itoa(limit))
+}
+
+// GetImportedTranscriptHead returns the head of the transcript imported from channelID
+func (c *ErccClient) GetImportedTranscriptHead(channelID string) (*registry.TranscriptHead, error) {
+	return c.getTranscriptHead("getImportedTranscriptHead", channelID)
+}
+
+// GetImportedTranscript returns up to limit entries of the transcript imported from channelID starting at fromSeq
+func (c *ErccClient) GetImportedTranscript(channelID string, fromSeq uint64, limit int) (*registry.TranscriptPage, error) {
+	return c.getTranscriptPage("getImportedTranscript", channelID, strconv.FormatUint(fromSeq, 10), strconv.Itoa(limit))
+}
+
+func (c *ErccClient) getTranscriptHead(function string, args ...string) (*registry.TranscriptHead, error) {
+	resp, err := c.querier.Query(c.chaincodeName, queryArgs(function, args))
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %s", function, err)
+	}
+
+	head := &registry.TranscriptHead{}
+	if err := json.Unmarshal(resp, head); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal transcript head: %s", err)
+	}
+	return head, nil
+}
+
+func (c *ErccClient) getTranscriptPage(function string, args ...string) (*registry.TranscriptPage, error) {
+	resp, err := c.querier.Query(c.chaincodeName, queryArgs(function, args))
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %s", function, err)
+	}
+
+	page := &registry.TranscriptPage{}
+	if err := json.Unmarshal(resp, page); err != nil {
+		return nil, fmt.Errorf("Can not unmarshal transcript page: %s", err)
+	}
+	return page, nil
+}
+
+func queryArgs(function string, args []string) [][]byte {
+	queryArgs := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		queryArgs = append(queryArgs, []byte(arg))
+	}
+	return queryArgs
+}
+
+// TranscriptRelayer mirrors the attestation transcripts of application channels to the ercc of an audit channel,
+// giving auditors one log across all channels. The relayer is not trusted: ercc on the audit channel only accepts
+// entries continuing the hash chain imported so far, and auditors compare the imported heads with the heads on
+// the application channels.
+type TranscriptRelayer struct {
+	// audit queries ercc on the audit channel, and auditContract submits to it
+	audit         *ErccClient
+	auditContract Contract
+	// PageSize is the number of entries imported per transaction, 0 for the default
+	PageSize int
+}
+
+// NewTranscriptRelayer creates a relayer importing into the ercc of the audit channel, which audit queries and
+// auditContract submits transactions to
+func NewTranscriptRelayer(audit *ErccClient, auditContract Contract) *TranscriptRelayer {
+	return &TranscriptRelayer{audit: audit, auditContract: auditContract}
+}
+
+// Relay imports the entries of the transcript of source, a client scoped to an application channel (see
+// NewChannelErccClient), that the audit channel lacks. It returns the number of imported entries.
+func (r *TranscriptRelayer) Relay(source *ErccClient) (int, error) {
+	if source.channelID == "" {
+		return 0, errors.New("Source registry is not scoped to a channel")
+	}
+
+	imported, err := r.audit.GetImportedTranscriptHead(source.channelID)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		page, err := source.GetTranscript(imported.Seq, r.PageSize)
+		if err != nil {
+			return count, err
+		}
+		if len(page.Entries) == 0 {
+			return count, nil
+		}
+
+		// check the page ourselves first; a broken chain is rejected by the audit channel anyway
+		head := imported
+		for i := range page.Entries {
+			if head, err = head.Append(&page.Entries[i]); err != nil {
+				return count, fmt.Errorf("Transcript of channel %s does not continue the imported one: %s", source.channelID, err)
+			}
+		}
+
+		entriesAsBytes, err := json.Marshal(page.Entries)
+		if err != nil {
+			return count, err
+		}
+		if _, err := r.auditContract.SubmitTransaction("importTranscript", string(entriesAsBytes)); err != nil {
+			return count, fmt.Errorf("importTranscript failed: %s", err)
+		}
+		count += len(page.Entries)
+		imported = head
+	}
+}
+
+// RelayChannels relays the transcripts of the given channels of registries, channel by channel. It stops at the
+// first error.
+func (r *TranscriptRelayer) RelayChannels(registries *ChannelRegistries, channelIDs []string) (int, error) {
+	count := 0
+	for _, channelID := range channelIDs {
+		n, err := r.Relay(registries.Channel(channelID))
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// CheckTranscriptHead returns an error if the transcript imported from the channel of source does not match the
+// transcript of that channel up to the imported head, e.g., if a relayer imported forged entries. As every entry
+// names the hash of its predecessor, comparing the last imported entry covers all imported entries.
+func (r *TranscriptRelayer) CheckTranscriptHead(source *ErccClient) error {
+	if source.channelID == "" {
+		return errors.New("Source registry is not scoped to a channel")
+	}
+
+	imported, err := r.audit.GetImportedTranscriptHead(source.channelID)
+	if err != nil {
+		return err
+	}
+	if imported.Seq == 0 {
+		return nil
+	}
+
+	page, err := source.GetTranscript(imported.Seq-1, 1)
+	if err != nil {
+		return err
+	}
+	if len(page.Entries) != 1 {
+		return fmt.Errorf("Transcript of channel %s has no entry %d", source.channelID, imported.Seq-1)
+	}
+	hash, err := page.Entries[0].Hash()
+	if err != nil {
+		return err
+	}
+	if !consttime.EqualString(hash, imported.Hash) {
+		return fmt.Errorf("Imported transcript of channel %s diverges at entry %d", source.channelID, imported.Seq-1)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// transcriptChannels serves getTranscript of the channels of an application channel's ercc
+type transcriptChannels struct {
+	transcripts map[string][]registry.TranscriptEntry
+}
+
+func (q *transcriptChannels) QueryChannel(channelID, chaincodeName string, args [][]byte) ([]byte, error) {
+	entries := q.transcripts[channelID]
+	fromSeq, _ := strconv.Atoi(string(args[1]))
+	limit, _ := strconv.Atoi(string(args[2]))
+	if limit == 0 {
+		limit = 2
+	}
+
+	page := &registry.TranscriptPage{Entries: []registry.TranscriptEntry{}}
+	for i := fromSeq; i < len(entries) && len(page.Entries) < limit; i++ {
+		page.Entries = append(page.Entries, entries[i])
+	}
+	return json.Marshal(page)
+}
+
+// auditChannel imports transcripts like ercc on the audit channel
+type auditChannel struct {
+	heads   map[string]*registry.TranscriptHead
+	imports int
+}
+
+func (a *auditChannel) head(channelID string) *registry.TranscriptHead {
+	if head, ok := a.heads[channelID]; ok {
+		return head
+	}
+	return &registry.TranscriptHead{ChannelID: channelID}
+}
+
+func (a *auditChannel) Query(chaincodeName string, args [][]byte) ([]byte, error) {
+	return json.Marshal(a.head(string(args[1])))
+}
+
+func (a *auditChannel) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return nil, nil
+}
+
+func (a *auditChannel) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	var entries []registry.TranscriptEntry
+	if err := json.Unmarshal([]byte(args[0]), &entries); err != nil {
+		return nil, err
+	}
+	head := a.head(entries[0].ChannelID)
+	for i := range entries {
+		var err error
+		if head, err = head.Append(&entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	a.heads[head.ChannelID] = head
+	a.imports++
+	return nil, nil
+}
+
+// transcript returns a hash chain of n entries of a channel
+func transcript(t *testing.T, channelID string, n int) []registry.TranscriptEntry {
+	head := &registry.TranscriptHead{ChannelID: channelID}
+	var entries []registry.TranscriptEntry
+	for i := 0; i < n; i++ {
+		entry := registry.TranscriptEntry{
+			ChannelID:     channelID,
+			Seq:           head.Seq,
+			Kind:          registry.TranscriptRegistration,
+			EnclavePkHash: channelID + strconv.Itoa(i),
+			PrevHash:      head.Hash,
+		}
+		var err error
+		if head, err = head.Append(&entry); err != nil {
+			t.Fatalf("Can not append entry: %s", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestTranscriptRelayer(t *testing.T) {
+	channels := &transcriptChannels{transcripts: map[string][]registry.TranscriptEntry{
+		"ch1": transcript(t, "ch1", 5),
+		"ch2": transcript(t, "ch2", 1),
+	}}
+	registries := NewChannelRegistries(channels, "ercc")
+	audit := &auditChannel{heads: make(map[string]*registry.TranscriptHead)}
+	relayer := NewTranscriptRelayer(NewErccClient(audit, "ercc"), audit)
+
+	n, err := relayer.RelayChannels(registries, []string{"ch1", "ch2"})
+	if err != nil {
+		t.Fatalf("RelayChannels failed: %s", err)
+	}
+	if n != 6 || audit.imports != 4 {
+		t.Fatalf("Expected 6 entries in 4 imports but got %d in %d", n, audit.imports)
+	}
+	if err := relayer.CheckTranscriptHead(registries.Channel("ch1")); err != nil {
+		t.Fatalf("Imported transcript should match: %s", err)
+	}
+
+	// nothing new to relay
+	if n, err := relayer.Relay(registries.Channel("ch1")); err != nil || n != 0 {
+		t.Fatalf("Expected nothing to relay but got %d (%v)", n, err)
+	}
+
+	// a rewritten channel transcript no longer continues the imported one
+	channels.transcripts["ch1"] = transcript(t, "ch1", 6)
+	channels.transcripts["ch1"][5].PrevHash = "forged"
+	if _, err := relayer.Relay(registries.Channel("ch1")); err == nil {
+		t.Fatalf("Relay should reject entries that do not continue the imported transcript")
+	}
+	channels.transcripts["ch1"][4].Description = "rewritten"
+	if err := relayer.CheckTranscriptHead(registries.Channel("ch1")); err == nil {
+		t.Fatalf("Diverging transcript should be detected")
+	}
+
+	if _, err := relayer.Relay(NewErccClient(audit, "ercc")); err == nil {
+		t.Fatalf("Relay should require a channel scoped source")
+	}
+}
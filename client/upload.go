@@ -0,0 +1,65 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// UploadChunkFunction is the function by which the enclave stores a chunk of an upload, see Upload
+	UploadChunkFunction = "__upload_chunk"
+	// UploadChunkSize is the size of the chunks of an upload
+	UploadChunkSize = 32 * 1024
+	// UploadMaxSize is the size of the largest payload the enclave accepts
+	UploadMaxSize = 32 * UploadChunkSize
+)
+
+// Upload stores a payload too large for a single transaction with the enclave and returns its upload id, the hex
+// encoded SHA-256 hash of the payload. Each chunk is submitted in a transaction of its own, encrypted like any
+// invocation; the chaincode reassembles and verifies the payload once invoked with the upload id (get_upload, see
+// ecc_enclave/enclave/upload.h), e.g.,
+//
+//	id, err := secureContract.Upload(document)
+//	result, err := secureContract.SubmitTransaction("storeDocument", id)
+//
+// Chunk submissions are idempotent, so an upload that failed part way may simply be repeated.
+func (c *SecureContract) Upload(payload []byte) (string, error) {
+	if len(payload) == 0 || len(payload) > UploadMaxSize {
+		return "", fmt.Errorf("Upload must be 1 to %d bytes", UploadMaxSize)
+	}
+	hash := sha256.Sum256(payload)
+	id := hex.EncodeToString(hash[:])
+
+	total := (len(payload) + UploadChunkSize - 1) / UploadChunkSize
+	for i := 0; i < total; i++ {
+		end := (i + 1) * UploadChunkSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk := base64.StdEncoding.EncodeToString(payload[i*UploadChunkSize : end])
+		args := []string{id, strconv.Itoa(i), strconv.Itoa(total), chunk}
+		if _, err := c.invokeWithFailover(Contract.SubmitTransaction, true, UploadChunkFunction, args); err != nil {
+			return "", fmt.Errorf("Upload of chunk %d of %d failed: %s", i+1, total, err)
+		}
+	}
+	return id, nil
+}
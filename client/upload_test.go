@@ -0,0 +1,70 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+func TestSecureContract_Upload(t *testing.T) {
+	querier := &registryQuerier{records: make(map[string]*registry.EnclaveRecord)}
+	verifier := NewResponseVerifier(NewErccClient(querier, "ercc"))
+	verifier.ra = &mock.MockVerifier{}
+	contract := newRegisteredContract(t, querier)
+	secureContract := NewSecureContract(contract, verifier)
+
+	payload := bytes.Repeat([]byte("confidential "), 2*UploadChunkSize/10)
+	id, err := secureContract.Upload(payload)
+	if err != nil {
+		t.Fatalf("Upload failed: %s", err)
+	}
+	hash := sha256.Sum256(payload)
+	if id != hex.EncodeToString(hash[:]) {
+		t.Fatalf("Unexpected upload id %s", id)
+	}
+
+	// the enclave reassembles the chunks in order
+	if len(contract.invoked) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(contract.invoked))
+	}
+	var reassembled []byte
+	for i, invocation := range contract.invoked {
+		if invocation[0] != UploadChunkFunction || invocation[1] != id || invocation[2] != strconv.Itoa(i) || invocation[3] != "3" {
+			t.Fatalf("Unexpected chunk invocation %v", invocation[:4])
+		}
+		chunk, _ := base64.StdEncoding.DecodeString(invocation[4])
+		if len(chunk) > UploadChunkSize {
+			t.Fatalf("Chunk %d exceeds chunk size", i)
+		}
+		reassembled = append(reassembled, chunk...)
+	}
+	if !bytes.Equal(reassembled, payload) {
+		t.Fatalf("Chunks do not reassemble to the payload")
+	}
+
+	if _, err := secureContract.Upload(make([]byte, UploadMaxSize+1)); err == nil {
+		t.Fatalf("Upload beyond the maximum size should fail")
+	}
+}
@@ -0,0 +1,178 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// VaultConfig locates a key in the transit secrets engine of HashiCorp Vault
+type VaultConfig struct {
+	// Address of the Vault server, e.g., https://vault.example.com:8200
+	Address string
+	// Token authenticates to Vault; it needs read access to the key and may use it for signing
+	Token string
+	// Namespace of the transit engine (Vault Enterprise), if any
+	Namespace string
+	// Mount path of the transit engine, transit by default
+	Mount string
+	// KeyName is the name of an ecdsa-p256 transit key
+	KeyName string
+	// HTTPClient sends the requests to Vault; a client with a timeout of 10 seconds is used if nil
+	HTTPClient *http.Client
+}
+
+// VaultSigner is a crypto.Signer for the certificate key of an Identity held in the transit secrets engine of
+// HashiCorp Vault. The key never leaves Vault; each request of the client is signed by Vault.
+type VaultSigner struct {
+	config  VaultConfig
+	client  *http.Client
+	version int
+	pub     crypto.PublicKey
+}
+
+// vaultKey is the part of a transit key read by the signer
+type vaultKey struct {
+	Data struct {
+		Type          string `json:"type"`
+		LatestVersion int    `json:"latest_version"`
+		Keys          map[string]struct {
+			PublicKey string `json:"public_key"`
+		} `json:"keys"`
+	} `json:"data"`
+}
+
+// vaultSignRequest signs a SHA-256 digest with a version of a transit key
+type vaultSignRequest struct {
+	Input               []byte `json:"input"`
+	KeyVersion          int    `json:"key_version"`
+	Prehashed           bool   `json:"prehashed"`
+	HashAlgorithm       string `json:"hash_algorithm"`
+	MarshalingAlgorithm string `json:"marshaling_algorithm"`
+}
+
+type vaultSignResponse struct {
+	Data struct {
+		Signature string `json:"signature"`
+	} `json:"data"`
+}
+
+// NewVaultSigner returns a signer for the latest version of the transit key in config. The signer keeps signing
+// with this version after the key is rotated in Vault, since the certificate of the identity is for its public key.
+func NewVaultSigner(config VaultConfig) (*VaultSigner, error) {
+	if config.Address == "" || config.Token == "" || config.KeyName == "" {
+		return nil, errors.New("Vault address, token and key name must not be empty")
+	}
+	if config.Mount == "" {
+		config.Mount = "transit"
+	}
+	v := &VaultSigner{config: config, client: defaultKMSClient(config.HTTPClient)}
+
+	key := &vaultKey{}
+	if err := v.do("GET", "keys", nil, key); err != nil {
+		return nil, err
+	}
+	if key.Data.Type != "ecdsa-p256" {
+		return nil, fmt.Errorf("Vault key %s must be of type ecdsa-p256, got %s", config.KeyName, key.Data.Type)
+	}
+	version, ok := key.Data.Keys[strconv.Itoa(key.Data.LatestVersion)]
+	if !ok {
+		return nil, fmt.Errorf("Vault key %s has no version %d", config.KeyName, key.Data.LatestVersion)
+	}
+	block, _ := pem.Decode([]byte(version.PublicKey))
+	if block == nil {
+		return nil, errors.New("Can not decode Vault public key PEM")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Can not parse Vault public key: %s", err)
+	}
+	if v.pub, err = checkKMSKey(pub); err != nil {
+		return nil, err
+	}
+	v.version = key.Data.LatestVersion
+	return v, nil
+}
+
+// Public returns the public key of the signer
+func (v *VaultSigner) Public() crypto.PublicKey {
+	return v.pub
+}
+
+// Sign signs a SHA-256 digest in Vault and returns the ASN.1 encoded ECDSA signature; rand is not used
+func (v *VaultSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 || len(digest) != crypto.SHA256.Size() {
+		return nil, errors.New("Vault signer supports SHA-256 digests only")
+	}
+
+	request := &vaultSignRequest{
+		Input:               digest,
+		KeyVersion:          v.version,
+		Prehashed:           true,
+		HashAlgorithm:       "sha2-256",
+		MarshalingAlgorithm: "asn1",
+	}
+	response := &vaultSignResponse{}
+	if err := v.do("POST", "sign", request, response); err != nil {
+		return nil, err
+	}
+
+	// signatures are of the form vault:v<version>:<base64 signature>
+	parts := strings.Split(response.Data.Signature, ":")
+	if len(parts) != 3 || parts[0] != "vault" || parts[1] != "v"+strconv.Itoa(v.version) {
+		return nil, errors.New("Unexpected Vault signature format")
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+// do sends a request to the given endpoint of the transit key
+func (v *VaultSigner) do(method, endpoint string, request, result interface{}) error {
+	var body io.Reader
+	if request != nil {
+		requestBytes, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(requestBytes)
+	}
+
+	u := strings.TrimRight(v.config.Address, "/") + "/v1/" + strings.Trim(v.config.Mount, "/") + "/" + endpoint + "/" + url.PathEscape(v.config.KeyName)
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return fmt.Errorf("KMS connection error: %s", err)
+	}
+	req.Header.Set("X-Vault-Token", v.config.Token)
+	if v.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doKMSRequest(v.client, req, result)
+}
@@ -0,0 +1,118 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newVaultServer returns a transit engine with an ecdsa-p256 key named fpc holding key as version 2
+func newVaultServer(t *testing.T, key *ecdsa.PrivateKey) *httptest.Server {
+	pk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pkPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pk}))
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/transit/keys/fpc":
+			w.Write([]byte(`{"data":{"type":"ecdsa-p256","latest_version":2,"keys":{"2":{"public_key":` + string(mustMarshal(t, pkPEM)) + `}}}}`))
+		case "/v1/transit/sign/fpc":
+			request := &vaultSignRequest{}
+			if err := json.NewDecoder(r.Body).Decode(request); err != nil || !request.Prehashed || request.KeyVersion != 2 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sig, _ := key.Sign(rand.Reader, request.Input, crypto.SHA256)
+			w.Write([]byte(`{"data":{"signature":"vault:v2:` + base64.StdEncoding.EncodeToString(sig) + `"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Can not marshal: %s", err)
+	}
+	return b
+}
+
+// verifyRaw verifies an ASN.1 encoded signature after converting it like Identity does for the enclave
+func verifyRaw(t *testing.T, pub *ecdsa.PublicKey, digest, der []byte) bool {
+	sig, err := rawSignature(der)
+	if err != nil {
+		t.Fatalf("Can not convert signature: %s", err)
+	}
+	return ecdsa.Verify(pub, digest, new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+}
+
+func TestVaultSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	server := newVaultServer(t, key)
+	defer server.Close()
+
+	if _, err := NewVaultSigner(VaultConfig{Address: server.URL, Token: "wrong", KeyName: "fpc"}); err == nil {
+		t.Fatalf("Vault signer with invalid token should fail")
+	}
+	if _, err := NewVaultSigner(VaultConfig{Address: server.URL, Token: "token", KeyName: "other"}); err == nil {
+		t.Fatalf("Vault signer for unknown key should fail")
+	}
+	signer, err := NewVaultSigner(VaultConfig{Address: server.URL, Token: "token", KeyName: "fpc"})
+	if err != nil {
+		t.Fatalf("Can not create Vault signer: %s", err)
+	}
+
+	digest := sha256.Sum256([]byte("request"))
+	sig, err := signer.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Vault signing failed: %s", err)
+	}
+	if !verifyRaw(t, signer.Public().(*ecdsa.PublicKey), digest[:], sig) {
+		t.Fatalf("Invalid Vault signature")
+	}
+	if _, err := signer.Sign(nil, digest[:], crypto.SHA384); err == nil {
+		t.Fatalf("Signing other digests should fail")
+	}
+
+	// the key of an identity can be held by Vault
+	if _, err := NewIdentityWithSigner("Org1MSP", newClientCert(t, key, "alice"), signer); err != nil {
+		t.Fatalf("Can not create identity with Vault signer: %s", err)
+	}
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := NewIdentityWithSigner("Org1MSP", newClientCert(t, otherKey, "bob"), signer); err == nil {
+		t.Fatalf("Vault key not matching the certificate should be rejected")
+	}
+}
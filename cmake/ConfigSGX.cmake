@@ -36,12 +36,17 @@ else()
     message(FATAL_ERROR "Unknown build ${SGX_BUILD}")
 endif()
 
+if (FPC_FIPS)
+    set(SGX_COMMON_CFLAGS "${SGX_COMMON_CFLAGS} -DFPC_FIPS")
+endif()
+
 set(SGX_SSL_LIBRARY_PATH ${SGX_SSL}/lib64)
 
 message(STATUS "SGX_COMMON_CFLAGS: ${SGX_COMMON_CFLAGS}")
 message(STATUS "SGX_SDK: ${SGX_SDK}")
 message(STATUS "SGX_MODE: ${SGX_MODE}")
 message(STATUS "SGX_BUILD: ${SGX_BUILD}")
+message(STATUS "FPC_FIPS: ${FPC_FIPS}")
 message(STATUS "SGX_LIBRARY_PATH: ${SGX_LIBRARY_PATH}")
 message(STATUS "SGX_ENCLAVE_SIGNER: ${SGX_ENCLAVE_SIGNER}")
 message(STATUS "SGX_EDGER8R: ${SGX_EDGER8R}")
@@ -9,3 +9,4 @@ set(SGX_ARCH x64)
 set(SGX_MODE HW) # SGX mode: sim, hw
 set(SGX_BUILD PRERELEASE)
 set(SGX_SSL /opt/intel/sgxssl)
+option(FPC_FIPS "Restrict the enclaves to constructions approved under FIPS 140" OFF)
@@ -56,9 +56,10 @@ int ecall_init(void)
     return SGX_SUCCESS;
 }
 
-// returns report (containing enclave pk hash) and enclave pk in big endian format
-int ecall_create_report(
-    const sgx_target_info_t *target, sgx_report_t *report_out, uint8_t *pubkey_out)
+// returns report (containing enclave pk hash and binding) and enclave pk in big endian format;
+// binding (32 bytes) is optional, see ReportDataFormat in ercc/attestation for the format
+int ecall_create_report(const sgx_target_info_t *target, const uint8_t *binding,
+    sgx_report_t *report_out, uint8_t *pubkey_out)
 {
     sgx_report_t report;
     sgx_report_data_t report_data = {{0}};
@@ -75,6 +76,11 @@ int ecall_create_report(
     assert(sizeof(report_data) >= sizeof(sgx_sha256_hash_t));
     sgx_sha256_msg(enclave_pk_be, sizeof(sgx_ec256_public_t), (sgx_sha256_hash_t *)&report_data);
 
+    // write binding in second half of report data; all zero if not bound
+    if (binding != NULL) {
+        memcpy(report_data.d + sizeof(sgx_sha256_hash_t), binding, sizeof(sgx_sha256_hash_t));
+    }
+
     // copy enclave_pk_be outside
     memcpy(pubkey_out, enclave_pk_be, sizeof(sgx_ec256_public_t));
 
@@ -19,7 +19,9 @@
 #include "base64.h"
 #include "logging.h"
 #include "parson.h"
+#include "utils.h"
 
+#include <stddef.h>
 #include <string.h>
 
 // openssl
@@ -59,7 +61,7 @@ int verify_mrenclave_in_quote(sgx_quote_t* quote, mrenclave_t* mrenclave)
     if (quote == NULL || mrenclave == NULL) {
         return -1;
     }
-    return memcmp(quote->report_body.mr_enclave.m, mrenclave->m, 32);
+    return consttime_memequal(quote->report_body.mr_enclave.m, mrenclave->m, 32) ? 0 : 1;
 }
 
 int verify_enclave_pk_in_quote(
@@ -106,43 +108,30 @@ int verify_enclave_pk_in_quote(
     BN_free(y);
     EC_KEY_free(pubkey);
 
-    return memcmp(quote->report_body.report_data.d, pk_hash, 32);
+    return consttime_memequal(quote->report_body.report_data.d, pk_hash, 32) ? 0 : 1;
 }
 
-int verify_attestation_report(uint8_t* json_bytes, size_t json_len, mrenclave_t* mrenclave)
+// verifies the signature of IAS (base64) over the report body
+static int verify_report_signature(const std::string& report_body, const char* base64_signature)
 {
-    std::string json((const char*)json_bytes, json_len);
-
     // first get intel pub key for verification
     BIO* mem = BIO_new(BIO_s_mem());
     if (BIO_puts(mem, INTEL_PUB_PEM) < 1) {
         LOG_ERROR("IAS: Mem NULL, error: %s", ERR_error_string(ERR_get_error(), NULL));
+        BIO_free(mem);
         return IAS_ERROR;
     }
 
     EVP_PKEY* intel_pkey = NULL;
     if (!PEM_read_bio_PUBKEY(mem, &intel_pkey, 0, 0)) {
         LOG_ERROR("IAS: Can not parse Intel PK from pem: %s", ERR_error_string(ERR_get_error(), NULL));
+        BIO_free(mem);
         return IAS_ERROR;
     }
+    BIO_free(mem);
 
     RSA* intel_pubkey_rsa = EVP_PKEY_get1_RSA(intel_pkey);
-
-    JSON_Value* root = json_parse_string(json.c_str());
-    if (root == NULL) {
-        LOG_ERROR("IAS: Failed to parse JSON");
-        return IAS_ERROR;
-    }
-
-    const char* base64_enclave_pk = json_object_get_string(json_object(root), "EnclavePk");
-    const char* base64_signature = json_object_get_string(json_object(root), "IASReport-Signature");
-    const char* base64_signing_cert =
-        json_object_get_string(json_object(root), "IASReport-Signing-Certificate");
-    const char* base64_report_body = json_object_get_string(json_object(root), "IASResponseBody");
-    json_value_free(root);
-
     std::string signature = base64_decode(base64_signature);
-    std::string report_body = base64_decode(base64_report_body);
 
     // next: compute hash IASReport Body
     unsigned char sig_hash[32];
@@ -156,34 +145,152 @@ int verify_attestation_report(uint8_t* json_bytes, size_t json_len, mrenclave_t*
     // next: verify
     int ret = RSA_verify(NID_sha256, sig_hash, 32, (const unsigned char*)signature.c_str(),
         signature.size(), intel_pubkey_rsa);
+    EVP_PKEY_free(intel_pkey);
+    RSA_free(intel_pubkey_rsa);
     if (ret == 0) {
         LOG_ERROR("IAS: Invalid IASReport signature");
         return IAS_ERROR;
     } else if (ret == -1) {
         LOG_ERROR("IAS: IASReport signature validation  error");
         return IAS_ERROR;
-    } else {
-        LOG_DEBUG("IAS: Valid IASReport");
     }
+    LOG_DEBUG("IAS: Valid IASReport");
+    return IAS_SUCCESS;
+}
 
-    sgx_quote_t* quote = quote_from_attestation_report_body(report_body.c_str());
+int verify_attestation_report(uint8_t* json_bytes, size_t json_len, mrenclave_t* mrenclave)
+{
+    std::string json((const char*)json_bytes, json_len);
+
+    JSON_Value* root = json_parse_string(json.c_str());
+    if (root == NULL) {
+        LOG_ERROR("IAS: Failed to parse JSON");
+        return IAS_ERROR;
+    }
+
+    const char* base64_enclave_pk = json_object_get_string(json_object(root), "EnclavePk");
+    const char* base64_signature = json_object_get_string(json_object(root), "IASReport-Signature");
+    const char* base64_report_body = json_object_get_string(json_object(root), "IASResponseBody");
+    if (base64_enclave_pk == NULL || base64_signature == NULL || base64_report_body == NULL) {
+        LOG_ERROR("IAS: Incomplete attestation report");
+        json_value_free(root);
+        return IAS_ERROR;
+    }
     std::string enclave_pk = base64_decode(base64_enclave_pk);
+    std::string report_body = base64_decode(base64_report_body);
+    int ret = verify_report_signature(report_body, base64_signature);
+    json_value_free(root);
+    if (ret != IAS_SUCCESS) {
+        return IAS_ERROR;
+    }
+
+    sgx_quote_t* quote = quote_from_attestation_report_body(report_body.c_str());
 
     // check that IASReport includes enclave PK
     if (verify_enclave_pk_in_quote(
             quote, (const unsigned char*)enclave_pk.c_str(), enclave_pk.size()) != 0) {
         LOG_ERROR("IAS: Enclave PK does not match attestation report");
+        free(quote);
         return IAS_ERROR;
     }
 
     // check that correct MRENCLAVE is present in quote
     if (verify_mrenclave_in_quote(quote, mrenclave) != 0) {
         LOG_ERROR("IAS: Chaincode MRENCLAVE does not match attestation report");
+        free(quote);
         return IAS_ERROR;
     }
 
-    EVP_PKEY_free(intel_pkey);
-    RSA_free(intel_pubkey_rsa);
+    free(quote);
+    return IAS_SUCCESS;
+}
+
+// quote status values of platforms that are not revoked; IAS signs reports of revoked platforms too
+static const char* ACCEPTED_QUOTE_STATUS[] = {"OK", "GROUP_OUT_OF_DATE", "CONFIGURATION_NEEDED",
+    "SW_HARDENING_NEEDED", "CONFIGURATION_AND_SW_HARDENING_NEEDED"};
+
+static bool quote_status_accepted(const char* status)
+{
+    if (status == NULL) {
+        return false;
+    }
+    for (size_t i = 0; i < sizeof(ACCEPTED_QUOTE_STATUS) / sizeof(ACCEPTED_QUOTE_STATUS[0]); i++) {
+        if (strcmp(status, ACCEPTED_QUOTE_STATUS[i]) == 0) {
+            return true;
+        }
+    }
+    return false;
+}
 
+// returns the coordinates (x || y, big endian) of a DER-encoded PKIX P-256 key
+static int p256_pk_from_der(const std::string& der, uint8_t pk[64])
+{
+    const unsigned char* tmp = (const unsigned char*)der.c_str();
+    EC_KEY* pubkey = d2i_EC_PUBKEY(NULL, &tmp, der.size());
+    if (pubkey == NULL) {
+        LOG_ERROR("IAS: Pubkey error: %s", ERR_error_string(ERR_get_error(), NULL));
+        return -1;
+    }
+
+    const EC_GROUP* grp = EC_KEY_get0_group(pubkey);
+    BIGNUM* x = BN_new();
+    BIGNUM* y = BN_new();
+    int ok = EC_GROUP_get_curve_name(grp) == NID_X9_62_prime256v1 && x != NULL && y != NULL &&
+             EC_POINT_get_affine_coordinates_GFp(grp, EC_KEY_get0_public_key(pubkey), x, y, NULL) &&
+             BN_bn2binpad(x, pk, 32) == 32 && BN_bn2binpad(y, pk + 32, 32) == 32;
+    BN_free(x);
+    BN_free(y);
+    EC_KEY_free(pubkey);
+    return ok ? 0 : -1;
+}
+
+int verify_enclave_report(const char* json, sgx_report_body_t* report_body, uint8_t enclave_pk[64])
+{
+    JSON_Value* root = json_parse_string(json);
+    const char* base64_enclave_pk = json_object_get_string(json_object(root), "EnclavePk");
+    const char* base64_signature = json_object_get_string(json_object(root), "IASReport-Signature");
+    const char* base64_report_body = json_object_get_string(json_object(root), "IASResponseBody");
+    if (base64_enclave_pk == NULL || base64_signature == NULL || base64_report_body == NULL) {
+        LOG_ERROR("IAS: Incomplete attestation report");
+        json_value_free(root);
+        return IAS_ERROR;
+    }
+    std::string enclave_pk_der = base64_decode(base64_enclave_pk);
+    std::string body = base64_decode(base64_report_body);
+    int ret = verify_report_signature(body, base64_signature);
+    json_value_free(root);
+    if (ret != IAS_SUCCESS) {
+        return IAS_ERROR;
+    }
+
+    root = json_parse_string(body.c_str());
+    const char* status = json_object_get_string(json_object(root), "isvEnclaveQuoteStatus");
+    const char* base64_quote = json_object_get_string(json_object(root), "isvEnclaveQuoteBody");
+    if (!quote_status_accepted(status) || base64_quote == NULL) {
+        LOG_ERROR("IAS: Quote status %s not accepted", status != NULL ? status : "missing");
+        json_value_free(root);
+        return IAS_ERROR;
+    }
+    std::string quote = base64_decode(base64_quote);
+    json_value_free(root);
+
+    // IAS returns the quote without signature
+    if (quote.size() < offsetof(sgx_quote_t, signature_len)) {
+        LOG_ERROR("IAS: Quote too short");
+        return IAS_ERROR;
+    }
+    memcpy(report_body, &((const sgx_quote_t*)quote.data())->report_body, sizeof(sgx_report_body_t));
+
+    // REPORT_DATA starts with the hash of the enclave pk
+    if (p256_pk_from_der(enclave_pk_der, enclave_pk) != 0) {
+        LOG_ERROR("IAS: Enclave PK is not a P-256 key");
+        return IAS_ERROR;
+    }
+    unsigned char pk_hash[32];
+    SHA256(enclave_pk, 64, pk_hash);
+    if (!consttime_memequal(report_body->report_data.d, pk_hash, sizeof(pk_hash))) {
+        LOG_ERROR("IAS: Enclave PK does not match attestation report");
+        return IAS_ERROR;
+    }
     return IAS_SUCCESS;
 }
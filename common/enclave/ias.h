@@ -40,6 +40,12 @@ typedef struct attestation_report {
 
 int verify_attestation_report(uint8_t* json_data, size_t json_len, mrenclave_t* mrenclave);
 
+// verifies the IAS signature over an attestation report (JSON, see attestation.IASAttestationReport) of
+// another enclave, that IAS did not find the platform revoked and that the quote binds the P-256
+// enclave pk of the report (see attestation.ReportDataFormat); returns the report body of the quote
+// and the enclave pk (x || y, big endian)
+int verify_enclave_report(const char* json, sgx_report_body_t* report_body, uint8_t enclave_pk[64]);
+
 #ifdef __cplusplus
 }
 #endif
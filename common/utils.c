@@ -38,6 +38,17 @@ void bytes_swap(void *bytes, size_t len)
     }
 }
 
+int consttime_memequal(const void *a, const void *b, size_t len)
+{
+    const volatile unsigned char *x = (const volatile unsigned char *)a;
+    const volatile unsigned char *y = (const volatile unsigned char *)b;
+    unsigned char diff = 0;
+    for (size_t i = 0; i < len; i++) {
+        diff |= x[i] ^ y[i];
+    }
+    return diff == 0;
+}
+
 char *bytes_to_hexstring(uint8_t *bytes, size_t len)
 {
     const char *hexdigs = "0123456789abcdef";
@@ -29,6 +29,9 @@ extern "C" {
 int append_string(char *buf, const char *string);
 void bytes_swap(void *bytes, size_t len);
 char *bytes_to_hexstring(uint8_t *bytes, size_t len);
+// returns 1 if the len bytes at a and b are equal, 0 otherwise, in time independent of their
+// contents; compare MACs, digests and measurements with it rather than memcmp
+int consttime_memequal(const void *a, const void *b, size_t len);
 
 #ifdef __cplusplus
 }
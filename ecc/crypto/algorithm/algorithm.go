@@ -0,0 +1,134 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Package algorithm names the signature and encryption algorithms of enclave keys. The algorithms of an enclave
+// follow from the type of its key; the chaincode enclave creates P-256 keys (sgx_ecc256), so these are the only
+// supported ones:
+//
+//	key          signature    encryption
+//	P-256        ECDSA-P256   ECDH-P256
+//
+// ercc records the algorithms when it registers an enclave, so clients know how to verify responses and encrypt
+// requests before they talk to the enclave, and a channel negotiates them through its algorithm policy. Further
+// algorithms can be added here once the enclave creates keys for them. Registrations without recorded algorithms
+// predate this and use Default.
+//
+// The package depends on the standard library only, so it is usable in the cgo-free verification path.
+package algorithm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// Signature algorithms
+const (
+	ECDSAP256 = "ECDSA-P256"
+)
+
+// Encryption algorithms, i.e., the key agreement with the enclave key
+const (
+	ECDHP256 = "ECDH-P256"
+)
+
+// Algorithms of an enclave key
+type Algorithms struct {
+	Signature  string `json:"Signature"`
+	Encryption string `json:"Encryption"`
+}
+
+// Default are the algorithms of enclaves registered without algorithms
+var Default = Algorithms{Signature: ECDSAP256, Encryption: ECDHP256}
+
+// Supported lists the supported algorithms in order of preference
+var Supported = []Algorithms{
+	{Signature: ECDSAP256, Encryption: ECDHP256},
+}
+
+// String returns the algorithms as "<signature>/<encryption>"
+func (a Algorithms) String() string {
+	return a.Signature + "/" + a.Encryption
+}
+
+// Parse parses algorithms in the format of String
+func Parse(s string) (Algorithms, error) {
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return Algorithms{}, fmt.Errorf("invalid algorithms %s, expecting <signature>/<encryption>", s)
+	}
+	a := Algorithms{Signature: s[:i], Encryption: s[i+1:]}
+	if err := a.Check(); err != nil {
+		return Algorithms{}, err
+	}
+	return a, nil
+}
+
+// OrDefault returns the recorded algorithms a, or Default if none are recorded
+func OrDefault(a *Algorithms) Algorithms {
+	if a == nil {
+		return Default
+	}
+	return *a
+}
+
+// Check returns an error if the algorithms are not supported
+func (a Algorithms) Check() error {
+	for _, supported := range Supported {
+		if a == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("algorithms %s not supported", a)
+}
+
+// ParsePublicKey parses an enclave public key in DER-encoded PKIX format and returns it along with its algorithms
+func ParsePublicKey(pkBytes []byte) (*ecdsa.PublicKey, Algorithms, error) {
+	pub, err := x509.ParsePKIXPublicKey(pkBytes)
+	if err != nil {
+		return nil, Algorithms{}, fmt.Errorf("can not parse enclave key: %s", err)
+	}
+
+	ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, Algorithms{}, fmt.Errorf("enclave key of type %T not supported", pub)
+	}
+	if ecdsaPub.Curve != elliptic.P256() {
+		return nil, Algorithms{}, fmt.Errorf("enclave key on curve %s not supported", ecdsaPub.Curve.Params().Name)
+	}
+	return ecdsaPub, Algorithms{Signature: ECDSAP256, Encryption: ECDHP256}, nil
+}
+
+// Of returns the algorithms of an enclave public key in DER-encoded PKIX format
+func Of(pkBytes []byte) (Algorithms, error) {
+	_, a, err := ParsePublicKey(pkBytes)
+	return a, err
+}
+
+// CheckKey returns an error if the recorded algorithms a (nil if none) do not match the enclave public key in
+// DER-encoded PKIX format
+func CheckKey(a *Algorithms, pkBytes []byte) error {
+	actual, err := Of(pkBytes)
+	if err != nil {
+		return err
+	}
+	if expected := OrDefault(a); actual != expected {
+		return fmt.Errorf("enclave key uses %s, but %s is recorded", actual, expected)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package algorithm
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+)
+
+func marshalPk(t *testing.T, pub interface{}) []byte {
+	pkBytes, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Can not marshal pk: %s", err)
+	}
+	return pkBytes
+}
+
+func TestParsePublicKey(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a, err := Of(marshalPk(t, &p256.PublicKey))
+	if err != nil {
+		t.Fatalf("Can not get algorithms of P-256 key: %s", err)
+	}
+	if a != Default {
+		t.Fatalf("Expected %s but got %s", Default, a)
+	}
+	if parsed, err := Parse(a.String()); err != nil || parsed != a {
+		t.Fatalf("Can not parse %s: %v", a, err)
+	}
+
+	// the enclave creates no other keys
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	for _, pub := range []interface{}{&p384.PublicKey, edPub} {
+		if _, err := Of(marshalPk(t, pub)); err == nil {
+			t.Fatalf("%T keys should not be supported", pub)
+		}
+	}
+	if _, err := Parse("ECDSA-P384/ECDH-P384"); err == nil {
+		t.Fatalf("P-384 algorithms should not be supported")
+	}
+	if _, err := Parse(ECDSAP256 + "/X25519"); err == nil {
+		t.Fatalf("Mixed algorithms should not be supported")
+	}
+	if _, err := Parse(ECDSAP256); err == nil {
+		t.Fatalf("Algorithms without encryption should not parse")
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	// registrations without algorithms use P-256
+	if err := CheckKey(nil, marshalPk(t, &p256.PublicKey)); err != nil {
+		t.Fatalf("P-256 key should match no algorithms: %s", err)
+	}
+	if err := CheckKey(&Default, marshalPk(t, &p256.PublicKey)); err != nil {
+		t.Fatalf("P-256 key should match %s: %s", Default, err)
+	}
+	other := Algorithms{Signature: "ECDSA-P384", Encryption: "ECDH-P384"}
+	if err := CheckKey(&other, marshalPk(t, &p256.PublicKey)); err == nil {
+		t.Fatalf("P-256 key should not match %s", other)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// AuditObjectType is the object type of the composite keys (AuditObjectType, ID) audit records are stored under
+const AuditObjectType = "fpc_audit"
+
+// AuditPkSecret is the name of the secret an auditor public key (sgx format) is provisioned to an enclave as
+const AuditPkSecret = "audit_pk"
+
+// AuditRecord is the record of a decryption or key release operation of an enclave, see
+// ecc_enclave/enclave/audit.h. Record is the record as JSON, e.g., {"seq": 3, "op": "decrypt_state", "keys":
+// [...], "creator": "x509::..."}, or, if EphemeralPk is set, the record encrypted to the auditor.
+type AuditRecord struct {
+	ID          string `json:"id"`
+	EnclavePk   []byte `json:"enclave_pk"`
+	Record      []byte `json:"record"`
+	EphemeralPk []byte `json:"ephemeral_pk,omitempty"`
+	Signature   []byte `json:"signature"`
+}
+
+// Verify checks the signature of the enclave over the record. It does not check that EnclavePk (sgx format) is the
+// key of a registered enclave; auditors look it up at ercc.
+func (r *AuditRecord) Verify() error {
+	pk, err := EnclavePk2ECDSAPK(r.EnclavePk)
+	if err != nil {
+		return err
+	}
+	if len(r.Signature) != 64 {
+		return errors.New("Invalid audit record signature size")
+	}
+
+	// H(id || ephemeral pk || record); sgx hashes again when signing
+	h := sha256.New()
+	h.Write([]byte(r.ID))
+	h.Write(r.EphemeralPk)
+	h.Write(r.Record)
+	hash := sha256.Sum256(h.Sum(nil))
+
+	rr := new(big.Int).SetBytes(r.Signature[:32])
+	s := new(big.Int).SetBytes(r.Signature[32:])
+	if !ecdsa.Verify(pk, hash[:], rr, s) {
+		return errors.New("Invalid audit record signature")
+	}
+	return nil
+}
+
+// Decrypt returns the record encrypted to the auditor with key auditorSk
+func (r *AuditRecord) Decrypt(auditorSk *ecdsa.PrivateKey) ([]byte, error) {
+	if len(r.EphemeralPk) == 0 {
+		return nil, errors.New("Audit record is not encrypted")
+	}
+	ephemeralPub, err := EnclavePk2ECDSAPK(r.EphemeralPk)
+	if err != nil {
+		return nil, err
+	}
+	key, err := GenSharedKey(ephemeralPub, auditorSk)
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(r.Record, key)
+}
@@ -0,0 +1,97 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+)
+
+// sealAuditRecord creates a record like the enclave does
+func sealAuditRecord(t *testing.T, enclaveSk *ecdsa.PrivateKey, record []byte, auditorPub *ecdsa.PublicKey) *AuditRecord {
+	r := &AuditRecord{ID: "00112233445566778899aabbccddeeff", EnclavePk: MarshalSgxPk(&enclaveSk.PublicKey), Record: record}
+	if auditorPub != nil {
+		ephemeralPriv, ephemeralPub, _ := GenKeyPair()
+		key, _ := GenSharedKey(auditorPub, ephemeralPriv)
+		cipher, err := Encrypt(record, key)
+		if err != nil {
+			t.Fatalf("Encrypt returned error: %s", err)
+		}
+		r.Record = cipher
+		r.EphemeralPk = MarshalSgxPk(ephemeralPub)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(r.ID))
+	h.Write(r.EphemeralPk)
+	h.Write(r.Record)
+	hash := sha256.Sum256(h.Sum(nil))
+	sr, ss, err := ecdsa.Sign(rand.Reader, enclaveSk, hash[:])
+	if err != nil {
+		t.Fatalf("Sign returned error: %s", err)
+	}
+	r.Signature = make([]byte, 64)
+	copy(r.Signature[32-len(sr.Bytes()):32], sr.Bytes())
+	copy(r.Signature[64-len(ss.Bytes()):], ss.Bytes())
+	return r
+}
+
+func TestAuditRecord(t *testing.T) {
+	enclaveSk, _, _ := GenKeyPair()
+	auditorSk, auditorPub, _ := GenKeyPair()
+	record := []byte(`{"op":"decrypt_state","keys":["salary.alice"],"seq":0}`)
+
+	// plain record
+	r := sealAuditRecord(t, enclaveSk, record, nil)
+	if err := r.Verify(); err != nil {
+		t.Fatalf("Verify returned error: %s", err)
+	}
+	if _, err := r.Decrypt(auditorSk); err == nil {
+		t.Fatalf("Decrypt of a plain record should fail")
+	}
+
+	// record encrypted to the auditor
+	r = sealAuditRecord(t, enclaveSk, record, auditorPub)
+	if err := r.Verify(); err != nil {
+		t.Fatalf("Verify returned error: %s", err)
+	}
+	plain, err := r.Decrypt(auditorSk)
+	if err != nil {
+		t.Fatalf("Decrypt returned error: %s", err)
+	}
+	if !bytes.Equal(plain, record) {
+		t.Fatalf("Decrypted record does not match")
+	}
+	otherSk, _, _ := GenKeyPair()
+	if _, err := r.Decrypt(otherSk); err == nil {
+		t.Fatalf("Decrypt with another key should fail")
+	}
+
+	// tampered records
+	r.ID = "ffffffffffffffffffffffffffffffff"
+	if err := r.Verify(); err == nil {
+		t.Fatalf("Verify should fail for a tampered id")
+	}
+	r = sealAuditRecord(t, enclaveSk, record, nil)
+	r.Record = []byte(`{"op":"decrypt_state","keys":[],"seq":0}`)
+	if err := r.Verify(); err == nil {
+		t.Fatalf("Verify should fail for a tampered record")
+	}
+}
@@ -0,0 +1,34 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Package consttime provides the comparisons of verification code: MACs, digests, nonces and measurements are
+// compared with Equal and EqualString rather than bytes.Equal, reflect.DeepEqual or ==, whose running time reveals
+// the length of the common prefix. Only the lengths of the values may leak.
+//
+// The package depends on the standard library only, so it is usable in the cgo-free verification path.
+package consttime
+
+import "crypto/subtle"
+
+// Equal reports whether a and b are equal, in time independent of their contents
+func Equal(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// EqualString is Equal for strings, e.g., base64 encoded hashes
+func EqualString(a, b string) bool {
+	return Equal([]byte(a), []byte(b))
+}
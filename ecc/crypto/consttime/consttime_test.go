@@ -0,0 +1,39 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package consttime
+
+import "testing"
+
+func TestEqual(t *testing.T) {
+	for _, c := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{"", "", true},
+		{"digest", "digest", true},
+		{"digest", "digesT", false},
+		{"digest", "diges", false},
+		{"", "digest", false},
+	} {
+		if Equal([]byte(c.a), []byte(c.b)) != c.equal || EqualString(c.a, c.b) != c.equal {
+			t.Fatalf("Expected %q and %q equal: %t", c.a, c.b, c.equal)
+		}
+	}
+	if !Equal(nil, []byte{}) {
+		t.Fatalf("nil and empty should be equal")
+	}
+}
@@ -25,6 +25,9 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/utils"
 )
 
 type ecdsaSignature struct {
@@ -58,6 +61,9 @@ func UnmarshalECDSASignature(raw []byte) (*big.Int, *big.Int, error) {
 }
 
 func MarshalEnclaveSignature(input []byte) ([]byte, error) {
+	if len(input) != 64 {
+		return nil, fmt.Errorf("Invalid signature. Expected 64 bytes but got %d", len(input))
+	}
 
 	r := new(big.Int)
 	r.SetBytes(input[:32])
@@ -91,7 +97,10 @@ func UnmarshalEnclavePk(input []byte) ([]byte, error) {
 		return nil, fmt.Errorf("Failed to parse DER encoded public key [%s]", err)
 	}
 
-	pub := re.(*ecdsa.PublicKey)
+	pub, ok := re.(*ecdsa.PublicKey)
+	if !ok || len(pub.X.Bytes()) > 32 || len(pub.Y.Bytes()) > 32 {
+		return nil, errors.New("Public key is not a P-256 key")
+	}
 
 	out := make([]byte, 64)
 	copy(out[:32], pub.X.Bytes())
@@ -101,6 +110,9 @@ func UnmarshalEnclavePk(input []byte) ([]byte, error) {
 }
 
 func EnclavePk2ECDSAPK(input []byte) (*ecdsa.PublicKey, error) {
+	if len(input) != 64 {
+		return nil, fmt.Errorf("Public key not valid (expected 64 bytes but got %d)", len(input))
+	}
 	x := new(big.Int)
 	x.SetBytes(input[:32])
 
@@ -132,45 +144,37 @@ func MarshalEnclavePk(input []byte) (raw []byte, err error) {
 	return raw, nil
 }
 
-// ECDSAVerifier implements Verifier interface!
+// ECDSAVerifier implements Verifier interface! It verifies signatures of the algorithms of package algorithm,
+// i.e., ECDSA-P256.
 type ECDSAVerifier struct {
 }
 
 // Verify returns true if signature validation of enclave return is correct; other false
 func (v *ECDSAVerifier) Verify(args, responseData []byte, readset, writeset [][]byte, signature, enclavePk []byte) (bool, error) {
-	// unmarshall signature
-	r, s, err := UnmarshalECDSASignature(signature)
-	if err != nil {
-		return false, fmt.Errorf("Failed unmarshalling signature [%s]", err)
-	}
-
 	// unmarshall pk
-	pk, err := x509.ParsePKIXPublicKey(enclavePk)
+	pk, _, err := algorithm.ParsePublicKey(enclavePk)
 	if err != nil {
-		return false, fmt.Errorf("Failed parsing ecdsa public key [%s]", err)
-	}
-	ecdsaPublickey, ok := pk.(*ecdsa.PublicKey)
-	if !ok {
-		return false, fmt.Errorf("Verification key is not of type ECDSA")
+		return false, fmt.Errorf("Failed parsing enclave public key [%s]", err)
 	}
 
-	// H(args || response || readset || writeset)
+	// H(args || response || H(readset || writeset))
 	h := sha256.New()
 	h.Write(args)
 	h.Write(responseData)
-	for _, r := range readset {
-		h.Write(r)
-	}
-	for _, w := range writeset {
-		h.Write(w)
-	}
+	h.Write(utils.RWSetDigest(readset, writeset))
 	hash := h.Sum(nil)
 
 	// hashBase64 := base64.StdEncoding.EncodeToString(hash)
 	// fmt.Printf("hash base64 for ecdsa signaature: %s\n", hashBase64)
 
+	// unmarshall signature
+	r, s, err := UnmarshalECDSASignature(signature)
+	if err != nil {
+		return false, fmt.Errorf("Failed unmarshalling signature [%s]", err)
+	}
+
 	// hash again!!! Note that, sgx_sign() takes the hash, as computed above, as input and hashes again
 	hash2 := sha256.Sum256(hash)
 
-	return ecdsa.Verify(ecdsaPublickey, hash2[:], r, s), nil
+	return ecdsa.Verify(pk, hash2[:], r, s), nil
 }
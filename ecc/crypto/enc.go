@@ -27,6 +27,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 )
 
 const (
@@ -66,6 +67,9 @@ func Encrypt(plaintextBytes, key []byte) ([]byte, error) {
 }
 
 func Decrypt(input, key []byte) ([]byte, error) {
+	if len(input) < aesgcm_iv_size+aesgcm_mac_size {
+		return nil, errors.New("Ciphertext too short")
+	}
 	iv := input[:aesgcm_iv_size]
 	mac := input[aesgcm_iv_size : aesgcm_iv_size+aesgcm_mac_size]
 	ciphertext := input[aesgcm_iv_size+aesgcm_mac_size:]
@@ -99,6 +103,10 @@ func ParseECDSAPubKey(raw []byte) (*ecdsa.PublicKey, error) {
 	if !ok {
 		return nil, fmt.Errorf("Verification key is not of type ECDSA")
 	}
+	// the sgx format and the key agreement of GenSharedKey are defined for P-256 only
+	if enclavePub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("Verification key is not a P-256 key")
+	}
 	return enclavePub, nil
 }
 
@@ -130,14 +138,17 @@ func GenSharedKey(pub *ecdsa.PublicKey, priv *ecdsa.PrivateKey) ([]byte, error)
 	// fmt.Printf("%s", hex.Dump(x.Bytes()))
 	// fmt.Printf("%s", hex.Dump(y.Bytes()))
 
-	// hash the key
-	h := sha256.New()
-	h.Write(x.Bytes())
-	// h.Write(y.Bytes())
-	key := h.Sum(nil)
+	return sharedKey(x), nil
+}
+
+// sharedKey derives the aes key from the x coordinate of a shared point. Like the enclave, it hashes x padded to
+// 32 bytes.
+func sharedKey(x *big.Int) []byte {
+	xBytes := make([]byte, 32)
+	copy(xBytes[32-len(x.Bytes()):], x.Bytes())
 
-	// fmt.Printf("shared dh hash\n%s", hex.Dump(key))
-	return key[:16], nil
+	key := sha256.Sum256(xBytes)
+	return key[:aesgcm_key_size]
 }
 
 // this is just for testing ... dont use it
@@ -164,3 +175,49 @@ func GenCrypto(enclavePk []byte) ([]byte, []byte) {
 	key, err := GenSharedKey(enclavePub, priv)
 	return key, pubBytes
 }
+
+// EncryptForEnclave encrypts plaintext to an enclave given its public key in DER-encoded PKIX format.
+// It returns the ephemeral public key in sgx format (big endian) and the ciphertext. The enclave
+// derives the same key from the ephemeral public key using its private key.
+func EncryptForEnclave(plaintext, enclavePk []byte) ([]byte, []byte, error) {
+	ephemeralPk, key, err := DeriveEnclaveKey(enclavePk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ciphertext, err := Encrypt(plaintext, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ephemeralPk, ciphertext, nil
+}
+
+// DeriveEnclaveKey generates an ephemeral key pair and returns its public key in sgx format and the key shared
+// with the enclave, e.g., if the plaintext must bind the ephemeral public key before it is encrypted
+func DeriveEnclaveKey(enclavePk []byte) ([]byte, []byte, error) {
+	enclavePub, err := ParseECDSAPubKey(enclavePk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, pub, err := GenKeyPair()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to generate key pair [%s]", err)
+	}
+
+	key, err := GenSharedKey(enclavePub, priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return MarshalSgxPk(pub), key, nil
+}
+
+// MarshalSgxPk transforms a public key to sgx format, that is, X and Y in big endian and padded to 32 bytes each
+func MarshalSgxPk(pub *ecdsa.PublicKey) []byte {
+	pubBytes := make([]byte, 64)
+	copy(pubBytes[32-len(pub.X.Bytes()):32], pub.X.Bytes())
+	copy(pubBytes[64-len(pub.Y.Bytes()):], pub.Y.Bytes())
+	return pubBytes
+}
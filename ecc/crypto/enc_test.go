@@ -17,9 +17,12 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	"testing"
@@ -52,3 +55,113 @@ func TestDH(t *testing.T) {
 	fmt.Printf("Base64 cipher: %s\n", ciphertext)
 	fmt.Printf("Base64 my pk: %s\n", base64.StdEncoding.EncodeToString(pubBytes))
 }
+
+func TestEncryptForEnclave(t *testing.T) {
+	enclavePriv, enclavePub, err := GenKeyPair()
+	if err != nil {
+		t.Fatalf("Can not gen key pair: %s", err)
+	}
+	enclavePk, err := x509.MarshalPKIXPublicKey(enclavePub)
+	if err != nil {
+		t.Fatalf("Can not marshal enclave pk: %s", err)
+	}
+
+	secret := []byte("my secret api key")
+	ephemeralPk, ciphertext, err := EncryptForEnclave(secret, enclavePk)
+	if err != nil {
+		t.Fatalf("EncryptForEnclave returned error: %s", err)
+	}
+
+	// enclave side
+	ephemeralPub, err := EnclavePk2ECDSAPK(ephemeralPk)
+	if err != nil {
+		t.Fatalf("Invalid ephemeral pk: %s", err)
+	}
+	key, _ := GenSharedKey(ephemeralPub, enclavePriv)
+	plaintext, err := Decrypt(ciphertext, key)
+	if err != nil {
+		t.Fatalf("Decrypt returned error: %s", err)
+	}
+	if !bytes.Equal(plaintext, secret) {
+		t.Fatalf("Expected %s but got %s", secret, plaintext)
+	}
+}
+
+func TestEnclaveKey_OtherAlgorithms(t *testing.T) {
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	// the enclave creates P-256 keys only, so neither requests are encrypted to nor responses verified with others
+	verifier := &ECDSAVerifier{}
+	for _, pub := range []interface{}{&p384.PublicKey, edPub} {
+		enclavePk, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatalf("Can not marshal enclave pk: %s", err)
+		}
+		if _, _, err := EncryptForEnclave([]byte("my secret api key"), enclavePk); err == nil {
+			t.Fatalf("EncryptForEnclave should fail for %T keys", pub)
+		}
+		if ok, err := verifier.Verify([]byte("args"), []byte("response"), nil, nil, make([]byte, 64), enclavePk); err == nil || ok {
+			t.Fatalf("Verify should fail for %T keys", pub)
+		}
+	}
+}
+
+func BenchmarkEncryptForEnclave(b *testing.B) {
+	_, enclavePub, err := GenKeyPair()
+	if err != nil {
+		b.Fatalf("Can not gen key pair: %s", err)
+	}
+	enclavePk, err := x509.MarshalPKIXPublicKey(enclavePub)
+	if err != nil {
+		b.Fatalf("Can not marshal enclave pk: %s", err)
+	}
+	args := []byte(`["submit","MyAuction123","Bob","1"]`)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := EncryptForEnclave(args, enclavePk); err != nil {
+			b.Fatalf("EncryptForEnclave returned error: %s", err)
+		}
+	}
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	priv, pub, err := GenKeyPair()
+	if err != nil {
+		b.Fatalf("Can not gen key pair: %s", err)
+	}
+	key, err := GenSharedKey(pub, priv)
+	if err != nil {
+		b.Fatalf("Can not gen shared key: %s", err)
+	}
+	ciphertext, err := Encrypt([]byte(`["submit","MyAuction123","Bob","1"]`), key)
+	if err != nil {
+		b.Fatalf("Can not encrypt: %s", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Decrypt(ciphertext, key); err != nil {
+			b.Fatalf("Decrypt returned error: %s", err)
+		}
+	}
+}
+
+func TestMalformedInput(t *testing.T) {
+	key := make([]byte, aesgcm_key_size)
+	for _, input := range [][]byte{nil, make([]byte, aesgcm_iv_size), make([]byte, aesgcm_iv_size+aesgcm_mac_size-1)} {
+		if _, err := Decrypt(input, key); err == nil {
+			t.Fatalf("Decrypt should reject %d bytes", len(input))
+		}
+	}
+	for _, input := range [][]byte{nil, make([]byte, 32), make([]byte, 65)} {
+		if _, err := EnclavePk2ECDSAPK(input); err == nil {
+			t.Fatalf("EnclavePk2ECDSAPK should reject %d bytes", len(input))
+		}
+		if _, err := MarshalEnclaveSignature(input); err == nil {
+			t.Fatalf("MarshalEnclaveSignature should reject %d bytes", len(input))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Threshold escrow of the state key of a chaincode. The enclave splits its state key with Shamir's secret sharing
+// over GF(2^8), one polynomial per key byte, into shares x || y_1 .. y_16 with x in [1, 255], and encrypts each
+// share to a recovery party like EncryptForEnclave does. To recover the key in a new enclave, threshold parties
+// decrypt their shares and re-encrypt them to the new enclave with ReEncryptEscrowShare; the enclave combines the
+// shares and checks the key against the check value of the escrow. Enclaves split and combine inside the enclave;
+// SplitSecret and CombineShares are the reference implementation.
+
+// SplitSecret splits secret into n shares, any threshold of which reconstruct it with CombineShares
+func SplitSecret(secret []byte, threshold, n int) ([][]byte, error) {
+	if threshold < 1 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("Invalid threshold %d of %d", threshold, n)
+	}
+
+	// coefficients[i] are the coefficients of the polynomial of byte i, constant term first
+	coefficients := make([][]byte, len(secret))
+	for i := range secret {
+		coefficients[i] = make([]byte, threshold)
+		coefficients[i][0] = secret[i]
+		if _, err := rand.Read(coefficients[i][1:]); err != nil {
+			return nil, err
+		}
+	}
+
+	shares := make([][]byte, n)
+	for x := 1; x <= n; x++ {
+		share := []byte{byte(x)}
+		for i := range secret {
+			var y byte
+			for j := threshold - 1; j >= 0; j-- {
+				y = gfMul(y, byte(x)) ^ coefficients[i][j]
+			}
+			share = append(share, y)
+		}
+		shares[x-1] = share
+	}
+	return shares, nil
+}
+
+// CombineShares reconstructs the secret from shares created by SplitSecret. Fewer than threshold shares yield a
+// wrong secret.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 || len(shares[0]) < 2 {
+		return nil, errors.New("Invalid shares")
+	}
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != len(shares[0]) || share[0] == 0 || seen[share[0]] {
+			return nil, errors.New("Malformed or duplicate share")
+		}
+		seen[share[0]] = true
+	}
+
+	// interpolate at 0 with the lagrange basis l_k = prod_{m != k} x_m / (x_m - x_k); minus is xor
+	secret := make([]byte, len(shares[0])-1)
+	for k, share := range shares {
+		l := byte(1)
+		for m, other := range shares {
+			if m != k {
+				l = gfMul(l, gfMul(other[0], gfInv(other[0]^share[0])))
+			}
+		}
+		for i := range secret {
+			secret[i] ^= gfMul(l, share[i+1])
+		}
+	}
+	return secret, nil
+}
+
+// ReEncryptEscrowShare decrypts the escrow share encrypted to a recovery party with secret key partySk, given the
+// ephemeral pk (sgx format) and ciphertext of the share, and encrypts it to the recovering enclave with public key
+// enclavePk (DER-encoded PKIX). It returns the ephemeral pk (sgx format) and the ciphertext for the enclave.
+func ReEncryptEscrowShare(partySk *ecdsa.PrivateKey, ephemeralPk, ciphertext, enclavePk []byte) ([]byte, []byte, error) {
+	ephemeralPub, err := EnclavePk2ECDSAPK(ephemeralPk)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := GenSharedKey(ephemeralPub, partySk)
+	if err != nil {
+		return nil, nil, err
+	}
+	share, err := Decrypt(ciphertext, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Can not decrypt escrow share: %s", err)
+	}
+	return EncryptForEnclave(share, enclavePk)
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x + 1
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the inverse of a != 0 in GF(2^8) as a^254
+func gfInv(a byte) byte {
+	result, square := byte(1), a
+	for e := 254; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result = gfMul(result, square)
+		}
+		square = gfMul(square, square)
+	}
+	return result
+}
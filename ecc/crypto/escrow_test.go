@@ -0,0 +1,90 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+)
+
+func TestSplitSecret(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	shares, err := SplitSecret(secret, 3, 5)
+	if err != nil {
+		t.Fatalf("SplitSecret returned error: %s", err)
+	}
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var selected [][]byte
+		for _, i := range subset {
+			selected = append(selected, shares[i])
+		}
+		combined, err := CombineShares(selected)
+		if err != nil {
+			t.Fatalf("CombineShares returned error: %s", err)
+		}
+		if !bytes.Equal(combined, secret) {
+			t.Fatalf("Shares %v combine to %x instead of %x", subset, combined, secret)
+		}
+	}
+
+	if combined, _ := CombineShares(shares[:2]); bytes.Equal(combined, secret) {
+		t.Fatalf("Fewer shares than the threshold should not reveal the secret")
+	}
+	if _, err := CombineShares([][]byte{shares[0], shares[0], shares[1]}); err == nil {
+		t.Fatalf("Duplicate shares should be rejected")
+	}
+	if _, err := SplitSecret(secret, 4, 3); err == nil {
+		t.Fatalf("Threshold above the number of shares should be rejected")
+	}
+}
+
+func TestReEncryptEscrowShare(t *testing.T) {
+	partyPriv, partyPub, _ := GenKeyPair()
+	partyPk, _ := x509.MarshalPKIXPublicKey(partyPub)
+	enclavePriv, enclavePub, _ := GenKeyPair()
+	enclavePk, _ := x509.MarshalPKIXPublicKey(enclavePub)
+
+	share := []byte{1, 2, 3}
+	ephemeralPk, ciphertext, err := EncryptForEnclave(share, partyPk)
+	if err != nil {
+		t.Fatalf("EncryptForEnclave returned error: %s", err)
+	}
+
+	reEncryptedPk, reEncrypted, err := ReEncryptEscrowShare(partyPriv, ephemeralPk, ciphertext, enclavePk)
+	if err != nil {
+		t.Fatalf("ReEncryptEscrowShare returned error: %s", err)
+	}
+
+	// what the recovering enclave does
+	reEncryptedPub, _ := EnclavePk2ECDSAPK(reEncryptedPk)
+	key, _ := GenSharedKey(reEncryptedPub, enclavePriv)
+	plaintext, err := Decrypt(reEncrypted, key)
+	if err != nil {
+		t.Fatalf("Enclave can not decrypt share: %s", err)
+	}
+	if !bytes.Equal(plaintext, share) {
+		t.Fatalf("Enclave decrypted %x instead of %x", plaintext, share)
+	}
+
+	// only the party can re-encrypt its share
+	otherPriv, _, _ := GenKeyPair()
+	if _, _, err := ReEncryptEscrowShare(otherPriv, ephemeralPk, ciphertext, enclavePk); err == nil {
+		t.Fatalf("Share should only decrypt for its party")
+	}
+}
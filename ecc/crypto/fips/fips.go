@@ -0,0 +1,68 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Package fips restricts the algorithms of FPC to those approved under FIPS 140 if built with the fips tag. The tag
+// requires a Go toolchain with BoringCrypto, whose FIPS-validated module then performs the cryptographic
+// operations, and makes crypto/tls accept FIPS-approved settings only:
+//
+//	$ GOEXPERIMENT=boringcrypto go build -tags fips
+//
+// Without the tag, Enabled is false and all checks pass.
+package fips
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"fmt"
+)
+
+// MinRSAKeySize is the minimum size in bits of RSA keys verifying signatures in FIPS mode
+const MinRSAKeySize = 2048
+
+// approvedCipherSuites are the TLS 1.2 cipher suites allowed in FIPS mode, i.e., those with AES-GCM
+var approvedCipherSuites = map[uint16]bool{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384: true,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   true,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:   true,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:         true,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:         true,
+}
+
+// CheckRSAKey returns an error if pub is too short to verify signatures in FIPS mode
+func CheckRSAKey(pub *rsa.PublicKey) error {
+	if Enabled && pub.N.BitLen() < MinRSAKeySize {
+		return fmt.Errorf("RSA key of %d bits not allowed in FIPS mode", pub.N.BitLen())
+	}
+	return nil
+}
+
+// CheckCipherSuite returns an error if the TLS 1.2 cipher suite is not allowed in FIPS mode
+func CheckCipherSuite(suite uint16) error {
+	if Enabled && !approvedCipherSuites[suite] {
+		return fmt.Errorf("cipher suite 0x%04x not allowed in FIPS mode", suite)
+	}
+	return nil
+}
+
+// CheckFeature returns an error if feature, which relies on constructions not approved under FIPS 140, is used in
+// FIPS mode
+func CheckFeature(feature string) error {
+	if Enabled {
+		return fmt.Errorf("%s is not available in FIPS mode", feature)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package fips
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"math/big"
+	"testing"
+)
+
+func TestChecks(t *testing.T) {
+	short := &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 1023), E: 65537}
+	long := &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 3071), E: 65537}
+
+	if err := CheckRSAKey(long); err != nil {
+		t.Fatalf("3072 bit key should be allowed: %s", err)
+	}
+	if err := CheckCipherSuite(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256); err != nil {
+		t.Fatalf("AES-GCM suite should be allowed: %s", err)
+	}
+
+	// outside FIPS mode nothing is restricted
+	restricted := []error{
+		CheckRSAKey(short),
+		CheckCipherSuite(tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305),
+		CheckFeature("feature"),
+	}
+	for i, err := range restricted {
+		if (err != nil) != Enabled {
+			t.Fatalf("Check %d: expected restriction %t, got %v", i, Enabled, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+)
+
+// Domain separation of leaf and interior node hashes as in RFC 6962, so a leaf can not be passed off as a node
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleLeaf is a key and its (encrypted) value as stored on the ledger
+type MerkleLeaf struct {
+	Key   string
+	Value []byte
+}
+
+// MerkleTree commits to a set of key/value pairs. Leaves are ordered by key and hashed as in RFC 6962, thus, two
+// parties holding the same state compute the same root.
+type MerkleTree struct {
+	leaves []MerkleLeaf
+	hashes [][]byte
+}
+
+// MerkleProof shows that a key with the given value is the leaf at Index of a tree with Size leaves
+type MerkleProof struct {
+	Key   string `json:"Key"`
+	Value []byte `json:"Value"`
+	Index int    `json:"Index"`
+	Size  int    `json:"Size"`
+	// Path are the sibling hashes from the leaf up to the root
+	Path [][]byte `json:"Path"`
+}
+
+// NewMerkleTree builds the tree over leaves; keys must be unique
+func NewMerkleTree(leaves []MerkleLeaf) (*MerkleTree, error) {
+	sorted := make([]MerkleLeaf, len(leaves))
+	copy(sorted, leaves)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	hashes := make([][]byte, len(sorted))
+	for i, leaf := range sorted {
+		if i > 0 && sorted[i-1].Key == leaf.Key {
+			return nil, fmt.Errorf("duplicate key %s", leaf.Key)
+		}
+		hashes[i] = merkleLeafHash(leaf.Key, leaf.Value)
+	}
+	return &MerkleTree{leaves: sorted, hashes: hashes}, nil
+}
+
+// Size returns the number of leaves
+func (t *MerkleTree) Size() int {
+	return len(t.leaves)
+}
+
+// Root returns the root hash; the root of the empty tree is the hash of the empty string
+func (t *MerkleTree) Root() []byte {
+	if len(t.hashes) == 0 {
+		h := sha256.Sum256(nil)
+		return h[:]
+	}
+	return merkleSubtreeRoot(t.hashes)
+}
+
+// Proof returns the inclusion proof of key
+func (t *MerkleTree) Proof(key string) (*MerkleProof, error) {
+	index := sort.Search(len(t.leaves), func(i int) bool { return t.leaves[i].Key >= key })
+	if index == len(t.leaves) || t.leaves[index].Key != key {
+		return nil, fmt.Errorf("key %s not in tree", key)
+	}
+	return &MerkleProof{
+		Key:   key,
+		Value: t.leaves[index].Value,
+		Index: index,
+		Size:  len(t.leaves),
+		Path:  merklePath(index, t.hashes),
+	}, nil
+}
+
+// Verify checks the proof against root following the audit path verification of RFC 9162
+func (p *MerkleProof) Verify(root []byte) error {
+	if p.Index < 0 || p.Index >= p.Size {
+		return errors.New("leaf index out of range")
+	}
+	fn, sn := p.Index, p.Size-1
+	r := merkleLeafHash(p.Key, p.Value)
+	for _, sibling := range p.Path {
+		if sn == 0 {
+			return errors.New("proof path too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(sibling, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("proof path too short")
+	}
+	if !consttime.Equal(r, root) {
+		return errors.New("root mismatch")
+	}
+	return nil
+}
+
+// merkleLeafHash hashes the length-prefixed key followed by the value
+func merkleLeafHash(key string, value []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafPrefix})
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(key)))
+	h.Write(length)
+	h.Write([]byte(key))
+	h.Write(value)
+	return h.Sum(nil)
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleSplit returns the largest power of two smaller than n, n > 1
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// merkleSubtreeRoot returns the root over the leaf hashes, which must not be empty
+func merkleSubtreeRoot(hashes [][]byte) []byte {
+	if len(hashes) == 1 {
+		return hashes[0]
+	}
+	k := merkleSplit(len(hashes))
+	return merkleNodeHash(merkleSubtreeRoot(hashes[:k]), merkleSubtreeRoot(hashes[k:]))
+}
+
+// merklePath returns the audit path of the leaf at index m
+func merklePath(m int, hashes [][]byte) [][]byte {
+	if len(hashes) == 1 {
+		return nil
+	}
+	k := merkleSplit(len(hashes))
+	if m < k {
+		return append(merklePath(m, hashes[:k]), merkleSubtreeRoot(hashes[k:]))
+	}
+	return append(merklePath(m-k, hashes[k:]), merkleSubtreeRoot(hashes[:k]))
+}
@@ -0,0 +1,99 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func testLeaves(n int) []MerkleLeaf {
+	var leaves []MerkleLeaf
+	// insert in reverse to check that the tree orders leaves by key
+	for i := n - 1; i >= 0; i-- {
+		leaves = append(leaves, MerkleLeaf{Key: fmt.Sprintf("key%02d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+	}
+	return leaves
+}
+
+func TestMerkleTree_Proof(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		tree, err := NewMerkleTree(testLeaves(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := tree.Root()
+		for i := 0; i < n; i++ {
+			proof, err := tree.Proof(fmt.Sprintf("key%02d", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proof.Index != i || proof.Size != n {
+				t.Fatalf("size %d: proof of key%02d at %d/%d", n, i, proof.Index, proof.Size)
+			}
+			if err := proof.Verify(root); err != nil {
+				t.Fatalf("size %d: proof of key%02d rejected: %s", n, i, err)
+			}
+
+			tampered := *proof
+			tampered.Value = []byte("other")
+			if tampered.Verify(root) == nil {
+				t.Fatalf("size %d: proof with tampered value accepted", n)
+			}
+			if n > 1 {
+				tampered = *proof
+				tampered.Index = (i + 1) % n
+				if tampered.Verify(root) == nil {
+					t.Fatalf("size %d: proof with wrong index accepted", n)
+				}
+				tampered = *proof
+				tampered.Path = tampered.Path[1:]
+				if tampered.Verify(root) == nil {
+					t.Fatalf("size %d: proof with truncated path accepted", n)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleTree_Root(t *testing.T) {
+	empty, err := NewMerkleTree(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyRoot := sha256.Sum256(nil)
+	if !bytes.Equal(empty.Root(), emptyRoot[:]) {
+		t.Fatalf("unexpected root of empty tree")
+	}
+	if _, err := empty.Proof("key"); err == nil {
+		t.Fatalf("proof of missing key returned")
+	}
+
+	tree, _ := NewMerkleTree(testLeaves(5))
+	changed := testLeaves(5)
+	changed[2].Value = []byte("other")
+	changedTree, _ := NewMerkleTree(changed)
+	if bytes.Equal(tree.Root(), changedTree.Root()) {
+		t.Fatalf("root does not commit to values")
+	}
+
+	if _, err := NewMerkleTree(append(testLeaves(2), MerkleLeaf{Key: "key01"})); err == nil {
+		t.Fatalf("duplicate key accepted")
+	}
+}
@@ -0,0 +1,139 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// Proxy re-encryption of data encrypted to an enclave with EncryptForEnclave. Such a ciphertext consists of an
+// ephemeral public key E = rG and the data encrypted with a key derived from rA = aE, where a is the secret key of
+// the enclave. To delegate decryption to another enclave with key pair (b, B), the delegator enclave picks an
+// ephemeral key pair (x, X) and creates the re-encryption key
+//
+//   rk = a * d^-1 mod n, where d = SHA256(X || B || x-coordinate of xB) mod n
+//
+// A proxy, e.g., ercc, transforms E into E' = rk * E without learning anything about the data. The delegatee
+// computes d from X and bX = xB, and recovers the key from d * E' = aE. The data itself is never re-encrypted.
+// Without b, rk does not reveal a, so a proxy can not decrypt; a delegatee colluding with the proxy could recover
+// a, which is why delegatees must be attested enclaves approved by governance.
+
+// NewReEncryptionKey creates a re-encryption key from the enclave with secret key delegatorSk to the enclave with
+// public key delegateePk (sgx format). It returns the delegation public key (sgx format) and the key (big endian).
+// Enclaves create re-encryption keys inside the enclave; this is the reference implementation.
+func NewReEncryptionKey(delegatorSk *ecdsa.PrivateKey, delegateePk []byte) ([]byte, []byte, error) {
+	delegateePub, err := EnclavePk2ECDSAPK(delegateePk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	xPriv, xPub, err := GenKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	delegationPk := MarshalSgxPk(xPub)
+
+	sharedX, _ := delegateePub.Curve.ScalarMult(delegateePub.X, delegateePub.Y, xPriv.D.Bytes())
+	d, err := delegationScalar(delegationPk, delegateePk, sharedX)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n := elliptic.P256().Params().N
+	rk := new(big.Int).ModInverse(d, n)
+	rk.Mul(rk, delegatorSk.D)
+	rk.Mod(rk, n)
+
+	return delegationPk, padScalar(rk), nil
+}
+
+// ReEncrypt transforms the ephemeral public key (sgx format) of a ciphertext encrypted to the delegator into one
+// the delegatee can decrypt with. The ciphertext itself remains unchanged.
+func ReEncrypt(key, ephemeralPk []byte) ([]byte, error) {
+	rk, err := parseScalar(key)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPub, err := EnclavePk2ECDSAPK(ephemeralPk)
+	if err != nil {
+		return nil, err
+	}
+
+	x, y := ephemeralPub.Curve.ScalarMult(ephemeralPub.X, ephemeralPub.Y, padScalar(rk))
+	return MarshalSgxPk(&ecdsa.PublicKey{Curve: ephemeralPub.Curve, X: x, Y: y}), nil
+}
+
+// DecryptReEncrypted decrypts a ciphertext re-encrypted for the delegatee with secret key delegateeSk, given the
+// delegation public key of the re-encryption key and the re-encrypted ephemeral public key (both sgx format).
+// This is what the delegatee enclave does; it is the reference implementation.
+func DecryptReEncrypted(delegateeSk *ecdsa.PrivateKey, delegationPk, reEncryptedPk, ciphertext []byte) ([]byte, error) {
+	delegationPub, err := EnclavePk2ECDSAPK(delegationPk)
+	if err != nil {
+		return nil, err
+	}
+	reEncryptedPub, err := EnclavePk2ECDSAPK(reEncryptedPk)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedX, _ := delegationPub.Curve.ScalarMult(delegationPub.X, delegationPub.Y, delegateeSk.D.Bytes())
+	d, err := delegationScalar(delegationPk, MarshalSgxPk(&delegateeSk.PublicKey), sharedX)
+	if err != nil {
+		return nil, err
+	}
+
+	x, _ := reEncryptedPub.Curve.ScalarMult(reEncryptedPub.X, reEncryptedPub.Y, padScalar(d))
+	return Decrypt(ciphertext, sharedKey(x))
+}
+
+// delegationScalar returns d = SHA256(delegationPk || delegateePk || sharedX) mod n, with sharedX padded to 32 bytes
+func delegationScalar(delegationPk, delegateePk []byte, sharedX *big.Int) (*big.Int, error) {
+	h := sha256.New()
+	h.Write(delegationPk)
+	h.Write(delegateePk)
+	h.Write(padScalar(sharedX))
+
+	d := new(big.Int).SetBytes(h.Sum(nil))
+	d.Mod(d, elliptic.P256().Params().N)
+	if d.Sign() == 0 {
+		return nil, errors.New("Invalid delegation key")
+	}
+	return d, nil
+}
+
+// parseScalar parses a big endian scalar in [1, n-1]
+func parseScalar(raw []byte) (*big.Int, error) {
+	if len(raw) != 32 {
+		return nil, errors.New("Invalid scalar size")
+	}
+	k := new(big.Int).SetBytes(raw)
+	if k.Sign() == 0 || k.Cmp(elliptic.P256().Params().N) >= 0 {
+		return nil, errors.New("Scalar out of range")
+	}
+	return k, nil
+}
+
+// padScalar returns k in big endian padded to 32 bytes
+func padScalar(k *big.Int) []byte {
+	out := make([]byte, 32)
+	copy(out[32-len(k.Bytes()):], k.Bytes())
+	return out
+}
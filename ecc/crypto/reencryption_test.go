@@ -0,0 +1,68 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+)
+
+func TestReEncryption(t *testing.T) {
+	delegatorPriv, delegatorPub, _ := GenKeyPair()
+	delegateePriv, delegateePub, _ := GenKeyPair()
+	delegatorPk, _ := x509.MarshalPKIXPublicKey(delegatorPub)
+
+	secret := []byte("data of chaincode A")
+	ephemeralPk, ciphertext, err := EncryptForEnclave(secret, delegatorPk)
+	if err != nil {
+		t.Fatalf("EncryptForEnclave returned error: %s", err)
+	}
+
+	delegationPk, key, err := NewReEncryptionKey(delegatorPriv, MarshalSgxPk(delegateePub))
+	if err != nil {
+		t.Fatalf("Can not create re-encryption key: %s", err)
+	}
+	reEncryptedPk, err := ReEncrypt(key, ephemeralPk)
+	if err != nil {
+		t.Fatalf("ReEncrypt returned error: %s", err)
+	}
+
+	plaintext, err := DecryptReEncrypted(delegateePriv, delegationPk, reEncryptedPk, ciphertext)
+	if err != nil {
+		t.Fatalf("Delegatee can not decrypt: %s", err)
+	}
+	if !bytes.Equal(plaintext, secret) {
+		t.Fatalf("Delegatee decrypted %s instead of %s", plaintext, secret)
+	}
+
+	// without re-encryption, or for another enclave, the ciphertext does not decrypt
+	if _, err := DecryptReEncrypted(delegateePriv, delegationPk, ephemeralPk, ciphertext); err == nil {
+		t.Fatalf("Ciphertext should not decrypt without re-encryption")
+	}
+	otherPriv, _, _ := GenKeyPair()
+	if _, err := DecryptReEncrypted(otherPriv, delegationPk, reEncryptedPk, ciphertext); err == nil {
+		t.Fatalf("Ciphertext should only decrypt for the delegatee")
+	}
+
+	if _, err := ReEncrypt(make([]byte, 32), ephemeralPk); err == nil {
+		t.Fatalf("Zero re-encryption key should be rejected")
+	}
+	if _, err := ReEncrypt(key, make([]byte, 64)); err == nil {
+		t.Fatalf("Ephemeral pk not on the curve should be rejected")
+	}
+}
@@ -19,8 +19,10 @@ package enclave
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"unsafe"
 
@@ -29,7 +31,7 @@ import (
 	"github.com/hyperledger/fabric/common/flogging"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
-	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/tlcc"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/internal/tlcc"
 	sgx_utils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
 )
 
@@ -57,8 +59,22 @@ const SIGNATURE_SIZE = 64
 const PUB_KEY_SIZE = 64
 const TARGET_INFO_SIZE = 512
 const CMAC_SIZE = 16
+const STATE_KEY_CIPHER_SIZE = 12 + 16 + 16
+const ESCROW_SHARE_CIPHER_SIZE = 12 + 16 + 1 + 16
+const ESCROW_CHECK_SIZE = 32
+const REENCRYPTION_KEY_SIZE = 32
+const REPORT_DATA_BINDING_SIZE = 32
+const MAX_SEALED_STATE_SIZE = 2 * 1024 * 1024
+const MAX_AUDIT_RECORDS_SIZE = 64 * 1024
 const ENCLAVE_TCS_NUM = 8
 
+// FPC_ERROR_BASE marks the codes of failed invocations, see errors.h of the enclave
+const FPC_ERROR_BASE = 0xfc00
+
+// Capabilities are the optional protocol features of the enclave built along with this stub, advertised to clients
+// with the enclave pk: "zlib" compression of args and responses (see ecc_enclave/enclave/compress.h)
+var Capabilities = []string{"zlib"}
+
 var logger = flogging.MustGetLogger("ecc_enclave")
 
 // just a container struct used for the callbacks
@@ -85,9 +101,9 @@ func NewRegistry() *Registry {
 
 func (r *Registry) Register(stubs *Stubs) int {
 	r.Lock()
+	defer r.Unlock()
 	r.index++
 	r.internal[r.index] = stubs
-	r.Unlock()
 	return r.index
 }
 
@@ -107,6 +123,35 @@ func (r *Registry) Get(i int) *Stubs {
 	return stubs
 }
 
+// newInvocationContext registers the stubs of an invocation and returns the ctx the enclave passes back to the
+// callbacks, the index of the stubs in C memory, so that neither the enclave nor sgxcclib holds a Go pointer; release
+// frees it once the invocation returned
+func newInvocationContext(stubs *Stubs) (ctx unsafe.Pointer, release func()) {
+	index := registry.Register(stubs)
+	ctx = C.malloc(C.size_t(unsafe.Sizeof(C.int(0))))
+	*(*C.int)(ctx) = C.int(index)
+	return ctx, func() {
+		registry.Release(index)
+		C.free(ctx)
+	}
+}
+
+// copyOut copies data to a buffer of the enclave
+func copyOut(target *C.uint8_t, data []byte) {
+	if len(data) > 0 {
+		C._cpy_bytes(target, (*C.uint8_t)(unsafe.Pointer(&data[0])), C.uint32_t(len(data)))
+	}
+}
+
+// copyCMAC copies a cmac of tlcc to the enclave; the enclave rejects what comes with a malformed one
+func copyCMAC(target *C.uint8_t, cmac []byte) {
+	if len(cmac) != CMAC_SIZE {
+		logger.Errorf("Invalid cmac of %d bytes", len(cmac))
+		return
+	}
+	copyOut(target, cmac)
+}
+
 //export golog
 func golog(str *C.char) {
 	logger.Infof("%s", C.GoString(str))
@@ -118,7 +163,7 @@ var _logger = func(in string) {
 
 //export get_state
 func get_state(key *C.char, val *C.uint8_t, max_val_len C.uint32_t, val_len *C.uint32_t, cmac *C.uint8_t, ctx unsafe.Pointer) {
-	stubs := registry.Get(*(*int)(ctx))
+	stubs := registry.Get(int(*(*C.int)(ctx)))
 
 	// check if composite key
 	key_str := C.GoString(key)
@@ -130,7 +175,12 @@ func get_state(key *C.char, val *C.uint8_t, max_val_len C.uint32_t, val_len *C.u
 	if err != nil {
 		panic("error while getting state")
 	}
-	C._cpy_bytes(val, (*C.uint8_t)(C.CBytes(data)), C.uint32_t(len(data)))
+	if len(data) > int(max_val_len) {
+		// the enclave limits state it reads, see limits.h; reading nothing fails the verification
+		logger.Errorf("State of %s exceeds %d bytes", key_str, max_val_len)
+		data = nil
+	}
+	copyOut(val, data)
 	C._set_int(val_len, C.uint32_t(len(data)))
 
 	// ask tlcc for verification
@@ -139,12 +189,12 @@ func get_state(key *C.char, val *C.uint8_t, max_val_len C.uint32_t, val_len *C.u
 	if err != nil {
 		panic("error while getting cmac: " + err.Error())
 	}
-	C._cpy_bytes(cmac, (*C.uint8_t)(C.CBytes(genCMAC)), C.uint32_t(CMAC_SIZE))
+	copyCMAC(cmac, genCMAC)
 }
 
 //export put_state
 func put_state(key *C.char, val unsafe.Pointer, val_len C.int, ctx unsafe.Pointer) {
-	stubs := registry.Get(*(*int)(ctx))
+	stubs := registry.Get(int(*(*C.int)(ctx)))
 
 	// check if composite key
 	key_str := C.GoString(key)
@@ -159,7 +209,7 @@ func put_state(key *C.char, val unsafe.Pointer, val_len C.int, ctx unsafe.Pointe
 
 //export get_state_by_partial_composite_key
 func get_state_by_partial_composite_key(comp_key *C.char, values *C.uint8_t, max_vales_len C.uint32_t, values_len *C.uint32_t, cmac *C.uint8_t, ctx unsafe.Pointer) {
-	stubs := registry.Get(*(*int)(ctx))
+	stubs := registry.Get(int(*(*C.int)(ctx)))
 
 	// split and get a proper composite key
 	comp := sgx_utils.SplitSGXCompositeKey(C.GoString(comp_key), sgx_utils.SEP)
@@ -188,8 +238,12 @@ func get_state_by_partial_composite_key(comp_key *C.char, values *C.uint8_t, max
 	}
 	buf.WriteString("]")
 	data := buf.Bytes()
+	if len(data) > int(max_vales_len) {
+		logger.Errorf("Values of %s exceed %d bytes", C.GoString(comp_key), max_vales_len)
+		data = []byte("[]")
+	}
 
-	C._cpy_bytes(values, (*C.uint8_t)(C.CBytes(data)), C.uint32_t(len(data)))
+	copyOut(values, data)
 	C._set_int(values_len, C.uint32_t(len(data)))
 
 	// ask tlcc for verification
@@ -197,13 +251,66 @@ func get_state_by_partial_composite_key(comp_key *C.char, values *C.uint8_t, max
 	if err != nil {
 		panic("error while getting cmac: " + err.Error())
 	}
-	C._cpy_bytes(cmac, (*C.uint8_t)(C.CBytes(genCMAC)), C.uint32_t(CMAC_SIZE))
+	copyCMAC(cmac, genCMAC)
+}
+
+//export get_msp_roots
+func get_msp_roots(msp_id *C.char, roots *C.uint8_t, max_roots_len C.uint32_t, roots_len *C.uint32_t, cmac *C.uint8_t, ctx unsafe.Pointer) {
+	stubs := registry.Get(int(*(*C.int)(ctx)))
+
+	// ask tlcc for the CA certificates of the msp; the enclave verifies them with the cmac
+	// TODO note that TLCC is currently hardcoded
+	data, genCMAC, err := stubs.tlccStub.GetMSPRoots(stubs.shimStub, "tlcc", stubs.shimStub.GetChannelID(), C.GoString(msp_id), nil)
+	if err != nil || len(data) > int(max_roots_len) {
+		// the enclave rejects a creator of an msp without roots
+		logger.Errorf("Can not get roots of MSP %s: %v", C.GoString(msp_id), err)
+		C._set_int(roots_len, C.uint32_t(0))
+		return
+	}
+	copyOut(roots, data)
+	C._set_int(roots_len, C.uint32_t(len(data)))
+	copyCMAC(cmac, genCMAC)
+}
+
+//export get_channel_msps
+func get_channel_msps(config *C.uint8_t, max_config_len C.uint32_t, config_len *C.uint32_t, cmac *C.uint8_t, ctx unsafe.Pointer) {
+	stubs := registry.Get(int(*(*C.int)(ctx)))
+
+	// ask tlcc for the channel id and the MSPs of the channel; the enclave verifies them with the cmac
+	// TODO note that TLCC is currently hardcoded
+	data, genCMAC, err := stubs.tlccStub.GetChannelMSPs(stubs.shimStub, "tlcc", stubs.shimStub.GetChannelID(), nil)
+	if err != nil || len(data) > int(max_config_len) {
+		// the enclave rejects approvals without the MSPs of the channel
+		logger.Errorf("Can not get channel MSPs: %v", err)
+		C._set_int(config_len, C.uint32_t(0))
+		return
+	}
+	copyOut(config, data)
+	C._set_int(config_len, C.uint32_t(len(data)))
+	copyCMAC(cmac, genCMAC)
+}
+
+//export get_ledger_time
+func get_ledger_time(nonce *C.uint8_t, height *C.uint64_t, ledger_time *C.int64_t, cmac *C.uint8_t, ctx unsafe.Pointer) {
+	stubs := registry.Get(int(*(*C.int)(ctx)))
+
+	// ask tlcc for the ledger time; the enclave verifies it with the cmac over its nonce
+	// TODO note that TLCC is currently hardcoded
+	h, t, genCMAC, err := stubs.tlccStub.GetLedgerTime(stubs.shimStub, "tlcc", stubs.shimStub.GetChannelID(), C.GoBytes(unsafe.Pointer(nonce), 32))
+	if err != nil {
+		// the enclave rejects the time without a valid cmac
+		logger.Errorf("Can not get ledger time: %v", err)
+		return
+	}
+	*height = C.uint64_t(h)
+	*ledger_time = C.int64_t(t)
+	copyCMAC(cmac, genCMAC)
 }
 
 // Stub interface
 type Stub interface {
 	// Return quote and enclave PK in DER-encoded PKIX format
-	GetRemoteAttestationReport(spid []byte) ([]byte, []byte, error)
+	GetRemoteAttestationReport(spid []byte, binding []byte) ([]byte, []byte, error)
 	// Return report and enclave PK in DER-encoded PKIX format
 	GetLocalAttestationReport(targetInfo []byte) ([]byte, []byte, error)
 	// Invoke chaincode
@@ -216,6 +323,39 @@ type Stub interface {
 	GetTargetInfo() ([]byte, error)
 	// Bind to tlcc
 	Bind(report, pk []byte) error
+	// Provision secret encrypted to the enclave pk; ephemeral pk in sgx format. The enclave verifies the approval
+	// (JSON) of the provisioner against the MSP roots from tlcc.
+	ProvisionSecret(name string, ephemeralPk, ciphertext, approval []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) error
+	// creates a re-encryption key to the delegatee enclave of an attestation report, provided the delegation
+	// approval is valid; returns delegation pk and key
+	CreateReEncryptionKey(chaincodeID string, delegateeReport, approvals []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) ([]byte, []byte, error)
+	// passes a secret, re-encrypted for the enclave via ercc, to the enclave
+	ProvisionDelegatedSecret(name string, delegationPk, reEncryptedPk, ciphertext, approval []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) error
+	// Export state key to the successor enclave of an attestation report, provided a quorum of the channel MSPs
+	// approved the upgrade of the chaincode; returns ephemeral pk (sgx format), ciphertext and the signature of the
+	// enclave over the handover
+	ExportStateKey(chaincodeID string, successorReport, approvals []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) ([]byte, []byte, []byte, error)
+	// Endorse the enclave of an attestation report, running the same code, as successor of the enclave key;
+	// returns the signature of the enclave over the successor key
+	EndorseKey(successorReport []byte) ([]byte, error)
+	// Sign the renewal of the enclave's registration with a fresh quote of the enclave in transaction txID
+	SignRenewal(txID string, quote []byte) ([]byte, error)
+	// Import state key handed over by the predecessor enclave of an attestation report; ephemeral pk in sgx format
+	ImportStateKey(chaincodeID string, predecessorReport, approvals, ephemeralPk, ciphertext, signature []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) error
+	// Split the state key into shares encrypted to recovery party PKs in sgx format, threshold of which recover
+	// it, provided the approval of the escrow policy is valid; returns one ephemeral pk and ciphertext per party, the
+	// check value of the state key and the signature of the enclave over the escrow
+	EscrowStateKey(chaincodeID string, threshold int, partyMSPIDs []string, partyPks [][]byte, approvals []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) ([][]byte, [][]byte, []byte, []byte, error)
+	// Recover the state key from escrow shares re-encrypted to the enclave; ephemeral pks in sgx format. The check
+	// value must be signed by the escrowing enclave of the attestation report under an approved escrow policy.
+	RecoverStateKey(escrowReport []byte, chaincodeID string, threshold int, partyMSPIDs []string, partyPks [][]byte, approvals, check, signature []byte, ephemeralPks, ciphertexts [][]byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) error
+	// Seal enclave identity, state key and provisioned secrets; returns the sealed state
+	SealState() ([]byte, error)
+	// Restore a state sealed by SealState of this or a predecessor enclave; returns true if the enclave
+	// resumed with the sealed identity, false if it only migrated state key and secrets
+	UnsealState(sealed []byte) (bool, error)
+	// Hands out the audit records of operations outside of invocations, e.g., provisioning a secret, as JSON
+	AuditRecords() ([][]byte, error)
 	// Destroys enclave
 	Destroy() error
 }
@@ -223,7 +363,20 @@ type Stub interface {
 // StubImpl implements the interface
 type StubImpl struct {
 	eid C.enclave_id_t
+	// sem bounds the concurrent ecalls by the TCS of the enclave; Create and Destroy, and sealing, take all permits
 	sem *semaphore.Weighted
+	// alive is set between Create and Destroy; read with a permit of sem and written with all of them
+	alive bool
+}
+
+// acquire takes n permits of sem for an ecall; fails if the enclave has not been created or is destroyed
+func (e *StubImpl) acquire(n int64) error {
+	e.sem.Acquire(context.Background(), n)
+	if !e.alive {
+		e.sem.Release(n)
+		return errors.New("No enclave; create it first")
+	}
+	return nil
 }
 
 // NewEnclave starts a new enclave
@@ -231,9 +384,13 @@ func NewEnclave() Stub {
 	return &StubImpl{sem: semaphore.NewWeighted(ENCLAVE_TCS_NUM)}
 }
 
-// GetRemoteAttestationReport - calls the enclave for attestation, takes SPID as input
-// and returns a quote and enclaves public key
-func (e *StubImpl) GetRemoteAttestationReport(spid []byte) ([]byte, []byte, error) {
+// GetRemoteAttestationReport - calls the enclave for attestation, takes SPID and binding (32 bytes or nil,
+// see attestation.ReportDataBinding) as input and returns a quote and enclaves public key
+func (e *StubImpl) GetRemoteAttestationReport(spid []byte, binding []byte) ([]byte, []byte, error) {
+	if binding != nil && len(binding) != REPORT_DATA_BINDING_SIZE {
+		return nil, nil, fmt.Errorf("binding must be %d bytes", REPORT_DATA_BINDING_SIZE)
+	}
+
 	// quote
 	quote_size := C.sgxcc_get_quote_size()
 	quotePtr := C.malloc(C.ulong(quote_size))
@@ -247,11 +404,20 @@ func (e *StubImpl) GetRemoteAttestationReport(spid []byte) ([]byte, []byte, erro
 	spidPtr := C.CBytes(spid)
 	defer C.free(spidPtr)
 
-	e.sem.Acquire(context.Background(), 1)
+	// binding
+	var bindingPtr unsafe.Pointer
+	if binding != nil {
+		bindingPtr = C.CBytes(binding)
+		defer C.free(bindingPtr)
+	}
+
+	if err := e.acquire(1); err != nil {
+		return nil, nil, err
+	}
 	// call enclave
 	// TODO read error
 	C.sgxcc_get_remote_attestation_report(e.eid, (*C.quote_t)(quotePtr), quote_size,
-		(*C.ec256_public_t)(pubkeyPtr), (*C.spid_t)(spidPtr))
+		(*C.ec256_public_t)(pubkeyPtr), (*C.spid_t)(spidPtr), (*C.uint8_t)(bindingPtr))
 
 	e.sem.Release(1)
 
@@ -278,11 +444,8 @@ func (e *StubImpl) Invoke(args []byte, pk []byte, shimStub shim.ChaincodeStubInt
 		return nil, nil, errors.New("Need shim")
 	}
 
-	// index := Register(Stubs{shimStub, tlccStub})
-	index := registry.Register(&Stubs{shimStub, tlccStub})
-	defer registry.Release(index)
-	// defer Release(index)
-	ctx := unsafe.Pointer(&index)
+	ctx, release := newInvocationContext(&Stubs{shimStub, tlccStub})
+	defer release()
 
 	// args
 	argsPtr := C.CString(string(args))
@@ -301,7 +464,9 @@ func (e *StubImpl) Invoke(args []byte, pk []byte, shimStub shim.ChaincodeStubInt
 	signaturePtr := C.malloc(SIGNATURE_SIZE)
 	defer C.free(signaturePtr)
 
-	e.sem.Acquire(context.Background(), 1)
+	if err := e.acquire(1); err != nil {
+		return nil, nil, err
+	}
 	// invoke enclave
 	// TODO read error
 	ret := C.sgxcc_invoke(e.eid,
@@ -311,6 +476,13 @@ func (e *StubImpl) Invoke(args []byte, pk []byte, shimStub shim.ChaincodeStubInt
 		(*C.ec256_signature_t)(signaturePtr),
 		ctx)
 	e.sem.Release(1)
+	if int(ret)&^0xff == FPC_ERROR_BASE {
+		// the response of a failed invocation is the error encrypted to the client, if any
+		return nil, nil, &sgx_utils.ErrorResponse{
+			Code:    int(ret) & 0xff,
+			Details: C.GoBytes(responsePtr, C.int(responseLenOut)),
+		}
+	}
 	if ret != 0 {
 		return nil, nil, fmt.Errorf("Invoke failed. Reason: %d", int(ret))
 	}
@@ -328,7 +500,9 @@ func (e *StubImpl) GetPublicKey() ([]byte, error) {
 	pubkeyPtr := C.malloc(PUB_KEY_SIZE)
 	defer C.free(pubkeyPtr)
 
-	e.sem.Acquire(context.Background(), 1)
+	if err := e.acquire(1); err != nil {
+		return nil, err
+	}
 	// call enclave
 	// TODO read error
 	C.sgxcc_get_pk(e.eid, (*C.ec256_public_t)(pubkeyPtr))
@@ -341,13 +515,20 @@ func (e *StubImpl) GetPublicKey() ([]byte, error) {
 // Create starts a new enclave instance
 func (e *StubImpl) Create(enclaveLibFile string) error {
 	var eid C.enclave_id_t
-	// todo read error
-	e.sem.Acquire(context.Background(), 1)
-	if ret := C.sgxcc_create_enclave(&eid, C.CString(enclaveLibFile)); ret != 0 {
+	libFilePtr := C.CString(enclaveLibFile)
+	defer C.free(unsafe.Pointer(libFilePtr))
+
+	// no ecall may run while the enclave changes
+	e.sem.Acquire(context.Background(), ENCLAVE_TCS_NUM)
+	defer e.sem.Release(ENCLAVE_TCS_NUM)
+	if e.alive {
+		return errors.New("Enclave exists already")
+	}
+	if ret := C.sgxcc_create_enclave(&eid, libFilePtr); ret != 0 {
 		return fmt.Errorf("Can not create enclave: Reason: %d", ret)
 	}
 	e.eid = eid
-	e.sem.Release(1)
+	e.alive = true
 	logger.Infof("Enclave created with %d", e.eid)
 	return nil
 }
@@ -356,7 +537,9 @@ func (e *StubImpl) GetTargetInfo() ([]byte, error) {
 	targetInfoPtr := C.malloc(TARGET_INFO_SIZE)
 	defer C.free(targetInfoPtr)
 
-	e.sem.Acquire(context.Background(), 1)
+	if err := e.acquire(1); err != nil {
+		return nil, err
+	}
 	C.sgxcc_get_target_info(e.eid, (*C.target_info_t)(targetInfoPtr))
 	e.sem.Release(1)
 
@@ -368,7 +551,9 @@ func (e *StubImpl) Bind(report, pk []byte) error {
 	// here we set the report and pk pointer to NULL if not provided
 	if report == nil || pk == nil {
 		logger.Infof("No report pk provided! Call bind with NULL")
-		e.sem.Acquire(context.Background(), 1)
+		if err := e.acquire(1); err != nil {
+			return err
+		}
 		C.sgxcc_bind(e.eid, (*C.report_t)(nil), (*C.ec256_public_t)(nil))
 		e.sem.Release(1)
 		return nil
@@ -385,14 +570,519 @@ func (e *StubImpl) Bind(report, pk []byte) error {
 	pkPtr := C.CBytes(transPk)
 	defer C.free(pkPtr)
 
-	e.sem.Acquire(context.Background(), 1)
+	if err := e.acquire(1); err != nil {
+		return err
+	}
 	C.sgxcc_bind(e.eid, (*C.report_t)(reportPtr), (*C.ec256_public_t)(pkPtr))
 	e.sem.Release(1)
 	return nil
 }
 
+// ProvisionSecret passes a secret, provisioned via ercc, to the enclave which decrypts and keeps it
+func (e *StubImpl) ProvisionSecret(name string, ephemeralPk, ciphertext, approval []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) error {
+	if len(ephemeralPk) != PUB_KEY_SIZE {
+		return fmt.Errorf("Invalid ephemeral pk size: %d", len(ephemeralPk))
+	}
+
+	ctx, release := newInvocationContext(&Stubs{shimStub, tlccStub})
+	defer release()
+
+	namePtr := C.CString(name)
+	defer C.free(unsafe.Pointer(namePtr))
+
+	pkPtr := C.CBytes(ephemeralPk)
+	defer C.free(pkPtr)
+
+	cipherPtr := C.CBytes(ciphertext)
+	defer C.free(cipherPtr)
+
+	approvalPtr := C.CString(string(approval))
+	defer C.free(unsafe.Pointer(approvalPtr))
+
+	if err := e.acquire(1); err != nil {
+		return err
+	}
+	ret := C.sgxcc_provision_secret(e.eid, namePtr, (*C.ec256_public_t)(pkPtr), (*C.uint8_t)(cipherPtr), C.uint32_t(len(ciphertext)), approvalPtr, ctx)
+	e.sem.Release(1)
+	if ret != 0 {
+		return fmt.Errorf("Provision secret failed. Reason: %d", int(ret))
+	}
+	return nil
+}
+
+// CreateReEncryptionKey returns a re-encryption key from the enclave to a delegatee enclave, see
+// crypto.NewReEncryptionKey, i.e., the delegation pk and the key. The enclave verifies the attestation report
+// (JSON) of the delegatee and the delegation approvals (JSON list) for chaincode chaincodeID itself, as the key
+// reveals its own key to the holder of the delegatee key.
+func (e *StubImpl) CreateReEncryptionKey(chaincodeID string, delegateeReport, approvals []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) ([]byte, []byte, error) {
+	ctx, release := newInvocationContext(&Stubs{shimStub, tlccStub})
+	defer release()
+
+	chaincodeIDPtr := C.CString(chaincodeID)
+	defer C.free(unsafe.Pointer(chaincodeIDPtr))
+
+	reportPtr := C.CString(string(delegateeReport))
+	defer C.free(unsafe.Pointer(reportPtr))
+
+	approvalPtr := C.CString(string(approvals))
+	defer C.free(unsafe.Pointer(approvalPtr))
+
+	delegationPkPtr := C.malloc(PUB_KEY_SIZE)
+	defer C.free(delegationPkPtr)
+
+	keyPtr := C.malloc(REENCRYPTION_KEY_SIZE)
+	defer C.free(keyPtr)
+
+	if err := e.acquire(1); err != nil {
+		return nil, nil, err
+	}
+	ret := C.sgxcc_create_reencryption_key(e.eid, chaincodeIDPtr, reportPtr, approvalPtr, (*C.ec256_public_t)(delegationPkPtr), (*C.uint8_t)(keyPtr), ctx)
+	e.sem.Release(1)
+	if ret != 0 {
+		return nil, nil, fmt.Errorf("Create re-encryption key failed. Reason: %d", int(ret))
+	}
+
+	return C.GoBytes(delegationPkPtr, C.int(PUB_KEY_SIZE)), C.GoBytes(keyPtr, C.int(REENCRYPTION_KEY_SIZE)), nil
+}
+
+// ProvisionDelegatedSecret passes a secret, re-encrypted for the enclave via ercc, to the enclave which decrypts
+// and keeps it like a secret provisioned with ProvisionSecret
+func (e *StubImpl) ProvisionDelegatedSecret(name string, delegationPk, reEncryptedPk, ciphertext, approval []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) error {
+	if len(delegationPk) != PUB_KEY_SIZE {
+		return fmt.Errorf("Invalid delegation pk size: %d", len(delegationPk))
+	}
+	if len(reEncryptedPk) != PUB_KEY_SIZE {
+		return fmt.Errorf("Invalid re-encrypted pk size: %d", len(reEncryptedPk))
+	}
+
+	ctx, release := newInvocationContext(&Stubs{shimStub, tlccStub})
+	defer release()
+
+	namePtr := C.CString(name)
+	defer C.free(unsafe.Pointer(namePtr))
+
+	delegationPkPtr := C.CBytes(delegationPk)
+	defer C.free(delegationPkPtr)
+
+	reEncryptedPkPtr := C.CBytes(reEncryptedPk)
+	defer C.free(reEncryptedPkPtr)
+
+	cipherPtr := C.CBytes(ciphertext)
+	defer C.free(cipherPtr)
+
+	approvalPtr := C.CString(string(approval))
+	defer C.free(unsafe.Pointer(approvalPtr))
+
+	if err := e.acquire(1); err != nil {
+		return err
+	}
+	ret := C.sgxcc_provision_delegated_secret(e.eid, namePtr, (*C.ec256_public_t)(delegationPkPtr), (*C.ec256_public_t)(reEncryptedPkPtr), (*C.uint8_t)(cipherPtr), C.uint32_t(len(ciphertext)), approvalPtr, ctx)
+	e.sem.Release(1)
+	if ret != 0 {
+		return fmt.Errorf("Provision delegated secret failed. Reason: %d", int(ret))
+	}
+	return nil
+}
+
+// ExportStateKey returns the state encryption key of the enclave encrypted to a successor enclave, i.e., the
+// ephemeral pk and the ciphertext, and the signature of the enclave over the handover (see
+// registry.HandoverMessage). The enclave verifies the attestation report (JSON) of the successor and the upgrade
+// approvals (JSON list) of the chaincode by a quorum of the channel MSPs itself.
+func (e *StubImpl) ExportStateKey(chaincodeID string, successorReport, approvals []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) ([]byte, []byte, []byte, error) {
+	ctx, release := newInvocationContext(&Stubs{shimStub, tlccStub})
+	defer release()
+
+	chaincodeIDPtr := C.CString(chaincodeID)
+	defer C.free(unsafe.Pointer(chaincodeIDPtr))
+
+	reportPtr := C.CString(string(successorReport))
+	defer C.free(unsafe.Pointer(reportPtr))
+
+	approvalsPtr := C.CString(string(approvals))
+	defer C.free(unsafe.Pointer(approvalsPtr))
+
+	ephemeralPkPtr := C.malloc(PUB_KEY_SIZE)
+	defer C.free(ephemeralPkPtr)
+
+	cipherPtr := C.malloc(STATE_KEY_CIPHER_SIZE)
+	defer C.free(cipherPtr)
+
+	signaturePtr := C.malloc(SIGNATURE_SIZE)
+	defer C.free(signaturePtr)
+
+	if err := e.acquire(1); err != nil {
+		return nil, nil, nil, err
+	}
+	ret := C.sgxcc_export_state_key(e.eid, chaincodeIDPtr, reportPtr, approvalsPtr, (*C.ec256_public_t)(ephemeralPkPtr), (*C.uint8_t)(cipherPtr), C.uint32_t(STATE_KEY_CIPHER_SIZE), (*C.uint8_t)(signaturePtr), ctx)
+	e.sem.Release(1)
+	if ret != 0 {
+		return nil, nil, nil, fmt.Errorf("Export state key failed. Reason: %d", int(ret))
+	}
+
+	return C.GoBytes(ephemeralPkPtr, C.int(PUB_KEY_SIZE)), C.GoBytes(cipherPtr, C.int(STATE_KEY_CIPHER_SIZE)), C.GoBytes(signaturePtr, C.int(SIGNATURE_SIZE)), nil
+}
+
+// EndorseKey returns the signature of the enclave over the key of a successor enclave running the same code (see
+// registry.RotationMessage), which ercc requires to rotate the enclave identity to the successor key. The enclave
+// verifies the attestation report (JSON) of the successor itself.
+func (e *StubImpl) EndorseKey(successorReport []byte) ([]byte, error) {
+	reportPtr := C.CString(string(successorReport))
+	defer C.free(unsafe.Pointer(reportPtr))
+
+	signaturePtr := C.malloc(SIGNATURE_SIZE)
+	defer C.free(signaturePtr)
+
+	if err := e.acquire(1); err != nil {
+		return nil, err
+	}
+	ret := C.sgxcc_endorse_key(e.eid, reportPtr, (*C.uint8_t)(signaturePtr))
+	e.sem.Release(1)
+	if ret != 0 {
+		return nil, fmt.Errorf("Endorse key failed. Reason: %d", int(ret))
+	}
+
+	return C.GoBytes(signaturePtr, C.int(SIGNATURE_SIZE)), nil
+}
+
+// SignRenewal returns the signature of the enclave over the renewal of its registration with a fresh quote of
+// itself in transaction txID (see registry.RenewalMessage), which ercc requires to replace the evidence of the
+// enclave
+func (e *StubImpl) SignRenewal(txID string, quote []byte) ([]byte, error) {
+	txIDPtr := C.CString(txID)
+	defer C.free(unsafe.Pointer(txIDPtr))
+
+	quotePtr := C.CBytes(quote)
+	defer C.free(quotePtr)
+
+	signaturePtr := C.malloc(SIGNATURE_SIZE)
+	defer C.free(signaturePtr)
+
+	if err := e.acquire(1); err != nil {
+		return nil, err
+	}
+	ret := C.sgxcc_sign_renewal(e.eid, txIDPtr, (*C.uint8_t)(quotePtr), C.uint32_t(len(quote)), (*C.uint8_t)(signaturePtr))
+	e.sem.Release(1)
+	if ret != 0 {
+		return nil, fmt.Errorf("Sign renewal failed. Reason: %d", int(ret))
+	}
+
+	return C.GoBytes(signaturePtr, C.int(SIGNATURE_SIZE)), nil
+}
+
+// ImportStateKey passes the state key, handed over by a predecessor via ercc, to the enclave. The enclave verifies
+// the attestation report (JSON) of the predecessor, its signature over the handover and the upgrade approvals (JSON
+// list) of the chaincode by a quorum of the channel MSPs.
+func (e *StubImpl) ImportStateKey(chaincodeID string, predecessorReport, approvals, ephemeralPk, ciphertext, signature []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) error {
+	if len(ephemeralPk) != PUB_KEY_SIZE {
+		return fmt.Errorf("Invalid ephemeral pk size: %d", len(ephemeralPk))
+	}
+	if len(signature) != SIGNATURE_SIZE {
+		return fmt.Errorf("Invalid handover signature size: %d", len(signature))
+	}
+
+	ctx, release := newInvocationContext(&Stubs{shimStub, tlccStub})
+	defer release()
+
+	chaincodeIDPtr := C.CString(chaincodeID)
+	defer C.free(unsafe.Pointer(chaincodeIDPtr))
+
+	reportPtr := C.CString(string(predecessorReport))
+	defer C.free(unsafe.Pointer(reportPtr))
+
+	approvalsPtr := C.CString(string(approvals))
+	defer C.free(unsafe.Pointer(approvalsPtr))
+
+	pkPtr := C.CBytes(ephemeralPk)
+	defer C.free(pkPtr)
+
+	cipherPtr := C.CBytes(ciphertext)
+	defer C.free(cipherPtr)
+
+	signaturePtr := C.CBytes(signature)
+	defer C.free(signaturePtr)
+
+	if err := e.acquire(1); err != nil {
+		return err
+	}
+	ret := C.sgxcc_import_state_key(e.eid, chaincodeIDPtr, reportPtr, approvalsPtr, (*C.ec256_public_t)(pkPtr), (*C.uint8_t)(cipherPtr), C.uint32_t(len(ciphertext)), (*C.uint8_t)(signaturePtr), ctx)
+	e.sem.Release(1)
+	if ret != 0 {
+		return fmt.Errorf("Import state key failed. Reason: %d", int(ret))
+	}
+	return nil
+}
+
+// EscrowStateKey returns shares of the state encryption key of the enclave, each encrypted to one recovery party,
+// the check value of the key and the signature of the enclave over the escrow (see registry.EscrowMessage). The
+// enclave verifies the approvals (JSON list) of the escrow policy, i.e., of the threshold and the MSP IDs and pks
+// of the parties, itself.
+func (e *StubImpl) EscrowStateKey(chaincodeID string, threshold int, partyMSPIDs []string, partyPks [][]byte, approvals []byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) ([][]byte, [][]byte, []byte, []byte, error) {
+	pks, err := joinPartyPks(partyPks)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	mspIDs, err := joinPartyMSPIDs(partyMSPIDs, len(partyPks))
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	ctx, release := newInvocationContext(&Stubs{shimStub, tlccStub})
+	defer release()
+
+	chaincodeIDPtr := C.CString(chaincodeID)
+	defer C.free(unsafe.Pointer(chaincodeIDPtr))
+
+	pksPtr := C.CBytes(pks)
+	defer C.free(pksPtr)
+
+	mspIDsPtr := C.CString(mspIDs)
+	defer C.free(unsafe.Pointer(mspIDsPtr))
+
+	approvalPtr := C.CString(string(approvals))
+	defer C.free(unsafe.Pointer(approvalPtr))
+
+	sharesSize := len(partyPks) * (PUB_KEY_SIZE + ESCROW_SHARE_CIPHER_SIZE)
+	sharesPtr := C.malloc(C.size_t(sharesSize))
+	defer C.free(sharesPtr)
+
+	checkPtr := C.malloc(ESCROW_CHECK_SIZE)
+	defer C.free(checkPtr)
+
+	signaturePtr := C.malloc(SIGNATURE_SIZE)
+	defer C.free(signaturePtr)
+
+	if err := e.acquire(1); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	ret := C.sgxcc_escrow_state_key(e.eid, chaincodeIDPtr, C.uint32_t(threshold), C.uint32_t(len(partyPks)), (*C.uint8_t)(pksPtr), C.uint32_t(len(pks)), mspIDsPtr, approvalPtr, (*C.uint8_t)(sharesPtr), C.uint32_t(sharesSize), (*C.uint8_t)(checkPtr), (*C.uint8_t)(signaturePtr), ctx)
+	e.sem.Release(1)
+	if ret != 0 {
+		return nil, nil, nil, nil, fmt.Errorf("Escrow state key failed. Reason: %d", int(ret))
+	}
+
+	// records are ephemeral pk || ciphertext
+	shares := C.GoBytes(sharesPtr, C.int(sharesSize))
+	ephemeralPks := make([][]byte, len(partyPks))
+	ciphertexts := make([][]byte, len(partyPks))
+	for i := range partyPks {
+		record := shares[i*(PUB_KEY_SIZE+ESCROW_SHARE_CIPHER_SIZE):]
+		ephemeralPks[i] = record[:PUB_KEY_SIZE]
+		ciphertexts[i] = record[PUB_KEY_SIZE : PUB_KEY_SIZE+ESCROW_SHARE_CIPHER_SIZE]
+	}
+	return ephemeralPks, ciphertexts, C.GoBytes(checkPtr, C.int(ESCROW_CHECK_SIZE)), C.GoBytes(signaturePtr, C.int(SIGNATURE_SIZE)), nil
+}
+
+// RecoverStateKey passes the escrow shares, re-encrypted to the enclave by the recovery parties, to the enclave,
+// which recovers the state key if they match the check value. The enclave verifies the attestation report (JSON) of
+// the escrowing enclave, its signature over the check value and the approvals (JSON list) of the escrow policy.
+func (e *StubImpl) RecoverStateKey(escrowReport []byte, chaincodeID string, threshold int, partyMSPIDs []string, partyPks [][]byte, approvals, check, signature []byte, ephemeralPks, ciphertexts [][]byte, shimStub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) error {
+	pks, err := joinPartyPks(partyPks)
+	if err != nil {
+		return err
+	}
+	mspIDs, err := joinPartyMSPIDs(partyMSPIDs, len(partyPks))
+	if err != nil {
+		return err
+	}
+	if len(ephemeralPks) == 0 || len(ephemeralPks) != len(ciphertexts) {
+		return errors.New("Invalid number of escrow shares")
+	}
+	if len(check) != ESCROW_CHECK_SIZE {
+		return fmt.Errorf("Invalid check value size: %d", len(check))
+	}
+	if len(signature) != SIGNATURE_SIZE {
+		return fmt.Errorf("Invalid escrow signature size: %d", len(signature))
+	}
+
+	var shares []byte
+	for i := range ephemeralPks {
+		if len(ephemeralPks[i]) != PUB_KEY_SIZE || len(ciphertexts[i]) != ESCROW_SHARE_CIPHER_SIZE {
+			return fmt.Errorf("Invalid escrow share %d", i)
+		}
+		shares = append(shares, ephemeralPks[i]...)
+		shares = append(shares, ciphertexts[i]...)
+	}
+
+	ctx, release := newInvocationContext(&Stubs{shimStub, tlccStub})
+	defer release()
+
+	reportPtr := C.CString(string(escrowReport))
+	defer C.free(unsafe.Pointer(reportPtr))
+
+	chaincodeIDPtr := C.CString(chaincodeID)
+	defer C.free(unsafe.Pointer(chaincodeIDPtr))
+
+	pksPtr := C.CBytes(pks)
+	defer C.free(pksPtr)
+
+	mspIDsPtr := C.CString(mspIDs)
+	defer C.free(unsafe.Pointer(mspIDsPtr))
+
+	approvalPtr := C.CString(string(approvals))
+	defer C.free(unsafe.Pointer(approvalPtr))
+
+	checkPtr := C.CBytes(check)
+	defer C.free(checkPtr)
+
+	signaturePtr := C.CBytes(signature)
+	defer C.free(signaturePtr)
+
+	sharesPtr := C.CBytes(shares)
+	defer C.free(sharesPtr)
+
+	if err := e.acquire(1); err != nil {
+		return err
+	}
+	ret := C.sgxcc_recover_state_key(e.eid, reportPtr, chaincodeIDPtr, C.uint32_t(threshold), C.uint32_t(len(partyPks)), (*C.uint8_t)(pksPtr), C.uint32_t(len(pks)), mspIDsPtr, approvalPtr, (*C.uint8_t)(checkPtr), (*C.uint8_t)(signaturePtr), C.uint32_t(len(ephemeralPks)), (*C.uint8_t)(sharesPtr), C.uint32_t(len(shares)), ctx)
+	e.sem.Release(1)
+	if ret != 0 {
+		return fmt.Errorf("Recover state key failed. Reason: %d", int(ret))
+	}
+	return nil
+}
+
+// joinPartyPks concatenates the pks (sgx format) of the recovery parties of an escrow policy
+func joinPartyPks(partyPks [][]byte) ([]byte, error) {
+	if len(partyPks) == 0 {
+		return nil, errors.New("No recovery parties")
+	}
+	var pks []byte
+	for _, pk := range partyPks {
+		if len(pk) != PUB_KEY_SIZE {
+			return nil, fmt.Errorf("Invalid party pk size: %d", len(pk))
+		}
+		pks = append(pks, pk...)
+	}
+	return pks, nil
+}
+
+// joinPartyMSPIDs returns the MSP IDs of the recovery parties of an escrow policy, each newline terminated, as the
+// enclave hashes them into the policy statement
+func joinPartyMSPIDs(partyMSPIDs []string, parties int) (string, error) {
+	if len(partyMSPIDs) != parties {
+		return "", errors.New("Recovery parties do not match their MSP IDs")
+	}
+	var mspIDs string
+	for _, mspID := range partyMSPIDs {
+		if mspID == "" || strings.Contains(mspID, "\n") {
+			return "", fmt.Errorf("Invalid party MSP ID: %q", mspID)
+		}
+		mspIDs += mspID + "\n"
+	}
+	return mspIDs, nil
+}
+
+// SealState returns the enclave state sealed to the enclave signer. It waits for all running ecalls so that the
+// sealed state includes their changes.
+func (e *StubImpl) SealState() ([]byte, error) {
+	sealedPtr := C.malloc(MAX_SEALED_STATE_SIZE)
+	defer C.free(sealedPtr)
+
+	sealedSize := C.uint32_t(0)
+
+	if err := e.acquire(ENCLAVE_TCS_NUM); err != nil {
+		return nil, err
+	}
+	ret := C.sgxcc_seal_state(e.eid, (*C.uint8_t)(sealedPtr), C.uint32_t(MAX_SEALED_STATE_SIZE), &sealedSize)
+	e.sem.Release(ENCLAVE_TCS_NUM)
+	if ret != 0 {
+		return nil, fmt.Errorf("Seal state failed. Reason: %d", int(ret))
+	}
+
+	return C.GoBytes(sealedPtr, C.int(sealedSize)), nil
+}
+
+// UnsealState restores a state sealed by SealState. A state sealed by this enclave build replaces the enclave
+// identity created by Create; a state sealed by a predecessor build of the same signer and product only hands
+// over the state key and the provisioned secrets, and the enclave keeps its new identity.
+func (e *StubImpl) UnsealState(sealed []byte) (bool, error) {
+	if len(sealed) == 0 || len(sealed) > MAX_SEALED_STATE_SIZE {
+		return false, fmt.Errorf("Invalid sealed state size: %d", len(sealed))
+	}
+
+	sealedPtr := C.CBytes(sealed)
+	defer C.free(sealedPtr)
+
+	identityRestored := C.uint32_t(0)
+
+	if err := e.acquire(ENCLAVE_TCS_NUM); err != nil {
+		return false, err
+	}
+	ret := C.sgxcc_unseal_state(e.eid, (*C.uint8_t)(sealedPtr), C.uint32_t(len(sealed)), &identityRestored)
+	e.sem.Release(ENCLAVE_TCS_NUM)
+	if ret != 0 {
+		return false, fmt.Errorf("Unseal state failed. Reason: %d", int(ret))
+	}
+	return identityRestored != 0, nil
+}
+
+// AuditRecords returns the audit records the enclave queued since the last call, see ecc_enclave/enclave/audit.h.
+// Records of invocations are not queued; the enclave writes them along with the invocation.
+func (e *StubImpl) AuditRecords() ([][]byte, error) {
+	recordsPtr := C.malloc(MAX_AUDIT_RECORDS_SIZE)
+	defer C.free(recordsPtr)
+
+	recordsSize := C.uint32_t(0)
+
+	if err := e.acquire(1); err != nil {
+		return nil, err
+	}
+	ret := C.sgxcc_get_audit_records(e.eid, (*C.uint8_t)(recordsPtr), C.uint32_t(MAX_AUDIT_RECORDS_SIZE), &recordsSize)
+	e.sem.Release(1)
+	if ret != 0 {
+		return nil, fmt.Errorf("Get audit records failed. Reason: %d", int(ret))
+	}
+
+	var records []json.RawMessage
+	if err := json.Unmarshal(C.GoBytes(recordsPtr, C.int(recordsSize)), &records); err != nil {
+		return nil, fmt.Errorf("Invalid audit records: %s", err)
+	}
+	out := make([][]byte, len(records))
+	for i, r := range records {
+		out[i] = r
+	}
+	return out, nil
+}
+
+// CrashRecord is the redacted record of an ecall that failed in itself, e.g., because the enclave aborted: the
+// ecall, the class of the sgx error and when it happened, nothing of the enclave memory
+type CrashRecord struct {
+	Ecall  string `json:"ecall"`
+	Class  string `json:"class"`
+	Status int    `json:"status"`
+	Time   int64  `json:"time"`
+	// Crashes counts the failed ecalls of all enclaves of this process
+	Crashes uint32 `json:"crashes"`
+}
+
+// LastCrash returns the record of the latest ecall of any enclave of this process that failed in itself, nil if none
+// did. sgxcclib logs each of them as it happens.
+func LastCrash() *CrashRecord {
+	var ecall, class *C.char
+	var status C.int
+	var crashTime C.int64_t
+	crashes := C.sgxcc_get_crash_record(&ecall, &class, &status, &crashTime)
+	if crashes == 0 {
+		return nil
+	}
+	return &CrashRecord{
+		Ecall:   C.GoString(ecall),
+		Class:   C.GoString(class),
+		Status:  int(status),
+		Time:    int64(crashTime),
+		Crashes: uint32(crashes),
+	}
+}
+
 // Destroy kills the current enclave instance
 func (e *StubImpl) Destroy() error {
+	// waits for the ecalls in flight; later ones fail instead of calling into a destroyed enclave
+	e.sem.Acquire(context.Background(), ENCLAVE_TCS_NUM)
+	defer e.sem.Release(ENCLAVE_TCS_NUM)
+	if !e.alive {
+		return nil
+	}
+	e.alive = false
 	// todo read error
 	C.sgxcc_destroy_enclave(e.eid)
 	return nil
@@ -22,11 +22,15 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/ercc"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/internal/tlcc"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
 const enclaveLibFile = "lib/enclave.signed.so"
@@ -44,7 +48,7 @@ func TestEnclaveStub_RemoteAttestation(t *testing.T) {
 	mockRegistry := ercc.MockEnclaveRegistryStub{}
 	spid, _ := mockRegistry.GetSPID(nil, "", "")
 
-	quoteAsBytes, pkBytes, err := stub.GetRemoteAttestationReport(spid)
+	quoteAsBytes, pkBytes, err := stub.GetRemoteAttestationReport(spid, nil)
 	if err != nil {
 		t.Fatalf("Attestation returned error %s", err)
 	}
@@ -146,3 +150,126 @@ func TestEnclaveStub_Destroy(t *testing.T) {
 		t.Fatalf("Deate returned error %s", err)
 	}
 }
+
+func TestEnclaveStub_InvokeAfterDestroy(t *testing.T) {
+	stub := NewEnclave()
+	if _, err := stub.GetPublicKey(); err == nil {
+		t.Fatalf("GetPublicKey without enclave should fail")
+	}
+	if err := stub.Create(enclaveLibFile); err != nil {
+		t.Fatalf("Create returned error %s", err)
+	}
+	stub.Bind(nil, nil)
+	if err := stub.Destroy(); err != nil {
+		t.Fatalf("Destroy returned error %s", err)
+	}
+
+	shimStub := shim.NewMockStub("ecc", nil)
+	if _, _, err := stub.Invoke([]byte(`["create","MyAuction"]`), nil, shimStub, &tlcc.MockTLCCStub{}); err == nil {
+		t.Fatalf("Invoke of destroyed enclave should fail")
+	}
+	if err := stub.Destroy(); err != nil {
+		t.Fatalf("Second Destroy returned error %s", err)
+	}
+}
+
+func TestEnclaveStub_ConcurrentInvoke(t *testing.T) {
+	stub := NewEnclave()
+	if err := stub.Create(enclaveLibFile); err != nil {
+		t.Fatalf("Create returned error %s", err)
+	}
+	stub.Bind(nil, nil)
+
+	// more invocations than the enclave has TCS, each with its own shim
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*ENCLAVE_TCS_NUM)
+	for i := 0; i < 4*ENCLAVE_TCS_NUM; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shimStub := shim.NewMockStub("ecc", nil)
+			txID := fmt.Sprintf("tx%d", i)
+			shimStub.MockTransactionStart(txID)
+			defer shimStub.MockTransactionEnd(txID)
+			args := []byte(fmt.Sprintf(`["create","auction%d"]`, i))
+			if _, _, err := stub.Invoke(args, nil, shimStub, &tlcc.MockTLCCStub{}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Concurrent invocation failed: %s", err)
+	}
+	if err := stub.Destroy(); err != nil {
+		t.Fatalf("Destroy returned error %s", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	indexes := make([]int, 100)
+	stubs := make([]*Stubs, len(indexes))
+	for i := range indexes {
+		stubs[i] = &Stubs{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			indexes[i] = r.Register(stubs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	for i, index := range indexes {
+		if seen[index] {
+			t.Fatalf("Index %d handed out twice", index)
+		}
+		seen[index] = true
+		if r.Get(index) != stubs[i] {
+			t.Fatalf("Index %d does not hold its stubs", index)
+		}
+		r.Release(index)
+	}
+}
+
+// the benchmarks below measure the ECALL round-trip including the copying of arguments and responses
+
+func BenchmarkEnclaveStub_GetPublicKey(b *testing.B) {
+	stub := NewEnclave()
+	if err := stub.Create(enclaveLibFile); err != nil {
+		b.Fatalf("Create returned error %s", err)
+	}
+	defer stub.Destroy()
+	stub.Bind(nil, nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := stub.GetPublicKey(); err != nil {
+			b.Fatalf("GetPublicKey returned error %s", err)
+		}
+	}
+}
+
+func BenchmarkEnclaveStub_Invoke(b *testing.B) {
+	stub := NewEnclave()
+	if err := stub.Create(enclaveLibFile); err != nil {
+		b.Fatalf("Create returned error %s", err)
+	}
+	defer stub.Destroy()
+	stub.Bind(nil, nil)
+
+	shimStub := shim.NewMockStub("ecc", nil)
+	shimStub.MockTransactionStart("tx")
+	defer shimStub.MockTransactionEnd("tx")
+	args := []byte(`["create","MyAuction"]`)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := stub.Invoke(args, nil, shimStub, &tlcc.MockTLCCStub{}); err != nil {
+			b.Fatalf("Invoke returned error %s", err)
+		}
+	}
+}
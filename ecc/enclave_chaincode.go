@@ -17,13 +17,24 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/enclave"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/ercc"
-	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/tlcc"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/internal/tlcc"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -32,26 +43,137 @@ import (
 
 const enclaveLibFile = "enclave/lib/enclave.signed.so"
 
+// sealedStateFileEnv names the file the enclave state is sealed to on shutdown and restored from on start; sealing
+// is disabled if unset. The file must be on a volume that survives restarts of the chaincode container.
+const sealedStateFileEnv = "ECC_SEALED_STATE_FILE"
+
+// chaincodeID is the chaincode name if the peer does not set chaincodeIDEnv, e.g., in tests
+const chaincodeID = "ecc"
+
+// chaincodeIDEnv is set by the peer to "<name>:<version>" of the chaincode
+const chaincodeIDEnv = "CORE_CHAINCODE_ID_NAME"
+
+// chaincodeName returns the name the peer started this chaincode with, or chaincodeID if the peer does not set it
+func chaincodeName() string {
+	if name, _ := attestation.ParseChaincodeRef(os.Getenv(chaincodeIDEnv)); name != "" {
+		return name
+	}
+	return chaincodeID
+}
+
+// chaincodeRef returns the chaincode name with the version the peer started this chaincode with, if known, as
+// "<name>:<version>"; both are bound to the quote of the enclave at registration
+func chaincodeRef() string {
+	if _, version := attestation.ParseChaincodeRef(os.Getenv(chaincodeIDEnv)); version != "" {
+		return chaincodeName() + ":" + version
+	}
+	return chaincodeName()
+}
+
 var logger = shim.NewLogger("ecc")
 
+// ProofProvider produces proofs of correct execution, e.g., a zk-SNARK generated by an alternate backend, that
+// clients verify with a client.ProofVerifier of the same type in addition to the enclave signature
+type ProofProvider interface {
+	Type() string
+	// Prove returns the proof that responseData is the correct result of args
+	Prove(args, responseData []byte) ([]byte, error)
+}
+
+// channelEnclave is the enclave serving a single channel
+type channelEnclave struct {
+	enclave.Stub
+	// setupLock serializes setup, restoring the sealed state and shutdown, so that concurrent setups create a
+	// single enclave; it guards restored and ready
+	setupLock sync.Mutex
+	// restored is set if the enclave resumed with the identity of a sealed state
+	restored bool
+	// ready is set once setup registered and bound the enclave
+	ready bool
+	// created is set once the enclave exists; invocations check it concurrently to setup
+	createdLock sync.Mutex
+	created     bool
+}
+
+func (e *channelEnclave) isCreated() bool {
+	e.createdLock.Lock()
+	defer e.createdLock.Unlock()
+	return e.created
+}
+
+func (e *channelEnclave) setCreated(created bool) {
+	e.createdLock.Lock()
+	e.created = created
+	e.createdLock.Unlock()
+}
+
 // EnclaveChaincode struct
 type EnclaveChaincode struct {
 	erccStub ercc.EnclaveRegistryStub
 	tlccStub tlcc.TLCCStub
-	enclave  enclave.Stub
-	verifier crypto.Verifier
+	// newEnclave creates the enclave of a channel. A peer runs one chaincode process for all its channels; each
+	// channel gets its own enclave so that keys and secrets of one channel are never used on another.
+	newEnclave   func() enclave.Stub
+	enclaves     map[string]*channelEnclave
+	enclavesLock sync.Mutex
+	verifier     crypto.Verifier
+	// provers attach additional proofs of correct execution to responses, see AddProofProvider
+	provers []ProofProvider
+	// sealedStateFile is where the enclave states are flushed on shutdown, see sealedStateFileEnv
+	sealedStateFile string
+	shutdownOnce    sync.Once
+	// watchdog refuses to endorse while tlcc lags behind the peer, see maxLedgerLagEnv
+	watchdog *ledgerWatchdog
 }
 
 // NewEcc is a helpful factory method for creating this beauty
 func NewEcc() *EnclaveChaincode {
 	return &EnclaveChaincode{
-		erccStub: &ercc.EnclaveRegistryStubImpl{},
-		tlccStub: &tlcc.TLCCStubImpl{},
-		enclave:  enclave.NewEnclave(),
-		verifier: &crypto.ECDSAVerifier{},
+		erccStub:   &ercc.EnclaveRegistryStubImpl{},
+		tlccStub:   &tlcc.TLCCStubImpl{},
+		newEnclave: enclave.NewEnclave,
+		enclaves:   make(map[string]*channelEnclave),
+		verifier:   &crypto.ECDSAVerifier{},
 	}
 }
 
+// enclaveOf returns the enclave of a channel, which may not have been created yet
+func (t *EnclaveChaincode) enclaveOf(channelID string) *channelEnclave {
+	t.enclavesLock.Lock()
+	defer t.enclavesLock.Unlock()
+
+	e, ok := t.enclaves[channelID]
+	if !ok {
+		e = &channelEnclave{Stub: t.newEnclave()}
+		t.enclaves[channelID] = e
+	}
+	return e
+}
+
+// createdEnclave returns the enclave of the channel of the transaction if it has been created
+func (t *EnclaveChaincode) createdEnclave(stub shim.ChaincodeStubInterface) (enclave.Stub, error) {
+	e := t.enclaveOf(stub.GetChannelID())
+	if !e.isCreated() {
+		return nil, fmt.Errorf("ecc: Enclave not initialized on channel %s! Run setup first!", stub.GetChannelID())
+	}
+	return e, nil
+}
+
+// channels returns the channels of all created enclaves
+func (t *EnclaveChaincode) channels() []string {
+	t.enclavesLock.Lock()
+	defer t.enclavesLock.Unlock()
+
+	var channels []string
+	for channelID, e := range t.enclaves {
+		if e.isCreated() {
+			channels = append(channels, channelID)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
 // Init sets the chaincode state to "init"
 func (t *EnclaveChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	logger.Debugf("ecc: Init chaincode [%s]", enclave.MrEnclave)
@@ -70,6 +192,34 @@ func (t *EnclaveChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		return t.setup(stub)
 	} else if function == "getEnclavePk" { //get Enclave PK
 		return t.getEnclavePk(stub)
+	} else if function == "provisionSecret" { // load secret provisioned at ercc into enclave
+		return t.provisionSecret(stub)
+	} else if function == "delegate" { // create re-encryption key to a delegatee enclave and store it at ercc
+		return t.delegate(stub)
+	} else if function == "provisionDelegatedSecret" { // load secret re-encrypted for our enclave at ercc into enclave
+		return t.provisionDelegatedSecret(stub)
+	} else if function == "handoverState" { // hand over state key to successor enclave
+		return t.handoverState(stub)
+	} else if function == "importState" { // load state key handed over by predecessor enclave
+		return t.importState(stub)
+	} else if function == "rotateKey" { // endorse a successor key of our enclave and rotate to it at ercc
+		return t.rotateKey(stub)
+	} else if function == "renewEnclave" { // replace the evidence of our enclave at ercc with a fresh quote
+		return t.renewEnclave(stub)
+	} else if function == "escrowState" { // escrow shares of the state key to the recovery parties
+		return t.escrowState(stub)
+	} else if function == "recoverState" { // recover the state key from shares of the recovery parties
+		return t.recoverState(stub)
+	} else if function == "commitState" { // publish Merkle root over the chaincode state at ercc
+		return t.commitState(stub)
+	} else if function == "getStateProof" { // get inclusion proof of a key against the current state
+		return t.getStateProof(stub)
+	} else if function == "listChannels" { // list the channels this chaincode runs an enclave for
+		return t.listChannels(stub)
+	} else if function == "getAuditLog" { // get the audit records of decryption and key release operations
+		return t.getAuditLog(stub)
+	} else if function == "getCrashRecord" { // get the redacted record of the latest enclave crash
+		return t.getCrashRecord(stub)
 	} else {
 		return t.invoke(stub)
 	}
@@ -84,41 +234,60 @@ func (t *EnclaveChaincode) setup(stub shim.ChaincodeStubInterface) pb.Response {
 	erccName := args[1]
 	channelName := stub.GetChannelID()
 
-	// check if there is already an enclave
-	if t.enclave == nil {
+	// check if there is already an enclave for this channel
+	e := t.enclaveOf(channelName)
+	e.setupLock.Lock()
+	defer e.setupLock.Unlock()
+	if e.ready {
 		return shim.Error("ecc: Enclave has already been initialized! Destroy first!!")
 	}
 
-	// create new Enclave
-	// TODO we should return error in case there is any :)
-	if err := t.enclave.Create(enclaveLibFile); err != nil {
-		return shim.Error(fmt.Sprintf("ecc: Error while creating enclave %s", err))
-	}
+	// an enclave restored from its sealed state is registered already and only binds to tlcc again
+	var enclavePkBase64 string
+	if e.restored {
+		enclavePk, err := e.GetPublicKey()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("ecc: Error while retrieving enclave pk %s", err))
+		}
+		enclavePkBase64 = base64.StdEncoding.EncodeToString(enclavePk)
+		logger.Debugf("ecc: enclave restored from sealed state; next binding")
+	} else {
+		// create new Enclave unless it has been created to migrate a sealed state
+		// TODO we should return error in case there is any :)
+		if !e.isCreated() {
+			if err := e.Create(enclaveLibFile); err != nil {
+				return shim.Error(fmt.Sprintf("ecc: Error while creating enclave %s", err))
+			}
+			e.setCreated(true)
+		}
 
-	//get spid from ercc
-	spid, err := t.erccStub.GetSPID(stub, erccName, channelName)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	logger.Debugf("ecc: SPID from ercc: %x", spid)
+		//get spid from ercc
+		spid, err := t.erccStub.GetSPID(stub, erccName, channelName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		logger.Debugf("ecc: SPID from ercc: %x", spid)
 
-	// ask enclave for quote
-	quoteAsBytes, enclavePk, err := t.enclave.GetRemoteAttestationReport(spid)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("ecc: Error while creating attestation report: %s", err))
-	}
+		// ask enclave for quote bound to this registration; see attestation.ReportDataFormat
+		binding := attestation.NewReportDataBinding(stub.GetTxID(), channelName, chaincodeRef())
+		bindingDigest := binding.Digest()
+		quoteAsBytes, enclavePk, err := e.GetRemoteAttestationReport(spid, bindingDigest[:])
+		if err != nil {
+			return shim.Error(fmt.Sprintf("ecc: Error while creating attestation report: %s", err))
+		}
 
-	enclavePkBase64 := base64.StdEncoding.EncodeToString(enclavePk)
-	quoteBase64 := base64.StdEncoding.EncodeToString(quoteAsBytes)
+		enclavePkBase64 = base64.StdEncoding.EncodeToString(enclavePk)
+		quoteBase64 := base64.StdEncoding.EncodeToString(quoteAsBytes)
 
-	// register enclave at ercc
-	if err = t.erccStub.RegisterEnclave(stub, erccName, channelName, []byte(enclavePkBase64), []byte(quoteBase64)); err != nil {
-		return shim.Error(err.Error())
-	}
+		// register enclave at ercc
+		if err = t.erccStub.RegisterEnclave(stub, erccName, channelName, []byte(enclavePkBase64), []byte(quoteBase64), chaincodeRef()); err != nil {
+			return shim.Error(err.Error())
+		}
 
-	logger.Debugf("ecc: registration done; next binding")
+		logger.Debugf("ecc: registration done; next binding")
+	}
 	// get target info from our new enclave
-	eccTargetInfo, err := t.enclave.GetTargetInfo()
+	eccTargetInfo, err := e.GetTargetInfo()
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Error while getting target info: %s", err))
 	}
@@ -130,9 +299,10 @@ func (t *EnclaveChaincode) setup(stub shim.ChaincodeStubInterface) pb.Response {
 	}
 
 	// call enclave binding
-	if err = t.enclave.Bind(tlccReport, tlccPk); err != nil {
+	if err = e.Bind(tlccReport, tlccPk); err != nil {
 		return shim.Error(fmt.Sprintf("Error while binding: %s", err))
 	}
+	e.ready = true
 
 	return shim.Success([]byte(enclavePkBase64))
 }
@@ -142,20 +312,29 @@ func (t *EnclaveChaincode) setup(stub shim.ChaincodeStubInterface) pb.Response {
 // ============================================================
 func (t *EnclaveChaincode) invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	// check if we have an enclave already
-	if t.enclave == nil {
-		return shim.Error("ecc: Enclave not initialized! Run setup first!")
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := t.watchdog.check(stub, t.tlccStub); err != nil {
+		return shim.Error(err.Error())
 	}
 	argss := stub.GetStringArgs()
 	args := []byte(argss[0])
 	pk := []byte(argss[1])
 
 	// call enclave
-	responseData, signature, err := t.enclave.Invoke(args, pk, stub, t.tlccStub)
+	responseData, signature, err := e.Invoke(args, pk, stub, t.tlccStub)
+	if errResponse, ok := err.(*utils.ErrorResponse); ok {
+		// the message carries the code and the details encrypted to the client, see utils.ParseErrorResponse
+		logger.Errorf("Invocation failed with code %d", errResponse.Code)
+		return shim.Error(errResponse.Error())
+	}
 	if err != nil {
 		return shim.Error(fmt.Sprintf("ecc: Error while invoking enclave: %s", err))
 	}
 
-	enclavePk, err := t.enclave.GetPublicKey()
+	enclavePk, err := e.GetPublicKey()
 	if err != nil {
 		return shim.Error(fmt.Sprintf("ecc: Error while retrieving enclave pk: %s", err))
 	}
@@ -165,6 +344,13 @@ func (t *EnclaveChaincode) invoke(stub shim.ChaincodeStubInterface) pb.Response
 		Signature:    signature,
 		PublicKey:    enclavePk,
 	}
+	for _, prover := range t.provers {
+		proof, err := prover.Prove(args, responseData)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("ecc: Error while creating %s proof: %s", prover.Type(), err))
+		}
+		response.Proofs = append(response.Proofs, utils.Proof{Type: prover.Type(), Data: proof})
+	}
 	responseBytes, _ := json.Marshal(response)
 
 	return shim.Success(responseBytes)
@@ -175,31 +361,708 @@ func (t *EnclaveChaincode) invoke(stub shim.ChaincodeStubInterface) pb.Response
 // ============================================================
 func (t *EnclaveChaincode) getEnclavePk(stub shim.ChaincodeStubInterface) pb.Response {
 	// check if we have an enclave already
-	if t.enclave == nil {
-		return shim.Error("ecc: Enclave not initialized! Run setup first!")
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
 	// get enclaves public key
-	enclavePk, err := t.enclave.GetPublicKey()
+	enclavePk, err := e.GetPublicKey()
 	if err != nil {
 		return shim.Error(fmt.Sprintf("ecc: Error while retrieving enclave pk %s", err))
 	}
 
 	// marshal response
-	responseBytes, _ := json.Marshal(&utils.Response{PublicKey: enclavePk})
+	responseBytes, _ := json.Marshal(&utils.Response{PublicKey: enclavePk, Capabilities: enclave.Capabilities})
 	return shim.Success(responseBytes)
 }
 
-func (t *EnclaveChaincode) destroy() {
-	if err := t.enclave.Destroy(); err != nil {
+// ============================================================
+// provisionSecret -
+// ============================================================
+func (t *EnclaveChaincode) provisionSecret(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 3 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting ercc name and secret name")
+	}
+	erccName := args[1]
+	secretName := args[2]
+
+	// check if we have an enclave already
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enclavePkHashBase64, err := getEnclavePkHash(e)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// fetch secret encrypted to our enclave from ercc
+	ephemeralPk, ciphertext, approval, err := t.erccStub.GetSecret(stub, erccName, stub.GetChannelID(), enclavePkHashBase64, secretName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the enclave verifies the approval of the provisioner itself
+	if err := e.ProvisionSecret(secretName, ephemeralPk, ciphertext, approval, stub, t.tlccStub); err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while provisioning secret: %s", err))
+	}
+	if err := putAuditRecords(stub, e); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// delegate -
+// ============================================================
+func (t *EnclaveChaincode) delegate(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 4 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting ercc name, delegatee pk hash and delegatee mrenclave")
+	}
+	erccName := args[1]
+	delegateePkHashBase64 := args[2]
+	delegateeMrEnclave := args[3]
+	channelName := stub.GetChannelID()
+
+	// check if we have an enclave already
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enclavePkHashBase64, err := getEnclavePkHash(e)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the enclave verifies the attestation report of the delegatee and the delegation approval itself
+	delegateeReport, err := t.erccStub.GetAttestationReport(stub, erccName, channelName, delegateePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	approvals, err := t.erccStub.GetDelegation(stub, erccName, channelName, chaincodeName(), enclave.MrEnclave, delegateeMrEnclave)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	delegationPk, key, err := e.CreateReEncryptionKey(chaincodeName(), delegateeReport, approvals, stub, t.tlccStub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while creating re-encryption key: %s", err))
+	}
+	if err := putAuditRecords(stub, e); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// ercc checks that the delegation is approved
+	if err := t.erccStub.PutReEncryptionKey(stub, erccName, channelName, chaincodeName(), enclavePkHashBase64, delegateePkHashBase64, enclave.MrEnclave, delegateeMrEnclave, delegationPk, key); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// provisionDelegatedSecret -
+// ============================================================
+func (t *EnclaveChaincode) provisionDelegatedSecret(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 3 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting ercc name and secret name")
+	}
+	erccName := args[1]
+	secretName := args[2]
+
+	// check if we have an enclave already
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enclavePkHashBase64, err := getEnclavePkHash(e)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// fetch secret re-encrypted for our enclave from ercc
+	delegationPk, reEncryptedPk, ciphertext, approval, err := t.erccStub.GetDelegatedSecret(stub, erccName, stub.GetChannelID(), enclavePkHashBase64, secretName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := e.ProvisionDelegatedSecret(secretName, delegationPk, reEncryptedPk, ciphertext, approval, stub, t.tlccStub); err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while provisioning delegated secret: %s", err))
+	}
+	if err := putAuditRecords(stub, e); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// handoverState -
+// ============================================================
+func (t *EnclaveChaincode) handoverState(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 3 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting ercc name and successor pk hash")
+	}
+	erccName := args[1]
+	successorPkHashBase64 := args[2]
+	channelName := stub.GetChannelID()
+
+	// check if we have an enclave already
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enclavePkHashBase64, err := getEnclavePkHash(e)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the enclave verifies the attestation report of the successor and the upgrade approval itself
+	successorReport, err := t.erccStub.GetAttestationReport(stub, erccName, channelName, successorPkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	approvals, err := t.erccStub.GetUpgrade(stub, erccName, channelName, chaincodeName(), enclave.MrEnclave)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ephemeralPk, ciphertext, signature, err := e.ExportStateKey(chaincodeName(), successorReport, approvals, stub, t.tlccStub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while exporting state key: %s", err))
+	}
+	if err := putAuditRecords(stub, e); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// ercc checks that the successor is approved and retires our enclave
+	if err := t.erccStub.HandoverState(stub, erccName, channelName, chaincodeName(), enclavePkHashBase64, successorPkHashBase64, enclave.MrEnclave, ephemeralPk, ciphertext, signature); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// importState -
+// ============================================================
+func (t *EnclaveChaincode) importState(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 2 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting ercc name")
+	}
+	erccName := args[1]
+
+	// check if we have an enclave already
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enclavePkHashBase64, err := getEnclavePkHash(e)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// fetch state key handed over to our enclave from ercc and the attestation report of the predecessor, which the
+	// enclave verifies along with the upgrade approval
+	handover, err := t.erccStub.GetStateHandover(stub, erccName, stub.GetChannelID(), enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	predecessorReport, err := t.erccStub.GetAttestationReport(stub, erccName, stub.GetChannelID(), handover.PredecessorPkHash)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := e.ImportStateKey(chaincodeName(), predecessorReport, handover.Approvals, handover.EphemeralPk, handover.Ciphertext, handover.Signature, stub, t.tlccStub); err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while importing state key: %s", err))
+	}
+	if err := putAuditRecords(stub, e); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// rotateKey -
+// ============================================================
+func (t *EnclaveChaincode) rotateKey(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 3 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting ercc name and new pk hash")
+	}
+	erccName := args[1]
+	newPkHashBase64 := args[2]
+	channelName := stub.GetChannelID()
+
+	// check if we have an enclave already
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enclavePkHashBase64, err := getEnclavePkHash(e)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the enclave verifies the attestation report of the new key itself
+	newReport, err := t.erccStub.GetAttestationReport(stub, erccName, channelName, newPkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	signature, err := e.EndorseKey(newReport)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while endorsing key: %s", err))
+	}
+	if err := putAuditRecords(stub, e); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := t.erccStub.RotateEnclaveKey(stub, erccName, channelName, enclavePkHashBase64, newPkHashBase64, signature); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// renewEnclave -
+// ============================================================
+func (t *EnclaveChaincode) renewEnclave(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 2 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting ercc name")
+	}
+	erccName := args[1]
+	channelName := stub.GetChannelID()
+
+	// check if we have an enclave already
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	spid, err := t.erccStub.GetSPID(stub, erccName, channelName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// fresh quote bound to this renewal, as in setup
+	binding := attestation.NewReportDataBinding(stub.GetTxID(), channelName, chaincodeRef())
+	bindingDigest := binding.Digest()
+	quoteAsBytes, enclavePk, err := e.GetRemoteAttestationReport(spid, bindingDigest[:])
+	if err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while creating attestation report: %s", err))
+	}
+
+	signature, err := e.SignRenewal(stub.GetTxID(), quoteAsBytes)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while signing renewal: %s", err))
+	}
+	if err := putAuditRecords(stub, e); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enclavePkBase64 := base64.StdEncoding.EncodeToString(enclavePk)
+	quoteBase64 := base64.StdEncoding.EncodeToString(quoteAsBytes)
+	if err := t.erccStub.RenewEnclave(stub, erccName, channelName, []byte(enclavePkBase64), []byte(quoteBase64), chaincodeRef(), signature); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// escrowState -
+// ============================================================
+func (t *EnclaveChaincode) escrowState(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 2 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting ercc name")
+	}
+	erccName := args[1]
+	channelName := stub.GetChannelID()
+
+	// check if we have an enclave already
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enclavePkHashBase64, err := getEnclavePkHash(e)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// get recovery parties from ercc and transform their pks to sgx format
+	threshold, mspIDs, partyPks, approvals, err := t.erccStub.GetEscrowPolicy(stub, erccName, channelName, chaincodeName())
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	sgxPks := make([][]byte, len(partyPks))
+	for i, partyPk := range partyPks {
+		partyPub, err := crypto.ParseECDSAPubKey(partyPk)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("ecc: Error while parsing pk of %s: %s", mspIDs[i], err))
+		}
+		sgxPks[i] = crypto.MarshalSgxPk(partyPub)
+	}
+
+	// the enclave verifies the approvals of the policy itself
+	ephemeralPks, ciphertexts, check, signature, err := e.EscrowStateKey(chaincodeName(), threshold, mspIDs, sgxPks, approvals, stub, t.tlccStub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while escrowing state key: %s", err))
+	}
+	if err := putAuditRecords(stub, e); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := t.erccStub.PutEscrow(stub, erccName, channelName, chaincodeName(), enclavePkHashBase64, threshold, mspIDs, ephemeralPks, ciphertexts, check, signature); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// recoverState -
+// ============================================================
+func (t *EnclaveChaincode) recoverState(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 2 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting ercc name")
+	}
+	erccName := args[1]
+
+	// check if we have an enclave already
+	e, err := t.createdEnclave(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enclavePkHashBase64, err := getEnclavePkHash(e)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// fetch the shares recovery parties re-encrypted to our enclave from ercc and the attestation report of the
+	// escrowing enclave, which the enclave verifies along with its signature over the escrow and the escrow policy
+	recovery, err := t.erccStub.GetRecovery(stub, erccName, stub.GetChannelID(), chaincodeName(), enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	escrowReport, err := t.erccStub.GetAttestationReport(stub, erccName, stub.GetChannelID(), recovery.EscrowedBy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := e.RecoverStateKey(escrowReport, chaincodeName(), recovery.Threshold, recovery.PartyMSPIDs, recovery.PartyPks, recovery.Approvals, recovery.Check, recovery.Signature, recovery.EphemeralPks(), recovery.Ciphertexts(), stub, t.tlccStub); err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while recovering state key: %s", err))
+	}
+	if err := putAuditRecords(stub, e); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// commitState -
+// ============================================================
+func (t *EnclaveChaincode) commitState(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 2 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting ercc name")
+	}
+	erccName := args[1]
+
+	tree, err := stateTree(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the range query is part of the read set, thus, the commitment is invalidated if the state changes before commit
+	commitment, err := t.erccStub.PutStateCommitment(stub, erccName, stub.GetChannelID(), chaincodeName(), tree.Root(), tree.Size())
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(commitment)
+}
+
+// ============================================================
+// getStateProof -
+// ============================================================
+func (t *EnclaveChaincode) getStateProof(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 2 {
+		return shim.Error("ecc: Incorrect number of arguments. Expecting key")
+	}
+
+	tree, err := stateTree(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proof, err := tree.Proof(args[1])
+	if err != nil {
+		return shim.Error(fmt.Sprintf("ecc: Error while creating state proof: %s", err))
+	}
+
+	proofBytes, _ := json.Marshal(proof)
+	return shim.Success(proofBytes)
+}
+
+// stateTree returns the Merkle tree over the state of the chaincode as stored on the ledger, i.e., over encrypted values
+func stateTree(stub shim.ChaincodeStubInterface) (*crypto.MerkleTree, error) {
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var leaves []crypto.MerkleLeaf
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, crypto.MerkleLeaf{Key: kv.Key, Value: kv.Value})
+	}
+	return crypto.NewMerkleTree(leaves)
+}
+
+// ============================================================
+// listChannels -
+// ============================================================
+func (t *EnclaveChaincode) listChannels(stub shim.ChaincodeStubInterface) pb.Response {
+	// the enclaves of other channels are not revealed, only that they exist
+	channelsBytes, _ := json.Marshal(t.channels())
+	return shim.Success(channelsBytes)
+}
+
+// ============================================================
+// getAuditLog -
+// ============================================================
+func (t *EnclaveChaincode) getAuditLog(stub shim.ChaincodeStubInterface) pb.Response {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(crypto.AuditObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	records := []crypto.AuditRecord{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var record crypto.AuditRecord
+		if err := json.Unmarshal(kv.Value, &record); err != nil {
+			return shim.Error(fmt.Sprintf("ecc: Invalid audit record %s: %s", kv.Key, err))
+		}
+		records = append(records, record)
+	}
+
+	recordsBytes, _ := json.Marshal(records)
+	return shim.Success(recordsBytes)
+}
+
+// ============================================================
+// getCrashRecord -
+// ============================================================
+func (t *EnclaveChaincode) getCrashRecord(stub shim.ChaincodeStubInterface) pb.Response {
+	// null if no ecall failed since the chaincode started
+	recordBytes, _ := json.Marshal(enclave.LastCrash())
+	return shim.Success(recordBytes)
+}
+
+// putAuditRecords writes the audit records the enclave queued for operations outside of invocations, so that they
+// commit along with the transaction
+func putAuditRecords(stub shim.ChaincodeStubInterface, e enclave.Stub) error {
+	records, err := e.AuditRecords()
+	if err != nil {
+		return fmt.Errorf("ecc: Error while getting audit records: %s", err)
+	}
+	for _, recordBytes := range records {
+		var record crypto.AuditRecord
+		if err := json.Unmarshal(recordBytes, &record); err != nil {
+			return fmt.Errorf("ecc: Invalid audit record: %s", err)
+		}
+		key, err := stub.CreateCompositeKey(crypto.AuditObjectType, []string{record.ID})
+		if err != nil {
+			return err
+		}
+		if err := stub.PutState(key, recordBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddProofProvider attaches the proofs of prover to all responses of invocations
+func (t *EnclaveChaincode) AddProofProvider(prover ProofProvider) {
+	t.provers = append(t.provers, prover)
+}
+
+// getEnclavePkHash returns the hash of the enclave pk as used by ercc to identify the enclave
+func getEnclavePkHash(e enclave.Stub) (string, error) {
+	enclavePk, err := e.GetPublicKey()
+	if err != nil {
+		return "", fmt.Errorf("ecc: Error while retrieving enclave pk %s", err)
+	}
+	enclavePkHash := sha256.Sum256(enclavePk)
+	return base64.StdEncoding.EncodeToString(enclavePkHash[:]), nil
+}
+
+func destroy(e enclave.Stub) {
+	if err := e.Destroy(); err != nil {
 		panic("ecc: Can not destory enclave!!!")
 	}
 }
 
+// sealedStateFileOf returns the file the state of the enclave of a channel is sealed to
+func (t *EnclaveChaincode) sealedStateFileOf(channelID string) string {
+	return t.sealedStateFile + "." + channelID
+}
+
+// restoreState creates the enclaves of all channels from the states sealed at the last shutdown
+func (t *EnclaveChaincode) restoreState() error {
+	if t.sealedStateFile == "" {
+		return nil
+	}
+	files, err := filepath.Glob(t.sealedStateFileOf("*"))
+	if err != nil {
+		return fmt.Errorf("ecc: Error while reading sealed states: %s", err)
+	}
+	for _, file := range files {
+		channelID := strings.TrimPrefix(file, t.sealedStateFileOf(""))
+		if err := t.restoreChannelState(channelID, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreChannelState creates the enclave of a channel from a sealed state
+func (t *EnclaveChaincode) restoreChannelState(channelID, file string) error {
+	sealed, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("ecc: Error while reading sealed state: %s", err)
+	}
+
+	e := t.enclaveOf(channelID)
+	e.setupLock.Lock()
+	defer e.setupLock.Unlock()
+	if err := e.Create(enclaveLibFile); err != nil {
+		return fmt.Errorf("ecc: Error while creating enclave %s", err)
+	}
+	e.setCreated(true)
+	restored, err := e.UnsealState(sealed)
+	if err != nil {
+		// e.g., the state was sealed by another signer; start over with a new enclave on the next setup
+		logger.Warningf("ecc: Can not restore sealed state of channel %s: %s", channelID, err)
+		e.setCreated(false)
+		destroy(e)
+		return nil
+	}
+	e.restored = restored
+	if restored {
+		logger.Infof("ecc: Enclave of channel %s restored from %s", channelID, file)
+	} else {
+		logger.Infof("ecc: Enclave of channel %s migrated the state sealed by its predecessor in %s; setup registers the new enclave", channelID, file)
+	}
+	return nil
+}
+
+// sealState flushes the sealed state of an enclave to file. The file is replaced atomically so that a crash while
+// writing keeps the previous state.
+func sealState(e enclave.Stub, file string) error {
+	sealed, err := e.SealState()
+	if err != nil {
+		return err
+	}
+	// hidden, thus, never taken for the sealed state of a channel
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(sealed); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// shutdown flushes the sealed states, if enabled, and destroys the enclaves of all channels; subsequent calls do
+// nothing
+func (t *EnclaveChaincode) shutdown() {
+	t.shutdownOnce.Do(func() {
+		for _, channelID := range t.channels() {
+			e := t.enclaveOf(channelID)
+			// a setup in progress completes first; invocations in flight complete before Destroy
+			e.setupLock.Lock()
+			if t.sealedStateFile != "" {
+				file := t.sealedStateFileOf(channelID)
+				if err := sealState(e, file); err != nil {
+					logger.Errorf("ecc: Error while sealing state of channel %s: %s", channelID, err)
+				} else {
+					logger.Infof("ecc: Enclave state of channel %s sealed to %s", channelID, file)
+				}
+			}
+			e.setCreated(false)
+			destroy(e)
+			e.setupLock.Unlock()
+		}
+	})
+}
+
+// shutdownOnSignal shuts down orderly when the peer stops the chaincode
+func (t *EnclaveChaincode) shutdownOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		logger.Infof("ecc: Received %s; shutting down", sig)
+		t.shutdown()
+		os.Exit(0)
+	}()
+}
+
 func main() {
 	// create enclave chaincode
 	t := NewEcc()
-	defer t.destroy()
+	t.sealedStateFile = os.Getenv(sealedStateFileEnv)
+	watchdog, err := newLedgerWatchdog(os.Getenv(maxLedgerLagEnv))
+	if err != nil {
+		logger.Errorf("%s", err)
+	}
+	t.watchdog = watchdog
+	if err := t.restoreState(); err != nil {
+		logger.Errorf("%s", err)
+	}
+	t.shutdownOnSignal()
+	defer t.shutdown()
 
 	// start chaincode
 	if err := shim.Start(t); err != nil {
@@ -23,6 +23,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
 	"sync"
 	"testing"
@@ -30,7 +31,7 @@ import (
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
 	enc "github.com/hyperledger-labs/fabric-secure-chaincode/ecc/enclave"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/ercc"
-	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/tlcc"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/internal/tlcc"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/eval/benchmark/executor"
 	th "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
 
@@ -51,6 +52,25 @@ func TestEnclaveChaincode_Init(t *testing.T) {
 	th.CheckState(t, stub, th.MrEnclaveStateKey, enc.MrEnclave)
 }
 
+func TestChaincodeRef(t *testing.T) {
+	defer os.Unsetenv(chaincodeIDEnv)
+
+	os.Unsetenv(chaincodeIDEnv)
+	if ref := chaincodeRef(); ref != chaincodeID {
+		t.Fatalf("Expected %s without %s, got %s", chaincodeID, chaincodeIDEnv, ref)
+	}
+
+	// the name the peer started the chaincode with is bound, not the default
+	os.Setenv(chaincodeIDEnv, "auction:1.2")
+	if name, ref := chaincodeName(), chaincodeRef(); name != "auction" || ref != "auction:1.2" {
+		t.Fatalf("Expected auction and auction:1.2, got %s and %s", name, ref)
+	}
+	os.Setenv(chaincodeIDEnv, "auction")
+	if ref := chaincodeRef(); ref != "auction" {
+		t.Fatalf("Expected auction, got %s", ref)
+	}
+}
+
 func TestEnclaveChaincode_Setup(t *testing.T) {
 	ecc := createECC()
 	stub := shim.NewMockStub("ecc", ecc)
@@ -239,8 +259,8 @@ func TestEnclaveChaincode_EncryptedInvoke(t *testing.T) {
 	// gen shared secret
 	key, err := crypto.GenSharedKey(enclavePub, priv)
 
-	plain_args := []string{"create", "MyAuction123"}
-	plaintext, _ := json.Marshal(plain_args)
+	plain_args, _ := json.Marshal([]string{"create", "MyAuction123"})
+	plaintext, _ := json.Marshal(map[string]string{"args": string(plain_args), "seq": "1"})
 	ciphertext, _ := crypto.Encrypt(plaintext, key[:16])
 	fmt.Printf("cipher: \n%s", hex.Dump(ciphertext))
 
@@ -266,9 +286,10 @@ func TestEnclaveChaincode_EncryptedInvoke(t *testing.T) {
 
 func createECC() *EnclaveChaincode {
 	return &EnclaveChaincode{
-		erccStub: &ercc.MockEnclaveRegistryStub{},
-		tlccStub: &tlcc.MockTLCCStub{},
-		enclave:  enc.NewEnclave(),
-		verifier: &crypto.ECDSAVerifier{},
+		erccStub:   &ercc.MockEnclaveRegistryStub{},
+		tlccStub:   &tlcc.MockTLCCStub{},
+		newEnclave: enc.NewEnclave,
+		enclaves:   make(map[string]*channelEnclave),
+		verifier:   &crypto.ECDSAVerifier{},
 	}
 }
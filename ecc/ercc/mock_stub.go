@@ -17,6 +17,8 @@
 package ercc
 
 import (
+	"errors"
+
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
@@ -32,7 +34,92 @@ func (t *MockEnclaveRegistryStub) GetSPID(stub shim.ChaincodeStubInterface, chai
 }
 
 // RegisterEnclave registers enclave at ercc
-func (t *MockEnclaveRegistryStub) RegisterEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel string, enclavePk, enclaveQuote []byte) error {
+func (t *MockEnclaveRegistryStub) RegisterEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel string, enclavePk, enclaveQuote []byte, chaincodeID string) error {
 	// fmt.Println("Register: " + base64.StdEncoding.EncodeToString(enclaveID) + " : " + base64.StdEncoding.EncodeToString(enclaveQuote))
 	return nil
 }
+
+// GetSecret returns no secret
+func (t *MockEnclaveRegistryStub) GetSecret(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash, secretName string) ([]byte, []byte, []byte, error) {
+	return nil, nil, nil, errors.New("No secret provisioned")
+}
+
+// GetClientKey returns no client key
+func (t *MockEnclaveRegistryStub) GetClientKey(stub shim.ChaincodeStubInterface, chaincodeName, channel, mspID, clientID string) ([]byte, error) {
+	return nil, errors.New("No client key registered")
+}
+
+// GetEnclavePk returns no enclave pk
+func (t *MockEnclaveRegistryStub) GetEnclavePk(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash string) ([]byte, error) {
+	return nil, errors.New("No enclave registered")
+}
+
+// GetAttestationReport returns no attestation report
+func (t *MockEnclaveRegistryStub) GetAttestationReport(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash string) ([]byte, error) {
+	return nil, errors.New("No enclave registered")
+}
+
+// GetUpgrade returns no upgrade approval
+func (t *MockEnclaveRegistryStub) GetUpgrade(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, mrEnclave string) ([]byte, error) {
+	return nil, errors.New("No upgrade approved")
+}
+
+// HandoverState does nothing
+func (t *MockEnclaveRegistryStub) HandoverState(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash, successorPkHash, mrEnclave string, ephemeralPk, ciphertext, signature []byte) error {
+	return nil
+}
+
+// GetStateHandover returns no state handover
+func (t *MockEnclaveRegistryStub) GetStateHandover(stub shim.ChaincodeStubInterface, chaincodeName, channel, successorPkHash string) (*StateHandover, error) {
+	return nil, errors.New("No state handover")
+}
+
+// RotateEnclaveKey does nothing
+func (t *MockEnclaveRegistryStub) RotateEnclaveKey(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash, newPkHash string, signature []byte) error {
+	return nil
+}
+
+// RenewEnclave does nothing
+func (t *MockEnclaveRegistryStub) RenewEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel string, enclavePk, enclaveQuote []byte, chaincodeID string, signature []byte) error {
+	return nil
+}
+
+// PutStateCommitment does nothing
+func (t *MockEnclaveRegistryStub) PutStateCommitment(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName string, root []byte, size int) ([]byte, error) {
+	return nil, nil
+}
+
+// GetDelegation returns no delegation approval
+func (t *MockEnclaveRegistryStub) GetDelegation(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, mrEnclave, delegateeMrEnclave string) ([]byte, error) {
+	return nil, errors.New("No delegation approved")
+}
+
+// PutReEncryptionKey does nothing
+func (t *MockEnclaveRegistryStub) PutReEncryptionKey(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash, delegateePkHash, mrEnclave, delegateeMrEnclave string, delegationPk, key []byte) error {
+	return nil
+}
+
+// GetDelegatedSecret returns no delegated secret
+func (t *MockEnclaveRegistryStub) GetDelegatedSecret(stub shim.ChaincodeStubInterface, chaincodeName, channel, delegateePkHash, secretName string) ([]byte, []byte, []byte, []byte, error) {
+	return nil, nil, nil, nil, errors.New("No secret delegated")
+}
+
+// GetEscrowPolicy returns no escrow policy
+func (t *MockEnclaveRegistryStub) GetEscrowPolicy(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName string) (int, []string, [][]byte, []byte, error) {
+	return 0, nil, nil, nil, errors.New("No escrow policy")
+}
+
+// PutEscrow does nothing
+func (t *MockEnclaveRegistryStub) PutEscrow(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash string, threshold int, mspIDs []string, ephemeralPks, ciphertexts [][]byte, check, signature []byte) error {
+	return nil
+}
+
+// GetRecovery returns no recovery
+func (t *MockEnclaveRegistryStub) GetRecovery(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash string) (*Recovery, error) {
+	return nil, errors.New("No recovery")
+}
+
+// VerifyForeignEnclave returns an error as no enclave is imported
+func (t *MockEnclaveRegistryStub) VerifyForeignEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel, sourceChannel string, enclavePk []byte) error {
+	return errors.New("No enclave imported")
+}
@@ -17,7 +17,10 @@
 package ercc
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"strconv"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
@@ -25,7 +28,24 @@ import (
 // EnclaveRegistryStub interface
 type EnclaveRegistryStub interface {
 	GetSPID(stub shim.ChaincodeStubInterface, chaincodeName, channel string) ([]byte, error)
-	RegisterEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel string, enclavePk, enclaveQuote []byte) error
+	RegisterEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel string, enclavePk, enclaveQuote []byte, chaincodeID string) error
+	GetSecret(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash, secretName string) ([]byte, []byte, []byte, error)
+	GetClientKey(stub shim.ChaincodeStubInterface, chaincodeName, channel, mspID, clientID string) ([]byte, error)
+	GetEnclavePk(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash string) ([]byte, error)
+	GetAttestationReport(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash string) ([]byte, error)
+	GetUpgrade(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, mrEnclave string) ([]byte, error)
+	HandoverState(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash, successorPkHash, mrEnclave string, ephemeralPk, ciphertext, signature []byte) error
+	GetStateHandover(stub shim.ChaincodeStubInterface, chaincodeName, channel, successorPkHash string) (*StateHandover, error)
+	RotateEnclaveKey(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash, newPkHash string, signature []byte) error
+	RenewEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel string, enclavePk, enclaveQuote []byte, chaincodeID string, signature []byte) error
+	PutStateCommitment(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName string, root []byte, size int) ([]byte, error)
+	GetDelegation(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, mrEnclave, delegateeMrEnclave string) ([]byte, error)
+	PutReEncryptionKey(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash, delegateePkHash, mrEnclave, delegateeMrEnclave string, delegationPk, key []byte) error
+	GetDelegatedSecret(stub shim.ChaincodeStubInterface, chaincodeName, channel, delegateePkHash, secretName string) ([]byte, []byte, []byte, []byte, error)
+	GetEscrowPolicy(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName string) (int, []string, [][]byte, []byte, error)
+	PutEscrow(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash string, threshold int, mspIDs []string, ephemeralPks, ciphertexts [][]byte, check, signature []byte) error
+	GetRecovery(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash string) (*Recovery, error)
+	VerifyForeignEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel, sourceChannel string, enclavePk []byte) error
 }
 
 // EnclaveRegistryStubImpl implements EnclaveRegistry interface and calls ercc
@@ -40,8 +60,9 @@ func (t *EnclaveRegistryStubImpl) GetSPID(stub shim.ChaincodeStubInterface, chai
 	return nil, errors.New("Can not load SPID")
 }
 
-// RegisterEnclave registers enclave at ercc
-func (t *EnclaveRegistryStubImpl) RegisterEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel string, enclavePk, enclaveQuote []byte) error {
+// RegisterEnclave registers enclave at ercc; the quote must be bound to the transaction, channel and chaincodeID,
+// which may include the chaincode version as "<chaincodeID>:<version>"
+func (t *EnclaveRegistryStubImpl) RegisterEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel string, enclavePk, enclaveQuote []byte, chaincodeID string) error {
 	certPEM, ok := stub.GetDecorations()["certPEM"]
 	if !ok {
 		return errors.New("Can not load CertPEM")
@@ -52,9 +73,378 @@ func (t *EnclaveRegistryStubImpl) RegisterEnclave(stub shim.ChaincodeStubInterfa
 		return errors.New("Can not load KeyPEM")
 	}
 
-	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("registerEnclave"), enclavePk, enclaveQuote, certPEM, keyPEM}, channel)
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("registerBoundEnclave"), enclavePk, enclaveQuote, []byte(chaincodeID), certPEM, keyPEM}, channel)
 	if resp.Status != shim.OK {
 		return errors.New("Setup failed: Con not register enclave at ercc" + string(resp.Message))
 	}
 	return nil
 }
+
+// GetSecret returns the ephemeral public key and the ciphertext of a secret provisioned to an enclave at ercc, and
+// the approval of the provisioner (JSON), which the enclave verifies itself
+func (t *EnclaveRegistryStubImpl) GetSecret(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash, secretName string) ([]byte, []byte, []byte, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getSecret"), []byte(enclavePkHash), []byte(secretName)}, channel)
+	if resp.Status != shim.OK {
+		return nil, nil, nil, errors.New("Can not get secret from ercc: " + string(resp.Message))
+	}
+
+	type Secret struct {
+		EphemeralPk []byte
+		Ciphertext  []byte
+		Approval    json.RawMessage
+	}
+
+	var s Secret
+	if err := json.Unmarshal(resp.Payload, &s); err != nil {
+		return nil, nil, nil, err
+	}
+	return s.EphemeralPk, s.Ciphertext, s.Approval, nil
+}
+
+// GetClientKey returns the public key (DER-encoded PKIX) a client registered at ercc
+func (t *EnclaveRegistryStubImpl) GetClientKey(stub shim.ChaincodeStubInterface, chaincodeName, channel, mspID, clientID string) ([]byte, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getClientKey"), []byte(mspID), []byte(clientID)}, channel)
+	if resp.Status != shim.OK {
+		return nil, errors.New("Can not get client key from ercc: " + string(resp.Message))
+	}
+
+	type ClientKey struct {
+		PublicKey []byte
+	}
+
+	var k ClientKey
+	if err := json.Unmarshal(resp.Payload, &k); err != nil {
+		return nil, err
+	}
+	return k.PublicKey, nil
+}
+
+// GetEnclavePk returns the public key (DER-encoded PKIX) of an enclave registered at ercc
+func (t *EnclaveRegistryStubImpl) GetEnclavePk(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash string) ([]byte, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getAttestationReport"), []byte(enclavePkHash)}, channel)
+	if resp.Status != shim.OK {
+		return nil, errors.New("Can not get attestation report from ercc: " + string(resp.Message))
+	}
+
+	type AttestationReport struct {
+		EnclavePk []byte
+	}
+
+	var r AttestationReport
+	if err := json.Unmarshal(resp.Payload, &r); err != nil {
+		return nil, err
+	}
+	return r.EnclavePk, nil
+}
+
+// GetAttestationReport returns the attestation report (JSON) of an enclave registered at ercc, e.g., for another
+// enclave to verify
+func (t *EnclaveRegistryStubImpl) GetAttestationReport(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash string) ([]byte, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getAttestationReport"), []byte(enclavePkHash)}, channel)
+	if resp.Status != shim.OK {
+		return nil, errors.New("Can not get attestation report from ercc: " + string(resp.Message))
+	}
+	return resp.Payload, nil
+}
+
+// GetUpgrade returns the approvals (JSON list) of the admins who approved the successor of the enclave binary
+// mrEnclave of chaincode eccName
+func (t *EnclaveRegistryStubImpl) GetUpgrade(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, mrEnclave string) ([]byte, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getUpgrade"), []byte(eccName), []byte(mrEnclave)}, channel)
+	if resp.Status != shim.OK {
+		return nil, errors.New("Can not get upgrade approval from ercc: " + string(resp.Message))
+	}
+
+	type Upgrade struct {
+		Approvals json.RawMessage
+	}
+
+	var u Upgrade
+	if err := json.Unmarshal(resp.Payload, &u); err != nil {
+		return nil, err
+	}
+	return u.Approvals, nil
+}
+
+// HandoverState hands over the encrypted state key to a successor enclave and retires the enclave at ercc; the
+// signature of the enclave authenticates the handover
+func (t *EnclaveRegistryStubImpl) HandoverState(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash, successorPkHash, mrEnclave string, ephemeralPk, ciphertext, signature []byte) error {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{
+		[]byte("handoverState"),
+		[]byte(eccName),
+		[]byte(enclavePkHash),
+		[]byte(successorPkHash),
+		[]byte(mrEnclave),
+		[]byte(base64.StdEncoding.EncodeToString(ephemeralPk)),
+		[]byte(base64.StdEncoding.EncodeToString(ciphertext)),
+		[]byte(base64.StdEncoding.EncodeToString(signature))}, channel)
+	if resp.Status != shim.OK {
+		return errors.New("Can not hand over state at ercc: " + string(resp.Message))
+	}
+	return nil
+}
+
+// StateHandover is the state key handed over to a successor enclave: the pk hash of the predecessor, the ephemeral
+// public key and the ciphertext of the key, the signature of the predecessor and the upgrade approvals (JSON list)
+type StateHandover struct {
+	PredecessorPkHash string
+	EphemeralPk       []byte
+	Ciphertext        []byte
+	Signature         []byte
+	Approvals         json.RawMessage
+}
+
+// GetStateHandover returns the state key handed over to a successor enclave
+func (t *EnclaveRegistryStubImpl) GetStateHandover(stub shim.ChaincodeStubInterface, chaincodeName, channel, successorPkHash string) (*StateHandover, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getStateHandover"), []byte(successorPkHash)}, channel)
+	if resp.Status != shim.OK {
+		return nil, errors.New("Can not get state handover from ercc: " + string(resp.Message))
+	}
+
+	h := &StateHandover{}
+	if err := json.Unmarshal(resp.Payload, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// RotateEnclaveKey rotates the identity of the enclave with enclavePkHash to the key with newPkHash at ercc; the
+// signature of the enclave over the new key authorizes the rotation
+func (t *EnclaveRegistryStubImpl) RotateEnclaveKey(stub shim.ChaincodeStubInterface, chaincodeName, channel, enclavePkHash, newPkHash string, signature []byte) error {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getEnclaveID"), []byte(enclavePkHash)}, channel)
+	if resp.Status != shim.OK {
+		return errors.New("Can not get enclave id from ercc: " + string(resp.Message))
+	}
+
+	resp = stub.InvokeChaincode(chaincodeName, [][]byte{
+		[]byte("rotateEnclaveKey"),
+		resp.Payload,
+		[]byte(newPkHash),
+		[]byte("signature"),
+		[]byte(base64.StdEncoding.EncodeToString(signature))}, channel)
+	if resp.Status != shim.OK {
+		return errors.New("Can not rotate enclave key at ercc: " + string(resp.Message))
+	}
+	return nil
+}
+
+// RenewEnclave replaces the evidence of the registered enclave at ercc with a fresh quote, bound like in
+// RegisterEnclave; the signature of the enclave over the renewal authorizes it
+func (t *EnclaveRegistryStubImpl) RenewEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel string, enclavePk, enclaveQuote []byte, chaincodeID string, signature []byte) error {
+	certPEM, ok := stub.GetDecorations()["certPEM"]
+	if !ok {
+		return errors.New("Can not load CertPEM")
+	}
+
+	keyPEM, ok := stub.GetDecorations()["keyPEM"]
+	if !ok {
+		return errors.New("Can not load KeyPEM")
+	}
+
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{
+		[]byte("renewEnclave"),
+		enclavePk,
+		enclaveQuote,
+		[]byte(""),
+		[]byte(chaincodeID),
+		[]byte(base64.StdEncoding.EncodeToString(signature)),
+		certPEM,
+		keyPEM}, channel)
+	if resp.Status != shim.OK {
+		return errors.New("Can not renew enclave at ercc: " + string(resp.Message))
+	}
+	return nil
+}
+
+// PutStateCommitment publishes the Merkle root over the state of chaincode eccName at ercc and returns the stored commitment
+func (t *EnclaveRegistryStubImpl) PutStateCommitment(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName string, root []byte, size int) ([]byte, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{
+		[]byte("putStateCommitment"),
+		[]byte(eccName),
+		[]byte(base64.StdEncoding.EncodeToString(root)),
+		[]byte(strconv.Itoa(size))}, channel)
+	if resp.Status != shim.OK {
+		return nil, errors.New("Can not publish state commitment at ercc: " + string(resp.Message))
+	}
+	return resp.Payload, nil
+}
+
+// GetDelegation returns the approvals (JSON list) of the admins who approved the delegation from the enclave binary
+// mrEnclave of chaincode eccName to delegateeMrEnclave
+func (t *EnclaveRegistryStubImpl) GetDelegation(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, mrEnclave, delegateeMrEnclave string) ([]byte, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getDelegation"), []byte(eccName), []byte(mrEnclave), []byte(delegateeMrEnclave)}, channel)
+	if resp.Status != shim.OK {
+		return nil, errors.New("Can not get delegation approval from ercc: " + string(resp.Message))
+	}
+
+	type Delegation struct {
+		Approvals json.RawMessage
+	}
+
+	var d Delegation
+	if err := json.Unmarshal(resp.Payload, &d); err != nil {
+		return nil, err
+	}
+	return d.Approvals, nil
+}
+
+// PutReEncryptionKey stores a re-encryption key from an enclave to a delegatee enclave at ercc
+func (t *EnclaveRegistryStubImpl) PutReEncryptionKey(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash, delegateePkHash, mrEnclave, delegateeMrEnclave string, delegationPk, key []byte) error {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{
+		[]byte("putReEncryptionKey"),
+		[]byte(eccName),
+		[]byte(enclavePkHash),
+		[]byte(delegateePkHash),
+		[]byte(mrEnclave),
+		[]byte(delegateeMrEnclave),
+		[]byte(base64.StdEncoding.EncodeToString(delegationPk)),
+		[]byte(base64.StdEncoding.EncodeToString(key))}, channel)
+	if resp.Status != shim.OK {
+		return errors.New("Can not store re-encryption key at ercc: " + string(resp.Message))
+	}
+	return nil
+}
+
+// GetDelegatedSecret returns the delegation public key, the re-encrypted ephemeral public key and the ciphertext of
+// a secret re-encrypted for a delegatee enclave at ercc, and the approval of the provisioner (JSON)
+func (t *EnclaveRegistryStubImpl) GetDelegatedSecret(stub shim.ChaincodeStubInterface, chaincodeName, channel, delegateePkHash, secretName string) ([]byte, []byte, []byte, []byte, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getDelegatedSecret"), []byte(delegateePkHash), []byte(secretName)}, channel)
+	if resp.Status != shim.OK {
+		return nil, nil, nil, nil, errors.New("Can not get delegated secret from ercc: " + string(resp.Message))
+	}
+
+	type DelegatedSecret struct {
+		DelegationPk []byte
+		EphemeralPk  []byte
+		Ciphertext   []byte
+		Approval     json.RawMessage
+	}
+
+	var s DelegatedSecret
+	if err := json.Unmarshal(resp.Payload, &s); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return s.DelegationPk, s.EphemeralPk, s.Ciphertext, s.Approval, nil
+}
+
+// escrowShare is a share of the state key of a chaincode encrypted to a recovery party or to a recovering enclave
+type escrowShare struct {
+	MSPID       string
+	EphemeralPk []byte
+	Ciphertext  []byte
+}
+
+// GetEscrowPolicy returns the threshold, the MSP IDs and the public keys (DER-encoded PKIX) of the recovery parties
+// of the state key of chaincode eccName from ercc, and the approvals (JSON list) of the admins who set the policy
+func (t *EnclaveRegistryStubImpl) GetEscrowPolicy(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName string) (int, []string, [][]byte, []byte, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getEscrowPolicy"), []byte(eccName)}, channel)
+	if resp.Status != shim.OK {
+		return 0, nil, nil, nil, errors.New("Can not get escrow policy from ercc: " + string(resp.Message))
+	}
+
+	type EscrowPolicy struct {
+		Threshold int
+		Parties   []struct {
+			MSPID string
+			Pk    []byte
+		}
+		Approvals json.RawMessage
+	}
+
+	var p EscrowPolicy
+	if err := json.Unmarshal(resp.Payload, &p); err != nil {
+		return 0, nil, nil, nil, err
+	}
+	mspIDs := make([]string, len(p.Parties))
+	pks := make([][]byte, len(p.Parties))
+	for i, party := range p.Parties {
+		mspIDs[i], pks[i] = party.MSPID, party.Pk
+	}
+	return p.Threshold, mspIDs, pks, p.Approvals, nil
+}
+
+// PutEscrow stores the shares of the state key of chaincode eccName, one per recovery party, at ercc; the signature
+// of the enclave binds the check value to the escrow policy
+func (t *EnclaveRegistryStubImpl) PutEscrow(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash string, threshold int, mspIDs []string, ephemeralPks, ciphertexts [][]byte, check, signature []byte) error {
+	type Escrow struct {
+		Threshold int
+		Check     []byte
+		Shares    []escrowShare
+		Signature []byte
+	}
+
+	escrow := &Escrow{Threshold: threshold, Check: check, Signature: signature}
+	for i := range mspIDs {
+		escrow.Shares = append(escrow.Shares, escrowShare{MSPID: mspIDs[i], EphemeralPk: ephemeralPks[i], Ciphertext: ciphertexts[i]})
+	}
+	escrowAsBytes, err := json.Marshal(escrow)
+	if err != nil {
+		return err
+	}
+
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("putEscrow"), []byte(eccName), []byte(enclavePkHash), escrowAsBytes}, channel)
+	if resp.Status != shim.OK {
+		return errors.New("Can not store escrow at ercc: " + string(resp.Message))
+	}
+	return nil
+}
+
+// Recovery holds the escrow shares recovery parties re-encrypted to an enclave and what the enclave verifies them
+// with: the check value of the escrowed state key, signed by the escrowing enclave (pk hash EscrowedBy) under the
+// escrow policy, i.e., the threshold, the MSP IDs and pks (sgx format) of the recovery parties and the approvals
+// (JSON list) of the admins
+type Recovery struct {
+	Check       []byte
+	Shares      []escrowShare
+	EscrowedBy  string
+	Threshold   int
+	PartyMSPIDs []string
+	PartyPks    [][]byte
+	Approvals   json.RawMessage
+	Signature   []byte
+}
+
+// GetRecovery returns the escrow shares recovery parties re-encrypted to an enclave from ercc
+func (t *EnclaveRegistryStubImpl) GetRecovery(stub shim.ChaincodeStubInterface, chaincodeName, channel, eccName, enclavePkHash string) (*Recovery, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("getRecovery"), []byte(eccName), []byte(enclavePkHash)}, channel)
+	if resp.Status != shim.OK {
+		return nil, errors.New("Can not get recovery from ercc: " + string(resp.Message))
+	}
+
+	r := &Recovery{}
+	if err := json.Unmarshal(resp.Payload, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// EphemeralPks returns the ephemeral public keys of the shares of a recovery
+func (r *Recovery) EphemeralPks() [][]byte {
+	pks := make([][]byte, len(r.Shares))
+	for i, share := range r.Shares {
+		pks[i] = share.EphemeralPk
+	}
+	return pks
+}
+
+// Ciphertexts returns the ciphertexts of the shares of a recovery
+func (r *Recovery) Ciphertexts() [][]byte {
+	ciphertexts := make([][]byte, len(r.Shares))
+	for i, share := range r.Shares {
+		ciphertexts[i] = share.Ciphertext
+	}
+	return ciphertexts
+}
+
+// VerifyForeignEnclave returns an error unless an enclave registered on sourceChannel has been imported into channel
+// and may be trusted there
+func (t *EnclaveRegistryStubImpl) VerifyForeignEnclave(stub shim.ChaincodeStubInterface, chaincodeName, channel, sourceChannel string, enclavePk []byte) error {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{
+		[]byte("verifyForeignEnclave"),
+		[]byte(sourceChannel),
+		[]byte(base64.StdEncoding.EncodeToString(enclavePk))}, channel)
+	if resp.Status != shim.OK {
+		return errors.New("Can not verify foreign enclave at ercc: " + string(resp.Message))
+	}
+	return nil
+}
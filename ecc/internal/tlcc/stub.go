@@ -0,0 +1,206 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package tlcc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric/common/flogging"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var logger = flogging.MustGetLogger("enclave_stub")
+
+var _logger = func(in string) {
+	logger.Info(in)
+}
+
+// TLCCStub interface
+type TLCCStub interface {
+	GetReport(stub shim.ChaincodeStubInterface, chaincodeName, channel string, targetInfo []byte) ([]byte, []byte, error)
+	VerifyState(stub shim.ChaincodeStubInterface, chaincodeName, channel, key string, nonce []byte, isRangeQuery bool) ([]byte, error)
+	GetMSPRoots(stub shim.ChaincodeStubInterface, chaincodeName, channel, mspID string, nonce []byte) ([]byte, []byte, error)
+	GetChannelMSPs(stub shim.ChaincodeStubInterface, chaincodeName, channel string, nonce []byte) ([]byte, []byte, error)
+	GetLedgerTime(stub shim.ChaincodeStubInterface, chaincodeName, channel string, nonce []byte) (uint64, int64, []byte, error)
+	GetBlockHeight(stub shim.ChaincodeStubInterface, chaincodeName, channel string) (uint64, error)
+}
+
+// TLCCStubImpl implements TLCC interface and calls tlcc
+type TLCCStubImpl struct {
+}
+
+// RegisterEnclave registers enclave at ercc
+func (t *TLCCStubImpl) GetReport(stub shim.ChaincodeStubInterface, chaincodeName, channel string, targetInfo []byte) ([]byte, []byte, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("GET_LOCAL_ATT_REPORT"), targetInfo}, channel)
+	if resp.Status != shim.OK {
+		return nil, nil, errors.New("Setup failed: Con not register enclave at ercc" + string(resp.Message))
+	}
+
+	type Response struct {
+		Report    string
+		EnclavePk string
+	}
+
+	var r Response
+	if err := json.Unmarshal(resp.Payload, &r); err != nil {
+		return nil, nil, err
+	}
+
+	reportBytes, err := base64.StdEncoding.DecodeString(r.Report)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enclavePkBytes, err := base64.StdEncoding.DecodeString(r.EnclavePk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return reportBytes, enclavePkBytes, nil
+}
+
+func (t *TLCCStubImpl) VerifyState(stub shim.ChaincodeStubInterface, chaincodeName, channel, key string, nonce []byte, isRangeQuery bool) ([]byte, error) {
+	// TODO state prefix currently hardcoded
+	prefix := "ecc"
+	nonceBase64 := base64.StdEncoding.EncodeToString(nonce)
+
+	var k string
+	if isRangeQuery {
+		k = prefix + key
+	} else {
+		k = prefix + "." + key
+	}
+
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("VERIFY_STATE"), []byte(k), []byte(nonceBase64), []byte(strconv.FormatBool(isRangeQuery))}, channel)
+	if resp.Status != shim.OK {
+		return nil, errors.New("Error while performing Verify state" + string(resp.Message))
+	}
+
+	// logger.Info("tlccStub got CMAC: " + string(resp.Payload))
+	cmacBytes, err := base64.StdEncoding.DecodeString(string(resp.Payload))
+	if err != nil {
+		return nil, err
+	}
+
+	return cmacBytes, nil
+}
+
+// GetMSPRoots returns the CA certificates of an MSP of the channel as PEM bundle and the cmac tlcc computed over them
+func (t *TLCCStubImpl) GetMSPRoots(stub shim.ChaincodeStubInterface, chaincodeName, channel, mspID string, nonce []byte) ([]byte, []byte, error) {
+	nonceBase64 := base64.StdEncoding.EncodeToString(nonce)
+
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("GET_MSP_ROOTS"), []byte(mspID), []byte(nonceBase64)}, channel)
+	if resp.Status != shim.OK {
+		return nil, nil, errors.New("Error while getting MSP roots: " + string(resp.Message))
+	}
+
+	type Response struct {
+		Roots string
+		CMAC  string
+	}
+
+	var r Response
+	if err := json.Unmarshal(resp.Payload, &r); err != nil {
+		return nil, nil, err
+	}
+
+	roots, err := base64.StdEncoding.DecodeString(r.Roots)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmac, err := base64.StdEncoding.DecodeString(r.CMAC)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return roots, cmac, nil
+}
+
+// GetChannelMSPs returns the channel id and the MSP IDs of the application orgs of the channel, each terminated by a
+// newline, and the cmac tlcc computed over them
+func (t *TLCCStubImpl) GetChannelMSPs(stub shim.ChaincodeStubInterface, chaincodeName, channel string, nonce []byte) ([]byte, []byte, error) {
+	nonceBase64 := base64.StdEncoding.EncodeToString(nonce)
+
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("GET_CHANNEL_MSPS"), []byte(nonceBase64)}, channel)
+	if resp.Status != shim.OK {
+		return nil, nil, errors.New("Error while getting channel MSPs: " + string(resp.Message))
+	}
+
+	type Response struct {
+		Config string
+		CMAC   string
+	}
+
+	var r Response
+	if err := json.Unmarshal(resp.Payload, &r); err != nil {
+		return nil, nil, err
+	}
+
+	config, err := base64.StdEncoding.DecodeString(r.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmac, err := base64.StdEncoding.DecodeString(r.CMAC)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, cmac, nil
+}
+
+// GetLedgerTime returns the block height and ledger time of tlcc and the cmac tlcc computed over them and the nonce
+func (t *TLCCStubImpl) GetLedgerTime(stub shim.ChaincodeStubInterface, chaincodeName, channel string, nonce []byte) (uint64, int64, []byte, error) {
+	nonceBase64 := base64.StdEncoding.EncodeToString(nonce)
+
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("GET_LEDGER_TIME"), []byte(nonceBase64)}, channel)
+	if resp.Status != shim.OK {
+		return 0, 0, nil, errors.New("Error while getting ledger time: " + string(resp.Message))
+	}
+
+	type Response struct {
+		Height uint64
+		Time   int64
+		CMAC   string
+	}
+
+	var r Response
+	if err := json.Unmarshal(resp.Payload, &r); err != nil {
+		return 0, 0, nil, err
+	}
+
+	cmac, err := base64.StdEncoding.DecodeString(r.CMAC)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return r.Height, r.Time, cmac, nil
+}
+
+// GetBlockHeight returns the height of the ledger of the peer, i.e., the number of the next block. Unlike the
+// height returned by GetLedgerTime, it is not authenticated by the tlcc enclave.
+func (t *TLCCStubImpl) GetBlockHeight(stub shim.ChaincodeStubInterface, chaincodeName, channel string) (uint64, error) {
+	resp := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("GET_BLOCK_HEIGHT")}, channel)
+	if resp.Status != shim.OK {
+		return 0, errors.New("Error while getting block height: " + string(resp.Message))
+	}
+	return strconv.ParseUint(string(resp.Payload), 10, 64)
+}
@@ -37,3 +37,19 @@ func (t *MockTLCCStub) VerifyState(stub shim.ChaincodeStubInterface, chaincodeNa
 	cmac = bytes.Repeat([]byte{0xff}, 16)
 	return cmac, nil
 }
+
+func (t *MockTLCCStub) GetMSPRoots(stub shim.ChaincodeStubInterface, chaincodeName, channel, mspID string, nonce []byte) ([]byte, []byte, error) {
+	return []byte{}, bytes.Repeat([]byte{0xff}, 16), nil
+}
+
+func (t *MockTLCCStub) GetChannelMSPs(stub shim.ChaincodeStubInterface, chaincodeName, channel string, nonce []byte) ([]byte, []byte, error) {
+	return []byte(channel + "\nOrg1MSP\n"), bytes.Repeat([]byte{0xff}, 16), nil
+}
+
+func (t *MockTLCCStub) GetLedgerTime(stub shim.ChaincodeStubInterface, chaincodeName, channel string, nonce []byte) (uint64, int64, []byte, error) {
+	return 0, 0, bytes.Repeat([]byte{0xff}, 16), nil
+}
+
+func (t *MockTLCCStub) GetBlockHeight(stub shim.ChaincodeStubInterface, chaincodeName, channel string) (uint64, error) {
+	return 0, nil
+}
@@ -21,7 +21,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"sort"
 
 	"github.com/golang/protobuf/proto"
 	commonerrors "github.com/hyperledger/fabric/common/errors"
@@ -31,28 +30,38 @@ import (
 	"github.com/hyperledger/fabric/protos/peer"
 	"github.com/hyperledger/fabric/protos/utils"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
 	sgx_utils "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
 )
 
 var logger = flogging.MustGetLogger("vscc")
 
-// New creates a new instance of the ercc VSCC
+// New creates a new instance of the ercc VSCC; enclaveEndorsementOnly is set if enclave endorsements replace the
+// endorsement policy, see EnclaveEndorsementOnlyEnv
 // Typically this will only be invoked once per peer
-func New(stateFetcher StateFetcher) *VSCCECC {
+func New(stateFetcher StateFetcher, enclaveEndorsementOnly bool) *VSCCECC {
 	return &VSCCECC{
-		verifier: &crypto.ECDSAVerifier{},
-		sf:       stateFetcher,
+		verifier:               &crypto.ECDSAVerifier{},
+		ra:                     &attestation.VerifierImpl{},
+		sf:                     stateFetcher,
+		enclaveEndorsementOnly: enclaveEndorsementOnly,
 	}
 }
 
 type VSCCECC struct {
 	verifier crypto.Verifier
+	ra       attestation.Verifier
 	sf       StateFetcher
+	// enclaveEndorsementOnly requires the enclave to be bound to the chaincode and to run its mrenclave
+	enclaveEndorsementOnly bool
 }
 
 // Validate validates the given envelope corresponding to a transaction with an endorsement
-// policy as given in its serialized form
-func (vscc *VSCCECC) Validate(envelopeBytes []byte, policyBytes []byte) commonerrors.TxValidationError {
+// policy as given in its serialized form; the enclave endorsement is checked against the read/write set
+// of the chaincode namespace
+func (vscc *VSCCECC) Validate(envelopeBytes []byte, namespace string, policyBytes []byte) commonerrors.TxValidationError {
 	// get the envelope...
 	env, err := utils.GetEnvelopeFromBlock(envelopeBytes)
 	if err != nil {
@@ -107,7 +116,7 @@ func (vscc *VSCCECC) Validate(envelopeBytes []byte, policyBytes []byte) commoner
 		}
 
 		// finally validate proposal and response
-		if err = vscc.checkEnclaveEndorsement(cis, ccAction); err != nil {
+		if err = vscc.checkEnclaveEndorsement(cis, ccAction, namespace); err != nil {
 			logger.Errorf("ECC-VSCC error: checkEnclaveEndorsement failed, err %s", err)
 			return policyErr(err)
 		}
@@ -115,9 +124,32 @@ func (vscc *VSCCECC) Validate(envelopeBytes []byte, policyBytes []byte) commoner
 	return nil
 }
 
-func (vscc *VSCCECC) checkEnclaveEndorsement(cis *peer.ChaincodeInvocationSpec, respPayload *peer.ChaincodeAction) error {
+func (vscc *VSCCECC) checkEnclaveEndorsement(cis *peer.ChaincodeInvocationSpec, respPayload *peer.ChaincodeAction, namespace string) error {
 	logger.Debug("checkEnclaveEndorsement starts")
 
+	txRWSet := &rwsetutil.TxRwSet{}
+	if err := txRWSet.FromProtoBytes(respPayload.Results); err != nil {
+		return err
+	}
+	return vscc.checkRWSetEndorsement(cis, respPayload, txRWSet, namespace)
+}
+
+// checkRWSetEndorsement checks the enclave signature over the read/write set of the chaincode namespace. Without
+// endorsement policy, the enclave signature is all that authorizes the transaction, so the read/write set of the
+// namespace must be present exactly once; otherwise a transaction without it would pass unendorsed.
+func (vscc *VSCCECC) checkRWSetEndorsement(cis *peer.ChaincodeInvocationSpec, respPayload *peer.ChaincodeAction, txRWSet *rwsetutil.TxRwSet, namespace string) error {
+	if vscc.enclaveEndorsementOnly {
+		rwsets := 0
+		for _, ns := range txRWSet.NsRwSets {
+			if ns.NameSpace == namespace {
+				rwsets++
+			}
+		}
+		if rwsets != 1 {
+			return fmt.Errorf("Expected one read/write set of %s signed by its enclave but got %d", namespace, rwsets)
+		}
+	}
+
 	channelState, err := vscc.sf.FetchState()
 	if err != nil {
 		return fmt.Errorf("Fetch channel state failed, err %s", err)
@@ -125,25 +157,26 @@ func (vscc *VSCCECC) checkEnclaveEndorsement(cis *peer.ChaincodeInvocationSpec,
 	defer channelState.Done()
 	state := &state{channelState}
 
-	txRWSet := &rwsetutil.TxRwSet{}
-	if err := txRWSet.FromProtoBytes(respPayload.Results); err != nil {
-		return err
-	}
-
 	for _, ns := range txRWSet.NsRwSets {
 		logger.Debugf("Namespace %s", ns.NameSpace)
 
-		// TODO make this more flexible
-		if ns.NameSpace != "ecc" {
+		// only the read/write set of the chaincode is signed by its enclave
+		if ns.NameSpace != namespace {
 			continue
 		}
 
 		// get the args of the ecc invocation
 		// carefull we need only args[0] (function) as it includes all arguments
+		if cis.ChaincodeSpec == nil || cis.ChaincodeSpec.Input == nil || len(cis.ChaincodeSpec.Input.Args) == 0 {
+			return fmt.Errorf("Invocation spec misses the args")
+		}
 		args := cis.ChaincodeSpec.Input.Args[0]
 		logger.Debugf("args: %s\n", string(args))
 
 		// get the enclave response
+		if respPayload.Response == nil {
+			return fmt.Errorf("Chaincode action misses the response")
+		}
 		response := &sgx_utils.Response{}
 		if err := json.Unmarshal(respPayload.Response.Payload, response); err != nil {
 			return fmt.Errorf("Unmarshalling of SGX response failed, err: %s", err)
@@ -161,50 +194,71 @@ func (vscc *VSCCECC) checkEnclaveEndorsement(cis *peer.ChaincodeInvocationSpec,
 			return fmt.Errorf("Enclave PK not found in registry")
 		}
 
-		// Next, reproduce sorted read/writeset
-		var readset, writeset [][]byte
-
-		// normal reads
-		var readKeys []string
-		for _, r := range ns.KvRwSet.Reads {
-			k := sgx_utils.TransformToSGX(r.Key, sgx_utils.SEP)
-			readKeys = append(readKeys, k)
+		// the registered evidence must bind the key that signed the response
+		if err := vscc.checkEnclavePkBinding(state, attestation, response.PublicKey); err != nil {
+			return err
 		}
 
-		// range query reads
-		for _, rqi := range ns.KvRwSet.RangeQueriesInfo {
-			for _, qr := range rqi.GetRawReads().KvReads {
-				k := sgx_utils.TransformToSGX(qr.Key, sgx_utils.SEP)
-				readKeys = append(readKeys, k)
+		// without endorsement policy, only enclaves of this chaincode may endorse its transactions
+		if vscc.enclaveEndorsementOnly {
+			if err := vscc.checkChaincodeEnclave(state, attestation, base64PublicKey, namespace); err != nil {
+				return err
 			}
 		}
 
-		// writes
-		var writeKeys []string
-		writesetMap := make(map[string][]byte)
-		for _, w := range ns.KvRwSet.Writes {
-			k := sgx_utils.TransformToSGX(w.Key, sgx_utils.SEP)
-			writeKeys = append(writeKeys, k)
-			writesetMap[k] = w.Value
+		// enclaves retired by an upgrade must not endorse anymore
+		retired, err := state.GetState("ercc", registry.CompositeKey(registry.RetiredObjectType, base64PublicKey))
+		if err != nil {
+			return fmt.Errorf("Fetch retirement of enclave failed, err %s", err)
+		}
+		if retired != nil {
+			return fmt.Errorf("Enclave PK has been retired")
 		}
 
-		// sort readset and writeset as enclave uses a sorted map
-		sort.Strings(readKeys)
-		sort.Strings(writeKeys)
+		// enclaves must not endorse before enough orgs confirmed their registration
+		pending, err := state.GetState("ercc", registry.CompositeKey(registry.PendingObjectType, base64PublicKey))
+		if err != nil {
+			return fmt.Errorf("Fetch pending registration of enclave failed, err %s", err)
+		}
+		if pending != nil {
+			return fmt.Errorf("Enclave registration is pending confirmation")
+		}
 
-		logger.Debug("reads:")
-		for _, k := range readKeys {
-			logger.Debugf("\t%s\n", k)
-			readset = append(readset, []byte(k))
+		// enclaves whose platform got revoked at re-validation must not endorse anymore
+		statusAsBytes, err := state.GetState("ercc", registry.CompositeKey(registry.StatusObjectType, base64PublicKey))
+		if err != nil {
+			return fmt.Errorf("Fetch status of enclave failed, err %s", err)
+		}
+		if statusAsBytes != nil {
+			status := &registry.EnclaveStatus{}
+			if err := json.Unmarshal(statusAsBytes, status); err != nil {
+				return fmt.Errorf("Unmarshalling of enclave status failed, err %s", err)
+			}
+			if status.IsRevoked() {
+				return fmt.Errorf("Enclave platform has been revoked: %s", status.QuoteStatus)
+			}
 		}
 
-		logger.Debug("writes:")
-		for _, k := range writeKeys {
-			logger.Debugf("\t%s - %s\n", k, string(writesetMap[k]))
-			writeset = append(writeset, []byte(k))
-			writeset = append(writeset, writesetMap[k])
+		// the transaction timestamp is chosen by the client, so grace periods and expiry are decided with the
+		// ledger clock of ercc
+		ledgerTime, err := getLedgerTime(state)
+		if err != nil {
+			return err
 		}
 
+		// enclaves below the minimum isv svn of the chaincode stop endorsing after the grace period
+		if err := checkIsvSvn(state, ns.NameSpace, attestation, ledgerTime); err != nil {
+			return err
+		}
+
+		// registrations expire relative to the IAS timestamp of their report
+		if err := checkRegistrationExpiry(state, attestation, ledgerTime); err != nil {
+			return err
+		}
+
+		// Next, reproduce sorted read/writeset
+		readset, writeset := sgx_utils.SignedRWSet(ns.KvRwSet)
+
 		isValid, err := vscc.verifier.Verify(args, response.ResponseData, readset, writeset, response.Signature, response.PublicKey)
 		if err != nil {
 			return fmt.Errorf("Response invalid! Signature verification failed! Error: %s", err)
@@ -234,6 +288,184 @@ func (s *state) GetState(namespace string, key string) ([]byte, error) {
 	return values[0], nil
 }
 
+// checkEnclavePkBinding checks that the attestation report belongs to the enclave pk and that the pk is bound by the quote
+func (vscc *VSCCECC) checkEnclavePkBinding(state *state, attestationReportAsBytes []byte, enclavePk []byte) error {
+	attestationReportAsBytes, err := registry.DecompressEvidence(attestationReportAsBytes)
+	if err != nil {
+		return fmt.Errorf("Decompression of attestation report failed, err %s", err)
+	}
+
+	report := attestation.IASAttestationReport{}
+	if err := json.Unmarshal(attestationReportAsBytes, &report); err != nil {
+		return fmt.Errorf("Unmarshalling of attestation report failed, err %s", err)
+	}
+
+	if !consttime.Equal(report.EnclavePk, enclavePk) {
+		return fmt.Errorf("Enclave PK does not match attestation report")
+	}
+
+	// quotes may be bound to the registration context, too
+	var binding *attestation.ReportDataBinding
+	enclavePkHash := sha256.Sum256(enclavePk)
+	bindingKey := registry.CompositeKey(registry.BindingObjectType, base64.StdEncoding.EncodeToString(enclavePkHash[:]))
+	bindingAsBytes, err := state.GetState("ercc", bindingKey)
+	if err != nil {
+		return fmt.Errorf("Fetch binding of enclave failed, err %s", err)
+	}
+	if bindingAsBytes != nil {
+		binding = &attestation.ReportDataBinding{}
+		if err := json.Unmarshal(bindingAsBytes, binding); err != nil {
+			return fmt.Errorf("Unmarshalling of binding failed, err %s", err)
+		}
+	}
+
+	isValid, err := vscc.ra.CheckReportData(enclavePk, binding, report)
+	if err != nil {
+		return fmt.Errorf("Error while checking enclave PK: %s", err)
+	}
+	if !isValid {
+		return fmt.Errorf("Enclave PK is not bound by quote")
+	}
+	return nil
+}
+
+// checkChaincodeEnclave checks that the enclave is bound to the chaincode and runs the mrenclave the chaincode
+// stored at instantiation
+func (vscc *VSCCECC) checkChaincodeEnclave(state *state, attestationReportAsBytes []byte, enclavePkHashBase64, chaincodeID string) error {
+	bindingAsBytes, err := state.GetState("ercc", registry.CompositeKey(registry.BindingObjectType, enclavePkHashBase64))
+	if err != nil {
+		return fmt.Errorf("Fetch binding of enclave failed, err %s", err)
+	}
+	if bindingAsBytes == nil {
+		return fmt.Errorf("Enclave is not bound to chaincode %s", chaincodeID)
+	}
+	binding := &attestation.ReportDataBinding{}
+	if err := json.Unmarshal(bindingAsBytes, binding); err != nil {
+		return fmt.Errorf("Unmarshalling of binding failed, err %s", err)
+	}
+	if binding.ChaincodeID != chaincodeID {
+		return fmt.Errorf("Enclave is bound to chaincode %s, not %s", binding.ChaincodeID, chaincodeID)
+	}
+
+	mrenclave, err := state.GetState(chaincodeID, sgx_utils.MrEnclaveStateKey)
+	if err != nil {
+		return fmt.Errorf("Fetch mrenclave of chaincode failed, err %s", err)
+	}
+	if mrenclave == nil {
+		return fmt.Errorf("No mrenclave stored by chaincode %s", chaincodeID)
+	}
+
+	attestationReportAsBytes, err = registry.DecompressEvidence(attestationReportAsBytes)
+	if err != nil {
+		return fmt.Errorf("Decompression of attestation report failed, err %s", err)
+	}
+	report := attestation.IASAttestationReport{}
+	if err := json.Unmarshal(attestationReportAsBytes, &report); err != nil {
+		return fmt.Errorf("Unmarshalling of attestation report failed, err %s", err)
+	}
+	matches, err := vscc.ra.CheckMrEnclave(string(mrenclave), report)
+	if err != nil {
+		return fmt.Errorf("Error while checking mrenclave: %s", err)
+	}
+	if !matches {
+		return fmt.Errorf("Enclave does not run mrenclave %s of chaincode %s", string(mrenclave), chaincodeID)
+	}
+	return nil
+}
+
+// getLedgerTime returns the time of the ledger clock committed by ercc, or 0 if it has not been started yet
+func getLedgerTime(state *state) (int64, error) {
+	clockAsBytes, err := state.GetState("ercc", registry.CompositeKey(registry.LedgerClockObjectType))
+	if err != nil {
+		return 0, fmt.Errorf("Fetch ledger clock failed, err %s", err)
+	}
+	if clockAsBytes == nil {
+		return 0, nil
+	}
+
+	clock := &registry.LedgerClock{}
+	if err := json.Unmarshal(clockAsBytes, clock); err != nil {
+		return 0, fmt.Errorf("Unmarshalling of ledger clock failed, err %s", err)
+	}
+	return clock.Time, nil
+}
+
+func checkIsvSvn(state *state, chaincodeName string, attestationReportAsBytes []byte, ledgerTime int64) error {
+	policyAsBytes, err := state.GetState("ercc", registry.CompositeKey(registry.MinIsvSvnObjectType, chaincodeName))
+	if err != nil {
+		return fmt.Errorf("Fetch min isv svn failed, err %s", err)
+	}
+	if policyAsBytes == nil {
+		return nil
+	}
+
+	policy := &registry.MinIsvSvnPolicy{}
+	if err := json.Unmarshal(policyAsBytes, policy); err != nil {
+		return fmt.Errorf("Unmarshalling of min isv svn failed, err %s", err)
+	}
+
+	attestationReportAsBytes, err = registry.DecompressEvidence(attestationReportAsBytes)
+	if err != nil {
+		return fmt.Errorf("Decompression of attestation report failed, err %s", err)
+	}
+
+	report := attestation.IASAttestationReport{}
+	if err := json.Unmarshal(attestationReportAsBytes, &report); err != nil {
+		return fmt.Errorf("Unmarshalling of attestation report failed, err %s", err)
+	}
+
+	quote, err := attestation.QuoteFromAttestionReport(report)
+	if err != nil {
+		return fmt.Errorf("Can not parse quote, err %s", err)
+	}
+
+	if !policy.IsEndorsing(registry.IsvSvn(quote), ledgerTime) {
+		return fmt.Errorf("Enclave isv svn below minimum %d", policy.MinIsvSvn)
+	}
+	return nil
+}
+
+func checkRegistrationExpiry(state *state, attestationReportAsBytes []byte, ledgerTime int64) error {
+	policyAsBytes, err := state.GetState("ercc", registry.CompositeKey(registry.AttestationPolicyObjectType))
+	if err != nil {
+		return fmt.Errorf("Fetch attestation policy failed, err %s", err)
+	}
+	if policyAsBytes == nil {
+		return nil
+	}
+
+	policy := &registry.AttestationPolicy{}
+	if err := json.Unmarshal(policyAsBytes, policy); err != nil {
+		return fmt.Errorf("Unmarshalling of attestation policy failed, err %s", err)
+	}
+	if policy.RegistrationValidity == 0 {
+		return nil
+	}
+
+	attestationReportAsBytes, err = registry.DecompressEvidence(attestationReportAsBytes)
+	if err != nil {
+		return fmt.Errorf("Decompression of attestation report failed, err %s", err)
+	}
+
+	report := attestation.IASAttestationReport{}
+	if err := json.Unmarshal(attestationReportAsBytes, &report); err != nil {
+		return fmt.Errorf("Unmarshalling of attestation report failed, err %s", err)
+	}
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return fmt.Errorf("Unmarshalling of report body failed, err %s", err)
+	}
+
+	expiresAt, err := policy.ExpiresAt(reportBody)
+	if err != nil {
+		return err
+	}
+	if ledgerTime >= expiresAt {
+		return fmt.Errorf("Enclave registration has expired")
+	}
+	return nil
+}
+
 func policyErr(err error) *commonerrors.VSCCEndorsementPolicyError {
 	return &commonerrors.VSCCEndorsementPolicyError{
 		Err: err,
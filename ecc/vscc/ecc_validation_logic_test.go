@@ -0,0 +1,72 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/hyperledger/fabric/core/handlers/validation/api/state"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// emptyState is a channel state without any entries
+type emptyState struct {
+	State
+}
+
+func (s *emptyState) GetStateMultipleKeys(namespace string, keys []string) ([][]byte, error) {
+	return make([][]byte, len(keys)), nil
+}
+
+func (s *emptyState) Done() {}
+
+type emptyStateFetcher struct{}
+
+func (f *emptyStateFetcher) FetchState() (State, error) {
+	return &emptyState{}, nil
+}
+
+func TestCheckRWSetEndorsement_MissingRWSet(t *testing.T) {
+	cis := &peer.ChaincodeInvocationSpec{ChaincodeSpec: &peer.ChaincodeSpec{Input: &peer.ChaincodeInput{Args: [][]byte{[]byte("invoke")}}}}
+	action := &peer.ChaincodeAction{}
+	// the transaction only writes to another namespace
+	txRWSet := &rwsetutil.TxRwSet{NsRwSets: []*rwsetutil.NsRwSet{
+		{NameSpace: "othercc", KvRwSet: &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "key", Value: []byte("value")}}}},
+	}}
+
+	// the default vscc checks the endorsement policy of such transactions
+	vscc := New(&emptyStateFetcher{}, false)
+	if err := vscc.checkRWSetEndorsement(cis, action, txRWSet, "mycc"); err != nil {
+		t.Fatalf("Transaction without read/write set of mycc should be left to the default vscc: %s", err)
+	}
+
+	// without endorsement policy, nothing would endorse it
+	vscc = New(&emptyStateFetcher{}, true)
+	if err := vscc.checkRWSetEndorsement(cis, action, txRWSet, "mycc"); err == nil {
+		t.Fatalf("Transaction without read/write set of mycc should fail without endorsement policy")
+	}
+
+	// one enclave signature covers one read/write set
+	txRWSet.NsRwSets = append(txRWSet.NsRwSets,
+		&rwsetutil.NsRwSet{NameSpace: "mycc", KvRwSet: &kvrwset.KVRWSet{}},
+		&rwsetutil.NsRwSet{NameSpace: "mycc", KvRwSet: &kvrwset.KVRWSet{}})
+	if err := vscc.checkRWSetEndorsement(cis, action, txRWSet, "mycc"); err == nil {
+		t.Fatalf("Transaction with two read/write sets of mycc should fail without endorsement policy")
+	}
+}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"reflect"
 
 	commonerrors "github.com/hyperledger/fabric/common/errors"
@@ -29,6 +30,10 @@ import (
 	"github.com/pkg/errors"
 )
 
+// EnclaveEndorsementOnlyEnv enables the "enclave as endorser" model if set to true: transactions are accepted
+// if endorsed by a registered chaincode enclave, without checking the MSP endorsement policy of the chaincode
+const EnclaveEndorsementOnlyEnv = "ECC_VSCC_ENCLAVE_ENDORSEMENT_ONLY"
+
 func NewPluginFactory() validation.PluginFactory {
 	return &ECCValidationFactory{}
 }
@@ -43,11 +48,13 @@ func (*ECCValidationFactory) New() validation.Plugin {
 type ECCValidation struct {
 	DefaultTxValidator validation.Plugin
 	ECCTxValidator     TransactionValidator
+	// EnclaveEndorsementOnly skips the default vscc, see EnclaveEndorsementOnlyEnv
+	EnclaveEndorsementOnly bool
 }
 
 //go:generate mockery -dir . -name TransactionValidator -case underscore -output mocks/
 type TransactionValidator interface {
-	Validate(txData []byte, policy []byte) commonerrors.TxValidationError
+	Validate(txData []byte, namespace string, policy []byte) commonerrors.TxValidationError
 }
 
 func (v *ECCValidation) Validate(block *common.Block, namespace string, txPosition int, actionPosition int, contextData ...validation.ContextDatum) error {
@@ -69,15 +76,17 @@ func (v *ECCValidation) Validate(block *common.Block, namespace string, txPositi
 		return errors.Errorf("no block header")
 	}
 
-	// do defalt vscc
-	err := v.DefaultTxValidator.Validate(block, namespace, txPosition, actionPosition, contextData...)
-	if err != nil {
-		logger.Debugf("block %d, namespace: %s, tx %d validation results is: %v", block.Header.Number, namespace, txPosition, err)
-		return convertErrorTypeOrPanic(err)
+	// do defalt vscc unless the enclave endorsement replaces the endorsement policy
+	if !v.EnclaveEndorsementOnly {
+		err := v.DefaultTxValidator.Validate(block, namespace, txPosition, actionPosition, contextData...)
+		if err != nil {
+			logger.Debugf("block %d, namespace: %s, tx %d validation results is: %v", block.Header.Number, namespace, txPosition, err)
+			return convertErrorTypeOrPanic(err)
+		}
 	}
 
 	// do ecc-vscc
-	err = v.ECCTxValidator.Validate(block.Data.Data[txPosition], serializedPolicy.Bytes())
+	err := v.ECCTxValidator.Validate(block.Data.Data[txPosition], namespace, serializedPolicy.Bytes())
 	logger.Debugf("block %d, namespace: %s, tx %d validation results is: %v", block.Header.Number, namespace, txPosition, err)
 	return convertErrorTypeOrPanic(err)
 
@@ -110,7 +119,11 @@ func (v *ECCValidation) Init(dependencies ...validation.Dependency) error {
 		return errors.New("ECC-VSCC: stateFetcher not passed in init")
 	}
 
-	v.ECCTxValidator = New(sf)
+	v.EnclaveEndorsementOnly = os.Getenv(EnclaveEndorsementOnlyEnv) == "true"
+	v.ECCTxValidator = New(sf, v.EnclaveEndorsementOnly)
+	if v.EnclaveEndorsementOnly {
+		logger.Infof("ECC-VSCC: accepting enclave endorsements without endorsement policy check")
+	}
 
 	// use default vscc and our custom ecc vscc
 	factory := &defaultvscc.DefaultValidationFactory{}
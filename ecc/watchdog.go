@@ -0,0 +1,95 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/internal/tlcc"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// maxLedgerLagEnv bounds how many blocks the view of tlcc may lag behind the ledger of the peer for the chaincode
+// to endorse invocations; the check is disabled if unset or 0
+const maxLedgerLagEnv = "ECC_MAX_LEDGER_LAG"
+
+// ledgerWatchdog refuses endorsements while the trusted ledger view lags too far behind the ledger of the peer.
+// The enclave reads state as validated by tlcc; if tlcc falls behind, e.g., because it is slow to validate blocks,
+// the enclave computes on stale state and its endorsements fail validation at best.
+type ledgerWatchdog struct {
+	// maxLag is the number of blocks tlcc may lag behind, 0 disables the watchdog
+	maxLag uint64
+	// stale is set while the lag exceeds maxLag, so that changes are logged once
+	stale     bool
+	staleLock sync.Mutex
+}
+
+// newLedgerWatchdog returns a watchdog with the maximal lag configured by maxLedgerLagEnv
+func newLedgerWatchdog(maxLag string) (*ledgerWatchdog, error) {
+	if maxLag == "" {
+		return &ledgerWatchdog{}, nil
+	}
+	lag, err := strconv.ParseUint(maxLag, 10, 64)
+	if err != nil {
+		return &ledgerWatchdog{}, fmt.Errorf("invalid %s %s: %s", maxLedgerLagEnv, maxLag, err)
+	}
+	return &ledgerWatchdog{maxLag: lag}, nil
+}
+
+// check returns an error if the height of tlcc lags behind the height of the peer by more than maxLag blocks
+func (w *ledgerWatchdog) check(stub shim.ChaincodeStubInterface, tlccStub tlcc.TLCCStub) error {
+	if w == nil || w.maxLag == 0 {
+		return nil
+	}
+
+	// TODO note that TLCC is currently hardcoded
+	peerHeight, err := tlccStub.GetBlockHeight(stub, "tlcc", stub.GetChannelID())
+	if err != nil {
+		return fmt.Errorf("ecc: Can not get block height of the peer: %s", err)
+	}
+	// the ledger time comes with the height of tlcc; the nonce only matters to the enclave
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	tlccHeight, _, _, err := tlccStub.GetLedgerTime(stub, "tlcc", stub.GetChannelID(), nonce)
+	if err != nil {
+		return fmt.Errorf("ecc: Can not get block height of tlcc: %s", err)
+	}
+
+	stale := peerHeight > tlccHeight && peerHeight-tlccHeight > w.maxLag
+	w.staleLock.Lock()
+	if stale != w.stale {
+		if stale {
+			logger.Warningf("tlcc at height %d lags behind the peer at height %d, refusing to endorse", tlccHeight, peerHeight)
+		} else {
+			logger.Infof("tlcc caught up with the peer at height %d", peerHeight)
+		}
+		w.stale = stale
+	}
+	w.staleLock.Unlock()
+
+	if stale {
+		return fmt.Errorf("ecc: Trusted ledger at height %d lags %d blocks behind the peer, more than %d", tlccHeight,
+			peerHeight-tlccHeight, w.maxLag)
+	}
+	return nil
+}
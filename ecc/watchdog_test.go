@@ -0,0 +1,70 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/internal/tlcc"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// heightsTLCCStub reports fixed heights of the peer and tlcc
+type heightsTLCCStub struct {
+	tlcc.MockTLCCStub
+	peerHeight, tlccHeight uint64
+}
+
+func (t *heightsTLCCStub) GetBlockHeight(stub shim.ChaincodeStubInterface, chaincodeName, channel string) (uint64, error) {
+	return t.peerHeight, nil
+}
+
+func (t *heightsTLCCStub) GetLedgerTime(stub shim.ChaincodeStubInterface, chaincodeName, channel string, nonce []byte) (uint64, int64, []byte, error) {
+	return t.tlccHeight, 0, nil, nil
+}
+
+func TestLedgerWatchdog(t *testing.T) {
+	stub := shim.NewMockStub("ecc", nil)
+
+	w, err := newLedgerWatchdog("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.check(stub, &heightsTLCCStub{peerHeight: 12, tlccHeight: 10}); err != nil {
+		t.Fatalf("lag within bound refused: %s", err)
+	}
+	if err := w.check(stub, &heightsTLCCStub{peerHeight: 13, tlccHeight: 10}); err == nil {
+		t.Fatalf("lag beyond bound accepted")
+	}
+	if err := w.check(stub, &heightsTLCCStub{peerHeight: 13, tlccHeight: 13}); err != nil {
+		t.Fatalf("caught up tlcc refused: %s", err)
+	}
+	// tlcc may see a block the peer has not reported yet
+	if err := w.check(stub, &heightsTLCCStub{peerHeight: 13, tlccHeight: 14}); err != nil {
+		t.Fatalf("tlcc ahead refused: %s", err)
+	}
+
+	disabled, _ := newLedgerWatchdog("")
+	if err := disabled.check(stub, &heightsTLCCStub{peerHeight: 100, tlccHeight: 0}); err != nil {
+		t.Fatalf("disabled watchdog refused: %s", err)
+	}
+
+	if _, err := newLedgerWatchdog("many"); err == nil {
+		t.Fatalf("invalid lag accepted")
+	}
+}
@@ -0,0 +1,146 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <map>
+#include <string>
+#include <vector>
+
+#include "shim.h"
+
+// Attribute-based access control evaluated inside the enclave. The chaincode declares policies over
+// the attributes of the creator per function and per key prefix and returns them from
+// get_access_control (see chaincode.h), e.g.,
+//
+//   static access_control acl = [] {
+//       access_control acl;
+//       acl.restrict_function("close", access_policy().require_ou("admin"));
+//       acl.restrict_keys("salary.", access_policy().require_attr("hr", "true"));
+//       return acl;
+//   }();
+//   const access_control* get_access_control() { return &acl; }
+//
+// The enclave checks the function before the chaincode runs and the keys before it decrypts state,
+// so clients without a matching identity (see client.Identity) neither execute the function nor
+// learn restricted state. Requests without creator, e.g., pseudonymous ones, satisfy no policy.
+
+// access_policy is satisfied by a creator that has all the required attributes
+class access_policy
+{
+public:
+    // the creator must be enrolled at msp_id
+    access_policy& require_msp(const std::string& msp_id)
+    {
+        msp_id_ = msp_id;
+        return *this;
+    }
+
+    // the creator must have one of the required OUs
+    access_policy& require_ou(const std::string& ou)
+    {
+        ous_.push_back(ou);
+        return *this;
+    }
+
+    // the creator must have the attribute with the value
+    access_policy& require_attr(const std::string& name, const std::string& value)
+    {
+        attrs_[name] = value;
+        return *this;
+    }
+
+    bool allows(const creator_t* creator) const
+    {
+        if (creator == NULL) {
+            return false;
+        }
+        if (!msp_id_.empty() && creator->msp_id != msp_id_) {
+            return false;
+        }
+        if (!ous_.empty()) {
+            bool found = false;
+            for (auto& ou : creator->ous) {
+                for (auto& required : ous_) {
+                    found = found || ou == required;
+                }
+            }
+            if (!found) {
+                return false;
+            }
+        }
+        for (auto& attr : attrs_) {
+            auto search = creator->attrs.find(attr.first);
+            if (search == creator->attrs.end() || search->second != attr.second) {
+                return false;
+            }
+        }
+        return true;
+    }
+
+private:
+    std::string msp_id_;
+    std::vector<std::string> ous_;
+    std::map<std::string, std::string> attrs_;
+};
+
+// access_control holds the policies of a chaincode. A function or key with several policies is
+// accessible if any of them allows it; a key under several restricted prefixes must be allowed for
+// each. Functions and keys without policy are accessible to everyone.
+class access_control
+{
+public:
+    void restrict_function(const std::string& function, const access_policy& policy)
+    {
+        functions_[function].push_back(policy);
+    }
+
+    void restrict_keys(const std::string& prefix, const access_policy& policy)
+    {
+        keys_[prefix].push_back(policy);
+    }
+
+    bool allows_function(const std::string& function, const creator_t* creator) const
+    {
+        auto search = functions_.find(function);
+        return search == functions_.end() || any_allows(search->second, creator);
+    }
+
+    bool allows_key(const std::string& key, const creator_t* creator) const
+    {
+        for (auto& restricted : keys_) {
+            if (key.compare(0, restricted.first.size(), restricted.first) == 0 &&
+                !any_allows(restricted.second, creator)) {
+                return false;
+            }
+        }
+        return true;
+    }
+
+private:
+    static bool any_allows(const std::vector<access_policy>& policies, const creator_t* creator)
+    {
+        for (auto& policy : policies) {
+            if (policy.allows(creator)) {
+                return true;
+            }
+        }
+        return false;
+    }
+
+    std::map<std::string, std::vector<access_policy>> functions_;
+    std::map<std::string, std::vector<access_policy>> keys_;
+};
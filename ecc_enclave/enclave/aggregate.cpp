@@ -0,0 +1,137 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#include "aggregate.h"
+#include "logging.h"
+
+#include "sgx_thread.h"
+#include "sgx_trts.h"
+
+#include <math.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+#include <map>
+
+// prefix of the state key holding the spent budget of a client
+#define DP_BUDGET_KEY_PREFIX "fpc_dp_budget."
+// budgets are accounted in micro epsilon to avoid rounding errors
+#define DP_BUDGET_UNIT 1000000.0
+// bound of the noise, far beyond what a query with DP_MIN_EPSILON draws in practice
+#define DP_MAX_NOISE (1LL << 62)
+
+// budget answered by this enclave per client, including queries that never committed
+static std::map<std::string, uint64_t> answered;
+static sgx_thread_mutex_t answered_mutex = SGX_THREAD_MUTEX_INITIALIZER;
+
+// returns a uniform sample of (0, 1]
+static double uniform()
+{
+    uint64_t r;
+    sgx_read_rand((uint8_t*)&r, sizeof(r));
+    return ((r >> 11) + 1) * (1.0 / (1ULL << 53));
+}
+
+// returns a sample of the geometric distribution P(k) = (1 - alpha) alpha^k for k >= 0
+static double geometric(double log_alpha)
+{
+    return floor(log(uniform()) / log_alpha);
+}
+
+int64_t dp_noise(uint64_t sensitivity, double epsilon)
+{
+    double log_alpha = -epsilon / (double)sensitivity;
+    // the difference of two geometric samples is two-sided geometric
+    double noise = geometric(log_alpha) - geometric(log_alpha);
+    if (noise > DP_MAX_NOISE) {
+        return DP_MAX_NOISE;
+    }
+    if (noise < -DP_MAX_NOISE) {
+        return -DP_MAX_NOISE;
+    }
+    return (int64_t)noise;
+}
+
+static int client_of(void* ctx, std::string& client)
+{
+    if (get_creator_id(ctx, client) == 0) {
+        return 0;
+    }
+    std::string nym;
+    if (get_creator_nym(ctx, nym) == 0) {
+        client = "nym::" + nym;
+        return 0;
+    }
+    return -1;
+}
+
+// returns the budget spent by client as of the state and this enclave
+static uint64_t spent(const std::string& client, void* ctx)
+{
+    std::string key = DP_BUDGET_KEY_PREFIX + client;
+    char value[32] = {0};
+    uint32_t value_len = 0;
+    get_obfuscated_state(key.c_str(), (uint8_t*)value, sizeof(value) - 1, &value_len, ctx);
+    uint64_t committed = value_len == 0 ? 0 : strtoull(value, NULL, 10);
+
+    sgx_thread_mutex_lock(&answered_mutex);
+    uint64_t local = answered[client];
+    sgx_thread_mutex_unlock(&answered_mutex);
+    return committed > local ? committed : local;
+}
+
+bool dp_aggregator::charge(double epsilon)
+{
+    if (!(epsilon >= DP_MIN_EPSILON && epsilon <= DP_MAX_EPSILON)) {
+        LOG_ERROR("Aggregate: epsilon %f out of range", epsilon);
+        return false;
+    }
+
+    std::string client;
+    if (client_of(ctx_, client) != 0) {
+        LOG_ERROR("Aggregate: Queries require a client identity");
+        return false;
+    }
+
+    uint64_t cost = (uint64_t)ceil(epsilon * DP_BUDGET_UNIT);
+    uint64_t total = spent(client, ctx_) + cost;
+    if (total > (uint64_t)(budget_ * DP_BUDGET_UNIT)) {
+        LOG_ERROR("Aggregate: Privacy budget exhausted");
+        return false;
+    }
+
+    std::string key = DP_BUDGET_KEY_PREFIX + client;
+    char value[32];
+    int value_len = snprintf(value, sizeof(value), "%llu", (unsigned long long)total);
+    put_obfuscated_state(key.c_str(), (uint8_t*)value, value_len, ctx_);
+
+    sgx_thread_mutex_lock(&answered_mutex);
+    if (answered[client] < total) {
+        answered[client] = total;
+    }
+    sgx_thread_mutex_unlock(&answered_mutex);
+    return true;
+}
+
+double dp_aggregator::remaining()
+{
+    std::string client;
+    if (client_of(ctx_, client) != 0) {
+        return 0;
+    }
+    double left = budget_ - spent(client, ctx_) / DP_BUDGET_UNIT;
+    return left > 0 ? left : 0;
+}
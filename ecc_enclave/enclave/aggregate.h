@@ -0,0 +1,121 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <algorithm>
+#include <functional>
+#include <string>
+
+#include "shim.h"
+#include "typed_state.h"
+
+// Aggregate queries with differential privacy. The enclave answers counts and sums over the
+// values under a composite key prefix with noise calibrated to the privacy loss epsilon of the
+// query, and charges epsilon to a privacy budget of the client, so that analysts learn statistics
+// but (almost) nothing about individual records, e.g.,
+//
+//   dp_aggregator agg(1.0, ctx);  // a client may spend epsilon 1.0 in total
+//   int64_t bids, volume;
+//   agg.count<bid_t, json_codec<bid_t>>(bid_composite_key, 0.1, bids);
+//   agg.sum<bid_t, json_codec<bid_t>>(bid_composite_key, 0.1, 0, 1000,
+//       [](const bid_t& bid) { return (int64_t)bid.value; }, volume);
+//
+// Noise follows the two-sided geometric distribution, i.e., the discrete Laplace mechanism, with
+// scale sensitivity/epsilon. Sums clamp each value to [lower, upper], which bounds the sensitivity.
+// Budgets are kept per creator id (or pseudonym, see get_creator_nym) in the state under an
+// obfuscated key, thus, they are spent only if the transaction commits. The enclave additionally
+// remembers what it answered, so repeating a query without submitting it does not help on the
+// same enclave; other enclaves of the chaincode only learn the committed spending.
+
+// epsilon of a query must be in [DP_MIN_EPSILON, DP_MAX_EPSILON]
+#define DP_MIN_EPSILON 0.001
+#define DP_MAX_EPSILON 10.0
+
+// dp_noise samples from the two-sided geometric distribution with P(k) ~ exp(-|k| epsilon /
+// sensitivity), which makes a query of the given sensitivity epsilon-differentially private
+int64_t dp_noise(uint64_t sensitivity, double epsilon);
+
+class dp_aggregator
+{
+public:
+    dp_aggregator(double budget, void* ctx) : budget_(budget), ctx_(ctx) {}
+
+    // count sets result to the noisy number of values under comp_key; returns false if the budget
+    // of the client does not cover epsilon or a value is malformed
+    template <typename T, typename Codec>
+    bool count(const std::string& comp_key, double epsilon, int64_t& result,
+        state_keys keys = PLAIN_KEYS)
+    {
+        if (!charge(epsilon)) {
+            return false;
+        }
+
+        int64_t n = 0;
+        typed_state_iterator<T, Codec> it(comp_key, ctx_, keys);
+        std::string key;
+        T value;
+        while (it.next(key, value)) {
+            n++;
+        }
+        if (!it.ok()) {
+            return false;
+        }
+        result = n + dp_noise(1, epsilon);
+        return true;
+    }
+
+    // sum sets result to the noisy sum of value_of over the values under comp_key, each clamped
+    // to [lower, upper]; returns false if the budget of the client does not cover epsilon or a
+    // value is malformed
+    template <typename T, typename Codec>
+    bool sum(const std::string& comp_key, double epsilon, int64_t lower, int64_t upper,
+        const std::function<int64_t(const T&)>& value_of, int64_t& result,
+        state_keys keys = PLAIN_KEYS)
+    {
+        if (lower > upper || !charge(epsilon)) {
+            return false;
+        }
+
+        int64_t total = 0;
+        typed_state_iterator<T, Codec> it(comp_key, ctx_, keys);
+        std::string key;
+        T value;
+        while (it.next(key, value)) {
+            int64_t v = value_of(value);
+            total += v < lower ? lower : (v > upper ? upper : v);
+        }
+        if (!it.ok()) {
+            return false;
+        }
+        uint64_t sensitivity = std::max(dp_abs(lower), dp_abs(upper));
+        result = total + dp_noise(sensitivity == 0 ? 1 : sensitivity, epsilon);
+        return true;
+    }
+
+    // remaining returns the budget the client has left
+    double remaining();
+
+private:
+    static uint64_t dp_abs(int64_t v) { return v < 0 ? -(uint64_t)v : v; }
+
+    // charge spends epsilon of the budget of the client; returns false if the client is unknown,
+    // epsilon is out of range or the budget does not cover it
+    bool charge(double epsilon);
+
+    double budget_;
+    void* ctx_;
+};
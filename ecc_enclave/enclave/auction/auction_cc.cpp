@@ -16,6 +16,7 @@
 
 #include "auction_cc.h"
 #include "auction_json.h"
+#include "chaincode.h"
 #include "logging.h"
 #include "shim.h"
 
@@ -80,6 +81,18 @@ int invoke(const char* args, uint8_t* response, uint32_t max_response_len,
     return 0;
 }
 
+// everyone may bid; see abac.h to restrict functions or state to creators with certain attributes
+const access_control* get_access_control()
+{
+    return NULL;
+}
+
+// the default limits allow auctions with a few thousand bids, see limits.h
+const request_limits* get_request_limits()
+{
+    return NULL;
+}
+
 std::string auction_create(std::string auction_name, void* ctx)
 {
     // check if auction already exists
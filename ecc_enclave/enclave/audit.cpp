@@ -0,0 +1,268 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#include "enclave_t.h"
+
+#include "audit.h"
+#include "crypto.h"
+#include "logging.h"
+#include "shim.h"
+#include "utils.h"
+
+#include "base64.h"
+#include "parson.h"
+
+#include "sgx_thread.h"
+#include "sgx_trts.h"
+
+#include <map>
+#include <set>
+#include <vector>
+
+#define AUDIT_RECORD_ID_SIZE 16
+
+extern sgx_ec256_private_t enclave_sk;
+extern sgx_ec256_public_t enclave_pk;
+
+// keys decrypted by invocation ctx
+static std::map<void*, std::set<std::string>> decryptions;
+// records of operations outside of invocations, not yet handed out
+static std::vector<std::string> pending;
+// numbers the records of this enclave instance
+static uint64_t audit_sequence = 0;
+static sgx_thread_mutex_t audit_mutex = SGX_THREAD_MUTEX_INITIALIZER;
+
+static std::string to_hex(const uint8_t* bytes, uint32_t len)
+{
+    static const char* digits = "0123456789abcdef";
+    std::string hex;
+    for (uint32_t i = 0; i < len; i++) {
+        hex += digits[bytes[i] >> 4];
+        hex += digits[bytes[i] & 0xf];
+    }
+    return hex;
+}
+
+// encrypts record to the auditor pk (big endian) with an ephemeral key pair; returns the ephemeral
+// pk (big endian) and the ciphertext in the format of encrypt_state
+static int encrypt_to_auditor(const std::string& auditor_pk, const std::string& record,
+    std::string& ephemeral_pk, std::string& cipher)
+{
+    sgx_ec256_private_t sk;
+    sgx_ec256_public_t pk;
+    sgx_ecc_state_handle_t ecc_handle = NULL;
+    sgx_ecc256_open_context(&ecc_handle);
+    int sgx_ret = sgx_ecc256_create_key_pair(&sk, &pk, ecc_handle);
+    sgx_ecc256_close_context(ecc_handle);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    sgx_aes_gcm_128bit_key_t key;
+    sgx_ret = derive_shared_key_with(&sk, (const uint8_t*)auditor_pk.c_str(), &key);
+    memset(&sk, 0, sizeof(sk));
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    uint32_t cipher_len = record.size() + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE;
+    cipher.assign(cipher_len, '\0');
+    sgx_ret = encrypt_state(
+        &key, (uint8_t*)record.c_str(), record.size(), (uint8_t*)&cipher[0], cipher_len);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    bytes_swap(&pk.gx, 32);
+    bytes_swap(&pk.gy, 32);
+    ephemeral_pk.assign((const char*)&pk, sizeof(pk));
+    return SGX_SUCCESS;
+}
+
+// seals record into an audit record:
+// {"id": hex, "enclave_pk": base64(pk), "record": base64(record or its ciphertext),
+//  "ephemeral_pk": base64(pk), "signature": base64(sig)}
+// where the signature covers SHA256(id || ephemeral_pk || record) and ephemeral_pk is only set if
+// the record is encrypted to the auditor
+static int seal_record(JSON_Value* record_value, std::string& id, std::string& audit_record)
+{
+    sgx_thread_mutex_lock(&audit_mutex);
+    json_object_set_number(json_value_get_object(record_value), "seq", (double)audit_sequence++);
+    sgx_thread_mutex_unlock(&audit_mutex);
+    char* serialized = json_serialize_to_string(record_value);
+    std::string record(serialized);
+    json_free_serialized_string(serialized);
+
+    uint8_t id_bytes[AUDIT_RECORD_ID_SIZE];
+    sgx_read_rand(id_bytes, sizeof(id_bytes));
+    id = to_hex(id_bytes, sizeof(id_bytes));
+
+    std::string auditor_pk, ephemeral_pk;
+    if (get_secret(AUDIT_PK_SECRET, auditor_pk) == 0) {
+        if (auditor_pk.size() != sizeof(sgx_ec256_public_t)) {
+            LOG_ERROR("Audit: Invalid auditor pk");
+            return SGX_ERROR_INVALID_PARAMETER;
+        }
+        std::string cipher;
+        int sgx_ret = encrypt_to_auditor(auditor_pk, record, ephemeral_pk, cipher);
+        if (sgx_ret != SGX_SUCCESS) {
+            LOG_ERROR("Audit: Cannot encrypt record: %d", sgx_ret);
+            return sgx_ret;
+        }
+        record = cipher;
+    }
+
+    sgx_sha256_hash_t hash;
+    sgx_sha_state_handle_t sha_handle = NULL;
+    sgx_sha256_init(&sha_handle);
+    sgx_sha256_update((const uint8_t*)id.c_str(), id.size(), sha_handle);
+    sgx_sha256_update((const uint8_t*)ephemeral_pk.c_str(), ephemeral_pk.size(), sha_handle);
+    sgx_sha256_update((const uint8_t*)record.c_str(), record.size(), sha_handle);
+    sgx_sha256_get_hash(sha_handle, &hash);
+    sgx_sha256_close(sha_handle);
+
+    uint8_t sig[sizeof(sgx_ec256_signature_t)];
+    sgx_ecc_state_handle_t ecc_handle = NULL;
+    sgx_ecc256_open_context(&ecc_handle);
+    int sgx_ret = sgx_ecdsa_sign((uint8_t*)&hash, SGX_SHA256_HASH_SIZE, &enclave_sk,
+        (sgx_ec256_signature_t*)sig, ecc_handle);
+    sgx_ecc256_close_context(ecc_handle);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Audit: Signing failed: %d", sgx_ret);
+        return sgx_ret;
+    }
+    bytes_swap(sig, 32);
+    bytes_swap(sig + 32, 32);
+
+    uint8_t pk[sizeof(sgx_ec256_public_t)];
+    memcpy(pk, &enclave_pk, sizeof(pk));
+    bytes_swap(pk, 32);
+    bytes_swap(pk + 32, 32);
+
+    JSON_Value* root_value = json_value_init_object();
+    JSON_Object* root_object = json_value_get_object(root_value);
+    json_object_set_string(root_object, "id", id.c_str());
+    json_object_set_string(root_object, "enclave_pk", base64_encode(pk, sizeof(pk)).c_str());
+    json_object_set_string(root_object, "record",
+        base64_encode((const unsigned char*)record.c_str(), record.size()).c_str());
+    if (!ephemeral_pk.empty()) {
+        json_object_set_string(root_object, "ephemeral_pk",
+            base64_encode((const unsigned char*)ephemeral_pk.c_str(), ephemeral_pk.size())
+                .c_str());
+    }
+    json_object_set_string(
+        root_object, "signature", base64_encode(sig, sizeof(sig)).c_str());
+    serialized = json_serialize_to_string(root_value);
+    audit_record = serialized;
+    json_free_serialized_string(serialized);
+    json_value_free(root_value);
+    return SGX_SUCCESS;
+}
+
+void audit_decryption(void* ctx, const std::string& key)
+{
+    sgx_thread_mutex_lock(&audit_mutex);
+    decryptions[ctx].insert(key);
+    sgx_thread_mutex_unlock(&audit_mutex);
+}
+
+void audit_discard(void* ctx)
+{
+    sgx_thread_mutex_lock(&audit_mutex);
+    decryptions.erase(ctx);
+    sgx_thread_mutex_unlock(&audit_mutex);
+}
+
+int audit_flush(void* ctx)
+{
+    sgx_thread_mutex_lock(&audit_mutex);
+    std::set<std::string> keys = decryptions[ctx];
+    decryptions.erase(ctx);
+    sgx_thread_mutex_unlock(&audit_mutex);
+    if (keys.empty()) {
+        return SGX_SUCCESS;
+    }
+
+    // {"op": "decrypt_state", "keys": [...], "creator": <creator id>, "nym": <pseudonym>}
+    JSON_Value* record_value = json_value_init_object();
+    JSON_Object* record_object = json_value_get_object(record_value);
+    json_object_set_string(record_object, "op", "decrypt_state");
+    JSON_Value* keys_value = json_value_init_array();
+    for (auto& key : keys) {
+        json_array_append_string(json_value_get_array(keys_value), key.c_str());
+    }
+    json_object_set_value(record_object, "keys", keys_value);
+    std::string creator_id, nym;
+    if (get_creator_id(ctx, creator_id) == 0) {
+        json_object_set_string(record_object, "creator", creator_id.c_str());
+    }
+    if (get_creator_nym(ctx, nym) == 0) {
+        json_object_set_string(record_object, "nym", nym.c_str());
+    }
+
+    std::string id, audit_record;
+    int sgx_ret = seal_record(record_value, id, audit_record);
+    json_value_free(record_value);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    std::string key = std::string(".") + AUDIT_OBJECT_TYPE + "." + id + ".";
+    put_public_state(key.c_str(), (const uint8_t*)audit_record.c_str(), audit_record.size(), ctx);
+    return SGX_SUCCESS;
+}
+
+int audit_operation(const std::string& op, const std::string& subject)
+{
+    // {"op": <op>, "subject": <subject>}
+    JSON_Value* record_value = json_value_init_object();
+    JSON_Object* record_object = json_value_get_object(record_value);
+    json_object_set_string(record_object, "op", op.c_str());
+    json_object_set_string(record_object, "subject", subject.c_str());
+
+    std::string id, audit_record;
+    int sgx_ret = seal_record(record_value, id, audit_record);
+    json_value_free(record_value);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    sgx_thread_mutex_lock(&audit_mutex);
+    pending.push_back(audit_record);
+    sgx_thread_mutex_unlock(&audit_mutex);
+    return SGX_SUCCESS;
+}
+
+std::string audit_drain(uint32_t max_len)
+{
+    std::string out = "[";
+    sgx_thread_mutex_lock(&audit_mutex);
+    size_t n = 0;
+    for (; n < pending.size(); n++) {
+        // separator and closing bracket
+        if (out.size() + pending[n].size() + 2 > max_len) {
+            break;
+        }
+        if (n > 0) {
+            out += ",";
+        }
+        out += pending[n];
+    }
+    pending.erase(pending.begin(), pending.begin() + n);
+    sgx_thread_mutex_unlock(&audit_mutex);
+    out += "]";
+    return out;
+}
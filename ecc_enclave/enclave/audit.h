@@ -0,0 +1,41 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <cstdint>
+#include <string>
+
+// Audit log of the decryption and key release operations of the enclave. Each record is signed by
+// the enclave and, if an auditor key is provisioned as secret AUDIT_PK_SECRET (P-256 pk, X || Y
+// big endian), encrypted to the auditor. Records are stored on the ledger under the composite key
+// AUDIT_OBJECT_TYPE.<record id>, see ecc/crypto/audit.go for their format and verification.
+#define AUDIT_PK_SECRET "audit_pk"
+#define AUDIT_OBJECT_TYPE "fpc_audit"
+
+// notes that the invocation of ctx decrypted the value of key (as stored on the ledger)
+void audit_decryption(void* ctx, const std::string& key);
+// writes the record of the decryptions of the invocation of ctx, if any, to its write set, so it
+// commits along with the transaction; an invocation whose record fails must not be endorsed
+int audit_flush(void* ctx);
+// forgets the decryptions of the invocation of ctx, e.g., if it failed
+void audit_discard(void* ctx);
+
+// queues the record of an operation outside of invocations, e.g., provisioning a secret
+int audit_operation(const std::string& op, const std::string& subject);
+// hands out queued records as json array of at most max_len bytes and forgets them; records that
+// do not fit stay queued
+std::string audit_drain(uint32_t max_len);
@@ -16,7 +16,16 @@
 
 #pragma once
 
-int invoke_enc(const char *args, const char *pk, uint8_t *response, uint32_t max_response_len,
-    uint32_t *actual_response_len, void *ctx);
+#include "limits.h"
+
+class access_control;
+
 int invoke(const char *args, uint8_t *response, uint32_t max_response_len,
     uint32_t *actual_response_len, void *ctx);
+
+// access policies of the chaincode, see abac.h; NULL if everyone may invoke all functions and access
+// all state
+const access_control* get_access_control();
+
+// limits of a single invocation, see limits.h; NULL for the defaults
+const request_limits* get_request_limits();
@@ -0,0 +1,457 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#include "compress.h"
+
+#include <string.h>
+
+#define MAX_BITS 15
+#define MAX_LIT_CODES 286
+#define MAX_DIST_CODES 30
+#define FIXED_LIT_CODES 288
+#define WINDOW_SIZE 32768
+#define MIN_MATCH 3
+#define MAX_MATCH 258
+#define HASH_BITS 14
+
+// base lengths and extra bits of length codes 257..285
+static const uint16_t length_base[29] = {3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+    35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258};
+static const uint8_t length_extra[29] = {
+    0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0};
+// base distances and extra bits of distance codes 0..29
+static const uint16_t dist_base[30] = {1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+    257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577};
+static const uint8_t dist_extra[30] = {
+    0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13};
+
+static uint32_t adler32(const std::string& data)
+{
+    uint32_t a = 1, b = 0;
+    for (size_t i = 0; i < data.size(); i++) {
+        a = (a + (uint8_t)data[i]) % 65521;
+        b = (b + a) % 65521;
+    }
+    return (b << 16) | a;
+}
+
+// inflate, after puff.c of the zlib distribution
+
+typedef struct
+{
+    const uint8_t* p;
+    const uint8_t* end;
+    uint32_t bit_buf;
+    int bit_count;
+    bool error;
+} bit_reader_t;
+
+static int read_bits(bit_reader_t& r, int n)
+{
+    while (r.bit_count < n) {
+        if (r.p == r.end) {
+            r.error = true;
+            return 0;
+        }
+        r.bit_buf |= (uint32_t)*r.p++ << r.bit_count;
+        r.bit_count += 8;
+    }
+    int bits = r.bit_buf & ((1u << n) - 1);
+    r.bit_buf >>= n;
+    r.bit_count -= n;
+    return bits;
+}
+
+typedef struct
+{
+    // number of codes of each length
+    uint16_t count[MAX_BITS + 1];
+    // symbols ordered by code
+    uint16_t symbol[FIXED_LIT_CODES];
+} huffman_t;
+
+// builds the canonical code of the code lengths; returns 0 for a complete code, a positive number
+// for an incomplete one and -1 for an over-subscribed one
+static int build_huffman(huffman_t& h, const uint8_t* lengths, int n)
+{
+    memset(h.count, 0, sizeof(h.count));
+    for (int i = 0; i < n; i++) {
+        h.count[lengths[i]]++;
+    }
+    if (h.count[0] == n) {
+        return 0;
+    }
+    int left = 1;
+    for (int len = 1; len <= MAX_BITS; len++) {
+        left <<= 1;
+        left -= h.count[len];
+        if (left < 0) {
+            return -1;
+        }
+    }
+    uint16_t offset[MAX_BITS + 1];
+    offset[1] = 0;
+    for (int len = 1; len < MAX_BITS; len++) {
+        offset[len + 1] = offset[len] + h.count[len];
+    }
+    for (int i = 0; i < n; i++) {
+        if (lengths[i] != 0) {
+            h.symbol[offset[lengths[i]]++] = i;
+        }
+    }
+    return left;
+}
+
+static int decode_symbol(bit_reader_t& r, const huffman_t& h)
+{
+    int code = 0, first = 0, index = 0;
+    for (int len = 1; len <= MAX_BITS; len++) {
+        code |= read_bits(r, 1);
+        int count = h.count[len];
+        if (code - count < first) {
+            return h.symbol[index + (code - first)];
+        }
+        index += count;
+        first += count;
+        first <<= 1;
+        code <<= 1;
+        if (r.error) {
+            return -1;
+        }
+    }
+    return -1;
+}
+
+static int inflate_codes(
+    bit_reader_t& r, const huffman_t& lit, const huffman_t& dist, std::string& out, size_t max_out)
+{
+    while (true) {
+        int symbol = decode_symbol(r, lit);
+        if (symbol < 0 || r.error) {
+            return -1;
+        }
+        if (symbol < 256) {
+            if (out.size() >= max_out) {
+                return -1;
+            }
+            out.push_back((char)symbol);
+            continue;
+        }
+        if (symbol == 256) {
+            return 0;
+        }
+        symbol -= 257;
+        if (symbol >= 29) {
+            return -1;
+        }
+        size_t len = length_base[symbol] + read_bits(r, length_extra[symbol]);
+        symbol = decode_symbol(r, dist);
+        if (symbol < 0 || symbol >= 30) {
+            return -1;
+        }
+        size_t distance = dist_base[symbol] + read_bits(r, dist_extra[symbol]);
+        if (r.error || distance > out.size() || out.size() + len > max_out) {
+            return -1;
+        }
+        // byte by byte, as the match may overlap what it copies
+        size_t from = out.size() - distance;
+        for (size_t i = 0; i < len; i++) {
+            out.push_back(out[from + i]);
+        }
+    }
+}
+
+static int inflate_stored(bit_reader_t& r, std::string& out, size_t max_out)
+{
+    // stored blocks start at a byte boundary
+    r.bit_buf = 0;
+    r.bit_count = 0;
+    if (r.end - r.p < 4) {
+        return -1;
+    }
+    uint16_t len = r.p[0] | (r.p[1] << 8);
+    uint16_t nlen = r.p[2] | (r.p[3] << 8);
+    r.p += 4;
+    if (len != (uint16_t)~nlen || r.end - r.p < len || out.size() + len > max_out) {
+        return -1;
+    }
+    out.append((const char*)r.p, len);
+    r.p += len;
+    return 0;
+}
+
+static int inflate_fixed(bit_reader_t& r, std::string& out, size_t max_out)
+{
+    uint8_t lengths[FIXED_LIT_CODES];
+    int i = 0;
+    for (; i < 144; i++) {
+        lengths[i] = 8;
+    }
+    for (; i < 256; i++) {
+        lengths[i] = 9;
+    }
+    for (; i < 280; i++) {
+        lengths[i] = 7;
+    }
+    for (; i < FIXED_LIT_CODES; i++) {
+        lengths[i] = 8;
+    }
+    huffman_t lit, dist;
+    build_huffman(lit, lengths, FIXED_LIT_CODES);
+    for (i = 0; i < MAX_DIST_CODES; i++) {
+        lengths[i] = 5;
+    }
+    build_huffman(dist, lengths, MAX_DIST_CODES);
+    return inflate_codes(r, lit, dist, out, max_out);
+}
+
+static int inflate_dynamic(bit_reader_t& r, std::string& out, size_t max_out)
+{
+    static const uint8_t order[19] = {
+        16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15};
+
+    int nlen = read_bits(r, 5) + 257;
+    int ndist = read_bits(r, 5) + 1;
+    int ncode = read_bits(r, 4) + 4;
+    if (r.error || nlen > MAX_LIT_CODES || ndist > MAX_DIST_CODES) {
+        return -1;
+    }
+
+    uint8_t lengths[MAX_LIT_CODES + MAX_DIST_CODES];
+    memset(lengths, 0, sizeof(lengths));
+    for (int i = 0; i < ncode; i++) {
+        lengths[order[i]] = read_bits(r, 3);
+    }
+    huffman_t code_lengths;
+    if (r.error || build_huffman(code_lengths, lengths, 19) != 0) {
+        return -1;
+    }
+
+    int index = 0;
+    while (index < nlen + ndist) {
+        int symbol = decode_symbol(r, code_lengths);
+        if (symbol < 0 || r.error) {
+            return -1;
+        }
+        if (symbol < 16) {
+            lengths[index++] = symbol;
+            continue;
+        }
+        uint8_t len = 0;
+        int repeat;
+        if (symbol == 16) {
+            if (index == 0) {
+                return -1;
+            }
+            len = lengths[index - 1];
+            repeat = 3 + read_bits(r, 2);
+        } else if (symbol == 17) {
+            repeat = 3 + read_bits(r, 3);
+        } else {
+            repeat = 11 + read_bits(r, 7);
+        }
+        if (r.error || index + repeat > nlen + ndist) {
+            return -1;
+        }
+        while (repeat-- > 0) {
+            lengths[index++] = len;
+        }
+    }
+    // a block without end of block code can not end
+    if (lengths[256] == 0) {
+        return -1;
+    }
+
+    huffman_t lit, dist;
+    int ret = build_huffman(lit, lengths, nlen);
+    if (ret < 0 || (ret > 0 && nlen - lit.count[0] != 1)) {
+        return -1;
+    }
+    ret = build_huffman(dist, lengths + nlen, ndist);
+    if (ret < 0 || (ret > 0 && ndist - dist.count[0] != 1)) {
+        return -1;
+    }
+    return inflate_codes(r, lit, dist, out, max_out);
+}
+
+int zlib_inflate(const std::string& in, std::string& out, size_t max_out)
+{
+    // CMF and FLG: deflate with a window of at most 32KB and no preset dictionary
+    if (in.size() < 6) {
+        return -1;
+    }
+    uint8_t cmf = in[0], flg = in[1];
+    if ((cmf & 0x0f) != 8 || (cmf >> 4) > 7 || (flg & 0x20) != 0 || ((cmf << 8) | flg) % 31 != 0) {
+        return -1;
+    }
+
+    bit_reader_t r = {(const uint8_t*)in.c_str() + 2, (const uint8_t*)in.c_str() + in.size(), 0, 0,
+        false};
+    std::string _out;
+    int last;
+    do {
+        last = read_bits(r, 1);
+        int type = read_bits(r, 2);
+        int ret = -1;
+        if (r.error) {
+            return -1;
+        } else if (type == 0) {
+            ret = inflate_stored(r, _out, max_out);
+        } else if (type == 1) {
+            ret = inflate_fixed(r, _out, max_out);
+        } else if (type == 2) {
+            ret = inflate_dynamic(r, _out, max_out);
+        }
+        if (ret != 0) {
+            return -1;
+        }
+    } while (!last);
+
+    // the adler32 checksum follows at the next byte boundary, i.e., bits left are dropped
+    if (r.end - r.p != 4) {
+        return -1;
+    }
+    uint32_t checksum = ((uint32_t)r.p[0] << 24) | (r.p[1] << 16) | (r.p[2] << 8) | r.p[3];
+    if (checksum != adler32(_out)) {
+        return -1;
+    }
+    out.swap(_out);
+    return 0;
+}
+
+// deflate with LZ77 matches of the most recent position of each 3 byte hash and fixed Huffman codes
+
+typedef struct
+{
+    std::string& out;
+    uint32_t bit_buf;
+    int bit_count;
+} bit_writer_t;
+
+static void write_bits(bit_writer_t& w, uint32_t bits, int n)
+{
+    w.bit_buf |= bits << w.bit_count;
+    w.bit_count += n;
+    while (w.bit_count >= 8) {
+        w.out.push_back((char)(w.bit_buf & 0xff));
+        w.bit_buf >>= 8;
+        w.bit_count -= 8;
+    }
+}
+
+// Huffman codes are packed starting with their most significant bit
+static void write_code(bit_writer_t& w, uint32_t code, int len)
+{
+    uint32_t reversed = 0;
+    for (int i = 0; i < len; i++) {
+        reversed = (reversed << 1) | ((code >> i) & 1);
+    }
+    write_bits(w, reversed, len);
+}
+
+static void write_literal(bit_writer_t& w, int symbol)
+{
+    if (symbol < 144) {
+        write_code(w, 0x30 + symbol, 8);
+    } else if (symbol < 256) {
+        write_code(w, 0x190 + symbol - 144, 9);
+    } else if (symbol < 280) {
+        write_code(w, symbol - 256, 7);
+    } else {
+        write_code(w, 0xc0 + symbol - 280, 8);
+    }
+}
+
+static void write_match(bit_writer_t& w, size_t len, size_t distance)
+{
+    int i = 28;
+    while (length_base[i] > len) {
+        i--;
+    }
+    write_literal(w, 257 + i);
+    write_bits(w, len - length_base[i], length_extra[i]);
+
+    int j = 29;
+    while (dist_base[j] > distance) {
+        j--;
+    }
+    write_code(w, j, 5);
+    write_bits(w, distance - dist_base[j], dist_extra[j]);
+}
+
+static uint32_t hash3(const uint8_t* p)
+{
+    return ((p[0] << 16 | p[1] << 8 | p[2]) * 2654435761u) >> (32 - HASH_BITS);
+}
+
+void zlib_deflate(const std::string& in, std::string& out)
+{
+    out.clear();
+    // deflate, 32KB window, default compression level
+    out.push_back((char)0x78);
+    out.push_back((char)0x9c);
+
+    bit_writer_t w = {out, 0, 0};
+    // a single final block with fixed codes
+    write_bits(w, 1, 1);
+    write_bits(w, 1, 2);
+
+    const uint8_t* data = (const uint8_t*)in.c_str();
+    size_t n = in.size();
+    // most recent position + 1 of each hash, 0 for none
+    std::string head_buf((1 << HASH_BITS) * sizeof(uint32_t), '\0');
+    uint32_t* head = (uint32_t*)&head_buf[0];
+    size_t i = 0;
+    while (i < n) {
+        size_t best_len = 0, best_distance = 0;
+        if (i + MIN_MATCH <= n) {
+            uint32_t h = hash3(data + i);
+            size_t candidate = head[h];
+            head[h] = i + 1;
+            if (candidate > 0 && i - (candidate - 1) <= WINDOW_SIZE) {
+                size_t from = candidate - 1;
+                size_t len = 0;
+                while (len < MAX_MATCH && i + len < n && data[from + len] == data[i + len]) {
+                    len++;
+                }
+                if (len >= MIN_MATCH) {
+                    best_len = len;
+                    best_distance = i - from;
+                }
+            }
+        }
+        if (best_len == 0) {
+            write_literal(w, data[i]);
+            i++;
+            continue;
+        }
+        write_match(w, best_len, best_distance);
+        // index the positions within the match
+        for (size_t k = i + 1; k < i + best_len && k + MIN_MATCH <= n; k++) {
+            head[hash3(data + k)] = k + 1;
+        }
+        i += best_len;
+    }
+    write_literal(w, 256);
+    if (w.bit_count > 0) {
+        write_bits(w, 0, 8 - w.bit_count);
+    }
+
+    uint32_t checksum = adler32(in);
+    for (int shift = 24; shift >= 0; shift -= 8) {
+        out.push_back((char)(checksum >> shift));
+    }
+}
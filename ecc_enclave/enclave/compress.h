@@ -0,0 +1,37 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <cstdint>
+#include <string>
+
+// zlib (RFC 1950) compression of request args and responses, see "Compression" in the README.
+// Clients compress args larger than a few hundred bytes, e.g., JSON documents, before encrypting
+// them and accept compressed responses; the enclave inflates the args after decryption and
+// deflates the response before encrypting it. Deflating only uses fixed Huffman codes, which
+// keeps the code small; inflating handles all of deflate (RFC 1951).
+
+#define COMPRESSION_ZLIB "zlib"
+// args inflate to this many bytes at most, so a small request can not exhaust the enclave heap
+#define COMPRESSION_MAX_INFLATED_SIZE (256 * 1024)
+
+// inflates the zlib stream in; returns 0 on success and -1 if the stream is malformed, fails its
+// checksum or inflates to more than max_out bytes
+int zlib_inflate(const std::string& in, std::string& out, size_t max_out);
+
+// deflates in to a zlib stream
+void zlib_deflate(const std::string& in, std::string& out);
@@ -16,9 +16,11 @@
 
 #include "crypto.h"
 #include "logging.h"
+#include "utils.h"
 
 #include <assert.h>
 #include <string.h>  // for memcpy etc
+#include <string>
 
 #include "sgx_trts.h"
 
@@ -35,7 +37,7 @@ int check_cmac(const char *key, uint8_t *nonce, sgx_sha256_hash_t *state_hash,
     sgx_cmac128_final(cmac_handle, &tmp_cmac);
     sgx_cmac128_close(cmac_handle);
 
-    if (memcmp(&tmp_cmac, cmac, sizeof(sgx_cmac_128bit_tag_t)) != 0) {
+    if (!consttime_memequal(&tmp_cmac, cmac, sizeof(sgx_cmac_128bit_tag_t))) {
         LOG_ERROR("VIOLATION Oh oh! cmac does not match!");
         return -1;
     }
@@ -43,7 +45,7 @@ int check_cmac(const char *key, uint8_t *nonce, sgx_sha256_hash_t *state_hash,
 }
 
 int encrypt_state(sgx_aes_gcm_128bit_key_t *key, uint8_t *plain, uint32_t plain_len,
-    uint8_t *cipher, uint32_t cipher_len)
+    uint8_t *cipher, uint32_t cipher_len, const uint8_t *aad, uint32_t aad_len)
 {
     // create buffer
     uint32_t needed_size = plain_len + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE;
@@ -54,12 +56,47 @@ int encrypt_state(sgx_aes_gcm_128bit_key_t *key, uint8_t *plain, uint32_t plain_
 
     // encrypt
     return sgx_rijndael128GCM_encrypt(key, plain, plain_len,
-        cipher + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE, cipher, SGX_AESGCM_IV_SIZE, NULL, 0,
+        cipher + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE, cipher, SGX_AESGCM_IV_SIZE, aad, aad_len,
+        (sgx_aes_gcm_128bit_tag_t *)(cipher + SGX_AESGCM_IV_SIZE));
+}
+
+int encrypt_state_deterministic(sgx_aes_gcm_128bit_key_t *key, const uint8_t *nonce_key,
+    uint32_t nonce_key_len, const char *ledger_key, uint8_t *plain, uint32_t plain_len,
+    uint8_t *cipher, uint32_t cipher_len, const uint8_t *aad, uint32_t aad_len)
+{
+    // create buffer
+    uint32_t needed_size = plain_len + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE;
+    assert(cipher_len >= needed_size);
+
+    // iv <- hmac(nonce key, len(ledger key) || ledger key || len(aad) || aad || plain); a nonce
+    // repeats only for the same value under the same key, which then yields the same ciphertext
+    uint32_t ledger_key_len = strlen(ledger_key);
+    std::string msg;
+    msg.append((const char *)&ledger_key_len, sizeof(ledger_key_len));
+    msg.append(ledger_key, ledger_key_len);
+    msg.append((const char *)&aad_len, sizeof(aad_len));
+    if (aad_len > 0) {
+        msg.append((const char *)aad, aad_len);
+    }
+    msg.append((const char *)plain, plain_len);
+
+    uint8_t mac[SGX_SHA256_HASH_SIZE];
+    int sgx_ret = sgx_hmac_sha256_msg((const unsigned char *)msg.data(), msg.size(), nonce_key,
+        nonce_key_len, mac, sizeof(mac));
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Derive state iv error: %x", sgx_ret);
+        return sgx_ret;
+    }
+    memcpy(cipher, mac, SGX_AESGCM_IV_SIZE);
+
+    // encrypt
+    return sgx_rijndael128GCM_encrypt(key, plain, plain_len,
+        cipher + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE, cipher, SGX_AESGCM_IV_SIZE, aad, aad_len,
         (sgx_aes_gcm_128bit_tag_t *)(cipher + SGX_AESGCM_IV_SIZE));
 }
 
 int decrypt_state(sgx_aes_gcm_128bit_key_t *key, uint8_t *cipher, uint32_t cipher_len,
-    uint8_t *plain, uint32_t plain_len)
+    uint8_t *plain, uint32_t plain_len, const uint8_t *aad, uint32_t aad_len)
 {
     // create buffer
     uint32_t needed_size = cipher_len - SGX_AESGCM_IV_SIZE - SGX_AESGCM_MAC_SIZE;
@@ -70,6 +107,39 @@ int decrypt_state(sgx_aes_gcm_128bit_key_t *key, uint8_t *cipher, uint32_t ciphe
         cipher + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE,          /* cipher */
         plain_len, plain,                                           /* plain out */
         cipher, SGX_AESGCM_IV_SIZE,                                 /* nonce */
-        NULL, 0,                                                    /* aad */
+        aad, aad_len,                                               /* aad */
         (sgx_aes_gcm_128bit_tag_t *)(cipher + SGX_AESGCM_IV_SIZE)); /* tag */
 }
+
+// derives an aes key from a sk and a pk (big endian) via ecdh
+int derive_shared_key_with(
+    const sgx_ec256_private_t *sk, const uint8_t *pk_be, sgx_aes_gcm_128bit_key_t *key)
+{
+    sgx_ec256_public_t client_pk = {0};
+
+    uint8_t pk_bytes[sizeof(sgx_ec256_public_t)];
+    memcpy(pk_bytes, pk_be, sizeof(sgx_ec256_public_t));
+    bytes_swap(pk_bytes, 32);
+    bytes_swap(pk_bytes + 32, 32);
+    memcpy(&client_pk, pk_bytes, sizeof(sgx_ec256_public_t));
+
+    sgx_ec256_dh_shared_t shared_dhkey;
+
+    sgx_ecc_state_handle_t ecc_handle = NULL;
+    sgx_ecc256_open_context(&ecc_handle);
+    int sgx_ret = sgx_ecc256_compute_shared_dhkey(
+        (sgx_ec256_private_t *)sk, &client_pk, &shared_dhkey, ecc_handle);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Compute shared dhkey: %d\n", sgx_ret);
+        return sgx_ret;
+    }
+    sgx_ecc256_close_context(ecc_handle);
+    bytes_swap(&shared_dhkey, 32);
+
+    sgx_sha256_hash_t h;
+    sgx_sha256_msg(
+        (const uint8_t *)&shared_dhkey, sizeof(sgx_ec256_dh_shared_t), (sgx_sha256_hash_t *)&h);
+
+    memcpy(key, h, sizeof(sgx_aes_gcm_128bit_key_t));
+    return SGX_SUCCESS;
+}
@@ -20,7 +20,16 @@
 
 int check_cmac(const char *key, uint8_t *nonce, sgx_sha256_hash_t *state_hash,
     sgx_cmac_128bit_key_t *cmac_key, sgx_cmac_128bit_tag_t *cmac);
+// aad is authenticated but not encrypted; the same aad must be given for decryption
 int encrypt_state(sgx_aes_gcm_128bit_key_t *key, uint8_t *plain, uint32_t plain_len,
-    uint8_t *cipher, uint32_t cipher_len);
+    uint8_t *cipher, uint32_t cipher_len, const uint8_t *aad = NULL, uint32_t aad_len = 0);
+// like encrypt_state but derives the iv from nonce_key, the ledger key, the aad and the plaintext
+// instead of drawing it at random; thus, enclaves sharing both keys produce identical ciphertexts
+int encrypt_state_deterministic(sgx_aes_gcm_128bit_key_t *key, const uint8_t *nonce_key,
+    uint32_t nonce_key_len, const char *ledger_key, uint8_t *plain, uint32_t plain_len,
+    uint8_t *cipher, uint32_t cipher_len, const uint8_t *aad = NULL, uint32_t aad_len = 0);
 int decrypt_state(sgx_aes_gcm_128bit_key_t *key, uint8_t *cipher, uint32_t cipher_len,
-    uint8_t *plain, uint32_t plain_len);
+    uint8_t *plain, uint32_t plain_len, const uint8_t *aad = NULL, uint32_t aad_len = 0);
+// derives an aes key from a sk and a pk (big endian) via ecdh
+int derive_shared_key_with(
+    const sgx_ec256_private_t *sk, const uint8_t *pk_be, sgx_aes_gcm_128bit_key_t *key);
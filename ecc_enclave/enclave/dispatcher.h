@@ -0,0 +1,106 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <functional>
+#include <map>
+#include <string>
+#include <vector>
+
+#include "base64.h"
+#include "logging.h"
+
+// Dispatch of invocations with protobuf-defined arguments. The chaincode declares the signature of
+// each function as a protobuf message and registers a handler for it:
+//
+//   dispatcher d;
+//   d.add<SubmitArgs>("submit", [](const SubmitArgs& args, void* ctx) { ... return result; });
+//   if (!d.dispatch(argss, result, ctx)) { ... }
+//
+// A client invokes the function with the arguments [function, base64(serialized message)], see
+// client.MarshalProtoArgs. The dispatcher decodes the (already decrypted) arguments and validates them
+// against the schema before calling the handler. Malformed input never reaches the chaincode; instead
+// the result is an error of the form "INVALID_ARGUMENTS: <reason>".
+//
+// Messages are any T providing the protobuf(-lite) methods ParseFromString and IsInitialized; the
+// latter checks that all required fields are set.
+
+#define INVALID_ARGUMENTS "INVALID_ARGUMENTS"
+
+class dispatcher
+{
+public:
+    // add registers handler for function. The optional validator checks constraints beyond the schema
+    // (e.g., value ranges); it returns false and sets an error if the arguments are invalid.
+    template <typename T>
+    void add(const std::string& function, std::function<std::string(const T&, void*)> handler,
+        std::function<bool(const T&, std::string&)> validator = nullptr)
+    {
+        handlers_[function] = [handler, validator](const std::string& encoded, std::string& result,
+                                  void* ctx) -> bool {
+            T args;
+            if (!args.ParseFromString(base64_decode(encoded))) {
+                result = std::string(INVALID_ARGUMENTS) + ": cannot decode arguments";
+                return false;
+            }
+            if (!args.IsInitialized()) {
+                result = std::string(INVALID_ARGUMENTS) + ": missing required arguments";
+                return false;
+            }
+            std::string error;
+            if (validator && !validator(args, error)) {
+                result = std::string(INVALID_ARGUMENTS) + ": " + error;
+                return false;
+            }
+            result = handler(args, ctx);
+            return true;
+        };
+    }
+
+    // dispatch calls the handler of the function in args[0] with the decoded args[1]; returns false
+    // and sets result to an error if the function is unknown or the arguments are malformed
+    bool dispatch(const std::vector<std::string>& args, std::string& result, void* ctx) const
+    {
+        if (args.empty()) {
+            result = std::string(INVALID_ARGUMENTS) + ": missing function";
+            LOG_ERROR("Dispatcher: %s", result.c_str());
+            return false;
+        }
+
+        auto search = handlers_.find(args[0]);
+        if (search == handlers_.end()) {
+            result = std::string(INVALID_ARGUMENTS) + ": unknown function " + args[0];
+            LOG_ERROR("Dispatcher: %s", result.c_str());
+            return false;
+        }
+        if (args.size() != 2) {
+            result = std::string(INVALID_ARGUMENTS) + ": expecting one argument message for " + args[0];
+            LOG_ERROR("Dispatcher: %s", result.c_str());
+            return false;
+        }
+
+        if (!search->second(args[1], result, ctx)) {
+            LOG_ERROR("Dispatcher: %s: %s", args[0].c_str(), result.c_str());
+            return false;
+        }
+        return true;
+    }
+
+private:
+    typedef std::function<bool(const std::string&, std::string&, void*)> handler_t;
+    std::map<std::string, handler_t> handlers_;
+};
@@ -16,15 +16,36 @@
 
 #include "enclave_t.h"
 
+#include "abac.h"
+#include "audit.h"
 #include "chaincode.h"
+#include "compress.h"
+#include "crypto.h"
+#include "errors.h"
+#include "escrow.h"
+#include "ias.h"
+#include "identity.h"
 #include "logging.h"
+#include "replay.h"
+#include "session.h"
 #include "shim.h"
+#include "upload.h"
 #include "utils.h"
 
 #include "base64.h"
+#include "parson.h"
 
+#include "sgx_trts.h"
+#include "sgx_tseal.h"
 #include "sgx_utils.h"
 
+#include <set>
+
+// openssl
+#include <openssl/bn.h>
+#include <openssl/ec.h>
+#include <openssl/obj_mac.h>
+
 extern sgx_ec256_private_t enclave_sk;
 extern sgx_ec256_public_t enclave_pk;
 
@@ -36,7 +57,7 @@ sgx_ec256_public_t tlcc_pk = {0};
 sgx_cmac_128bit_key_t session_key = {
     0x3F, 0xE2, 0x59, 0xDF, 0x62, 0x7F, 0xEF, 0x99, 0x5B, 0x4B, 0x00, 0xDE, 0x44, 0xC1, 0x26, 0x33};
 
-// state encryption key; hardcoded for debugging
+// state master secret, the keys encrypting the state are derived from it; hardcoded for debugging
 sgx_aes_gcm_128bit_key_t state_encryption_key = {
     0x6A, 0xB0, 0x46, 0xB3, 0x8D, 0x14, 0x2D, 0x17, 0x3F, 0x52, 0xF3, 0x9F, 0xDA, 0x1D, 0x63, 0x4A};
 
@@ -54,7 +75,7 @@ int ecall_bind_tlcc(const sgx_report_t *report, const uint8_t *pubkey)
     std::string base64_hash = base64_encode((const unsigned char *)pk_hash, SGX_SHA256_HASH_SIZE);
     LOG_DEBUG("Received pk hash: %s", base64_hash.c_str());
 
-    if (memcmp(&pk_hash, &(report->body.report_data), SGX_HASH_SIZE) != 0) {
+    if (!consttime_memequal(&pk_hash, &(report->body.report_data), SGX_HASH_SIZE)) {
         LOG_ERROR("PK does not match the one in report !");
         return SGX_ERROR_INVALID_PARAMETER;
     }
@@ -73,46 +94,644 @@ int ecall_bind_tlcc(const sgx_report_t *report, const uint8_t *pubkey)
     return SGX_SUCCESS;
 }
 
-int invoke_enc(const char *args, const char *pk, uint8_t *response, uint32_t max_response_len,
-    uint32_t *actual_response_len, void *ctx)
+// secrets provisioned via ercc after registration
+static std::map<std::string, std::string> provisioned_secrets;
+
+// derives an aes key from the enclave sk and a client pk (big endian) as used for args encryption
+static int derive_shared_key(const uint8_t *pk_be, sgx_aes_gcm_128bit_key_t *key)
 {
-    LOG_DEBUG("Encrypted invcation");
-    sgx_ec256_public_t client_pk = {0};
+    return derive_shared_key_with(&enclave_sk, pk_be, key);
+}
 
-    std::string _pk = base64_decode(pk);
-    uint8_t *pk_bytes = (uint8_t *)_pk.c_str();
-    bytes_swap(pk_bytes, 32);
-    bytes_swap(pk_bytes + 32, 32);
-    memcpy(&client_pk, pk_bytes, sizeof(sgx_ec256_public_t));
+// verifies the signature (r || s, big endian) of a client key (big endian) over args || client pk
+static bool verify_request_signature(const std::string &args, const std::string &client_pk,
+    const uint8_t signer_pk[sizeof(sgx_ec256_public_t)], const std::string &sig)
+{
+    if (sig.size() != sizeof(sgx_ec256_signature_t)) {
+        return false;
+    }
 
-    sgx_ec256_dh_shared_t shared_dhkey;
+    // sgx expects key and signature in little endian
+    sgx_ec256_public_t signer_pk_le;
+    memcpy(&signer_pk_le, signer_pk, sizeof(sgx_ec256_public_t));
+    bytes_swap(&signer_pk_le, 32);
+    bytes_swap((uint8_t *)&signer_pk_le + 32, 32);
+    sgx_ec256_signature_t sig_le;
+    memcpy(&sig_le, sig.c_str(), sizeof(sgx_ec256_signature_t));
+    bytes_swap(&sig_le, 32);
+    bytes_swap((uint8_t *)&sig_le + 32, 32);
 
+    std::string signed_data = args + client_pk;
+    uint8_t result = SGX_EC_INVALID_SIGNATURE;
     sgx_ecc_state_handle_t ecc_handle = NULL;
     sgx_ecc256_open_context(&ecc_handle);
+    int sgx_ret = sgx_ecdsa_verify((const uint8_t *)signed_data.c_str(), signed_data.size(),
+        &signer_pk_le, &sig_le, &result, ecc_handle);
+    sgx_ecc256_close_context(ecc_handle);
+    return sgx_ret == SGX_SUCCESS && result == SGX_EC_VALID;
+}
+
+// opens the envelope of a pseudonymous request, see client.Pseudonym:
+// {"args": <args>, "nym": base64(pk), "nym_sig": base64(sig)}
+// where the pseudonym key signs args || client pk, binding the args to the key of this request. The
+// pseudonym pk (big endian) is returned in nym_pk.
+static int open_nym_envelope(const char *envelope, const std::string &client_pk,
+    std::string &args, uint8_t nym_pk[sizeof(sgx_ec256_public_t)])
+{
+    JSON_Value *root = json_parse_string(envelope);
+    if (json_value_get_type(root) != JSONObject) {
+        LOG_ERROR("Cannot parse pseudonymous request");
+        json_value_free(root);
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    JSON_Object *object = json_value_get_object(root);
+    const char *_args = json_object_get_string(object, "args");
+    const char *_nym = json_object_get_string(object, "nym");
+    const char *_sig = json_object_get_string(object, "nym_sig");
+    if (_args == NULL || _nym == NULL || _sig == NULL) {
+        LOG_ERROR("Incomplete pseudonymous request");
+        json_value_free(root);
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    args = _args;
+    std::string nym = base64_decode(_nym);
+    std::string sig = base64_decode(_sig);
+    json_value_free(root);
+    if (nym.size() != sizeof(sgx_ec256_public_t) || sig.size() != sizeof(sgx_ec256_signature_t)) {
+        LOG_ERROR("Invalid pseudonym");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    memcpy(nym_pk, nym.c_str(), sizeof(sgx_ec256_public_t));
+
+    if (!verify_request_signature(args, client_pk, nym_pk, sig)) {
+        LOG_ERROR("Invalid pseudonym signature");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    return SGX_SUCCESS;
+}
+
+// MSP roots from tlcc are authenticated under a key that can not start a valid UTF-8 ledger key, see
+// ecall_get_msp_roots of tlcc
+#define MSP_ROOTS_CMAC_PREFIX "\xff" "msp."
+#define MAX_MSP_ROOTS_LEN 16384
+
+// fetches the CA certificates of an MSP of the channel from tlcc and verifies them with the cmac
+static int get_msp_roots(const std::string &msp_id, std::string &roots, void *ctx)
+{
+    uint8_t buf[MAX_MSP_ROOTS_LEN];
+    uint32_t len = 0;
+    sgx_cmac_128bit_tag_t cmac = {0};
+    ocall_get_msp_roots(msp_id.c_str(), buf, sizeof(buf), &len, &cmac, ctx);
+    if (len == 0 || len > sizeof(buf)) {
+        LOG_ERROR("No roots for MSP %s", msp_id.c_str());
+        return -1;
+    }
+
+    sgx_sha256_hash_t roots_hash = {0};
+    sgx_sha256_msg(buf, len, &roots_hash);
+    std::string key = std::string(MSP_ROOTS_CMAC_PREFIX) + msp_id;
+    if (check_cmac(key.c_str(), NULL, &roots_hash, &session_key, &cmac) != 0) {
+        LOG_ERROR("Roots of MSP %s are not from tlcc", msp_id.c_str());
+        return -1;
+    }
+    roots = std::string((const char *)buf, len);
+    return 0;
+}
+
+// opens the envelope of a request with creator identity, see client.Identity:
+// {"args": <args>, "msp_id": <msp id>, "creator": base64(cert pem), "creator_sig": base64(sig)}
+// where the certificate key signs args || client pk like a pseudonym key and the certificate chains
+// to a CA of the MSP
+static int open_creator_envelope(const char *envelope, const std::string &client_pk,
+    std::string &args, creator_t &creator, void *ctx)
+{
+    JSON_Value *root = json_parse_string(envelope);
+    JSON_Object *object = json_value_get_object(root);
+    const char *_args = json_object_get_string(object, "args");
+    const char *_msp_id = json_object_get_string(object, "msp_id");
+    const char *_cert = json_object_get_string(object, "creator");
+    const char *_sig = json_object_get_string(object, "creator_sig");
+    if (_args == NULL || _msp_id == NULL || _cert == NULL || _sig == NULL) {
+        LOG_ERROR("Incomplete request with creator");
+        json_value_free(root);
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    args = _args;
+    creator.msp_id = _msp_id;
+    std::string cert = base64_decode(_cert);
+    std::string sig = base64_decode(_sig);
+    json_value_free(root);
+
+    uint8_t creator_pk[sizeof(sgx_ec256_public_t)];
+    if (parse_creator_cert(cert, creator, creator_pk) != 0) {
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    if (!verify_request_signature(args, client_pk, creator_pk, sig)) {
+        LOG_ERROR("Invalid creator signature");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    std::string roots;
+    if (get_msp_roots(creator.msp_id, roots, ctx) != 0 || validate_creator_cert(cert, roots) != 0) {
+        LOG_ERROR("Creator is not a member of %s", creator.msp_id.c_str());
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    return SGX_SUCCESS;
+}
+
+// joins the fields of a statement signed by an approval, each terminated by a newline, see
+// registry.Approval
+static std::string approval_statement(const char *kind, const std::vector<std::string> &fields)
+{
+    std::string statement = std::string(kind) + "\n";
+    for (auto &field : fields) {
+        statement += field + "\n";
+    }
+    return statement;
+}
+
+// base64 of the sha256 digest of data, as statements carry it
+static std::string statement_digest(const uint8_t *data, uint32_t len)
+{
+    sgx_sha256_hash_t hash;
+    sgx_sha256_msg(data, len, &hash);
+    return base64_encode((const unsigned char *)hash, sizeof(hash));
+}
+
+// verifies an approval stored by ercc, see registry.Approval:
+// {"MspID": <msp id>, "Certificate": base64(cert pem), "Signature": base64(sig)}
+// The peer relays the approval but can not forge it: the certificate key must have signed the
+// statement, the certificate must chain to a CA of the MSP as served by tlcc, and carry the
+// attribute (e.g., ercc.provisioner) with value "true". Returns the MSP ID of the signer.
+static int verify_approval_object(JSON_Object *object, const std::string &statement,
+    const char *attribute, std::string &msp_id, void *ctx)
+{
+    const char *_msp_id = json_object_get_string(object, "MspID");
+    const char *_cert = json_object_get_string(object, "Certificate");
+    const char *_sig = json_object_get_string(object, "Signature");
+    if (_msp_id == NULL || _cert == NULL || _sig == NULL) {
+        LOG_ERROR("Missing approval");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    creator_t signer;
+    signer.msp_id = _msp_id;
+    std::string cert = base64_decode(_cert);
+    std::string sig = base64_decode(_sig);
+
+    uint8_t signer_pk[sizeof(sgx_ec256_public_t)];
+    if (parse_creator_cert(cert, signer, signer_pk) != 0) {
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    if (!verify_request_signature(statement, "", signer_pk, sig)) {
+        LOG_ERROR("Invalid approval signature");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    std::string roots;
+    if (get_msp_roots(signer.msp_id, roots, ctx) != 0 || validate_creator_cert(cert, roots) != 0) {
+        LOG_ERROR("Approver is not a member of %s", signer.msp_id.c_str());
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    auto attr = signer.attrs.find(attribute);
+    if (attr == signer.attrs.end() || attr->second != "true") {
+        LOG_ERROR("Approver lacks attribute %s", attribute);
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    msp_id = signer.msp_id;
+    return SGX_SUCCESS;
+}
+
+// verifies a single approval (JSON), see verify_approval_object
+static int verify_approval(
+    const char *approval, const std::string &statement, const char *attribute, void *ctx)
+{
+    JSON_Value *root = json_parse_string(approval);
+    std::string msp_id;
+    int ret = verify_approval_object(json_value_get_object(root), statement, attribute, msp_id, ctx);
+    json_value_free(root);
+    return ret;
+}
+
+// the channel config from tlcc is authenticated under a key that can not start a valid UTF-8 ledger
+// key, see ecall_get_channel_msps of tlcc
+#define CHANNEL_MSPS_CMAC_KEY "\xff" "channel"
+
+// fetches the id of the channel and the MSP IDs of its application orgs from tlcc and verifies them
+// with the cmac
+static int get_channel_msps(std::string &channel_id, std::set<std::string> &msps, void *ctx)
+{
+    uint8_t buf[MAX_MSP_ROOTS_LEN];
+    uint32_t len = 0;
+    sgx_cmac_128bit_tag_t cmac = {0};
+    ocall_get_channel_msps(buf, sizeof(buf), &len, &cmac, ctx);
+    if (len == 0 || len > sizeof(buf)) {
+        LOG_ERROR("No channel MSPs");
+        return -1;
+    }
+
+    sgx_sha256_hash_t config_hash = {0};
+    sgx_sha256_msg(buf, len, &config_hash);
+    if (check_cmac(CHANNEL_MSPS_CMAC_KEY, NULL, &config_hash, &session_key, &cmac) != 0) {
+        LOG_ERROR("Channel MSPs are not from tlcc");
+        return -1;
+    }
+
+    // channel id and MSP IDs, each terminated by a newline
+    std::string config((const char *)buf, len);
+    size_t start = 0;
+    for (size_t end = config.find('\n'); end != std::string::npos;
+         start = end + 1, end = config.find('\n', start)) {
+        std::string field = config.substr(start, end - start);
+        if (start == 0) {
+            channel_id = field;
+        } else {
+            msps.insert(field);
+        }
+    }
+    if (channel_id.empty() || msps.empty()) {
+        LOG_ERROR("Invalid channel MSPs");
+        return -1;
+    }
+    return 0;
+}
+
+// verifies the approvals (a JSON list of registry.Approval) of a governance statement of kind over
+// the id of the channel, as served by tlcc, and the fields. Like registry.VerifyQuorum, the approvals
+// must come from distinct MSPs of the channel, a majority of them, so that no single org decides
+// alone, and each signer must carry the attribute.
+static int verify_quorum(const char *approvals, const char *kind,
+    const std::vector<std::string> &fields, const char *attribute, void *ctx,
+    std::string *approved_statement = NULL)
+{
+    std::string channel_id;
+    std::set<std::string> msps;
+    if (get_channel_msps(channel_id, msps, ctx) != 0) {
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    std::vector<std::string> statement_fields = {channel_id};
+    statement_fields.insert(statement_fields.end(), fields.begin(), fields.end());
+    std::string statement = approval_statement(kind, statement_fields);
+
+    JSON_Value *root = json_parse_string(approvals);
+    JSON_Array *array = json_value_get_array(root);
+    if (array == NULL) {
+        LOG_ERROR("Missing approvals");
+        json_value_free(root);
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    std::set<std::string> approved;
+    for (size_t i = 0; i < json_array_get_count(array); i++) {
+        std::string msp_id;
+        int ret = verify_approval_object(
+            json_array_get_object(array, i), statement, attribute, msp_id, ctx);
+        if (ret != SGX_SUCCESS) {
+            json_value_free(root);
+            return ret;
+        }
+        if (msps.count(msp_id) == 0 || !approved.insert(msp_id).second) {
+            LOG_ERROR("Approval of %s does not count towards the quorum", msp_id.c_str());
+            json_value_free(root);
+            return SGX_ERROR_INVALID_PARAMETER;
+        }
+    }
+    json_value_free(root);
+
+    if (approved.size() < msps.size() / 2 + 1) {
+        LOG_ERROR("Approved by %zu of %zu MSPs, a majority is required", approved.size(), msps.size());
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    if (approved_statement != NULL) {
+        *approved_statement = statement;
+    }
+    return SGX_SUCCESS;
+}
+
+// returns true if the request envelope has the field, e.g., "creator" for requests with creator
+// identity and "nym" for pseudonymous ones
+static bool envelope_has(const char *envelope, const char *field)
+{
+    JSON_Value *root = json_parse_string(envelope);
+    bool has_field = json_object_has_value(json_value_get_object(root), field) == 1;
+    json_value_free(root);
+    return has_field;
+}
+
+// reads the sequence number of a request envelope, a decimal string, and its args; see replay.h
+static int open_request_envelope(const char *envelope, uint64_t *seq, std::string &args)
+{
+    JSON_Value *root = json_parse_string(envelope);
+    JSON_Object *object = json_value_get_object(root);
+    const char *_seq = json_object_get_string(object, "seq");
+    const char *_args = json_object_get_string(object, "args");
+    char *seq_end = NULL;
+    if (_seq != NULL && _args != NULL && _seq[0] >= '0' && _seq[0] <= '9') {
+        *seq = strtoull(_seq, &seq_end, 10);
+        args = _args;
+    }
+    json_value_free(root);
+    if (seq_end == NULL || *seq_end != '\0') {
+        LOG_ERROR("Request without sequence number");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    return SGX_SUCCESS;
+}
+
+// invokes the chaincode if its access policies allow the creator of the request, if any, to call
+// the function
+static int authorized_invoke(const char *args, uint8_t *response, uint32_t max_response_len,
+    uint32_t *actual_response_len, void *ctx)
+{
+    if (strlen(args) > get_limits().max_args_size) {
+        LOG_ERROR("Args exceed %u bytes", get_limits().max_args_size);
+        record_error(ctx, FPC_ERROR_LIMIT_EXCEEDED, "Args exceed %u bytes",
+            get_limits().max_args_size);
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    std::vector<std::string> argss;
+    unmarshal_args(argss, args);
+    const access_control *acl = get_access_control();
+    if (acl != NULL) {
+        creator_t creator;
+        bool has_creator = get_creator(ctx, creator) == 0;
+        if (argss.empty() || !acl->allows_function(argss[0], has_creator ? &creator : NULL)) {
+            LOG_ERROR("Access to function denied");
+            record_error(ctx, FPC_ERROR_ACCESS_DENIED, "Access to function %s denied",
+                argss.empty() ? "" : argss[0].c_str());
+            return SGX_ERROR_INVALID_PARAMETER;
+        }
+    }
+    // chunks of uploads are stored by the enclave, see upload.h; chaincodes may restrict who
+    // uploads like any function
+    int ret;
+    if (!argss.empty() && argss[0] == UPLOAD_CHUNK_FUNCTION) {
+        ret = upload_chunk(argss, response, max_response_len, actual_response_len, ctx);
+    } else {
+        ret = invoke(args, response, max_response_len, actual_response_len, ctx);
+    }
+    if (ret != 0) {
+        // the chaincode may have recorded a more specific error
+        record_error(ctx, FPC_ERROR_CHAINCODE, "Function %s failed",
+            argss.empty() ? "" : argss[0].c_str());
+    }
+    return ret;
+}
+
+// encrypts the response in place with the given key, e.g., the key of a pseudonym
+static int encrypt_response(sgx_aes_gcm_128bit_key_t *key, uint8_t *response,
+    uint32_t max_response_len, uint32_t *actual_response_len)
+{
+    uint32_t cipher_out_len = *actual_response_len + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE;
+    if (cipher_out_len > max_response_len) {
+        LOG_ERROR("Response too large to encrypt");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    std::string result((const char *)response, *actual_response_len);
     int sgx_ret =
-        sgx_ecc256_compute_shared_dhkey(&enclave_sk, &client_pk, &shared_dhkey, ecc_handle);
+        encrypt_state(key, (uint8_t *)&result[0], result.size(), response, cipher_out_len);
     if (sgx_ret != SGX_SUCCESS) {
-        LOG_ERROR("Compute shared dhkey: %d\n", sgx_ret);
+        LOG_ERROR("Encrypt response error: %x", sgx_ret);
         return sgx_ret;
     }
-    sgx_ecc256_close_context(ecc_handle);
-    bytes_swap(&shared_dhkey, 32);
+    *actual_response_len = cipher_out_len;
+    return SGX_SUCCESS;
+}
 
-    sgx_sha256_hash_t h;
-    sgx_sha256_msg(
-        (const uint8_t *)&shared_dhkey, sizeof(sgx_ec256_dh_shared_t), (sgx_sha256_hash_t *)&h);
+// opens a session with the client session pk of {"session_pk": base64(pk)}, see session.h, and
+// responds with {"id": <session id>, "pk": base64(enclave session pk), "expires": <ledger time>}
+// encrypted with the key of the request, so that only the client learns the session pk
+static int open_session(const char *envelope, sgx_aes_gcm_128bit_key_t *key, uint8_t *response,
+    uint32_t max_response_len, uint32_t *actual_response_len, void *ctx)
+{
+    JSON_Value *root = json_parse_string(envelope);
+    const char *_session_pk = json_object_get_string(json_value_get_object(root), "session_pk");
+    std::string client_session_pk = _session_pk != NULL ? base64_decode(_session_pk) : "";
+    json_value_free(root);
+    if (client_session_pk.size() != sizeof(sgx_ec256_public_t)) {
+        LOG_ERROR("Invalid client session pk");
+        record_error(ctx, FPC_ERROR_INVALID_REQUEST, "Invalid client session pk");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    // sessions expire in ledger time
+    int64_t now;
+    uint64_t height;
+    if (get_ledger_time(ctx, &now, &height) != 0) {
+        return SGX_ERROR_UNEXPECTED;
+    }
+    std::string id;
+    uint8_t enclave_session_pk[sizeof(sgx_ec256_public_t)];
+    int64_t expires;
+    int sgx_ret = session_open((const uint8_t *)client_session_pk.c_str(), now, id,
+        enclave_session_pk, &expires);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    JSON_Value *out = json_value_init_object();
+    JSON_Object *out_object = json_value_get_object(out);
+    json_object_set_string(out_object, "id", id.c_str());
+    json_object_set_string(out_object, "pk",
+        base64_encode(enclave_session_pk, sizeof(enclave_session_pk)).c_str());
+    json_object_set_number(out_object, "expires", (double)expires);
+    char *serialized = json_serialize_to_string(out);
+    uint32_t len = strlen(serialized);
+    if (len <= max_response_len) {
+        memcpy(response, serialized, len);
+        *actual_response_len = len;
+    }
+    json_free_serialized_string(serialized);
+    json_value_free(out);
+    if (len > max_response_len) {
+        LOG_ERROR("Session response too large");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    return encrypt_response(key, response, max_response_len, actual_response_len);
+}
+
+// replaces the compressed args of an envelope, {"args": base64(zlib(args)), "compression": "zlib",
+// ...}, by the inflated args, see compress.h
+static int inflate_envelope(std::string &envelope)
+{
+    JSON_Value *root = json_parse_string(envelope.c_str());
+    JSON_Object *object = json_value_get_object(root);
+    const char *compression = json_object_get_string(object, "compression");
+    const char *args = json_object_get_string(object, "args");
+    std::string inflated;
+    int ret = -1;
+    if (compression != NULL && args != NULL && strcmp(compression, COMPRESSION_ZLIB) == 0 &&
+        zlib_inflate(base64_decode(args), inflated, COMPRESSION_MAX_INFLATED_SIZE) == 0 &&
+        json_object_set_string(object, "args", inflated.c_str()) == JSONSuccess &&
+        json_object_remove(object, "compression") == JSONSuccess) {
+        char *serialized = json_serialize_to_string(root);
+        envelope = serialized;
+        json_free_serialized_string(serialized);
+        ret = 0;
+    }
+    json_value_free(root);
+    if (ret != 0) {
+        LOG_ERROR("Cannot inflate request args");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    return SGX_SUCCESS;
+}
+
+// frames the response for a client that accepts compressed responses: a byte 1 followed by the
+// zlib stream if that is shorter, a byte 0 followed by the response as is otherwise
+static int frame_response(uint8_t *response, uint32_t max_response_len, uint32_t *actual_response_len)
+{
+    std::string raw((const char *)response, *actual_response_len);
+    std::string deflated;
+    zlib_deflate(raw, deflated);
+    bool compressed = deflated.size() < raw.size();
+    const std::string &body = compressed ? deflated : raw;
+    if (body.size() + 1 > max_response_len) {
+        LOG_ERROR("Response too large to frame");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    response[0] = compressed ? 1 : 0;
+    memcpy(response + 1, body.c_str(), body.size());
+    *actual_response_len = body.size() + 1;
+    return SGX_SUCCESS;
+}
+
+// invokes the chaincode with the decrypted request envelope of the client with the given pk (big
+// endian)
+static int invoke_envelope(const char *plain, const std::string &_pk, uint8_t *response,
+    uint32_t max_response_len, uint32_t *actual_response_len, void *ctx)
+{
+    // the args (a json array) come in an envelope object with the sequence number of the request;
+    // pseudonymous requests and requests with creator identity carry their credentials along
+    uint64_t seq;
+    std::string args;
+    int sgx_ret = open_request_envelope(plain, &seq, args);
+    if (sgx_ret != SGX_SUCCESS) {
+        record_error(ctx, FPC_ERROR_INVALID_REQUEST, "Request without sequence number");
+        return sgx_ret;
+    }
+    // the client encrypts each request with a fresh key, thus, a replay comes with a known key
+    sgx_sha256_hash_t pk_hash;
+    sgx_sha256_msg((const uint8_t *)_pk.c_str(), _pk.size(), &pk_hash);
+    std::string nonce((const char *)pk_hash, sizeof(pk_hash));
+
+    // requests without identity are not replay protected, anyone can create them
+    bool is_nym = envelope_has(plain, "nym");
+    uint8_t nym_pk[sizeof(sgx_ec256_public_t)];
+    if (envelope_has(plain, "creator")) {
+        creator_t creator;
+        sgx_ret = open_creator_envelope(plain, _pk, args, creator, ctx);
+        if (sgx_ret != SGX_SUCCESS) {
+            record_error(ctx, FPC_ERROR_UNAUTHENTICATED, "Invalid creator credentials");
+            return sgx_ret;
+        }
+        if (replay_check("creator::" + creator.msp_id + "::" + creator.id, seq, nonce) != 0) {
+            record_error(ctx, FPC_ERROR_REPLAYED, "Request %llu replayed or too old",
+                (unsigned long long)seq);
+            return SGX_ERROR_INVALID_PARAMETER;
+        }
+        register_creator(ctx, creator);
+        is_nym = false;
+    } else if (is_nym) {
+        sgx_ret = open_nym_envelope(plain, _pk, args, nym_pk);
+        if (sgx_ret != SGX_SUCCESS) {
+            record_error(ctx, FPC_ERROR_UNAUTHENTICATED, "Invalid pseudonym signature");
+            return sgx_ret;
+        }
+        sgx_sha256_hash_t nym_hash;
+        sgx_sha256_msg(nym_pk, sizeof(nym_pk), &nym_hash);
+        std::string nym = base64_encode((const unsigned char *)nym_hash, SGX_SHA256_HASH_SIZE);
+        if (replay_check("nym::" + nym, seq, nonce) != 0) {
+            record_error(ctx, FPC_ERROR_REPLAYED, "Request %llu replayed or too old",
+                (unsigned long long)seq);
+            return SGX_ERROR_INVALID_PARAMETER;
+        }
+        register_nym(ctx, nym);
+    }
+
+    int ret = authorized_invoke(args.c_str(), response, max_response_len, actual_response_len, ctx);
+    if (ret != 0) {
+        return ret;
+    }
+    if (envelope_has(plain, "accept_compression")) {
+        sgx_ret = frame_response(response, max_response_len, actual_response_len);
+        if (sgx_ret != SGX_SUCCESS) {
+            record_error(ctx, FPC_ERROR_LIMIT_EXCEEDED, "Response too large");
+            return sgx_ret;
+        }
+    }
+    if (!is_nym) {
+        return SGX_SUCCESS;
+    }
+
+    // the response is encrypted to the pseudonym; only its holder learns the result
+    sgx_aes_gcm_128bit_key_t nym_key;
+    sgx_ret = derive_shared_key(nym_pk, &nym_key);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+    sgx_ret = encrypt_response(&nym_key, response, max_response_len, actual_response_len);
+    memset(&nym_key, 0, sizeof(nym_key));
+    return sgx_ret;
+}
 
+// invokes the chaincode with an encrypted request; once the request is decrypted, its key is
+// copied to reply_key
+static int invoke_enc(const char *args, const char *pk, uint8_t *response,
+    uint32_t max_response_len, uint32_t *actual_response_len, sgx_aes_gcm_128bit_key_t *reply_key,
+    bool *has_reply_key, void *ctx)
+{
+    LOG_DEBUG("Encrypted invcation");
+
+    // the pk either is the base64 encoded client pk of the request or names a message of a session
+    std::string _pk;
     sgx_aes_gcm_128bit_key_t key;
-    memcpy(key, h, sizeof(sgx_aes_gcm_128bit_key_t));
+    int sgx_ret;
+    bool in_session = strncmp(pk, SESSION_PK_PREFIX, strlen(SESSION_PK_PREFIX)) == 0;
+    if (in_session) {
+        int64_t now;
+        uint64_t height;
+        if (get_ledger_time(ctx, &now, &height) != 0) {
+            return SGX_ERROR_UNEXPECTED;
+        }
+        sgx_ret = session_message_key(pk + strlen(SESSION_PK_PREFIX), now, &key, _pk);
+    } else {
+        _pk = base64_decode(pk);
+        if (_pk.size() != sizeof(sgx_ec256_public_t)) {
+            LOG_ERROR("Invalid client pk");
+            record_error(ctx, FPC_ERROR_INVALID_REQUEST, "Invalid client pk");
+            return SGX_ERROR_INVALID_PARAMETER;
+        }
+        sgx_ret = derive_shared_key((const uint8_t *)_pk.c_str(), &key);
+    }
+    if (sgx_ret != SGX_SUCCESS) {
+        record_error(ctx, FPC_ERROR_INVALID_REQUEST,
+            in_session ? "Unknown or expired session" : "Invalid client pk");
+        return sgx_ret;
+    }
 
     std::string _cipher = base64_decode(args);
     uint8_t *cipher = (uint8_t *)_cipher.c_str();
     int cipher_len = _cipher.size();
+    if (cipher_len < SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE) {
+        LOG_ERROR("Request ciphertext too short");
+        record_error(ctx, FPC_ERROR_INVALID_REQUEST, "Request ciphertext too short");
+        memset(&key, 0, sizeof(key));
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
 
     uint32_t needed_size = cipher_len - SGX_AESGCM_IV_SIZE - SGX_AESGCM_MAC_SIZE;
+    // refuse oversized requests before spending enclave memory on them, see limits.h
+    uint64_t max_request_size =
+        (uint64_t)get_limits().max_args_size * 4 / 3 + MAX_ENVELOPE_OVERHEAD;
+    if (needed_size > max_request_size) {
+        LOG_ERROR("Request exceeds %llu bytes", (unsigned long long)max_request_size);
+        record_error(ctx, FPC_ERROR_LIMIT_EXCEEDED, "Request exceeds %llu bytes",
+            (unsigned long long)max_request_size);
+        memset(&key, 0, sizeof(key));
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
     // need one byte more for string terminator
-    char plain[needed_size + 1];
-    plain[needed_size] = '\0';
+    std::vector<char> _plain(needed_size + 1, '\0');
+    char *plain = _plain.data();
 
     // decrypt
     sgx_ret = sgx_rijndael128GCM_decrypt(&key,
@@ -123,65 +742,1224 @@ int invoke_enc(const char *args, const char *pk, uint8_t *response, uint32_t max
         (sgx_aes_gcm_128bit_tag_t *)(cipher + SGX_AESGCM_IV_SIZE)); /* tag */
     if (sgx_ret != SGX_SUCCESS) {
         LOG_ERROR("Decrypt error: %x\n", sgx_ret);
+        record_error(ctx, FPC_ERROR_INVALID_REQUEST, "Cannot decrypt request");
+        memset(&key, 0, sizeof(key));
+        return sgx_ret;
+    }
+    // whoever encrypted the request can read the errors of its invocation, see errors.h
+    memcpy(reply_key, &key, sizeof(key));
+    *has_reply_key = true;
+
+    // compressed args are inflated before anything looks at them
+    std::string envelope(plain);
+    if (envelope_has(plain, "compression")) {
+        sgx_ret = inflate_envelope(envelope);
+        if (sgx_ret != SGX_SUCCESS) {
+            record_error(ctx, FPC_ERROR_INVALID_REQUEST, "Cannot inflate request args");
+            memset(&key, 0, sizeof(key));
+            return sgx_ret;
+        }
+    }
+
+    if (!in_session && envelope_has(envelope.c_str(), "session_pk")) {
+        sgx_ret = open_session(
+            envelope.c_str(), &key, response, max_response_len, actual_response_len, ctx);
+    } else {
+        sgx_ret = invoke_envelope(
+            envelope.c_str(), _pk, response, max_response_len, actual_response_len, ctx);
+        // responses within a session are encrypted with the message key
+        if (sgx_ret == SGX_SUCCESS && in_session) {
+            sgx_ret = encrypt_response(&key, response, max_response_len, actual_response_len);
+        }
+    }
+    memset(&key, 0, sizeof(key));
+    return sgx_ret;
+}
+
+// decrypts a secret with the given key and stores it under name
+static int store_secret(const char *name, const sgx_aes_gcm_128bit_key_t *key,
+    const uint8_t *cipher, uint32_t cipher_len)
+{
+    if (cipher_len < SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE) {
+        LOG_ERROR("Secret ciphertext too short");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    uint32_t plain_len = cipher_len - SGX_AESGCM_IV_SIZE - SGX_AESGCM_MAC_SIZE;
+    std::string plain(plain_len, '\0');
+
+    int sgx_ret = sgx_rijndael128GCM_decrypt(key,
+        cipher + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE,                /* cipher */
+        plain_len, (uint8_t *)&plain[0],                                  /* plain out */
+        cipher, SGX_AESGCM_IV_SIZE,                                       /* nonce */
+        NULL, 0,                                                          /* aad */
+        (const sgx_aes_gcm_128bit_tag_t *)(cipher + SGX_AESGCM_IV_SIZE)); /* tag */
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Decrypt secret error: %x", sgx_ret);
         return sgx_ret;
     }
 
-    return invoke((const char *)plain, response, max_response_len, actual_response_len, ctx);
+    provisioned_secrets[std::string(name)] = plain;
+    LOG_DEBUG("Secret %s provisioned", name);
+    return SGX_SUCCESS;
 }
 
-// chaincode call
-// output, response <- F(args, input)
-// signature <- sign (hash,sk)
-int ecall_invoke(const char *args, const char *pk, uint8_t *response, uint32_t response_len_in,
-    uint32_t *response_len_out, sgx_ec256_signature_t *signature, void *ctx)
+// statement a provisioner signs for a secret, see registry.SecretStatement
+#define SECRET_STATEMENT "fpc.secret"
+#define PROVISIONER_ATTRIBUTE "ercc.provisioner"
+
+// verifies that a provisioner approved the secret name with the ciphertext
+static int verify_secret_approval(const char *name, const uint8_t *cipher, uint32_t cipher_len,
+    const char *approval, void *ctx)
 {
-    // register ctx
-    read_set_t readset;
-    write_set_t writeset;
+    std::string statement = approval_statement(
+        SECRET_STATEMENT, {name, statement_digest(cipher, cipher_len)});
+    return verify_approval(approval, statement, PROVISIONER_ATTRIBUTE, ctx);
+}
 
-    register_rwset(ctx, &readset, &writeset);
+int ecall_provision_secret(const char *name, const uint8_t *ephemeral_pk, const uint8_t *cipher,
+    uint32_t cipher_len, const char *approval, void *ctx)
+{
+    int sgx_ret = verify_secret_approval(name, cipher, cipher_len, approval, ctx);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
 
-    // call chaincode invoke logic: creates output and response
-    // output, response <- F(args, input)
-    int ret;
-    if (strlen(pk) == 0) {
-        // clear input
-        ret = invoke(args, response, response_len_in, response_len_out, ctx);
-    } else {
-        // encrypted input
-        ret = invoke_enc(args, pk, response, response_len_in, response_len_out, ctx);
+    sgx_aes_gcm_128bit_key_t key;
+    sgx_ret = derive_shared_key(ephemeral_pk, &key);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
     }
 
-    if (ret != 0) {
-        return SGX_ERROR_UNEXPECTED;
+    sgx_ret = store_secret(name, &key, cipher, cipher_len);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
     }
+    return audit_operation("provision_secret", name);
+}
 
-    // create Hash <- H(args || result || read-write set)
-    sgx_sha256_hash_t hash;
-    sgx_sha_state_handle_t sha_handle;
-    sgx_sha256_init(&sha_handle);
-    sgx_sha256_update((const uint8_t *)args, strlen(args), sha_handle);
-    sgx_sha256_update(response, *response_len_out, sha_handle);
+#define ADMIN_ATTRIBUTE "ercc.admin"
 
-    // hash read and write set
-    LOG_DEBUG("read_set:");
-    for (auto &it : readset) {
-        LOG_DEBUG("\\-> %s", it.c_str());
-        sgx_sha256_update((const uint8_t *)it.c_str(), it.size(), sha_handle);
+// verifies the attestation report of a peer enclave and returns its pk (big endian) and mrenclave. A
+// debug enclave can not be a peer of a production enclave as its memory is not protected.
+static int verify_peer_enclave(const char *report, uint8_t peer_pk[sizeof(sgx_ec256_public_t)],
+    sgx_measurement_t *mrenclave)
+{
+    sgx_report_body_t peer;
+    if (verify_enclave_report(report, &peer, peer_pk) != IAS_SUCCESS) {
+        LOG_ERROR("Invalid peer attestation report");
+        return SGX_ERROR_INVALID_PARAMETER;
     }
 
-    LOG_DEBUG("write_set:");
-    for (auto &it : writeset) {
-        LOG_DEBUG("\\-> %s - %s", it.first.c_str(), it.second.c_str());
-        sgx_sha256_update((const uint8_t *)it.first.c_str(), it.first.size(), sha_handle);
-        sgx_sha256_update((const uint8_t *)it.second.c_str(), it.second.size(), sha_handle);
+    const sgx_report_t *self = sgx_self_report();
+    if ((peer.attributes.flags & SGX_FLAGS_DEBUG) &&
+        !(self->body.attributes.flags & SGX_FLAGS_DEBUG)) {
+        LOG_ERROR("Peer is a debug enclave");
+        return SGX_ERROR_INVALID_PARAMETER;
     }
+    memcpy(mrenclave, &peer.mr_enclave, sizeof(sgx_measurement_t));
+    return SGX_SUCCESS;
+}
+
+// returns the enclave pk in big endian
+static void own_pk_be(uint8_t pk[sizeof(sgx_ec256_public_t)])
+{
+    memcpy(pk, &enclave_pk, sizeof(sgx_ec256_public_t));
+    bytes_swap(pk, 32);
+    bytes_swap(pk + 32, 32);
+}
+
+// proxy re-encryption, see ecc/crypto/reencryption.go for the scheme. The delegator creates the
+// re-encryption key rk = a * d^-1 mod n, where a is the enclave sk and d is the delegation scalar
+// SHA256(X || B || x-coordinate of xB) mod n of an ephemeral key pair (x, X) and the delegatee pk B.
+// The delegatee recomputes d from bX and recovers the key of a re-encrypted ciphertext from d * E'.
+
+// computes the delegation scalar (big endian) from the delegation pk X and delegatee pk B (both big
+// endian) and the x-coordinate of the shared point, as returned by sgx (little endian)
+static int delegation_scalar(const uint8_t *delegation_pk, const uint8_t *delegatee_pk,
+    sgx_ec256_dh_shared_t *shared, BIGNUM *d, BN_CTX *ctx)
+{
+    bytes_swap(shared, 32);
 
+    sgx_sha256_hash_t h;
+    sgx_sha_state_handle_t sha_handle = NULL;
+    sgx_sha256_init(&sha_handle);
+    sgx_sha256_update(delegation_pk, sizeof(sgx_ec256_public_t), sha_handle);
+    sgx_sha256_update(delegatee_pk, sizeof(sgx_ec256_public_t), sha_handle);
+    sgx_sha256_update((const uint8_t *)shared, sizeof(sgx_ec256_dh_shared_t), sha_handle);
+    int sgx_ret = sgx_sha256_get_hash(sha_handle, &h);
+    sgx_sha256_close(sha_handle);
+    memset(shared, 0, sizeof(sgx_ec256_dh_shared_t));
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    EC_GROUP *group = EC_GROUP_new_by_curve_name(NID_X9_62_prime256v1);
+    BIGNUM *n = BN_new();
+    BIGNUM *hash = BN_bin2bn(h, sizeof(h), NULL);
+    int ok = group != NULL && n != NULL && hash != NULL && EC_GROUP_get_order(group, n, ctx) &&
+             BN_nnmod(d, hash, n, ctx) && !BN_is_zero(d);
+    BN_free(hash);
+    BN_free(n);
+    EC_GROUP_free(group);
+    if (!ok) {
+        LOG_ERROR("Invalid delegation scalar");
+        return SGX_ERROR_UNEXPECTED;
+    }
+    return SGX_SUCCESS;
+}
+
+#define DELEGATION_STATEMENT "fpc.delegation"
+
+// creates a re-encryption key from this enclave to the delegatee enclave of the attestation report,
+// after checking a quorum of the channel MSPs approved the delegation of the chaincode to its
+// mrenclave, see registry.DelegationStatement. The key reveals the enclave sk to the holder of the
+// delegatee sk, hence the delegatee must be an attested enclave. Returns the delegation pk (big
+// endian) and rk (big endian).
+int ecall_create_reencryption_key(const char *chaincode_id, const char *delegatee_report,
+    const char *approvals, uint8_t *delegation_pk, uint8_t *rk, void *ctx)
+{
+    uint8_t target_pk[sizeof(sgx_ec256_public_t)];
+    sgx_measurement_t delegatee;
+    int sgx_ret = verify_peer_enclave(delegatee_report, target_pk, &delegatee);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+    const sgx_measurement_t *mrenclave = &sgx_self_report()->body.mr_enclave;
+    sgx_ret = verify_quorum(approvals, DELEGATION_STATEMENT,
+        {chaincode_id, base64_encode((const unsigned char *)mrenclave->m, sizeof(mrenclave->m)),
+            base64_encode((const unsigned char *)delegatee.m, sizeof(delegatee.m))},
+        ADMIN_ATTRIBUTE, ctx);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    sgx_ec256_public_t target_pk_le;
+    memcpy(&target_pk_le, target_pk, sizeof(sgx_ec256_public_t));
+    bytes_swap(&target_pk_le, 32);
+    bytes_swap((uint8_t *)&target_pk_le + 32, 32);
+
+    // create ephemeral key pair and the shared point with the delegatee
+    sgx_ec256_private_t ephemeral_sk;
+    sgx_ec256_public_t ephemeral_pk_le;
+    sgx_ec256_dh_shared_t shared;
+    sgx_ecc_state_handle_t ecc_handle = NULL;
+    sgx_ecc256_open_context(&ecc_handle);
+    sgx_ret = sgx_ecc256_create_key_pair(&ephemeral_sk, &ephemeral_pk_le, ecc_handle);
+    if (sgx_ret == SGX_SUCCESS) {
+        sgx_ret = sgx_ecc256_compute_shared_dhkey(&ephemeral_sk, &target_pk_le, &shared, ecc_handle);
+    }
+    sgx_ecc256_close_context(ecc_handle);
+    memset(&ephemeral_sk, 0, sizeof(sgx_ec256_private_t));
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Create delegation key error: %x", sgx_ret);
+        return sgx_ret;
+    }
+
+    // return delegation pk in big endian
+    memcpy(delegation_pk, &ephemeral_pk_le, sizeof(sgx_ec256_public_t));
+    bytes_swap(delegation_pk, 32);
+    bytes_swap(delegation_pk + 32, 32);
+
+    uint8_t sk_be[sizeof(sgx_ec256_private_t)];
+    memcpy(sk_be, &enclave_sk, sizeof(sgx_ec256_private_t));
+    bytes_swap(sk_be, sizeof(sk_be));
+
+    BN_CTX *bn_ctx = BN_CTX_new();
+    EC_GROUP *group = EC_GROUP_new_by_curve_name(NID_X9_62_prime256v1);
+    BIGNUM *n = BN_new();
+    BIGNUM *d = BN_new();
+    BIGNUM *a = BN_bin2bn(sk_be, sizeof(sk_be), NULL);
+    memset(sk_be, 0, sizeof(sk_be));
+
+    sgx_ret = SGX_ERROR_UNEXPECTED;
+    if (bn_ctx != NULL && group != NULL && n != NULL && d != NULL && a != NULL &&
+        EC_GROUP_get_order(group, n, bn_ctx) &&
+        delegation_scalar(delegation_pk, target_pk, &shared, d, bn_ctx) == SGX_SUCCESS &&
+        BN_mod_inverse(d, d, n, bn_ctx) != NULL && BN_mod_mul(d, d, a, n, bn_ctx) &&
+        BN_bn2binpad(d, rk, 32) == 32) {
+        sgx_ret = SGX_SUCCESS;
+    }
+
+    BN_clear_free(a);
+    BN_clear_free(d);
+    BN_free(n);
+    EC_GROUP_free(group);
+    BN_CTX_free(bn_ctx);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Create re-encryption key failed");
+        return sgx_ret;
+    }
+
+    LOG_DEBUG("Re-encryption key created");
+    return audit_operation("create_reencryption_key",
+        base64_encode((const unsigned char *)target_pk, sizeof(target_pk)));
+}
+
+// provisions a secret encrypted to a delegator enclave and re-encrypted for this enclave. The
+// delegation pk and the re-encrypted ephemeral pk are big endian. Re-encryption leaves the
+// ciphertext as is, thus, the approval of the provisioner covers it.
+int ecall_provision_delegated_secret(const char *name, const uint8_t *delegation_pk,
+    const uint8_t *reencrypted_pk, const uint8_t *cipher, uint32_t cipher_len,
+    const char *approval, void *ctx)
+{
+    int sgx_ret = verify_secret_approval(name, cipher, cipher_len, approval, ctx);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    sgx_ec256_public_t delegation_pk_le;
+    memcpy(&delegation_pk_le, delegation_pk, sizeof(sgx_ec256_public_t));
+    bytes_swap(&delegation_pk_le, 32);
+    bytes_swap((uint8_t *)&delegation_pk_le + 32, 32);
+
+    sgx_ec256_dh_shared_t shared;
+    sgx_ecc_state_handle_t ecc_handle = NULL;
+    sgx_ecc256_open_context(&ecc_handle);
+    sgx_ret = sgx_ecc256_compute_shared_dhkey(&enclave_sk, &delegation_pk_le, &shared, ecc_handle);
+    sgx_ecc256_close_context(ecc_handle);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Compute shared dhkey: %d\n", sgx_ret);
+        return sgx_ret;
+    }
+
+    uint8_t own_pk[sizeof(sgx_ec256_public_t)];
+    own_pk_be(own_pk);
+
+    // d as sgx private key (little endian)
+    sgx_ec256_private_t d_sk;
+    BN_CTX *ctx = BN_CTX_new();
+    BIGNUM *d = BN_new();
+    sgx_ret = SGX_ERROR_UNEXPECTED;
+    if (ctx != NULL && d != NULL &&
+        delegation_scalar(delegation_pk, own_pk, &shared, d, ctx) == SGX_SUCCESS &&
+        BN_bn2binpad(d, (uint8_t *)&d_sk, sizeof(d_sk)) == sizeof(d_sk)) {
+        bytes_swap(&d_sk, sizeof(d_sk));
+        sgx_ret = SGX_SUCCESS;
+    }
+    BN_clear_free(d);
+    BN_CTX_free(ctx);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Derive delegation scalar failed");
+        return sgx_ret;
+    }
+
+    // d * E' = aE, the point the delegator would have derived its key from
+    sgx_aes_gcm_128bit_key_t key;
+    sgx_ret = derive_shared_key_with(&d_sk, reencrypted_pk, &key);
+    memset(&d_sk, 0, sizeof(d_sk));
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    sgx_ret = store_secret(name, &key, cipher, cipher_len);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+    return audit_operation("provision_delegated_secret", name);
+}
+
+#define UPGRADE_STATEMENT "fpc.upgrade"
+
+// verifies that admins of a quorum of the channel MSPs approved the upgrade of the chaincode from
+// mrenclave to successor, see registry.UpgradeStatement
+static int verify_upgrade_approval(const char *chaincode_id, const sgx_measurement_t *mrenclave,
+    const sgx_measurement_t *successor, const char *approvals, void *ctx)
+{
+    return verify_quorum(approvals, UPGRADE_STATEMENT,
+        {chaincode_id, base64_encode((const unsigned char *)mrenclave->m, sizeof(mrenclave->m)),
+            base64_encode((const unsigned char *)successor->m, sizeof(successor->m))},
+        ADMIN_ATTRIBUTE, ctx);
+}
+
+// exports the state encryption key to the successor enclave of the attestation report, after checking
+// a quorum of the channel MSPs approved the upgrade of the chaincode to its mrenclave. The key is
+// encrypted to the successor pk and the enclave signs (r || s, big endian)
+// ephemeral pk || cipher || successor pk, see registry.HandoverMessage, so the successor can
+// authenticate the key.
+int ecall_export_state_key(const char *chaincode_id, const char *successor_report,
+    const char *approvals, uint8_t *ephemeral_pk, uint8_t *cipher, uint32_t cipher_len,
+    uint8_t *signature, void *ctx)
+{
+    if (cipher_len !=
+        SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE + sizeof(sgx_aes_gcm_128bit_key_t)) {
+        LOG_ERROR("Invalid cipher buffer size");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    uint8_t target_pk[sizeof(sgx_ec256_public_t)];
+    sgx_measurement_t successor;
+    int sgx_ret = verify_peer_enclave(successor_report, target_pk, &successor);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+    sgx_ret = verify_upgrade_approval(
+        chaincode_id, &sgx_self_report()->body.mr_enclave, &successor, approvals, ctx);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    // create ephemeral key pair
+    sgx_ec256_private_t ephemeral_sk;
+    sgx_ec256_public_t ephemeral_pk_le;
+    sgx_ecc_state_handle_t ecc_handle = NULL;
+    sgx_ecc256_open_context(&ecc_handle);
+    sgx_ret = sgx_ecc256_create_key_pair(&ephemeral_sk, &ephemeral_pk_le, ecc_handle);
+    sgx_ecc256_close_context(ecc_handle);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Create ephemeral key pair error: %x", sgx_ret);
+        return sgx_ret;
+    }
+
+    sgx_aes_gcm_128bit_key_t key;
+    sgx_ret = derive_shared_key_with(&ephemeral_sk, target_pk, &key);
+    memset(&ephemeral_sk, 0, sizeof(sgx_ec256_private_t));
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    sgx_ret = sgx_read_rand(cipher, SGX_AESGCM_IV_SIZE);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Read rand error: %x", sgx_ret);
+        return sgx_ret;
+    }
+
+    sgx_ret = sgx_rijndael128GCM_encrypt(&key,
+        (const uint8_t *)&state_encryption_key, sizeof(sgx_aes_gcm_128bit_key_t), /* plain */
+        cipher + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE,                       /* cipher out */
+        cipher, SGX_AESGCM_IV_SIZE,                                              /* nonce */
+        NULL, 0,                                                                 /* aad */
+        (sgx_aes_gcm_128bit_tag_t *)(cipher + SGX_AESGCM_IV_SIZE));              /* tag */
+    memset(&key, 0, sizeof(key));
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Encrypt state key error: %x", sgx_ret);
+        return sgx_ret;
+    }
+
+    // return ephemeral pk in big endian
+    memcpy(ephemeral_pk, &ephemeral_pk_le, sizeof(sgx_ec256_public_t));
+    bytes_swap(ephemeral_pk, 32);
+    bytes_swap(ephemeral_pk + 32, 32);
+
+    // sign the handover; sgx returns the signature in little endian
+    std::string message((const char *)ephemeral_pk, sizeof(sgx_ec256_public_t));
+    message.append((const char *)cipher, cipher_len);
+    message.append((const char *)target_pk, sizeof(target_pk));
+    sgx_ec256_signature_t sig_le;
+    sgx_ecc256_open_context(&ecc_handle);
+    sgx_ret = sgx_ecdsa_sign(
+        (const uint8_t *)message.c_str(), message.size(), &enclave_sk, &sig_le, ecc_handle);
+    sgx_ecc256_close_context(ecc_handle);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Sign state handover error: %x", sgx_ret);
+        return sgx_ret;
+    }
+    memcpy(signature, &sig_le, sizeof(sgx_ec256_signature_t));
+    bytes_swap(signature, 32);
+    bytes_swap(signature + 32, 32);
+
+    LOG_DEBUG("State key exported");
+    return audit_operation("export_state_key",
+        base64_encode((const unsigned char *)target_pk, sizeof(target_pk)));
+}
+
+// prefix of the rotation message, see registry.RotationMessage
+#define ROTATION_MESSAGE "fpc.rotation.key\n"
+
+// endorses the enclave of the attestation report as successor of this enclave's key, e.g., after a
+// restart on the same platform. The successor must run the same mrenclave; the enclave signs
+// (r || s, big endian) the successor pk, see registry.RotationMessage, which ercc requires to rotate
+// the enclave identity to the successor key.
+int ecall_endorse_key(const char *successor_report, uint8_t *signature)
+{
+    uint8_t successor_pk[sizeof(sgx_ec256_public_t)];
+    sgx_measurement_t successor;
+    int sgx_ret = verify_peer_enclave(successor_report, successor_pk, &successor);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+    if (memcmp(&successor, &sgx_self_report()->body.mr_enclave, sizeof(sgx_measurement_t)) != 0) {
+        LOG_ERROR("Successor runs another mrenclave");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    // sgx returns the signature in little endian
+    std::string message(ROTATION_MESSAGE);
+    message.append((const char *)successor_pk, sizeof(successor_pk));
+    sgx_ec256_signature_t sig_le;
+    sgx_ecc_state_handle_t ecc_handle = NULL;
+    sgx_ecc256_open_context(&ecc_handle);
+    sgx_ret = sgx_ecdsa_sign(
+        (const uint8_t *)message.c_str(), message.size(), &enclave_sk, &sig_le, ecc_handle);
+    sgx_ecc256_close_context(ecc_handle);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Sign key rotation error: %x", sgx_ret);
+        return sgx_ret;
+    }
+    memcpy(signature, &sig_le, sizeof(sgx_ec256_signature_t));
+    bytes_swap(signature, 32);
+    bytes_swap(signature + 32, 32);
+
+    LOG_DEBUG("Successor key endorsed");
+    return audit_operation("endorse_key",
+        base64_encode((const unsigned char *)successor_pk, sizeof(successor_pk)));
+}
+
+// prefix of the renewal message, see registry.RenewalMessage
+#define RENEWAL_MESSAGE "fpc.renewal.quote\n"
+
+// signs (r || s, big endian) the renewal of this enclave's registration with a fresh quote in
+// transaction tx_id, see registry.RenewalMessage, which ercc requires to replace the evidence of the
+// enclave. The quote must carry a report of this enclave over its own pk.
+int ecall_sign_renewal(
+    const char *tx_id, const uint8_t *quote, uint32_t quote_size, uint8_t *signature)
+{
+    if (quote_size < sizeof(sgx_quote_t)) {
+        LOG_ERROR("Quote too short");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    const sgx_report_body_t *body = &((const sgx_quote_t *)quote)->report_body;
+    if (memcmp(&body->mr_enclave, &sgx_self_report()->body.mr_enclave,
+            sizeof(sgx_measurement_t)) != 0) {
+        LOG_ERROR("Quote belongs to another mrenclave");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    uint8_t pk[sizeof(sgx_ec256_public_t)];
+    own_pk_be(pk);
+    sgx_sha256_hash_t pk_hash;
+    int sgx_ret = sgx_sha256_msg(pk, sizeof(pk), &pk_hash);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+    if (!consttime_memequal(&pk_hash, &body->report_data, SGX_HASH_SIZE)) {
+        LOG_ERROR("Quote does not report the enclave pk");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    // sgx returns the signature in little endian
+    std::string message(RENEWAL_MESSAGE);
+    message.append(tx_id);
+    message.append("\n");
+    message.append((const char *)quote, quote_size);
+    sgx_ec256_signature_t sig_le;
+    sgx_ecc_state_handle_t ecc_handle = NULL;
+    sgx_ecc256_open_context(&ecc_handle);
+    sgx_ret = sgx_ecdsa_sign(
+        (const uint8_t *)message.c_str(), message.size(), &enclave_sk, &sig_le, ecc_handle);
+    sgx_ecc256_close_context(ecc_handle);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Sign renewal error: %x", sgx_ret);
+        return sgx_ret;
+    }
+    memcpy(signature, &sig_le, sizeof(sgx_ec256_signature_t));
+    bytes_swap(signature, 32);
+    bytes_swap(signature + 32, 32);
+
+    LOG_DEBUG("Renewal signed");
+    return audit_operation("sign_renewal", tx_id);
+}
+
+// imports the state encryption key handed over by the predecessor enclave of the attestation report,
+// after checking a quorum of the channel MSPs approved the upgrade of the chaincode from its
+// mrenclave to this one and the predecessor signed the handover to this enclave
+int ecall_import_state_key(const char *chaincode_id, const char *predecessor_report,
+    const char *approvals, const uint8_t *ephemeral_pk, const uint8_t *cipher, uint32_t cipher_len,
+    const uint8_t *signature, void *ctx)
+{
+    if (cipher_len !=
+        SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE + sizeof(sgx_aes_gcm_128bit_key_t)) {
+        LOG_ERROR("Invalid state key ciphertext size");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    uint8_t predecessor_pk[sizeof(sgx_ec256_public_t)];
+    sgx_measurement_t predecessor;
+    int sgx_ret = verify_peer_enclave(predecessor_report, predecessor_pk, &predecessor);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+    sgx_ret = verify_upgrade_approval(
+        chaincode_id, &predecessor, &sgx_self_report()->body.mr_enclave, approvals, ctx);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    uint8_t own_pk[sizeof(sgx_ec256_public_t)];
+    own_pk_be(own_pk);
+    std::string message((const char *)ephemeral_pk, sizeof(sgx_ec256_public_t));
+    message.append((const char *)cipher, cipher_len);
+    if (!verify_request_signature(message, std::string((const char *)own_pk, sizeof(own_pk)),
+            predecessor_pk,
+            std::string((const char *)signature, sizeof(sgx_ec256_signature_t)))) {
+        LOG_ERROR("Invalid state handover signature");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    sgx_aes_gcm_128bit_key_t key;
+    sgx_ret = derive_shared_key(ephemeral_pk, &key);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    sgx_aes_gcm_128bit_key_t imported_key;
+    sgx_ret = sgx_rijndael128GCM_decrypt(&key,
+        cipher + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE,                /* cipher */
+        sizeof(sgx_aes_gcm_128bit_key_t), (uint8_t *)&imported_key,       /* plain out */
+        cipher, SGX_AESGCM_IV_SIZE,                                       /* nonce */
+        NULL, 0,                                                          /* aad */
+        (const sgx_aes_gcm_128bit_tag_t *)(cipher + SGX_AESGCM_IV_SIZE)); /* tag */
+    memset(&key, 0, sizeof(key));
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Decrypt state key error: %x", sgx_ret);
+        return sgx_ret;
+    }
+
+    memcpy(&state_encryption_key, &imported_key, sizeof(sgx_aes_gcm_128bit_key_t));
+    memset(&imported_key, 0, sizeof(imported_key));
+    LOG_DEBUG("State key imported");
+    return audit_operation("import_state_key",
+        base64_encode((const unsigned char *)predecessor_pk, sizeof(predecessor_pk)));
+}
+
+// ciphertext of an escrow share (x || state key sized y) in the format of encrypt_state
+#define ESCROW_SHARE_CIPHER_SIZE \
+    (SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE + 1 + sizeof(sgx_aes_gcm_128bit_key_t))
+// an escrow share record is the ephemeral pk (big endian) followed by the share ciphertext
+#define ESCROW_SHARE_RECORD_SIZE (sizeof(sgx_ec256_public_t) + ESCROW_SHARE_CIPHER_SIZE)
+
+// check value of the state key, see ESCROW_CHECK_LABEL
+static void escrow_check(sgx_aes_gcm_128bit_key_t *key, uint8_t *check)
+{
+    sgx_hmac_sha256_msg((const unsigned char *)ESCROW_CHECK_LABEL, strlen(ESCROW_CHECK_LABEL),
+        (const unsigned char *)key, sizeof(sgx_aes_gcm_128bit_key_t), check, SGX_SHA256_HASH_SIZE);
+}
+
+#define ESCROW_STATEMENT "fpc.escrow"
+#define ESCROW_CHECK_STATEMENT "fpc.escrow.check"
+
+// the least number of parties, each of another MSP, that must take part in a recovery, see
+// registry.MinEscrowThreshold
+#define MIN_ESCROW_THRESHOLD 2
+
+// verifies that a quorum of the channel MSPs approved the escrow policy of the chaincode, see
+// registry.EscrowStatement, and that no single MSP can recover the state key under it: the parties
+// are of distinct MSPs (party_msp_ids, each newline terminated) and the threshold is at least
+// MIN_ESCROW_THRESHOLD. Returns the approved policy statement.
+static int verify_escrow_policy(const char *chaincode_id, uint32_t threshold, uint32_t parties,
+    const char *party_msp_ids, const uint8_t *party_pks, uint32_t party_pks_len,
+    const char *approvals, std::string &statement, void *ctx)
+{
+    if (threshold < MIN_ESCROW_THRESHOLD || threshold > parties) {
+        LOG_ERROR("Escrow threshold %u out of range", threshold);
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    std::string msp_ids(party_msp_ids);
+    std::set<std::string> distinct;
+    size_t start = 0;
+    for (size_t end = msp_ids.find('\n'); end != std::string::npos;
+         start = end + 1, end = msp_ids.find('\n', start)) {
+        if (end == start || !distinct.insert(msp_ids.substr(start, end - start)).second) {
+            LOG_ERROR("Recovery parties must have distinct MSP IDs");
+            return SGX_ERROR_INVALID_PARAMETER;
+        }
+    }
+    if (start != msp_ids.size() || distinct.size() != parties) {
+        LOG_ERROR("Recovery parties do not match their MSP IDs");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    char _threshold[16];
+    snprintf(_threshold, sizeof(_threshold), "%u", threshold);
+    return verify_quorum(approvals, ESCROW_STATEMENT,
+        {chaincode_id, _threshold, statement_digest((const uint8_t *)msp_ids.data(), msp_ids.size()),
+            statement_digest(party_pks, party_pks_len)},
+        ADMIN_ATTRIBUTE, ctx, &statement);
+}
+
+// what the escrowing enclave signs along with an escrow, see registry.EscrowMessage
+static std::string escrow_message(const std::string &policy_statement, const uint8_t *check)
+{
+    return approval_statement(ESCROW_CHECK_STATEMENT,
+        {statement_digest((const uint8_t *)policy_statement.data(), policy_statement.size()),
+            base64_encode((const unsigned char *)check, SGX_SHA256_HASH_SIZE)});
+}
+
+// splits the state key into one share per recovery party pk (big endian), threshold of which
+// recover it, and encrypts each share to its party, after checking a quorum of the channel MSPs
+// approved the escrow policy of chaincode id, threshold and parties, see verify_escrow_policy;
+// writes the share records in the order of the parties, the check value of the state key and the
+// enclave signature (r || s, big endian) over the check value and the policy, see
+// registry.EscrowMessage
+int ecall_escrow_state_key(const char *chaincode_id, uint32_t threshold, uint32_t parties,
+    const uint8_t *party_pks, uint32_t party_pks_len, const char *party_msp_ids,
+    const char *approvals, uint8_t *shares, uint32_t shares_len, uint8_t *check,
+    uint8_t *signature, void *ctx)
+{
+    if (parties == 0 || parties > MAX_ESCROW_PARTIES ||
+        party_pks_len != parties * sizeof(sgx_ec256_public_t) ||
+        shares_len != parties * ESCROW_SHARE_RECORD_SIZE) {
+        LOG_ERROR("Invalid escrow parameters");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    std::string statement;
+    int sgx_ret = verify_escrow_policy(chaincode_id, threshold, parties, party_msp_ids, party_pks,
+        party_pks_len, approvals, statement, ctx);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    std::vector<std::string> plain_shares;
+    std::string secret((const char *)&state_encryption_key, sizeof(sgx_aes_gcm_128bit_key_t));
+    bool ok = shamir_split(secret, threshold, parties, plain_shares);
+    memset(&secret[0], 0, secret.size());
+    if (!ok) {
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    for (uint32_t i = 0; i < parties && sgx_ret == SGX_SUCCESS; i++) {
+        uint8_t *record = shares + i * ESCROW_SHARE_RECORD_SIZE;
+
+        // a fresh ephemeral key pair per share
+        sgx_ec256_private_t ephemeral_sk;
+        sgx_ec256_public_t ephemeral_pk_le;
+        sgx_ecc_state_handle_t ecc_handle = NULL;
+        sgx_ecc256_open_context(&ecc_handle);
+        sgx_ret = sgx_ecc256_create_key_pair(&ephemeral_sk, &ephemeral_pk_le, ecc_handle);
+        sgx_ecc256_close_context(ecc_handle);
+        if (sgx_ret != SGX_SUCCESS) {
+            LOG_ERROR("Create ephemeral key pair error: %x", sgx_ret);
+            break;
+        }
+
+        sgx_aes_gcm_128bit_key_t key;
+        sgx_ret = derive_shared_key_with(
+            &ephemeral_sk, party_pks + i * sizeof(sgx_ec256_public_t), &key);
+        memset(&ephemeral_sk, 0, sizeof(sgx_ec256_private_t));
+        if (sgx_ret != SGX_SUCCESS) {
+            break;
+        }
+
+        memcpy(record, &ephemeral_pk_le, sizeof(sgx_ec256_public_t));
+        bytes_swap(record, 32);
+        bytes_swap(record + 32, 32);
+        sgx_ret = encrypt_state(&key, (uint8_t *)&plain_shares[i][0], plain_shares[i].size(),
+            record + sizeof(sgx_ec256_public_t), ESCROW_SHARE_CIPHER_SIZE);
+        memset(&key, 0, sizeof(key));
+        if (sgx_ret != SGX_SUCCESS) {
+            LOG_ERROR("Encrypt escrow share error: %x", sgx_ret);
+        }
+    }
+    for (auto &share : plain_shares) {
+        memset(&share[0], 0, share.size());
+    }
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    escrow_check(&state_encryption_key, check);
+
+    // sign the escrow; sgx returns the signature in little endian
+    std::string message = escrow_message(statement, check);
+    sgx_ec256_signature_t sig_le;
+    sgx_ecc_state_handle_t ecc_handle = NULL;
+    sgx_ecc256_open_context(&ecc_handle);
+    sgx_ret = sgx_ecdsa_sign(
+        (const uint8_t *)message.c_str(), message.size(), &enclave_sk, &sig_le, ecc_handle);
+    sgx_ecc256_close_context(ecc_handle);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Sign escrow error: %x", sgx_ret);
+        return sgx_ret;
+    }
+    memcpy(signature, &sig_le, sizeof(sgx_ec256_signature_t));
+    bytes_swap(signature, 32);
+    bytes_swap(signature + 32, 32);
+
+    LOG_DEBUG("State key escrowed to %u parties with threshold %u", parties, threshold);
+    char subject[32];
+    snprintf(subject, sizeof(subject), "%u/%u", threshold, parties);
+    return audit_operation("escrow_state_key", subject);
+}
+
+// recovers the state key from escrow shares the recovery parties re-encrypted to this enclave. The
+// escrow must have been created by an enclave of the attestation report with the same mrenclave, under
+// an escrow policy a quorum of the channel MSPs approved, and signed by it; the recovered key must
+// match the signed check value of the escrow.
+int ecall_recover_state_key(const char *escrow_report, const char *chaincode_id, uint32_t threshold,
+    uint32_t parties, const uint8_t *party_pks, uint32_t party_pks_len, const char *party_msp_ids,
+    const char *approvals, const uint8_t *check, const uint8_t *signature, uint32_t count,
+    const uint8_t *shares, uint32_t shares_len, void *ctx)
+{
+    if (count == 0 || count > MAX_ESCROW_PARTIES ||
+        shares_len != count * ESCROW_SHARE_RECORD_SIZE || parties == 0 ||
+        parties > MAX_ESCROW_PARTIES || party_pks_len != parties * sizeof(sgx_ec256_public_t)) {
+        LOG_ERROR("Invalid recovery parameters");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    uint8_t escrow_pk[sizeof(sgx_ec256_public_t)];
+    sgx_measurement_t escrowed_by;
+    int sgx_ret = verify_peer_enclave(escrow_report, escrow_pk, &escrowed_by);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+    if (!consttime_memequal(&escrowed_by, &sgx_self_report()->body.mr_enclave,
+            sizeof(sgx_measurement_t))) {
+        LOG_ERROR("Escrow was created by another enclave");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    std::string statement;
+    sgx_ret = verify_escrow_policy(chaincode_id, threshold, parties, party_msp_ids, party_pks,
+        party_pks_len, approvals, statement, ctx);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+    if (!verify_request_signature(escrow_message(statement, check), "", escrow_pk,
+            std::string((const char *)signature, sizeof(sgx_ec256_signature_t)))) {
+        LOG_ERROR("Invalid escrow signature");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    std::vector<std::string> plain_shares;
+    for (uint32_t i = 0; i < count; i++) {
+        const uint8_t *record = shares + i * ESCROW_SHARE_RECORD_SIZE;
+
+        sgx_aes_gcm_128bit_key_t key;
+        sgx_ret = derive_shared_key(record, &key);
+        if (sgx_ret != SGX_SUCCESS) {
+            break;
+        }
+
+        std::string share(1 + sizeof(sgx_aes_gcm_128bit_key_t), 0);
+        sgx_ret = decrypt_state(&key, (uint8_t *)record + sizeof(sgx_ec256_public_t),
+            ESCROW_SHARE_CIPHER_SIZE, (uint8_t *)&share[0], share.size());
+        memset(&key, 0, sizeof(key));
+        if (sgx_ret != SGX_SUCCESS) {
+            LOG_ERROR("Decrypt escrow share error: %x", sgx_ret);
+            break;
+        }
+        plain_shares.push_back(share);
+    }
+
+    std::string secret;
+    bool ok = sgx_ret == SGX_SUCCESS && shamir_combine(plain_shares, secret);
+    for (auto &share : plain_shares) {
+        memset(&share[0], 0, share.size());
+    }
+    if (!ok) {
+        return sgx_ret != SGX_SUCCESS ? sgx_ret : SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    sgx_aes_gcm_128bit_key_t recovered;
+    memcpy(&recovered, secret.data(), sizeof(recovered));
+    memset(&secret[0], 0, secret.size());
+
+    // too few or wrong shares interpolate to another key
+    uint8_t recovered_check[SGX_SHA256_HASH_SIZE];
+    escrow_check(&recovered, recovered_check);
+    if (!consttime_memequal(recovered_check, check, sizeof(recovered_check))) {
+        memset(&recovered, 0, sizeof(recovered));
+        LOG_ERROR("Recovered state key does not match escrow");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    memcpy(&state_encryption_key, &recovered, sizeof(sgx_aes_gcm_128bit_key_t));
+    memset(&recovered, 0, sizeof(recovered));
+    LOG_DEBUG("State key recovered from %u escrow shares", count);
+    char subject[16];
+    snprintf(subject, sizeof(subject), "%u", count);
+    return audit_operation("recover_state_key", subject);
+}
+
+// format version of the sealed state; version 1 was sealed to mrenclave without metadata,
+// version 2 is sealed to mrsigner and carries sealed_state_metadata_t, the payload is unchanged,
+// version 3 appends the replay state (see replay.h)
+#define SEALED_STATE_VERSION 3
+#define SEALED_STATE_VERSION_NO_REPLAY 2
+#define SEALED_STATE_VERSION_MRENCLAVE 1
+
+// authenticated but unencrypted metadata of the sealed state identifying the sealing enclave
+typedef struct {
+    uint32_t version;
+    uint16_t isv_prod_id;
+    uint16_t isv_svn;
+    sgx_measurement_t mrenclave;
+} sealed_state_metadata_t;
+
+// incremented whenever the state is sealed; a restored state must not be older than the current one
+static uint64_t seal_sequence = 0;
+
+static void append_uint32(std::string &out, uint32_t n)
+{
+    out.append((const char *)&n, sizeof(n));
+}
+
+static void append_item(std::string &out, const std::string &item)
+{
+    append_uint32(out, item.size());
+    out.append(item);
+}
+
+static bool read_bytes(const uint8_t *&p, const uint8_t *end, void *out, uint32_t len)
+{
+    if ((uint32_t)(end - p) < len) {
+        return false;
+    }
+    memcpy(out, p, len);
+    p += len;
+    return true;
+}
+
+static bool read_item(const uint8_t *&p, const uint8_t *end, std::string &item)
+{
+    uint32_t len;
+    if (!read_bytes(p, end, &len, sizeof(len)) || (uint32_t)(end - p) < len) {
+        return false;
+    }
+    item.assign((const char *)p, len);
+    p += len;
+    return true;
+}
+
+// state <- version || sequence || enclave sk || enclave pk || state key || #secrets || secrets ||
+// replay state, all integers little endian, secret names and values length-prefixed
+static void serialize_state(std::string &out)
+{
+    append_uint32(out, SEALED_STATE_VERSION);
+    out.append((const char *)&seal_sequence, sizeof(seal_sequence));
+    out.append((const char *)&enclave_sk, sizeof(sgx_ec256_private_t));
+    out.append((const char *)&enclave_pk, sizeof(sgx_ec256_public_t));
+    out.append((const char *)&state_encryption_key, sizeof(sgx_aes_gcm_128bit_key_t));
+    append_uint32(out, provisioned_secrets.size());
+    for (auto it = provisioned_secrets.begin(); it != provisioned_secrets.end(); ++it) {
+        append_item(out, it->first);
+        append_item(out, it->second);
+    }
+    replay_serialize(out);
+}
+
+// seals the enclave identity, the state key and the provisioned secrets to the enclave signer
+// (mrsigner), so that an orderly restarted peer can resume with the registered enclave and an
+// updated enclave build of the same product can take over the state key and the secrets
+int ecall_seal_state(uint8_t *sealed, uint32_t sealed_len_in, uint32_t *sealed_len_out)
+{
+    const sgx_report_t *self = sgx_self_report();
+    sealed_state_metadata_t metadata;
+    memset(&metadata, 0, sizeof(metadata));
+    metadata.version = SEALED_STATE_VERSION;
+    metadata.isv_prod_id = self->body.isv_prod_id;
+    metadata.isv_svn = self->body.isv_svn;
+    memcpy(&metadata.mrenclave, &self->body.mr_enclave, sizeof(sgx_measurement_t));
+
+    seal_sequence++;
+    std::string plain;
+    serialize_state(plain);
+
+    uint32_t sealed_len = sgx_calc_sealed_data_size(sizeof(metadata), plain.size());
+    *sealed_len_out = sealed_len;
+    if (sealed_len == UINT32_MAX || sealed_len > sealed_len_in) {
+        LOG_ERROR("Sealed state buffer too small: %u", sealed_len);
+        memset(&plain[0], 0, plain.size());
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    // sgx_seal_data uses the mrsigner policy; the key also depends on the isv svn so that enclaves
+    // with a lower svn can not unseal the state
+    int sgx_ret = sgx_seal_data(sizeof(metadata), (const uint8_t *)&metadata, plain.size(),
+        (const uint8_t *)plain.c_str(), sealed_len, (sgx_sealed_data_t *)sealed);
+    memset(&plain[0], 0, plain.size());
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Seal state error: %x", sgx_ret);
+        return sgx_ret;
+    }
+
+    LOG_DEBUG("State sealed (version %u, sequence %llu)", metadata.version, seal_sequence);
+    return SGX_SUCCESS;
+}
+
+// unseals the state and its metadata; version 1 states have no metadata and could only be unsealed
+// by the sealing enclave itself
+static int unseal_state(const uint8_t *sealed, uint32_t sealed_len, std::string &plain,
+    sealed_state_metadata_t *metadata)
+{
+    if (sealed_len < sizeof(sgx_sealed_data_t)) {
+        LOG_ERROR("Sealed state too short");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    uint32_t plain_len = sgx_get_encrypt_txt_len((const sgx_sealed_data_t *)sealed);
+    uint32_t metadata_len = sgx_get_add_mac_txt_len((const sgx_sealed_data_t *)sealed);
+    if (plain_len == UINT32_MAX || metadata_len == UINT32_MAX ||
+        (metadata_len != 0 && metadata_len != sizeof(sealed_state_metadata_t)) ||
+        sgx_calc_sealed_data_size(metadata_len, plain_len) != sealed_len) {
+        LOG_ERROR("Invalid sealed state size");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    plain.assign(plain_len, '\0');
+    int sgx_ret = sgx_unseal_data((const sgx_sealed_data_t *)sealed,
+        metadata_len != 0 ? (uint8_t *)metadata : NULL, metadata_len != 0 ? &metadata_len : NULL,
+        (uint8_t *)&plain[0], &plain_len);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Unseal state error: %x", sgx_ret);
+        return sgx_ret;
+    }
+
+    if (metadata_len == 0) {
+        // unsealing under the mrenclave policy succeeded, thus, we sealed it ourselves
+        const sgx_report_t *self = sgx_self_report();
+        metadata->version = SEALED_STATE_VERSION_MRENCLAVE;
+        metadata->isv_prod_id = self->body.isv_prod_id;
+        metadata->isv_svn = self->body.isv_svn;
+        memcpy(&metadata->mrenclave, &self->body.mr_enclave, sizeof(sgx_measurement_t));
+    }
+    return SGX_SUCCESS;
+}
+
+// restores a state sealed by ecall_seal_state. If this enclave sealed the state, it resumes with the
+// sealed identity. If a predecessor build of the same product sealed it, the state is migrated: this
+// enclave takes over the state key and the provisioned secrets but keeps its new identity, which must
+// be registered at ercc. The next seal upgrades the state to the current version.
+int ecall_unseal_state(const uint8_t *sealed, uint32_t sealed_len, uint32_t *identity_restored)
+{
+    *identity_restored = 0;
+
+    std::string plain;
+    sealed_state_metadata_t metadata;
+    memset(&metadata, 0, sizeof(metadata));
+    int sgx_ret = unseal_state(sealed, sealed_len, plain, &metadata);
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    const sgx_report_t *self = sgx_self_report();
+    if (metadata.version > SEALED_STATE_VERSION) {
+        LOG_ERROR("Sealed state version %u not supported", metadata.version);
+        memset(&plain[0], 0, plain.size());
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    // the signer may sign other enclaves, which must not get our secrets; the sgx key derivation
+    // already prevents a lower svn, checked again for clearer errors
+    if (metadata.isv_prod_id != self->body.isv_prod_id || metadata.isv_svn > self->body.isv_svn) {
+        LOG_ERROR("Sealed state of another product or a newer svn");
+        memset(&plain[0], 0, plain.size());
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    const uint8_t *p = (const uint8_t *)plain.c_str();
+    const uint8_t *end = p + plain.size();
+
+    uint32_t version;
+    uint64_t sequence;
+    sgx_ec256_private_t sk;
+    sgx_ec256_public_t pk;
+    sgx_aes_gcm_128bit_key_t key;
+    uint32_t secrets_count;
+    std::map<std::string, std::string> secrets;
+    replay_state_t replay = {0, {}};
+
+    // versions 1 and 2 share the payload layout, version 3 adds the replay state
+    bool ok = read_bytes(p, end, &version, sizeof(version)) && version == metadata.version &&
+              read_bytes(p, end, &sequence, sizeof(sequence)) &&
+              read_bytes(p, end, &sk, sizeof(sk)) && read_bytes(p, end, &pk, sizeof(pk)) &&
+              read_bytes(p, end, &key, sizeof(key)) &&
+              read_bytes(p, end, &secrets_count, sizeof(secrets_count));
+    for (uint32_t i = 0; ok && i < secrets_count; i++) {
+        std::string name, value;
+        ok = read_item(p, end, name) && read_item(p, end, value);
+        secrets[name] = value;
+    }
+    if (ok && version > SEALED_STATE_VERSION_NO_REPLAY) {
+        ok = replay_deserialize(p, end, replay);
+    }
+    memset(&plain[0], 0, plain.size());
+    if (!ok || p != end) {
+        LOG_ERROR("Invalid sealed state");
+        memset(&sk, 0, sizeof(sk));
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    // the untrusted host may replay older sealed states; at least never go back within a run
+    if (sequence < seal_sequence) {
+        LOG_ERROR("Sealed state is older than the current state");
+        memset(&sk, 0, sizeof(sk));
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    // the identity is attested for the mrenclave that created it, thus, it is never migrated
+    if (memcmp(&metadata.mrenclave, &self->body.mr_enclave, sizeof(sgx_measurement_t)) == 0) {
+        memcpy(&enclave_sk, &sk, sizeof(sgx_ec256_private_t));
+        memcpy(&enclave_pk, &pk, sizeof(sgx_ec256_public_t));
+        *identity_restored = 1;
+    } else {
+        LOG_DEBUG("Migrating sealed state of predecessor (svn %u)", metadata.isv_svn);
+    }
+    memcpy(&state_encryption_key, &key, sizeof(sgx_aes_gcm_128bit_key_t));
+    provisioned_secrets.swap(secrets);
+    replay_restore(replay);
+    seal_sequence = sequence;
+    memset(&sk, 0, sizeof(sk));
+
+    LOG_DEBUG("State unsealed (version %u, sequence %llu)", metadata.version, seal_sequence);
+    return SGX_SUCCESS;
+}
+
+int get_secret(const char *name, std::string &secret)
+{
+    auto it = provisioned_secrets.find(std::string(name));
+    if (it == provisioned_secrets.end()) {
+        return -1;
+    }
+    secret = it->second;
+    return 0;
+}
+
+// hash a 32 bit big endian integer
+static void rwset_digest_update_uint32(uint32_t n, sgx_sha_state_handle_t sha_handle)
+{
+    uint8_t n_be[4] = {(uint8_t)(n >> 24), (uint8_t)(n >> 16), (uint8_t)(n >> 8), (uint8_t)n};
+    sgx_sha256_update(n_be, sizeof(n_be), sha_handle);
+}
+
+// hash a length-prefixed item
+static void rwset_digest_update(const std::string &item, sgx_sha_state_handle_t sha_handle)
+{
+    rwset_digest_update_uint32(item.size(), sha_handle);
+    sgx_sha256_update((const uint8_t *)item.c_str(), item.size(), sha_handle);
+}
+
+// rwset digest <- H(#reads || keys || #writes || keys and values), all integers 32 bit big endian,
+// all items length-prefixed and sorted by key; see utils.RWSetDigest
+static void compute_rwset_digest(
+    read_set_t &readset, write_set_t &writeset, sgx_sha256_hash_t *digest)
+{
+    sgx_sha_state_handle_t sha_handle;
+    sgx_sha256_init(&sha_handle);
+
+    LOG_DEBUG("read_set:");
+    rwset_digest_update_uint32(readset.size(), sha_handle);
+    for (auto &it : readset) {
+        LOG_DEBUG("\\-> %s", it.c_str());
+        rwset_digest_update(it, sha_handle);
+    }
+
+    LOG_DEBUG("write_set:");
+    rwset_digest_update_uint32(writeset.size(), sha_handle);
+    for (auto &it : writeset) {
+        LOG_DEBUG("\\-> %s - %s", it.first.c_str(), it.second.c_str());
+        rwset_digest_update(it.first, sha_handle);
+        rwset_digest_update(it.second, sha_handle);
+    }
+
+    sgx_sha256_get_hash(sha_handle, digest);
+    sgx_sha256_close(sha_handle);
+}
+
+// the response of a failed invocation to the client, {"code": <code>, "message": <message>}
+// encrypted with the key of its request, see errors.h
+static int seal_error(sgx_aes_gcm_128bit_key_t *key, int code, const std::string &message,
+    uint8_t *response, uint32_t max_response_len, uint32_t *actual_response_len)
+{
+    JSON_Value *root = json_value_init_object();
+    JSON_Object *object = json_value_get_object(root);
+    json_object_set_number(object, "code", code);
+    json_object_set_string(object, "message", message.c_str());
+    char *serialized = json_serialize_to_string(root);
+    uint32_t len = strlen(serialized);
+    int ret = SGX_ERROR_INVALID_PARAMETER;
+    if (len <= max_response_len) {
+        memcpy(response, serialized, len);
+        *actual_response_len = len;
+        ret = encrypt_response(key, response, max_response_len, actual_response_len);
+    }
+    json_free_serialized_string(serialized);
+    json_value_free(root);
+    if (ret != SGX_SUCCESS) {
+        *actual_response_len = 0;
+    }
+    return ret;
+}
+
+// chaincode call
+// output, response <- F(args, input)
+// signature <- sign (hash,sk)
+int ecall_get_audit_records(uint8_t *records, uint32_t max_records_len, uint32_t *records_len)
+{
+    std::string out = audit_drain(max_records_len);
+    memcpy(records, out.c_str(), out.size());
+    *records_len = out.size();
+    return SGX_SUCCESS;
+}
+
+int ecall_invoke(const char *args, const char *pk, uint8_t *response, uint32_t response_len_in,
+    uint32_t *response_len_out, sgx_ec256_signature_t *signature, void *ctx)
+{
+    // register ctx
+    read_set_t readset;
+    write_set_t writeset;
+
+    register_rwset(ctx, &readset, &writeset);
+
+    // call chaincode invoke logic: creates output and response
+    // output, response <- F(args, input)
+    int ret;
+    sgx_aes_gcm_128bit_key_t reply_key;
+    bool has_reply_key = false;
+    if (strlen(pk) == 0) {
+        // clear input
+        ret = authorized_invoke(args, response, response_len_in, response_len_out, ctx);
+    } else {
+        // encrypted input
+        ret = invoke_enc(args, pk, response, response_len_in, response_len_out, &reply_key,
+            &has_reply_key, ctx);
+    }
+
+    // an invocation denied access to state must not be endorsed; neither must one whose decryptions
+    // are not on the record
+    bool failed = ret != 0 || access_violated(ctx);
+    if (!failed && audit_flush(ctx) != SGX_SUCCESS) {
+        record_error(ctx, FPC_ERROR_INTERNAL, "Cannot record decryptions in the audit log");
+        failed = true;
+    }
+    std::string error_message;
+    int error_code = take_error(ctx, error_message);
+    if (failed) {
+        // a stale pseudonym or creator must not carry over to a later invocation with the same ctx
+        audit_discard(ctx);
+        free_nym(ctx);
+        free_creator(ctx);
+        // the response is the error, if the client can read it; the peer only learns the code
+        *response_len_out = 0;
+        if (has_reply_key) {
+            seal_error(&reply_key, error_code, error_message, response, response_len_in,
+                response_len_out);
+        }
+        memset(&reply_key, 0, sizeof(reply_key));
+        return FPC_ERROR_CODE(error_code);
+    }
+    memset(&reply_key, 0, sizeof(reply_key));
+
+    // digest of the read-write set captured by the shim; validators recompute it from the
+    // read-write set of the proposal response
+    sgx_sha256_hash_t rwset_digest;
+    compute_rwset_digest(readset, writeset, &rwset_digest);
+
+    // create Hash <- H(args || result || H(read-write set))
+    sgx_sha256_hash_t hash;
+    sgx_sha_state_handle_t sha_handle;
+    sgx_sha256_init(&sha_handle);
+    sgx_sha256_update((const uint8_t *)args, strlen(args), sha_handle);
+    sgx_sha256_update(response, *response_len_out, sha_handle);
+    sgx_sha256_update((const uint8_t *)rwset_digest, sizeof(rwset_digest), sha_handle);
     sgx_sha256_get_hash(sha_handle, &hash);
     sgx_sha256_close(sha_handle);
 
     // clean context
     free_rwset(ctx);
+    free_nym(ctx);
+    free_creator(ctx);
 
     // sig <- sign (hash,sk)
     uint8_t sig[sizeof(sgx_ec256_signature_t)];
@@ -0,0 +1,63 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#include "errors.h"
+
+#include "sgx_thread.h"
+
+#include <stdarg.h>
+#include <stdio.h>
+#include <map>
+
+typedef struct
+{
+    int code;
+    std::string message;
+} invocation_error_t;
+
+// errors of invocations by ctx
+static std::map<void*, invocation_error_t> errors;
+static sgx_thread_mutex_t errors_mutex = SGX_THREAD_MUTEX_INITIALIZER;
+
+void record_error(void* ctx, int code, const char* format, ...)
+{
+    char message[MAX_ERROR_MESSAGE_LEN + 1];
+    va_list ap;
+    va_start(ap, format);
+    vsnprintf(message, sizeof(message), format, ap);
+    va_end(ap);
+
+    sgx_thread_mutex_lock(&errors_mutex);
+    if (errors.count(ctx) == 0) {
+        errors[ctx] = {code, message};
+    }
+    sgx_thread_mutex_unlock(&errors_mutex);
+}
+
+int take_error(void* ctx, std::string& message)
+{
+    int code = FPC_ERROR_INTERNAL;
+    message = "";
+    sgx_thread_mutex_lock(&errors_mutex);
+    auto search = errors.find(ctx);
+    if (search != errors.end()) {
+        code = search->second.code;
+        message = search->second.message;
+        errors.erase(search);
+    }
+    sgx_thread_mutex_unlock(&errors_mutex);
+    return code;
+}
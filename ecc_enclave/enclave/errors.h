@@ -0,0 +1,51 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <string>
+
+// Failed invocations. The peer learns a generic code only, ecall_invoke returns
+// FPC_ERROR_CODE(code); a client whose request the enclave decrypted also gets the details as
+// response, {"code": <code>, "message": <message>} encrypted with the key of its request.
+// Chaincodes may record why an invocation failed before they return non-zero, e.g.,
+//
+//   record_error(ctx, FPC_ERROR_CHAINCODE, "Auction %s is closed", name);
+#define FPC_ERROR_BASE 0xfc00
+#define FPC_ERROR_CODE(code) (FPC_ERROR_BASE | (code))
+
+#define FPC_ERROR_INTERNAL 1
+// malformed, oversized or undecryptable requests
+#define FPC_ERROR_INVALID_REQUEST 2
+// invalid pseudonym or creator signatures
+#define FPC_ERROR_UNAUTHENTICATED 3
+// requests seen before, see replay.h
+#define FPC_ERROR_REPLAYED 4
+// requests denied access to functions or state, see abac.h
+#define FPC_ERROR_ACCESS_DENIED 5
+// requests exceeding the limits of the chaincode, see limits.h
+#define FPC_ERROR_LIMIT_EXCEEDED 6
+// chaincode returned non-zero
+#define FPC_ERROR_CHAINCODE 7
+
+// messages are truncated to fit into the response
+#define MAX_ERROR_MESSAGE_LEN 256
+
+// records why the invocation of ctx failed; the first error wins as later ones tend to follow from it
+void record_error(void* ctx, int code, const char* format, ...);
+// returns the code and message of the error recorded for the invocation of ctx, FPC_ERROR_INTERNAL
+// if none, and forgets it
+int take_error(void* ctx, std::string& message);
@@ -0,0 +1,124 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#include "escrow.h"
+#include "logging.h"
+
+#include "sgx_trts.h"
+
+#include <string.h>
+
+// multiplication in GF(2^8) modulo x^8 + x^4 + x^3 + x + 1 without secret dependent branches
+static uint8_t gf_mul(uint8_t a, uint8_t b)
+{
+    uint8_t p = 0;
+    for (int i = 0; i < 8; i++) {
+        p ^= a & (uint8_t)(-(b & 1));
+        a = (uint8_t)((a << 1) ^ (0x1b & (uint8_t)(-(a >> 7))));
+        b >>= 1;
+    }
+    return p;
+}
+
+// inverse in GF(2^8) as a^254; a must not be 0
+static uint8_t gf_inv(uint8_t a)
+{
+    uint8_t result = 1;
+    uint8_t square = a;
+    for (int e = 254; e > 0; e >>= 1) {
+        if (e & 1) {
+            result = gf_mul(result, square);
+        }
+        square = gf_mul(square, square);
+    }
+    return result;
+}
+
+bool shamir_split(const std::string& secret, uint32_t threshold, uint32_t n,
+    std::vector<std::string>& shares)
+{
+    if (threshold < 1 || threshold > n || n > MAX_ESCROW_PARTIES) {
+        LOG_ERROR("Escrow: Invalid threshold %u of %u", threshold, n);
+        return false;
+    }
+
+    // one polynomial of degree threshold - 1 per byte with the byte as constant term
+    std::vector<uint8_t> coefficients(secret.size() * threshold);
+    for (size_t i = 0; i < secret.size(); i++) {
+        coefficients[i * threshold] = (uint8_t)secret[i];
+    }
+    for (size_t i = 0; i < secret.size() && threshold > 1; i++) {
+        if (sgx_read_rand(&coefficients[i * threshold + 1], threshold - 1) != SGX_SUCCESS) {
+            LOG_ERROR("Escrow: Can not read randomness");
+            memset(coefficients.data(), 0, coefficients.size());
+            return false;
+        }
+    }
+
+    shares.clear();
+    for (uint32_t x = 1; x <= n; x++) {
+        std::string share(1, (char)x);
+        for (size_t i = 0; i < secret.size(); i++) {
+            // horner's scheme
+            uint8_t y = 0;
+            for (uint32_t j = threshold; j > 0; j--) {
+                y = gf_mul(y, (uint8_t)x) ^ coefficients[i * threshold + j - 1];
+            }
+            share.push_back((char)y);
+        }
+        shares.push_back(share);
+    }
+    memset(coefficients.data(), 0, coefficients.size());
+    return true;
+}
+
+bool shamir_combine(const std::vector<std::string>& shares, std::string& secret)
+{
+    if (shares.empty() || shares.size() > MAX_ESCROW_PARTIES || shares[0].size() < 2) {
+        LOG_ERROR("Escrow: Invalid shares");
+        return false;
+    }
+    size_t len = shares[0].size() - 1;
+    for (size_t k = 0; k < shares.size(); k++) {
+        if (shares[k].size() != len + 1 || shares[k][0] == 0) {
+            LOG_ERROR("Escrow: Malformed share");
+            return false;
+        }
+        for (size_t m = 0; m < k; m++) {
+            if (shares[m][0] == shares[k][0]) {
+                LOG_ERROR("Escrow: Duplicate share %u", (uint8_t)shares[k][0]);
+                return false;
+            }
+        }
+    }
+
+    // lagrange basis polynomials at 0: l_k = prod_{m != k} x_m / (x_m - x_k); minus is xor
+    secret.assign(len, 0);
+    for (size_t k = 0; k < shares.size(); k++) {
+        uint8_t xk = (uint8_t)shares[k][0];
+        uint8_t l = 1;
+        for (size_t m = 0; m < shares.size(); m++) {
+            if (m != k) {
+                uint8_t xm = (uint8_t)shares[m][0];
+                l = gf_mul(l, gf_mul(xm, gf_inv(xm ^ xk)));
+            }
+        }
+        for (size_t i = 0; i < len; i++) {
+            secret[i] = (char)((uint8_t)secret[i] ^ gf_mul(l, (uint8_t)shares[k][i + 1]));
+        }
+    }
+    return true;
+}
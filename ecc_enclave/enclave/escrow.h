@@ -0,0 +1,41 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <cstdint>
+#include <string>
+#include <vector>
+
+// Threshold escrow of the state key. The enclave splits the state key with Shamir's secret sharing
+// over GF(2^8) into one share per recovery party, any threshold of which reconstruct it, and
+// encrypts each share to the key of its party. After the loss of all enclaves, threshold parties
+// decrypt their shares and re-encrypt them to a new enclave, which combines them. A share is the
+// x-coordinate (1..255) followed by one y-coordinate per byte of the secret.
+
+// recovery parties per escrow at most; x-coordinates are single non-zero bytes
+#define MAX_ESCROW_PARTIES 255
+// label of the check value that identifies the state key an escrow was created for
+#define ESCROW_CHECK_LABEL "FPC escrow check"
+
+// splits secret into n shares of which threshold reconstruct it; returns false on invalid
+// parameters or if no randomness is available
+bool shamir_split(const std::string& secret, uint32_t threshold, uint32_t n,
+    std::vector<std::string>& shares);
+// combines shares into the secret by interpolation at x = 0; returns false if the shares are
+// malformed or share an x-coordinate. Too few shares yield a wrong secret, thus, callers check the
+// result against a check value.
+bool shamir_combine(const std::vector<std::string>& shares, std::string& secret);
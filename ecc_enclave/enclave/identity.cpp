@@ -0,0 +1,181 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#include "identity.h"
+#include "logging.h"
+
+#include "parson.h"
+
+// openssl
+#include <openssl/bio.h>
+#include <openssl/ec.h>
+#include <openssl/obj_mac.h>
+#include <openssl/objects.h>
+#include <openssl/pem.h>
+#include <openssl/x509.h>
+#include <openssl/x509_vfy.h>
+#include <openssl/x509v3.h>
+
+// extension in which the fabric CA stores the attributes of an identity as
+// {"attrs": {"<name>": "<value>", ...}}
+static const char* FABRIC_ATTRS_OID = "1.2.3.4.5.6.7.8.1";
+
+static void parse_ous(X509* cert, creator_t& creator)
+{
+    X509_NAME* subject = X509_get_subject_name(cert);
+    int i = -1;
+    while ((i = X509_NAME_get_index_by_NID(subject, NID_organizationalUnitName, i)) >= 0) {
+        ASN1_STRING* data = X509_NAME_ENTRY_get_data(X509_NAME_get_entry(subject, i));
+        creator.ous.push_back(
+            std::string((const char*)ASN1_STRING_get0_data(data), ASN1_STRING_length(data)));
+    }
+}
+
+static int parse_attrs(X509* cert, creator_t& creator)
+{
+    ASN1_OBJECT* oid = OBJ_txt2obj(FABRIC_ATTRS_OID, 1);
+    int i = X509_get_ext_by_OBJ(cert, oid, -1);
+    ASN1_OBJECT_free(oid);
+    if (i < 0) {
+        // certificates without attributes are fine
+        return 0;
+    }
+
+    ASN1_OCTET_STRING* data = X509_EXTENSION_get_data(X509_get_ext(cert, i));
+    std::string json((const char*)ASN1_STRING_get0_data(data), ASN1_STRING_length(data));
+    JSON_Value* root = json_parse_string(json.c_str());
+    JSON_Object* attrs = json_object_get_object(json_value_get_object(root), "attrs");
+    if (attrs == NULL) {
+        LOG_ERROR("Identity: Malformed attributes extension");
+        json_value_free(root);
+        return -1;
+    }
+    for (size_t j = 0; j < json_object_get_count(attrs); j++) {
+        const char* value = json_string(json_object_get_value_at(attrs, j));
+        if (value != NULL) {
+            creator.attrs[json_object_get_name(attrs, j)] = value;
+        }
+    }
+    json_value_free(root);
+    return 0;
+}
+
+static int parse_pk(X509* cert, uint8_t pk[64])
+{
+    EVP_PKEY* pubkey = X509_get_pubkey(cert);
+    EC_KEY* eckey = pubkey != NULL ? EVP_PKEY_get1_EC_KEY(pubkey) : NULL;
+    EVP_PKEY_free(pubkey);
+    if (eckey == NULL ||
+        EC_GROUP_get_curve_name(EC_KEY_get0_group(eckey)) != NID_X9_62_prime256v1) {
+        LOG_ERROR("Identity: Certificate key is not a P-256 key");
+        EC_KEY_free(eckey);
+        return -1;
+    }
+
+    // uncompressed point 0x04 || x || y
+    uint8_t point[65];
+    size_t len = EC_POINT_point2oct(EC_KEY_get0_group(eckey), EC_KEY_get0_public_key(eckey),
+        POINT_CONVERSION_UNCOMPRESSED, point, sizeof(point), NULL);
+    EC_KEY_free(eckey);
+    if (len != sizeof(point)) {
+        LOG_ERROR("Identity: Cannot encode certificate key");
+        return -1;
+    }
+    memcpy(pk, point + 1, 64);
+    return 0;
+}
+
+// distinguished name in RFC 2253 format, e.g., CN=user1,OU=client,O=org1
+static std::string name_string(X509_NAME* name)
+{
+    BIO* mem = BIO_new(BIO_s_mem());
+    X509_NAME_print_ex(mem, name, 0, XN_FLAG_RFC2253);
+    char* data = NULL;
+    long len = BIO_get_mem_data(mem, &data);
+    std::string str(data, len);
+    BIO_free_all(mem);
+    return str;
+}
+
+int parse_creator_cert(const std::string& cert_pem, creator_t& creator, uint8_t pk[64])
+{
+    BIO* mem = BIO_new_mem_buf(cert_pem.c_str(), cert_pem.size());
+    X509* cert = PEM_read_bio_X509(mem, NULL, 0, NULL);
+    BIO_free_all(mem);
+    if (cert == NULL) {
+        LOG_ERROR("Identity: Cannot parse certificate");
+        return -1;
+    }
+
+    creator.cert = cert_pem;
+    creator.id = "x509::" + name_string(X509_get_subject_name(cert)) +
+                 "::" + name_string(X509_get_issuer_name(cert));
+    parse_ous(cert, creator);
+    int ret = parse_attrs(cert, creator);
+    if (ret == 0) {
+        ret = parse_pk(cert, pk);
+    }
+    X509_free(cert);
+    return ret;
+}
+
+int validate_creator_cert(const std::string& cert_pem, const std::string& ca_pem)
+{
+    BIO* mem = BIO_new_mem_buf(cert_pem.c_str(), cert_pem.size());
+    X509* cert = PEM_read_bio_X509(mem, NULL, 0, NULL);
+    BIO_free_all(mem);
+    if (cert == NULL) {
+        LOG_ERROR("Identity: Cannot parse certificate");
+        return -1;
+    }
+
+    // self-signed certificates of the bundle are the roots, all others are intermediates
+    X509_STORE* roots = X509_STORE_new();
+    STACK_OF(X509)* intermediates = sk_X509_new_null();
+    int root_count = 0;
+    mem = BIO_new_mem_buf(ca_pem.c_str(), ca_pem.size());
+    X509* ca = NULL;
+    while ((ca = PEM_read_bio_X509(mem, NULL, 0, NULL)) != NULL) {
+        if (X509_check_issued(ca, ca) == X509_V_OK) {
+            X509_STORE_add_cert(roots, ca);
+            X509_free(ca);
+            root_count++;
+        } else {
+            sk_X509_push(intermediates, ca);
+        }
+    }
+    BIO_free_all(mem);
+
+    int ret = -1;
+    if (root_count == 0) {
+        LOG_ERROR("Identity: No root certificates");
+    } else {
+        X509_STORE_CTX* ctx = X509_STORE_CTX_new();
+        X509_STORE_CTX_init(ctx, roots, cert, intermediates);
+        if (X509_verify_cert(ctx) == 1) {
+            ret = 0;
+        } else {
+            LOG_ERROR("Identity: Invalid certificate: %s",
+                X509_verify_cert_error_string(X509_STORE_CTX_get_error(ctx)));
+        }
+        X509_STORE_CTX_free(ctx);
+    }
+
+    sk_X509_pop_free(intermediates, X509_free);
+    X509_STORE_free(roots);
+    X509_free(cert);
+    return ret;
+}
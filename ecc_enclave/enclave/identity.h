@@ -0,0 +1,29 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <string>
+
+#include "shim.h"
+
+// parses a PEM encoded X.509 certificate of a client into creator and returns the P-256 key of the
+// certificate in pk (big endian); returns 0 on success
+int parse_creator_cert(const std::string& cert_pem, creator_t& creator, uint8_t pk[64]);
+
+// validates the certificate chain of a client certificate against the CA certificates of its MSP, a
+// PEM bundle of self-signed roots and intermediates as served by tlcc; returns 0 if the chain is valid
+int validate_creator_cert(const std::string& cert_pem, const std::string& ca_pem);
@@ -0,0 +1,54 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <cstdint>
+
+// Limits of a single invocation, so that one request can neither exhaust the enclave memory (EPC)
+// nor stall the peer with endless state access. The chaincode returns its limits from
+// get_request_limits (see chaincode.h), e.g.,
+//
+//   static const request_limits limits = {64 * 1024, 500, 2000, 1024 * 1024};
+//   const request_limits* get_request_limits() { return &limits; }
+//
+// or NULL for the defaults below. The enclave refuses requests whose args exceed max_args_size
+// before it runs the chaincode; an invocation exceeding one of the other limits fails like one
+// denied access to state (see access_violated): the shim hands out no more state, and the enclave
+// does not endorse the invocation.
+typedef struct
+{
+    // bytes of the args of an invocation, i.e., the JSON array after decryption and inflation
+    uint32_t max_args_size;
+    // values a partial composite key query may return
+    uint32_t max_query_results;
+    // reads, writes and queries of state
+    uint32_t max_state_accesses;
+    // bytes of state read and written, including the values of queries
+    uint32_t max_state_bytes;
+} request_limits;
+
+#define DEFAULT_MAX_ARGS_SIZE (128 * 1024)
+#define DEFAULT_MAX_QUERY_RESULTS 2000
+#define DEFAULT_MAX_STATE_ACCESSES 10000
+#define DEFAULT_MAX_STATE_BYTES (4 * 1024 * 1024)
+
+// bytes an encrypted request may add to its args, e.g., the creator's certificate; compressed args
+// are base64 encoded and thus may take up to 4/3 of max_args_size in the request
+#define MAX_ENVELOPE_OVERHEAD (16 * 1024)
+
+// returns the limits of the chaincode, or the defaults
+const request_limits& get_limits();
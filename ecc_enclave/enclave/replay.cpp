@@ -0,0 +1,178 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#include "replay.h"
+#include "logging.h"
+
+#include "sgx_thread.h"
+
+#include <string.h>
+
+static replay_state_t replay_state = {0, {}};
+static sgx_thread_mutex_t replay_mutex = SGX_THREAD_MUTEX_INITIALIZER;
+
+// forgets the client with the oldest newest request
+static void forget_oldest_client()
+{
+    auto oldest = replay_state.clients.begin();
+    for (auto it = replay_state.clients.begin(); it != replay_state.clients.end(); ++it) {
+        if (it->second.high < oldest->second.high) {
+            oldest = it;
+        }
+    }
+    if (oldest->second.high > replay_state.forgotten_high) {
+        replay_state.forgotten_high = oldest->second.high;
+    }
+    replay_state.clients.erase(oldest);
+}
+
+int replay_check(const std::string& client, uint64_t seq, const std::string& _nonce)
+{
+    std::string nonce = _nonce.substr(0, REPLAY_NONCE_SIZE);
+    sgx_thread_mutex_lock(&replay_mutex);
+
+    auto it = replay_state.clients.find(client);
+    if (it == replay_state.clients.end()) {
+        // a forgotten client may return with a replay
+        if (seq <= replay_state.forgotten_high) {
+            sgx_thread_mutex_unlock(&replay_mutex);
+            LOG_ERROR("Replay: Request of unknown client is too old");
+            return -1;
+        }
+        if (replay_state.clients.size() >= MAX_REPLAY_CLIENTS) {
+            forget_oldest_client();
+        }
+        replay_window_t window = {seq, 0, {}};
+        it = replay_state.clients.insert(std::make_pair(client, window)).first;
+    }
+
+    replay_window_t& window = it->second;
+    std::pair<uint64_t, std::string> request(seq, nonce);
+    bool too_old = seq <= window.low || (seq < window.high && window.high - seq > REPLAY_WINDOW);
+    if (too_old || window.seen.count(request) > 0) {
+        sgx_thread_mutex_unlock(&replay_mutex);
+        LOG_ERROR("Replay: Request replayed or too old");
+        return -1;
+    }
+
+    window.seen.insert(request);
+    if (seq > window.high) {
+        window.high = seq;
+    }
+    // drop what fell out of the window
+    while (!window.seen.empty() && (window.seen.size() > REPLAY_WINDOW_SIZE ||
+                                       window.high - window.seen.begin()->first > REPLAY_WINDOW)) {
+        if (window.seen.begin()->first > window.low) {
+            window.low = window.seen.begin()->first;
+        }
+        window.seen.erase(window.seen.begin());
+    }
+
+    sgx_thread_mutex_unlock(&replay_mutex);
+    return 0;
+}
+
+static void append_bytes(std::string& out, const void* bytes, uint32_t len)
+{
+    out.append((const char*)bytes, len);
+}
+
+static void append_item(std::string& out, const std::string& item)
+{
+    uint32_t len = item.size();
+    append_bytes(out, &len, sizeof(len));
+    out.append(item);
+}
+
+static bool read_bytes(const uint8_t*& p, const uint8_t* end, void* out, uint32_t len)
+{
+    if ((uint32_t)(end - p) < len) {
+        return false;
+    }
+    memcpy(out, p, len);
+    p += len;
+    return true;
+}
+
+static bool read_item(const uint8_t*& p, const uint8_t* end, std::string& item)
+{
+    uint32_t len;
+    if (!read_bytes(p, end, &len, sizeof(len)) || (uint32_t)(end - p) < len) {
+        return false;
+    }
+    item.assign((const char*)p, len);
+    p += len;
+    return true;
+}
+
+// replay state <- forgotten high || #clients || (client || high || low || #seen || (seq || nonce)*)*, all
+// integers little endian, clients and nonces length-prefixed
+void replay_serialize(std::string& out)
+{
+    sgx_thread_mutex_lock(&replay_mutex);
+    append_bytes(out, &replay_state.forgotten_high, sizeof(uint64_t));
+    uint32_t clients_count = replay_state.clients.size();
+    append_bytes(out, &clients_count, sizeof(clients_count));
+    for (auto& client : replay_state.clients) {
+        append_item(out, client.first);
+        append_bytes(out, &client.second.high, sizeof(uint64_t));
+        append_bytes(out, &client.second.low, sizeof(uint64_t));
+        uint32_t seen_count = client.second.seen.size();
+        append_bytes(out, &seen_count, sizeof(seen_count));
+        for (auto& request : client.second.seen) {
+            append_bytes(out, &request.first, sizeof(uint64_t));
+            append_item(out, request.second);
+        }
+    }
+    sgx_thread_mutex_unlock(&replay_mutex);
+}
+
+bool replay_deserialize(const uint8_t*& p, const uint8_t* end, replay_state_t& state)
+{
+    uint32_t clients_count;
+    if (!read_bytes(p, end, &state.forgotten_high, sizeof(uint64_t)) ||
+        !read_bytes(p, end, &clients_count, sizeof(clients_count))) {
+        return false;
+    }
+    for (uint32_t i = 0; i < clients_count; i++) {
+        std::string client;
+        replay_window_t window;
+        uint32_t seen_count;
+        if (!read_item(p, end, client) || !read_bytes(p, end, &window.high, sizeof(uint64_t)) ||
+            !read_bytes(p, end, &window.low, sizeof(uint64_t)) ||
+            !read_bytes(p, end, &seen_count, sizeof(seen_count))) {
+            return false;
+        }
+        for (uint32_t j = 0; j < seen_count; j++) {
+            uint64_t seq;
+            std::string nonce;
+            if (!read_bytes(p, end, &seq, sizeof(seq)) || !read_item(p, end, nonce)) {
+                return false;
+            }
+            window.seen.insert(std::make_pair(seq, nonce));
+        }
+        state.clients[client] = window;
+    }
+    return true;
+}
+
+void replay_restore(replay_state_t& state)
+{
+    sgx_thread_mutex_lock(&replay_mutex);
+    replay_state.forgotten_high = state.forgotten_high;
+    replay_state.clients.swap(state.clients);
+    sgx_thread_mutex_unlock(&replay_mutex);
+}
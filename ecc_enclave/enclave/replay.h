@@ -0,0 +1,69 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <cstdint>
+#include <map>
+#include <set>
+#include <string>
+#include <utility>
+
+// Replay protection of encrypted requests. Each request carries a sequence number, the time of the
+// client in nanoseconds since the epoch, and is encrypted with a fresh key of the client whose hash
+// serves as nonce. The enclave accepts a request once: the pair of sequence number and nonce must
+// not have been seen, and the sequence number must be within the window of the client, i.e., not
+// older than REPLAY_WINDOW before the newest request of the client nor older than the last
+// REPLAY_WINDOW_SIZE requests. Clients are identified by their creator id or their pseudonym; requests
+// without identity are not tracked, since anyone, including the peer, can create them anyway.
+
+// requests may be this much older than the newest request of their client (nanoseconds)
+#define REPLAY_WINDOW 60000000000ULL
+// requests remembered per client; older requests are refused
+#define REPLAY_WINDOW_SIZE 32
+// clients tracked at most; the client with the oldest newest request is forgotten first
+#define MAX_REPLAY_CLIENTS 1024
+// bytes of the nonce remembered
+#define REPLAY_NONCE_SIZE 16
+
+typedef struct
+{
+    // newest sequence number of the client
+    uint64_t high;
+    // requests with sequence numbers up to low are refused
+    uint64_t low;
+    // sequence numbers and nonces within the window
+    std::set<std::pair<uint64_t, std::string>> seen;
+} replay_window_t;
+
+typedef struct
+{
+    // sequence number of the newest request of all forgotten clients; requests of unknown clients
+    // must be newer
+    uint64_t forgotten_high;
+    std::map<std::string, replay_window_t> clients;
+} replay_state_t;
+
+// records the request of client with the given sequence number and nonce; returns 0 if the request
+// is fresh and -1 if it is a replay or too old
+int replay_check(const std::string& client, uint64_t seq, const std::string& nonce);
+
+// appends the replay state to out for sealing
+void replay_serialize(std::string& out);
+// reads a replay state written by replay_serialize; returns false if it is malformed
+bool replay_deserialize(const uint8_t*& p, const uint8_t* end, replay_state_t& state);
+// replaces the replay state, e.g., when restoring a sealed state
+void replay_restore(replay_state_t& state);
@@ -0,0 +1,186 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#include "session.h"
+#include "crypto.h"
+#include "logging.h"
+#include "utils.h"
+
+#include "base64.h"
+
+#include "sgx_thread.h"
+#include "sgx_trts.h"
+
+#include <stdlib.h>
+#include <string.h>
+#include <map>
+
+#define SESSION_ID_SIZE 16
+
+typedef struct
+{
+    sgx_sha256_hash_t chain;
+    // counter of the next message, i.e., the one chain derives the key of
+    uint64_t next;
+    int64_t expires;
+    std::string client_pk;
+} session_t;
+
+static std::map<std::string, session_t> sessions;
+static sgx_thread_mutex_t session_mutex = SGX_THREAD_MUTEX_INITIALIZER;
+
+// out = SHA256(label || in)
+static void session_hash(uint8_t label, const uint8_t* in, uint32_t in_len, sgx_sha256_hash_t* out)
+{
+    sgx_sha_state_handle_t sha_handle;
+    sgx_sha256_init(&sha_handle);
+    sgx_sha256_update(&label, 1, sha_handle);
+    sgx_sha256_update(in, in_len, sha_handle);
+    sgx_sha256_get_hash(sha_handle, out);
+    sgx_sha256_close(sha_handle);
+}
+
+// drops expired sessions and, if still full, the one expiring first
+static void make_room(int64_t now)
+{
+    for (auto it = sessions.begin(); it != sessions.end();) {
+        if (it->second.expires <= now) {
+            memset(&it->second.chain, 0, sizeof(sgx_sha256_hash_t));
+            it = sessions.erase(it);
+        } else {
+            ++it;
+        }
+    }
+    if (sessions.size() < MAX_SESSIONS) {
+        return;
+    }
+    auto first = sessions.begin();
+    for (auto it = sessions.begin(); it != sessions.end(); ++it) {
+        if (it->second.expires < first->second.expires) {
+            first = it;
+        }
+    }
+    memset(&first->second.chain, 0, sizeof(sgx_sha256_hash_t));
+    sessions.erase(first);
+}
+
+int session_open(const uint8_t* client_pk, int64_t now, std::string& id,
+    uint8_t enclave_pk[sizeof(sgx_ec256_public_t)], int64_t* expires)
+{
+    // fresh enclave key pair, used for this session only
+    sgx_ec256_private_t session_sk;
+    sgx_ec256_public_t session_pk;
+    sgx_ecc_state_handle_t ecc_handle = NULL;
+    sgx_ecc256_open_context(&ecc_handle);
+    int sgx_ret = sgx_ecc256_create_key_pair(&session_sk, &session_pk, ecc_handle);
+    sgx_ecc256_close_context(ecc_handle);
+    if (sgx_ret != SGX_SUCCESS) {
+        LOG_ERROR("Session: Create key pair error: %x", sgx_ret);
+        return sgx_ret;
+    }
+
+    sgx_aes_gcm_128bit_key_t root;
+    sgx_ret = derive_shared_key_with(&session_sk, client_pk, &root);
+    memset(&session_sk, 0, sizeof(sgx_ec256_private_t));
+    if (sgx_ret != SGX_SUCCESS) {
+        return sgx_ret;
+    }
+
+    session_t session;
+    std::string label = std::string("fpc.session") + std::string((const char*)root, sizeof(root));
+    memset(&root, 0, sizeof(root));
+    sgx_sha256_msg((const uint8_t*)label.c_str(), label.size(), &session.chain);
+    memset(&label[0], 0, label.size());
+    session.next = 0;
+    session.expires = now + SESSION_TTL;
+    session.client_pk = std::string((const char*)client_pk, sizeof(sgx_ec256_public_t));
+
+    uint8_t raw_id[SESSION_ID_SIZE];
+    sgx_read_rand(raw_id, sizeof(raw_id));
+    id = base64_encode(raw_id, sizeof(raw_id));
+
+    // the session pk in big endian, like all keys given to clients
+    memcpy(enclave_pk, &session_pk, sizeof(sgx_ec256_public_t));
+    bytes_swap(enclave_pk, 32);
+    bytes_swap(enclave_pk + 32, 32);
+    *expires = session.expires;
+
+    sgx_thread_mutex_lock(&session_mutex);
+    make_room(now);
+    sessions[id] = session;
+    sgx_thread_mutex_unlock(&session_mutex);
+    memset(&session.chain, 0, sizeof(sgx_sha256_hash_t));
+
+    LOG_DEBUG("Session: Opened session %s", id.c_str());
+    return SGX_SUCCESS;
+}
+
+int session_message_key(const char* session, int64_t now, sgx_aes_gcm_128bit_key_t* key,
+    std::string& client_pk)
+{
+    // <id>:<counter>
+    const char* sep = strchr(session, ':');
+    if (sep == NULL || sep[1] < '0' || sep[1] > '9') {
+        LOG_ERROR("Session: Malformed session message");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    std::string id(session, sep - session);
+    char* counter_end = NULL;
+    uint64_t counter = strtoull(sep + 1, &counter_end, 10);
+    if (*counter_end != '\0') {
+        LOG_ERROR("Session: Malformed session message");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    sgx_thread_mutex_lock(&session_mutex);
+    auto it = sessions.find(id);
+    if (it == sessions.end() || it->second.expires <= now) {
+        sgx_thread_mutex_unlock(&session_mutex);
+        LOG_ERROR("Session: Unknown or expired session");
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+    session_t& s = it->second;
+    if (counter < s.next || counter - s.next > SESSION_MAX_SKIP ||
+        counter >= SESSION_MAX_MESSAGES) {
+        sgx_thread_mutex_unlock(&session_mutex);
+        LOG_ERROR("Session: Message counter %llu used or out of range",
+            (unsigned long long)counter);
+        return SGX_ERROR_INVALID_PARAMETER;
+    }
+
+    // ratchet past skipped messages, whose keys are gone for good
+    sgx_sha256_hash_t next_chain;
+    for (; s.next < counter; s.next++) {
+        session_hash(0x02, s.chain, sizeof(s.chain), &next_chain);
+        memcpy(&s.chain, &next_chain, sizeof(sgx_sha256_hash_t));
+    }
+    sgx_sha256_hash_t message_key;
+    session_hash(0x01, s.chain, sizeof(s.chain), &message_key);
+    memcpy(key, message_key, sizeof(sgx_aes_gcm_128bit_key_t));
+    session_hash(0x02, s.chain, sizeof(s.chain), &next_chain);
+    memcpy(&s.chain, &next_chain, sizeof(sgx_sha256_hash_t));
+    s.next++;
+    client_pk = s.client_pk;
+    if (s.next >= SESSION_MAX_MESSAGES) {
+        memset(&s.chain, 0, sizeof(sgx_sha256_hash_t));
+        sessions.erase(it);
+    }
+    sgx_thread_mutex_unlock(&session_mutex);
+
+    memset(&next_chain, 0, sizeof(next_chain));
+    memset(&message_key, 0, sizeof(message_key));
+    return SGX_SUCCESS;
+}
@@ -0,0 +1,55 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <cstdint>
+#include <string>
+
+#include "sgx_tcrypto.h"
+
+// Sessions between a client and this enclave for workflows with many requests. A client opens a
+// session with a fresh key pair; the enclave answers with a fresh key pair of its own, and both
+// derive the root of a chain of message keys from the two, after which the enclave forgets its
+// session sk. Message n is encrypted with the key
+//     key_n = SHA256(0x01 || chain_n)[0:16], where chain_0 = SHA256("fpc.session" || root)
+//     and chain_n+1 = SHA256(0x02 || chain_n),
+// which also encrypts the response. Each side drops chain_n once it derived chain_n+1, so a leaked
+// message key exposes neither earlier nor later messages, and a leaked enclave sk does not expose
+// past sessions. Sessions live in enclave memory only and end with the enclave.
+
+// the client pk of an encrypted request names a session message as "session:<id>:<counter>"
+#define SESSION_PK_PREFIX "session:"
+// lifetime of a session in seconds of ledger time
+#define SESSION_TTL 3600
+// messages per session at most
+#define SESSION_MAX_MESSAGES 4096
+// messages a client may skip, e.g., if a proposal never reached the enclave
+#define SESSION_MAX_SKIP 64
+// sessions at most; the session expiring first is dropped to make room
+#define MAX_SESSIONS 256
+
+// opens a session with the client session pk (sgx format, big endian) at ledger time now. Returns
+// the session id and the enclave session pk (big endian) and 0 on success.
+int session_open(const uint8_t* client_pk, int64_t now, std::string& id,
+    uint8_t enclave_pk[sizeof(sgx_ec256_public_t)], int64_t* expires);
+
+// derives the key of message counter of a session, given as the part of the client pk after
+// SESSION_PK_PREFIX, at ledger time now, and ratchets the session past it. Returns the client
+// session pk (big endian), to which signatures of pseudonyms and creators bind requests, and 0
+// on success; fails for unknown or expired sessions and counters already used.
+int session_message_key(const char* session, int64_t now, sgx_aes_gcm_128bit_key_t* key,
+    std::string& client_pk);
@@ -16,6 +16,10 @@
 
 #include "enclave_t.h"
 
+#include "abac.h"
+#include "audit.h"
+#include "chaincode.h"
+#include "errors.h"
 #include "logging.h"
 #include "shim.h"
 
@@ -25,16 +29,125 @@
 #include "parson.h"
 
 #include "sgx_thread.h"
+#include "sgx_trts.h"
 
 static context_t context;
+// pseudonyms of the clients of pseudonymous requests by ctx
+static std::map<void*, std::string> nyms;
+// verified creators of requests and invocations denied access to state by ctx
+static std::map<void*, creator_t> creators;
+static std::set<void*> violations;
+// state accesses and bytes of state of invocations by ctx, see limits.h
+typedef struct
+{
+    uint32_t accesses;
+    uint64_t bytes;
+} state_usage_t;
+static std::map<void*, state_usage_t> usages;
 static sgx_thread_mutex_t global_mutex = SGX_THREAD_MUTEX_INITIALIZER;
 
 extern sgx_ec256_public_t tlcc_pk;
 extern sgx_cmac_128bit_key_t session_key;
 extern sgx_aes_gcm_128bit_key_t state_encryption_key;
 
-void get_state(const char* key, uint8_t* val, uint32_t max_val_len, uint32_t* val_len, void* ctx)
+// labels of the keys derived from the state master secret (state_encryption_key), which replicas
+// share via the key handover; derived on use, so the keys follow the master secret when a replica
+// imports it
+static const char* STATE_ENCRYPTION_LABEL = "FPC state encryption";
+static const char* STATE_NONCE_LABEL = "FPC state nonce";
+static const char* KEY_OBFUSCATION_LABEL = "FPC state key obfuscation";
+
+// derives a key from the state master secret as hmac(master secret, label)
+static void derive_state_key(const char* label, uint8_t* key, uint32_t key_len)
+{
+    uint8_t mac[SGX_SHA256_HASH_SIZE];
+    sgx_hmac_sha256_msg((const unsigned char*)label, strlen(label),
+        (const unsigned char*)&state_encryption_key, sizeof(state_encryption_key), mac,
+        sizeof(mac));
+    memcpy(key, mac, key_len);
+}
+
+// decrypts a state value; values written before state encryption keys were derived are encrypted
+// with the master secret itself
+static int decrypt_state_value(
+    std::string& cipher, uint8_t* plain, uint32_t plain_len, const uint8_t* aad, uint32_t aad_len)
+{
+    sgx_aes_gcm_128bit_key_t key;
+    derive_state_key(STATE_ENCRYPTION_LABEL, (uint8_t*)&key, sizeof(key));
+    int ret = decrypt_state(
+        &key, (uint8_t*)cipher.c_str(), cipher.size(), plain, plain_len, aad, aad_len);
+    if (ret != SGX_SUCCESS) {
+        ret = decrypt_state(&state_encryption_key, (uint8_t*)cipher.c_str(), cipher.size(), plain,
+            plain_len, aad, aad_len);
+    }
+    return ret;
+}
+
+// checks the access policies of the chaincode for key; a denied access fails the invocation
+static bool key_allowed(const std::string& key, void* ctx, bool record_violation = true)
+{
+    const access_control* acl = get_access_control();
+    if (acl == NULL) {
+        return true;
+    }
+
+    creator_t creator;
+    bool has_creator = get_creator(ctx, creator) == 0;
+    if (acl->allows_key(key, has_creator ? &creator : NULL)) {
+        return true;
+    }
+
+    if (record_violation) {
+        LOG_ERROR("Shim: Access to %s denied", key.c_str());
+        record_error(ctx, FPC_ERROR_ACCESS_DENIED, "Access to %s denied", key.c_str());
+        sgx_thread_mutex_lock(&global_mutex);
+        violations.insert(ctx);
+        sgx_thread_mutex_unlock(&global_mutex);
+    }
+    return false;
+}
+
+static const request_limits default_limits = {DEFAULT_MAX_ARGS_SIZE, DEFAULT_MAX_QUERY_RESULTS,
+    DEFAULT_MAX_STATE_ACCESSES, DEFAULT_MAX_STATE_BYTES};
+
+const request_limits& get_limits()
+{
+    const request_limits* limits = get_request_limits();
+    return limits != NULL ? *limits : default_limits;
+}
+
+// charges state accesses and bytes of state to the invocation of ctx; an invocation over its limits
+// fails
+static bool charge_state_usage(void* ctx, uint32_t accesses, uint64_t bytes)
 {
+    const request_limits& limits = get_limits();
+    sgx_thread_mutex_lock(&global_mutex);
+    state_usage_t& usage = usages[ctx];
+    usage.accesses += accesses;
+    usage.bytes += bytes;
+    bool within =
+        usage.accesses <= limits.max_state_accesses && usage.bytes <= limits.max_state_bytes;
+    if (!within) {
+        violations.insert(ctx);
+    }
+    sgx_thread_mutex_unlock(&global_mutex);
+
+    if (!within) {
+        LOG_ERROR("Shim: Invocation exceeds its state access limits");
+        record_error(ctx, FPC_ERROR_LIMIT_EXCEEDED, "Invocation exceeds its state access limits");
+    }
+    return within;
+}
+
+// bind_key binds the encrypted value to its key using the key as AAD
+static void get_state_internal(
+    const char* key, uint8_t* val, uint32_t max_val_len, uint32_t* val_len, void* ctx, bool bind_key)
+{
+    if (!charge_state_usage(ctx, 1, 0)) {
+        *val_len = 0;
+        return;
+    }
+
     // read state
     read_set_t* read_set = get_read_set(&context, ctx);
     read_set->insert(std::string(key));
@@ -56,20 +169,34 @@ void get_state(const char* key, uint8_t* val, uint32_t max_val_len, uint32_t* va
     if (*val_len == 0) {
         return;
     }
+    if (!charge_state_usage(ctx, 0, *val_len)) {
+        memset(val, 0, *val_len);
+        *val_len = 0;
+        return;
+    }
 
     // base64 decode
     std::string cipher = base64_decode((const char*)val);
 
     // decrypt
     uint32_t plain_len = cipher.size() - SGX_AESGCM_IV_SIZE - SGX_AESGCM_MAC_SIZE;
-    uint8_t plain[plain_len];
-    int ret = decrypt_state(
-        &state_encryption_key, (uint8_t*)cipher.c_str(), cipher.size(), plain, plain_len);
+    std::vector<uint8_t> plain(plain_len);
+    const uint8_t* aad = bind_key ? (const uint8_t*)key : NULL;
+    uint32_t aad_len = bind_key ? strlen(key) : 0;
+    int ret = decrypt_state_value(cipher, plain.data(), plain_len, aad, aad_len);
     if (ret != SGX_SUCCESS) {
         LOG_ERROR("Enclave: Error decrypting state: %d", ret);
+        if (bind_key) {
+            // value does not belong to key; do not hand it out
+            memset(val, 0, *val_len);
+            *val_len = 0;
+            return;
+        }
+    } else {
+        audit_decryption(ctx, key);
     }
 
-    memcpy(val, plain, plain_len);
+    memcpy(val, plain.data(), plain_len);
     if (*val_len - plain_len > 0) {
         // just fill val with zeros
         memset(val + plain_len, 0, *val_len - plain_len);
@@ -77,28 +204,92 @@ void get_state(const char* key, uint8_t* val, uint32_t max_val_len, uint32_t* va
     *val_len = plain_len;
 }
 
-void put_state(const char* key, uint8_t* val, uint32_t val_len, void* ctx)
+void get_state(const char* key, uint8_t* val, uint32_t max_val_len, uint32_t* val_len, void* ctx)
+{
+    *val_len = 0;
+    if (key_allowed(key, ctx)) {
+        get_state_internal(key, val, max_val_len, val_len, ctx, false);
+    }
+}
+
+void get_bound_state(
+    const char* key, uint8_t* val, uint32_t max_val_len, uint32_t* val_len, void* ctx)
+{
+    *val_len = 0;
+    if (key_allowed(key, ctx)) {
+        get_state_internal(key, val, max_val_len, val_len, ctx, true);
+    }
+}
+
+static void put_state_internal(
+    const char* key, uint8_t* val, uint32_t val_len, void* ctx, bool bind_key)
 {
-    // encrypt
+    if (!charge_state_usage(ctx, 1, val_len)) {
+        return;
+    }
+
+    sgx_aes_gcm_128bit_key_t encryption_key;
+    derive_state_key(STATE_ENCRYPTION_LABEL, (uint8_t*)&encryption_key, sizeof(encryption_key));
+
     uint32_t cipher_len = val_len + SGX_AESGCM_IV_SIZE + SGX_AESGCM_MAC_SIZE;
-    uint8_t cipher[cipher_len];
-    int ret = encrypt_state(&state_encryption_key, val, val_len, cipher, cipher_len);
+    std::vector<uint8_t> cipher(cipher_len);
+    const uint8_t* aad = bind_key ? (const uint8_t*)key : NULL;
+    uint32_t aad_len = bind_key ? strlen(key) : 0;
+#ifdef FPC_FIPS
+    // SP 800-38D does not approve ivs derived from the plaintext, so FIPS builds use random ivs and
+    // replicas endorsing the same transaction write different ciphertexts
+    int ret = encrypt_state(&encryption_key, val, val_len, cipher.data(), cipher_len, aad, aad_len);
+#else
+    // encrypt deterministically, so replicas endorsing the same transaction produce the same
+    // write set
+    uint8_t nonce_key[SGX_SHA256_HASH_SIZE];
+    derive_state_key(STATE_NONCE_LABEL, nonce_key, sizeof(nonce_key));
+    int ret = encrypt_state_deterministic(&encryption_key, nonce_key, sizeof(nonce_key), key, val,
+        val_len, cipher.data(), cipher_len, aad, aad_len);
+#endif
     if (ret != SGX_SUCCESS) {
         LOG_ERROR("Enclave: Error encrypting state");
     }
 
     // base64 encode
-    std::string base64 = base64_encode((unsigned char*)cipher, cipher_len);
+    std::string base64 = base64_encode(cipher.data(), cipher_len);
 
     // write state
     write_set_t* write_set = get_write_set(&context, ctx);
-    write_set->insert({key, base64});
+    // the last write to a key wins, as on the ledger
+    (*write_set)[key] = base64;
     ocall_put_state(key, (uint8_t*)base64.c_str(), base64.size(), ctx);
 }
 
-void get_state_by_partial_composite_key(
-    const char* comp_key, std::map<std::string, std::string>& values, void* ctx)
+void put_public_state(const char* key, const uint8_t* val, uint32_t val_len, void* ctx)
+{
+    std::string value((const char*)val, val_len);
+    write_set_t* write_set = get_write_set(&context, ctx);
+    (*write_set)[key] = value;
+    ocall_put_state(key, (uint8_t*)value.c_str(), value.size(), ctx);
+}
+
+void put_state(const char* key, uint8_t* val, uint32_t val_len, void* ctx)
+{
+    if (key_allowed(key, ctx)) {
+        put_state_internal(key, val, val_len, ctx, false);
+    }
+}
+
+void put_bound_state(const char* key, uint8_t* val, uint32_t val_len, void* ctx)
 {
+    if (key_allowed(key, ctx)) {
+        put_state_internal(key, val, val_len, ctx, true);
+    }
+}
+
+// restricted keys the creator may not access are dropped before decryption if check_access is set
+static void get_state_by_partial_composite_key_internal(const char* comp_key,
+    std::map<std::string, std::string>& values, void* ctx, bool bind_key, bool check_access)
+{
+    if (!charge_state_usage(ctx, 1, 0)) {
+        return;
+    }
     read_set_t* read_set = get_read_set(&context, ctx);
 
     uint8_t json[262144];  // 128k needed for 1000 bids
@@ -109,33 +300,59 @@ void get_state_by_partial_composite_key(
         comp_key, json, sizeof(json), &len, (sgx_cmac_128bit_tag_t*)cmac, ctx);
 
     unmarshal_values(values, (const char*)json, len);
+    // the result must be within the limits as a whole, a truncated result would be wrong
+    if (values.size() > get_limits().max_query_results || !charge_state_usage(ctx, 0, len)) {
+        LOG_ERROR("Shim: Query %s exceeds the limits of the invocation", comp_key);
+        record_error(ctx, FPC_ERROR_LIMIT_EXCEEDED, "Query %s exceeds the limits of the invocation",
+            comp_key);
+        sgx_thread_mutex_lock(&global_mutex);
+        violations.insert(ctx);
+        sgx_thread_mutex_unlock(&global_mutex);
+        values.clear();
+        return;
+    }
 
     // create state hash
     sgx_sha256_hash_t state_hash = {0};
     sgx_sha_state_handle_t sha_handle;
     sgx_sha256_init(&sha_handle);
 
-    for (auto& u : values) {
+    for (auto it = values.begin(); it != values.end();) {
+        auto& u = *it;
         read_set->insert(u.first);
 
         // but also compute hash
         sgx_sha256_update((const uint8_t*)u.first.c_str(), u.first.size(), sha_handle);
         sgx_sha256_update((const uint8_t*)u.second.c_str(), u.second.size(), sha_handle);
 
+        if (check_access && !key_allowed(u.first, ctx, false)) {
+            it = values.erase(it);
+            continue;
+        }
+
         // base64 decode
         std::string cipher = base64_decode(u.second.c_str());
 
         // decrypt
         uint32_t plain_len = cipher.size() - SGX_AESGCM_IV_SIZE - SGX_AESGCM_MAC_SIZE;
-        uint8_t plain[plain_len];
-        int ret = decrypt_state(
-            &state_encryption_key, (uint8_t*)cipher.c_str(), cipher.size(), plain, plain_len);
+        std::vector<uint8_t> plain(plain_len);
+        const uint8_t* aad = bind_key ? (const uint8_t*)u.first.c_str() : NULL;
+        uint32_t aad_len = bind_key ? u.first.size() : 0;
+        int ret = decrypt_state_value(cipher, plain.data(), plain_len, aad, aad_len);
         if (ret != SGX_SUCCESS) {
             LOG_ERROR("Enclave: Error decrypting state: %d", ret);
+            if (bind_key) {
+                // value does not belong to key; do not hand it out
+                it = values.erase(it);
+                continue;
+            }
+        } else {
+            audit_decryption(ctx, u.first);
         }
 
-        std::string s((const char*)plain, plain_len);
+        std::string s((const char*)plain.data(), plain_len);
         u.second = s;
+        ++it;
     }
 
     sgx_sha256_get_hash(sha_handle, &state_hash);
@@ -148,6 +365,150 @@ void get_state_by_partial_composite_key(
     }
 }
 
+void get_state_by_partial_composite_key(
+    const char* comp_key, std::map<std::string, std::string>& values, void* ctx)
+{
+    get_state_by_partial_composite_key_internal(comp_key, values, ctx, false, true);
+}
+
+void get_bound_state_by_partial_composite_key(
+    const char* comp_key, std::map<std::string, std::string>& values, void* ctx)
+{
+    get_state_by_partial_composite_key_internal(comp_key, values, ctx, true, true);
+}
+
+// separator of composite keys, see SEP in utils
+static const char COMPOSITE_KEY_SEP = '.';
+
+static std::string obfuscate_key_component(const std::string& component)
+{
+    uint8_t obfuscation_key[SGX_SHA256_HASH_SIZE];
+    derive_state_key(KEY_OBFUSCATION_LABEL, obfuscation_key, sizeof(obfuscation_key));
+
+    uint8_t mac[SGX_SHA256_HASH_SIZE];
+    sgx_hmac_sha256_msg((const unsigned char*)component.data(), component.size(), obfuscation_key,
+        sizeof(obfuscation_key), mac, sizeof(mac));
+    return base64_encode(mac, sizeof(mac));
+}
+
+std::string obfuscate_key(const std::string& key)
+{
+    if (key.empty() || key[0] != COMPOSITE_KEY_SEP) {
+        return obfuscate_key_component(key);
+    }
+
+    // composite key ".type.attr1.attr2."
+    std::string obfuscated(1, COMPOSITE_KEY_SEP);
+    size_t start = 1;
+    while (start < key.size()) {
+        size_t end = key.find(COMPOSITE_KEY_SEP, start);
+        if (end == std::string::npos) {
+            end = key.size();
+        }
+        obfuscated += obfuscate_key_component(key.substr(start, end - start));
+        if (end < key.size()) {
+            obfuscated += COMPOSITE_KEY_SEP;
+        }
+        start = end + 1;
+    }
+    return obfuscated;
+}
+
+// obfuscated values are the key length (4 bytes), the key and the value
+static bool split_obfuscated_value(
+    const uint8_t* buf, uint32_t buf_len, std::string& key, const uint8_t** val, uint32_t* val_len)
+{
+    uint32_t key_len;
+    if (buf_len < sizeof(key_len)) {
+        return false;
+    }
+    memcpy(&key_len, buf, sizeof(key_len));
+    if (key_len > buf_len - sizeof(key_len)) {
+        return false;
+    }
+    key.assign((const char*)buf + sizeof(key_len), key_len);
+    *val = buf + sizeof(key_len) + key_len;
+    *val_len = buf_len - sizeof(key_len) - key_len;
+    return true;
+}
+
+void get_obfuscated_state(
+    const char* key, uint8_t* val, uint32_t max_val_len, uint32_t* val_len, void* ctx)
+{
+    *val_len = 0;
+    if (!key_allowed(key, ctx)) {
+        return;
+    }
+
+    std::string obfuscated = obfuscate_key(key);
+    std::vector<uint8_t> buf(max_val_len + sizeof(uint32_t) + strlen(key));
+    uint32_t buf_len = 0;
+    get_state_internal(obfuscated.c_str(), buf.data(), buf.size(), &buf_len, ctx, true);
+    if (buf_len == 0) {
+        return;
+    }
+
+    std::string stored_key;
+    const uint8_t* stored_val;
+    uint32_t stored_val_len;
+    if (!split_obfuscated_value(buf.data(), buf_len, stored_key, &stored_val, &stored_val_len) ||
+        stored_key != key) {
+        LOG_ERROR("Shim: Malformed obfuscated value of %s", obfuscated.c_str());
+        return;
+    }
+    if (stored_val_len > max_val_len) {
+        LOG_ERROR("Shim: Value of %s exceeds buffer", obfuscated.c_str());
+        return;
+    }
+    memcpy(val, stored_val, stored_val_len);
+    *val_len = stored_val_len;
+}
+
+void put_obfuscated_state(const char* key, uint8_t* val, uint32_t val_len, void* ctx)
+{
+    if (!key_allowed(key, ctx)) {
+        return;
+    }
+
+    uint32_t key_len = strlen(key);
+    std::vector<uint8_t> buf(sizeof(key_len) + key_len + val_len);
+    memcpy(buf.data(), &key_len, sizeof(key_len));
+    memcpy(buf.data() + sizeof(key_len), key, key_len);
+    memcpy(buf.data() + sizeof(key_len) + key_len, val, val_len);
+    put_state_internal(obfuscate_key(key).c_str(), buf.data(), buf.size(), ctx, true);
+}
+
+void get_obfuscated_state_by_partial_composite_key(
+    const char* comp_key, std::map<std::string, std::string>& values, void* ctx)
+{
+    // the peer only sees obfuscated keys, thus, restricted keys are dropped once their value reveals
+    // them; queries within a restricted prefix are denied up front
+    if (!key_allowed(comp_key, ctx)) {
+        return;
+    }
+
+    std::map<std::string, std::string> obfuscated_values;
+    get_state_by_partial_composite_key_internal(
+        obfuscate_key(comp_key).c_str(), obfuscated_values, ctx, true, false);
+
+    for (auto& u : obfuscated_values) {
+        std::string key;
+        const uint8_t* val;
+        uint32_t val_len;
+        // the value must be stored under the obfuscation of the key it carries
+        if (!split_obfuscated_value((const uint8_t*)u.second.data(), u.second.size(), key, &val,
+                &val_len) ||
+            obfuscate_key(key) != u.first) {
+            LOG_ERROR("Shim: Malformed obfuscated value of %s", u.first.c_str());
+            continue;
+        }
+        if (!key_allowed(key, ctx, false)) {
+            continue;
+        }
+        values[key] = std::string((const char*)val, val_len);
+    }
+}
+
 void register_rwset(void* ctx, read_set_t* readset, write_set_t* writeset)
 {
     sgx_thread_mutex_lock(&global_mutex);
@@ -162,6 +523,148 @@ void free_rwset(void* ctx)
     sgx_thread_mutex_unlock(&global_mutex);
 }
 
+void register_nym(void* ctx, const std::string& nym)
+{
+    sgx_thread_mutex_lock(&global_mutex);
+    nyms[ctx] = nym;
+    sgx_thread_mutex_unlock(&global_mutex);
+}
+
+void free_nym(void* ctx)
+{
+    sgx_thread_mutex_lock(&global_mutex);
+    nyms.erase(ctx);
+    sgx_thread_mutex_unlock(&global_mutex);
+}
+
+int get_creator_nym(void* ctx, std::string& nym)
+{
+    sgx_thread_mutex_lock(&global_mutex);
+    auto search = nyms.find(ctx);
+    int ret = -1;
+    if (search != nyms.end()) {
+        nym = search->second;
+        ret = 0;
+    }
+    sgx_thread_mutex_unlock(&global_mutex);
+    return ret;
+}
+
+void register_creator(void* ctx, const creator_t& creator)
+{
+    sgx_thread_mutex_lock(&global_mutex);
+    creators[ctx] = creator;
+    sgx_thread_mutex_unlock(&global_mutex);
+}
+
+void free_creator(void* ctx)
+{
+    sgx_thread_mutex_lock(&global_mutex);
+    creators.erase(ctx);
+    violations.erase(ctx);
+    usages.erase(ctx);
+    sgx_thread_mutex_unlock(&global_mutex);
+}
+
+int get_creator(void* ctx, creator_t& creator)
+{
+    sgx_thread_mutex_lock(&global_mutex);
+    auto search = creators.find(ctx);
+    int ret = -1;
+    if (search != creators.end()) {
+        creator = search->second;
+        ret = 0;
+    }
+    sgx_thread_mutex_unlock(&global_mutex);
+    return ret;
+}
+
+int get_creator_id(void* ctx, std::string& id)
+{
+    creator_t creator;
+    if (get_creator(ctx, creator) != 0) {
+        return -1;
+    }
+    id = creator.id;
+    return 0;
+}
+
+int get_creator_msp_id(void* ctx, std::string& msp_id)
+{
+    creator_t creator;
+    if (get_creator(ctx, creator) != 0) {
+        return -1;
+    }
+    msp_id = creator.msp_id;
+    return 0;
+}
+
+int get_creator_attr(void* ctx, const std::string& name, std::string& value)
+{
+    creator_t creator;
+    if (get_creator(ctx, creator) != 0) {
+        return -1;
+    }
+    auto search = creator.attrs.find(name);
+    if (search == creator.attrs.end()) {
+        return -1;
+    }
+    value = search->second;
+    return 0;
+}
+
+bool access_violated(void* ctx)
+{
+    sgx_thread_mutex_lock(&global_mutex);
+    bool violated = violations.count(ctx) > 0;
+    sgx_thread_mutex_unlock(&global_mutex);
+    return violated;
+}
+
+// see ecall_get_ledger_time of tlcc
+#define LEDGER_TIME_CMAC_KEY "\xff" "time"
+
+// latest ledger time this enclave has seen, so that time never goes backwards
+static int64_t last_ledger_time = 0;
+static uint64_t last_ledger_height = 0;
+
+int get_ledger_time(void* ctx, int64_t* time, uint64_t* height)
+{
+    uint8_t nonce[32];
+    sgx_read_rand(nonce, sizeof(nonce));
+
+    int64_t _time = 0;
+    uint64_t _height = 0;
+    sgx_cmac_128bit_tag_t cmac = {0};
+    ocall_get_ledger_time(nonce, &_height, &_time, &cmac, ctx);
+
+    // hash( nonce || height || time )
+    sgx_sha256_hash_t time_hash = {0};
+    sgx_sha_state_handle_t sha_handle;
+    sgx_sha256_init(&sha_handle);
+    sgx_sha256_update(nonce, sizeof(nonce), sha_handle);
+    sgx_sha256_update((const uint8_t*)&_height, sizeof(_height), sha_handle);
+    sgx_sha256_update((const uint8_t*)&_time, sizeof(_time), sha_handle);
+    sgx_sha256_get_hash(sha_handle, &time_hash);
+    sgx_sha256_close(sha_handle);
+    if (check_cmac(LEDGER_TIME_CMAC_KEY, NULL, &time_hash, &session_key, &cmac) != 0) {
+        LOG_ERROR("Shim: Ledger time is not from tlcc");
+        return -1;
+    }
+
+    sgx_thread_mutex_lock(&global_mutex);
+    if (_height > last_ledger_height) {
+        last_ledger_height = _height;
+    }
+    if (_time > last_ledger_time) {
+        last_ledger_time = _time;
+    }
+    *time = last_ledger_time;
+    *height = last_ledger_height;
+    sgx_thread_mutex_unlock(&global_mutex);
+    return 0;
+}
+
 read_set_t* get_read_set(context_t* context, void* ctx)
 {
     sgx_thread_mutex_lock(&global_mutex);
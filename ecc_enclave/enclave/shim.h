@@ -33,10 +33,91 @@ void get_state_by_partial_composite_key(
     const char* comp_key, std::map<std::string, std::string>& values,
     void* ctx);
 
+// like above but the encrypted values are bound to their keys (AAD), i.e., the
+// peer cannot swap values between keys; values that fail to decrypt are not
+// returned. Use typed_state.h rather than these directly.
+void get_bound_state(const char* key, uint8_t* val, uint32_t max_val_len,
+                     uint32_t* val_len, void* ctx);
+void put_bound_state(const char* key, uint8_t* val, uint32_t val_len,
+                     void* ctx);
+void get_bound_state_by_partial_composite_key(
+    const char* comp_key, std::map<std::string, std::string>& values,
+    void* ctx);
+
+// like the bound variants but under obfuscated keys: the ledger key is the HMAC of the key under
+// a key derived from the state encryption key, so the peer learns neither the keys nor the
+// business identifiers in them. Each component of a composite key is obfuscated separately, so
+// partial composite key queries still work, and the values carry their key, so queries return the
+// original keys. Use typed_state.h rather than these directly.
+void get_obfuscated_state(const char* key, uint8_t* val, uint32_t max_val_len,
+                          uint32_t* val_len, void* ctx);
+void put_obfuscated_state(const char* key, uint8_t* val, uint32_t val_len,
+                          void* ctx);
+void get_obfuscated_state_by_partial_composite_key(
+    const char* comp_key, std::map<std::string, std::string>& values,
+    void* ctx);
+// obfuscate_key returns the ledger key of key as used by the obfuscated variants
+std::string obfuscate_key(const std::string& key);
+
+// writes val as is, i.e., in plain, for records the enclave publishes on the ledger, e.g., audit
+// records (see audit.h)
+void put_public_state(const char* key, const uint8_t* val, uint32_t val_len,
+                      void* ctx);
+
 int unmarshal_args(std::vector<std::string>& argss, const char* json_string);
 int unmarshal_values(std::map<std::string, std::string>& values,
                      const char* json_bytes, uint32_t json_len);
 
+// secrets provisioned via ercc; returns 0 if secret exists
+int get_secret(const char* name, std::string& secret);
+
+// trusted time for expiry and freshness checks: the ledger time in seconds since the epoch and the
+// block height as of the latest block validated by tlcc, where the ledger time is the median of the
+// transaction timestamps of a block. The enclave asks tlcc with a fresh nonce, so the peer can not
+// replay an older time, and the time never goes backwards; it lags behind the wall clock by the
+// time it takes to order and validate blocks. Returns 0 on success.
+int get_ledger_time(void* ctx, int64_t* time, uint64_t* height);
+
+// pseudonym of the client of a pseudonymous request, i.e., the base64 encoded
+// SHA-256 hash of its pseudonym key (see client.Pseudonym); returns 0 if the
+// request is pseudonymous. The pseudonym is stable per client and scope, so
+// chaincodes can authorize requests by it without learning who the client is.
+// The response to a pseudonymous request is encrypted to the pseudonym key.
+int get_creator_nym(void* ctx, std::string& nym);
+void register_nym(void* ctx, const std::string& nym);
+void free_nym(void* ctx);
+
+// creator of a request the client signed with the key of its enrollment certificate (see
+// client.Identity). The enclave verifies the signature and validates the certificate chain against
+// the CA certificates of the MSP as of the latest config block validated by tlcc, so chaincodes can
+// trust who is calling; the certificate attributes are what the access policies of the chaincode
+// are evaluated over (see abac.h).
+typedef struct
+{
+    std::string msp_id;
+    // x509::<subject>::<issuer> with distinguished names in RFC 2253 format
+    std::string id;
+    // PEM encoded certificate
+    std::string cert;
+    // organizational units of the certificate subject
+    std::vector<std::string> ous;
+    // attributes issued by the fabric CA
+    std::map<std::string, std::string> attrs;
+} creator_t;
+
+// returns 0 if the request carries a verified creator
+int get_creator(void* ctx, creator_t& creator);
+// shorthands for fields of the creator; return 0 if the request carries a verified creator (and
+// it has the attribute)
+int get_creator_id(void* ctx, std::string& id);
+int get_creator_msp_id(void* ctx, std::string& msp_id);
+int get_creator_attr(void* ctx, const std::string& name, std::string& value);
+void register_creator(void* ctx, const creator_t& creator);
+// also forgets access violations of ctx
+void free_creator(void* ctx);
+// returns true if the invocation of ctx was denied access to state, see abac.h
+bool access_violated(void* ctx);
+
 // read/writeset
 void register_rwset(void* ctx, read_set_t* readset, write_set_t* writeset);
 void free_rwset(void* ctx);
@@ -0,0 +1,207 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <map>
+#include <string>
+#include <vector>
+
+#include "logging.h"
+#include "parson.h"
+#include "shim.h"
+
+// Typed state access for chaincodes. The helpers serialize values with a codec and store them
+// encrypted and bound to their key (see put_bound_state), e.g.,
+//
+//   auction_t auction;
+//   if (!get_json(auction_name, auction, ctx)) { ... }
+//   put_json(auction_name, auction, ctx);
+//   put_json(auction_name, auction, ctx, OBFUSCATED_KEYS);  // hide the key from the peer
+//
+// A codec is a type providing
+//   static bool marshal(const T& value, std::string& bytes);
+//   static bool unmarshal(const std::string& bytes, T& value);
+
+// maximal size of a serialized value
+#define MAX_TYPED_VALUE_SIZE 65536
+
+// state_keys selects how keys appear on the ledger. With OBFUSCATED_KEYS, the helpers store values
+// under the HMAC of their key (see put_obfuscated_state), hiding keys and access patterns from the
+// peer; chaincodes keep using their logical keys. Values must be read with the mode they were
+// written with.
+enum state_keys
+{
+    PLAIN_KEYS,
+    OBFUSCATED_KEYS
+};
+
+// json_codec serializes values as JSON. The chaincode provides for its type
+//   JSON_Value* to_json(const T& value);
+//   bool from_json(const JSON_Value* json, T& value);
+template <typename T>
+struct json_codec
+{
+    static bool marshal(const T& value, std::string& bytes)
+    {
+        JSON_Value* json = to_json(value);
+        if (json == NULL) {
+            return false;
+        }
+        char* serialized = json_serialize_to_string(json);
+        json_value_free(json);
+        if (serialized == NULL) {
+            return false;
+        }
+        bytes = serialized;
+        json_free_serialized_string(serialized);
+        return true;
+    }
+
+    static bool unmarshal(const std::string& bytes, T& value)
+    {
+        JSON_Value* json = json_parse_string(bytes.c_str());
+        if (json == NULL) {
+            return false;
+        }
+        bool ok = from_json(json, value);
+        json_value_free(json);
+        return ok;
+    }
+};
+
+// proto_codec serializes protobuf messages, i.e., any T providing the protobuf(-lite) methods
+// SerializeToString and ParseFromString
+template <typename T>
+struct proto_codec
+{
+    static bool marshal(const T& value, std::string& bytes) { return value.SerializeToString(&bytes); }
+
+    static bool unmarshal(const std::string& bytes, T& value) { return value.ParseFromString(bytes); }
+};
+
+// get_typed_state reads the value of key; returns false if there is no (valid) value
+template <typename T, typename Codec>
+bool get_typed_state(const std::string& key, T& value, void* ctx, state_keys keys = PLAIN_KEYS)
+{
+    std::vector<uint8_t> bytes(MAX_TYPED_VALUE_SIZE);
+    uint32_t bytes_len = 0;
+    if (keys == OBFUSCATED_KEYS) {
+        get_obfuscated_state(key.c_str(), bytes.data(), bytes.size(), &bytes_len, ctx);
+    } else {
+        get_bound_state(key.c_str(), bytes.data(), bytes.size(), &bytes_len, ctx);
+    }
+    if (bytes_len == 0) {
+        return false;
+    }
+
+    if (!Codec::unmarshal(std::string((const char*)bytes.data(), bytes_len), value)) {
+        LOG_ERROR("Shim: Cannot unmarshal value of %s", key.c_str());
+        return false;
+    }
+    return true;
+}
+
+// put_typed_state writes the value of key; returns false if the value cannot be serialized
+template <typename T, typename Codec>
+bool put_typed_state(
+    const std::string& key, const T& value, void* ctx, state_keys keys = PLAIN_KEYS)
+{
+    std::string bytes;
+    if (!Codec::marshal(value, bytes) || bytes.size() > MAX_TYPED_VALUE_SIZE) {
+        LOG_ERROR("Shim: Cannot marshal value of %s", key.c_str());
+        return false;
+    }
+
+    if (keys == OBFUSCATED_KEYS) {
+        put_obfuscated_state(key.c_str(), (uint8_t*)bytes.data(), bytes.size(), ctx);
+    } else {
+        put_bound_state(key.c_str(), (uint8_t*)bytes.data(), bytes.size(), ctx);
+    }
+    return true;
+}
+
+// typed_state_iterator iterates over the values of all keys with the given composite key prefix
+//
+//   typed_state_iterator<bid_t, json_codec<bid_t>> it(bid_composite_key, ctx);
+//   std::string key;
+//   bid_t bid;
+//   while (it.next(key, bid)) { ... }
+//   if (!it.ok()) { ... }
+template <typename T, typename Codec>
+class typed_state_iterator
+{
+public:
+    typed_state_iterator(const std::string& comp_key, void* ctx, state_keys keys = PLAIN_KEYS)
+        : ok_(true)
+    {
+        if (keys == OBFUSCATED_KEYS) {
+            get_obfuscated_state_by_partial_composite_key(comp_key.c_str(), values_, ctx);
+        } else {
+            get_bound_state_by_partial_composite_key(comp_key.c_str(), values_, ctx);
+        }
+        it_ = values_.begin();
+    }
+
+    // next returns the next key and value; returns false at the end or if a value cannot be
+    // unmarshalled, see ok
+    bool next(std::string& key, T& value)
+    {
+        if (!ok_ || it_ == values_.end()) {
+            return false;
+        }
+        if (!Codec::unmarshal(it_->second, value)) {
+            LOG_ERROR("Shim: Cannot unmarshal value of %s", it_->first.c_str());
+            ok_ = false;
+            return false;
+        }
+        key = it_->first;
+        ++it_;
+        return true;
+    }
+
+    // ok returns false if the iteration stopped at a malformed value
+    bool ok() const { return ok_; }
+
+private:
+    std::map<std::string, std::string> values_;
+    std::map<std::string, std::string>::const_iterator it_;
+    bool ok_;
+};
+
+template <typename T>
+bool get_json(const std::string& key, T& value, void* ctx, state_keys keys = PLAIN_KEYS)
+{
+    return get_typed_state<T, json_codec<T>>(key, value, ctx, keys);
+}
+
+template <typename T>
+bool put_json(const std::string& key, const T& value, void* ctx, state_keys keys = PLAIN_KEYS)
+{
+    return put_typed_state<T, json_codec<T>>(key, value, ctx, keys);
+}
+
+template <typename T>
+bool get_proto(const std::string& key, T& value, void* ctx, state_keys keys = PLAIN_KEYS)
+{
+    return get_typed_state<T, proto_codec<T>>(key, value, ctx, keys);
+}
+
+template <typename T>
+bool put_proto(const std::string& key, const T& value, void* ctx, state_keys keys = PLAIN_KEYS)
+{
+    return put_typed_state<T, proto_codec<T>>(key, value, ctx, keys);
+}
@@ -0,0 +1,134 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#include "upload.h"
+#include "logging.h"
+#include "shim.h"
+
+#include "base64.h"
+
+#include "sgx_tcrypto.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+// prefix of the state keys of an upload: the number of chunks under <prefix><id> and the chunks
+// under <prefix><id>.<index>
+#define UPLOAD_KEY_PREFIX "fpc_upload."
+#define UPLOAD_ID_LEN (2 * SGX_SHA256_HASH_SIZE)
+
+static bool valid_upload_id(const std::string& id)
+{
+    if (id.size() != UPLOAD_ID_LEN) {
+        return false;
+    }
+    for (char c : id) {
+        if (!((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f'))) {
+            return false;
+        }
+    }
+    return true;
+}
+
+// parses a decimal number in [0, max); returns -1 otherwise
+static int parse_bounded(const std::string& s, int max)
+{
+    if (s.empty() || s.size() > 9 || s.find_first_not_of("0123456789") != std::string::npos) {
+        return -1;
+    }
+    int n = atoi(s.c_str());
+    return n < max ? n : -1;
+}
+
+int upload_chunk(const std::vector<std::string>& argss, uint8_t* response,
+    uint32_t max_response_len, uint32_t* actual_response_len, void* ctx)
+{
+    if (argss.size() != 5 || !valid_upload_id(argss[1])) {
+        LOG_ERROR("Upload: Malformed chunk");
+        return -1;
+    }
+    const std::string& id = argss[1];
+    int total = parse_bounded(argss[3], UPLOAD_MAX_CHUNKS + 1);
+    int index = total > 0 ? parse_bounded(argss[2], total) : -1;
+    std::string chunk = base64_decode(argss[4]);
+    if (index < 0 || chunk.empty() || chunk.size() > UPLOAD_CHUNK_SIZE) {
+        LOG_ERROR("Upload: Chunk out of bounds");
+        return -1;
+    }
+
+    std::string key = std::string(UPLOAD_KEY_PREFIX) + id;
+    put_obfuscated_state(key.c_str(), (uint8_t*)argss[3].c_str(), argss[3].size(), ctx);
+    key += "." + argss[2];
+    put_obfuscated_state(key.c_str(), (uint8_t*)chunk.c_str(), chunk.size(), ctx);
+
+    char out[32];
+    int len = snprintf(out, sizeof(out), "%d/%d", index, total);
+    if (len < 0 || (uint32_t)len > max_response_len) {
+        return -1;
+    }
+    memcpy(response, out, len);
+    *actual_response_len = len;
+    return 0;
+}
+
+int get_upload(const std::string& id, std::string& payload, void* ctx)
+{
+    if (!valid_upload_id(id)) {
+        LOG_ERROR("Upload: Invalid upload id");
+        return -1;
+    }
+
+    std::string key = std::string(UPLOAD_KEY_PREFIX) + id;
+    char total_value[16];
+    uint32_t total_len = 0;
+    get_obfuscated_state(key.c_str(), (uint8_t*)total_value, sizeof(total_value) - 1, &total_len, ctx);
+    total_value[total_len] = '\0';
+    int total = parse_bounded(total_value, UPLOAD_MAX_CHUNKS + 1);
+    if (total <= 0) {
+        LOG_ERROR("Upload: Unknown upload %s", id.c_str());
+        return -1;
+    }
+
+    // the state is read base64 encoded and encrypted, so the buffer must be larger than the chunk
+    std::vector<uint8_t> chunk(2 * UPLOAD_CHUNK_SIZE);
+    std::string _payload;
+    for (int i = 0; i < total; i++) {
+        char index[16];
+        snprintf(index, sizeof(index), "%d", i);
+        std::string chunk_key = key + "." + index;
+        uint32_t chunk_len = 0;
+        get_obfuscated_state(chunk_key.c_str(), chunk.data(), chunk.size(), &chunk_len, ctx);
+        if (chunk_len == 0) {
+            LOG_ERROR("Upload: Chunk %d of %s missing", i, id.c_str());
+            return -1;
+        }
+        _payload.append((const char*)chunk.data(), chunk_len);
+    }
+
+    sgx_sha256_hash_t hash;
+    sgx_sha256_msg((const uint8_t*)_payload.c_str(), _payload.size(), &hash);
+    char hex[UPLOAD_ID_LEN + 1];
+    for (int i = 0; i < SGX_SHA256_HASH_SIZE; i++) {
+        snprintf(hex + 2 * i, 3, "%02x", hash[i]);
+    }
+    if (id != hex) {
+        LOG_ERROR("Upload: Payload does not match %s", id.c_str());
+        return -1;
+    }
+    payload.swap(_payload);
+    return 0;
+}
@@ -0,0 +1,53 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+#pragma once
+
+#include <cstdint>
+#include <string>
+#include <vector>
+
+// Uploads of payloads too large for a single transaction, e.g., confidential documents. The client
+// splits the payload into chunks and submits each in its own encrypted transaction (see
+// client.SecureContract.Upload):
+//
+//   [UPLOAD_CHUNK_FUNCTION, <upload id>, <index>, <number of chunks>, base64(chunk)]
+//
+// where the upload id is the hex encoded SHA-256 hash of the payload. The enclave stores each chunk
+// under an obfuscated key, so the ledger reveals neither the chunks nor the upload id, and the
+// chaincode later reassembles the payload, e.g., when a function is invoked with the upload id:
+//
+//   std::string document;
+//   if (get_upload(upload_id, document, ctx) != 0) { ... }
+//
+// get_upload checks that all chunks are there and that they hash to the upload id, so a payload
+// is either complete and as uploaded or not returned at all. Uploads of the same payload share
+// their chunks; chunks stay on the ledger.
+
+#define UPLOAD_CHUNK_FUNCTION "__upload_chunk"
+// bytes per chunk at most
+#define UPLOAD_CHUNK_SIZE (32 * 1024)
+// chunks per upload at most, i.e., uploads take up to 1MB
+#define UPLOAD_MAX_CHUNKS 32
+
+// stores the chunk of an invocation of UPLOAD_CHUNK_FUNCTION and responds with
+// "<index>/<number of chunks>"; returns 0 on success
+int upload_chunk(const std::vector<std::string>& argss, uint8_t* response,
+    uint32_t max_response_len, uint32_t* actual_response_len, void* ctx);
+
+// reassembles the payload of the upload with the given id; returns 0 if all chunks are present
+// and the payload hashes to the id
+int get_upload(const std::string& id, std::string& payload, void* ctx);
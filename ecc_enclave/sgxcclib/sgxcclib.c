@@ -17,7 +17,9 @@
 #include "sgxcclib.h"
 #include "enclave_u.h"
 
+#include <pthread.h>
 #include <string.h>
+#include <time.h>
 #include <unistd.h>
 
 // for RA:
@@ -43,6 +45,66 @@ extern void get_state_by_partial_composite_key(const char *comp_key, uint8_t *va
 extern void get_state(const char *key, uint8_t *val, uint32_t max_val_len, uint32_t *val_len,
     cmac_t *cmac, void *ctx);
 extern void put_state(const char *key, uint8_t *val, uint32_t val_len, void *ctx);
+// for validating client certificates
+extern void get_msp_roots(const char *msp_id, uint8_t *roots, uint32_t max_roots_len,
+    uint32_t *roots_len, cmac_t *cmac, void *ctx);
+// for trusted time
+extern void get_ledger_time(
+    uint8_t *nonce, uint64_t *height, int64_t *time, cmac_t *cmac, void *ctx);
+
+// the latest ecall that failed in itself, e.g., because the enclave aborted, as opposed to errors
+// the enclave returns; only the ecall name and the sgx status are kept, nothing of the enclave
+static const char *crash_ecall = NULL;
+static int crash_status = 0;
+static int64_t crash_time = 0;
+static uint32_t crashes = 0;
+static pthread_mutex_t crash_mutex = PTHREAD_MUTEX_INITIALIZER;
+
+static const char *crash_class(int status)
+{
+    switch (status) {
+        case SGX_ERROR_ENCLAVE_CRASHED:
+            return "crashed";
+        case SGX_ERROR_ENCLAVE_LOST:
+            return "lost";
+        case SGX_ERROR_OUT_OF_MEMORY:
+        case SGX_ERROR_OUT_OF_EPC:
+            return "out of memory";
+        case SGX_ERROR_STACK_OVERRUN:
+            return "stack overrun";
+        case SGX_ERROR_OUT_OF_TCS:
+            return "out of tcs";
+        case SGX_ERROR_INVALID_ENCLAVE_ID:
+        case SGX_ERROR_INVALID_ENCLAVE:
+            return "invalid enclave";
+        default:
+            return "sgx error";
+    }
+}
+
+static void record_crash(const char *ecall, int status)
+{
+    pthread_mutex_lock(&crash_mutex);
+    crash_ecall = ecall;
+    crash_status = status;
+    crash_time = (int64_t)time(NULL);
+    crashes++;
+    pthread_mutex_unlock(&crash_mutex);
+    LOG_ERROR("Lib: Enclave failure in %s: %s (%#x)", ecall, crash_class(status), status);
+}
+
+uint32_t sgxcc_get_crash_record(
+    const char **ecall, const char **error_class, int *status, int64_t *crash_time_out)
+{
+    pthread_mutex_lock(&crash_mutex);
+    uint32_t count = crashes;
+    *ecall = crash_ecall;
+    *error_class = crash_ecall != NULL ? crash_class(crash_status) : NULL;
+    *status = crash_status;
+    *crash_time_out = crash_time;
+    pthread_mutex_unlock(&crash_mutex);
+    return count;
+}
 
 int sgxcc_create_enclave(sgx_enclave_id_t *eid, const char *enclave_file)
 {
@@ -63,6 +125,9 @@ int sgxcc_create_enclave(sgx_enclave_id_t *eid, const char *enclave_file)
     int enclave_ret = -1;
     ret = ecall_init(*eid, &enclave_ret);
     if (ret != SGX_SUCCESS || enclave_ret != SGX_SUCCESS) {
+        if (ret != SGX_SUCCESS) {
+            record_crash("ecall_init", ret);
+        }
         LOG_ERROR("Lib: Unable to initialize enclave. reason: %d", ret);
         return ret;
     }
@@ -92,6 +157,7 @@ int sgxcc_get_target_info(enclave_id_t eid, target_info_t *target_info)
     int enclave_ret;
     int ret = ecall_get_target_info(eid, &enclave_ret, (sgx_target_info_t *)target_info);
     if (ret != SGX_SUCCESS) {
+        record_crash("ecall_get_target_info", ret);
         LOG_ERROR("Lib: ERROR - ecall_get_target_info: %d", ret);
     }
     return ret;
@@ -101,9 +167,10 @@ int sgxcc_get_local_attestation_report(
     enclave_id_t eid, target_info_t *target_info, report_t *report, ec256_public_t *pubkey)
 {
     int enclave_ret;
-    int ret = ecall_create_report(eid, &enclave_ret, (sgx_target_info_t *)target_info,
+    int ret = ecall_create_report(eid, &enclave_ret, (sgx_target_info_t *)target_info, NULL,
         (sgx_report_t *)report, (uint8_t *)pubkey);
     if (ret != SGX_SUCCESS) {
+        record_crash("ecall_create_report", ret);
         LOG_ERROR("Lib: ERROR - ecall_create_report: %d", ret);
         return ret;
     }
@@ -115,13 +182,14 @@ int sgxcc_bind(enclave_id_t eid, report_t *report, ec256_public_t *pubkey)
     int enclave_ret;
     int ret = ecall_bind_tlcc(eid, &enclave_ret, (sgx_report_t *)report, (uint8_t *)pubkey);
     if (ret != SGX_SUCCESS) {
+        record_crash("ecall_bind_tlcc", ret);
         LOG_ERROR("Lib: ERROR - ecall_bind_tlcc: %d", ret);
     }
     return enclave_ret;
 }
 
-int sgxcc_get_remote_attestation_report(
-    enclave_id_t eid, quote_t *quote, uint32_t quote_size, ec256_public_t *pubkey, spid_t *spid)
+int sgxcc_get_remote_attestation_report(enclave_id_t eid, quote_t *quote, uint32_t quote_size,
+    ec256_public_t *pubkey, spid_t *spid, const uint8_t *binding)
 {
     sgx_target_info_t qe_target_info = {0};
     sgx_epid_group_id_t gid = {0};
@@ -135,8 +203,10 @@ int sgxcc_get_remote_attestation_report(
 
     // get report from enclave
     int enclave_ret = -1;
-    ret = ecall_create_report(eid, &enclave_ret, &qe_target_info, &report, (uint8_t *)pubkey);
+    ret = ecall_create_report(
+        eid, &enclave_ret, &qe_target_info, binding, &report, (uint8_t *)pubkey);
     if (ret != SGX_SUCCESS) {
+        record_crash("ecall_create_report", ret);
         return ret;
     }
 
@@ -167,6 +237,7 @@ int sgxcc_get_pk(enclave_id_t eid, ec256_public_t *pubkey)
     int enclave_ret;
     int ret = ecall_get_pk(eid, &enclave_ret, (uint8_t *)pubkey);
     if (ret != SGX_SUCCESS) {
+        record_crash("ecall_get_pk", ret);
         LOG_ERROR("Lib: ERROR - ecall_get_pk: %d", ret);
         return ret;
     }
@@ -185,6 +256,7 @@ int sgxcc_invoke(enclave_id_t eid, const char *args, const char *pk, uint8_t *re
         (sgx_ec256_signature_t *)signature,           // signature
         ctx);                                         // context for callback
     if (ret != SGX_SUCCESS) {
+        record_crash("ecall_invoke", ret);
         LOG_ERROR("Lib: ERROR - invoke: %d", ret);
         return ret;
     }
@@ -192,6 +264,190 @@ int sgxcc_invoke(enclave_id_t eid, const char *args, const char *pk, uint8_t *re
     return enclave_ret;
 }
 
+int sgxcc_provision_secret(enclave_id_t eid, const char *name, ec256_public_t *ephemeral_pk,
+    uint8_t *cipher, uint32_t cipher_len, const char *approval, void *ctx)
+{
+    int enclave_ret;
+    int ret = ecall_provision_secret(
+        eid, &enclave_ret, name, (uint8_t *)ephemeral_pk, cipher, cipher_len, approval, ctx);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_provision_secret", ret);
+        LOG_ERROR("Lib: ERROR - ecall_provision_secret: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_create_reencryption_key(enclave_id_t eid, const char *chaincode_id,
+    const char *delegatee_report, const char *approvals, ec256_public_t *delegation_pk, uint8_t *rk,
+    void *ctx)
+{
+    int enclave_ret;
+    int ret = ecall_create_reencryption_key(eid, &enclave_ret, chaincode_id, delegatee_report,
+        approvals, (uint8_t *)delegation_pk, rk, ctx);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_create_reencryption_key", ret);
+        LOG_ERROR("Lib: ERROR - ecall_create_reencryption_key: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_provision_delegated_secret(enclave_id_t eid, const char *name,
+    ec256_public_t *delegation_pk, ec256_public_t *reencrypted_pk, uint8_t *cipher,
+    uint32_t cipher_len, const char *approval, void *ctx)
+{
+    int enclave_ret;
+    int ret = ecall_provision_delegated_secret(eid, &enclave_ret, name, (uint8_t *)delegation_pk,
+        (uint8_t *)reencrypted_pk, cipher, cipher_len, approval, ctx);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_provision_delegated_secret", ret);
+        LOG_ERROR("Lib: ERROR - ecall_provision_delegated_secret: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_export_state_key(enclave_id_t eid, const char *chaincode_id,
+    const char *successor_report, const char *approvals, ec256_public_t *ephemeral_pk,
+    uint8_t *cipher, uint32_t cipher_len, uint8_t *signature, void *ctx)
+{
+    int enclave_ret;
+    int ret = ecall_export_state_key(eid, &enclave_ret, chaincode_id, successor_report, approvals,
+        (uint8_t *)ephemeral_pk, cipher, cipher_len, signature, ctx);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_export_state_key", ret);
+        LOG_ERROR("Lib: ERROR - ecall_export_state_key: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_endorse_key(enclave_id_t eid, const char *successor_report, uint8_t *signature)
+{
+    int enclave_ret;
+    int ret = ecall_endorse_key(eid, &enclave_ret, successor_report, signature);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_endorse_key", ret);
+        LOG_ERROR("Lib: ERROR - ecall_endorse_key: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_sign_renewal(enclave_id_t eid, const char *tx_id, const uint8_t *quote,
+    uint32_t quote_size, uint8_t *signature)
+{
+    int enclave_ret;
+    int ret = ecall_sign_renewal(eid, &enclave_ret, tx_id, quote, quote_size, signature);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_sign_renewal", ret);
+        LOG_ERROR("Lib: ERROR - ecall_sign_renewal: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_import_state_key(enclave_id_t eid, const char *chaincode_id,
+    const char *predecessor_report, const char *approvals, ec256_public_t *ephemeral_pk,
+    uint8_t *cipher, uint32_t cipher_len, uint8_t *signature, void *ctx)
+{
+    int enclave_ret;
+    int ret = ecall_import_state_key(eid, &enclave_ret, chaincode_id, predecessor_report, approvals,
+        (uint8_t *)ephemeral_pk, cipher, cipher_len, signature, ctx);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_import_state_key", ret);
+        LOG_ERROR("Lib: ERROR - ecall_import_state_key: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_escrow_state_key(enclave_id_t eid, const char *chaincode_id, uint32_t threshold,
+    uint32_t parties, uint8_t *party_pks, uint32_t party_pks_len, const char *party_msp_ids,
+    const char *approvals, uint8_t *shares, uint32_t shares_len, uint8_t *check,
+    uint8_t *signature, void *ctx)
+{
+    int enclave_ret;
+    int ret = ecall_escrow_state_key(eid, &enclave_ret, chaincode_id, threshold, parties,
+        party_pks, party_pks_len, party_msp_ids, approvals, shares, shares_len, check, signature,
+        ctx);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_escrow_state_key", ret);
+        LOG_ERROR("Lib: ERROR - ecall_escrow_state_key: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_recover_state_key(enclave_id_t eid, const char *escrow_report, const char *chaincode_id,
+    uint32_t threshold, uint32_t parties, uint8_t *party_pks, uint32_t party_pks_len,
+    const char *party_msp_ids, const char *approvals, uint8_t *check, uint8_t *signature,
+    uint32_t count, uint8_t *shares, uint32_t shares_len, void *ctx)
+{
+    int enclave_ret;
+    int ret = ecall_recover_state_key(eid, &enclave_ret, escrow_report, chaincode_id, threshold,
+        parties, party_pks, party_pks_len, party_msp_ids, approvals, check, signature, count,
+        shares, shares_len, ctx);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_recover_state_key", ret);
+        LOG_ERROR("Lib: ERROR - ecall_recover_state_key: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_seal_state(
+    enclave_id_t eid, uint8_t *sealed, uint32_t sealed_len_in, uint32_t *sealed_len_out)
+{
+    int enclave_ret;
+    int ret = ecall_seal_state(eid, &enclave_ret, sealed, sealed_len_in, sealed_len_out);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_seal_state", ret);
+        LOG_ERROR("Lib: ERROR - ecall_seal_state: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_unseal_state(
+    enclave_id_t eid, uint8_t *sealed, uint32_t sealed_len, uint32_t *identity_restored)
+{
+    int enclave_ret;
+    int ret = ecall_unseal_state(eid, &enclave_ret, sealed, sealed_len, identity_restored);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_unseal_state", ret);
+        LOG_ERROR("Lib: ERROR - ecall_unseal_state: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int sgxcc_get_audit_records(
+    enclave_id_t eid, uint8_t *records, uint32_t max_records_len, uint32_t *records_len)
+{
+    int enclave_ret;
+    int ret = ecall_get_audit_records(eid, &enclave_ret, records, max_records_len, records_len);
+    if (ret != SGX_SUCCESS) {
+        record_crash("ecall_get_audit_records", ret);
+        LOG_ERROR("Lib: ERROR - ecall_get_audit_records: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
 /* OCall functions */
 void ocall_get_state(const char *key, uint8_t *val, uint32_t max_val_len, uint32_t *val_len,
     sgx_cmac_128bit_tag_t *cmac, void *ctx)
@@ -211,6 +467,24 @@ void ocall_get_state_by_partial_composite_key(const char *key, uint8_t *bids_byt
         key, bids_bytes, max_len, bids_bytes_len, (cmac_t *)cmac, ctx);
 }
 
+void ocall_get_msp_roots(const char *msp_id, uint8_t *roots, uint32_t max_roots_len,
+    uint32_t *roots_len, sgx_cmac_128bit_tag_t *cmac, void *ctx)
+{
+    get_msp_roots(msp_id, roots, max_roots_len, roots_len, (cmac_t *)cmac, ctx);
+}
+
+void ocall_get_channel_msps(uint8_t *config, uint32_t max_config_len, uint32_t *config_len,
+    sgx_cmac_128bit_tag_t *cmac, void *ctx)
+{
+    get_channel_msps(config, max_config_len, config_len, (cmac_t *)cmac, ctx);
+}
+
+void ocall_get_ledger_time(
+    uint8_t *nonce, uint64_t *height, int64_t *time, sgx_cmac_128bit_tag_t *cmac, void *ctx)
+{
+    get_ledger_time(nonce, height, time, (cmac_t *)cmac, ctx);
+}
+
 void ocall_print_string(const char *str)
 {
     golog(str);
@@ -32,8 +32,9 @@ uint32_t sgxcc_get_quote_size(void);
 int sgxcc_get_local_attestation_report(
     enclave_id_t eid, target_info_t *target_info, report_t *report, ec256_public_t *pubkey);
 
-int sgxcc_get_remote_attestation_report(
-    enclave_id_t eid, quote_t *quote, uint32_t quote_size, ec256_public_t *pubkey, spid_t *spid);
+// binding (32 bytes) is optional and ends up in the second half of the report data
+int sgxcc_get_remote_attestation_report(enclave_id_t eid, quote_t *quote, uint32_t quote_size,
+    ec256_public_t *pubkey, spid_t *spid, const uint8_t *binding);
 
 int sgxcc_get_target_info(enclave_id_t eid, target_info_t *target_info);
 
@@ -47,6 +48,55 @@ int sgxcc_invoke(enclave_id_t eid, const char *args,
 
 int sgxcc_get_pk(enclave_id_t eid, ec256_public_t *pubkey);
 
+int sgxcc_provision_secret(enclave_id_t eid, const char *name, ec256_public_t *ephemeral_pk,
+    uint8_t *cipher, uint32_t cipher_len, const char *approval, void *ctx);
+
+int sgxcc_create_reencryption_key(enclave_id_t eid, const char *chaincode_id,
+    const char *delegatee_report, const char *approvals, ec256_public_t *delegation_pk, uint8_t *rk,
+    void *ctx);
+
+int sgxcc_provision_delegated_secret(enclave_id_t eid, const char *name,
+    ec256_public_t *delegation_pk, ec256_public_t *reencrypted_pk, uint8_t *cipher,
+    uint32_t cipher_len, const char *approval, void *ctx);
+
+int sgxcc_export_state_key(enclave_id_t eid, const char *chaincode_id,
+    const char *successor_report, const char *approvals, ec256_public_t *ephemeral_pk,
+    uint8_t *cipher, uint32_t cipher_len, uint8_t *signature, void *ctx);
+
+int sgxcc_endorse_key(enclave_id_t eid, const char *successor_report, uint8_t *signature);
+
+int sgxcc_sign_renewal(enclave_id_t eid, const char *tx_id, const uint8_t *quote,
+    uint32_t quote_size, uint8_t *signature);
+
+int sgxcc_import_state_key(enclave_id_t eid, const char *chaincode_id,
+    const char *predecessor_report, const char *approvals, ec256_public_t *ephemeral_pk,
+    uint8_t *cipher, uint32_t cipher_len, uint8_t *signature, void *ctx);
+
+int sgxcc_escrow_state_key(enclave_id_t eid, const char *chaincode_id, uint32_t threshold,
+    uint32_t parties, uint8_t *party_pks, uint32_t party_pks_len, const char *party_msp_ids,
+    const char *approvals, uint8_t *shares, uint32_t shares_len, uint8_t *check,
+    uint8_t *signature, void *ctx);
+
+int sgxcc_recover_state_key(enclave_id_t eid, const char *escrow_report, const char *chaincode_id,
+    uint32_t threshold, uint32_t parties, uint8_t *party_pks, uint32_t party_pks_len,
+    const char *party_msp_ids, const char *approvals, uint8_t *check, uint8_t *signature,
+    uint32_t count, uint8_t *shares, uint32_t shares_len, void *ctx);
+
+int sgxcc_seal_state(
+    enclave_id_t eid, uint8_t *sealed, uint32_t sealed_len_in, uint32_t *sealed_len_out);
+
+int sgxcc_unseal_state(
+    enclave_id_t eid, uint8_t *sealed, uint32_t sealed_len, uint32_t *identity_restored);
+
+int sgxcc_get_audit_records(
+    enclave_id_t eid, uint8_t *records, uint32_t max_records_len, uint32_t *records_len);
+
+// returns the number of ecalls that failed in themselves, e.g., because the enclave aborted, and the
+// name, error class, sgx status and time (unix seconds) of the latest; names and classes are static
+// strings, NULL if no ecall failed
+uint32_t sgxcc_get_crash_record(
+    const char **ecall, const char **error_class, int *status, int64_t *time);
+
 #ifdef __cplusplus
 }
 #endif /* __cplusplus */
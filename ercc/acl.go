@@ -0,0 +1,167 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// setFunctionACLFunction is the function managing ACLs. Only admins may change ACLs; an ACL set for it restricts
+// them further.
+const setFunctionACLFunction = "setFunctionACL"
+
+// ============================================================
+// setFunctionACL -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setFunctionACL(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: function name
+	// 1: acl (json encoded registry.FunctionACL); empty to remove the ACL
+	// the caller has passed the ACL of setFunctionACL in Invoke already
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting function name and ACL")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Empty function name")
+	}
+
+	key, err := stub.CreateCompositeKey(registry.ACLObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "" {
+		if err := stub.DelState(key); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+
+	acl := &registry.FunctionACL{}
+	if err := json.Unmarshal([]byte(args[1]), acl); err != nil {
+		return shim.Error("Can not parse ACL: " + err.Error())
+	}
+	if err := acl.Validate(); err != nil {
+		return shim.Error("Invalid ACL: " + err.Error())
+	}
+
+	aclAsBytes, err := registry.MarshalCanonical(acl)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, aclAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(aclAsBytes)
+}
+
+// ============================================================
+// getFunctionACL -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getFunctionACL(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "function"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting function name")
+	}
+
+	acl, err := getFunctionACL(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if acl == nil {
+		return shim.Error("No ACL for " + args[0])
+	}
+
+	aclAsBytes, err := registry.MarshalCanonical(acl)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(aclAsBytes)
+}
+
+// checkFunctionACL returns an error if the ACL of function does not allow the invoking identity. Functions without
+// ACL are open to anyone. Checks within the functions, e.g., for admins, apply in addition.
+func (ercc *EnclaveRegistryCC) checkFunctionACL(stub shim.ChaincodeStubInterface, function string) error {
+	acl, err := getFunctionACL(stub, function)
+	if err != nil {
+		return err
+	} else if acl == nil {
+		return nil
+	}
+
+	identity, err := aclIdentity(stub)
+	if err != nil {
+		return err
+	}
+	if ercc.policies != nil {
+		identity.Policies = &stubPolicies{policies: ercc.policies, stub: stub}
+	}
+	if !acl.Allows(*identity) {
+		return errors.New("Access denied to " + function + " for " + identity.MspID)
+	}
+	return nil
+}
+
+// aclIdentity returns the MSP id, organizational units and admin attribute of the invoking identity
+func aclIdentity(stub shim.ChaincodeStubInterface) (*registry.ACLIdentity, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, errors.New("Can not get client msp id: " + err.Error())
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return nil, errors.New("Can not get client certificate: " + err.Error())
+	}
+	return &registry.ACLIdentity{
+		MspID:   mspID,
+		OUs:     cert.Subject.OrganizationalUnit,
+		IsAdmin: checkAdmin(stub) == nil,
+	}, nil
+}
+
+// getFunctionACL returns the ACL of function or nil if there is none
+func getFunctionACL(stub shim.ChaincodeStubInterface, function string) (*registry.FunctionACL, error) {
+	key, err := stub.CreateCompositeKey(registry.ACLObjectType, []string{function})
+	if err != nil {
+		return nil, err
+	}
+
+	aclAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if aclAsBytes == nil {
+		return nil, nil
+	}
+
+	acl := &registry.FunctionACL{}
+	if err := json.Unmarshal(aclAsBytes, acl); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
@@ -0,0 +1,186 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// setAdvisory -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setAdvisory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: advisoryID (e.g., INTEL-SA-00161)
+	// 1: action (flag or revoke)
+	// 2: description
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting advisory id, action and description")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	advisory := &registry.Advisory{ID: args[0], Action: args[1], Description: args[2]}
+	if advisory.ID == "" {
+		return shim.Error("Advisory id must not be empty")
+	}
+	if advisory.Action != registry.AdvisoryActionFlag && advisory.Action != registry.AdvisoryActionRevoke {
+		return shim.Error("Invalid advisory action: " + advisory.Action)
+	}
+
+	advisoryAsBytes, err := registry.MarshalCanonical(advisory)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.AdvisoryObjectType, []string{advisory.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := stub.PutState(key, advisoryAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// flag or revoke all enclaves whose platform is affected according to their last re-validation
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(registry.StatusObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var changes []registry.TrustChange
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		status := &registry.EnclaveStatus{}
+		if err := json.Unmarshal(kv.Value, status); err != nil {
+			return shim.Error(err.Error())
+		}
+		if !status.Apply(advisory) {
+			continue
+		}
+		if status.IsRevoked() && status.RevokedAt == 0 {
+			if status.RevokedAt, err = getTxTime(stub); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		statusAsBytes, err := registry.MarshalCanonical(status)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.PutState(kv.Key, statusAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+
+		changes = append(changes, registry.TrustChange{
+			EnclavePkHash: attributes[0],
+			AdvisoryID:    advisory.ID,
+			Action:        advisory.Action,
+			Description:   advisory.Description,
+		})
+	}
+
+	if err := setTrustChangedEvent(stub, changes); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := transcribeRevocations(stub, changes); err != nil {
+		return shim.Error("Can not append to transcript: " + err.Error())
+	}
+
+	changesAsBytes, err := registry.MarshalCanonical(changes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(changesAsBytes)
+}
+
+// ============================================================
+// getAdvisory -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getAdvisory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "advisoryID"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting advisory id")
+	}
+
+	advisory, err := getAdvisory(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if advisory == nil {
+		return shim.Error("Advisory does not exist: " + args[0])
+	}
+
+	advisoryAsBytes, err := registry.MarshalCanonical(advisory)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(advisoryAsBytes)
+}
+
+// getAdvisory returns the ingested advisory or nil if there is none with the given id
+func getAdvisory(stub shim.ChaincodeStubInterface, advisoryID string) (*registry.Advisory, error) {
+	key, err := stub.CreateCompositeKey(registry.AdvisoryObjectType, []string{advisoryID})
+	if err != nil {
+		return nil, err
+	}
+
+	advisoryAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if advisoryAsBytes == nil {
+		return nil, nil
+	}
+
+	advisory := &registry.Advisory{}
+	if err := json.Unmarshal(advisoryAsBytes, advisory); err != nil {
+		return nil, err
+	}
+	return advisory, nil
+}
+
+// setTrustChangedEvent tells operators which enclaves lost trust and why
+func setTrustChangedEvent(stub shim.ChaincodeStubInterface, changes []registry.TrustChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	changesAsBytes, err := registry.MarshalCanonical(changes)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(registry.TrustChangedEventName, changesAsBytes)
+}
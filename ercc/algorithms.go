@@ -0,0 +1,161 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// setAlgorithmPolicy -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setAlgorithmPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0..n: accepted algorithms as "<signature>/<encryption>", in order of preference
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting accepted algorithms")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policy := &registry.AlgorithmPolicy{}
+	for _, arg := range args {
+		a, err := algorithm.Parse(arg)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		policy.Accepted = append(policy.Accepted, a)
+	}
+	if err := policy.Validate(); err != nil {
+		return shim.Error("Invalid algorithm policy: " + err.Error())
+	}
+
+	policyAsBytes, err := registry.MarshalCanonical(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.AlgorithmPolicyObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, policyAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// registered enclaves keep their algorithms; the policy applies to subsequent registrations
+	return shim.Success(policyAsBytes)
+}
+
+// ============================================================
+// getAlgorithmPolicy -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getAlgorithmPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	policy, err := getAlgorithmPolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policyAsBytes, err := registry.MarshalCanonical(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(policyAsBytes)
+}
+
+// getAlgorithmPolicy returns the algorithm policy of the channel, or the default policy if none is set
+func getAlgorithmPolicy(stub shim.ChaincodeStubInterface) (*registry.AlgorithmPolicy, error) {
+	key, err := stub.CreateCompositeKey(registry.AlgorithmPolicyObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+
+	policyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get algorithm policy")
+	} else if policyAsBytes == nil {
+		return registry.DefaultAlgorithmPolicy(), nil
+	}
+
+	policy := &registry.AlgorithmPolicy{}
+	if err := json.Unmarshal(policyAsBytes, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// checkAlgorithms returns the algorithms of an enclave key (DER-encoded PKIX) if the channel accepts them
+func checkAlgorithms(stub shim.ChaincodeStubInterface, enclavePk []byte) (algorithm.Algorithms, error) {
+	a, err := algorithm.Of(enclavePk)
+	if err != nil {
+		return algorithm.Algorithms{}, err
+	}
+
+	policy, err := getAlgorithmPolicy(stub)
+	if err != nil {
+		return algorithm.Algorithms{}, err
+	}
+	if err := policy.Accepts(a); err != nil {
+		return algorithm.Algorithms{}, err
+	}
+	return a, nil
+}
+
+// putAlgorithms records the algorithms of a registered enclave key
+func putAlgorithms(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, a algorithm.Algorithms) error {
+	algorithmsAsBytes, err := registry.MarshalCanonical(&a)
+	if err != nil {
+		return err
+	}
+
+	key, err := stub.CreateCompositeKey(registry.AlgorithmsObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, algorithmsAsBytes)
+}
+
+// getAlgorithms returns the recorded algorithms of an enclave key, or nil for registrations without
+func getAlgorithms(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) (*algorithm.Algorithms, error) {
+	key, err := stub.CreateCompositeKey(registry.AlgorithmsObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return nil, err
+	}
+
+	algorithmsAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if algorithmsAsBytes == nil {
+		return nil, nil
+	}
+
+	a := &algorithm.Algorithms{}
+	if err := json.Unmarshal(algorithmsAsBytes, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
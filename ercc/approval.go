@@ -0,0 +1,90 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+)
+
+// checkApproval parses a json encoded registry.Approval and returns it if the creator of the transaction signed the
+// statement. ercc stores approvals along with what they approve, so that enclaves can verify them.
+func checkApproval(stub shim.ChaincodeStubInterface, approvalJSON string, statement []byte) (*registry.Approval, error) {
+	approval := &registry.Approval{}
+	if err := json.Unmarshal([]byte(approvalJSON), approval); err != nil {
+		return nil, errors.New("Can not parse approval: " + err.Error())
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, errors.New("Can not get client msp id: " + err.Error())
+	}
+	if approval.MspID != mspID {
+		return nil, errors.New("Approval is not signed by a member of " + mspID)
+	}
+
+	creatorCert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return nil, errors.New("Can not get client certificate: " + err.Error())
+	}
+	cert, err := approval.Verify(statement)
+	if err != nil {
+		return nil, errors.New("Invalid approval: " + err.Error())
+	}
+	if !bytes.Equal(cert.Raw, creatorCert.Raw) {
+		return nil, errors.New("Approval is not signed by the client")
+	}
+	return approval, nil
+}
+
+// checkChannelApproval is checkApproval for approvals that count towards a quorum of the application MSPs of the
+// channel (see registry.VerifyQuorum); it also returns these MSPs.
+func (ercc *EnclaveRegistryCC) checkChannelApproval(stub shim.ChaincodeStubInterface, approvalJSON string, statement []byte) (*registry.Approval, []string, error) {
+	approval, err := checkApproval(stub, approvalJSON, statement)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msps, err := ercc.channelMSPs(stub)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, mspID := range msps {
+		if mspID == approval.MspID {
+			return approval, msps, nil
+		}
+	}
+	return nil, nil, errors.New(approval.MspID + " is not an application MSP of the channel")
+}
+
+// channelMSPs returns the application MSPs of the channel as seen by tlcc
+func (ercc *EnclaveRegistryCC) channelMSPs(stub shim.ChaincodeStubInterface) ([]string, error) {
+	if ercc.ledger == nil {
+		return nil, errors.New("Channel MSPs not available to verify approvals")
+	}
+	msps, err := ercc.ledger.MSPs(stub)
+	if err != nil {
+		return nil, errors.New("Can not get channel MSPs: " + err.Error())
+	}
+	return msps, nil
+}
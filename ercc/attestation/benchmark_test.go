@@ -0,0 +1,104 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation_test
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+)
+
+// signedReport returns an attestation report signed by a SigningIAS together with the verification key and the
+// enclave pk and binding bound in the quote
+func signedReport(b *testing.B) (attestation.IASAttestationReport, interface{}, []byte, *attestation.ReportDataBinding) {
+	ias, err := mock.NewSigningIAS()
+	if err != nil {
+		b.Fatalf("Can not create IAS: %s", err)
+	}
+	_, pkBytes, err := mock.EnclaveKey()
+	if err != nil {
+		b.Fatalf("Can not load enclave key: %s", err)
+	}
+	binding := &attestation.ReportDataBinding{Nonce: attestation.TxNonce("tx1"), ChannelID: "mychannel", ChaincodeID: "ecc"}
+	quote, err := mock.NewQuote(pkBytes, binding, mock.MrEnclave, 1)
+	if err != nil {
+		b.Fatalf("Can not create quote: %s", err)
+	}
+	report, err := ias.RequestAttestationReport(tls.Certificate{}, quote)
+	if err != nil {
+		b.Fatalf("Can not get attestation report: %s", err)
+	}
+	verificationPK, err := ias.GetIntelVerificationKey()
+	if err != nil {
+		b.Fatalf("Can not get verification key: %s", err)
+	}
+	return report, verificationPK, pkBytes, binding
+}
+
+func BenchmarkVerifierImpl_VerifyAttestionReport(b *testing.B) {
+	report, verificationPK, _, _ := signedReport(b)
+	verifier := &attestation.VerifierImpl{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ok, err := verifier.VerifyAttestionReport(verificationPK, report); !ok || err != nil {
+			b.Fatalf("Report should be valid: %v", err)
+		}
+	}
+}
+
+func BenchmarkVerifierImpl_CheckReportData(b *testing.B) {
+	report, _, pkBytes, binding := signedReport(b)
+	verifier := &attestation.VerifierImpl{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ok, err := verifier.CheckReportData(pkBytes, binding, report); !ok || err != nil {
+			b.Fatalf("Report data should match: %v", err)
+		}
+	}
+}
+
+func BenchmarkParseQuote(b *testing.B) {
+	_, pkBytes, _ := mock.EnclaveKey()
+	quote, err := mock.NewQuote(pkBytes, nil, mock.MrEnclave, 1)
+	if err != nil {
+		b.Fatalf("Can not create quote: %s", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := attestation.ParseQuote(quote); err != nil {
+			b.Fatalf("Can not parse quote: %s", err)
+		}
+	}
+}
+
+func BenchmarkQuoteFromAttestionReport(b *testing.B) {
+	report, _, _, _ := signedReport(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := attestation.QuoteFromAttestionReport(report); err != nil {
+			b.Fatalf("Can not get quote from report: %s", err)
+		}
+	}
+}
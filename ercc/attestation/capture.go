@@ -0,0 +1,142 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// CaptureFileEnv names the environment variable that enables the debug capture of IAS requests and responses.
+// If set, every request/response pair is appended as JSON line to the given file. Bodies are recorded as digests
+// only and credentials are removed from the headers, so the file can be shared with Intel support.
+const CaptureFileEnv = "IAS_CAPTURE_FILE"
+
+// headers that must never be written to a capture file
+var sensitiveHeaders = []string{"Authorization", "Ocp-Apim-Subscription-Key", "Cookie", "Set-Cookie"}
+
+// CaptureRecord is a sanitized IAS request/response pair
+type CaptureRecord struct {
+	Time               time.Time   `json:"time"`
+	Method             string      `json:"method"`
+	URL                string      `json:"url"`
+	RequestHeader      http.Header `json:"requestHeader"`
+	RequestBodySHA256  string      `json:"requestBodySHA256"`
+	StatusCode         int         `json:"statusCode,omitempty"`
+	ResponseHeader     http.Header `json:"responseHeader,omitempty"`
+	ResponseBodySHA256 string      `json:"responseBodySHA256,omitempty"`
+	ResponseBodySize   int         `json:"responseBodySize,omitempty"`
+	Error              string      `json:"error,omitempty"`
+}
+
+// captureTransport records every round trip to a capture file
+type captureTransport struct {
+	next http.RoundTripper
+	file string
+	lock sync.Mutex
+}
+
+func newCaptureTransport(next http.RoundTripper, file string) *captureTransport {
+	return &captureTransport{next: next, file: file}
+}
+
+func (c *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	record := &CaptureRecord{
+		Time:          time.Now().UTC(),
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		RequestHeader: sanitizeHeader(req.Header),
+	}
+
+	if req.Body != nil {
+		requestBody, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		record.RequestBodySHA256 = digest(requestBody)
+		req.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		record.Error = err.Error()
+		c.write(record)
+		return nil, err
+	}
+
+	// the body is recorded as received, i.e., possibly compressed
+	// oversized bodies are truncated one byte beyond the limit, which the reader of the response rejects
+	responseBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxResponseBodySize+1))
+	resp.Body.Close()
+	if err != nil {
+		record.Error = err.Error()
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+	record.StatusCode = resp.StatusCode
+	record.ResponseHeader = sanitizeHeader(resp.Header)
+	record.ResponseBodySHA256 = digest(responseBody)
+	record.ResponseBodySize = len(responseBody)
+	c.write(record)
+
+	return resp, nil
+}
+
+// write appends a record to the capture file; capture failures never fail the request
+func (c *captureTransport) write(record *CaptureRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	f, err := os.OpenFile(c.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+func sanitizeHeader(header http.Header) http.Header {
+	sanitized := http.Header{}
+	for k, v := range header {
+		sanitized[k] = v
+	}
+	for _, k := range sensitiveHeaders {
+		if sanitized.Get(k) != "" {
+			sanitized.Set(k, "REDACTED")
+		}
+	}
+	return sanitized
+}
+
+func digest(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,77 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestCaptureTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatalf("Can not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ias.log")
+
+	body := []byte(`{"isvEnclaveQuoteStatus":"OK"}`)
+	transport := newCaptureTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		header := http.Header{}
+		header.Set("Request-ID", "42")
+		return &http.Response{StatusCode: 200, Header: header, Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+	}), file)
+
+	req, _ := http.NewRequest("POST", iasURL, strings.NewReader(`{"isvEnclaveQuote":"secret quote"}`))
+	req.Header.Set("Ocp-Apim-Subscription-Key", "mykey")
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %s", err)
+	}
+	if data, _ := ioutil.ReadAll(resp.Body); !bytes.Equal(data, body) {
+		t.Fatalf("Response body not preserved")
+	}
+
+	captured, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Can not read capture file: %s", err)
+	}
+	if bytes.Contains(captured, []byte("mykey")) || bytes.Contains(captured, []byte("secret quote")) {
+		t.Fatalf("Capture file leaks credentials or bodies: %s", captured)
+	}
+
+	record := &CaptureRecord{}
+	if err := json.Unmarshal(captured, record); err != nil {
+		t.Fatalf("Can not parse capture record: %s", err)
+	}
+	if record.StatusCode != 200 || record.ResponseHeader.Get("Request-ID") != "42" || record.ResponseBodySHA256 != digest(body) {
+		t.Fatalf("Unexpected capture record %+v", record)
+	}
+}
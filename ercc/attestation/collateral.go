@@ -0,0 +1,170 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// CollateralTypeIAS is the type of the collateral of attestation reports signed by IAS
+const CollateralTypeIAS = "ias"
+
+// crlPEMType is the PEM block type of CRLs
+const crlPEMType = "X509 CRL"
+
+// Collateral bundles what an attestation report was verified with: the verification key, the signing certificate
+// chain and the CRLs of the chain known at the time of verification. With the collateral, anyone can re-verify a
+// report as of the time it was verified, even years later when certificates have expired or the attestation
+// service has changed.
+type Collateral struct {
+	Type string `json:"type"`
+	// VerifiedAt is the time (unix seconds) the report was verified; the chain and the CRLs are checked as of then
+	VerifiedAt int64 `json:"verifiedAt"`
+	// VerificationKey is the PEM-encoded key the report signature was verified with
+	VerificationKey string `json:"verificationKey"`
+	// CertificateChain is the PEM-encoded report signing certificate chain, signing certificate first
+	CertificateChain string `json:"certificateChain"`
+	// CRLs are the PEM-encoded CRLs issued by certificates of the chain
+	CRLs []string `json:"crls,omitempty"`
+}
+
+// NewCollateral bundles the collateral of report, which was verified with verificationPubKey at verifiedAt (unix
+// seconds). Of crls (PEM or DER), only those issued by a certificate of the signing chain are kept.
+func NewCollateral(report IASAttestationReport, verificationPubKey interface{}, crls [][]byte, verifiedAt int64) (*Collateral, error) {
+	keyDER, err := x509.MarshalPKIXPublicKey(verificationPubKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid verification key: %s", err)
+	}
+	chain, err := url.QueryUnescape(report.IASReportSigningCertificate)
+	if err != nil {
+		return nil, errors.New("Malformed signing certificate chain: " + err.Error())
+	}
+
+	collateral := &Collateral{
+		Type:             CollateralTypeIAS,
+		VerifiedAt:       verifiedAt,
+		VerificationKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: keyDER})),
+		CertificateChain: chain,
+	}
+	certs := parseCertificates([]byte(chain))
+	for _, raw := range crls {
+		crl, der, err := ParseCRL(raw)
+		if err != nil {
+			return nil, err
+		}
+		if crlIssuer(crl, certs) == nil {
+			continue
+		}
+		collateral.CRLs = append(collateral.CRLs, string(pem.EncodeToMemory(&pem.Block{Type: crlPEMType, Bytes: der})))
+	}
+	return collateral, nil
+}
+
+// Verify re-verifies report as of the time recorded in the collateral. The report must be signed with the
+// bundled key by the bundled certificate chain, which must have been valid and not revoked by the bundled CRLs.
+func (c *Collateral) Verify(report IASAttestationReport) error {
+	if c.Type != CollateralTypeIAS {
+		return fmt.Errorf("Unsupported collateral type %s", c.Type)
+	}
+	block, _ := pem.Decode([]byte(c.VerificationKey))
+	if block == nil {
+		return errors.New("No verification key in collateral")
+	}
+	verificationPubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("Invalid verification key in collateral: %s", err)
+	}
+	chain, err := url.QueryUnescape(report.IASReportSigningCertificate)
+	if err != nil || chain != c.CertificateChain {
+		return errors.New("Signing certificate chain of report differs from collateral")
+	}
+
+	at := time.Unix(c.VerifiedAt, 0)
+	certs, err := verifyAttestationReportAt(verificationPubKey, report, at)
+	if err != nil {
+		return err
+	}
+
+	for _, crlPEM := range c.CRLs {
+		crl, _, err := ParseCRL([]byte(crlPEM))
+		if err != nil {
+			return err
+		}
+		issuer := crlIssuer(crl, certs)
+		if issuer == nil {
+			return errors.New("CRL in collateral not issued by signing certificate chain")
+		}
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.RevocationTime.After(at) {
+				continue
+			}
+			for _, cert := range certs {
+				if bytes.Equal(cert.RawIssuer, issuer.RawSubject) && cert.SerialNumber.Cmp(revoked.SerialNumber) == 0 {
+					return fmt.Errorf("Certificate %s was revoked at %s", cert.Subject.CommonName, revoked.RevocationTime)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ParseCRL parses a PEM or DER-encoded CRL and returns it together with its DER encoding
+func ParseCRL(raw []byte) (*pkix.CertificateList, []byte, error) {
+	der := raw
+	if block, _ := pem.Decode(raw); block != nil {
+		if block.Type != crlPEMType {
+			return nil, nil, fmt.Errorf("Unexpected PEM block %s instead of CRL", block.Type)
+		}
+		der = block.Bytes
+	}
+	crl, err := x509.ParseDERCRL(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid CRL: %s", err)
+	}
+	return crl, der, nil
+}
+
+// crlIssuer returns the certificate of certs that signed crl, if any
+func crlIssuer(crl *pkix.CertificateList, certs []*x509.Certificate) *x509.Certificate {
+	for _, cert := range certs {
+		if cert.CheckCRLSignature(crl) == nil {
+			return cert
+		}
+	}
+	return nil
+}
+
+// parseCertificates returns the certificates of a PEM-encoded chain; malformed certificates are skipped
+func parseCertificates(chain []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(chain); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
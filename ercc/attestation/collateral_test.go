@@ -0,0 +1,91 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation_test
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+)
+
+func TestCollateral(t *testing.T) {
+	ias, err := mock.NewSigningIAS()
+	if err != nil {
+		t.Fatalf("Can not create IAS: %s", err)
+	}
+	_, pkBytes, _ := mock.EnclaveKey()
+	quote, _ := mock.NewQuote(pkBytes, nil, mock.MrEnclave, 1)
+	report, err := ias.RequestAttestationReport(tls.Certificate{}, quote)
+	if err != nil {
+		t.Fatalf("Can not get attestation report: %s", err)
+	}
+	verificationPK, _ := ias.GetIntelVerificationKey()
+
+	crl, err := ias.CRL(time.Time{})
+	if err != nil {
+		t.Fatalf("Can not create CRL: %s", err)
+	}
+	otherIAS, _ := mock.NewSigningIAS()
+	otherCRL, _ := otherIAS.CRL(time.Time{})
+
+	now := time.Now().Unix()
+	collateral, err := attestation.NewCollateral(report, verificationPK, [][]byte{crl, otherCRL}, now)
+	if err != nil {
+		t.Fatalf("Can not create collateral: %s", err)
+	}
+	if len(collateral.CRLs) != 1 {
+		t.Fatalf("Expected the CRL of the signing chain only but got %d CRLs", len(collateral.CRLs))
+	}
+	if err := collateral.Verify(report); err != nil {
+		t.Fatalf("Report should verify against its collateral: %s", err)
+	}
+
+	// the chain is checked as of the verification time, not now
+	before := *collateral
+	before.VerifiedAt = now - 48*3600
+	if err := before.Verify(report); err == nil {
+		t.Fatalf("Report should not verify before its signing certificate was valid")
+	}
+
+	// another report signing key or chain does not verify
+	otherReport, _ := otherIAS.RequestAttestationReport(tls.Certificate{}, quote)
+	if err := collateral.Verify(otherReport); err == nil {
+		t.Fatalf("Report of another IAS should not verify")
+	}
+
+	// only revocations before the verification time count
+	for revokedAt, valid := range map[time.Time]bool{
+		time.Unix(now-60, 0):   false,
+		time.Unix(now+3600, 0): true,
+	} {
+		revokingCRL, _ := ias.CRL(revokedAt)
+		revoking, err := attestation.NewCollateral(report, verificationPK, [][]byte{revokingCRL}, now)
+		if err != nil {
+			t.Fatalf("Can not create collateral: %s", err)
+		}
+		if err := revoking.Verify(report); (err == nil) != valid {
+			t.Fatalf("Revocation at %s: expected valid %t but got %v", revokedAt, valid, err)
+		}
+	}
+
+	if _, err := attestation.NewCollateral(report, verificationPK, [][]byte{[]byte("not a crl")}, now); err == nil {
+		t.Fatalf("Malformed CRL should be rejected")
+	}
+}
@@ -0,0 +1,236 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CredentialsCheckInterval is how often an IASCredentialProvider checks its files for changes
+const CredentialsCheckInterval = 10 * time.Second
+
+// ClientCertProvider provides the client certificate authenticating to IAS
+type ClientCertProvider interface {
+	GetIASClientCert() (tls.Certificate, error)
+}
+
+// IASCredentials authenticate ercc to IAS
+type IASCredentials struct {
+	CertPEM []byte
+	KeyPEM  []byte
+	SPID    []byte
+}
+
+// IASCredentialProvider provides the IAS credentials stored in files. The files are re-read when they change, so
+// credentials can be rotated without restarting the peer. New files are only used once the certificate matches
+// the key; while a rotation is incomplete, the previous credentials remain in use.
+type IASCredentialProvider struct {
+	files []string
+
+	mutex       sync.Mutex
+	credentials *IASCredentials
+	versions    []string
+	lastCheck   time.Time
+	now         func() time.Time
+}
+
+// NewIASCredentialProvider loads the credentials from the given files. spidFile may be empty if no SPID is needed.
+func NewIASCredentialProvider(certFile, keyFile, spidFile string) (*IASCredentialProvider, error) {
+	p := &IASCredentialProvider{files: []string{certFile, keyFile, spidFile}, now: time.Now}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewIASCredentialProviderFromConfig loads the credentials from the files configured for the peer as sgx.ias.*.file,
+// overridden by the CORE_SGX_IAS_*_FILE environment variables. Relative paths are resolved against FABRIC_CFG_PATH.
+func NewIASCredentialProviderFromConfig() (*IASCredentialProvider, error) {
+	return NewIASCredentialProvider(
+		configPath("CORE_SGX_IAS_CERT_FILE", "ias/client.crt"),
+		configPath("CORE_SGX_IAS_KEY_FILE", "ias/client.key"),
+		configPath("CORE_SGX_IAS_SPID_FILE", "ias/spid.txt"))
+}
+
+// configPath returns the file named by the environment variable env, or defaultPath if not set. Relative paths are
+// resolved against FABRIC_CFG_PATH.
+func configPath(env, defaultPath string) string {
+	file := os.Getenv(env)
+	if file == "" {
+		file = defaultPath
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(os.Getenv("FABRIC_CFG_PATH"), file)
+	}
+	return file
+}
+
+// Get returns the current credentials
+func (p *IASCredentialProvider) Get() *IASCredentials {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if now := p.now(); now.Sub(p.lastCheck) >= CredentialsCheckInterval {
+		p.lastCheck = now
+		if versions, err := p.fileVersions(); err == nil && !sameVersions(versions, p.versions) {
+			// keep the previous credentials until the rotation is complete
+			p.load(versions)
+		}
+	}
+	return p.credentials
+}
+
+// GetIASClientCert returns the current client certificate
+func (p *IASCredentialProvider) GetIASClientCert() (tls.Certificate, error) {
+	credentials := p.Get()
+	return tls.X509KeyPair(credentials.CertPEM, credentials.KeyPEM)
+}
+
+// Reload re-reads the credential files immediately, e.g., when triggered by an admin. The previous credentials
+// remain in use if the files are invalid.
+func (p *IASCredentialProvider) Reload() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	versions, err := p.fileVersions()
+	if err != nil {
+		return err
+	}
+	return p.load(versions)
+}
+
+// load reads the credentials; must be called with mutex held
+func (p *IASCredentialProvider) load(versions []string) error {
+	certPEM, err := readPemFromFile(p.files[0])
+	if err != nil {
+		return err
+	}
+	keyPEM, err := readPemFromFile(p.files[1])
+	if err != nil {
+		return err
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("Certificate does not match key: %s", err)
+	}
+	var spid []byte
+	if p.files[2] != "" {
+		if spid, err = ioutil.ReadFile(p.files[2]); err != nil {
+			return fmt.Errorf("Could not read file %s, err %s", p.files[2], err)
+		}
+	}
+
+	p.credentials = &IASCredentials{CertPEM: certPEM, KeyPEM: keyPEM, SPID: spid}
+	p.versions = versions
+	return nil
+}
+
+// fileVersions identifies the current content of the credential files by size and modification time
+func (p *IASCredentialProvider) fileVersions() ([]string, error) {
+	return fileVersionsOf(p.files)
+}
+
+// fileVersionsOf identifies the current content of files by size and modification time; empty names are skipped
+func fileVersionsOf(files []string) ([]string, error) {
+	versions := make([]string, len(files))
+	for i, file := range files {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		versions[i] = fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+	}
+	return versions, nil
+}
+
+// IASAccount configures the IAS subscription of an org, e.g., as entry of sgx.ias.accounts in core.yaml
+type IASAccount struct {
+	MspID    string
+	CertFile string
+	KeyFile  string
+	SPIDFile string
+}
+
+// IASCredentialSet selects IAS credentials by the msp id of the org registering an enclave. This allows
+// consortiums whose members have their own IAS subscriptions to share one registry.
+type IASCredentialSet struct {
+	defaultCredentials *IASCredentialProvider
+	orgs               map[string]*IASCredentialProvider
+}
+
+// NewIASCredentialSet loads the credentials of the given accounts. The default credentials are used for orgs
+// without an account; they may be nil if every registering org has its own account.
+func NewIASCredentialSet(defaultCredentials *IASCredentialProvider, accounts []IASAccount) (*IASCredentialSet, error) {
+	s := &IASCredentialSet{defaultCredentials: defaultCredentials, orgs: make(map[string]*IASCredentialProvider)}
+	for _, account := range accounts {
+		if account.MspID == "" {
+			return nil, fmt.Errorf("IAS account without msp id")
+		}
+		if _, ok := s.orgs[account.MspID]; ok {
+			return nil, fmt.Errorf("Duplicate IAS account for %s", account.MspID)
+		}
+		provider, err := NewIASCredentialProvider(account.CertFile, account.KeyFile, account.SPIDFile)
+		if err != nil {
+			return nil, fmt.Errorf("Can not load IAS credentials of %s: %s", account.MspID, err)
+		}
+		s.orgs[account.MspID] = provider
+	}
+	return s, nil
+}
+
+// ForMSP returns the credentials of the org with the given msp id
+func (s *IASCredentialSet) ForMSP(mspID string) (*IASCredentialProvider, error) {
+	if provider, ok := s.orgs[mspID]; ok {
+		return provider, nil
+	}
+	if s.defaultCredentials == nil {
+		return nil, fmt.Errorf("No IAS account for %s", mspID)
+	}
+	return s.defaultCredentials, nil
+}
+
+func readPemFromFile(file string) ([]byte, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read file %s, err %s", file, err)
+	}
+	if b, _ := pem.Decode(bytes); b == nil {
+		return nil, fmt.Errorf("No pem content for file %s", file)
+	}
+	return bytes, nil
+}
+
+func sameVersions(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
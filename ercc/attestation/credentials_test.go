@@ -0,0 +1,178 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newClientCert returns a self-signed client cert and its key in PEM format
+func newClientCert(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Can not create cert: %s", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile writes a credential file with the given modification time
+func writeFile(t *testing.T, file string, content []byte, modTime time.Time) {
+	if err := ioutil.WriteFile(file, content, 0600); err != nil {
+		t.Fatalf("Can not write %s: %s", file, err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatalf("Can not touch %s: %s", file, err)
+	}
+}
+
+func TestIASCredentialProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ias")
+	if err != nil {
+		t.Fatalf("Can not create dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, spidFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "spid.txt")
+
+	modTime := time.Now().Add(-time.Hour)
+	oldCert, oldKey := newClientCert(t, "old")
+	writeFile(t, certFile, oldCert, modTime)
+	writeFile(t, keyFile, oldKey, modTime)
+	writeFile(t, spidFile, []byte("spid"), modTime)
+
+	provider, err := NewIASCredentialProvider(certFile, keyFile, spidFile)
+	if err != nil {
+		t.Fatalf("Can not load credentials: %s", err)
+	}
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+	if string(provider.Get().CertPEM) != string(oldCert) || string(provider.Get().SPID) != "spid" {
+		t.Fatalf("Unexpected credentials")
+	}
+
+	// the cert is rotated first; the old credentials remain in use as the key does not match
+	newCert, newKey := newClientCert(t, "new")
+	modTime = modTime.Add(time.Minute)
+	writeFile(t, certFile, newCert, modTime)
+	now = now.Add(CredentialsCheckInterval)
+	if string(provider.Get().CertPEM) != string(oldCert) {
+		t.Fatalf("Incomplete rotation should keep the old credentials")
+	}
+
+	// the key is rotated but not checked before the next interval
+	writeFile(t, keyFile, newKey, modTime)
+	if string(provider.Get().CertPEM) != string(oldCert) {
+		t.Fatalf("Files should only be checked once per interval")
+	}
+
+	now = now.Add(CredentialsCheckInterval)
+	if string(provider.Get().CertPEM) != string(newCert) {
+		t.Fatalf("Rotated credentials should be used")
+	}
+	if _, err := provider.GetIASClientCert(); err != nil {
+		t.Fatalf("Can not get client cert: %s", err)
+	}
+
+	// forced reload of invalid files keeps the credentials
+	writeFile(t, keyFile, []byte("no key"), modTime)
+	if err := provider.Reload(); err == nil {
+		t.Fatalf("Reload of invalid key should fail")
+	}
+	if string(provider.Get().KeyPEM) != string(newKey) {
+		t.Fatalf("Invalid files should keep the credentials")
+	}
+}
+
+func TestIASCredentialSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ias")
+	if err != nil {
+		t.Fatalf("Can not create dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// every org has its own account
+	var accounts []IASAccount
+	for _, mspID := range []string{"Org1MSP", "Org2MSP"} {
+		cert, key := newClientCert(t, mspID)
+		account := IASAccount{
+			MspID:    mspID,
+			CertFile: filepath.Join(dir, mspID+".crt"),
+			KeyFile:  filepath.Join(dir, mspID+".key"),
+			SPIDFile: filepath.Join(dir, mspID+".spid"),
+		}
+		writeFile(t, account.CertFile, cert, time.Now())
+		writeFile(t, account.KeyFile, key, time.Now())
+		writeFile(t, account.SPIDFile, []byte("spid of "+mspID), time.Now())
+		accounts = append(accounts, account)
+	}
+
+	credentials, err := NewIASCredentialSet(nil, accounts)
+	if err != nil {
+		t.Fatalf("Can not load credentials: %s", err)
+	}
+	for _, mspID := range []string{"Org1MSP", "Org2MSP"} {
+		provider, err := credentials.ForMSP(mspID)
+		if err != nil {
+			t.Fatalf("No credentials for %s: %s", mspID, err)
+		}
+		if string(provider.Get().SPID) != "spid of "+mspID {
+			t.Fatalf("Unexpected SPID for %s: %s", mspID, provider.Get().SPID)
+		}
+	}
+	if _, err := credentials.ForMSP("Org3MSP"); err == nil {
+		t.Fatalf("Orgs without account should have no credentials")
+	}
+
+	// orgs without account fall back to the default credentials
+	defaultCredentials, err := NewIASCredentialProvider(accounts[0].CertFile, accounts[0].KeyFile, "")
+	if err != nil {
+		t.Fatalf("Can not load credentials: %s", err)
+	}
+	credentials, err = NewIASCredentialSet(defaultCredentials, accounts[1:])
+	if err != nil {
+		t.Fatalf("Can not load credentials: %s", err)
+	}
+	if provider, err := credentials.ForMSP("Org3MSP"); err != nil || provider != defaultCredentials {
+		t.Fatalf("Orgs without account should get the default credentials")
+	}
+
+	if _, err := NewIASCredentialSet(nil, []IASAccount{accounts[0], accounts[0]}); err == nil {
+		t.Fatalf("Duplicate accounts should be rejected")
+	}
+}
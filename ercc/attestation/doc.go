@@ -0,0 +1,23 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Package attestation parses and verifies SGX quotes and IAS attestation reports, and talks to IAS.
+//
+// This is the attestation logic used by ercc and its vscc. It does not depend on the chaincode shim, so it can be
+// embedded off-chain. Its exported API is stable within a major release; see package client for the compatibility
+// rules. The subpackages mock, iasproxy and verification are public as well; the commands ias_proxy,
+// verification_service, verify_evidence and the ias_credentials plugin are not meant to be imported.
+package attestation
@@ -0,0 +1,150 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"net/url"
+	"testing"
+)
+
+// fuzzIterations is the number of mutated inputs per seed; kept small enough for every test run
+const fuzzIterations = 2000
+
+// fuzzTokens are inserted to hit the syntax of JSON, base64 and URL-encoded input
+const fuzzTokens = "{}[]\":,0AZ=%"
+
+// mutate returns a copy of data with a few random bit flips, truncations, insertions or duplications
+func mutate(r *rand.Rand, data []byte) []byte {
+	out := append([]byte{}, data...)
+	for n := r.Intn(4) + 1; n > 0; n-- {
+		switch r.Intn(5) {
+		case 0:
+			if len(out) > 0 {
+				out[r.Intn(len(out))] ^= byte(1 << uint(r.Intn(8)))
+			}
+		case 1:
+			if len(out) > 0 {
+				out = out[:r.Intn(len(out))]
+			}
+		case 2:
+			i := r.Intn(len(out) + 1)
+			out = append(out[:i], append([]byte{byte(r.Intn(256))}, out[i:]...)...)
+		case 3:
+			if len(out) > 0 {
+				out[r.Intn(len(out))] = fuzzTokens[r.Intn(len(fuzzTokens))]
+			}
+		case 4:
+			out = append(out, out...)
+		}
+	}
+	return out
+}
+
+// fuzz feeds mutations of the seeds to parse and fails on panics
+func fuzz(t *testing.T, seeds [][]byte, parse func(data []byte)) {
+	r := rand.New(rand.NewSource(1))
+	for _, seed := range seeds {
+		for i := 0; i < fuzzIterations; i++ {
+			data := mutate(r, seed)
+			func() {
+				defer func() {
+					if p := recover(); p != nil {
+						t.Fatalf("Parser panics on input %s: %v", hex.EncodeToString(data), p)
+					}
+				}()
+				parse(data)
+			}()
+		}
+	}
+}
+
+func TestFuzzQuote(t *testing.T) {
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	seeds := [][]byte{quoteAsBytes, quoteAsBytes[:QuoteBodySize], {}}
+
+	fuzz(t, seeds, func(data []byte) {
+		ParseQuote(data)
+		QuoteFromBytes(data)
+		QuoteFromBase64(base64.StdEncoding.EncodeToString(data))
+		MatchQuoteBody(quoteAsBytes, base64.StdEncoding.EncodeToString(data))
+		MatchQuoteBody(data, base64.StdEncoding.EncodeToString(quoteAsBytes[:QuoteBodySize]))
+	})
+}
+
+func TestFuzzReport(t *testing.T) {
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	bodyData, _ := json.Marshal(&IASReportBody{
+		ID:                    "report id",
+		IsvEnclaveQuoteStatus: "GROUP_OUT_OF_DATE",
+		IsvEnclaveQuoteBody:   base64.StdEncoding.EncodeToString(quoteAsBytes[:QuoteBodySize]),
+		Timestamp:             "2019-01-01T12:00:00.123456",
+		AdvisoryIDs:           []string{"INTEL-SA-00233"},
+	})
+	certChain := url.QueryEscape("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+	reportData, _ := json.Marshal(&IASAttestationReport{
+		IASReportSignature:          base64.StdEncoding.EncodeToString([]byte("signature")),
+		IASReportSigningCertificate: certChain,
+		IASReportBody:               bodyData,
+	})
+	pkBytes, _ := base64.StdEncoding.DecodeString(enclavePK)
+	verifier := &VerifierImpl{}
+
+	fuzz(t, [][]byte{bodyData, reportData, []byte(certChain)}, func(data []byte) {
+		ParseReportBody(data)
+		report, _ := ParseAttestationReport(data)
+		for _, report := range []IASAttestationReport{
+			report,
+			{IASReportBody: data},
+			{IASReportSigningCertificate: string(data), IASReportBody: bodyData},
+		} {
+			verifier.VerifyAttestionReport(nil, report)
+			verifier.CheckMrEnclave(base64.StdEncoding.EncodeToString(data), report)
+			verifier.CheckEnclavePkHash(pkBytes, report)
+			verifier.CheckReportData(pkBytes, &ReportDataBinding{Nonce: data}, report)
+			ReportContainsQuote(report, quoteAsBytes)
+		}
+	})
+}
+
+func TestParsersRejectOversizedInput(t *testing.T) {
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	large := make([]byte, MaxQuoteSize+1)
+	copy(large, quoteAsBytes)
+
+	if _, err := ParseQuote(large); err != ErrQuoteTooLarge {
+		t.Fatalf("Expected %s but got %v", ErrQuoteTooLarge, err)
+	}
+	// base64 encodes 3 bytes at a time
+	large = append(large, 0, 0)
+	if _, err := QuoteFromBase64(base64.StdEncoding.EncodeToString(large)); err != ErrQuoteTooLarge {
+		t.Fatalf("Expected %s but got %v", ErrQuoteTooLarge, err)
+	}
+	largeBody := make([]byte, MaxReportBodySize+1)
+	if _, err := ParseReportBody(largeBody); err != ErrReportBodyTooLarge {
+		t.Fatalf("Expected %s but got %v", ErrReportBodyTooLarge, err)
+	}
+	if _, err := QuoteFromAttestionReport(IASAttestationReport{IASReportBody: largeBody}); err != ErrReportBodyTooLarge {
+		t.Fatalf("Expected %s but got %v", ErrReportBodyTooLarge, err)
+	}
+	if _, err := ParseAttestationReport(make([]byte, MaxAttestationReportSize+1)); err == nil {
+		t.Fatalf("Oversized attestation report should be rejected")
+	}
+}
@@ -18,16 +18,16 @@ package attestation
 
 import (
 	"bytes"
+	"compress/gzip"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
-	"errors"
 	"fmt"
-	"io/ioutil"
+	"mime"
 	"net/http"
-	"strings"
+	"os"
 )
 
 // intel verification key
@@ -44,6 +44,9 @@ tQIDAQAB
 
 const iasURL = "https://test-as.sgx.trustedservices.intel.com:443/attestation/sgx/v2/report"
 
+// MaxResponseBodySize limits the (decompressed) size of IAS responses
+const MaxResponseBodySize = 64 * 1024
+
 // IASReportBody received from IAS (Intel attestation service)
 type IASReportBody struct {
 	ID                    string `json:"id"`
@@ -56,6 +59,9 @@ type IASReportBody struct {
 	Nonce                 string `json:"nonce,omitempty"`
 	EpidPseudonym         string `json:"epidPseudonym,omitempty"`
 	Timestamp             string `json:"timestamp"`
+	// advisories affecting the platform; returned by IAS API v4
+	AdvisoryURL string   `json:"advisoryURL,omitempty"`
+	AdvisoryIDs []string `json:"advisoryIDs,omitempty"`
 }
 
 // IASAttestationReport received from IAS (Intel attestation service)
@@ -75,26 +81,52 @@ type IntelAttestationService interface {
 
 type intelAttestationServiceImpl struct {
 	url string
+	// captureFile is set if debug capture is enabled (see CaptureFileEnv)
+	captureFile string
+	transports  *transportPool
+	// transportErr is set if the transport configuration in the environment is invalid
+	transportErr error
 }
 
 // NewIAS is a great help to build an IntelAttestationService object
 func NewIAS() IntelAttestationService {
-	return &intelAttestationServiceImpl{url: iasURL}
+	config, err := TransportConfigFromEnv()
+	return &intelAttestationServiceImpl{
+		url:          iasURL,
+		captureFile:  os.Getenv(CaptureFileEnv),
+		transports:   newTransportPool(config),
+		transportErr: err,
+	}
+}
+
+// client returns an HTTPS client authenticating with cert. Clients with the same cert share connections.
+func (ias *intelAttestationServiceImpl) client(cert tls.Certificate) (*http.Client, error) {
+	if ias.transportErr != nil {
+		return nil, ias.transportErr
+	}
+	pooled, err := ias.transports.get(cert)
+	if err != nil {
+		return nil, err
+	}
+	var transport http.RoundTripper = pooled
+	if ias.captureFile != "" {
+		transport = newCaptureTransport(transport, ias.captureFile)
+	}
+	return &http.Client{Transport: transport}, nil
 }
 
 // RequestAttestationReport sends a quote to Intel for verification and in return receives an IASAttestationReport
 // Calling Intel qualifies ercc as a system chaincode since in the future chaincodes might be restricted and can not make call outside their docker container
 func (ias *intelAttestationServiceImpl) RequestAttestationReport(cert tls.Certificate, quoteAsBytes []byte) (IASAttestationReport, error) {
+	// fail early on quotes IAS can not verify
+	if _, err := ParseQuote(quoteAsBytes); err != nil {
+		return IASAttestationReport{}, fmt.Errorf("Invalid quote: %s", err)
+	}
 
-	// Setup HTTPS client
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		// RootCAs:            caCertPool,
-		InsecureSkipVerify: true,
+	client, err := ias.client(cert)
+	if err != nil {
+		return IASAttestationReport{}, fmt.Errorf("IAS connection error: %s", err)
 	}
-	tlsConfig.BuildNameToCertificate()
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
-	client := &http.Client{Transport: transport}
 
 	// transform quote bytes to base64 and build request body
 	quoteAsBase64 := base64.StdEncoding.EncodeToString(quoteAsBytes)
@@ -106,6 +138,8 @@ func (ias *intelAttestationServiceImpl) RequestAttestationReport(cert tls.Certif
 		return IASAttestationReport{}, fmt.Errorf("IAS connection error: %s", err)
 	}
 	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Accept-Encoding", "gzip")
 
 	// submit quote for verification
 	resp, err := client.Do(req)
@@ -119,17 +153,19 @@ func (ias *intelAttestationServiceImpl) RequestAttestationReport(cert tls.Certif
 		return IASAttestationReport{}, fmt.Errorf("IAS returned error: Code %s", resp.Status)
 	}
 
-	bodyData, err := ioutil.ReadAll(resp.Body)
+	bodyData, err := readResponseBody(resp)
 	if err != nil {
 		return IASAttestationReport{}, fmt.Errorf("Can not read response body: %s", err)
 	}
 
-	reportBody := IASReportBody{}
-	json.Unmarshal(bodyData, &reportBody)
+	reportBody, err := ParseReportBody(bodyData)
+	if err != nil {
+		return IASAttestationReport{}, err
+	}
 
 	// check response contains submitted quote
-	if !strings.HasPrefix(quoteAsBase64, reportBody.IsvEnclaveQuoteBody) {
-		return IASAttestationReport{}, errors.New("Report does not contain submitted quote")
+	if err := MatchQuoteBody(quoteAsBytes, reportBody.IsvEnclaveQuoteBody); err != nil {
+		return IASAttestationReport{}, fmt.Errorf("Report does not contain submitted quote: %s", err)
 	}
 
 	report := IASAttestationReport{
@@ -141,6 +177,37 @@ func (ias *intelAttestationServiceImpl) RequestAttestationReport(cert tls.Certif
 	return report, nil
 }
 
+// readResponseBody checks the content type of an IAS response and returns its body, decompressed if needed.
+// Reading is aborted as soon as the body exceeds MaxResponseBodySize.
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type: %s", err)
+	}
+	if mediaType != "application/json" {
+		return nil, fmt.Errorf("unexpected content type %s", mediaType)
+	}
+
+	// we request gzip explicitly, thus, the transport does not decompress transparently
+	switch encoding := resp.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+		return readLimited(resp.Body, resp.ContentLength, MaxResponseBodySize)
+	case "gzip":
+		// both the compressed and the decompressed size are limited, so decompression bombs are aborted early
+		if resp.ContentLength > MaxResponseBodySize {
+			return nil, &ResponseTooLargeError{Limit: MaxResponseBodySize}
+		}
+		gzipReader, err := gzip.NewReader(newLimitedReader(resp.Body, MaxResponseBodySize))
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		return readLimited(gzipReader, -1, MaxResponseBodySize)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+	}
+}
+
 func (ias *intelAttestationServiceImpl) GetIntelVerificationKey() (interface{}, error) {
 	return PublicKeyFromPem([]byte(IntelPubPEM))
 }
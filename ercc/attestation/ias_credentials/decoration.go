@@ -17,17 +17,23 @@
 package main
 
 import (
-	"encoding/pem"
 	"fmt"
-	"io/ioutil"
+	"path/filepath"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
 	"github.com/hyperledger/fabric/core/config"
 	"github.com/hyperledger/fabric/core/handlers/decoration"
 	"github.com/hyperledger/fabric/peer/common"
+	"github.com/hyperledger/fabric/protos/msp"
 	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+	"github.com/spf13/viper"
 )
 
-// NewDecorator creates a new decorator
+// NewDecorator creates a new decorator. The credential files are watched for changes, so the IAS credentials
+// can be rotated without restarting the peer. Orgs with their own IAS subscription are configured as
+// sgx.ias.accounts; the sgx.ias.* files are used for all other orgs.
 func NewDecorator() decoration.Decorator {
 	common.InitConfig("core")
 
@@ -38,69 +44,78 @@ func NewDecorator() decoration.Decorator {
 
 	fmt.Printf("cert: %s\n key: %s\n spid: %s\n", certFile, keyFile, spidFile)
 
-	certPEM, err := readPemFromFile(certFile)
-	if err != nil {
-		panic("not read Cert from file: " + err.Error())
+	var accounts []attestation.IASAccount
+	if err := viper.UnmarshalKey("sgx.ias.accounts", &accounts); err != nil {
+		panic("Can not read IAS accounts: " + err.Error())
+	}
+	configDir := filepath.Dir(viper.ConfigFileUsed())
+	for i := range accounts {
+		accounts[i].CertFile = config.TranslatePath(configDir, accounts[i].CertFile)
+		accounts[i].KeyFile = config.TranslatePath(configDir, accounts[i].KeyFile)
+		if accounts[i].SPIDFile != "" {
+			accounts[i].SPIDFile = config.TranslatePath(configDir, accounts[i].SPIDFile)
+		}
+		fmt.Printf("IAS account of %s: cert: %s\n", accounts[i].MspID, accounts[i].CertFile)
 	}
 
-	keyPEM, err := readPemFromFile(keyFile)
+	defaultCredentials, err := attestation.NewIASCredentialProvider(certFile, keyFile, spidFile)
 	if err != nil {
-		panic("not read Cert from file: " + err.Error())
+		// the default credentials are optional if every org has its own account
+		if len(accounts) == 0 {
+			panic("Can not read IAS credentials: " + err.Error())
+		}
+		fmt.Printf("No default IAS credentials: %s\n", err)
+		defaultCredentials = nil
 	}
 
-	spid, err := readSPIDFromFile(spidFile)
+	credentials, err := attestation.NewIASCredentialSet(defaultCredentials, accounts)
 	if err != nil {
-		panic("Can not read SPID from file: " + err.Error())
+		panic("Can not read IAS credentials: " + err.Error())
 	}
 
-	return &decorator{
-		certPEM: certPEM,
-		keyPEM:  keyPEM,
-		spid:    spid,
-	}
+	return &decorator{credentials: credentials}
 }
 
 type decorator struct {
-	certPEM []byte
-	keyPEM  []byte
-	spid    []byte
+	credentials *attestation.IASCredentialSet
 }
 
-// Decorate decorates a chaincode input by changing it
+// Decorate decorates a chaincode input by changing it. The IAS credentials are those of the org that created
+// the proposal.
 func (d *decorator) Decorate(proposal *peer.Proposal, input *peer.ChaincodeInput) *peer.ChaincodeInput {
-	input.Decorations["SPID"] = d.spid
-	input.Decorations["certPEM"] = d.certPEM
-	input.Decorations["keyPEM"] = d.keyPEM
-	return input
-}
-
-func readSPIDFromFile(spidFile string) ([]byte, error) {
-	bytes, err := readFile(spidFile)
+	mspID, err := creatorMSPID(proposal)
 	if err != nil {
-		return nil, err
+		fmt.Printf("Can not get creator of proposal: %s\n", err)
+		return input
 	}
-	return bytes, nil
-}
-
-func readPemFromFile(file string) ([]byte, error) {
-	bytes, err := readFile(file)
+	provider, err := d.credentials.ForMSP(mspID)
 	if err != nil {
-		return nil, err
+		// without credentials, ercc fails to contact IAS
+		return input
 	}
 
-	b, _ := pem.Decode(bytes)
-	if b == nil { // TODO: also check that the type is what we expect (cert vs key..)
-		return nil, fmt.Errorf("No pem content for file %s", file)
-	}
-	return bytes, nil
+	credentials := provider.Get()
+	input.Decorations["SPID"] = credentials.SPID
+	input.Decorations["certPEM"] = credentials.CertPEM
+	input.Decorations["keyPEM"] = credentials.KeyPEM
+	return input
 }
 
-func readFile(file string) ([]byte, error) {
-	fileCont, err := ioutil.ReadFile(file)
+// creatorMSPID returns the msp id of the identity that created the proposal
+func creatorMSPID(proposal *peer.Proposal) (string, error) {
+	header, err := utils.GetHeader(proposal.Header)
 	if err != nil {
-		return nil, fmt.Errorf("Could not read file %s, err %s", file, err)
+		return "", err
+	}
+	signatureHeader, err := utils.GetSignatureHeader(header.SignatureHeader)
+	if err != nil {
+		return "", err
+	}
+	creator := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(signatureHeader.Creator, creator); err != nil {
+		return "", err
 	}
-	return fileCont, nil
+	return creator.Mspid, nil
 }
 
 func main() {
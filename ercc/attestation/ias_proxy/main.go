@@ -0,0 +1,88 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/iasproxy"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:7070", "address to serve the proxy on")
+	certFile := flag.String("ias-cert", "", "IAS client certificate file of the consortium")
+	keyFile := flag.String("ias-key", "", "IAS client key file of the consortium")
+	reportTTL := flag.Duration("report-ttl", 0, "how long attestation reports are cached, e.g., 10m")
+	sigRLTTL := flag.Duration("sigrl-ttl", 0, "how long SigRLs are cached, e.g., 1h")
+	rate := flag.Float64("rate", 0, "maximum number of IAS requests per second; unlimited if zero")
+	burst := flag.Int("burst", 1, "number of IAS requests that may be sent at once")
+	tlsCertFile := flag.String("tls-cert", "", "TLS certificate file of the proxy, issued by the org's TLS CA")
+	tlsKeyFile := flag.String("tls-key", "", "TLS key file of the proxy")
+	tlsCAFiles := flag.String("tls-ca", "", "comma separated TLS CA certificate files of the peers, e.g., from msp/tlscacerts")
+	flag.Parse()
+
+	mTLSCredentials, err := attestation.NewMTLSCredentials(*tlsCertFile, *tlsKeyFile, strings.Split(*tlsCAFiles, ","))
+	if err != nil {
+		log.Fatalf("Can not load TLS credentials: %s", err)
+	}
+
+	credentials, err := attestation.NewIASCredentialProvider(*certFile, *keyFile, "")
+	if err != nil {
+		log.Fatalf("Can not load IAS client cert: %s", err)
+	}
+	// credentials are re-read when the files change or on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := credentials.Reload(); err != nil {
+				log.Printf("Can not reload IAS client cert: %s", err)
+			} else {
+				log.Printf("IAS client cert reloaded")
+			}
+			if err := mTLSCredentials.Reload(); err != nil {
+				log.Printf("Can not reload TLS credentials: %s", err)
+			} else {
+				log.Printf("TLS credentials reloaded")
+			}
+		}
+	}()
+
+	proxy := iasproxy.NewProxy(attestation.NewIAS(), credentials, iasproxy.Config{
+		ReportTTL:         *reportTTL,
+		SigRLTTL:          *sigRLTTL,
+		RequestsPerSecond: *rate,
+		Burst:             *burst,
+	})
+
+	server := iasproxy.NewServer(mTLSCredentials)
+	iasproxy.Register(server, proxy)
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Can not listen: %s", err)
+	}
+	log.Printf("IAS proxy listening on %s", *addr)
+	log.Fatal(server.Serve(listener))
+}
@@ -17,7 +17,11 @@
 package attestation
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/base64"
+	"io/ioutil"
+	"net/http"
 	"testing"
 )
 
@@ -28,7 +32,10 @@ const quote = `AgAAAG4NAAAEAAQAAAAAACVC+Q1jMSwdovbiGHbw44nMDb+CvAvF0FJF/38NWjOqA
 func TestRequestAttestationReport(t *testing.T) {
 
 	ias := NewIAS()
-	credis, _ := NewIASCredentialProviderFromConfig()
+	credis, err := NewIASCredentialProviderFromConfig()
+	if err != nil {
+		t.Skipf("IAS credentials not configured: %s", err)
+	}
 	verifier := VerifierImpl{}
 
 	quoteAsBytes, err := base64.StdEncoding.DecodeString(quote)
@@ -68,3 +75,39 @@ func TestRequestAttestationReport(t *testing.T) {
 	}
 
 }
+
+func TestReadResponseBody(t *testing.T) {
+	body := []byte(`{"isvEnclaveQuoteStatus":"OK"}`)
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write(body)
+	w.Close()
+
+	newResponse := func(contentType, contentEncoding string, body []byte) *http.Response {
+		header := http.Header{}
+		header.Set("Content-Type", contentType)
+		if contentEncoding != "" {
+			header.Set("Content-Encoding", contentEncoding)
+		}
+		return &http.Response{Header: header, Body: ioutil.NopCloser(bytes.NewReader(body))}
+	}
+
+	data, err := readResponseBody(newResponse("application/json", "", body))
+	if err != nil || !bytes.Equal(data, body) {
+		t.Fatalf("Plain response not read: %s", err)
+	}
+
+	data, err = readResponseBody(newResponse("application/json; charset=utf-8", "gzip", compressed.Bytes()))
+	if err != nil || !bytes.Equal(data, body) {
+		t.Fatalf("Gzip response not read: %s", err)
+	}
+
+	if _, err := readResponseBody(newResponse("text/html", "", body)); err == nil {
+		t.Fatalf("Unexpected content type should be rejected")
+	}
+
+	if _, err := readResponseBody(newResponse("application/json", "", make([]byte, MaxResponseBodySize+1))); err == nil {
+		t.Fatalf("Oversized response should be rejected")
+	}
+}
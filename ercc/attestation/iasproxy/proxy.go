@@ -0,0 +1,187 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Package iasproxy implements the ias-proxy sidecar and the client ercc and applications use to reach it.
+package iasproxy
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+// ErrRateLimited is returned if the proxy exceeds the configured IAS request rate
+var ErrRateLimited = errors.New("IAS request rate exceeded")
+
+// Config of the proxy
+type Config struct {
+	// ReportTTL is how long attestation reports are served from the cache; zero disables the cache
+	ReportTTL time.Duration
+	// SigRLTTL is how long SigRLs are served from the cache; zero disables the cache
+	SigRLTTL time.Duration
+	// RequestsPerSecond limits the requests sent to IAS; zero disables the limit
+	RequestsPerSecond float64
+	// Burst is the number of requests that may be sent to IAS at once; at least one
+	Burst int
+}
+
+type cachedReport struct {
+	report  attestation.IASAttestationReport
+	expires time.Time
+}
+
+type cachedSigRL struct {
+	sigRL   []byte
+	expires time.Time
+}
+
+// Proxy sends quotes to IAS on behalf of peers using the consortium credentials. Reports and SigRLs are cached,
+// so all peers endorsing the same registration obtain the same report and IAS is contacted only once.
+type Proxy struct {
+	ias    attestation.IntelAttestationService
+	certs  attestation.ClientCertProvider
+	config Config
+
+	mutex   sync.Mutex
+	reports map[[32]byte]*cachedReport
+	sigRLs  map[[4]byte]*cachedSigRL
+	tokens  float64
+	last    time.Time
+	now     func() time.Time
+}
+
+// NewProxy creates a proxy for ias authenticating with the current cert of certs. SigRLs are served if ias
+// implements attestation.SigRLService.
+func NewProxy(ias attestation.IntelAttestationService, certs attestation.ClientCertProvider, config Config) *Proxy {
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+	return &Proxy{
+		ias:     ias,
+		certs:   certs,
+		config:  config,
+		reports: make(map[[32]byte]*cachedReport),
+		sigRLs:  make(map[[4]byte]*cachedSigRL),
+		tokens:  float64(config.Burst),
+		now:     time.Now,
+	}
+}
+
+// RequestAttestationReport returns the attestation report for a quote
+func (p *Proxy) RequestAttestationReport(quoteAsBytes []byte) (attestation.IASAttestationReport, error) {
+	key := sha256.Sum256(quoteAsBytes)
+	p.mutex.Lock()
+	now := p.now()
+	if cached, ok := p.reports[key]; ok && now.Before(cached.expires) {
+		p.mutex.Unlock()
+		return cached.report, nil
+	}
+	if !p.take(now) {
+		p.mutex.Unlock()
+		return attestation.IASAttestationReport{}, ErrRateLimited
+	}
+	p.mutex.Unlock()
+
+	cert, err := p.certs.GetIASClientCert()
+	if err != nil {
+		return attestation.IASAttestationReport{}, err
+	}
+	report, err := p.ias.RequestAttestationReport(cert, quoteAsBytes)
+	if err != nil {
+		return attestation.IASAttestationReport{}, err
+	}
+
+	if p.config.ReportTTL > 0 {
+		p.mutex.Lock()
+		p.purge(now)
+		p.reports[key] = &cachedReport{report: report, expires: now.Add(p.config.ReportTTL)}
+		p.mutex.Unlock()
+	}
+	return report, nil
+}
+
+// RequestSigRL returns the SigRL of the EPID group gid
+func (p *Proxy) RequestSigRL(gid [4]byte) ([]byte, error) {
+	sigRLService, ok := p.ias.(attestation.SigRLService)
+	if !ok {
+		return nil, errors.New("SigRL retrieval not supported")
+	}
+
+	p.mutex.Lock()
+	now := p.now()
+	if cached, ok := p.sigRLs[gid]; ok && now.Before(cached.expires) {
+		p.mutex.Unlock()
+		return cached.sigRL, nil
+	}
+	if !p.take(now) {
+		p.mutex.Unlock()
+		return nil, ErrRateLimited
+	}
+	p.mutex.Unlock()
+
+	cert, err := p.certs.GetIASClientCert()
+	if err != nil {
+		return nil, err
+	}
+	sigRL, err := sigRLService.RequestSigRL(cert, gid)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.config.SigRLTTL > 0 {
+		p.mutex.Lock()
+		p.purge(now)
+		p.sigRLs[gid] = &cachedSigRL{sigRL: sigRL, expires: now.Add(p.config.SigRLTTL)}
+		p.mutex.Unlock()
+	}
+	return sigRL, nil
+}
+
+// take consumes a token of the rate limiter (token bucket); must be called with mutex held
+func (p *Proxy) take(now time.Time) bool {
+	if p.config.RequestsPerSecond <= 0 {
+		return true
+	}
+	if !p.last.IsZero() {
+		p.tokens += now.Sub(p.last).Seconds() * p.config.RequestsPerSecond
+		if p.tokens > float64(p.config.Burst) {
+			p.tokens = float64(p.config.Burst)
+		}
+	}
+	p.last = now
+	if p.tokens < 1 {
+		return false
+	}
+	p.tokens--
+	return true
+}
+
+// purge removes expired cache entries; must be called with mutex held
+func (p *Proxy) purge(now time.Time) {
+	for key, cached := range p.reports {
+		if !now.Before(cached.expires) {
+			delete(p.reports, key)
+		}
+	}
+	for gid, cached := range p.sigRLs {
+		if !now.Before(cached.expires) {
+			delete(p.sigRLs, gid)
+		}
+	}
+}
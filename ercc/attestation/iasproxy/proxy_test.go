@@ -0,0 +1,98 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package iasproxy
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+)
+
+// countingIAS counts the requests reaching IAS
+type countingIAS struct {
+	*mock.SigningIAS
+	reports int
+	sigRLs  int
+}
+
+func (ias *countingIAS) RequestAttestationReport(cert tls.Certificate, quoteAsBytes []byte) (attestation.IASAttestationReport, error) {
+	ias.reports++
+	return ias.SigningIAS.RequestAttestationReport(cert, quoteAsBytes)
+}
+
+func (ias *countingIAS) RequestSigRL(cert tls.Certificate, gid [4]byte) ([]byte, error) {
+	ias.sigRLs++
+	return gid[:], nil
+}
+
+// noCert is used as the IAS client cert by the tests
+type noCert struct{}
+
+func (noCert) GetIASClientCert() (tls.Certificate, error) {
+	return tls.Certificate{}, nil
+}
+
+func TestProxy(t *testing.T) {
+	signingIAS, err := mock.NewSigningIAS()
+	if err != nil {
+		t.Fatalf("Can not create IAS: %s", err)
+	}
+	ias := &countingIAS{SigningIAS: signingIAS}
+	proxy := NewProxy(ias, noCert{}, Config{ReportTTL: time.Minute, SigRLTTL: time.Hour, RequestsPerSecond: 1, Burst: 2})
+	now := time.Now()
+	proxy.now = func() time.Time { return now }
+
+	_, pkBytes, _ := mock.EnclaveKey()
+	quote, _ := mock.NewQuote(pkBytes, nil, mock.MrEnclave, 1)
+	report, err := proxy.RequestAttestationReport(quote)
+	if err != nil {
+		t.Fatalf("Can not get report: %s", err)
+	}
+	verificationKey, _ := signingIAS.GetIntelVerificationKey()
+	if ok, err := (&attestation.VerifierImpl{}).VerifyAttestionReport(verificationKey, report); !ok || err != nil {
+		t.Fatalf("Report should be valid: %s", err)
+	}
+
+	// peers endorsing the same registration obtain the same report
+	cached, err := proxy.RequestAttestationReport(quote)
+	if err != nil || cached.IASReportSignature != report.IASReportSignature || ias.reports != 1 {
+		t.Fatalf("Report should be served from the cache")
+	}
+
+	sigRL, err := proxy.RequestSigRL([4]byte{1, 2, 3, 4})
+	if err != nil || len(sigRL) != 4 || ias.sigRLs != 1 {
+		t.Fatalf("Can not get SigRL: %s", err)
+	}
+
+	// the burst is used up
+	otherQuote, _ := mock.NewQuote(pkBytes, nil, [32]byte{}, 1)
+	if _, err := proxy.RequestAttestationReport(otherQuote); err != ErrRateLimited {
+		t.Fatalf("Request should be rate limited")
+	}
+	if _, err := proxy.RequestSigRL([4]byte{1, 2, 3, 4}); err != nil {
+		t.Fatalf("Cached SigRL should not be rate limited: %s", err)
+	}
+
+	// the cached report expired and tokens were refilled
+	now = now.Add(2 * time.Minute)
+	if _, err := proxy.RequestAttestationReport(quote); err != nil || ias.reports != 2 {
+		t.Fatalf("Report should be requested again: %s", err)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package iasproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// the proxy messages are plain go structs sent as json, thus, no generated protobuf code is needed
+const serviceName = "iasproxy.IASProxy"
+
+// requestTimeout bounds calls to the proxy; IAS itself may take a few seconds
+const requestTimeout = 30 * time.Second
+
+// maxMessageSize bounds proxy messages; the largest are requests carrying a quote and responses carrying an
+// attestation report, both encoded as json, i.e., with base64 encoded bytes
+const maxMessageSize = 2 * (attestation.MaxQuoteSize + attestation.MaxAttestationReportSize)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// ReportRequest asks for the attestation report of a quote
+type ReportRequest struct {
+	Quote []byte `json:"quote"`
+}
+
+// SigRLRequest asks for the SigRL of an EPID group
+type SigRLRequest struct {
+	GID [4]byte `json:"gid"`
+}
+
+// SigRLResponse carries a SigRL; it is empty if no signatures of the group are revoked
+type SigRLResponse struct {
+	SigRL []byte `json:"sigRL"`
+}
+
+// proxyServer is the interface served over gRPC, implemented by Proxy
+type proxyServer interface {
+	RequestAttestationReport(quoteAsBytes []byte) (attestation.IASAttestationReport, error)
+	RequestSigRL(gid [4]byte) ([]byte, error)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*proxyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "RequestAttestationReport", Handler: reportHandler},
+		{MethodName: "RequestSigRL", Handler: sigRLHandler},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// NewServer creates a gRPC server that only accepts clients authenticated by mTLSCredentials, i.e., the peers of
+// the org
+func NewServer(mTLSCredentials *attestation.MTLSCredentials) *grpc.Server {
+	return grpc.NewServer(grpc.Creds(credentials.NewTLS(mTLSCredentials.ServerConfig())), grpc.MaxRecvMsgSize(maxMessageSize))
+}
+
+// Register serves the proxy on a gRPC server
+func Register(server *grpc.Server, proxy *Proxy) {
+	server.RegisterService(&serviceDesc, proxy)
+}
+
+func reportHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	request := &ReportRequest{}
+	if err := dec(request); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		report, err := srv.(proxyServer).RequestAttestationReport(req.(*ReportRequest).Quote)
+		if err != nil {
+			return nil, statusOf(err)
+		}
+		return &report, nil
+	}
+	if interceptor == nil {
+		return handler(ctx, request)
+	}
+	return interceptor(ctx, request, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/RequestAttestationReport"}, handler)
+}
+
+func sigRLHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	request := &SigRLRequest{}
+	if err := dec(request); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		sigRL, err := srv.(proxyServer).RequestSigRL(req.(*SigRLRequest).GID)
+		if err != nil {
+			return nil, statusOf(err)
+		}
+		return &SigRLResponse{SigRL: sigRL}, nil
+	}
+	if interceptor == nil {
+		return handler(ctx, request)
+	}
+	return interceptor(ctx, request, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/RequestSigRL"}, handler)
+}
+
+func statusOf(err error) error {
+	if err == ErrRateLimited {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// Client talks to an ias-proxy. It implements attestation.IntelAttestationService and attestation.SigRLService;
+// the client certs passed are ignored as the proxy holds the IAS credentials.
+type Client struct {
+	conn            *grpc.ClientConn
+	verificationKey interface{}
+}
+
+// NewClient creates a client for the proxy at address. The proxy runs as sidecar of the peer; its answers need
+// not be trusted as attestation reports are signed by IAS and verified by the caller with verificationKey.
+// The key is never obtained from the proxy; if nil, the Intel verification key is used. Peer and proxy
+// authenticate each other with mTLSCredentials; the proxy must present a certificate for the host of address.
+func NewClient(address string, mTLSCredentials *attestation.MTLSCredentials, verificationKey interface{}) (*Client, error) {
+	if verificationKey == nil {
+		var err error
+		if verificationKey, err = attestation.PublicKeyFromPem([]byte(attestation.IntelPubPEM)); err != nil {
+			return nil, err
+		}
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	tlsCredentials := credentials.NewTLS(mTLSCredentials.ClientConfig(host))
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(tlsCredentials),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodec{}.Name()), grpc.MaxCallRecvMsgSize(maxMessageSize)))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, verificationKey: verificationKey}, nil
+}
+
+func (c *Client) invoke(method string, request, response interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return c.conn.Invoke(ctx, "/"+serviceName+"/"+method, request, response)
+}
+
+// RequestAttestationReport obtains the attestation report for a quote from the proxy
+func (c *Client) RequestAttestationReport(cert tls.Certificate, quoteAsBytes []byte) (attestation.IASAttestationReport, error) {
+	report := attestation.IASAttestationReport{}
+	if err := c.invoke("RequestAttestationReport", &ReportRequest{Quote: quoteAsBytes}, &report); err != nil {
+		return attestation.IASAttestationReport{}, err
+	}
+	return report, nil
+}
+
+// RequestSigRL obtains the SigRL of an EPID group from the proxy
+func (c *Client) RequestSigRL(cert tls.Certificate, gid [4]byte) ([]byte, error) {
+	response := &SigRLResponse{}
+	if err := c.invoke("RequestSigRL", &SigRLRequest{GID: gid}, response); err != nil {
+		return nil, err
+	}
+	return response.SigRL, nil
+}
+
+// GetIntelVerificationKey returns the key verifying attestation reports
+func (c *Client) GetIntelVerificationKey() (interface{}, error) {
+	return c.verificationKey, nil
+}
+
+// Close closes the connection to the proxy
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
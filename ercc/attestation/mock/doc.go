@@ -0,0 +1,24 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+// Package mock provides test doubles for the enclave registry and the attestation stack so that chaincode
+// developers can unit-test against fabric secure chaincode without SGX hardware or access to IAS:
+//
+// - MockIAS returns unsigned attestation reports and is used together with MockVerifier
+// - SigningIAS returns signed attestation reports that pass attestation.VerifierImpl
+// - NewQuote creates quotes whose REPORT_DATA binds a given enclave key, e.g., EnclaveKey
+//
+// A mock of the enclave registry stub used by ecc is provided by package ecc/ercc (MockEnclaveRegistryStub).
+package mock
@@ -17,19 +17,54 @@
 package mock
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"time"
 
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
 )
 
+// quoteBodySize is the size of a quote without signature
+const quoteBodySize = 432
+
+// linkableSignType is the quote sign type of linkable quotes
+const linkableSignType = 1
+
 type MockIAS struct {
 }
 
 func (ias *MockIAS) RequestAttestationReport(cert tls.Certificate, quoteAsBytes []byte) (attestation.IASAttestationReport, error) {
+	// as IAS, include the quote without signature in the report body
+	quoteBody := quoteAsBytes
+	if len(quoteBody) > quoteBodySize {
+		quoteBody = quoteBody[:quoteBodySize]
+	}
+
+	body := &attestation.IASReportBody{
+		ID:                    "some report id",
+		IsvEnclaveQuoteStatus: "OK",
+		IsvEnclaveQuoteBody:   base64.StdEncoding.EncodeToString(quoteBody),
+		Timestamp:             time.Now().UTC().Format(iasTimestampLayout),
+	}
+
+	// as IAS, return a pseudonym for linkable quotes; we derive it from the EPID group id
+	if len(quoteAsBytes) >= 8 && binary.LittleEndian.Uint16(quoteAsBytes[2:4]) == linkableSignType {
+		pseudonym := sha256.Sum256(quoteAsBytes[4:8])
+		body.EpidPseudonym = base64.StdEncoding.EncodeToString(pseudonym[:])
+	}
+
+	reportBody, err := json.Marshal(body)
+	if err != nil {
+		return attestation.IASAttestationReport{}, err
+	}
+
 	report := attestation.IASAttestationReport{
 		IASReportSignature:          "some X-IASReport-Signature",
 		IASReportSigningCertificate: "some X-IASReport-Signing-Certificate",
-		IASReportBody:               []byte("Some report body"),
+		IASReportBody:               reportBody,
 	}
 
 	return report, nil
@@ -0,0 +1,59 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package mock
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+func TestSigningIAS(t *testing.T) {
+	ias, err := NewSigningIAS()
+	if err != nil {
+		t.Fatalf("Can not create IAS: %s", err)
+	}
+	_, pkBytes, err := EnclaveKey()
+	if err != nil {
+		t.Fatalf("Can not load enclave key: %s", err)
+	}
+	binding := &attestation.ReportDataBinding{Nonce: attestation.TxNonce("tx1"), ChannelID: "mychannel", ChaincodeID: "ecc"}
+	quote, err := NewQuote(pkBytes, binding, MrEnclave, 1)
+	if err != nil {
+		t.Fatalf("Can not create quote: %s", err)
+	}
+
+	report, err := ias.RequestAttestationReport(tls.Certificate{}, quote)
+	if err != nil {
+		t.Fatalf("Can not get attestation report: %s", err)
+	}
+	verificationPK, err := ias.GetIntelVerificationKey()
+	if err != nil {
+		t.Fatalf("Can not get verification key: %s", err)
+	}
+
+	verifier := &attestation.VerifierImpl{}
+	if ok, err := verifier.VerifyAttestionReport(verificationPK, report); !ok || err != nil {
+		t.Fatalf("Attestation report should be valid: %s", err)
+	}
+	if ok, err := verifier.CheckReportData(pkBytes, binding, report); !ok || err != nil {
+		t.Fatalf("Quote should bind enclave pk: %s", err)
+	}
+	if ok, _ := verifier.CheckReportData(pkBytes, nil, report); ok {
+		t.Fatalf("Quote should not match without binding")
+	}
+}
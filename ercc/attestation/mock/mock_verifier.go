@@ -32,3 +32,11 @@ func (v *MockVerifier) CheckMrEnclave(mrEnclaveBase64 string, report attestation
 func (v *MockVerifier) CheckEnclavePkHash(pkBytes []byte, report attestation.IASAttestationReport) (bool, error) {
 	return true, nil
 }
+
+func (v *MockVerifier) CheckReportData(pkBytes []byte, binding *attestation.ReportDataBinding, report attestation.IASAttestationReport) (bool, error) {
+	return true, nil
+}
+
+func (v *MockVerifier) CheckQuoteReportData(pkBytes []byte, binding *attestation.ReportDataBinding, quoteAsBytes []byte) (bool, error) {
+	return true, nil
+}
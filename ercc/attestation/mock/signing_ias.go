@@ -0,0 +1,168 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package mock
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+// iasTimestampLayout is the format of the timestamp in IAS report bodies
+const iasTimestampLayout = "2006-01-02T15:04:05.999999"
+
+// SigningIAS is an IAS double that signs attestation reports with its own report signing key. Reports pass
+// attestation.VerifierImpl when verified with the key returned by GetIntelVerificationKey.
+type SigningIAS struct {
+	// QuoteStatus is reported for all quotes; OK if empty
+	QuoteStatus string
+	// AdvisoryIDs are reported for all quotes
+	AdvisoryIDs []string
+
+	key       *rsa.PrivateKey
+	certChain string
+	caKey     *rsa.PrivateKey
+	caCert    *x509.Certificate
+	cert      *x509.Certificate
+}
+
+// NewSigningIAS creates a SigningIAS with a fresh report signing key and certificate chain
+func NewSigningIAS() (*SigningIAS, error) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(24 * time.Hour)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Mock IAS Root CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caCert, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Mock IAS Report Signing"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// as IAS, send signing certificate followed by the root ca certificate
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert})...)
+
+	parsedCACert, err := x509.ParseCertificate(caCert)
+	if err != nil {
+		return nil, err
+	}
+	parsedCert, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningIAS{
+		key:       key,
+		certChain: url.QueryEscape(string(chain)),
+		caKey:     caKey,
+		caCert:    parsedCACert,
+		cert:      parsedCert,
+	}, nil
+}
+
+// CRL returns a PEM-encoded CRL of the root CA. The report signing certificate is listed as revoked at revokedAt
+// unless revokedAt is zero.
+func (ias *SigningIAS) CRL(revokedAt time.Time) ([]byte, error) {
+	var revoked []pkix.RevokedCertificate
+	if !revokedAt.IsZero() {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: ias.cert.SerialNumber, RevocationTime: revokedAt})
+	}
+	now := time.Now()
+	crl, err := ias.caCert.CreateCRL(rand.Reader, ias.caKey, revoked, now, now.Add(24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl}), nil
+}
+
+// RequestAttestationReport returns a signed attestation report for the quote
+func (ias *SigningIAS) RequestAttestationReport(cert tls.Certificate, quoteAsBytes []byte) (attestation.IASAttestationReport, error) {
+	quoteBody := quoteAsBytes
+	if len(quoteBody) > quoteBodySize {
+		quoteBody = quoteBody[:quoteBodySize]
+	}
+
+	quoteStatus := ias.QuoteStatus
+	if quoteStatus == "" {
+		quoteStatus = "OK"
+	}
+
+	reportBody, err := json.Marshal(&attestation.IASReportBody{
+		ID:                    "some report id",
+		IsvEnclaveQuoteStatus: quoteStatus,
+		IsvEnclaveQuoteBody:   base64.StdEncoding.EncodeToString(quoteBody),
+		Timestamp:             time.Now().UTC().Format(iasTimestampLayout),
+		AdvisoryIDs:           ias.AdvisoryIDs,
+	})
+	if err != nil {
+		return attestation.IASAttestationReport{}, err
+	}
+
+	hashedBody := sha256.Sum256(reportBody)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, ias.key, crypto.SHA256, hashedBody[:])
+	if err != nil {
+		return attestation.IASAttestationReport{}, err
+	}
+
+	return attestation.IASAttestationReport{
+		IASReportSignature:          base64.StdEncoding.EncodeToString(signature),
+		IASReportSigningCertificate: ias.certChain,
+		IASReportBody:               reportBody,
+	}, nil
+}
+
+// GetIntelVerificationKey returns the public report signing key
+func (ias *SigningIAS) GetIntelVerificationKey() (interface{}, error) {
+	return &ias.key.PublicKey, nil
+}
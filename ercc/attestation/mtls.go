@@ -0,0 +1,188 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MTLSCredentials authenticate the peer and its sidecar services, such as the ias-proxy and the verification
+// service, to each other. Both sides present a TLS certificate issued by the MSP of their org, e.g., the peer's TLS
+// certificate, and accept only certificates issued by the given CAs, e.g., the tlscacerts of the MSP. Like IAS
+// credentials, the files are re-read when they change, so certificates and CAs can be rotated without restarts.
+type MTLSCredentials struct {
+	keyPair *IASCredentialProvider
+	caFiles []string
+
+	mutex      sync.Mutex
+	roots      *x509.CertPool
+	caVersions []string
+	lastCheck  time.Time
+	now        func() time.Time
+}
+
+// NewMTLSCredentials loads the certificate and key of this side and the CA certificates accepted from the other side
+func NewMTLSCredentials(certFile, keyFile string, caFiles []string) (*MTLSCredentials, error) {
+	if certFile == "" || keyFile == "" || len(caFiles) == 0 {
+		return nil, errors.New("Mutual TLS requires a certificate, a key and CA certificates")
+	}
+	for _, file := range caFiles {
+		if file == "" {
+			return nil, errors.New("Empty CA certificate file name")
+		}
+	}
+	keyPair, err := NewIASCredentialProvider(certFile, keyFile, "")
+	if err != nil {
+		return nil, err
+	}
+	c := &MTLSCredentials{keyPair: keyPair, caFiles: caFiles, now: time.Now}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewMTLSCredentialsFromPeerConfig loads the TLS certificate, key and root CA of the peer from the files named by
+// CORE_PEER_TLS_CERT_FILE, CORE_PEER_TLS_KEY_FILE and CORE_PEER_TLS_ROOTCERT_FILE, defaulting to the tls directory
+// in FABRIC_CFG_PATH.
+func NewMTLSCredentialsFromPeerConfig() (*MTLSCredentials, error) {
+	return NewMTLSCredentials(
+		configPath("CORE_PEER_TLS_CERT_FILE", "tls/server.crt"),
+		configPath("CORE_PEER_TLS_KEY_FILE", "tls/server.key"),
+		[]string{configPath("CORE_PEER_TLS_ROOTCERT_FILE", "tls/ca.crt")})
+}
+
+// Reload re-reads all files immediately. The previous credentials remain in use if the files are invalid.
+func (c *MTLSCredentials) Reload() error {
+	if err := c.keyPair.Reload(); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	versions, err := fileVersionsOf(c.caFiles)
+	if err != nil {
+		return err
+	}
+	return c.loadRoots(versions)
+}
+
+// Roots returns the current CA certificates
+func (c *MTLSCredentials) Roots() *x509.CertPool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now := c.now(); now.Sub(c.lastCheck) >= CredentialsCheckInterval {
+		c.lastCheck = now
+		if versions, err := fileVersionsOf(c.caFiles); err == nil && !sameVersions(versions, c.caVersions) {
+			c.loadRoots(versions)
+		}
+	}
+	return c.roots
+}
+
+// loadRoots reads the CA certificates; must be called with mutex held
+func (c *MTLSCredentials) loadRoots(versions []string) error {
+	roots := x509.NewCertPool()
+	for _, file := range c.caFiles {
+		caPEM, err := readPemFromFile(file)
+		if err != nil {
+			return err
+		}
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("No CA certificate in %s", file)
+		}
+	}
+	c.roots = roots
+	c.caVersions = versions
+	return nil
+}
+
+func (c *MTLSCredentials) certificate() (*tls.Certificate, error) {
+	cert, err := c.keyPair.GetIASClientCert()
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// ServerConfig returns the TLS config of a sidecar service. Clients must present a certificate issued by the CAs.
+func (c *MTLSCredentials) ServerConfig() *tls.Config {
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return c.certificate()
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+		// the config is created per connection to pick up rotated CAs
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: getCertificate,
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+				ClientCAs:      c.Roots(),
+			}, nil
+		},
+	}
+}
+
+// ClientConfig returns the TLS config connecting to the sidecar service serverName. The service must present a
+// certificate for serverName issued by the CAs.
+func (c *MTLSCredentials) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.certificate()
+		},
+		// the standard verification uses fixed roots; VerifyPeerCertificate verifies against the current CAs
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return c.verifyServer(rawCerts, serverName)
+		},
+	}
+}
+
+// verifyServer verifies the certificate chain presented by a sidecar service
+func (c *MTLSCredentials) verifyServer(rawCerts [][]byte, serverName string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("No server certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("Invalid server certificate: %s", err)
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         c.Roots(),
+		Intermediates: intermediates,
+	})
+	return err
+}
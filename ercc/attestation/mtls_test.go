@@ -0,0 +1,144 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues TLS certificates like the TLS CA of an MSP
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tlsca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Can not create CA cert: %s", err)
+	}
+	cert, _ := x509.ParseCertificate(certDER)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})}
+}
+
+// issue writes a certificate for 127.0.0.1 and its key to dir and returns the file names
+func (ca *testCA) issue(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Can not create cert: %s", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), time.Now())
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), time.Now())
+	return certFile, keyFile
+}
+
+func TestMTLSCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatalf("Can not create dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, otherCA := newTestCA(t), newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem, time.Now())
+
+	credentials := func(ca *testCA, name string) *MTLSCredentials {
+		certFile, keyFile := ca.issue(t, dir, name)
+		c, err := NewMTLSCredentials(certFile, keyFile, []string{caFile})
+		if err != nil {
+			t.Fatalf("Can not load credentials: %s", err)
+		}
+		return c
+	}
+	proxy, peer, foreignPeer := credentials(ca, "proxy"), credentials(ca, "peer"), credentials(otherCA, "foreign")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = proxy.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(c *MTLSCredentials) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: c.ClientConfig("127.0.0.1")}}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(peer); err != nil {
+		t.Fatalf("Peer of the org should be accepted: %s", err)
+	}
+	if err := get(foreignPeer); err == nil {
+		t.Fatalf("Peer with a certificate of another CA should be rejected")
+	}
+
+	// rotate the CA: certificates of the previous CA are no longer accepted
+	writeFile(t, caFile, otherCA.pem, time.Now().Add(time.Minute))
+	if err := proxy.Reload(); err != nil {
+		t.Fatalf("Can not reload credentials: %s", err)
+	}
+	if err := get(peer); err == nil {
+		t.Fatalf("Peer with a certificate of the previous CA should be rejected")
+	}
+
+	if _, err := NewMTLSCredentials("", "", nil); err == nil {
+		t.Fatalf("Missing credentials should be rejected")
+	}
+}
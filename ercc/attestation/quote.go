@@ -0,0 +1,177 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+)
+
+// QuoteBodySize is the size of a quote without signature length and signature. IAS report bodies include this
+// part of the quote only.
+const QuoteBodySize = 432
+
+// Quote versions and signature types of EPID quotes, the only ones IAS verifies
+const (
+	QuoteVersion1      = 1
+	QuoteVersion2      = 2
+	SignTypeUnlinkable = 0
+	SignTypeLinkable   = 1
+)
+
+// MaxQuoteSize bounds quotes accepted from enclaves and clients. An EPID signature grows by 160 bytes per entry
+// of the SigRL; the bound leaves room for SigRLs far larger than seen in practice.
+const MaxQuoteSize = 256 * 1024
+
+// Errors of quotes with an unsupported or malformed format
+var (
+	ErrQuoteTooShort           = errors.New("quote too short")
+	ErrQuoteTooLarge           = errors.New("quote too large")
+	ErrQuoteSignatureLength    = errors.New("quote signature length does not match quote size")
+	ErrUnsupportedQuoteVersion = errors.New("unsupported quote version")
+	ErrUnsupportedSignType     = errors.New("unsupported quote signature type")
+)
+
+// Flags of the enclave attributes (SGX_FLAGS_*)
+const (
+	FlagInitted       = 0x01
+	FlagDebug         = 0x02
+	FlagMode64Bit     = 0x04
+	FlagProvisionKey  = 0x10
+	FlagEinitTokenKey = 0x20
+	FlagKSS           = 0x80
+)
+
+// Flags returns the flags of the enclave attributes
+func (q EnclaveQuote) Flags() uint64 {
+	return binary.LittleEndian.Uint64(q.Attributes[:8])
+}
+
+// IsDebug returns true if the enclave that produced the quote was launched in debug mode. The memory of a debug
+// enclave can be inspected by the host, so it protects neither its keys nor its state.
+func (q EnclaveQuote) IsDebug() bool {
+	return q.Flags()&FlagDebug != 0
+}
+
+// The quote layout predates Key Separation & Sharing (KSS), which assigns some of the reserved bytes of the report
+// body. Enclaves not using KSS leave them zero.
+
+// IsvExtProdID returns the ISV extended product id of the enclave that produced the quote (KSS)
+func (q EnclaveQuote) IsvExtProdID() [16]byte {
+	var id [16]byte
+	copy(id[:], q.Reserved1[12:])
+	return id
+}
+
+// IsvFamilyID returns the ISV family id of the enclave that produced the quote (KSS)
+func (q EnclaveQuote) IsvFamilyID() [16]byte {
+	var id [16]byte
+	copy(id[:], q.Reserved4[44:])
+	return id
+}
+
+// ConfigID returns the config id the enclave was launched with (KSS)
+func (q EnclaveQuote) ConfigID() [64]byte {
+	var id [64]byte
+	copy(id[:], q.Reserved3[32:])
+	return id
+}
+
+// ConfigSvn returns the config SVN the enclave was launched with (KSS)
+func (q EnclaveQuote) ConfigSvn() uint16 {
+	return binary.LittleEndian.Uint16(q.Reserved4[:2])
+}
+
+// ValidateQuoteHeader returns an error if the quote version or signature type is not supported
+func ValidateQuoteHeader(quote EnclaveQuote) error {
+	if quote.Version != QuoteVersion1 && quote.Version != QuoteVersion2 {
+		return ErrUnsupportedQuoteVersion
+	}
+	if quote.SignType != SignTypeUnlinkable && quote.SignType != SignTypeLinkable {
+		return ErrUnsupportedSignType
+	}
+	return nil
+}
+
+// ParseQuote parses a quote as produced by the quoting enclave, i.e., followed by signature length and
+// signature, and validates its format before it is sent to IAS or stored
+func ParseQuote(quoteAsBytes []byte) (EnclaveQuote, error) {
+	if len(quoteAsBytes) < QuoteBodySize+4 {
+		return EnclaveQuote{}, ErrQuoteTooShort
+	}
+	if len(quoteAsBytes) > MaxQuoteSize {
+		return EnclaveQuote{}, ErrQuoteTooLarge
+	}
+	signatureLen := binary.LittleEndian.Uint32(quoteAsBytes[QuoteBodySize:])
+	if uint64(signatureLen) != uint64(len(quoteAsBytes)-QuoteBodySize-4) {
+		return EnclaveQuote{}, ErrQuoteSignatureLength
+	}
+
+	quote, err := QuoteFromBytes(quoteAsBytes)
+	if err != nil {
+		return EnclaveQuote{}, err
+	}
+	if err := ValidateQuoteHeader(quote); err != nil {
+		return EnclaveQuote{}, err
+	}
+	return quote, nil
+}
+
+// MatchQuoteBody returns an error if the quote body reported by IAS (base64) does not belong to the submitted
+// quote. IAS reports the quote without signature length and signature; both quote bodies are compared in constant
+// time, and if they differ, field by field, so the error names the first field that differs.
+func MatchQuoteBody(quoteAsBytes []byte, reportedBodyBase64 string) error {
+	if len(reportedBodyBase64) != base64.StdEncoding.EncodedLen(QuoteBodySize) {
+		return fmt.Errorf("reported quote body has %d base64 characters instead of %d", len(reportedBodyBase64), base64.StdEncoding.EncodedLen(QuoteBodySize))
+	}
+	reportedBody, err := base64.StdEncoding.DecodeString(reportedBodyBase64)
+	if err != nil {
+		return fmt.Errorf("malformed reported quote body: %s", err)
+	}
+	if len(reportedBody) != QuoteBodySize {
+		return fmt.Errorf("reported quote body has %d bytes instead of %d", len(reportedBody), QuoteBodySize)
+	}
+	if len(quoteAsBytes) < QuoteBodySize {
+		return ErrQuoteTooShort
+	}
+
+	submitted, err := QuoteFromBytes(quoteAsBytes[:QuoteBodySize])
+	if err != nil {
+		return err
+	}
+	reported, err := QuoteFromBytes(reportedBody)
+	if err != nil {
+		return err
+	}
+	if consttime.Equal(quoteAsBytes[:QuoteBodySize], reportedBody) {
+		return nil
+	}
+
+	// only name the field that differs once the bodies are known to differ
+	submittedValue, reportedValue := reflect.ValueOf(submitted), reflect.ValueOf(reported)
+	for i := 0; i < submittedValue.NumField(); i++ {
+		if !reflect.DeepEqual(submittedValue.Field(i).Interface(), reportedValue.Field(i).Interface()) {
+			return fmt.Errorf("reported quote body differs in %s", submittedValue.Type().Field(i).Name)
+		}
+	}
+	return errors.New("reported quote body differs")
+}
@@ -0,0 +1,115 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseQuote(t *testing.T) {
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	if _, err := ParseQuote(quoteAsBytes); err != nil {
+		t.Fatalf("Quote should be valid: %s", err)
+	}
+
+	if _, err := ParseQuote(quoteAsBytes[:QuoteBodySize]); err != ErrQuoteTooShort {
+		t.Fatalf("Expected %s but got %v", ErrQuoteTooShort, err)
+	}
+	if _, err := ParseQuote(quoteAsBytes[:len(quoteAsBytes)-1]); err != ErrQuoteSignatureLength {
+		t.Fatalf("Expected %s but got %v", ErrQuoteSignatureLength, err)
+	}
+
+	// DCAP (ECDSA) quotes are version 3
+	other := append([]byte{}, quoteAsBytes...)
+	other[0] = 3
+	if _, err := ParseQuote(other); err != ErrUnsupportedQuoteVersion {
+		t.Fatalf("Expected %s but got %v", ErrUnsupportedQuoteVersion, err)
+	}
+
+	other = append([]byte{}, quoteAsBytes...)
+	other[2] = 2
+	if _, err := ParseQuote(other); err != ErrUnsupportedSignType {
+		t.Fatalf("Expected %s but got %v", ErrUnsupportedSignType, err)
+	}
+}
+
+func TestMatchQuoteBody(t *testing.T) {
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	reportedBody := base64.StdEncoding.EncodeToString(quoteAsBytes[:QuoteBodySize])
+	if err := MatchQuoteBody(quoteAsBytes, reportedBody); err != nil {
+		t.Fatalf("Quote body should match: %s", err)
+	}
+
+	// a prefix of the quote is not a quote body
+	if err := MatchQuoteBody(quoteAsBytes, base64.StdEncoding.EncodeToString(quoteAsBytes[:100])); err == nil {
+		t.Fatalf("Truncated quote body should not match")
+	}
+	if err := MatchQuoteBody(quoteAsBytes, ""); err == nil {
+		t.Fatalf("Empty quote body should not match")
+	}
+
+	other := append([]byte{}, quoteAsBytes...)
+	other[112] ^= 1
+	err := MatchQuoteBody(other, reportedBody)
+	if err == nil || err.Error() != "reported quote body differs in MrEnclave" {
+		t.Fatalf("Expected mrenclave mismatch but got %v", err)
+	}
+}
+
+func TestEnclaveQuote_KSS(t *testing.T) {
+	// offsets in the quote: 48 bytes header, then the report body
+	quoteAsBytes := make([]byte, QuoteBodySize)
+	quoteAsBytes[48+32] = 1
+	quoteAsBytes[48+304+15] = 2
+	quoteAsBytes[48+192] = 3
+	quoteAsBytes[48+260] = 4
+
+	quote, err := QuoteFromBytes(quoteAsBytes)
+	if err != nil {
+		t.Fatalf("Can not parse quote: %s", err)
+	}
+	if id := quote.IsvExtProdID(); id[0] != 1 {
+		t.Fatalf("Unexpected isv ext prod id %v", id)
+	}
+	if id := quote.IsvFamilyID(); id[15] != 2 {
+		t.Fatalf("Unexpected isv family id %v", id)
+	}
+	if id := quote.ConfigID(); id[0] != 3 {
+		t.Fatalf("Unexpected config id %v", id)
+	}
+	if svn := quote.ConfigSvn(); svn != 4 {
+		t.Fatalf("Unexpected config svn %d", svn)
+	}
+}
+
+func TestEnclaveQuote_IsDebug(t *testing.T) {
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	debugQuote, err := QuoteFromBytes(quoteAsBytes)
+	if err != nil {
+		t.Fatalf("Can not parse quote: %s", err)
+	}
+	// the test quote comes from a debug build
+	if !debugQuote.IsDebug() || debugQuote.Flags()&FlagInitted == 0 {
+		t.Fatalf("Unexpected flags %x", debugQuote.Flags())
+	}
+
+	debugQuote.Attributes[0] &^= FlagDebug
+	if debugQuote.IsDebug() {
+		t.Fatalf("Quote should not be from a debug enclave")
+	}
+}
@@ -0,0 +1,129 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// reportTimestampLayout is the format of the timestamp in IAS report bodies (UTC without time zone)
+const reportTimestampLayout = "2006-01-02T15:04:05.999999999"
+
+// MaxReportBodySize bounds IAS report bodies. Bodies carry the quote body, a few status fields and, for
+// outdated platforms, the platform info blob and advisories, i.e., a few KB.
+const MaxReportBodySize = 64 * 1024
+
+// ErrReportBodyTooLarge is returned for report bodies exceeding MaxReportBodySize
+var ErrReportBodyTooLarge = errors.New("report body too large")
+
+// MaxCertificateChainSize bounds the URL-encoded report signing certificate chain of IAS reports
+const MaxCertificateChainSize = 32 * 1024
+
+// MaxAttestationReportSize bounds JSON-encoded attestation reports submitted by clients, i.e., report body
+// (base64), signature and certificate chain
+const MaxAttestationReportSize = 128 * 1024
+
+// ParseAttestationReport decodes a JSON-encoded attestation report as submitted by clients. The report is not
+// verified.
+func ParseAttestationReport(data []byte) (IASAttestationReport, error) {
+	if len(data) > MaxAttestationReportSize {
+		return IASAttestationReport{}, errors.New("attestation report too large")
+	}
+	report := IASAttestationReport{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return IASAttestationReport{}, err
+	}
+	if len(report.IASReportBody) > MaxReportBodySize {
+		return IASAttestationReport{}, ErrReportBodyTooLarge
+	}
+	return report, nil
+}
+
+// reportBodyChecks validate a decoded IAS report body in order; each check may rely on the previous ones
+var reportBodyChecks = []func(body *IASReportBody) error{
+	checkReportRequiredFields,
+	checkReportTimestamp,
+	checkReportQuoteBody,
+}
+
+// ParseReportBody strictly decodes and validates an IAS report body. Unlike json.Unmarshal on its own, it
+// rejects trailing data, missing required fields, malformed timestamps and quote bodies, so that malformed IAS
+// responses do not end up in the ledger. Fields unknown to us are tolerated, as IAS may add new ones.
+func ParseReportBody(bodyData []byte) (IASReportBody, error) {
+	if len(bodyData) > MaxReportBodySize {
+		return IASReportBody{}, ErrReportBodyTooLarge
+	}
+	reportBody := IASReportBody{}
+
+	decoder := json.NewDecoder(bytes.NewReader(bodyData))
+	if err := decoder.Decode(&reportBody); err != nil {
+		return IASReportBody{}, fmt.Errorf("Can not decode report body: %s", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return IASReportBody{}, errors.New("Report body has trailing data")
+	}
+
+	for _, check := range reportBodyChecks {
+		if err := check(&reportBody); err != nil {
+			return IASReportBody{}, fmt.Errorf("Invalid report body: %s", err)
+		}
+	}
+	return reportBody, nil
+}
+
+func checkReportRequiredFields(body *IASReportBody) error {
+	required := map[string]string{
+		"id":                    body.ID,
+		"timestamp":             body.Timestamp,
+		"isvEnclaveQuoteStatus": body.IsvEnclaveQuoteStatus,
+		"isvEnclaveQuoteBody":   body.IsvEnclaveQuoteBody,
+	}
+	for _, field := range []string{"id", "timestamp", "isvEnclaveQuoteStatus", "isvEnclaveQuoteBody"} {
+		if required[field] == "" {
+			return fmt.Errorf("missing %s", field)
+		}
+	}
+	return nil
+}
+
+func checkReportTimestamp(body *IASReportBody) error {
+	if _, err := time.Parse(reportTimestampLayout, body.Timestamp); err != nil {
+		return fmt.Errorf("malformed timestamp %s", body.Timestamp)
+	}
+	return nil
+}
+
+func checkReportQuoteBody(body *IASReportBody) error {
+	quoteBody, err := base64.StdEncoding.DecodeString(body.IsvEnclaveQuoteBody)
+	if err != nil {
+		return fmt.Errorf("malformed isvEnclaveQuoteBody: %s", err)
+	}
+	if len(quoteBody) != QuoteBodySize {
+		return fmt.Errorf("isvEnclaveQuoteBody has %d bytes instead of %d", len(quoteBody), QuoteBodySize)
+	}
+	quote, err := QuoteFromBytes(quoteBody)
+	if err != nil {
+		return err
+	}
+	return ValidateQuoteHeader(quote)
+}
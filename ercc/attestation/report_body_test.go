@@ -0,0 +1,69 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func TestParseReportBody(t *testing.T) {
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	valid := IASReportBody{
+		ID:                    "report id",
+		IsvEnclaveQuoteStatus: "OK",
+		IsvEnclaveQuoteBody:   base64.StdEncoding.EncodeToString(quoteAsBytes[:QuoteBodySize]),
+		Timestamp:             "2019-01-01T12:00:00.123456",
+	}
+	bodyData, _ := json.Marshal(&valid)
+	if _, err := ParseReportBody(bodyData); err != nil {
+		t.Fatalf("Report body should be valid: %s", err)
+	}
+
+	// fields added by IAS are tolerated
+	if _, err := ParseReportBody([]byte(`{"version": 3,` + string(bodyData[1:]))); err != nil {
+		t.Fatalf("Unknown fields should be tolerated: %s", err)
+	}
+
+	for name, body := range map[string][]byte{
+		"trailing data": append(append([]byte{}, bodyData...), []byte(`{}`)...),
+		"wrong type":    []byte(`{"id": 1}`),
+		"not json":      []byte(`report`),
+	} {
+		if _, err := ParseReportBody(body); err == nil {
+			t.Fatalf("Report body with %s should be rejected", name)
+		}
+	}
+
+	for name, modify := range map[string]func(body *IASReportBody){
+		"missing id":        func(body *IASReportBody) { body.ID = "" },
+		"missing status":    func(body *IASReportBody) { body.IsvEnclaveQuoteStatus = "" },
+		"invalid timestamp": func(body *IASReportBody) { body.Timestamp = "yesterday" },
+		"short quote body": func(body *IASReportBody) {
+			body.IsvEnclaveQuoteBody = base64.StdEncoding.EncodeToString(quoteAsBytes[:100])
+		},
+		"full quote": func(body *IASReportBody) { body.IsvEnclaveQuoteBody = quote },
+	} {
+		body := valid
+		modify(&body)
+		bodyData, _ := json.Marshal(&body)
+		if _, err := ParseReportBody(bodyData); err == nil {
+			t.Fatalf("Report body with %s should be rejected", name)
+		}
+	}
+}
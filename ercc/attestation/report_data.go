@@ -0,0 +1,127 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+)
+
+// ReportDataFormat documents how an enclave binds its public key and the registration context to its quote.
+// Both the enclave (via the binding passed at quote generation) and ercc use ComputeReportData.
+//
+// pkHash  = SHA-256(X || Y) with X, Y the big endian coordinates (32 bytes each) of the enclave pk
+// binding = SHA-256(nonce || len(channelID) || channelID || len(chaincodeID) || chaincodeID || len(version) || version)
+// with lengths as uint32 big endian; an empty version is omitted including its length, so V1 bindings remain valid
+// REPORT_DATA = pkHash || binding, where binding is all zero if the quote is not bound to a context
+const ReportDataFormat = "FPC-REPORT-DATA-V2"
+
+// ReportDataBinding is the context a quote is bound to
+type ReportDataBinding struct {
+	// Nonce guarantees freshness, e.g., derived from the registration transaction with TxNonce
+	Nonce       []byte `json:"Nonce"`
+	ChannelID   string `json:"ChannelID"`
+	ChaincodeID string `json:"ChaincodeID"`
+	// ChaincodeVersion is empty if the quote does not bind the version
+	ChaincodeVersion string `json:"ChaincodeVersion,omitempty"`
+}
+
+// NewReportDataBinding returns the binding of a quote to the transaction txID on channelID for the chaincode
+// reference chaincode, which is either a chaincode id or "<chaincode id>:<version>" as in CORE_CHAINCODE_ID_NAME
+func NewReportDataBinding(txID, channelID, chaincode string) *ReportDataBinding {
+	chaincodeID, chaincodeVersion := ParseChaincodeRef(chaincode)
+	return &ReportDataBinding{
+		Nonce:            TxNonce(txID),
+		ChannelID:        channelID,
+		ChaincodeID:      chaincodeID,
+		ChaincodeVersion: chaincodeVersion,
+	}
+}
+
+// ParseChaincodeRef splits a chaincode reference "<chaincode id>[:<version>]" into id and version. Chaincode
+// names can not contain colons, versions can.
+func ParseChaincodeRef(chaincode string) (string, string) {
+	if i := strings.Index(chaincode, ":"); i >= 0 {
+		return chaincode[:i], chaincode[i+1:]
+	}
+	return chaincode, ""
+}
+
+// TxNonce returns the nonce binding a quote to the transaction with the given id
+func TxNonce(txID string) []byte {
+	nonce := sha256.Sum256([]byte(txID))
+	return nonce[:]
+}
+
+// Digest returns the binding part of the REPORT_DATA. The digest of a nil binding is all zero.
+func (b *ReportDataBinding) Digest() [32]byte {
+	var digest [32]byte
+	if b == nil {
+		return digest
+	}
+
+	h := sha256.New()
+	h.Write(b.Nonce)
+	items := []string{b.ChannelID, b.ChaincodeID}
+	if b.ChaincodeVersion != "" {
+		items = append(items, b.ChaincodeVersion)
+	}
+	for _, s := range items {
+		binary.Write(h, binary.BigEndian, uint32(len(s)))
+		h.Write([]byte(s))
+	}
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// ComputeReportData returns the REPORT_DATA an enclave with the given pk (DER-encoded PKIX) puts into a quote
+// bound to the given context (nil if unbound), see ReportDataFormat
+func ComputeReportData(pkBytes []byte, binding *ReportDataBinding) ([64]byte, error) {
+	var reportData [64]byte
+
+	ecdsaPublickey, _, err := algorithm.ParsePublicKey(pkBytes)
+	if err != nil {
+		return reportData, err
+	}
+
+	// coordinates are padded as the enclave hashes the fixed size sgx_ec256_public_t
+	var rawPk [64]byte
+	xBytes := ecdsaPublickey.X.Bytes()
+	yBytes := ecdsaPublickey.Y.Bytes()
+	copy(rawPk[32-len(xBytes):32], xBytes)
+	copy(rawPk[64-len(yBytes):], yBytes)
+
+	pkHash := sha256.Sum256(rawPk[:])
+	bindingDigest := binding.Digest()
+	copy(reportData[:32], pkHash[:])
+	copy(reportData[32:], bindingDigest[:])
+	return reportData, nil
+}
+
+// MatchReportData returns true if the REPORT_DATA of the quote binds the enclave pk (DER-encoded PKIX) and
+// the given context (nil if unbound)
+func MatchReportData(pkBytes []byte, binding *ReportDataBinding, quote EnclaveQuote) (bool, error) {
+	expected, err := ComputeReportData(pkBytes, binding)
+	if err != nil {
+		return false, err
+	}
+	return consttime.Equal(expected[:], quote.ReportData[:]), nil
+}
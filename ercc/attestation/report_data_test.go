@@ -0,0 +1,83 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestMatchReportData(t *testing.T) {
+	pkBytes, _ := base64.StdEncoding.DecodeString(enclavePK)
+	binding := &ReportDataBinding{Nonce: TxNonce("tx1"), ChannelID: "mychannel", ChaincodeID: "ecc"}
+
+	reportData, err := ComputeReportData(pkBytes, binding)
+	if err != nil {
+		t.Fatalf("ComputeReportData failed: %s", err)
+	}
+	quote := EnclaveQuote{ReportData: reportData}
+
+	if ok, err := MatchReportData(pkBytes, binding, quote); err != nil || !ok {
+		t.Fatalf("Report data should match binding: %v", err)
+	}
+
+	// replayed in another transaction
+	otherBinding := &ReportDataBinding{Nonce: TxNonce("tx2"), ChannelID: "mychannel", ChaincodeID: "ecc"}
+	if ok, _ := MatchReportData(pkBytes, otherBinding, quote); ok {
+		t.Fatalf("Report data should not match other binding")
+	}
+	if ok, _ := MatchReportData(pkBytes, nil, quote); ok {
+		t.Fatalf("Bound report data should not match unbound")
+	}
+}
+
+func TestReportDataBinding_Version(t *testing.T) {
+	unversioned := NewReportDataBinding("tx1", "mychannel", "ecc")
+	if unversioned.ChaincodeID != "ecc" || unversioned.ChaincodeVersion != "" {
+		t.Fatalf("Unexpected binding %+v", unversioned)
+	}
+	// without version the digest is the one of format V1
+	v1 := &ReportDataBinding{Nonce: TxNonce("tx1"), ChannelID: "mychannel", ChaincodeID: "ecc"}
+	if unversioned.Digest() != v1.Digest() {
+		t.Fatalf("Unversioned binding should have V1 digest")
+	}
+
+	versioned := NewReportDataBinding("tx1", "mychannel", "ecc:1.0:rc1")
+	if versioned.ChaincodeID != "ecc" || versioned.ChaincodeVersion != "1.0:rc1" {
+		t.Fatalf("Unexpected binding %+v", versioned)
+	}
+	if versioned.Digest() == unversioned.Digest() {
+		t.Fatalf("Version should be bound")
+	}
+	other := NewReportDataBinding("tx1", "mychannel", "ecc:1.1")
+	if versioned.Digest() == other.Digest() {
+		t.Fatalf("Versions should be distinguished")
+	}
+
+	pkBytes, _ := base64.StdEncoding.DecodeString(enclavePK)
+	reportData, err := ComputeReportData(pkBytes, versioned)
+	if err != nil {
+		t.Fatalf("ComputeReportData failed: %s", err)
+	}
+	quote := EnclaveQuote{ReportData: reportData}
+	if ok, _ := MatchReportData(pkBytes, other, quote); ok {
+		t.Fatalf("Report data should not match other version")
+	}
+	if ok, _ := MatchReportData(pkBytes, unversioned, quote); ok {
+		t.Fatalf("Report data should not match unversioned binding")
+	}
+}
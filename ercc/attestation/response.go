@@ -0,0 +1,82 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// MaxResponseHeaderSize limits the size of the response headers of attestation services. IAS sends the report
+// signature and the URL-encoded signing certificate chain as headers.
+const MaxResponseHeaderSize = 2*MaxCertificateChainSize + 16*1024
+
+// ResponseTooLargeError is returned as soon as a response exceeds its size limit
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response body exceeds %d bytes", e.Limit)
+}
+
+// limitedReader reads at most limit bytes from r. Unlike io.LimitReader, it fails with a ResponseTooLargeError
+// instead of returning EOF once the limit is exceeded, so that truncated responses are never mistaken for
+// complete ones.
+type limitedReader struct {
+	r         io.Reader
+	limit     int64
+	remaining int64
+}
+
+// newLimitedReader returns a reader of r that fails once more than limit bytes are read
+func newLimitedReader(r io.Reader, limit int64) io.Reader {
+	return &limitedReader{r: r, limit: limit, remaining: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, &ResponseTooLargeError{Limit: l.limit}
+	}
+	// read one byte beyond the limit to detect oversized responses
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n + int(l.remaining), &ResponseTooLargeError{Limit: l.limit}
+	}
+	return n, err
+}
+
+// readLimited reads r until EOF and aborts as soon as more than limit bytes are read. contentLength is the
+// announced size, or -1 if unknown; announced sizes beyond the limit are rejected before reading.
+func readLimited(r io.Reader, contentLength, limit int64) ([]byte, error) {
+	if contentLength > limit {
+		return nil, &ResponseTooLargeError{Limit: limit}
+	}
+	buf := &bytes.Buffer{}
+	if contentLength > 0 {
+		buf.Grow(int(contentLength))
+	}
+	if _, err := buf.ReadFrom(newLimitedReader(r, limit)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
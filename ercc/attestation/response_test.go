@@ -0,0 +1,91 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// endlessReader returns zeros forever and counts the bytes read
+type endlessReader struct {
+	read int
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	r.read += len(p)
+	return len(p), nil
+}
+
+func TestReadLimited(t *testing.T) {
+	data, err := readLimited(bytes.NewReader([]byte("report")), -1, 6)
+	if err != nil || string(data) != "report" {
+		t.Fatalf("Response within limit not read: %v", err)
+	}
+
+	// reading stops right after the limit
+	r := &endlessReader{}
+	if _, err := readLimited(r, -1, 1000); err == nil {
+		t.Fatalf("Endless response should be rejected")
+	} else if _, ok := err.(*ResponseTooLargeError); !ok {
+		t.Fatalf("Expected ResponseTooLargeError but got %s", err)
+	}
+	if r.read > 1000+bytes.MinRead {
+		t.Fatalf("Read %d bytes of an oversized response", r.read)
+	}
+
+	// announced sizes beyond the limit are rejected without reading
+	r = &endlessReader{}
+	if _, err := readLimited(r, 1001, 1000); err == nil || r.read != 0 {
+		t.Fatalf("Announced oversized response should be rejected before reading")
+	}
+}
+
+func TestReadResponseBody_Limits(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+
+	resp := &http.Response{Header: header, ContentLength: MaxResponseBodySize + 1, Body: ioutil.NopCloser(&endlessReader{})}
+	if _, err := readResponseBody(resp); err == nil {
+		t.Fatalf("Announced oversized response should be rejected")
+	}
+
+	// a small compressed body expanding beyond the limit
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write(make([]byte, 100*MaxResponseBodySize))
+	w.Close()
+	if compressed.Len() > MaxResponseBodySize {
+		t.Fatalf("Compressed body too large for test")
+	}
+
+	gzipHeader := http.Header{}
+	gzipHeader.Set("Content-Type", "application/json")
+	gzipHeader.Set("Content-Encoding", "gzip")
+	resp = &http.Response{Header: gzipHeader, ContentLength: -1, Body: ioutil.NopCloser(&compressed)}
+	if _, err := readResponseBody(resp); err == nil {
+		t.Fatalf("Decompression bomb should be rejected")
+	} else if _, ok := err.(*ResponseTooLargeError); !ok {
+		t.Fatalf("Expected ResponseTooLargeError but got %s", err)
+	}
+}
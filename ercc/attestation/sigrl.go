@@ -0,0 +1,65 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+const iasSigRLURL = "https://test-as.sgx.trustedservices.intel.com:443/attestation/sgx/v2/sigrl/"
+
+// SigRLService retrieves the signature revocation list (SigRL) of an EPID group. Enclaves need the SigRL
+// of their group to create quotes that IAS accepts.
+type SigRLService interface {
+	// RequestSigRL returns the SigRL of the EPID group gid (as found in the quote); it is empty if there are
+	// no revoked signatures in the group
+	RequestSigRL(cert tls.Certificate, gid [4]byte) ([]byte, error)
+}
+
+// RequestSigRL fetches the SigRL of an EPID group from IAS
+func (ias *intelAttestationServiceImpl) RequestSigRL(cert tls.Certificate, gid [4]byte) ([]byte, error) {
+	client, err := ias.client(cert)
+	if err != nil {
+		return nil, fmt.Errorf("IAS connection error: %s", err)
+	}
+
+	// IAS expects the group id in big endian whereas quotes carry it in little endian
+	gidBE := []byte{gid[3], gid[2], gid[1], gid[0]}
+	resp, err := client.Get(iasSigRLURL + hex.EncodeToString(gidBE))
+	if err != nil {
+		return nil, fmt.Errorf("IAS connection error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("IAS returned error: Code %s", resp.Status)
+	}
+
+	if resp.ContentLength > MaxResponseBodySize {
+		return nil, &ResponseTooLargeError{Limit: MaxResponseBodySize}
+	}
+	// decode while reading, aborting once the body exceeds MaxResponseBodySize; line breaks are ignored
+	body := newLimitedReader(resp.Body, MaxResponseBodySize)
+	sigRL, err := readLimited(base64.NewDecoder(base64.StdEncoding, body), -1, MaxResponseBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("Can not read SigRL: %s", err)
+	}
+	return sigRL, nil
+}
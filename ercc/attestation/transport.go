@@ -0,0 +1,228 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/fips"
+	"golang.org/x/net/http2"
+)
+
+// Environment variables tuning the connections to attestation services. Unset variables keep the defaults of
+// DefaultTransportConfig.
+const (
+	// HTTP2Env enables HTTP/2 if set to true
+	HTTP2Env = "IAS_HTTP2"
+	// MaxIdleConnsPerHostEnv sets the number of idle connections kept open per host
+	MaxIdleConnsPerHostEnv = "IAS_MAX_IDLE_CONNS_PER_HOST"
+	// IdleConnTimeoutEnv sets how long idle connections are kept open, e.g., 90s
+	IdleConnTimeoutEnv = "IAS_IDLE_CONN_TIMEOUT"
+	// TLSSessionCacheSizeEnv sets the number of TLS sessions cached for resumption; 0 disables resumption
+	TLSSessionCacheSizeEnv = "IAS_TLS_SESSION_CACHE_SIZE"
+	// TLSMinVersionEnv sets the minimum TLS version, either 1.2 or 1.3
+	TLSMinVersionEnv = "IAS_TLS_MIN_VERSION"
+	// TLSCipherSuitesEnv restricts the TLS 1.2 cipher suites to a comma separated list of names, e.g.,
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	TLSCipherSuitesEnv = "IAS_TLS_CIPHER_SUITES"
+)
+
+// versionTLS13 is tls.VersionTLS13 which is not defined before Go 1.12
+const versionTLS13 = 0x0304
+
+// tlsVersions are the TLS versions that may be required as minimum
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": versionTLS13,
+}
+
+// cipherSuites are the TLS 1.2 cipher suites that may be allowed. TLS 1.3 suites are not configurable.
+var cipherSuites = map[string]uint16{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// TransportConfig tunes the HTTP transport used to talk to attestation services
+type TransportConfig struct {
+	// HTTP2 allows to negotiate HTTP/2 with the server
+	HTTP2 bool
+	// MaxIdleConnsPerHost is the number of idle connections kept open per host
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long idle connections are kept open
+	IdleConnTimeout time.Duration
+	// TLSSessionCacheSize is the number of TLS sessions cached for resumption; 0 disables resumption
+	TLSSessionCacheSize int
+	// TLSMinVersion is the minimum TLS version accepted from the server
+	TLSMinVersion uint16
+	// CipherSuites restricts the TLS 1.2 cipher suites; nil allows the Go defaults
+	CipherSuites []uint16
+}
+
+// DefaultTransportConfig keeps connections to IAS open across a burst of registrations and resumes TLS sessions
+var DefaultTransportConfig = TransportConfig{
+	HTTP2:               false,
+	MaxIdleConnsPerHost: 4,
+	IdleConnTimeout:     90 * time.Second,
+	TLSSessionCacheSize: 64,
+	TLSMinVersion:       tls.VersionTLS12,
+}
+
+// TransportConfigFromEnv returns DefaultTransportConfig overridden by the environment (see HTTP2Env and friends)
+func TransportConfigFromEnv() (TransportConfig, error) {
+	config := DefaultTransportConfig
+
+	if value := os.Getenv(HTTP2Env); value != "" {
+		http2Enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %s", HTTP2Env, err)
+		}
+		config.HTTP2 = http2Enabled
+	}
+
+	for env, target := range map[string]*int{
+		MaxIdleConnsPerHostEnv: &config.MaxIdleConnsPerHost,
+		TLSSessionCacheSizeEnv: &config.TLSSessionCacheSize,
+	} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return config, fmt.Errorf("invalid %s: %s", env, value)
+		}
+		*target = n
+	}
+
+	if value := os.Getenv(IdleConnTimeoutEnv); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return config, fmt.Errorf("invalid %s: %s", IdleConnTimeoutEnv, value)
+		}
+		config.IdleConnTimeout = timeout
+	}
+
+	if value := os.Getenv(TLSMinVersionEnv); value != "" {
+		version, ok := tlsVersions[value]
+		if !ok {
+			return config, fmt.Errorf("invalid %s: %s", TLSMinVersionEnv, value)
+		}
+		config.TLSMinVersion = version
+	}
+
+	if value := os.Getenv(TLSCipherSuitesEnv); value != "" {
+		suites, err := parseCipherSuites(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %s", TLSCipherSuitesEnv, err)
+		}
+		config.CipherSuites = suites
+	}
+	return config, nil
+}
+
+// parseCipherSuites parses a comma separated list of cipher suite names
+func parseCipherSuites(names string) ([]uint16, error) {
+	var suites []uint16
+	for _, name := range strings.Split(names, ",") {
+		suite, ok := cipherSuites[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %s", name)
+		}
+		if err := fips.CheckCipherSuite(suite); err != nil {
+			return nil, err
+		}
+		suites = append(suites, suite)
+	}
+	return suites, nil
+}
+
+// NewAttestationTransport creates a transport authenticating with the client certificate cert. The transport
+// pools connections and caches TLS sessions, thus, it should be reused for all requests with the same cert.
+func NewAttestationTransport(cert tls.Certificate, config TransportConfig) (*http.Transport, error) {
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// RootCAs:            caCertPool,
+		InsecureSkipVerify: true,
+		MinVersion:         config.TLSMinVersion,
+		CipherSuites:       config.CipherSuites,
+	}
+	tlsConfig.BuildNameToCertificate()
+	if config.TLSSessionCacheSize > 0 {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(config.TLSSessionCacheSize)
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig:        tlsConfig,
+		MaxIdleConnsPerHost:    config.MaxIdleConnsPerHost,
+		IdleConnTimeout:        config.IdleConnTimeout,
+		MaxResponseHeaderBytes: MaxResponseHeaderSize,
+	}
+	// with a custom TLS config net/http speaks HTTP/1.1 only unless HTTP/2 is configured explicitly
+	if config.HTTP2 {
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return nil, fmt.Errorf("can not enable HTTP/2: %s", err)
+		}
+	}
+	return transport, nil
+}
+
+// transportPool holds one transport per client certificate so that connections and TLS sessions are reused
+type transportPool struct {
+	config     TransportConfig
+	transports map[[sha256.Size]byte]*http.Transport
+	lock       sync.Mutex
+}
+
+func newTransportPool(config TransportConfig) *transportPool {
+	return &transportPool{config: config, transports: make(map[[sha256.Size]byte]*http.Transport)}
+}
+
+// get returns the transport for cert, creating it on first use
+func (p *transportPool) get(cert tls.Certificate) (*http.Transport, error) {
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("no client certificate")
+	}
+	id := sha256.Sum256(cert.Certificate[0])
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if transport, ok := p.transports[id]; ok {
+		return transport, nil
+	}
+	transport, err := NewAttestationTransport(cert, p.config)
+	if err != nil {
+		return nil, err
+	}
+	p.transports[id] = transport
+	return transport, nil
+}
@@ -0,0 +1,98 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"crypto/tls"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTransportConfigFromEnv(t *testing.T) {
+	envs := []string{HTTP2Env, MaxIdleConnsPerHostEnv, IdleConnTimeoutEnv, TLSSessionCacheSizeEnv,
+		TLSMinVersionEnv, TLSCipherSuitesEnv}
+	defer func() {
+		for _, env := range envs {
+			os.Unsetenv(env)
+		}
+	}()
+
+	config, err := TransportConfigFromEnv()
+	if err != nil || !reflect.DeepEqual(config, DefaultTransportConfig) {
+		t.Fatalf("Expected default config but got %v (%v)", config, err)
+	}
+
+	os.Setenv(HTTP2Env, "true")
+	os.Setenv(MaxIdleConnsPerHostEnv, "16")
+	os.Setenv(IdleConnTimeoutEnv, "5m")
+	os.Setenv(TLSSessionCacheSizeEnv, "0")
+	os.Setenv(TLSMinVersionEnv, "1.3")
+	os.Setenv(TLSCipherSuitesEnv, "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	config, err = TransportConfigFromEnv()
+	if err != nil {
+		t.Fatalf("Can not read config: %s", err)
+	}
+	expected := TransportConfig{HTTP2: true, MaxIdleConnsPerHost: 16, IdleConnTimeout: 5 * time.Minute,
+		TLSMinVersion: versionTLS13,
+		CipherSuites:  []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}}
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("Expected %v but got %v", expected, config)
+	}
+
+	for env, value := range map[string]string{
+		IdleConnTimeoutEnv: "forever",
+		TLSMinVersionEnv:   "1.0",
+		TLSCipherSuitesEnv: "TLS_RSA_WITH_RC4_128_SHA",
+	} {
+		previous := os.Getenv(env)
+		os.Setenv(env, value)
+		if _, err := TransportConfigFromEnv(); err == nil {
+			t.Fatalf("Invalid %s should be rejected", env)
+		}
+		os.Setenv(env, previous)
+	}
+}
+
+func TestTransportPool(t *testing.T) {
+	pool := newTransportPool(DefaultTransportConfig)
+	certPem, keyPem := newClientCert(t, "a")
+	a, _ := tls.X509KeyPair(certPem, keyPem)
+	certPem, keyPem = newClientCert(t, "b")
+	b, _ := tls.X509KeyPair(certPem, keyPem)
+
+	transportA, err := pool.get(a)
+	if err != nil {
+		t.Fatalf("Can not create transport: %s", err)
+	}
+	if transportA.TLSClientConfig.ClientSessionCache == nil {
+		t.Fatalf("TLS session resumption should be enabled")
+	}
+	if transportA.TLSClientConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("TLS 1.2 should be required by default")
+	}
+	if again, _ := pool.get(a); again != transportA {
+		t.Fatalf("Transport should be reused for the same cert")
+	}
+	if transportB, _ := pool.get(b); transportB == transportA {
+		t.Fatalf("Transports must not be shared between certs")
+	}
+	if _, err := pool.get(tls.Certificate{}); err == nil {
+		t.Fatalf("Missing client cert should be rejected")
+	}
+}
@@ -0,0 +1,55 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package verification
+
+import (
+	"go/build"
+	"strings"
+	"testing"
+)
+
+// TestNoCgoDependencies ensures the verification path can be built with CGO_ENABLED=0 and without Fabric, so it
+// can be cross-compiled into tools that only check evidence
+func TestNoCgoDependencies(t *testing.T) {
+	const self = "github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/verification"
+
+	seen := map[string]bool{}
+	queue := []string{self}
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		if seen[path] || path == "C" {
+			continue
+		}
+		seen[path] = true
+
+		pkg, err := build.Import(path, ".", 0)
+		if err != nil {
+			t.Fatalf("Can not import %s: %s", path, err)
+		}
+		if pkg.Goroot {
+			continue
+		}
+		if strings.HasPrefix(path, "github.com/hyperledger/fabric") {
+			t.Fatalf("%s depends on Fabric", path)
+		}
+		if len(pkg.CgoFiles) > 0 {
+			t.Fatalf("%s uses cgo", path)
+		}
+		queue = append(queue, pkg.Imports...)
+	}
+}
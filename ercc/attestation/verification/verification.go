@@ -0,0 +1,168 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Package verification verifies attestation evidence exported from ercc with the same code as ercc and its vscc.
+// It does not depend on the chaincode shim or the peer, so wallets, explorers and auditors can embed it.
+package verification
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+// Request carries attestation evidence exported from ercc. Exactly one of Record and Report must be set.
+type Request struct {
+	// Record is an enclave record as returned by getEnclaveByPk or listEnclaves
+	Record *registry.EnclaveRecord `json:"record,omitempty"`
+	// Report is an attestation report as returned by getAttestationReport
+	Report *attestation.IASAttestationReport `json:"report,omitempty"`
+	// MrEnclave (base64) the enclave is expected to run, if any
+	MrEnclave string `json:"mrEnclave,omitempty"`
+	// Policy the evidence must satisfy, e.g., as returned by getAttestationPolicy
+	Policy *registry.AttestationPolicy `json:"policy,omitempty"`
+	// Collateral the report was verified with, as returned by getCollateral. If given, the report is re-verified
+	// as of the time of registration with the collateral instead of the verification key of the verifier.
+	Collateral *attestation.Collateral `json:"collateral,omitempty"`
+}
+
+// Result is the outcome of a verification. The enclave details are only set if the evidence is valid.
+type Result struct {
+	Valid         bool   `json:"valid"`
+	Error         string `json:"error,omitempty"`
+	EnclavePkHash string `json:"enclavePkHash,omitempty"`
+	MrEnclave     string `json:"mrEnclave,omitempty"`
+	IsvSvn        uint16 `json:"isvSvn,omitempty"`
+	PlatformID    string `json:"platformId,omitempty"`
+	QuoteStatus   string `json:"quoteStatus,omitempty"`
+	Timestamp     string `json:"timestamp,omitempty"`
+	// ConfigID (base64) and ConfigSvn are set for enclaves using Key Separation & Sharing
+	ConfigID  string `json:"configId,omitempty"`
+	ConfigSvn uint16 `json:"configSvn,omitempty"`
+	// Retired and Revoked reflect the registry state captured in the record
+	Retired bool `json:"retired,omitempty"`
+	Revoked bool `json:"revoked,omitempty"`
+}
+
+// Verifier verifies attestation evidence against the key signing IAS reports
+type Verifier struct {
+	ra             attestation.Verifier
+	verificationPK interface{}
+	now            func() int64
+}
+
+// NewVerifier creates a verifier accepting reports signed with verificationPK, e.g., the Intel verification key
+func NewVerifier(verificationPK interface{}) *Verifier {
+	return &Verifier{
+		ra:             &attestation.VerifierImpl{},
+		verificationPK: verificationPK,
+		now:            func() int64 { return time.Now().Unix() },
+	}
+}
+
+// Verify checks the evidence of request and returns the details of the attested enclave. An error is returned if
+// the evidence is invalid.
+func (v *Verifier) Verify(request *Request) (*Result, error) {
+	if (request.Record == nil) == (request.Report == nil) {
+		return nil, errors.New("Either record or report must be given")
+	}
+
+	result := &Result{}
+	var report attestation.IASAttestationReport
+	var binding *attestation.ReportDataBinding
+	if request.Record != nil {
+		report = request.Record.AttestationReport
+		binding = request.Record.Binding
+		if !consttime.EqualString(request.Record.EnclavePkHash, registry.EnclavePkHash(report.EnclavePk)) {
+			return nil, errors.New("Enclave PK hash does not match attestation report")
+		}
+		result.Retired = request.Record.SuccessorPkHash != ""
+		result.Revoked = request.Record.Status != nil && request.Record.Status.IsRevoked()
+	} else {
+		report = *request.Report
+	}
+
+	now := v.now()
+	if request.Collateral != nil {
+		if err := request.Collateral.Verify(report); err != nil {
+			return nil, fmt.Errorf("Attestation report verification failed: %s", err)
+		}
+		now = request.Collateral.VerifiedAt
+	} else {
+		isValid, err := v.ra.VerifyAttestionReport(v.verificationPK, report)
+		if err != nil {
+			return nil, fmt.Errorf("Attestation report verification failed: %s", err)
+		}
+		if !isValid {
+			return nil, errors.New("Attestation report is not valid")
+		}
+	}
+
+	isValid, err := v.ra.CheckReportData(report.EnclavePk, binding, report)
+	if err != nil {
+		return nil, fmt.Errorf("Error while checking enclave PK: %s", err)
+	}
+	if !isValid {
+		return nil, errors.New("Enclave PK is not bound by quote")
+	}
+
+	if request.MrEnclave != "" {
+		matches, err := v.ra.CheckMrEnclave(request.MrEnclave, report)
+		if err != nil {
+			return nil, fmt.Errorf("Error while checking mrenclave: %s", err)
+		}
+		if !matches {
+			return nil, errors.New("Attestation report does not match MRENCLAVE")
+		}
+	}
+
+	reportBody, err := attestation.ParseReportBody(report.IASReportBody)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := attestation.QuoteFromAttestionReport(report)
+	if err != nil {
+		return nil, fmt.Errorf("Can not parse quote: %s", err)
+	}
+
+	if request.Policy != nil {
+		if err := request.Policy.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid attestation policy: %s", err)
+		}
+		if err := request.Policy.Check(quote, reportBody, now); err != nil {
+			return nil, fmt.Errorf("Attestation policy violated: %s", err)
+		}
+	} else if err := registry.CheckDebugEnclave(quote, nil); err != nil {
+		return nil, fmt.Errorf("Attestation policy violated: %s", err)
+	}
+
+	result.Valid = true
+	result.EnclavePkHash = registry.EnclavePkHash(report.EnclavePk)
+	result.MrEnclave = registry.MrEnclave(quote)
+	result.IsvSvn = registry.IsvSvn(quote)
+	result.PlatformID = registry.PlatformID(quote, reportBody)
+	result.QuoteStatus = reportBody.IsvEnclaveQuoteStatus
+	result.Timestamp = reportBody.Timestamp
+	if quote.Flags()&attestation.FlagKSS != 0 {
+		result.ConfigID = registry.ConfigID(quote)
+		result.ConfigSvn = quote.ConfigSvn()
+	}
+	return result, nil
+}
@@ -0,0 +1,99 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package verification
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+func TestVerifier(t *testing.T) {
+	ias, err := mock.NewSigningIAS()
+	if err != nil {
+		t.Fatalf("Can not create IAS: %s", err)
+	}
+	_, pkBytes, err := mock.EnclaveKey()
+	if err != nil {
+		t.Fatalf("Can not load enclave key: %s", err)
+	}
+	binding := &attestation.ReportDataBinding{Nonce: attestation.TxNonce("tx1"), ChannelID: "mychannel", ChaincodeID: "ecc"}
+	quote, err := mock.NewQuote(pkBytes, binding, mock.MrEnclave, 1)
+	if err != nil {
+		t.Fatalf("Can not create quote: %s", err)
+	}
+	report, err := ias.RequestAttestationReport(tls.Certificate{}, quote)
+	if err != nil {
+		t.Fatalf("Can not get attestation report: %s", err)
+	}
+	// ercc stores the enclave pk along with the report
+	report.EnclavePk = pkBytes
+	verificationPK, _ := ias.GetIntelVerificationKey()
+	verifier := NewVerifier(verificationPK)
+
+	record := &registry.EnclaveRecord{EnclavePkHash: registry.EnclavePkHash(pkBytes), AttestationReport: report, Binding: binding}
+	result, err := verifier.Verify(&Request{Record: record})
+	if err != nil || !result.Valid {
+		t.Fatalf("Record should be valid: %s", err)
+	}
+	if result.EnclavePkHash != record.EnclavePkHash || result.MrEnclave != registry.MrEnclave(attestation.EnclaveQuote{MrEnclave: mock.MrEnclave}) || result.IsvSvn != 1 {
+		t.Fatalf("Unexpected enclave details %+v", result)
+	}
+
+	// the quote binds the transaction of the registration
+	if _, err := verifier.Verify(&Request{Report: &report}); err == nil {
+		t.Fatalf("Report without binding should be invalid")
+	}
+
+	// the enclave runs other code than expected
+	other := registry.MrEnclave(attestation.EnclaveQuote{})
+	if _, err := verifier.Verify(&Request{Record: record, MrEnclave: other}); err == nil {
+		t.Fatalf("Record with other mrenclave should be invalid")
+	}
+	if _, err := verifier.Verify(&Request{Record: record, Policy: &registry.AttestationPolicy{MrEnclaves: []string{other}}}); err == nil {
+		t.Fatalf("Record violating the policy should be invalid")
+	}
+
+	// the report was not signed by IAS
+	forged := *record
+	forged.AttestationReport.IASReportSignature = ""
+	if _, err := verifier.Verify(&Request{Record: &forged}); err == nil {
+		t.Fatalf("Forged report should be invalid")
+	}
+
+	// with collateral, the record is re-verified without trusting the key of the verifier
+	collateral, err := attestation.NewCollateral(report, verificationPK, nil, time.Now().Unix())
+	if err != nil {
+		t.Fatalf("Can not create collateral: %s", err)
+	}
+	otherIAS, _ := mock.NewSigningIAS()
+	otherPK, _ := otherIAS.GetIntelVerificationKey()
+	if result, err := NewVerifier(otherPK).Verify(&Request{Record: record, Collateral: collateral}); err != nil || !result.Valid {
+		t.Fatalf("Record should be valid with its collateral: %s", err)
+	}
+	if _, err := verifier.Verify(&Request{Record: &forged, Collateral: collateral}); err == nil {
+		t.Fatalf("Forged report should be invalid with collateral")
+	}
+
+	if _, err := verifier.Verify(&Request{}); err == nil {
+		t.Fatalf("Request without evidence should be rejected")
+	}
+}
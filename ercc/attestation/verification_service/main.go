@@ -0,0 +1,61 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	iasPubFile := flag.String("ias-pub", "", "PEM file of the IAS report signing key; defaults to the Intel production key")
+	certFile := flag.String("tls-cert", "", "TLS certificate file, issued by the org's TLS CA")
+	keyFile := flag.String("tls-key", "", "TLS key file")
+	caFiles := flag.String("tls-ca", "", "comma separated TLS CA certificate files of accepted clients, e.g., from msp/tlscacerts")
+	flag.Parse()
+
+	mTLSCredentials, err := attestation.NewMTLSCredentials(*certFile, *keyFile, strings.Split(*caFiles, ","))
+	if err != nil {
+		log.Fatalf("Can not load TLS credentials: %s", err)
+	}
+
+	iasPubPEM := []byte(attestation.IntelPubPEM)
+	if *iasPubFile != "" {
+		var err error
+		if iasPubPEM, err = ioutil.ReadFile(*iasPubFile); err != nil {
+			log.Fatalf("Can not read IAS key: %s", err)
+		}
+	}
+	verificationPK, err := attestation.PublicKeyFromPem(iasPubPEM)
+	if err != nil {
+		log.Fatalf("Can not parse IAS key: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/verify", newVerificationService(verificationPK))
+
+	// clients must authenticate with a certificate issued by one of the CAs
+	server := &http.Server{Addr: *addr, Handler: mux, TLSConfig: mTLSCredentials.ServerConfig()}
+	log.Printf("Attestation verification service listening on %s", *addr)
+	log.Fatal(server.ListenAndServeTLS("", ""))
+}
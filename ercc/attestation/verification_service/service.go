@@ -0,0 +1,59 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/verification"
+)
+
+// maximum size of a verification request; attestation evidence is a few KB
+const maxRequestSize = 1 << 20
+
+// verificationService serves a verification.Verifier over HTTP
+type verificationService struct {
+	verifier *verification.Verifier
+}
+
+func newVerificationService(verificationPK interface{}) *verificationService {
+	return &verificationService{verifier: verification.NewVerifier(verificationPK)}
+}
+
+// ServeHTTP handles POST requests with a verification.Request as body
+func (s *verificationService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request := &verification.Request{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(request); err != nil {
+		http.Error(w, fmt.Sprintf("Can not parse request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.verifier.Verify(request)
+	if err != nil {
+		result = &verification.Result{Error: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,99 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/verification"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+func verify(t *testing.T, url string, request *verification.Request) *verification.Result {
+	body, _ := json.Marshal(request)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %d", resp.StatusCode)
+	}
+	result := &verification.Result{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatalf("Can not parse result: %s", err)
+	}
+	return result
+}
+
+func TestVerificationService(t *testing.T) {
+	ias, err := mock.NewSigningIAS()
+	if err != nil {
+		t.Fatalf("Can not create IAS: %s", err)
+	}
+	_, pkBytes, err := mock.EnclaveKey()
+	if err != nil {
+		t.Fatalf("Can not load enclave key: %s", err)
+	}
+	binding := &attestation.ReportDataBinding{Nonce: attestation.TxNonce("tx1"), ChannelID: "mychannel", ChaincodeID: "ecc"}
+	quote, err := mock.NewQuote(pkBytes, binding, mock.MrEnclave, 1)
+	if err != nil {
+		t.Fatalf("Can not create quote: %s", err)
+	}
+	report, err := ias.RequestAttestationReport(tls.Certificate{}, quote)
+	if err != nil {
+		t.Fatalf("Can not get attestation report: %s", err)
+	}
+	// ercc stores the enclave pk along with the report
+	report.EnclavePk = pkBytes
+	verificationPK, _ := ias.GetIntelVerificationKey()
+
+	server := httptest.NewServer(newVerificationService(verificationPK))
+	defer server.Close()
+
+	record := &registry.EnclaveRecord{EnclavePkHash: registry.EnclavePkHash(pkBytes), AttestationReport: report, Binding: binding}
+	result := verify(t, server.URL, &verification.Request{Record: record})
+	if !result.Valid {
+		t.Fatalf("Record should be valid: %s", result.Error)
+	}
+	if result.EnclavePkHash != record.EnclavePkHash || result.MrEnclave != registry.MrEnclave(attestation.EnclaveQuote{MrEnclave: mock.MrEnclave}) || result.IsvSvn != 1 {
+		t.Fatalf("Unexpected enclave details %+v", result)
+	}
+
+	// the quote binds the transaction of the registration
+	if result := verify(t, server.URL, &verification.Request{Report: &report}); result.Valid {
+		t.Fatalf("Report without binding should be invalid")
+	}
+
+	// the report was not signed by IAS
+	forged := *record
+	forged.AttestationReport.IASReportSignature = ""
+	if result := verify(t, server.URL, &verification.Request{Record: &forged}); result.Valid {
+		t.Fatalf("Forged report should be invalid")
+	}
+
+	if resp, _ := http.Get(server.URL); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET should not be allowed")
+	}
+}
@@ -19,7 +19,6 @@ package attestation
 import (
 	"bytes"
 	"crypto"
-	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
@@ -30,7 +29,10 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
-	"reflect"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/fips"
 )
 
 // IASRequestBody sent to IAS (Intel attestation service)
@@ -64,10 +66,14 @@ type EnclaveQuote struct {
 	//Signature    []byte
 }
 
-// QuoteFromBytes parses a byte string to EnclaveQuote
+// QuoteFromBytes parses a byte string to EnclaveQuote. Only the quote body is decoded; anything following it,
+// e.g., signature length and signature, is ignored.
 func QuoteFromBytes(quoteAsBytes []byte) (EnclaveQuote, error) {
 	quote := EnclaveQuote{}
-	err := binary.Read(bytes.NewReader(quoteAsBytes), binary.LittleEndian, &quote)
+	if len(quoteAsBytes) < QuoteBodySize {
+		return quote, ErrQuoteTooShort
+	}
+	err := binary.Read(bytes.NewReader(quoteAsBytes[:QuoteBodySize]), binary.LittleEndian, &quote)
 	if err != nil {
 		return quote, err
 	}
@@ -76,6 +82,10 @@ func QuoteFromBytes(quoteAsBytes []byte) (EnclaveQuote, error) {
 
 // QuoteFromBase64 parses a byte string to EnclaveQuote
 func QuoteFromBase64(quoteBase64 string) (EnclaveQuote, error) {
+	// check the size before decoding, so that oversized input is not copied
+	if len(quoteBase64) > base64.StdEncoding.EncodedLen(MaxQuoteSize) {
+		return EnclaveQuote{}, ErrQuoteTooLarge
+	}
 	quoteAsBytes, err := base64.StdEncoding.DecodeString(quoteBase64)
 	if err != nil {
 		return EnclaveQuote{}, err
@@ -84,16 +94,38 @@ func QuoteFromBase64(quoteBase64 string) (EnclaveQuote, error) {
 }
 
 func QuoteFromAttestionReport(report IASAttestationReport) (EnclaveQuote, error) {
+	if len(report.IASReportBody) > MaxReportBodySize {
+		return EnclaveQuote{}, ErrReportBodyTooLarge
+	}
 	reportBody := IASReportBody{}
-	json.Unmarshal(report.IASReportBody, &reportBody)
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return EnclaveQuote{}, fmt.Errorf("Can not decode report body: %s", err)
+	}
 
 	quote, err := QuoteFromBase64(reportBody.IsvEnclaveQuoteBody)
 	if err != nil {
 		return EnclaveQuote{}, err
 	}
+	// reject quote formats we do not understand instead of misinterpreting their fields
+	if err := ValidateQuoteHeader(quote); err != nil {
+		return EnclaveQuote{}, err
+	}
 	return quote, nil
 }
 
+// ReportContainsQuote returns true if the report body contains the quote (without signature) the report was
+// issued for; this is checked on reports not requested by the verifier itself
+func ReportContainsQuote(report IASAttestationReport, quoteAsBytes []byte) bool {
+	if len(report.IASReportBody) > MaxReportBodySize {
+		return false
+	}
+	reportBody := IASReportBody{}
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return false
+	}
+	return MatchQuoteBody(quoteAsBytes, reportBody.IsvEnclaveQuoteBody) == nil
+}
+
 // ReportBodyT contains report body
 // type ReportBodyT struct {
 // 	CPUSVN     [16]byte
@@ -115,6 +147,8 @@ type Verifier interface {
 	VerifyAttestionReport(verificationPubKey interface{}, report IASAttestationReport) (bool, error)
 	CheckMrEnclave(mrEnclaveBase64 string, report IASAttestationReport) (bool, error)
 	CheckEnclavePkHash(pkBytes []byte, report IASAttestationReport) (bool, error)
+	CheckReportData(pkBytes []byte, binding *ReportDataBinding, report IASAttestationReport) (bool, error)
+	CheckQuoteReportData(pkBytes []byte, binding *ReportDataBinding, quoteAsBytes []byte) (bool, error)
 }
 
 // EnclaveVerifierImpl implements EnclaveVerifier interface!
@@ -123,48 +157,80 @@ type VerifierImpl struct {
 
 // VerifyAttestionReport verifies IASAttestationReport signature; also checks with intel provided key
 func (v *VerifierImpl) VerifyAttestionReport(verificationPubKey interface{}, report IASAttestationReport) (bool, error) {
+	if _, err := verifyAttestationReportAt(verificationPubKey, report, time.Time{}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyAttestationReportAt verifies the report signature and the signing certificate chain at the given time,
+// now if zero. It returns the certificates of the chain, signing certificate first.
+func verifyAttestationReportAt(verificationPubKey interface{}, report IASAttestationReport, at time.Time) ([]*x509.Certificate, error) {
+	if len(report.IASReportSigningCertificate) > MaxCertificateChainSize {
+		return nil, errors.New("Signing certificate chain too large")
+	}
+	if len(report.IASReportBody) > MaxReportBodySize {
+		return nil, ErrReportBodyTooLarge
+	}
 
 	// decode certs
-	certs, _ := url.QueryUnescape(report.IASReportSigningCertificate)
+	certs, err := url.QueryUnescape(report.IASReportSigningCertificate)
+	if err != nil {
+		return nil, errors.New("Malformed signing certificate chain: " + err.Error())
+	}
 
 	// read signing cert first
 	block, rest := pem.Decode([]byte(certs))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("No signing certificate")
+	}
 	signCert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		return false, errors.New("failed to parse signing certificate:" + err.Error())
+		return nil, errors.New("failed to parse signing certificate:" + err.Error())
 	}
 
 	// read ca cert
+	chain := append([]*x509.Certificate{signCert}, parseCertificates(rest)...)
+	if len(chain) == 1 {
+		return nil, errors.New("Failed to parse root certificate")
+	}
 	roots := x509.NewCertPool()
-	if ok := roots.AppendCertsFromPEM(rest); !ok {
-		return false, errors.New("Failed to parse root certificate")
+	for _, cert := range chain[1:] {
+		roots.AddCert(cert)
 	}
 
 	opts := x509.VerifyOptions{
-		Roots: roots,
+		Roots:       roots,
+		CurrentTime: at,
 	}
 
 	// verify signing Cert
 	if _, err := signCert.Verify(opts); err != nil {
-		return false, errors.New("Failed to verify signing certificate")
+		return nil, errors.New("Failed to verify signing certificate")
 	}
 
 	// verify response signature
-	signature, _ := base64.StdEncoding.DecodeString(report.IASReportSignature)
+	signature, err := base64.StdEncoding.DecodeString(report.IASReportSignature)
+	if err != nil {
+		return nil, errors.New("Malformed signature: " + err.Error())
+	}
 	hashedBody := sha256.Sum256(report.IASReportBody)
 
 	// check verification if its rsa key
 	rsaPublickey, ok := verificationPubKey.(*rsa.PublicKey)
 	if !ok {
-		return false, errors.New("Verification key is not of type RSA")
+		return nil, errors.New("Verification key is not of type RSA")
+	}
+	if err := fips.CheckRSAKey(rsaPublickey); err != nil {
+		return nil, errors.New("Invalid verification key: " + err.Error())
 	}
 
 	// if err = rsa.VerifyPKCS1v15(signCertPK, crypto.SHA256, hashedBody[:], signature); err != nil {
 	if err = rsa.VerifyPKCS1v15(rsaPublickey, crypto.SHA256, hashedBody[:], signature); err != nil {
-		return false, errors.New("Signature verification failed: " + err.Error())
+		return nil, errors.New("Signature verification failed: " + err.Error())
 	}
 
-	return true, nil
+	return chain, nil
 }
 
 // CheckMrEnclave returs true if mrenclave in attestation report matches the expected value. Expected value input as base64.
@@ -178,31 +244,42 @@ func (v *VerifierImpl) CheckMrEnclave(mrEnclaveBase64 string, report IASAttestat
 	if err != nil {
 		return false, err
 	}
+	if len(mrenclave) != len(quote.MrEnclave) {
+		return false, fmt.Errorf("mrenclave has %d bytes instead of %d", len(mrenclave), len(quote.MrEnclave))
+	}
 
-	return reflect.DeepEqual(mrenclave[:32], quote.MrEnclave[:32]), nil
+	return consttime.Equal(mrenclave, quote.MrEnclave[:]), nil
 }
 
-// CheckEnclavePkHash returns true if hash of enclave pk in quote matches the expected value.
+// CheckEnclavePkHash returns true if the REPORT_DATA of the quote binds the given enclave pk (DER-encoded PKIX)
+// and no further context, i.e., equals ComputeReportData(pkBytes, nil). This prevents pairing a valid quote with
+// an arbitrary key. Quotes bound to a context are checked with CheckReportData.
 func (v *VerifierImpl) CheckEnclavePkHash(pkBytes []byte, report IASAttestationReport) (bool, error) {
 	quote, err := QuoteFromAttestionReport(report)
 	if err != nil {
 		return false, err
 	}
 
-	pub, err := x509.ParsePKIXPublicKey(pkBytes)
+	return MatchReportData(pkBytes, nil, quote)
+}
+
+// CheckReportData returns true if the REPORT_DATA of the quote binds the given enclave pk (DER-encoded PKIX) and context
+func (v *VerifierImpl) CheckReportData(pkBytes []byte, binding *ReportDataBinding, report IASAttestationReport) (bool, error) {
+	quote, err := QuoteFromAttestionReport(report)
 	if err != nil {
-		return false, fmt.Errorf("x509.ParsePKIXPublicKey error %s", err)
+		return false, err
 	}
 
-	ecdsaPublickey, ok := pub.(*ecdsa.PublicKey)
-	if !ok {
-		return false, fmt.Errorf("enclave key is not ecdsa key")
-	}
+	return MatchReportData(pkBytes, binding, quote)
+}
 
-	h := sha256.New()
-	h.Write(ecdsaPublickey.X.Bytes())
-	h.Write(ecdsaPublickey.Y.Bytes())
-	enclavePkHash := h.Sum(nil)
+// CheckQuoteReportData returns true if the REPORT_DATA of a quote not yet attested by IAS binds the given enclave
+// pk (DER-encoded PKIX) and context
+func (v *VerifierImpl) CheckQuoteReportData(pkBytes []byte, binding *ReportDataBinding, quoteAsBytes []byte) (bool, error) {
+	quote, err := QuoteFromBytes(quoteAsBytes)
+	if err != nil {
+		return false, err
+	}
 
-	return reflect.DeepEqual(enclavePkHash[:32], quote.ReportData[:32]), nil
+	return MatchReportData(pkBytes, binding, quote)
 }
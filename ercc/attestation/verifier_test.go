@@ -0,0 +1,69 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func TestVerifierImpl_CheckEnclavePkHash(t *testing.T) {
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	body, _ := json.Marshal(&IASReportBody{IsvEnclaveQuoteBody: base64.StdEncoding.EncodeToString(quoteAsBytes[:432])})
+	report := IASAttestationReport{IASReportBody: body}
+	verifier := &VerifierImpl{}
+
+	pkBytes, _ := base64.StdEncoding.DecodeString(enclavePK)
+	if ok, err := verifier.CheckEnclavePkHash(pkBytes, report); err != nil || !ok {
+		t.Fatalf("Enclave pk should match quote: %v", err)
+	}
+
+	// a valid quote can not be paired with another key
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherPkBytes, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if ok, err := verifier.CheckEnclavePkHash(otherPkBytes, report); err != nil || ok {
+		t.Fatalf("Other pk should not match quote: %v", err)
+	}
+}
+
+func TestVerifierImpl_CheckQuoteReportData(t *testing.T) {
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	verifier := &VerifierImpl{}
+
+	pkBytes, _ := base64.StdEncoding.DecodeString(enclavePK)
+	if ok, err := verifier.CheckQuoteReportData(pkBytes, nil, quoteAsBytes); err != nil || !ok {
+		t.Fatalf("Enclave pk should match quote: %v", err)
+	}
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherPkBytes, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if ok, err := verifier.CheckQuoteReportData(otherPkBytes, nil, quoteAsBytes); err != nil || ok {
+		t.Fatalf("Other pk should not match quote: %v", err)
+	}
+	binding := &ReportDataBinding{Nonce: TxNonce("tx1"), ChannelID: "mychannel", ChaincodeID: "ecc"}
+	if ok, err := verifier.CheckQuoteReportData(pkBytes, binding, quoteAsBytes); err != nil || ok {
+		t.Fatalf("Unbound quote should not match binding: %v", err)
+	}
+	if _, err := verifier.CheckQuoteReportData(pkBytes, nil, quoteAsBytes[:100]); err == nil {
+		t.Fatalf("Truncated quote should be rejected")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Command verify_evidence verifies attestation evidence exported from ercc on the command line. Like the
+// verification package it is built on, it needs neither cgo nor the SGX SDK nor Fabric, so it can be cross-compiled
+// for auditors' laptops and CI pipelines, see make evidence-verifier-cross.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/verification"
+)
+
+// maximum size of a verification request; attestation evidence is a few KB
+const maxRequestSize = 1 << 20
+
+// exit codes
+const (
+	exitValid   = 0
+	exitInvalid = 1
+	exitError   = 2
+)
+
+func main() {
+	iasPubFile := flag.String("ias-pub", "", "PEM file of the IAS report signing key; defaults to the Intel production key")
+	inFile := flag.String("in", "-", "file with a verification request, as accepted by the verification service; - reads stdin")
+	flag.Parse()
+
+	iasPubPEM := []byte(attestation.IntelPubPEM)
+	if *iasPubFile != "" {
+		var err error
+		if iasPubPEM, err = ioutil.ReadFile(*iasPubFile); err != nil {
+			log.Printf("Can not read IAS key: %s", err)
+			os.Exit(exitError)
+		}
+	}
+	verificationPK, err := attestation.PublicKeyFromPem(iasPubPEM)
+	if err != nil {
+		log.Printf("Can not parse IAS key: %s", err)
+		os.Exit(exitError)
+	}
+
+	in := os.Stdin
+	if *inFile != "-" {
+		if in, err = os.Open(*inFile); err != nil {
+			log.Printf("Can not open request: %s", err)
+			os.Exit(exitError)
+		}
+		defer in.Close()
+	}
+
+	result, err := verify(verification.NewVerifier(verificationPK), in)
+	if err != nil {
+		log.Printf("%s", err)
+		os.Exit(exitError)
+	}
+	resultAsBytes, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(resultAsBytes))
+	if !result.Valid {
+		os.Exit(exitInvalid)
+	}
+}
+
+// verify reads a verification.Request from in and verifies it. Invalid evidence is reported in the result; an error
+// is only returned if the request can not be read.
+func verify(verifier *verification.Verifier, in io.Reader) (*verification.Result, error) {
+	request := &verification.Request{}
+	if err := json.NewDecoder(io.LimitReader(in, maxRequestSize)).Decode(request); err != nil {
+		return nil, fmt.Errorf("Can not parse request: %s", err)
+	}
+
+	result, err := verifier.Verify(request)
+	if err != nil {
+		result = &verification.Result{Error: err.Error()}
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/verification"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+)
+
+func TestVerify(t *testing.T) {
+	ias, err := mock.NewSigningIAS()
+	if err != nil {
+		t.Fatalf("Can not create IAS: %s", err)
+	}
+	_, pkBytes, err := mock.EnclaveKey()
+	if err != nil {
+		t.Fatalf("Can not load enclave key: %s", err)
+	}
+	binding := &attestation.ReportDataBinding{Nonce: attestation.TxNonce("tx1"), ChannelID: "mychannel", ChaincodeID: "ecc"}
+	quote, err := mock.NewQuote(pkBytes, binding, mock.MrEnclave, 1)
+	if err != nil {
+		t.Fatalf("Can not create quote: %s", err)
+	}
+	report, err := ias.RequestAttestationReport(tls.Certificate{}, quote)
+	if err != nil {
+		t.Fatalf("Can not get attestation report: %s", err)
+	}
+	// ercc stores the enclave pk along with the report
+	report.EnclavePk = pkBytes
+	verificationPK, _ := ias.GetIntelVerificationKey()
+	verifier := verification.NewVerifier(verificationPK)
+
+	record := &registry.EnclaveRecord{EnclavePkHash: registry.EnclavePkHash(pkBytes), AttestationReport: report, Binding: binding}
+	request, _ := json.Marshal(&verification.Request{Record: record})
+	result, err := verify(verifier, bytes.NewReader(request))
+	if err != nil || !result.Valid {
+		t.Fatalf("Record should be valid: %v %v", err, result)
+	}
+
+	forged := *record
+	forged.AttestationReport.IASReportSignature = ""
+	request, _ = json.Marshal(&verification.Request{Record: &forged})
+	if result, err := verify(verifier, bytes.NewReader(request)); err != nil || result.Valid || result.Error == "" {
+		t.Fatalf("Forged report should be reported invalid: %v %v", err, result)
+	}
+
+	if _, err := verify(verifier, strings.NewReader("not json")); err == nil {
+		t.Fatalf("Malformed request should be rejected")
+	}
+}
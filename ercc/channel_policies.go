@@ -0,0 +1,62 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// tlccName is the trusted ledger system chaincode which evaluates channel policies on behalf of ercc
+const tlccName = "tlcc"
+
+// ChannelPolicies evaluates transactions against the policies of the channel configuration, e.g., the policies
+// referenced by the resource ACLs of the channel
+type ChannelPolicies interface {
+	// CheckPolicy returns an error if the transaction of stub does not satisfy the policy
+	CheckPolicy(stub shim.ChaincodeStubInterface, policy string) error
+}
+
+// tlccPolicies asks tlcc, which as system chaincode has access to the channel configuration, to evaluate the signed
+// proposal of the transaction
+type tlccPolicies struct{}
+
+// CheckPolicy invokes CHECK_POLICY of tlcc
+func (p *tlccPolicies) CheckPolicy(stub shim.ChaincodeStubInterface, policy string) error {
+	channelID := stub.GetChannelID()
+	resp := stub.InvokeChaincode(tlccName, [][]byte{[]byte("CHECK_POLICY"), []byte(policy)}, channelID)
+	if resp.Status != shim.OK {
+		return errors.New(resp.Message)
+	}
+	return nil
+}
+
+// stubPolicies binds ChannelPolicies to the transaction for evaluating ACLs
+type stubPolicies struct {
+	policies ChannelPolicies
+	stub     shim.ChaincodeStubInterface
+}
+
+// SatisfiesPolicy fails closed, i.e., a policy that can not be evaluated is not satisfied
+func (p *stubPolicies) SatisfiesPolicy(policy string) bool {
+	if err := p.policies.CheckPolicy(p.stub, policy); err != nil {
+		logger.Debugf("ercc: policy %s not satisfied: %s", policy, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ClientKey is the long-term public key of a client application bound to its MSP identity.
+// Enclaves use it to encrypt responses and events to known clients.
+type ClientKey struct {
+	MspID     string `json:"MspID"`
+	ClientID  string `json:"ClientID"`
+	PublicKey []byte `json:"PublicKey"`
+}
+
+// ============================================================
+// registerClientKey -
+// ============================================================
+func (ercc *EnclaveRegistryCC) registerClientKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: clientPkBase64 (DER-encoded PKIX)
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting client pk")
+	}
+
+	clientPk, err := base64.StdEncoding.DecodeString(args[0])
+	if err != nil {
+		return shim.Error("Can not parse clientPkBase64: " + err.Error())
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(clientPk)
+	if err != nil {
+		return shim.Error("Can not parse client pk: " + err.Error())
+	}
+	if _, ok := pub.(*ecdsa.PublicKey); !ok {
+		return shim.Error("Client key is not ecdsa key")
+	}
+
+	// the key is bound to the identity of the submitting client
+	identity, err := cid.New(stub)
+	if err != nil {
+		return shim.Error("Can not get client identity: " + err.Error())
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return shim.Error("Can not get client msp id: " + err.Error())
+	}
+	clientID, err := identity.GetID()
+	if err != nil {
+		return shim.Error("Can not get client id: " + err.Error())
+	}
+
+	clientKeyAsBytes, err := registry.MarshalCanonical(&ClientKey{MspID: mspID, ClientID: clientID, PublicKey: clientPk})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.ClientKeyObjectType, []string{mspID, clientID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := stub.PutState(key, clientKeyAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(clientKeyAsBytes)
+}
+
+// ============================================================
+// getClientKey -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getClientKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0        1
+	// "mspID", "clientID"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting msp id and client id")
+	}
+
+	key, err := stub.CreateCompositeKey(registry.ClientKeyObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	clientKeyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get client key for " + args[1])
+	} else if clientKeyAsBytes == nil {
+		return shim.Error("Client key does not exist: " + args[1])
+	}
+
+	return shim.Success(clientKeyAsBytes)
+}
@@ -0,0 +1,137 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// advanceLedgerClock -
+// ============================================================
+func (ercc *EnclaveRegistryCC) advanceLedgerClock(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// no args
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting none")
+	}
+
+	clock, err := ercc.advanceClock(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	clockAsBytes, err := registry.MarshalCanonical(clock)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(clockAsBytes)
+}
+
+// ============================================================
+// getLedgerClock -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getLedgerClock(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// no args
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting none")
+	}
+
+	clock, err := getLedgerClock(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if clock == nil {
+		return shim.Error("Ledger clock not started")
+	}
+
+	clockAsBytes, err := registry.MarshalCanonical(clock)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(clockAsBytes)
+}
+
+// advanceClock advances the ledger clock to the time of the transaction and the current block height
+func (ercc *EnclaveRegistryCC) advanceClock(stub shim.ChaincodeStubInterface) (*registry.LedgerClock, error) {
+	if ercc.ledger == nil {
+		return nil, errors.New("Block height not available to advance the ledger clock")
+	}
+	height, err := ercc.ledger.BlockHeight(stub)
+	if err != nil {
+		return nil, errors.New("Can not get block height: " + err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	clock, err := getLedgerClock(stub)
+	if err != nil {
+		return nil, err
+	}
+	clock = clock.Advance(txTime, height)
+
+	clockAsBytes, err := registry.MarshalCanonical(clock)
+	if err != nil {
+		return nil, err
+	}
+	key, err := stub.CreateCompositeKey(registry.LedgerClockObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	if err := stub.PutState(key, clockAsBytes); err != nil {
+		return nil, err
+	}
+	return clock, nil
+}
+
+// getLedgerClock returns the ledger clock or nil if it has not been started yet
+func getLedgerClock(stub shim.ChaincodeStubInterface) (*registry.LedgerClock, error) {
+	key, err := stub.CreateCompositeKey(registry.LedgerClockObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	clockAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if clockAsBytes == nil {
+		return nil, nil
+	}
+
+	clock := &registry.LedgerClock{}
+	if err := json.Unmarshal(clockAsBytes, clock); err != nil {
+		return nil, err
+	}
+	return clock, nil
+}
+
+// getLedgerTime returns the time of the ledger clock, which the ecc vscc uses as well, or 0 if the clock has not
+// been started yet
+func getLedgerTime(stub shim.ChaincodeStubInterface) (int64, error) {
+	clock, err := getLedgerClock(stub)
+	if err != nil || clock == nil {
+		return 0, err
+	}
+	return clock.Time, nil
+}
@@ -0,0 +1,175 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// putCRL -
+// ============================================================
+func (ercc *EnclaveRegistryCC) putCRL(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: CRL (PEM or DER), e.g., of the IAS report signing CA
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting CRL")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	crl, der, err := attestation.ParseCRL([]byte(args[0]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	issuer, err := asn1.Marshal(crl.TBSCertList.Issuer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	issuerHash := sha256.Sum256(issuer)
+	key, err := stub.CreateCompositeKey(registry.CRLObjectType, []string{base64.StdEncoding.EncodeToString(issuerHash[:])})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// one CRL per issuer; never go back to an older one
+	oldDER, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if oldDER != nil {
+		oldCRL, _, err := attestation.ParseCRL(oldDER)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !crl.TBSCertList.ThisUpdate.After(oldCRL.TBSCertList.ThisUpdate) {
+			return shim.Error("CRL is not newer than the stored CRL of its issuer")
+		}
+	}
+
+	if err := stub.PutState(key, der); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getCollateral -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getCollateral(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "enclavePkHashBase64"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash")
+	}
+
+	collateral, err := getCollateral(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if collateral == nil {
+		return shim.Error("No collateral for " + args[0])
+	}
+
+	collateralAsBytes, err := registry.MarshalCanonical(collateral)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(collateralAsBytes)
+}
+
+// putCollateral stores the collateral a registration was verified with, i.e., the verification key, the report
+// signing chain and the CRLs of the chain issuers, so that it can be re-verified offline later
+func putCollateral(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, report attestation.IASAttestationReport, verificationPK interface{}) error {
+	now, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	crls, err := getCRLs(stub)
+	if err != nil {
+		return err
+	}
+	collateral, err := attestation.NewCollateral(report, verificationPK, crls, now)
+	if err != nil {
+		return errors.New("Can not create collateral: " + err.Error())
+	}
+
+	collateralAsBytes, err := registry.MarshalCanonical(collateral)
+	if err != nil {
+		return err
+	}
+	compressedCollateral, err := registry.CompressEvidence(collateralAsBytes)
+	if err != nil {
+		return errors.New("Can not compress collateral: " + err.Error())
+	}
+	key, err := stub.CreateCompositeKey(registry.CollateralObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, compressedCollateral)
+}
+
+// getCRLs returns the CRLs put by admins
+func getCRLs(stub shim.ChaincodeStubInterface) ([][]byte, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(registry.CRLObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var crls [][]byte
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, kv.Value)
+	}
+	return crls, nil
+}
+
+// getCollateral returns the stored collateral of a registration, nil if there is none
+func getCollateral(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) (*attestation.Collateral, error) {
+	key, err := stub.CreateCompositeKey(registry.CollateralObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return nil, err
+	}
+	collateralAsBytes, err := stub.GetState(key)
+	if err != nil || collateralAsBytes == nil {
+		return nil, err
+	}
+	collateralAsBytes, err = registry.DecompressEvidence(collateralAsBytes)
+	if err != nil {
+		return nil, errors.New("Can not decompress collateral: " + err.Error())
+	}
+	collateral := &attestation.Collateral{}
+	if err := json.Unmarshal(collateralAsBytes, collateral); err != nil {
+		return nil, err
+	}
+	return collateral, nil
+}
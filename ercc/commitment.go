@@ -0,0 +1,124 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// putStateCommitment -
+// ============================================================
+func (ercc *EnclaveRegistryCC) putStateCommitment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeName
+	// 1: rootBase64, the Merkle root over the state of the chaincode
+	// 2: size, the number of keys
+	// ecc calls this function from commitState, which computes the root in the same transaction
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name, root and size")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	root, err := base64.StdEncoding.DecodeString(args[1])
+	if err != nil || len(root) != 32 {
+		return shim.Error("Invalid root; expecting a base64 encoded sha256 hash")
+	}
+	size, err := strconv.Atoi(args[2])
+	if err != nil || size < 0 {
+		return shim.Error("Invalid size: " + args[2])
+	}
+
+	key, err := stub.CreateCompositeKey(registry.StateCommitmentObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	previous, err := getStateCommitment(stub, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	commitment := &registry.StateCommitment{
+		ChaincodeName: args[0],
+		Root:          args[1],
+		Size:          size,
+		Sequence:      1,
+		TxID:          stub.GetTxID(),
+		CommittedAt:   now,
+	}
+	if previous != nil {
+		commitment.Sequence = previous.Sequence + 1
+	}
+	commitmentAsBytes, err := registry.MarshalCanonical(commitment)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, commitmentAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(commitmentAsBytes)
+}
+
+// ============================================================
+// getStateCommitment -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getStateCommitment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "chaincodeName"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name")
+	}
+
+	key, err := stub.CreateCompositeKey(registry.StateCommitmentObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	commitmentAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get state commitment for " + args[0])
+	} else if commitmentAsBytes == nil {
+		return shim.Error("No state commitment published for " + args[0])
+	}
+	return shim.Success(commitmentAsBytes)
+}
+
+// getStateCommitment returns the state commitment stored under key, nil if there is none
+func getStateCommitment(stub shim.ChaincodeStubInterface, key string) (*registry.StateCommitment, error) {
+	commitmentAsBytes, err := stub.GetState(key)
+	if err != nil || commitmentAsBytes == nil {
+		return nil, err
+	}
+	commitment := &registry.StateCommitment{}
+	if err := json.Unmarshal(commitmentAsBytes, commitment); err != nil {
+		return nil, err
+	}
+	return commitment, nil
+}
@@ -0,0 +1,267 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// setRegistrationQuorum -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setRegistrationQuorum(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: number of orgs that must verify a registration before the enclave becomes active
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting number of orgs")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	requiredOrgs, err := strconv.Atoi(args[0])
+	if err != nil || requiredOrgs < 1 {
+		return shim.Error("Invalid number of orgs: " + args[0])
+	}
+
+	quorumAsBytes, err := registry.MarshalCanonical(&registry.RegistrationQuorum{RequiredOrgs: requiredOrgs})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.QuorumObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, quorumAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// confirmEnclave -
+// ============================================================
+func (ercc *EnclaveRegistryCC) confirmEnclave(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkHashBase64
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash")
+	}
+	enclavePkHashBase64 := args[0]
+
+	pending, err := getPendingRegistration(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if pending == nil {
+		return shim.Error("No pending registration for " + enclavePkHashBase64)
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Can not get client msp id: " + err.Error())
+	}
+	if pending.IsConfirmedBy(mspID) {
+		return shim.Error("Registration already confirmed by " + mspID)
+	}
+
+	// verify the attestation evidence independently of the registrar
+	report, err := getAttestationReport(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if _, err := ercc.verifyAttestationReport(stub, report); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	isValid, err := ercc.ra.CheckEnclavePkHash(report.EnclavePk, report)
+	if err != nil {
+		return shim.Error("Error while checking enclave PK: " + err.Error())
+	}
+	if !isValid {
+		return shim.Error("Enclave PK does not match attestation report!")
+	}
+
+	pending.ConfirmedBy = append(pending.ConfirmedBy, mspID)
+
+	quorum, err := getRegistrationQuorum(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.PendingObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// enclave becomes active once enough orgs confirmed
+	if quorum == nil || len(pending.ConfirmedBy) >= quorum.RequiredOrgs {
+		if err := stub.DelState(key); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+
+	pendingAsBytes, err := registry.MarshalCanonical(pending)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, pendingAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pendingAsBytes)
+}
+
+// ============================================================
+// getPendingRegistration -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getPendingRegistration(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "enclavePkHashBase64"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash")
+	}
+
+	pending, err := getPendingRegistration(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if pending == nil {
+		return shim.Error("No pending registration for " + args[0])
+	}
+
+	pendingAsBytes, err := registry.MarshalCanonical(pending)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pendingAsBytes)
+}
+
+// createPendingRegistration marks a new registration as pending if a registration quorum is set.
+// The registrar counts as the first confirmation.
+func createPendingRegistration(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) error {
+	quorum, err := getRegistrationQuorum(stub)
+	if err != nil {
+		return err
+	} else if quorum == nil || quorum.RequiredOrgs <= 1 {
+		return nil
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return errors.New("Can not get client msp id: " + err.Error())
+	}
+
+	pendingAsBytes, err := registry.MarshalCanonical(&registry.PendingRegistration{
+		EnclavePkHash: enclavePkHashBase64,
+		ConfirmedBy:   []string{mspID},
+	})
+	if err != nil {
+		return err
+	}
+
+	key, err := stub.CreateCompositeKey(registry.PendingObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, pendingAsBytes)
+}
+
+// confirmPendingRegistration adds the confirmations of the given orgs to a pending registration and activates the
+// enclave once the quorum is reached. Registrations that are not pending are left as they are.
+func confirmPendingRegistration(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, mspIDs []string) error {
+	pending, err := getPendingRegistration(stub, enclavePkHashBase64)
+	if err != nil || pending == nil {
+		return err
+	}
+	for _, mspID := range mspIDs {
+		if !pending.IsConfirmedBy(mspID) {
+			pending.ConfirmedBy = append(pending.ConfirmedBy, mspID)
+		}
+	}
+
+	quorum, err := getRegistrationQuorum(stub)
+	if err != nil {
+		return err
+	}
+
+	key, err := stub.CreateCompositeKey(registry.PendingObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	if quorum == nil || len(pending.ConfirmedBy) >= quorum.RequiredOrgs {
+		return stub.DelState(key)
+	}
+
+	pendingAsBytes, err := registry.MarshalCanonical(pending)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, pendingAsBytes)
+}
+
+func getRegistrationQuorum(stub shim.ChaincodeStubInterface) (*registry.RegistrationQuorum, error) {
+	key, err := stub.CreateCompositeKey(registry.QuorumObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+
+	quorumAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if quorumAsBytes == nil {
+		return nil, nil
+	}
+
+	quorum := &registry.RegistrationQuorum{}
+	if err := json.Unmarshal(quorumAsBytes, quorum); err != nil {
+		return nil, err
+	}
+	return quorum, nil
+}
+
+func getPendingRegistration(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) (*registry.PendingRegistration, error) {
+	key, err := stub.CreateCompositeKey(registry.PendingObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return nil, err
+	}
+
+	pendingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if pendingAsBytes == nil {
+		return nil, nil
+	}
+
+	pending := &registry.PendingRegistration{}
+	if err := json.Unmarshal(pendingAsBytes, pending); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
@@ -0,0 +1,478 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	ecccrypto "github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// approveDelegation -
+// ============================================================
+func (ercc *EnclaveRegistryCC) approveDelegation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeID (delegator)
+	// 1: mrEnclaveBase64 (delegator)
+	// 2: delegateeMrEnclaveBase64
+	// 3: approval (json encoded registry.Approval of the registry.DelegationStatement, signed by the admin)
+	//
+	// Each admin adds the approval of its MSP; the delegation takes effect once a quorum of channel MSPs approved it
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id, mrenclave, delegatee mrenclave and approval")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	chaincodeID := args[0]
+	if chaincodeID == "" {
+		return shim.Error("Chaincode id must not be empty")
+	}
+
+	for _, mrEnclaveBase64 := range args[1:3] {
+		mrEnclave, err := base64.StdEncoding.DecodeString(mrEnclaveBase64)
+		if err != nil {
+			return shim.Error("Can not parse mrEnclaveBase64: " + err.Error())
+		}
+		if len(mrEnclave) != 32 {
+			return shim.Error("Invalid mrenclave size")
+		}
+	}
+
+	statement := registry.DelegationStatement(stub.GetChannelID(), chaincodeID, args[1], args[2])
+	approval, _, err := ercc.checkChannelApproval(stub, args[3], statement)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.DelegationObjectType, []string{chaincodeID, args[1], args[2]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	delegation := &registry.DelegationApproval{ChaincodeID: chaincodeID, MrEnclave: args[1], Delegatee: args[2], ApprovedAt: txTime}
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if existingAsBytes != nil {
+		existing := &registry.DelegationApproval{}
+		if err := json.Unmarshal(existingAsBytes, existing); err != nil {
+			return shim.Error(err.Error())
+		}
+		delegation.Approvals = existing.Approvals
+	}
+	delegation.Approvals = registry.AddApproval(delegation.Approvals, approval)
+
+	approvalAsBytes, err := registry.MarshalCanonical(delegation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := stub.PutState(key, approvalAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getDelegation -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getDelegation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeID (delegator)
+	// 1: mrEnclaveBase64 (delegator)
+	// 2: delegateeMrEnclaveBase64
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id, mrenclave and delegatee mrenclave")
+	}
+
+	key, err := stub.CreateCompositeKey(registry.DelegationObjectType, []string{args[0], args[1], args[2]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	approvalAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get delegation approval for " + args[1])
+	} else if approvalAsBytes == nil {
+		return shim.Error("No delegation approved from " + args[1] + " to " + args[2])
+	}
+
+	return shim.Success(approvalAsBytes)
+}
+
+// ============================================================
+// revokeDelegation -
+// ============================================================
+func (ercc *EnclaveRegistryCC) revokeDelegation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeID (delegator)
+	// 1: mrEnclaveBase64 (delegator)
+	// 2: delegateeMrEnclaveBase64
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id, mrenclave and delegatee mrenclave")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.DelegationObjectType, []string{args[0], args[1], args[2]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	approvalAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get delegation approval for " + args[1])
+	} else if approvalAsBytes == nil {
+		return shim.Error("No delegation approved from " + args[1] + " to " + args[2])
+	}
+
+	// the admin of any MSP may withdraw a delegation; re-encryption keys stay on the ledger but are refused
+	// without approval
+	if err := stub.DelState(key); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// putReEncryptionKey -
+// ============================================================
+func (ercc *EnclaveRegistryCC) putReEncryptionKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeID (delegator)
+	// 1: enclavePkHashBase64 (delegator)
+	// 2: delegateePkHashBase64
+	// 3: mrEnclaveBase64 (delegator)
+	// 4: delegateeMrEnclaveBase64
+	// 5: delegationPkBase64
+	// 6: keyBase64
+	if len(args) != 7 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id, enclave pk hash, delegatee pk hash, mrenclave, delegatee mrenclave, delegation pk and key")
+	}
+
+	delegationPk, err := base64.StdEncoding.DecodeString(args[5])
+	if err != nil {
+		return shim.Error("Can not parse delegationPkBase64: " + err.Error())
+	}
+	if _, err := ecccrypto.EnclavePk2ECDSAPK(delegationPk); err != nil {
+		return shim.Error("Invalid delegation pk: " + err.Error())
+	}
+
+	key, err := base64.StdEncoding.DecodeString(args[6])
+	if err != nil {
+		return shim.Error("Can not parse keyBase64: " + err.Error())
+	}
+	// re-encrypting the delegation pk checks the key is a valid scalar
+	if _, err := ecccrypto.ReEncrypt(key, delegationPk); err != nil {
+		return shim.Error("Invalid re-encryption key: " + err.Error())
+	}
+
+	rk := &registry.ReEncryptionKey{
+		ChaincodeID:        args[0],
+		DelegatorPkHash:    args[1],
+		DelegateePkHash:    args[2],
+		MrEnclave:          args[3],
+		DelegateeMrEnclave: args[4],
+		DelegationPk:       delegationPk,
+		Key:                key,
+	}
+
+	// check both enclaves run the binaries the approval refers to
+	for _, party := range [][2]string{{rk.DelegatorPkHash, rk.MrEnclave}, {rk.DelegateePkHash, rk.DelegateeMrEnclave}} {
+		report, err := getAttestationReport(stub, party[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		isValid, err := ercc.ra.CheckMrEnclave(party[1], report)
+		if err != nil {
+			return shim.Error("Error while checking mrenclave: " + err.Error())
+		}
+		if !isValid {
+			return shim.Error("Mrenclave does not match attestation report of enclave " + party[0])
+		}
+	}
+
+	// the delegator must be registered for the chaincode of the delegation
+	if err := checkBinding(stub, rk.DelegatorPkHash, rk.ChaincodeID); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := ercc.checkDelegation(stub, rk); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	rkAsBytes, err := registry.MarshalCanonical(rk)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	rkKey, err := stub.CreateCompositeKey(registry.ReEncryptionKeyObjectType, []string{rk.DelegatorPkHash, rk.DelegateePkHash})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := stub.PutState(rkKey, rkAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// reEncrypt -
+// ============================================================
+func (ercc *EnclaveRegistryCC) reEncrypt(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkHashBase64 (delegator)
+	// 1: delegateePkHashBase64
+	// 2: ephemeralPkBase64
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash, delegatee pk hash and ephemeral pk")
+	}
+
+	ephemeralPk, err := base64.StdEncoding.DecodeString(args[2])
+	if err != nil {
+		return shim.Error("Can not parse ephemeralPkBase64: " + err.Error())
+	}
+
+	rk, err := ercc.getReEncryptionKey(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	reEncryptedPk, err := ecccrypto.ReEncrypt(rk.Key, ephemeralPk)
+	if err != nil {
+		return shim.Error("Can not re-encrypt: " + err.Error())
+	}
+
+	// the ciphertext does not change and is not needed to re-encrypt
+	delegatedAsBytes, err := registry.MarshalCanonical(&registry.DelegatedSecret{
+		DelegatorPkHash: rk.DelegatorPkHash,
+		DelegationPk:    rk.DelegationPk,
+		EphemeralPk:     reEncryptedPk,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(delegatedAsBytes)
+}
+
+// ============================================================
+// delegateSecret -
+// ============================================================
+func (ercc *EnclaveRegistryCC) delegateSecret(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkHashBase64 (delegator)
+	// 1: delegateePkHashBase64
+	// 2: secretName
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash, delegatee pk hash and secret name")
+	}
+
+	rk, err := ercc.getReEncryptionKey(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	secretKey, err := stub.CreateCompositeKey(registry.SecretObjectType, []string{args[0], args[2]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	secretAsBytes, err := stub.GetState(secretKey)
+	if err != nil {
+		return shim.Error("Failed to get secret " + args[2])
+	} else if secretAsBytes == nil {
+		return shim.Error("Secret does not exist: " + args[2])
+	}
+	secret := &ProvisionedSecret{}
+	if err := json.Unmarshal(secretAsBytes, secret); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	reEncryptedPk, err := ecccrypto.ReEncrypt(rk.Key, secret.EphemeralPk)
+	if err != nil {
+		return shim.Error("Can not re-encrypt: " + err.Error())
+	}
+
+	delegatedAsBytes, err := registry.MarshalCanonical(&registry.DelegatedSecret{
+		DelegatorPkHash: rk.DelegatorPkHash,
+		DelegationPk:    rk.DelegationPk,
+		EphemeralPk:     reEncryptedPk,
+		Ciphertext:      secret.Ciphertext,
+		Approval:        secret.Approval,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	delegatedKey, err := stub.CreateCompositeKey(registry.DelegatedSecretObjectType, []string{args[1], args[2]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(delegatedKey, delegatedAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getDelegatedSecret -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getDelegatedSecret(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0                         1
+	// "delegateePkHashBase64", "secretName"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting delegatee pk hash and secret name")
+	}
+
+	key, err := stub.CreateCompositeKey(registry.DelegatedSecretObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	delegatedAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get delegated secret " + args[1])
+	} else if delegatedAsBytes == nil {
+		return shim.Error("Delegated secret does not exist: " + args[1])
+	}
+
+	return shim.Success(delegatedAsBytes)
+}
+
+// getReEncryptionKey returns the re-encryption key between two enclaves if the delegation is still approved
+func (ercc *EnclaveRegistryCC) getReEncryptionKey(stub shim.ChaincodeStubInterface, delegatorPkHashBase64, delegateePkHashBase64 string) (*registry.ReEncryptionKey, error) {
+	key, err := stub.CreateCompositeKey(registry.ReEncryptionKeyObjectType, []string{delegatorPkHashBase64, delegateePkHashBase64})
+	if err != nil {
+		return nil, err
+	}
+	rkAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get re-encryption key for " + delegatorPkHashBase64)
+	} else if rkAsBytes == nil {
+		return nil, errors.New("No re-encryption key from " + delegatorPkHashBase64 + " to " + delegateePkHashBase64)
+	}
+
+	rk := &registry.ReEncryptionKey{}
+	if err := json.Unmarshal(rkAsBytes, rk); err != nil {
+		return nil, err
+	}
+
+	if err := ercc.checkDelegation(stub, rk); err != nil {
+		return nil, err
+	}
+	return rk, nil
+}
+
+// checkDelegation returns an error unless both enclaves of a re-encryption key are registered, neither retired
+// nor revoked, and a quorum of the channel MSPs approved the delegation between their mrenclaves
+func (ercc *EnclaveRegistryCC) checkDelegation(stub shim.ChaincodeStubInterface, rk *registry.ReEncryptionKey) error {
+	if rk.DelegatorPkHash == rk.DelegateePkHash {
+		return errors.New("Enclave can not delegate to itself")
+	}
+
+	for _, enclavePkHashBase64 := range []string{rk.DelegatorPkHash, rk.DelegateePkHash} {
+		if err := checkDelegationParty(stub, enclavePkHashBase64); err != nil {
+			return err
+		}
+	}
+
+	key, err := stub.CreateCompositeKey(registry.DelegationObjectType, []string{rk.ChaincodeID, rk.MrEnclave, rk.DelegateeMrEnclave})
+	if err != nil {
+		return err
+	}
+	approvalAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get delegation approval for " + rk.MrEnclave)
+	} else if approvalAsBytes == nil {
+		return errors.New("No delegation approved from " + rk.MrEnclave + " to " + rk.DelegateeMrEnclave)
+	}
+	delegation := &registry.DelegationApproval{}
+	if err := json.Unmarshal(approvalAsBytes, delegation); err != nil {
+		return err
+	}
+
+	msps, err := ercc.channelMSPs(stub)
+	if err != nil {
+		return err
+	}
+	statement := registry.DelegationStatement(stub.GetChannelID(), rk.ChaincodeID, rk.MrEnclave, rk.DelegateeMrEnclave)
+	if err := registry.VerifyQuorum(delegation.Approvals, statement, msps); err != nil {
+		return errors.New("Delegation not approved: " + err.Error())
+	}
+	return nil
+}
+
+// checkDelegationParty returns an error unless the enclave is registered and neither retired nor revoked
+func checkDelegationParty(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) error {
+	reportAsBytes, err := stub.GetState(enclavePkHashBase64)
+	if err != nil {
+		return errors.New("Failed to get state for " + enclavePkHashBase64)
+	} else if reportAsBytes == nil {
+		return errors.New("EnclavePK does not exist: " + enclavePkHashBase64)
+	}
+
+	retiredKey, err := stub.CreateCompositeKey(registry.RetiredObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	retired, err := stub.GetState(retiredKey)
+	if err != nil {
+		return err
+	} else if retired != nil {
+		return errors.New("Enclave retired: " + enclavePkHashBase64)
+	}
+
+	statusKey, err := stub.CreateCompositeKey(registry.StatusObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	statusAsBytes, err := stub.GetState(statusKey)
+	if err != nil {
+		return err
+	} else if statusAsBytes != nil {
+		status := &registry.EnclaveStatus{}
+		if err := json.Unmarshal(statusAsBytes, status); err != nil {
+			return err
+		}
+		if status.IsRevoked() {
+			return errors.New("Enclave revoked: " + enclavePkHashBase64)
+		}
+	}
+	return nil
+}
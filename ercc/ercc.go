@@ -20,10 +20,12 @@ import (
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/base64"
-	"encoding/json"
+	"os"
 
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/iasproxy"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -31,18 +33,47 @@ import (
 
 var logger = shim.NewLogger("ercc")
 
+// IASProxyAddressEnv names the environment variable with the address of the ias-proxy. If set, ercc sends
+// quotes to the proxy, which holds the IAS credentials, instead of contacting IAS directly. ercc authenticates
+// to the proxy with the TLS certificate of the peer (see attestation.NewMTLSCredentialsFromPeerConfig).
+const IASProxyAddressEnv = "IAS_PROXY_ADDRESS"
+
 // EnclaveRegistryCC ...
 type EnclaveRegistryCC struct {
 	ra  attestation.Verifier
 	ias attestation.IntelAttestationService
+	// iasProxied is set if IAS credentials are held by the ias-proxy
+	iasProxied bool
+	// lifecycle resolves the chaincodes enclaves are bound to; nil skips the check
+	lifecycle ChaincodeLifecycle
+	// policies evaluates channel policies named in function ACLs; nil denies principals with a policy
+	policies ChannelPolicies
+	// ledger reports the block height trusted roots are selected at; nil fails verification once roots are governed
+	ledger ChannelLedger
 }
 
 // NewErcc is a helpful factory method for creating this beauty
 func NewErcc() *EnclaveRegistryCC {
-	return &EnclaveRegistryCC{
-		ra:  &attestation.VerifierImpl{},
-		ias: attestation.NewIAS(),
+	ercc := &EnclaveRegistryCC{
+		ra:        &attestation.VerifierImpl{},
+		ias:       attestation.NewIAS(),
+		lifecycle: &lsccLifecycle{},
+		policies:  &tlccPolicies{},
+		ledger:    &tlccLedger{},
 	}
+	if address := os.Getenv(IASProxyAddressEnv); address != "" {
+		mTLSCredentials, err := attestation.NewMTLSCredentialsFromPeerConfig()
+		if err != nil {
+			panic("Can not load TLS credentials for ias-proxy: " + err.Error())
+		}
+		client, err := iasproxy.NewClient(address, mTLSCredentials, nil)
+		if err != nil {
+			panic("Can not connect to ias-proxy: " + err.Error())
+		}
+		ercc.ias = client
+		ercc.iasProxied = true
+	}
+	return ercc
 }
 
 func NewTestErcc() *EnclaveRegistryCC {
@@ -62,12 +93,183 @@ func (ercc *EnclaveRegistryCC) Invoke(stub shim.ChaincodeStubInterface) pb.Respo
 	function, args := stub.GetFunctionAndParameters()
 	logger.Debug("ercc: invoke is running " + function)
 
+	// ACLs governed on the ledger restrict who may invoke a function
+	if err := ercc.checkFunctionACL(stub, function); err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "registerEnclave" {
 		return ercc.registerEnclave(stub, args)
+	} else if function == "registerBoundEnclave" { // register enclave whose quote is bound to channel and chaincode
+		return ercc.registerBoundEnclave(stub, args)
+	} else if function == "registerEnclaveWithReport" { // register enclave with attestation report obtained by the client
+		return ercc.registerEnclaveWithReport(stub, args)
+	} else if function == "registerBoundEnclaveWithReport" { // register enclave with bound quote and attestation report obtained by the client
+		return ercc.registerBoundEnclaveWithReport(stub, args)
 	} else if function == "getAttestationReport" { //get enclave attestation report
 		return ercc.getAttestationReport(stub, args)
 	} else if function == "getSPID" { //get SPID
 		return ercc.getSPID(stub, args)
+	} else if function == "provisionSecret" { // store secret for a registered enclave
+		return ercc.provisionSecret(stub, args)
+	} else if function == "getSecret" { // get secret provisioned to an enclave
+		return ercc.getSecret(stub, args)
+	} else if function == "registerClientKey" { // bind client pk to the invoking identity
+		return ercc.registerClientKey(stub, args)
+	} else if function == "getClientKey" { // get client pk by msp id and client id
+		return ercc.getClientKey(stub, args)
+	} else if function == "approveUpgrade" { // approve successor mrenclave
+		return ercc.approveUpgrade(stub, args)
+	} else if function == "getUpgrade" { // get approved successor mrenclave
+		return ercc.getUpgrade(stub, args)
+	} else if function == "handoverState" { // hand over state key to successor and retire enclave
+		return ercc.handoverState(stub, args)
+	} else if function == "getStateHandover" { // get state key handed over to successor
+		return ercc.getStateHandover(stub, args)
+	} else if function == "setMinIsvSvn" { // set minimum isv svn for enclaves of a chaincode
+		return ercc.setMinIsvSvn(stub, args)
+	} else if function == "getMinIsvSvn" { // get minimum isv svn for enclaves of a chaincode
+		return ercc.getMinIsvSvn(stub, args)
+	} else if function == "advanceLedgerClock" { // advance ledger clock to the transaction time
+		return ercc.advanceLedgerClock(stub, args)
+	} else if function == "getLedgerClock" { // get ledger clock used for validation
+		return ercc.getLedgerClock(stub, args)
+	} else if function == "isEndorsing" { // check if an enclave may endorse for a chaincode
+		return ercc.isEndorsing(stub, args)
+	} else if function == "revalidateEnclave" { // re-submit stored quote of an enclave to IAS
+		return ercc.revalidateEnclave(stub, args)
+	} else if function == "revalidateEnclaves" { // re-submit stored quotes of all enclaves to IAS
+		return ercc.revalidateEnclaves(stub, args)
+	} else if function == "getEnclaveStatus" { // get platform status of an enclave
+		return ercc.getEnclaveStatus(stub, args)
+	} else if function == "setAdvisory" { // ingest security advisory and flag or revoke affected enclaves
+		return ercc.setAdvisory(stub, args)
+	} else if function == "getAdvisory" { // get ingested security advisory
+		return ercc.getAdvisory(stub, args)
+	} else if function == "listEnclaves" { // list registered enclaves page by page
+		return ercc.listEnclaves(stub, args)
+	} else if function == "getEnclaveByPk" { // get registration record by enclave pk
+		return ercc.getEnclaveByPk(stub, args)
+	} else if function == "rotateEnclaveKey" { // make another registered key the active key of an enclave identity
+		return ercc.rotateEnclaveKey(stub, args)
+	} else if function == "getEnclaveIdentity" { // get enclave identity by enclave id
+		return ercc.getEnclaveIdentity(stub, args)
+	} else if function == "getEnclaveID" { // get enclave id by enclave pk hash
+		return ercc.getEnclaveID(stub, args)
+	} else if function == "setRegistrationQuorum" { // set number of orgs that must verify a registration
+		return ercc.setRegistrationQuorum(stub, args)
+	} else if function == "confirmEnclave" { // verify and confirm a pending registration
+		return ercc.confirmEnclave(stub, args)
+	} else if function == "getPendingRegistration" { // get pending registration by enclave pk hash
+		return ercc.getPendingRegistration(stub, args)
+	} else if function == "submitQuote" { // submit quote of an enclave, first phase of a two-phase registration
+		return ercc.submitQuote(stub, args)
+	} else if function == "finalizeRegistration" { // attach attestation report to a submitted quote and register the enclave
+		return ercc.finalizeRegistration(stub, args)
+	} else if function == "attachEvidence" { // attach the attestation report of a verifier to a submitted quote
+		return ercc.attachEvidence(stub, args)
+	} else if function == "getQuoteSubmission" { // get submitted quote by enclave pk hash
+		return ercc.getQuoteSubmission(stub, args)
+	} else if function == "renewEnclave" { // replace the attestation evidence of a registered enclave
+		return ercc.renewEnclave(stub, args)
+	} else if function == "getChaincodeEnclaves" { // get enclaves registered for a chaincode
+		return ercc.getChaincodeEnclaves(stub, args)
+	} else if function == "setRegistrationQuota" { // set per-org registration quota and rate limit
+		return ercc.setRegistrationQuota(stub, args)
+	} else if function == "getRegistrationUsage" { // get registration usage of an org
+		return ercc.getRegistrationUsage(stub, args)
+	} else if function == "resetRegistrationUsage" { // reset registration usage of an org
+		return ercc.resetRegistrationUsage(stub, args)
+	} else if function == "signRegistration" { // store registrar signature over an attestation report
+		return ercc.signRegistration(stub, args)
+	} else if function == "getRegistrarSignature" { // get registrar signature by enclave pk hash
+		return ercc.getRegistrarSignature(stub, args)
+	} else if function == "setPsePolicy" { // set requirements on platform services of enclaves
+		return ercc.setPsePolicy(stub, args)
+	} else if function == "getEnclavesByPlatform" { // get enclaves registered from the same platform by EPID pseudonym
+		return ercc.getEnclavesByPlatform(stub, args)
+	} else if function == "setAttestationPolicy" { // set attestation policy of the channel
+		return ercc.setAttestationPolicy(stub, args)
+	} else if function == "getAttestationPolicy" { // get attestation policy of the channel
+		return ercc.getAttestationPolicy(stub, args)
+	} else if function == "pruneRegistry" { // remove expired and revoked registrations past a retention window
+		return ercc.pruneRegistry(stub, args)
+	} else if function == "getTombstone" { // get what remains of a pruned registration
+		return ercc.getTombstone(stub, args)
+	} else if function == "putCRL" { // store a CRL to bundle into the collateral of registrations
+		return ercc.putCRL(stub, args)
+	} else if function == "getCollateral" { // get the collateral a registration was verified with
+		return ercc.getCollateral(stub, args)
+	} else if function == "putStateCommitment" { // publish the Merkle root over the state of a chaincode
+		return ercc.putStateCommitment(stub, args)
+	} else if function == "getStateCommitment" { // get the latest state commitment of a chaincode
+		return ercc.getStateCommitment(stub, args)
+	} else if function == "getRegistryStats" { // get aggregate statistics over all registrations
+		return ercc.getRegistryStats(stub, args)
+	} else if function == setFunctionACLFunction { // set or remove the ACL of a function
+		return ercc.setFunctionACL(stub, args)
+	} else if function == "getFunctionACL" { // get the ACL of a function
+		return ercc.getFunctionACL(stub, args)
+	} else if function == "addTrustedRoot" { // add a key evidence is verified with, effective from a block height
+		return ercc.addTrustedRoot(stub, args)
+	} else if function == "retireTrustedRoot" { // stop trusting a root from a block height
+		return ercc.retireTrustedRoot(stub, args)
+	} else if function == "getTrustedRoots" { // get all trusted roots
+		return ercc.getTrustedRoots(stub, args)
+	} else if function == "approveDelegation" { // approve re-encryption from enclaves of one mrenclave to another
+		return ercc.approveDelegation(stub, args)
+	} else if function == "getDelegation" { // get an approved delegation
+		return ercc.getDelegation(stub, args)
+	} else if function == "revokeDelegation" { // revoke an approved delegation
+		return ercc.revokeDelegation(stub, args)
+	} else if function == "putReEncryptionKey" { // store re-encryption key from one enclave to another
+		return ercc.putReEncryptionKey(stub, args)
+	} else if function == "reEncrypt" { // re-encrypt an ephemeral pk of data encrypted to an enclave for a delegatee
+		return ercc.reEncrypt(stub, args)
+	} else if function == "delegateSecret" { // re-encrypt a secret provisioned to an enclave for a delegatee
+		return ercc.delegateSecret(stub, args)
+	} else if function == "getDelegatedSecret" { // get secret re-encrypted for a delegatee
+		return ercc.getDelegatedSecret(stub, args)
+	} else if function == "setEscrowPolicy" { // designate the recovery parties of the state key of a chaincode
+		return ercc.setEscrowPolicy(stub, args)
+	} else if function == "getEscrowPolicy" { // get the escrow policy of a chaincode
+		return ercc.getEscrowPolicy(stub, args)
+	} else if function == "putEscrow" { // store the shares of the state key of a chaincode encrypted to the recovery parties
+		return ercc.putEscrow(stub, args)
+	} else if function == "getEscrow" { // get the escrow of the state key of a chaincode
+		return ercc.getEscrow(stub, args)
+	} else if function == "submitRecoveryShare" { // submit the share of a recovery party re-encrypted to a new enclave
+		return ercc.submitRecoveryShare(stub, args)
+	} else if function == "getRecovery" { // get the recovery shares of an enclave once the threshold is met
+		return ercc.getRecovery(stub, args)
+	} else if function == "setAlgorithmPolicy" { // set the algorithms of enclave keys accepted by the channel
+		return ercc.setAlgorithmPolicy(stub, args)
+	} else if function == "getAlgorithmPolicy" { // get the algorithms of enclave keys accepted by the channel
+		return ercc.getAlgorithmPolicy(stub, args)
+	} else if function == "setTranscriptConfig" { // enable the attestation transcript, optionally in a private collection
+		return ercc.setTranscriptConfig(stub, args)
+	} else if function == "getTranscriptHead" { // get the head of the attestation transcript
+		return ercc.getTranscriptHead(stub, args)
+	} else if function == "getTranscript" { // get entries of the attestation transcript
+		return ercc.getTranscript(stub, args)
+	} else if function == "importTranscript" { // append entries of the attestation transcript of another channel
+		return ercc.importTranscript(stub, args)
+	} else if function == "getImportedTranscriptHead" { // get the head of a transcript imported from another channel
+		return ercc.getImportedTranscriptHead(stub, args)
+	} else if function == "getImportedTranscript" { // get entries of a transcript imported from another channel
+		return ercc.getImportedTranscript(stub, args)
+	} else if function == "setForeignChannels" { // set the channels enclaves may be imported from
+		return ercc.setForeignChannels(stub, args)
+	} else if function == "getForeignChannels" { // get the channels enclaves may be imported from
+		return ercc.getForeignChannels(stub, args)
+	} else if function == "importForeignEnclave" { // import an enclave registered on another channel
+		return ercc.importForeignEnclave(stub, args)
+	} else if function == "getForeignEnclave" { // get an enclave imported from another channel
+		return ercc.getForeignEnclave(stub, args)
+	} else if function == "verifyForeignEnclave" { // check that an enclave of another channel may be trusted
+		return ercc.verifyForeignEnclave(stub, args)
+	} else if function == "removeForeignEnclave" { // remove an enclave imported from another channel
+		return ercc.removeForeignEnclave(stub, args)
 	}
 
 	return shim.Error("Received unknown function invocation: " + function)
@@ -88,34 +290,56 @@ func (ercc *EnclaveRegistryCC) registerEnclave(stub shim.ChaincodeStubInterface,
 		return shim.Error("Incorrect number of arguments. Expecting enclave pk and quote to register")
 	}
 
-	enclavePkAsBytes, err := base64.StdEncoding.DecodeString(args[0])
+	// quote binds the enclave pk only
+	return ercc.register(stub, args[0], args[1], args[2:], nil)
+}
+
+// ============================================================
+// registerBoundEnclave -
+// ============================================================
+func (ercc *EnclaveRegistryCC) registerBoundEnclave(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkBase64
+	// 1: quoteBase64
+	// 2: chaincodeID[:version]
+	// 3: certPem
+	// 4: keyPem
+	// if certPem and keyPem not available as argument we try to read them from decorator
+
+	if len(args) < 3 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk, quote and chaincode id to register")
+	}
+
+	// quote binds the enclave pk to this transaction, channel and chaincode; see attestation.ReportDataFormat
+	binding := attestation.NewReportDataBinding(stub.GetTxID(), stub.GetChannelID(), args[2])
+	return ercc.register(stub, args[0], args[1], args[3:], binding)
+}
+
+// register verifies the quote with IAS and stores the attestation report of the enclave
+func (ercc *EnclaveRegistryCC) register(stub shim.ChaincodeStubInterface, enclavePkBase64, quoteBase64 string, certArgs []string, binding *attestation.ReportDataBinding) pb.Response {
+	enclavePkAsBytes, err := base64.StdEncoding.DecodeString(enclavePkBase64)
 	if err != nil {
 		return shim.Error("Can not parse enclavePkHash: " + err.Error())
 	}
 
-	quoteBase64 := args[1]
-	quoteAsBytes, err := base64.StdEncoding.DecodeString(quoteBase64)
+	quoteAsBytes, err := decodeQuote(quoteBase64)
 	if err != nil {
-		return shim.Error("Can not parse quoteBase64 string: " + err.Error())
+		return shim.Error(err.Error())
 	}
 
-	// get ercc client cert for IAS
-	var certPem []byte
-	if len(args) >= 3 {
-		certPem = []byte(args[2])
-	} else {
-		certPem = stub.GetDecorations()["certPEM"]
+	// registering identical evidence again returns the existing registration
+	if enclaveID, err := getIdenticalRegistration(stub, enclavePkAsBytes, quoteAsBytes, binding); err != nil {
+		return shim.Error(err.Error())
+	} else if enclaveID != "" {
+		return shim.Success([]byte(enclaveID))
 	}
 
-	// get ercc client key for IAS
-	var keyPem []byte
-	if len(args) >= 4 {
-		keyPem = []byte(args[3])
-	} else {
-		keyPem = stub.GetDecorations()["keyPEM"]
+	// reject registrations beyond the org's quota before contacting IAS
+	if err := chargeRegistration(stub); err != nil {
+		return shim.Error("Registration rejected: " + err.Error())
 	}
 
-	cert, err := tls.X509KeyPair(certPem, keyPem)
+	cert, err := ercc.getIASClientCert(stub, certArgs)
 	if err != nil {
 		return shim.Error("Can not load client cert: " + err.Error())
 	}
@@ -126,34 +350,53 @@ func (ercc *EnclaveRegistryCC) registerEnclave(stub shim.ChaincodeStubInterface,
 		return shim.Error("Error while retrieving attestation report: " + err.Error())
 	}
 
-	// TODO get verification public key from ledger
-	verificationPK, err := ercc.ias.GetIntelVerificationKey()
-	if err != nil {
-		return shim.Error("Can not parse verifiaction key: " + err.Error())
-	}
+	return ercc.registerReport(stub, enclavePkAsBytes, quoteAsBytes, attestationReport, binding, false)
+}
 
-	// verify attestation report
-	isValid, err := ercc.ra.VerifyAttestionReport(verificationPK, attestationReport)
+// registerReport verifies the attestation report of a quote and stores it; this is deterministic as it does
+// not involve IAS. Existing registrations are only replaced if renewal is set.
+func (ercc *EnclaveRegistryCC) registerReport(stub shim.ChaincodeStubInterface, enclavePkAsBytes, quoteAsBytes []byte, attestationReport attestation.IASAttestationReport, binding *attestation.ReportDataBinding, renewal bool) pb.Response {
+	// verify attestation report with the trusted roots
+	verificationPK, err := ercc.verifyAttestationReport(stub, attestationReport)
 	if err != nil {
-		return shim.Error("Error while attestation report verification: " + err.Error())
+		return shim.Error(err.Error())
 	}
-	if !isValid {
-		return shim.Error("Attestation report is not valid")
+	// malformed report bodies must not end up in the ledger
+	if _, err := attestation.ParseReportBody(attestationReport.IASReportBody); err != nil {
+		return shim.Error(err.Error())
 	}
 
-	// first verify that enclavePkHash matches the one in the attestation report
-	isValid, err = ercc.ra.CheckEnclavePkHash(enclavePkAsBytes, attestationReport)
+	// first verify that enclavePkHash (and binding) matches the one in the attestation report
+	isValid, err := ercc.ra.CheckReportData(enclavePkAsBytes, binding, attestationReport)
 	if err != nil {
 		return shim.Error("Error while checking enclave PK: " + err.Error())
 	}
 	if !isValid {
 		return shim.Error("Enclave PK does not match attestation report!")
 	}
+	// the chaincode the enclave is bound to must exist on this channel
+	if err := ercc.checkChaincodeDefinition(stub, binding); err != nil {
+		return shim.Error("Invalid chaincode binding: " + err.Error())
+	}
+	// the report must satisfy the attestation policy of the channel
+	if err := checkAttestationPolicy(stub, attestationReport); err != nil {
+		return shim.Error("Attestation policy violated: " + err.Error())
+	}
+	// platform services must meet the PSE policy
+	if err := checkPseManifest(stub, attestationReport); err != nil {
+		return shim.Error("PSE manifest not accepted: " + err.Error())
+	}
+	// the algorithms of the enclave key must be accepted by the channel
+	algorithms, err := checkAlgorithms(stub, enclavePkAsBytes)
+	if err != nil {
+		return shim.Error("Enclave key not accepted: " + err.Error())
+	}
+
 	// set enclave public key in attestation report
 	attestationReport.EnclavePk = enclavePkAsBytes
 
 	// store attestation report under enclavePk hash in state
-	attestationReportAsBytes, err := json.Marshal(attestationReport)
+	attestationReportAsBytes, err := registry.MarshalCanonical(attestationReport)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -161,9 +404,141 @@ func (ercc *EnclaveRegistryCC) registerEnclave(stub shim.ChaincodeStubInterface,
 	// create hash of enclave pk
 	enclavePkHash := sha256.Sum256(enclavePkAsBytes)
 	enclavePkHashBase64 := base64.StdEncoding.EncodeToString(enclavePkHash[:])
-	err = stub.PutState(enclavePkHashBase64, attestationReportAsBytes)
 
-	return shim.Success(nil)
+	// never overwrite the registration of an enclave silently
+	if err := checkRegistrationCollision(stub, enclavePkHashBase64, renewal); err != nil {
+		return shim.Error(err.Error())
+	}
+	// one quote backs one registration only
+	if err := checkReplay(stub, enclavePkAsBytes, binding, renewal); err != nil {
+		return shim.Error(err.Error())
+	}
+	// a renewal may bind the enclave to another chaincode, which must index it instead
+	previousChaincodeID := ""
+	if renewal {
+		if previousChaincodeID, err = getBoundChaincodeID(stub, enclavePkHashBase64); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	// evidence is bulky, thus, we store it compressed
+	compressedReport, err := registry.CompressEvidence(attestationReportAsBytes)
+	if err != nil {
+		return shim.Error("Can not compress attestation report: " + err.Error())
+	}
+	err = stub.PutState(enclavePkHashBase64, compressedReport)
+
+	// keep quote for re-validation
+	quoteKey, err := stub.CreateCompositeKey(registry.QuoteObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	compressedQuote, err := registry.CompressEvidence(quoteAsBytes)
+	if err != nil {
+		return shim.Error("Can not compress quote: " + err.Error())
+	}
+	if err := stub.PutState(quoteKey, compressedQuote); err != nil {
+		return shim.Error(err.Error())
+	}
+	// bundle what the report was verified with for offline re-verification
+	if err := putCollateral(stub, enclavePkHashBase64, attestationReport, verificationPK); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if binding != nil {
+		bindingAsBytes, err := registry.MarshalCanonical(binding)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		bindingKey, err := stub.CreateCompositeKey(registry.BindingObjectType, []string{enclavePkHashBase64})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.PutState(bindingKey, bindingAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := putAlgorithms(stub, enclavePkHashBase64, algorithms); err != nil {
+		return shim.Error(err.Error())
+	}
+	if renewal {
+		// records referring to the previous evidence do not apply anymore
+		if err := dropEvidenceRecords(stub, enclavePkHashBase64, binding == nil); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	if err := putRegistrant(stub, enclavePkHashBase64); err != nil {
+		return shim.Error("Can not record registrant: " + err.Error())
+	}
+
+	// with a registration quorum the enclave stays inactive until other orgs confirmed it
+	if err := createPendingRegistration(stub, enclavePkHashBase64); err != nil {
+		return shim.Error("Can not create pending registration: " + err.Error())
+	}
+
+	// stable identity of the enclave; survives key rotation and renewal
+	var enclaveID string
+	if renewal {
+		enclaveID, err = getRenewedEnclaveID(stub, enclavePkHashBase64, attestationReport)
+	} else {
+		enclaveID, err = createEnclaveIdentity(stub, enclavePkHashBase64, attestationReport)
+	}
+	if err != nil {
+		return shim.Error("Can not create enclave identity: " + err.Error())
+	}
+
+	chaincodeID := ""
+	if binding != nil {
+		chaincodeID = binding.ChaincodeID
+	}
+	if renewal && previousChaincodeID != chaincodeID {
+		if err := delChaincodeEnclave(stub, previousChaincodeID, enclaveID); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := putChaincodeEnclave(stub, chaincodeID, enclaveID, enclavePkHashBase64, renewal); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// linkable quotes reveal registrations from the same platform
+	if err := indexPlatform(stub, enclavePkHashBase64, attestationReport); err != nil {
+		return shim.Error("Can not index platform: " + err.Error())
+	}
+
+	// mirrored to auditors, if the channel keeps a transcript
+	if err := transcribeRegistration(stub, enclavePkHashBase64, enclaveID, attestationReportAsBytes, renewal); err != nil {
+		return shim.Error("Can not append to transcript: " + err.Error())
+	}
+
+	return shim.Success([]byte(enclaveID))
+}
+
+// getIASClientCert returns the ercc client cert for IAS
+// args:
+// 0: certPem
+// 1: keyPem
+// if certPem and keyPem not available as argument we try to read them from decorator
+// if the ias-proxy holds the credentials, no cert is needed
+func (ercc *EnclaveRegistryCC) getIASClientCert(stub shim.ChaincodeStubInterface, args []string) (tls.Certificate, error) {
+	if ercc.iasProxied && len(args) == 0 {
+		return tls.Certificate{}, nil
+	}
+
+	var certPem []byte
+	if len(args) >= 1 {
+		certPem = []byte(args[0])
+	} else {
+		certPem = stub.GetDecorations()["certPEM"]
+	}
+
+	var keyPem []byte
+	if len(args) >= 2 {
+		keyPem = []byte(args[1])
+	} else {
+		keyPem = stub.GetDecorations()["keyPEM"]
+	}
+
+	return tls.X509KeyPair(certPem, keyPem)
 }
 
 // ============================================================
@@ -186,6 +561,11 @@ func (ercc *EnclaveRegistryCC) getAttestationReport(stub shim.ChaincodeStubInter
 		return shim.Error("EnclavePK does not exist: " + enclavePkHashBase64)
 	}
 
+	attestationReport, err = registry.DecompressEvidence(attestationReport)
+	if err != nil {
+		return shim.Error("Can not decompress attestation report: " + err.Error())
+	}
+
 	return shim.Success(attestationReport)
 }
 
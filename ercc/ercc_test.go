@@ -17,14 +17,32 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
+	"math/big"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	ecccrypto "github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
 	th "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
 )
 
@@ -48,6 +66,7 @@ func TestEnclaveRegistry_Register(t *testing.T) {
 
 	// Init
 	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
 
 	// invoke registerEnclave
 	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote)})
@@ -62,6 +81,7 @@ func TestEnclaveRegistry_GetAttestationReport(t *testing.T) {
 
 	// Init
 	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
 
 	// invoke registerEnclave
 	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote)})
@@ -96,3 +116,2293 @@ func TestEnclaveRegistry_PEM(t *testing.T) {
 	}
 	t.Log("Success")
 }
+
+func TestEnclaveRegistry_ProvisionSecret(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+
+	provisioner, key := th.CreateCreatorWithAttrsAndKey(t, "Org1MSP", "provisioner", map[string]string{provisionerAttribute: "true"})
+	ciphertext, _ := base64.StdEncoding.DecodeString("AAAA")
+	approval := approve(t, provisioner, key, registry.SecretStatement("apiKey", ciphertext))
+	secretArgs := [][]byte{[]byte("provisionSecret"), []byte(enclavePkHash), []byte("apiKey"), []byte("AAAA"), []byte("AAAA"), approval}
+
+	// secrets can only be provisioned to registered enclaves
+	stub.Creator = provisioner
+	if res := stub.MockInvoke("1", secretArgs); res.Status == shim.OK {
+		t.Fatalf("provisionSecret should fail for unregistered enclave")
+	}
+
+	stub.MockTransactionStart("2")
+	stub.PutState(enclavePkHash, []byte("{}"))
+	stub.MockTransactionEnd("2")
+
+	// only provisioners provision secrets, and only with their own approval of the secret
+	member, memberKey := th.CreateCreatorWithKey(t, "Org1MSP", "member")
+	stub.Creator = member
+	if res := stub.MockInvoke("3", [][]byte{[]byte("provisionSecret"), []byte(enclavePkHash), []byte("apiKey"), []byte("AAAA"), []byte("AAAA"),
+		approve(t, member, memberKey, registry.SecretStatement("apiKey", ciphertext))}); res.Status == shim.OK {
+		t.Fatalf("provisionSecret should fail for non-provisioners")
+	}
+	stub.Creator = provisioner
+	if res := stub.MockInvoke("4", [][]byte{[]byte("provisionSecret"), []byte(enclavePkHash), []byte("apiKey"), []byte("AAAA"), []byte("AAAA"),
+		approve(t, provisioner, key, registry.SecretStatement("otherKey", ciphertext))}); res.Status == shim.OK {
+		t.Fatalf("provisionSecret should fail with the approval of another secret")
+	}
+	other, otherKey := th.CreateCreatorWithAttrsAndKey(t, "Org1MSP", "other", map[string]string{provisionerAttribute: "true"})
+	if res := stub.MockInvoke("5", [][]byte{[]byte("provisionSecret"), []byte(enclavePkHash), []byte("apiKey"), []byte("AAAA"), []byte("AAAA"),
+		approve(t, other, otherKey, registry.SecretStatement("apiKey", ciphertext))}); res.Status == shim.OK {
+		t.Fatalf("provisionSecret should fail with the approval of another client")
+	}
+
+	th.CheckInvoke(t, stub, secretArgs)
+	res := stub.MockInvoke("6", [][]byte{[]byte("getSecret"), []byte(enclavePkHash), []byte("apiKey")})
+	secret := &ProvisionedSecret{}
+	if err := json.Unmarshal(res.Payload, secret); err != nil || secret.Approval == nil {
+		t.Fatalf("Secret should carry the approval: %s", res.Payload)
+	}
+	if _, err := secret.Approval.Verify(registry.SecretStatement("apiKey", secret.Ciphertext)); err != nil {
+		t.Fatalf("Approval should verify: %s", err)
+	}
+}
+
+// approve returns the json encoded approval of statement by the identity creator with its key
+func approve(t *testing.T, creator []byte, key *ecdsa.PrivateKey, statement []byte) []byte {
+	sid := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(creator, sid); err != nil {
+		t.Fatalf("Can not unmarshal creator: %s", err)
+	}
+	digest := sha256.Sum256(statement)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Can not sign statement: %s", err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	approvalAsBytes, _ := json.Marshal(&registry.Approval{MspID: sid.Mspid, Certificate: sid.IdBytes, Signature: sig})
+	return approvalAsBytes
+}
+
+func TestEnclaveRegistry_RegisterClientKey(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	stub.Creator = th.CreateCreator(t, "Org1MSP", "client1")
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+
+	// invalid keys are rejected
+	if res := stub.MockInvoke("1", [][]byte{[]byte("registerClientKey"), []byte("AAAA")}); res.Status == shim.OK {
+		t.Fatalf("registerClientKey should fail for invalid key")
+	}
+
+	res := stub.MockInvoke("2", [][]byte{[]byte("registerClientKey"), []byte(enclavePK)})
+	if res.Status != shim.OK {
+		t.Fatalf("registerClientKey failed: %s", res.Message)
+	}
+
+	clientKey := &ClientKey{}
+	if err := json.Unmarshal(res.Payload, clientKey); err != nil {
+		t.Fatalf("Can not unmarshal client key: %s", err)
+	}
+	if clientKey.MspID != "Org1MSP" {
+		t.Fatalf("Expected Org1MSP but got %s", clientKey.MspID)
+	}
+
+	th.CheckQueryNotNull(t, stub, [][]byte{[]byte("getClientKey"), []byte(clientKey.MspID), []byte(clientKey.ClientID)})
+}
+
+func TestEnclaveRegistry_HandoverState(t *testing.T) {
+	ercc := NewTestErcc()
+	ercc.ledger = &mockLedger{height: 5, msps: []string{"Org1MSP", "Org2MSP"}}
+	stub := shim.NewMockStub("ercc", ercc)
+	stub.ChannelID = "mychannel"
+	admin, adminKey := th.CreateCreatorWithAttrsAndKey(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	admin2, admin2Key := th.CreateCreatorWithAttrsAndKey(t, "Org2MSP", "admin", map[string]string{adminAttribute: "true"})
+	outsider, outsiderKey := th.CreateCreatorWithAttrsAndKey(t, "Org3MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+
+	mrEnclave := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	successorMrEnclave := base64.StdEncoding.EncodeToString(append(make([]byte, 31), 0x01))
+
+	enclaveSk, _, err := ecccrypto.GenKeyPair()
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	successorSk, _, err := ecccrypto.GenKeyPair()
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	enclavePk, _ := x509.MarshalPKIXPublicKey(&enclaveSk.PublicKey)
+	successorPk, _ := x509.MarshalPKIXPublicKey(&successorSk.PublicKey)
+	enclavePkHash := registry.EnclavePkHash(enclavePk)
+	successorPkHash := registry.EnclavePkHash(successorPk)
+
+	stub.MockTransactionStart("1")
+	for _, pk := range [][]byte{enclavePk, successorPk} {
+		reportAsBytes, _ := json.Marshal(&attestation.IASAttestationReport{EnclavePk: pk})
+		stub.PutState(registry.EnclavePkHash(pk), reportAsBytes)
+		bindingKey, _ := stub.CreateCompositeKey(registry.BindingObjectType, []string{registry.EnclavePkHash(pk)})
+		bindingAsBytes, _ := json.Marshal(&attestation.ReportDataBinding{ChannelID: "mychannel", ChaincodeID: "ecc"})
+		stub.PutState(bindingKey, bindingAsBytes)
+	}
+	stub.MockTransactionEnd("1")
+
+	// the old enclave signs the handover of its state key to the successor
+	ephemeralPk, ciphertext := make([]byte, 64), []byte("ciphertext")
+	handoverArgs := func(signer *ecdsa.PrivateKey) [][]byte {
+		message := registry.HandoverMessage(ephemeralPk, ciphertext, ecccrypto.MarshalSgxPk(&successorSk.PublicKey))
+		digest := sha256.Sum256(message)
+		r, s, err := ecdsa.Sign(rand.Reader, signer, digest[:])
+		if err != nil {
+			t.Fatalf("Can not sign handover: %s", err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return [][]byte{[]byte("handoverState"), []byte("ecc"), []byte(enclavePkHash), []byte(successorPkHash), []byte(mrEnclave),
+			[]byte(base64.StdEncoding.EncodeToString(ephemeralPk)), []byte(base64.StdEncoding.EncodeToString(ciphertext)),
+			[]byte(base64.StdEncoding.EncodeToString(sig))}
+	}
+
+	// handover requires an approved successor
+	if res := stub.MockInvoke("2", handoverArgs(enclaveSk)); res.Status == shim.OK {
+		t.Fatalf("handoverState should fail without upgrade approval")
+	}
+
+	// only admins approve upgrades, with their own approval of the upgrade of the chaincode on the channel
+	statement := registry.UpgradeStatement("mychannel", "ecc", mrEnclave, successorMrEnclave)
+	approveArgs := func(creator []byte, key *ecdsa.PrivateKey, statement []byte) [][]byte {
+		return [][]byte{[]byte("approveUpgrade"), []byte("ecc"), []byte(mrEnclave), []byte(successorMrEnclave), approve(t, creator, key, statement)}
+	}
+	if res := stub.MockInvoke("2", approveArgs(admin, adminKey, statement)); res.Status == shim.OK {
+		t.Fatalf("approveUpgrade should fail for non-admins")
+	}
+	stub.Creator = admin
+	for _, other := range [][]byte{
+		registry.UpgradeStatement("mychannel", "ecc", successorMrEnclave, mrEnclave),
+		registry.UpgradeStatement("otherchannel", "ecc", mrEnclave, successorMrEnclave),
+		registry.UpgradeStatement("mychannel", "other", mrEnclave, successorMrEnclave),
+	} {
+		if res := stub.MockInvoke("2", approveArgs(admin, adminKey, other)); res.Status == shim.OK {
+			t.Fatalf("approveUpgrade should fail with the approval of another upgrade")
+		}
+	}
+	stub.Creator = outsider
+	if res := stub.MockInvoke("2", approveArgs(outsider, outsiderKey, statement)); res.Status == shim.OK {
+		t.Fatalf("approveUpgrade should fail for admins of MSPs outside the channel")
+	}
+	stub.Creator = admin
+	th.CheckInvoke(t, stub, approveArgs(admin, adminKey, statement))
+	th.CheckQueryNotNull(t, stub, [][]byte{[]byte("getUpgrade"), []byte("ecc"), []byte(mrEnclave)})
+
+	// one of two MSPs is no quorum
+	if res := stub.MockInvoke("3", handoverArgs(enclaveSk)); res.Status == shim.OK {
+		t.Fatalf("handoverState should fail without a quorum of approvals")
+	}
+	stub.Creator = admin2
+	th.CheckInvoke(t, stub, approveArgs(admin2, admin2Key, statement))
+
+	// only the old enclave hands over its state
+	if res := stub.MockInvoke("3", handoverArgs(successorSk)); res.Status == shim.OK {
+		t.Fatalf("handoverState should fail without the signature of the old enclave")
+	}
+	th.CheckInvoke(t, stub, handoverArgs(enclaveSk))
+
+	res := stub.MockInvoke("4", [][]byte{[]byte("getStateHandover"), []byte(successorPkHash)})
+	if res.Status != shim.OK {
+		t.Fatalf("getStateHandover failed: %s", res.Message)
+	}
+	handover := &StateHandover{}
+	if err := json.Unmarshal(res.Payload, handover); err != nil {
+		t.Fatalf("Can not parse state handover: %s", err)
+	}
+	if handover.PredecessorPkHash != enclavePkHash || len(handover.Approvals) != 2 {
+		t.Fatalf("Unexpected state handover %v", handover)
+	}
+	if err := registry.VerifyQuorum(handover.Approvals, statement, []string{"Org1MSP", "Org2MSP"}); err != nil {
+		t.Fatalf("Upgrade approvals should be valid: %s", err)
+	}
+
+	// retired enclaves can not hand over again
+	if res := stub.MockInvoke("5", handoverArgs(enclaveSk)); res.Status == shim.OK {
+		t.Fatalf("handoverState should fail for retired enclave")
+	}
+}
+
+func TestEnclaveRegistry_MinIsvSvn(t *testing.T) {
+	ercc := NewTestErcc()
+	ercc.ledger = &mockLedger{height: 5}
+	stub := shim.NewMockStub("ercc", ercc)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+
+	reportBody, _ := json.Marshal(&attestation.IASReportBody{IsvEnclaveQuoteBody: quote})
+	report, _ := json.Marshal(&attestation.IASAttestationReport{IASReportBody: reportBody})
+
+	stub.MockTransactionStart("1")
+	stub.PutState(enclavePkHash, report)
+	stub.MockTransactionEnd("1")
+
+	isEndorsingArgs := [][]byte{[]byte("isEndorsing"), []byte(enclavePkHash), []byte("ecc")}
+
+	// no policy
+	th.CheckQuery(t, stub, isEndorsingArgs, "true")
+
+	// only admins set the policy
+	if res := stub.MockInvoke("2", [][]byte{[]byte("setMinIsvSvn"), []byte("ecc"), []byte("65535"), []byte("0")}); res.Status == shim.OK {
+		t.Fatalf("setMinIsvSvn should fail for non-admins")
+	}
+	stub.Creator = th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// grace period
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setMinIsvSvn"), []byte("ecc"), []byte("65535"), []byte("3600")})
+	th.CheckQueryNotNull(t, stub, [][]byte{[]byte("getMinIsvSvn"), []byte("ecc")})
+	th.CheckQuery(t, stub, isEndorsingArgs, "true")
+
+	// the policy is stamped with the ledger clock
+	res := stub.MockInvoke("3", [][]byte{[]byte("getLedgerClock")})
+	if res.Status != shim.OK {
+		t.Fatalf("getLedgerClock failed: %s", res.Message)
+	}
+	clock := &registry.LedgerClock{}
+	if err := json.Unmarshal(res.Payload, clock); err != nil {
+		t.Fatalf("Can not unmarshal ledger clock: %s", err)
+	}
+	res = stub.MockInvoke("3", [][]byte{[]byte("getMinIsvSvn"), []byte("ecc")})
+	policy := &registry.MinIsvSvnPolicy{}
+	if err := json.Unmarshal(res.Payload, policy); err != nil {
+		t.Fatalf("Can not unmarshal min isv svn: %s", err)
+	}
+	if clock.Height != 5 || policy.EnforcedFrom != clock.Time+3600 {
+		t.Fatalf("Unexpected ledger clock %+v for policy %+v", clock, policy)
+	}
+
+	// enforced
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setMinIsvSvn"), []byte("ecc"), []byte("65535"), []byte("0")})
+	th.CheckQuery(t, stub, isEndorsingArgs, "false")
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setMinIsvSvn"), []byte("ecc"), []byte("0"), []byte("0")})
+	th.CheckQuery(t, stub, isEndorsingArgs, "true")
+}
+
+func TestEnclaveRegistry_RevalidateEnclave(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("revalidateEnclave"), []byte(enclavePkHash), certPem, keyPem})
+	if res.Status != shim.OK {
+		t.Fatalf("revalidateEnclave failed: %s", res.Message)
+	}
+
+	status := &registry.EnclaveStatus{}
+	if err := json.Unmarshal(res.Payload, status); err != nil {
+		t.Fatalf("Can not unmarshal status: %s", err)
+	}
+	if status.QuoteStatus != registry.QuoteStatusOK {
+		t.Fatalf("Expected status %s but got %s", registry.QuoteStatusOK, status.QuoteStatus)
+	}
+	th.CheckQueryNotNull(t, stub, [][]byte{[]byte("getEnclaveStatus"), []byte(enclavePkHash)})
+
+	res = stub.MockInvoke("2", [][]byte{[]byte("revalidateEnclaves"), certPem, keyPem})
+	if res.Status != shim.OK {
+		t.Fatalf("revalidateEnclaves failed: %s", res.Message)
+	}
+
+	statusMap := make(map[string]*registry.EnclaveStatus)
+	if err := json.Unmarshal(res.Payload, &statusMap); err != nil {
+		t.Fatalf("Can not unmarshal status map: %s", err)
+	}
+	if _, ok := statusMap[enclavePkHash]; !ok || len(statusMap) != 1 {
+		t.Fatalf("Expected status of registered enclave only but got %v", statusMap)
+	}
+}
+
+// newEnclavePk returns a fresh enclave pk (base64)
+func newEnclavePk(t *testing.T) string {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("Can not marshal key: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(pk)
+}
+
+// createIASClientCert returns a self-signed client cert and key to talk to the (mock) IAS
+func createIASClientCert(t *testing.T) ([]byte, []byte) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ercc"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("Can not create certificate: %s", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("Can not marshal key: %s", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestEnclaveRegistry_SetAdvisory(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+
+	reportBody, _ := json.Marshal(&attestation.IASReportBody{IsvEnclaveQuoteBody: quote})
+	report, _ := json.Marshal(&attestation.IASAttestationReport{IASReportBody: reportBody})
+	status, _ := json.Marshal(&registry.EnclaveStatus{QuoteStatus: registry.QuoteStatusGroupOutOfDate, AdvisoryIDs: []string{"INTEL-SA-00161"}})
+
+	stub.MockTransactionStart("1")
+	stub.PutState(enclavePkHash, report)
+	statusKey, _ := stub.CreateCompositeKey(registry.StatusObjectType, []string{enclavePkHash})
+	stub.PutState(statusKey, status)
+	stub.MockTransactionEnd("1")
+
+	isEndorsingArgs := [][]byte{[]byte("isEndorsing"), []byte(enclavePkHash), []byte("ecc")}
+	th.CheckQuery(t, stub, isEndorsingArgs, "true")
+
+	// only admins ingest advisories
+	advisoryArgs := [][]byte{[]byte("setAdvisory"), []byte("INTEL-SA-00161"), []byte(registry.AdvisoryActionRevoke), []byte("L1 Terminal Fault")}
+	if res := stub.MockInvoke("2", advisoryArgs); res.Status == shim.OK {
+		t.Fatalf("setAdvisory should fail for non-admins")
+	}
+	stub.Creator = th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	res := stub.MockInvoke("2", advisoryArgs)
+	if res.Status != shim.OK {
+		t.Fatalf("setAdvisory failed: %s", res.Message)
+	}
+
+	var changes []registry.TrustChange
+	if err := json.Unmarshal(res.Payload, &changes); err != nil {
+		t.Fatalf("Can not unmarshal trust changes: %s", err)
+	}
+	if len(changes) != 1 || changes[0].EnclavePkHash != enclavePkHash {
+		t.Fatalf("Expected enclave to be revoked but got %v", changes)
+	}
+
+	event := <-stub.ChaincodeEventsChannel
+	if event.EventName != registry.TrustChangedEventName {
+		t.Fatalf("Expected %s event but got %s", registry.TrustChangedEventName, event.EventName)
+	}
+
+	th.CheckQueryNotNull(t, stub, [][]byte{[]byte("getAdvisory"), []byte("INTEL-SA-00161")})
+	th.CheckQuery(t, stub, isEndorsingArgs, "false")
+}
+
+func TestEnclaveRegistry_GetEnclaveByPk(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	if res := stub.MockInvoke("1", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)}); res.Status == shim.OK {
+		t.Fatalf("getEnclaveByPk should fail for unregistered enclave")
+	}
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+
+	res := stub.MockInvoke("2", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)})
+	if res.Status != shim.OK {
+		t.Fatalf("getEnclaveByPk failed: %s", res.Message)
+	}
+
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(res.Payload, record); err != nil {
+		t.Fatalf("Can not unmarshal enclave record: %s", err)
+	}
+	if record.EnclavePkHash != enclavePkHash {
+		t.Fatalf("Expected %s but got %s", enclavePkHash, record.EnclavePkHash)
+	}
+}
+
+func TestEnclaveRegistry_RotateEnclaveKey(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	stub.ChannelID = "mychannel"
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	// linkable quotes identify the platform by its EPID pseudonym
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	quoteAsBytes[2] = 1
+	linkableQuote := base64.StdEncoding.EncodeToString(quoteAsBytes)
+
+	register := func(q string) (*ecdsa.PrivateKey, string) {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Can not create key: %s", err)
+		}
+		pk, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+		if err != nil {
+			t.Fatalf("Can not marshal key: %s", err)
+		}
+		th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(base64.StdEncoding.EncodeToString(pk)), []byte(q), certPem, keyPem})
+		return priv, registry.EnclavePkHash(pk)
+	}
+	endorse := func(active, successor *ecdsa.PrivateKey) []byte {
+		sgxPk := make([]byte, 64)
+		successor.X.FillBytes(sgxPk[:32])
+		successor.Y.FillBytes(sgxPk[32:])
+		digest := sha256.Sum256(registry.RotationMessage(sgxPk))
+		r, s, err := ecdsa.Sign(rand.Reader, active, digest[:])
+		if err != nil {
+			t.Fatalf("Can not sign rotation: %s", err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return []byte(base64.StdEncoding.EncodeToString(sig))
+	}
+
+	activeSk, activePkHash := register(linkableQuote)
+	res := stub.MockInvoke("1", [][]byte{[]byte("getEnclaveID"), []byte(activePkHash)})
+	enclaveID := string(res.Payload)
+
+	// register a fresh key of the same enclave
+	newSk, newPkHash := register(linkableQuote)
+
+	// the rotation must be signed by the active key, not by the new one
+	if res := stub.MockInvoke("2", [][]byte{[]byte("rotateEnclaveKey"), []byte(enclaveID), []byte(newPkHash), []byte("signature"), endorse(newSk, newSk)}); res.Status == shim.OK {
+		t.Fatalf("rotateEnclaveKey should fail without signature of the active key")
+	}
+
+	res = stub.MockInvoke("3", [][]byte{[]byte("rotateEnclaveKey"), []byte(enclaveID), []byte(newPkHash), []byte("signature"), endorse(activeSk, newSk)})
+	if res.Status != shim.OK {
+		t.Fatalf("rotateEnclaveKey failed: %s", res.Message)
+	}
+
+	identity := &registry.EnclaveIdentity{}
+	if err := json.Unmarshal(res.Payload, identity); err != nil {
+		t.Fatalf("Can not unmarshal enclave identity: %s", err)
+	}
+	if identity.EnclaveID != enclaveID || identity.ActivePkHash != newPkHash || len(identity.Keys) != 2 {
+		t.Fatalf("Unexpected enclave identity %+v", identity)
+	}
+
+	// both keys map to the same identity; the old one is retired
+	th.CheckQuery(t, stub, [][]byte{[]byte("getEnclaveID"), []byte(newPkHash)}, enclaveID)
+	th.CheckQuery(t, stub, [][]byte{[]byte("isEndorsing"), []byte(activePkHash), []byte("mycc")}, "false")
+
+	// rotating to the active key again fails
+	if res := stub.MockInvoke("4", [][]byte{[]byte("rotateEnclaveKey"), []byte(enclaveID), []byte(newPkHash), []byte("signature"), endorse(newSk, newSk)}); res.Status == shim.OK {
+		t.Fatalf("rotateEnclaveKey should fail for active key")
+	}
+
+	// an ercc admin may approve a rotation instead, e.g., if the enclave of the active key is lost
+	_, thirdPkHash := register(linkableQuote)
+	statement := registry.RotationStatement("mychannel", enclaveID, thirdPkHash)
+	client, clientKey := th.CreateCreatorWithAttrsAndKey(t, "Org1MSP", "client", nil)
+	stub.Creator = client
+	if res := stub.MockInvoke("5", [][]byte{[]byte("rotateEnclaveKey"), []byte(enclaveID), []byte(thirdPkHash), []byte("approval"), approve(t, client, clientKey, statement)}); res.Status == shim.OK {
+		t.Fatalf("rotateEnclaveKey should fail for approval of a non-admin")
+	}
+	admin, adminKey := th.CreateCreatorWithAttrsAndKey(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	stub.Creator = admin
+	if res := stub.MockInvoke("6", [][]byte{[]byte("rotateEnclaveKey"), []byte(enclaveID), []byte(thirdPkHash), []byte("approval"), approve(t, admin, adminKey, registry.RotationStatement("mychannel", enclaveID, newPkHash))}); res.Status == shim.OK {
+		t.Fatalf("rotateEnclaveKey should fail for approval of another rotation")
+	}
+	th.CheckInvoke(t, stub, [][]byte{[]byte("rotateEnclaveKey"), []byte(enclaveID), []byte(thirdPkHash), []byte("approval"), approve(t, admin, adminKey, statement)})
+	th.CheckQuery(t, stub, [][]byte{[]byte("getEnclaveID"), []byte(thirdPkHash)}, enclaveID)
+
+	// unlinkable quotes only identify the EPID group of the platform
+	groupSk, groupPkHash := register(quote)
+	res = stub.MockInvoke("7", [][]byte{[]byte("getEnclaveID"), []byte(groupPkHash)})
+	groupID := string(res.Payload)
+	otherSk, otherPkHash := register(quote)
+	if res := stub.MockInvoke("8", [][]byte{[]byte("rotateEnclaveKey"), []byte(groupID), []byte(otherPkHash), []byte("signature"), endorse(groupSk, otherSk)}); res.Status == shim.OK {
+		t.Fatalf("rotateEnclaveKey should fail for a platform only identified by its EPID group id")
+	}
+}
+
+func TestEnclaveRegistry_ConfirmEnclave(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+	// only admins set the quorum
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setRegistrationQuorum"), []byte("2")}); res.Status == shim.OK {
+		t.Fatalf("setRegistrationQuorum should fail for non-admins")
+	}
+	stub.Creator = th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setRegistrationQuorum"), []byte("2")})
+
+	stub.Creator = th.CreateCreator(t, "Org1MSP", "registrar")
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+	th.CheckQueryNotNull(t, stub, [][]byte{[]byte("getPendingRegistration"), []byte(enclavePkHash)})
+	th.CheckQuery(t, stub, [][]byte{[]byte("isEndorsing"), []byte(enclavePkHash), []byte("mycc")}, "false")
+
+	// the registrar's org can not confirm twice
+	if res := stub.MockInvoke("1", [][]byte{[]byte("confirmEnclave"), []byte(enclavePkHash)}); res.Status == shim.OK {
+		t.Fatalf("confirmEnclave should fail for the registrar org")
+	}
+
+	stub.Creator = th.CreateCreator(t, "Org2MSP", "peer0")
+	th.CheckInvoke(t, stub, [][]byte{[]byte("confirmEnclave"), []byte(enclavePkHash)})
+
+	if res := stub.MockInvoke("2", [][]byte{[]byte("getPendingRegistration"), []byte(enclavePkHash)}); res.Status == shim.OK {
+		t.Fatalf("registration should not be pending anymore")
+	}
+	th.CheckQuery(t, stub, [][]byte{[]byte("isEndorsing"), []byte(enclavePkHash), []byte("mycc")}, "true")
+}
+
+func TestEnclaveRegistry_TwoPhaseRegistration(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+	stub.Creator = th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setRegistrationQuorum"), []byte("2")})
+
+	stub.Creator = th.CreateCreator(t, "Org1MSP", "registrar")
+	if res := stub.MockInvoke("1", [][]byte{[]byte("submitQuote"), []byte(enclavePK), []byte(quote), []byte("ecc")}); res.Status != shim.OK {
+		t.Fatalf("submitQuote failed: %s", res.Message)
+	}
+	th.CheckQueryNotNull(t, stub, [][]byte{[]byte("getQuoteSubmission"), []byte(enclavePkHash)})
+	stub.Creator = th.CreateCreator(t, "Org2MSP", "registrar")
+	if res := stub.MockInvoke("2", [][]byte{[]byte("submitQuote"), []byte(enclavePK), []byte(quote)}); res.Status == shim.OK {
+		t.Fatalf("submitQuote should fail for a quote submitted by another org")
+	}
+	stub.Creator = th.CreateCreator(t, "Org1MSP", "registrar")
+
+	// a failed finalization keeps the submission
+	otherQuote, _ := base64.StdEncoding.DecodeString(quote)
+	otherQuote[100] ^= 1
+	report, _ := (&mock.MockIAS{}).RequestAttestationReport(tls.Certificate{}, otherQuote)
+	reportAsBytes, _ := json.Marshal(report)
+	if res := stub.MockInvoke("3", [][]byte{[]byte("finalizeRegistration"), []byte(enclavePkHash), reportAsBytes}); res.Status == shim.OK {
+		t.Fatalf("finalizeRegistration should fail with a report of another quote")
+	}
+	th.CheckQueryNotNull(t, stub, [][]byte{[]byte("getQuoteSubmission"), []byte(enclavePkHash)})
+
+	// ercc requests the report from IAS
+	th.CheckInvoke(t, stub, [][]byte{[]byte("finalizeRegistration"), []byte(enclavePkHash), []byte(""), certPem, keyPem})
+	th.CheckStateNotNull(t, stub, enclavePkHash)
+	if res := stub.MockInvoke("4", [][]byte{[]byte("getQuoteSubmission"), []byte(enclavePkHash)}); res.Status == shim.OK {
+		t.Fatalf("quote submission should be removed")
+	}
+
+	res := stub.MockInvoke("5", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)})
+	if res.Status != shim.OK {
+		t.Fatalf("getEnclaveByPk failed: %s", res.Message)
+	}
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(res.Payload, record); err != nil {
+		t.Fatalf("Can not unmarshal enclave record: %s", err)
+	}
+	if record.Binding == nil || !bytes.Equal(record.Binding.Nonce, attestation.TxNonce("1")) {
+		t.Fatalf("Quote must bind the submitting transaction: %+v", record.Binding)
+	}
+
+	// other orgs attach their verification before the enclave becomes active
+	th.CheckQuery(t, stub, [][]byte{[]byte("isEndorsing"), []byte(enclavePkHash), []byte("mycc")}, "false")
+	stub.Creator = th.CreateCreator(t, "Org2MSP", "peer0")
+	th.CheckInvoke(t, stub, [][]byte{[]byte("confirmEnclave"), []byte(enclavePkHash)})
+	th.CheckQuery(t, stub, [][]byte{[]byte("isEndorsing"), []byte(enclavePkHash), []byte("mycc")}, "true")
+}
+
+func TestEnclaveRegistry_SubmissionEvidence(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	org1 := th.CreateCreator(t, "Org1MSP", "registrar")
+	org2 := th.CreateCreator(t, "Org2MSP", "verifier")
+	org3 := th.CreateCreator(t, "Org3MSP", "verifier")
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+	stub.Creator = th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setRegistrationQuorum"), []byte("3")})
+
+	stub.Creator = org1
+	th.CheckInvoke(t, stub, [][]byte{[]byte("submitQuote"), []byte(enclavePK), []byte(quote)})
+
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	report, _ := (&mock.MockIAS{}).RequestAttestationReport(tls.Certificate{}, quoteAsBytes)
+	reportAsBytes, _ := json.Marshal(report)
+	otherQuote, _ := base64.StdEncoding.DecodeString(quote)
+	otherQuote[100] ^= 1
+	otherReport, _ := (&mock.MockIAS{}).RequestAttestationReport(tls.Certificate{}, otherQuote)
+	otherReportAsBytes, _ := json.Marshal(otherReport)
+
+	stub.Creator = org2
+	if res := stub.MockInvoke("1", [][]byte{[]byte("attachEvidence"), []byte(enclavePkHash), otherReportAsBytes}); res.Status == shim.OK {
+		t.Fatalf("attachEvidence should fail with a report of another quote")
+	}
+	th.CheckInvoke(t, stub, [][]byte{[]byte("attachEvidence"), []byte(enclavePkHash), reportAsBytes})
+
+	// replacing the submission drops its evidence
+	stub.Creator = org1
+	th.CheckInvoke(t, stub, [][]byte{[]byte("submitQuote"), []byte(enclavePK), []byte(quote)})
+	evidenceKey, _ := stub.CreateCompositeKey(registry.SubmissionEvidenceObjectType, []string{enclavePkHash, "Org2MSP"})
+	if stub.State[evidenceKey] != nil {
+		t.Fatalf("Evidence of a replaced submission should be removed")
+	}
+
+	stub.Creator = org2
+	th.CheckInvoke(t, stub, [][]byte{[]byte("attachEvidence"), []byte(enclavePkHash), reportAsBytes})
+	stub.Creator = org3
+	th.CheckInvoke(t, stub, [][]byte{[]byte("attachEvidence"), []byte(enclavePkHash), reportAsBytes})
+
+	// the registration uses the attached evidence; the verifiers complete the quorum
+	stub.Creator = org1
+	th.CheckInvoke(t, stub, [][]byte{[]byte("finalizeRegistration"), []byte(enclavePkHash), []byte("")})
+	th.CheckStateNotNull(t, stub, enclavePkHash)
+	if stub.State[evidenceKey] != nil {
+		t.Fatalf("Evidence should be removed at finalization")
+	}
+	if res := stub.MockInvoke("2", [][]byte{[]byte("getPendingRegistration"), []byte(enclavePkHash)}); res.Status == shim.OK {
+		t.Fatalf("registration should not be pending anymore")
+	}
+	th.CheckQuery(t, stub, [][]byte{[]byte("isEndorsing"), []byte(enclavePkHash), []byte("mycc")}, "true")
+}
+
+func TestEnclaveRegistry_StaleSubmission(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+	org1 := th.CreateCreator(t, "Org1MSP", "registrar")
+	org2 := th.CreateCreator(t, "Org2MSP", "registrar")
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	stub.Creator = org1
+	th.CheckInvoke(t, stub, [][]byte{[]byte("submitQuote"), []byte(enclavePK), []byte(quote)})
+
+	// the submission was made two days ago
+	res := stub.MockInvoke("1", [][]byte{[]byte("getQuoteSubmission"), []byte(enclavePkHash)})
+	submission := &registry.QuoteSubmission{}
+	if err := json.Unmarshal(res.Payload, submission); err != nil {
+		t.Fatalf("Can not unmarshal quote submission: %s", err)
+	}
+	submission.SubmittedAt -= 2 * defaultSubmissionTimeout
+	submissionAsBytes, _ := registry.MarshalCanonical(submission)
+	stub.MockTransactionStart("2")
+	key, _ := stub.CreateCompositeKey(registry.SubmissionObjectType, []string{enclavePkHash})
+	stub.PutState(key, submissionAsBytes)
+	stub.MockTransactionEnd("2")
+
+	if res := stub.MockInvoke("3", [][]byte{[]byte("finalizeRegistration"), []byte(enclavePkHash), []byte(""), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("finalizeRegistration should fail for stale submissions")
+	}
+
+	// other orgs may replace stale submissions
+	stub.Creator = org2
+	th.CheckInvoke(t, stub, [][]byte{[]byte("submitQuote"), []byte(enclavePK), []byte(quote)})
+	res = stub.MockInvoke("4", [][]byte{[]byte("getQuoteSubmission"), []byte(enclavePkHash)})
+	if err := json.Unmarshal(res.Payload, submission); err != nil || submission.SubmittedBy != "Org2MSP" {
+		t.Fatalf("Submission should be replaced: %s", res.Payload)
+	}
+	th.CheckInvoke(t, stub, [][]byte{[]byte("finalizeRegistration"), []byte(enclavePkHash), []byte(""), certPem, keyPem})
+	th.CheckStateNotNull(t, stub, enclavePkHash)
+}
+
+func TestEnclaveRegistry_RegistrationQuota(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	member := th.CreateCreator(t, "Org2MSP", "member")
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	// only admins set quotas
+	stub.Creator = member
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setRegistrationQuota"), []byte("1"), []byte("0"), []byte("0")}); res.Status == shim.OK {
+		t.Fatalf("setRegistrationQuota should fail for non-admins")
+	}
+	stub.Creator = admin
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setRegistrationQuota"), []byte("1"), []byte("0"), []byte("0")})
+
+	// every registration needs another enclave key
+	registerArgs := func() [][]byte {
+		return [][]byte{[]byte("registerEnclave"), []byte(newEnclavePk(t)), []byte(quote), certPem, keyPem}
+	}
+
+	stub.Creator = member
+	th.CheckInvoke(t, stub, registerArgs())
+	if res := stub.MockInvoke("2", registerArgs()); res.Status == shim.OK {
+		t.Fatalf("registerEnclave should exceed quota")
+	}
+
+	// admins are not subject to quotas and can reset the usage of an org
+	stub.Creator = admin
+	th.CheckInvoke(t, stub, registerArgs())
+	th.CheckInvoke(t, stub, [][]byte{[]byte("resetRegistrationUsage"), []byte("Org2MSP")})
+
+	stub.Creator = member
+	th.CheckInvoke(t, stub, registerArgs())
+}
+
+func TestEnclaveRegistry_SignRegistration(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("getAttestationReport"), []byte(enclavePkHash)})
+	if res.Status != shim.OK {
+		t.Fatalf("getAttestationReport failed: %s", res.Message)
+	}
+	report := attestation.IASAttestationReport{}
+	if err := json.Unmarshal(res.Payload, &report); err != nil {
+		t.Fatalf("Can not unmarshal attestation report: %s", err)
+	}
+	digest, err := registry.ReportDigest(report)
+	if err != nil {
+		t.Fatalf("Can not compute report digest: %s", err)
+	}
+
+	creator, priv := th.CreateCreatorWithKey(t, "Org1MSP", "registrar")
+	r, ss, err := ecdsa.Sign(rand.Reader, priv, digest)
+	if err != nil {
+		t.Fatalf("Can not sign digest: %s", err)
+	}
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, ss})
+	if err != nil {
+		t.Fatalf("Can not marshal signature: %s", err)
+	}
+
+	// signatures of other identities are rejected
+	stub.Creator = th.CreateCreator(t, "Org2MSP", "someone")
+	signArgs := [][]byte{[]byte("signRegistration"), []byte(enclavePkHash), []byte(base64.StdEncoding.EncodeToString(signature))}
+	if res := stub.MockInvoke("2", signArgs); res.Status == shim.OK {
+		t.Fatalf("signRegistration should fail for signature of another identity")
+	}
+
+	stub.Creator = creator
+	th.CheckInvoke(t, stub, signArgs)
+
+	res = stub.MockInvoke("3", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)})
+	if res.Status != shim.OK {
+		t.Fatalf("getEnclaveByPk failed: %s", res.Message)
+	}
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(res.Payload, record); err != nil {
+		t.Fatalf("Can not unmarshal enclave record: %s", err)
+	}
+	if record.RegistrarSignature == nil {
+		t.Fatalf("Enclave record misses registrar signature")
+	}
+	if err := record.RegistrarSignature.Verify(record.AttestationReport); err != nil {
+		t.Fatalf("Registrar signature not valid: %s", err)
+	}
+}
+
+func TestEnclaveRegistry_RegisterBoundEnclave(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	if res := stub.MockInvoke("1", [][]byte{[]byte("registerBoundEnclave"), []byte(enclavePK), []byte(quote)}); res.Status == shim.OK {
+		t.Fatalf("registerBoundEnclave should fail without chaincode id")
+	}
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerBoundEnclave"), []byte(enclavePK), []byte(quote), []byte("ecc"), certPem, keyPem})
+
+	res := stub.MockInvoke("2", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)})
+	if res.Status != shim.OK {
+		t.Fatalf("getEnclaveByPk failed: %s", res.Message)
+	}
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(res.Payload, record); err != nil {
+		t.Fatalf("Can not unmarshal enclave record: %s", err)
+	}
+	if record.Binding == nil || record.Binding.ChaincodeID != "ecc" {
+		t.Fatalf("Enclave record misses binding: %+v", record.Binding)
+	}
+}
+
+func TestEnclaveRegistry_PsePolicy(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	// only admins set the PSE policy
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setPsePolicy"), []byte("true"), []byte("false")}); res.Status == shim.OK {
+		t.Fatalf("setPsePolicy should fail for non-admins")
+	}
+	stub.Creator = th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setPsePolicy"), []byte("true"), []byte("false")})
+
+	// mock IAS does not report a PSE manifest
+	if res := stub.MockInvoke("1", [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("registerEnclave should fail without PSE manifest")
+	}
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setPsePolicy"), []byte("false"), []byte("false")})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+}
+
+func TestEnclaveRegistry_AlgorithmPolicy(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	// without a policy all supported algorithms are accepted
+	res := stub.MockInvoke("1", [][]byte{[]byte("getAlgorithmPolicy")})
+	if res.Status != shim.OK {
+		t.Fatalf("getAlgorithmPolicy failed: %s", res.Message)
+	}
+	policy := &registry.AlgorithmPolicy{}
+	if err := json.Unmarshal(res.Payload, policy); err != nil {
+		t.Fatalf("Can not unmarshal algorithm policy: %s", err)
+	}
+	if len(policy.Accepted) != len(registry.DefaultAlgorithmPolicy().Accepted) {
+		t.Fatalf("Default policy should accept all supported algorithms: %v", policy.Accepted)
+	}
+
+	// only admins set the policy
+	p256 := []byte(algorithm.Default.String())
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setAlgorithmPolicy"), p256}); res.Status == shim.OK {
+		t.Fatalf("setAlgorithmPolicy should fail for non-admins")
+	}
+	stub.Creator = admin
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setAlgorithmPolicy"), []byte("RSA/RSA")}); res.Status == shim.OK {
+		t.Fatalf("setAlgorithmPolicy should fail for unsupported algorithms")
+	}
+	// the enclave creates P-256 keys only
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setAlgorithmPolicy"), []byte("ECDSA-P384/ECDH-P384")}); res.Status == shim.OK {
+		t.Fatalf("setAlgorithmPolicy should fail for P-384")
+	}
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setAlgorithmPolicy"), p256})
+
+	// the test enclave has a P-256 key
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+
+	res = stub.MockInvoke("3", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)})
+	if res.Status != shim.OK {
+		t.Fatalf("getEnclaveByPk failed: %s", res.Message)
+	}
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(res.Payload, record); err != nil {
+		t.Fatalf("Can not unmarshal enclave record: %s", err)
+	}
+	if record.Algorithms == nil || *record.Algorithms != algorithm.Default {
+		t.Fatalf("Enclave record should have algorithms %s but has %v", algorithm.Default, record.Algorithms)
+	}
+}
+
+func TestEnclaveRegistry_Transcript(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	stub.ChannelID = "ch1"
+	certPem, keyPem := createIASClientCert(t)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	// only admins enable the transcript
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setTranscriptConfig"), []byte("true"), []byte("auditors")}); res.Status == shim.OK {
+		t.Fatalf("setTranscriptConfig should fail for non-admins")
+	}
+	stub.Creator = admin
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setTranscriptConfig"), []byte("true"), []byte("auditors")})
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+
+	stub.MockTransactionStart("2")
+	status, _ := json.Marshal(&registry.EnclaveStatus{QuoteStatus: registry.QuoteStatusGroupOutOfDate, AdvisoryIDs: []string{"INTEL-SA-00161"}})
+	statusKey, _ := stub.CreateCompositeKey(registry.StatusObjectType, []string{enclavePkHash})
+	stub.PutState(statusKey, status)
+	stub.MockTransactionEnd("2")
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setAdvisory"), []byte("INTEL-SA-00161"), []byte(registry.AdvisoryActionRevoke), []byte("L1 Terminal Fault")})
+
+	// entries are kept in the collection, the head is public
+	entryKey, _ := stub.CreateCompositeKey(registry.TranscriptObjectType, []string{registry.TranscriptKey(0)})
+	if entry, _ := stub.GetState(entryKey); entry != nil {
+		t.Fatalf("Transcript entry should not be in the public state")
+	}
+	if res := stub.MockInvoke("3", [][]byte{[]byte("setTranscriptConfig"), []byte("true")}); res.Status == shim.OK {
+		t.Fatalf("The collection of a non-empty transcript should not change")
+	}
+
+	res := stub.MockInvoke("4", [][]byte{[]byte("getTranscript"), []byte("0")})
+	if res.Status != shim.OK {
+		t.Fatalf("getTranscript failed: %s", res.Message)
+	}
+	page := &registry.TranscriptPage{}
+	if err := json.Unmarshal(res.Payload, page); err != nil {
+		t.Fatalf("Can not unmarshal transcript page: %s", err)
+	}
+	if len(page.Entries) != 2 || page.Entries[0].Kind != registry.TranscriptRegistration || page.Entries[1].Kind != registry.TranscriptRevocation {
+		t.Fatalf("Expected registration and revocation but got %+v", page.Entries)
+	}
+	head := &registry.TranscriptHead{ChannelID: "ch1"}
+	for i := range page.Entries {
+		var err error
+		if head, err = head.Append(&page.Entries[i]); err != nil {
+			t.Fatalf("Transcript is not a hash chain: %s", err)
+		}
+	}
+	if *head != page.Head {
+		t.Fatalf("Expected head %+v but got %+v", head, page.Head)
+	}
+
+	// mirror the transcript to an audit channel
+	auditStub := shim.NewMockStub("ercc", NewTestErcc())
+	auditStub.ChannelID = "audit"
+	th.CheckInit(t, auditStub, [][]byte{})
+	entriesAsBytes, _ := json.Marshal(page.Entries)
+	th.CheckInvoke(t, auditStub, [][]byte{[]byte("importTranscript"), entriesAsBytes})
+	if res := auditStub.MockInvoke("1", [][]byte{[]byte("importTranscript"), entriesAsBytes}); res.Status == shim.OK {
+		t.Fatalf("importTranscript should reject entries imported before")
+	}
+
+	res = auditStub.MockInvoke("2", [][]byte{[]byte("getImportedTranscript"), []byte("ch1"), []byte("1"), []byte("10")})
+	if res.Status != shim.OK {
+		t.Fatalf("getImportedTranscript failed: %s", res.Message)
+	}
+	imported := &registry.TranscriptPage{}
+	if err := json.Unmarshal(res.Payload, imported); err != nil {
+		t.Fatalf("Can not unmarshal transcript page: %s", err)
+	}
+	if len(imported.Entries) != 1 || imported.Head != page.Head {
+		t.Fatalf("Unexpected imported transcript %+v", imported)
+	}
+
+	// a channel imports no transcript of its own
+	if res := stub.MockInvoke("5", [][]byte{[]byte("importTranscript"), entriesAsBytes}); res.Status == shim.OK {
+		t.Fatalf("importTranscript should reject the transcript of the channel itself")
+	}
+}
+
+func TestEnclaveRegistry_ForeignEnclave(t *testing.T) {
+	certPem, keyPem := createIASClientCert(t)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// the enclave is registered on ch2
+	sourceStub := shim.NewMockStub("ercc", NewTestErcc())
+	sourceStub.ChannelID = "ch2"
+	th.CheckInit(t, sourceStub, [][]byte{})
+	allowDebugEnclaves(t, sourceStub)
+	th.CheckInvoke(t, sourceStub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+	res := sourceStub.MockInvoke("1", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)})
+	if res.Status != shim.OK {
+		t.Fatalf("getEnclaveByPk failed: %s", res.Message)
+	}
+	recordAsBytes := res.Payload
+
+	// and used by chaincodes on ch1
+	stub := shim.NewMockStub("ercc", NewTestErcc())
+	stub.ChannelID = "ch1"
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	importArgs := [][]byte{[]byte("importForeignEnclave"), []byte("ch2"), recordAsBytes}
+	verifyArgs := [][]byte{[]byte("verifyForeignEnclave"), []byte("ch2"), []byte(enclavePK)}
+	if res := stub.MockInvoke("1", importArgs); res.Status == shim.OK {
+		t.Fatalf("importForeignEnclave should fail for channels not allowed")
+	}
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setForeignChannels"), []byte("0"), []byte("ch2")}); res.Status == shim.OK {
+		t.Fatalf("setForeignChannels should fail for non-admins")
+	}
+	stub.Creator = admin
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setForeignChannels"), []byte("0"), []byte("ch1")}); res.Status == shim.OK {
+		t.Fatalf("setForeignChannels should reject the channel itself")
+	}
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setForeignChannels"), []byte("3600"), []byte("ch2")})
+
+	// records must match their attestation evidence
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(recordAsBytes, record); err != nil {
+		t.Fatalf("Can not unmarshal enclave record: %s", err)
+	}
+	forged := *record
+	forged.EnclavePkHash = registry.EnclavePkHash([]byte("other"))
+	forgedAsBytes, _ := json.Marshal(&forged)
+	if res := stub.MockInvoke("2", [][]byte{[]byte("importForeignEnclave"), []byte("ch2"), forgedAsBytes}); res.Status == shim.OK {
+		t.Fatalf("importForeignEnclave should fail for records of another enclave")
+	}
+	if res := stub.MockInvoke("2", verifyArgs); res.Status == shim.OK {
+		t.Fatalf("verifyForeignEnclave should fail for enclaves not imported")
+	}
+
+	th.CheckInvoke(t, stub, importArgs)
+	res = stub.MockInvoke("3", verifyArgs)
+	if res.Status != shim.OK {
+		t.Fatalf("verifyForeignEnclave failed: %s", res.Message)
+	}
+	foreign := &registry.ForeignEnclave{}
+	if err := json.Unmarshal(res.Payload, foreign); err != nil {
+		t.Fatalf("Can not unmarshal foreign enclave: %s", err)
+	}
+	if foreign.ChannelID != "ch2" || foreign.Record.EnclavePkHash != enclavePkHash || foreign.ImportedBy != "Org1MSP" {
+		t.Fatalf("Unexpected foreign enclave %+v", foreign)
+	}
+	// enclaves of ch2 are not registered on ch1
+	if res := stub.MockInvoke("3", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)}); res.Status == shim.OK {
+		t.Fatalf("Imported enclave should not be registered")
+	}
+
+	// imports must be refreshed within the max age
+	stale := *foreign
+	stale.ImportedAt -= 3601
+	staleAsBytes, _ := json.Marshal(&stale)
+	stub.MockTransactionStart("4")
+	foreignKey, _ := stub.CreateCompositeKey(registry.ForeignEnclaveObjectType, []string{"ch2", enclavePkHash})
+	stub.PutState(foreignKey, staleAsBytes)
+	stub.MockTransactionEnd("4")
+	if res := stub.MockInvoke("5", verifyArgs); res.Status == shim.OK {
+		t.Fatalf("verifyForeignEnclave should fail for outdated imports")
+	}
+
+	// revocations are relayed and final
+	th.CheckInvoke(t, stub, importArgs)
+	revoked := *record
+	revoked.Status = &registry.EnclaveStatus{QuoteStatus: registry.QuoteStatusOK, RevokedBy: []string{"INTEL-SA-00161"}}
+	revokedAsBytes, _ := json.Marshal(&revoked)
+	th.CheckInvoke(t, stub, [][]byte{[]byte("importForeignEnclave"), []byte("ch2"), revokedAsBytes})
+	if res := stub.MockInvoke("6", verifyArgs); res.Status == shim.OK {
+		t.Fatalf("verifyForeignEnclave should fail for revoked enclaves")
+	}
+	if res := stub.MockInvoke("6", importArgs); res.Status == shim.OK {
+		t.Fatalf("importForeignEnclave should not resurrect revoked enclaves")
+	}
+
+	// admins remove imports, e.g., to re-import after a wrongly relayed revocation
+	th.CheckInvoke(t, stub, [][]byte{[]byte("removeForeignEnclave"), []byte("ch2"), []byte(enclavePkHash)})
+	if res := stub.MockInvoke("7", [][]byte{[]byte("getForeignEnclave"), []byte("ch2"), []byte(enclavePkHash)}); res.Status == shim.OK {
+		t.Fatalf("Removed enclave should not be found")
+	}
+	th.CheckInvoke(t, stub, importArgs)
+}
+
+func TestEnclaveRegistry_GetEnclavesByPlatform(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	// turn the test quote into a linkable one
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	quoteAsBytes[2] = 1
+	linkableQuote := base64.StdEncoding.EncodeToString(quoteAsBytes)
+
+	// two enclaves on the same platform
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	otherPk, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("Can not marshal key: %s", err)
+	}
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(linkableQuote), certPem, keyPem})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(base64.StdEncoding.EncodeToString(otherPk)), []byte(linkableQuote), certPem, keyPem})
+
+	// the platform id of enclaves with linkable quotes is the EPID pseudonym
+	res := stub.MockInvoke("1", [][]byte{[]byte("getEnclaveID"), []byte(enclavePkHash)})
+	res = stub.MockInvoke("1", [][]byte{[]byte("getEnclaveIdentity"), res.Payload})
+	identity := &registry.EnclaveIdentity{}
+	if err := json.Unmarshal(res.Payload, identity); err != nil {
+		t.Fatalf("Can not unmarshal enclave identity: %s", err)
+	}
+
+	res = stub.MockInvoke("2", [][]byte{[]byte("getEnclavesByPlatform"), []byte(identity.PlatformID)})
+	if res.Status != shim.OK {
+		t.Fatalf("getEnclavesByPlatform failed: %s", res.Message)
+	}
+	platform := &registry.PlatformEnclaves{}
+	if err := json.Unmarshal(res.Payload, platform); err != nil {
+		t.Fatalf("Can not unmarshal platform enclaves: %s", err)
+	}
+	if len(platform.EnclavePkHashes) != 2 {
+		t.Fatalf("Expected two enclaves on platform but got %v", platform.EnclavePkHashes)
+	}
+}
+
+func TestEnclaveRegistry_AttestationPolicy(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+
+	reportBody, _ := json.Marshal(&attestation.IASReportBody{IsvEnclaveQuoteBody: quote})
+	enclaveQuote, err := attestation.QuoteFromAttestionReport(attestation.IASAttestationReport{IASReportBody: reportBody})
+	if err != nil {
+		t.Fatalf("Can not parse quote: %s", err)
+	}
+
+	// only admins set the policy
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setAttestationPolicy"), []byte("{}")}); res.Status == shim.OK {
+		t.Fatalf("setAttestationPolicy should fail for non-admins")
+	}
+
+	// the test quote comes from a debug enclave, which is rejected without a policy allowing debug enclaves
+	registerArgs := [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem}
+	if res := stub.MockInvoke("2", registerArgs); res.Status == shim.OK {
+		t.Fatalf("registerEnclave should fail for debug enclaves")
+	}
+
+	stub.Creator = admin
+	otherPolicy, _ := json.Marshal(&registry.AttestationPolicy{MrEnclaves: []string{enclavePkHash}, AllowDebugEnclaves: true})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setAttestationPolicy"), otherPolicy})
+	if res := stub.MockInvoke("2", registerArgs); res.Status == shim.OK {
+		t.Fatalf("registerEnclave should fail for mrenclave not in policy")
+	}
+
+	policy, _ := json.Marshal(&registry.AttestationPolicy{MrEnclaves: []string{registry.MrEnclave(enclaveQuote)}, AllowDebugEnclaves: true})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setAttestationPolicy"), policy})
+	th.CheckInvoke(t, stub, registerArgs)
+
+	res := stub.MockInvoke("3", [][]byte{[]byte("getAttestationPolicy")})
+	stored := &registry.AttestationPolicy{}
+	if err := json.Unmarshal(res.Payload, stored); err != nil {
+		t.Fatalf("Can not unmarshal attestation policy: %s", err)
+	}
+	if stored.Version != 2 {
+		t.Fatalf("Expected policy version 2 but got %d", stored.Version)
+	}
+
+	// every update is recorded in an audit event
+	var changes []registry.AttestationPolicyChange
+	for len(stub.ChaincodeEventsChannel) > 0 {
+		event := <-stub.ChaincodeEventsChannel
+		if event.EventName != registry.AttestationPolicyChangedEventName {
+			continue
+		}
+		change := registry.AttestationPolicyChange{}
+		if err := json.Unmarshal(event.Payload, &change); err != nil {
+			t.Fatalf("Can not unmarshal policy change: %s", err)
+		}
+		changes = append(changes, change)
+	}
+	digest, _ := stored.Digest()
+	if len(changes) != 2 || changes[0].OldDigest != "" || changes[1].OldDigest != changes[0].NewDigest ||
+		changes[1].NewDigest != digest || changes[1].OldVersion != 1 || changes[1].MspID != "Org1MSP" {
+		t.Fatalf("Unexpected policy changes %+v", changes)
+	}
+}
+
+func TestEnclaveRegistry_RegistrationExpiry(t *testing.T) {
+	ercc := NewTestErcc()
+	ercc.ledger = &mockLedger{height: 5}
+	stub := shim.NewMockStub("ercc", ercc)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	stub.Creator = admin
+	policy, _ := json.Marshal(&registry.AttestationPolicy{RegistrationValidity: 3600, AllowDebugEnclaves: true})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setAttestationPolicy"), policy})
+
+	// reports issued by IAS at the given time
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	reportAt := func(issued time.Time) []byte {
+		report, _ := (&mock.MockIAS{}).RequestAttestationReport(tls.Certificate{}, quoteAsBytes)
+		reportBody := attestation.IASReportBody{}
+		json.Unmarshal(report.IASReportBody, &reportBody)
+		reportBody.Timestamp = issued.UTC().Format("2006-01-02T15:04:05.999999")
+		report.IASReportBody, _ = json.Marshal(&reportBody)
+		reportAsBytes, _ := json.Marshal(report)
+		return reportAsBytes
+	}
+
+	if res := stub.MockInvoke("1", [][]byte{[]byte("registerEnclaveWithReport"), []byte(newEnclavePk(t)), []byte(quote), reportAt(time.Now().Add(-2 * time.Hour))}); res.Status == shim.OK {
+		t.Fatalf("registerEnclaveWithReport should fail for expired reports")
+	}
+
+	issued := time.Now().Add(-30 * time.Minute)
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclaveWithReport"), []byte(enclavePK), []byte(quote), reportAt(issued)})
+	th.CheckQuery(t, stub, [][]byte{[]byte("isEndorsing"), []byte(enclavePkHash), []byte("mycc")}, "true")
+
+	// expiry counts from the IAS timestamp
+	res := stub.MockInvoke("2", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)})
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(res.Payload, record); err != nil {
+		t.Fatalf("Can not unmarshal enclave record: %s", err)
+	}
+	if record.ExpiresAt != issued.Unix()+3600 {
+		t.Fatalf("Expected expiry %d but got %d", issued.Unix()+3600, record.ExpiresAt)
+	}
+
+	policy, _ = json.Marshal(&registry.AttestationPolicy{RegistrationValidity: 1200, AllowDebugEnclaves: true})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setAttestationPolicy"), policy})
+
+	// expiry is decided with the ledger clock, like in the ecc vscc
+	th.CheckQuery(t, stub, [][]byte{[]byte("isEndorsing"), []byte(enclavePkHash), []byte("mycc")}, "true")
+	th.CheckInvoke(t, stub, [][]byte{[]byte("advanceLedgerClock")})
+	th.CheckQuery(t, stub, [][]byte{[]byte("isEndorsing"), []byte(enclavePkHash), []byte("mycc")}, "false")
+}
+
+func TestEnclaveRegistry_PruneRegistry(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	user := th.CreateCreatorWithAttrs(t, "Org1MSP", "user", map[string]string{})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	stub.Creator = admin
+	policy, _ := json.Marshal(&registry.AttestationPolicy{RegistrationValidity: 3600, AllowDebugEnclaves: true})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setAttestationPolicy"), policy})
+
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	report, _ := (&mock.MockIAS{}).RequestAttestationReport(tls.Certificate{}, quoteAsBytes)
+	reportBody := attestation.IASReportBody{}
+	json.Unmarshal(report.IASReportBody, &reportBody)
+	reportBody.Timestamp = time.Now().Add(-50 * time.Minute).UTC().Format("2006-01-02T15:04:05.999999")
+	report.IASReportBody, _ = json.Marshal(&reportBody)
+	reportAsBytes, _ := json.Marshal(report)
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclaveWithReport"), []byte(enclavePK), []byte(quote), reportAsBytes})
+
+	stub.Creator = user
+	if res := stub.MockInvoke("1", [][]byte{[]byte("pruneRegistry"), []byte("0")}); res.Status == shim.OK {
+		t.Fatalf("pruneRegistry should be restricted to admins")
+	}
+
+	stub.Creator = admin
+	th.CheckInvoke(t, stub, [][]byte{[]byte("pruneRegistry"), []byte("0")})
+	th.CheckStateNotNull(t, stub, enclavePkHash)
+
+	// the registration expired 30 minutes ago
+	policy, _ = json.Marshal(&registry.AttestationPolicy{RegistrationValidity: 1200, AllowDebugEnclaves: true})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setAttestationPolicy"), policy})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("pruneRegistry"), []byte("3600")})
+	th.CheckStateNotNull(t, stub, enclavePkHash)
+
+	res := stub.MockInvoke("2", [][]byte{[]byte("pruneRegistry"), []byte("600")})
+	if res.Status != shim.OK {
+		t.Fatalf("pruneRegistry failed: %s", res.Message)
+	}
+	var tombstones []registry.Tombstone
+	if err := json.Unmarshal(res.Payload, &tombstones); err != nil || len(tombstones) != 1 {
+		t.Fatalf("Expected one pruned registration but got %s", res.Payload)
+	}
+	if tombstones[0].EnclavePkHash != enclavePkHash || tombstones[0].Reason != registry.PruneReasonExpired {
+		t.Fatalf("Unexpected tombstone %+v", tombstones[0])
+	}
+	pruned := false
+	for len(stub.ChaincodeEventsChannel) > 0 {
+		event := <-stub.ChaincodeEventsChannel
+		pruned = pruned || event.EventName == registry.RegistryPrunedEventName
+	}
+	if !pruned {
+		t.Fatalf("Expected %s event", registry.RegistryPrunedEventName)
+	}
+	if stub.State[enclavePkHash] != nil {
+		t.Fatalf("Pruned registration should be deleted")
+	}
+	th.CheckInvoke(t, stub, [][]byte{[]byte("getTombstone"), []byte(enclavePkHash)})
+
+	if res := stub.MockInvoke("3", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)}); res.Status == shim.OK || !strings.Contains(res.Message, "pruned") {
+		t.Fatalf("getEnclaveByPk should report the pruned registration: %s", res.Message)
+	}
+	if res := stub.MockInvoke("4", [][]byte{[]byte("registerEnclaveWithReport"), []byte(enclavePK), []byte(quote), reportAsBytes}); res.Status == shim.OK {
+		t.Fatalf("Pruned enclave key should not be registered again")
+	}
+}
+
+func TestEnclaveRegistry_Collateral(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	user := th.CreateCreatorWithAttrs(t, "Org1MSP", "user", map[string]string{})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+	stub.Creator = admin
+
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	report, _ := (&mock.MockIAS{}).RequestAttestationReport(tls.Certificate{}, quoteAsBytes)
+	reportAsBytes, _ := json.Marshal(report)
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclaveWithReport"), []byte(enclavePK), []byte(quote), reportAsBytes})
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("getCollateral"), []byte(enclavePkHash)})
+	if res.Status != shim.OK {
+		t.Fatalf("getCollateral failed: %s", res.Message)
+	}
+	collateral := attestation.Collateral{}
+	if err := json.Unmarshal(res.Payload, &collateral); err != nil {
+		t.Fatalf("Can not unmarshal collateral: %s", err)
+	}
+	if collateral.Type != attestation.CollateralTypeIAS || collateral.VerificationKey == "" {
+		t.Fatalf("Unexpected collateral: %+v", collateral)
+	}
+
+	ias, err := mock.NewSigningIAS()
+	if err != nil {
+		t.Fatalf("Can not create signing IAS: %s", err)
+	}
+	crl, _ := ias.CRL(time.Time{})
+
+	stub.Creator = user
+	if res := stub.MockInvoke("1", [][]byte{[]byte("putCRL"), crl}); res.Status == shim.OK {
+		t.Fatalf("putCRL should be restricted to admins")
+	}
+	stub.Creator = admin
+	th.CheckInvoke(t, stub, [][]byte{[]byte("putCRL"), crl})
+	if res := stub.MockInvoke("1", [][]byte{[]byte("putCRL"), crl}); res.Status == shim.OK {
+		t.Fatalf("putCRL should reject a CRL that is not newer than the stored one")
+	}
+	if res := stub.MockInvoke("1", [][]byte{[]byte("putCRL"), []byte("not a CRL")}); res.Status == shim.OK {
+		t.Fatalf("putCRL should reject malformed CRLs")
+	}
+}
+
+func TestEnclaveRegistry_ReplayCache(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	stub.Creator = admin
+	policy, _ := json.Marshal(&registry.AttestationPolicy{ReplayCacheTTL: 3600, AllowDebugEnclaves: true})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setAttestationPolicy"), policy})
+
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	report, _ := (&mock.MockIAS{}).RequestAttestationReport(tls.Certificate{}, quoteAsBytes)
+	reportAsBytes, _ := json.Marshal(report)
+	registerArgs := [][]byte{[]byte("registerEnclaveWithReport"), []byte(enclavePK), []byte(quote), reportAsBytes}
+	th.CheckInvoke(t, stub, registerArgs)
+
+	pkBytes, _ := base64.StdEncoding.DecodeString(enclavePK)
+	reportData, _ := attestation.ComputeReportData(pkBytes, nil)
+	reportDataHash := sha256.Sum256(reportData[:])
+	key, _ := stub.CreateCompositeKey(registry.ReplayObjectType, []string{base64.StdEncoding.EncodeToString(reportDataHash[:])})
+	entry := &registry.ReplayEntry{}
+	if err := json.Unmarshal(stub.State[key], entry); err != nil {
+		t.Fatalf("Can not unmarshal replay cache entry: %s", err)
+	}
+	if entry.EnclavePkHash != enclavePkHash || entry.ExpiresAt != entry.UsedAt+3600 {
+		t.Fatalf("Unexpected replay cache entry %+v", entry)
+	}
+
+	// the quote can not back another registration even if the first one is gone
+	stub.MockTransactionStart("2")
+	stub.DelState(enclavePkHash)
+	stub.MockTransactionEnd("2")
+	if res := stub.MockInvoke("3", registerArgs); res.Status == shim.OK || !strings.Contains(res.Message, "replayed") {
+		t.Fatalf("Replayed quote should be rejected: %s", res.Message)
+	}
+
+	stub.MockTransactionStart("4")
+	if n, err := pruneReplayCache(stub, entry.ExpiresAt-1, 10); err != nil || n != 0 {
+		t.Fatalf("Replay cache entry should not be pruned before it expired")
+	}
+	if n, err := pruneReplayCache(stub, entry.ExpiresAt, 10); err != nil || n != 1 {
+		t.Fatalf("Expired replay cache entry should be pruned")
+	}
+	stub.MockTransactionEnd("4")
+	if stub.State[key] != nil {
+		t.Fatalf("Pruned replay cache entry should be deleted")
+	}
+}
+
+func TestEnclaveRegistry_RegisterEnclaveWithReport(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	// the client obtains the report
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	report, err := (&mock.MockIAS{}).RequestAttestationReport(tls.Certificate{}, quoteAsBytes)
+	if err != nil {
+		t.Fatalf("Can not create attestation report: %s", err)
+	}
+	reportAsBytes, _ := json.Marshal(report)
+
+	// report must be issued for the quote
+	otherQuote := append([]byte{}, quoteAsBytes...)
+	otherQuote[100] ^= 1
+	if res := stub.MockInvoke("1", [][]byte{[]byte("registerEnclaveWithReport"), []byte(enclavePK), []byte(base64.StdEncoding.EncodeToString(otherQuote)), reportAsBytes}); res.Status == shim.OK {
+		t.Fatalf("registerEnclaveWithReport should fail with a report of another quote")
+	}
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclaveWithReport"), []byte(enclavePK), []byte(quote), reportAsBytes})
+	th.CheckStateNotNull(t, stub, enclavePkHash)
+
+	otherPk := newEnclavePk(t)
+	res := stub.MockInvoke("2", [][]byte{[]byte("registerBoundEnclaveWithReport"), []byte(otherPk), []byte(quote), reportAsBytes, []byte("ecc")})
+	if res.Status != shim.OK {
+		t.Fatalf("registerBoundEnclaveWithReport failed: %s", res.Message)
+	}
+	res = stub.MockInvoke("3", [][]byte{[]byte("getEnclaveByPk"), []byte(otherPk)})
+	if res.Status != shim.OK {
+		t.Fatalf("getEnclaveByPk failed: %s", res.Message)
+	}
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(res.Payload, record); err != nil {
+		t.Fatalf("Can not unmarshal enclave record: %s", err)
+	}
+	if record.Binding == nil || record.Binding.ChaincodeID != "ecc" {
+		t.Fatalf("Enclave record misses binding: %+v", record.Binding)
+	}
+}
+
+func TestEnclaveRegistry_RenewEnclave(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, err := x509.MarshalPKIXPublicKey(&sk.PublicKey)
+	if err != nil {
+		t.Fatalf("Can not marshal key: %s", err)
+	}
+	pkBase64 := []byte(base64.StdEncoding.EncodeToString(pk))
+	pkHash := registry.EnclavePkHash(pk)
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	sign := func(key *ecdsa.PrivateKey, txID string) []byte {
+		digest := sha256.Sum256(registry.RenewalMessage(txID, quoteAsBytes))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("Can not sign renewal: %s", err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return []byte(base64.StdEncoding.EncodeToString(sig))
+	}
+	chaincodeEnclaves := func(txID, chaincodeID string) []registry.ChaincodeEnclave {
+		res := stub.MockInvoke(txID, [][]byte{[]byte("getChaincodeEnclaves"), []byte(chaincodeID)})
+		if res.Status != shim.OK {
+			t.Fatalf("getChaincodeEnclaves failed: %s", res.Message)
+		}
+		enclaves := &registry.ChaincodeEnclaves{}
+		if err := json.Unmarshal(res.Payload, enclaves); err != nil {
+			t.Fatalf("Can not unmarshal chaincode enclaves: %s", err)
+		}
+		return enclaves.Enclaves
+	}
+
+	if res := stub.MockInvoke("1", [][]byte{[]byte("renewEnclave"), pkBase64, []byte(quote), []byte(""), []byte(""), sign(sk, "1"), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("renewEnclave should fail for unregistered enclaves")
+	}
+
+	res := stub.MockInvoke("2", [][]byte{[]byte("registerBoundEnclave"), pkBase64, []byte(quote), []byte("ecc"), certPem, keyPem})
+	if res.Status != shim.OK {
+		t.Fatalf("registerBoundEnclave failed: %s", res.Message)
+	}
+	enclaveID := string(res.Payload)
+
+	// registrations are not overwritten silently
+	if res := stub.MockInvoke("3", [][]byte{[]byte("registerEnclave"), pkBase64, []byte(quote), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("registerEnclave should fail for registered enclaves")
+	}
+
+	// only the enclave may renew its registration, and only in the transaction it signed
+	otherSk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if res := stub.MockInvoke("4", [][]byte{[]byte("renewEnclave"), pkBase64, []byte(quote), []byte(""), []byte("ecc"), sign(otherSk, "4"), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("renewEnclave should fail without signature of the enclave")
+	}
+	if res := stub.MockInvoke("5", [][]byte{[]byte("renewEnclave"), pkBase64, []byte(quote), []byte(""), []byte("ecc"), sign(sk, "4"), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("renewEnclave should fail with the signature of another transaction")
+	}
+
+	res = stub.MockInvoke("6", [][]byte{[]byte("renewEnclave"), pkBase64, []byte(quote), []byte(""), []byte("ecc"), sign(sk, "6"), certPem, keyPem})
+	if res.Status != shim.OK {
+		t.Fatalf("renewEnclave failed: %s", res.Message)
+	}
+	if string(res.Payload) != enclaveID {
+		t.Fatalf("Renewal must keep the enclave identity")
+	}
+
+	enclaves := chaincodeEnclaves("7", "ecc")
+	if len(enclaves) != 1 || enclaves[0].EnclaveID != enclaveID || enclaves[0].EnclavePkHash != pkHash {
+		t.Fatalf("Unexpected chaincode enclaves: %+v", enclaves)
+	}
+
+	res = stub.MockInvoke("8", [][]byte{[]byte("getEnclaveByPk"), pkBase64})
+	if res.Status != shim.OK {
+		t.Fatalf("getEnclaveByPk failed: %s", res.Message)
+	}
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(res.Payload, record); err != nil {
+		t.Fatalf("Can not unmarshal enclave record: %s", err)
+	}
+	if record.Binding == nil || !bytes.Equal(record.Binding.Nonce, attestation.TxNonce("6")) {
+		t.Fatalf("Renewed quote must bind the renewal transaction: %+v", record.Binding)
+	}
+
+	// renewing with an unbound quote moves the enclave out of the index of the chaincode
+	res = stub.MockInvoke("9", [][]byte{[]byte("renewEnclave"), pkBase64, []byte(quote), []byte(""), []byte(""), sign(sk, "9"), certPem, keyPem})
+	if res.Status != shim.OK {
+		t.Fatalf("renewEnclave failed: %s", res.Message)
+	}
+	if enclaves := chaincodeEnclaves("10", "ecc"); len(enclaves) != 0 {
+		t.Fatalf("Enclave must not be indexed for its previous chaincode: %+v", enclaves)
+	}
+	enclaves = chaincodeEnclaves("11", "")
+	if len(enclaves) != 1 || enclaves[0].EnclaveID != enclaveID || enclaves[0].EnclavePkHash != pkHash {
+		t.Fatalf("Unexpected unbound enclaves: %+v", enclaves)
+	}
+}
+
+func TestEnclaveRegistry_IdempotentRegistration(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	registerArgs := [][]byte{[]byte("registerBoundEnclave"), []byte(enclavePK), []byte(quote), []byte("ecc"), certPem, keyPem}
+	res := stub.MockInvoke("1", registerArgs)
+	if res.Status != shim.OK {
+		t.Fatalf("registerBoundEnclave failed: %s", res.Message)
+	}
+	enclaveID := string(res.Payload)
+	reportAsBytes := stub.State[enclavePkHash]
+
+	// identical evidence returns the existing registration without writing anything
+	res = stub.MockInvoke("2", registerArgs)
+	if res.Status != shim.OK {
+		t.Fatalf("Registering identical evidence should succeed: %s", res.Message)
+	}
+	if string(res.Payload) != enclaveID {
+		t.Fatalf("Expected existing enclave id %s, got %s", enclaveID, res.Payload)
+	}
+	if !bytes.Equal(stub.State[enclavePkHash], reportAsBytes) {
+		t.Fatalf("Registering identical evidence must not change the registration")
+	}
+
+	// another binding target is a collision
+	if res := stub.MockInvoke("3", [][]byte{[]byte("registerBoundEnclave"), []byte(enclavePK), []byte(quote), []byte("other"), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("registerBoundEnclave should fail for another chaincode")
+	}
+
+	// as is another quote
+	quoteAsBytes, _ := base64.StdEncoding.DecodeString(quote)
+	otherQuote := append([]byte{}, quoteAsBytes...)
+	otherQuote[100] ^= 1
+	if res := stub.MockInvoke("4", [][]byte{[]byte("registerBoundEnclave"), []byte(enclavePK), []byte(base64.StdEncoding.EncodeToString(otherQuote)), []byte("ecc"), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("registerBoundEnclave should fail for another quote")
+	}
+}
+
+func TestTxTimestampSource(t *testing.T) {
+	stub := shim.NewMockStub("ercc", NewTestErcc())
+	stub.MockTransactionStart("1")
+	defer stub.MockTransactionEnd("1")
+
+	now := time.Now()
+	source := &txTimestampSource{clock: func() time.Time { return now }, maxSkew: 300}
+	txTime, err := source.TxTime(stub)
+	if err != nil {
+		t.Fatalf("Transaction time should be accepted: %s", err)
+	}
+	if txTime != stub.TxTimestamp.Seconds {
+		t.Fatalf("Expected transaction timestamp but got %d", txTime)
+	}
+
+	// the client backdated the transaction
+	now = now.Add(time.Hour)
+	if _, err := source.TxTime(stub); err == nil {
+		t.Fatalf("Skewed transaction time should be rejected")
+	}
+
+	source.maxSkew = -1
+	if _, err := source.TxTime(stub); err != nil {
+		t.Fatalf("Skew check should be disabled: %s", err)
+	}
+}
+
+func TestEnclaveRegistry_StateCommitment(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	user := th.CreateCreatorWithAttrs(t, "Org1MSP", "user", map[string]string{})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	root := sha256.Sum256([]byte("state"))
+	rootBase64 := base64.StdEncoding.EncodeToString(root[:])
+
+	if res := stub.MockInvoke("1", [][]byte{[]byte("getStateCommitment"), []byte("ecc")}); res.Status == shim.OK {
+		t.Fatalf("getStateCommitment should fail before a commitment is published")
+	}
+
+	stub.Creator = user
+	if res := stub.MockInvoke("1", [][]byte{[]byte("putStateCommitment"), []byte("ecc"), []byte(rootBase64), []byte("3")}); res.Status == shim.OK {
+		t.Fatalf("putStateCommitment should be restricted to admins")
+	}
+	stub.Creator = admin
+	if res := stub.MockInvoke("1", [][]byte{[]byte("putStateCommitment"), []byte("ecc"), []byte("bm90IGEgcm9vdA=="), []byte("3")}); res.Status == shim.OK {
+		t.Fatalf("putStateCommitment should reject a root that is not a sha256 hash")
+	}
+	if res := stub.MockInvoke("1", [][]byte{[]byte("putStateCommitment"), []byte("ecc"), []byte(rootBase64), []byte("-1")}); res.Status == shim.OK {
+		t.Fatalf("putStateCommitment should reject a negative size")
+	}
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("putStateCommitment"), []byte("ecc"), []byte(rootBase64), []byte("3")})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("putStateCommitment"), []byte("ecc"), []byte(rootBase64), []byte("4")})
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("getStateCommitment"), []byte("ecc")})
+	if res.Status != shim.OK {
+		t.Fatalf("getStateCommitment failed: %s", res.Message)
+	}
+	commitment := registry.StateCommitment{}
+	if err := json.Unmarshal(res.Payload, &commitment); err != nil {
+		t.Fatalf("Can not unmarshal state commitment: %s", err)
+	}
+	if commitment.Root != rootBase64 || commitment.Size != 4 || commitment.Sequence != 2 || commitment.ChaincodeName != "ecc" {
+		t.Fatalf("Unexpected state commitment: %+v", commitment)
+	}
+}
+
+func TestEnclaveRegistry_RegistryStats(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+	stub.Creator = th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setRegistrationQuorum"), []byte("2")})
+
+	// one active enclave registered by Org1 and one pending enclave registered by Org2
+	stub.Creator = th.CreateCreator(t, "Org1MSP", "registrar")
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+	stub.Creator = th.CreateCreator(t, "Org2MSP", "registrar")
+	th.CheckInvoke(t, stub, [][]byte{[]byte("confirmEnclave"), []byte(enclavePkHash)})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(newEnclavePk(t)), []byte(quote), certPem, keyPem})
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("getRegistryStats")})
+	if res.Status != shim.OK {
+		t.Fatalf("getRegistryStats failed: %s", res.Message)
+	}
+	stats := &registry.RegistryStats{}
+	if err := json.Unmarshal(res.Payload, stats); err != nil {
+		t.Fatalf("Can not unmarshal registry stats: %s", err)
+	}
+	if stats.Total != 2 || stats.Active != 1 || stats.Pending != 1 || stats.Expired != 0 || stats.Revoked != 0 {
+		t.Fatalf("Unexpected registry stats: %+v", stats)
+	}
+	if stats.PerOrg["Org1MSP"] != 1 || stats.PerOrg["Org2MSP"] != 1 || stats.Unattributed != 0 {
+		t.Fatalf("Unexpected per-org counts: %v", stats.PerOrg)
+	}
+	if stats.OldestAttestation == 0 || stats.OldestAttestationAge != stats.ComputedAt-stats.OldestAttestation {
+		t.Fatalf("Unexpected oldest attestation: %+v", stats)
+	}
+
+	if res := stub.MockInvoke("2", [][]byte{[]byte("getRegistryStats"), []byte("x")}); res.Status == shim.OK {
+		t.Fatalf("getRegistryStats should not take arguments")
+	}
+}
+
+func TestEnclaveRegistry_FunctionACL(t *testing.T) {
+	ercc := NewTestErcc()
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	peer := th.CreateCreatorWithOUs(t, "Org1MSP", "peer0", "peer")
+	client := th.CreateCreatorWithOUs(t, "Org1MSP", "user1", "client")
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	// only admins may set ACLs unless an ACL says otherwise
+	acl, _ := json.Marshal(&registry.FunctionACL{Principals: []registry.ACLPrincipal{{Role: registry.RolePeer}}})
+	stub.Creator = peer
+	if res := stub.MockInvoke("1", [][]byte{[]byte("setFunctionACL"), []byte("registerEnclave"), acl}); res.Status == shim.OK {
+		t.Fatalf("setFunctionACL should be restricted to admins")
+	}
+	stub.Creator = admin
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setFunctionACL"), []byte("registerEnclave"), acl})
+	th.CheckQueryNotNull(t, stub, [][]byte{[]byte("getFunctionACL"), []byte("registerEnclave")})
+
+	// only peers may register
+	stub.Creator = client
+	if res := stub.MockInvoke("2", [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("registerEnclave should be denied to clients")
+	}
+	stub.Creator = peer
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+
+	// functions without ACL remain open
+	stub.Creator = client
+	th.CheckQueryNotNull(t, stub, [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)})
+
+	// removing the ACL opens the function again
+	stub.Creator = admin
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setFunctionACL"), []byte("registerEnclave"), []byte("")})
+	stub.Creator = client
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(newEnclavePk(t)), []byte(quote), certPem, keyPem})
+
+	// an ACL for setFunctionACL does not replace the admin check
+	stub.Creator = admin
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setFunctionACL"), []byte("setFunctionACL"), acl})
+	stub.Creator = peer
+	if res := stub.MockInvoke("3", [][]byte{[]byte("setFunctionACL"), []byte("registerEnclave"), acl}); res.Status == shim.OK {
+		t.Fatalf("setFunctionACL should remain restricted to admins")
+	}
+}
+
+// mockTlcc satisfies the channel policies listed
+type mockTlcc struct {
+	satisfied map[string]bool
+}
+
+func (m *mockTlcc) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (m *mockTlcc) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if args[0] != "CHECK_POLICY" || !m.satisfied[args[1]] {
+		return shim.Error("policy not satisfied")
+	}
+	return shim.Success(nil)
+}
+
+func TestEnclaveRegistry_ChannelPolicyACL(t *testing.T) {
+	ercc := NewTestErcc()
+	ercc.policies = &tlccPolicies{}
+	stub := shim.NewMockStub("ercc", ercc)
+	tlcc := &mockTlcc{satisfied: map[string]bool{}}
+	stub.MockPeerChaincode(tlccName, shim.NewMockStub(tlccName, tlcc))
+	certPem, keyPem := createIASClientCert(t)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	stub.Creator = admin
+	acl, _ := json.Marshal(&registry.FunctionACL{Principals: []registry.ACLPrincipal{{Policy: "/Channel/Application/Writers"}}})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setFunctionACL"), []byte("registerEnclave"), acl})
+
+	stub.Creator = th.CreateCreator(t, "Org1MSP", "peer0")
+	if res := stub.MockInvoke("1", [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("registerEnclave should be denied if the channel policy is not satisfied")
+	}
+	tlcc.satisfied["/Channel/Application/Writers"] = true
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+}
+
+// mockLedger reports a fixed block height
+type mockLedger struct {
+	height uint64
+	msps   []string
+}
+
+func (l *mockLedger) BlockHeight(stub shim.ChaincodeStubInterface) (uint64, error) {
+	return l.height, nil
+}
+
+func (l *mockLedger) MSPs(stub shim.ChaincodeStubInterface) ([]string, error) {
+	return l.msps, nil
+}
+
+func TestEnclaveRegistry_TrustedRoots(t *testing.T) {
+	ercc := NewTestErcc()
+	ledger := &mockLedger{height: 5}
+	ercc.ledger = ledger
+	stub := shim.NewMockStub("ercc", ercc)
+	certPem, keyPem := createIASClientCert(t)
+	admin := th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	stub.Creator = th.CreateCreator(t, "Org1MSP", "peer0")
+	if res := stub.MockInvoke("1", [][]byte{[]byte("addTrustedRoot"), []byte("intel"), []byte(attestation.IntelPubPEM), []byte("10")}); res.Status == shim.OK {
+		t.Fatalf("addTrustedRoot should be restricted to admins")
+	}
+
+	stub.Creator = admin
+	res := stub.MockInvoke("1", [][]byte{[]byte("addTrustedRoot"), []byte("intel"), []byte(attestation.IntelPubPEM), []byte("10")})
+	if res.Status != shim.OK {
+		t.Fatalf("addTrustedRoot failed: %s", res.Message)
+	}
+	rootID := string(res.Payload)
+	if res := stub.MockInvoke("1", [][]byte{[]byte("addTrustedRoot"), []byte("intel"), []byte(attestation.IntelPubPEM), []byte("20")}); res.Status == shim.OK {
+		t.Fatalf("addTrustedRoot should reject a root that already exists")
+	}
+	if res := stub.MockInvoke("1", [][]byte{[]byte("addTrustedRoot"), []byte("intel"), []byte("not a key"), []byte("10")}); res.Status == shim.OK {
+		t.Fatalf("addTrustedRoot should reject an invalid root")
+	}
+
+	// the root is not effective before block 10
+	stub.Creator = th.CreateCreator(t, "Org1MSP", "peer0")
+	if res := stub.MockInvoke("1", [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("registerEnclave should fail without an effective root")
+	}
+	ledger.height = 10
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerEnclave"), []byte(enclavePK), []byte(quote), certPem, keyPem})
+
+	// once retired, the root no longer verifies evidence
+	stub.Creator = admin
+	if res := stub.MockInvoke("1", [][]byte{[]byte("retireTrustedRoot"), []byte(rootID), []byte("10")}); res.Status == shim.OK {
+		t.Fatalf("retireTrustedRoot should reject retiring a root before it takes effect")
+	}
+	th.CheckInvoke(t, stub, [][]byte{[]byte("retireTrustedRoot"), []byte(rootID), []byte("15")})
+	if res := stub.MockInvoke("1", [][]byte{[]byte("retireTrustedRoot"), []byte(rootID), []byte("20")}); res.Status == shim.OK {
+		t.Fatalf("retireTrustedRoot should reject a root that is already retired")
+	}
+
+	res = stub.MockInvoke("1", [][]byte{[]byte("getTrustedRoots")})
+	if res.Status != shim.OK {
+		t.Fatalf("getTrustedRoots failed: %s", res.Message)
+	}
+	var roots []registry.TrustedRoot
+	if err := json.Unmarshal(res.Payload, &roots); err != nil {
+		t.Fatalf("Can not parse trusted roots: %s", err)
+	}
+	if len(roots) != 1 || roots[0].ID != rootID || roots[0].EffectiveFrom != 10 || roots[0].RetiredFrom != 15 {
+		t.Fatalf("Unexpected trusted roots %v", roots)
+	}
+
+	ledger.height = 15
+	if _, err := ercc.verifyAttestationReport(stub, attestation.IASAttestationReport{}); err == nil {
+		t.Fatalf("Evidence should not be verified with a retired root")
+	}
+}
+
+func TestEnclaveRegistry_Delegation(t *testing.T) {
+	ercc := NewTestErcc()
+	ercc.ledger = &mockLedger{height: 5, msps: []string{"Org1MSP", "Org2MSP"}}
+	stub := shim.NewMockStub("ercc", ercc)
+	stub.ChannelID = "mychannel"
+	admin, adminKey := th.CreateCreatorWithAttrsAndKey(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	admin2, admin2Key := th.CreateCreatorWithAttrsAndKey(t, "Org2MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+
+	delegatorSk, _, err := ecccrypto.GenKeyPair()
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	delegateeSk, _, err := ecccrypto.GenKeyPair()
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	delegatorPk, _ := x509.MarshalPKIXPublicKey(&delegatorSk.PublicKey)
+	delegatorPkHash := registry.EnclavePkHash(delegatorPk)
+	delegateePkHash := base64.StdEncoding.EncodeToString(append(make([]byte, 31), 0x01))
+	mrEnclave := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	delegateeMrEnclave := base64.StdEncoding.EncodeToString(append(make([]byte, 31), 0x01))
+
+	stub.MockTransactionStart("1")
+	stub.PutState(delegatorPkHash, []byte("{}"))
+	stub.PutState(delegateePkHash, []byte("{}"))
+	bindingKey, _ := stub.CreateCompositeKey(registry.BindingObjectType, []string{delegatorPkHash})
+	bindingAsBytes, _ := json.Marshal(&attestation.ReportDataBinding{ChannelID: "mychannel", ChaincodeID: "ecc"})
+	stub.PutState(bindingKey, bindingAsBytes)
+	stub.MockTransactionEnd("1")
+
+	ephemeralPk, ciphertext, err := ecccrypto.EncryptForEnclave([]byte("apiKey"), delegatorPk)
+	if err != nil {
+		t.Fatalf("Can not encrypt secret: %s", err)
+	}
+	provisioner, provisionerKey := th.CreateCreatorWithAttrsAndKey(t, "Org1MSP", "provisioner", map[string]string{provisionerAttribute: "true"})
+	stub.Creator = provisioner
+	th.CheckInvoke(t, stub, [][]byte{[]byte("provisionSecret"), []byte(delegatorPkHash), []byte("apiKey"),
+		[]byte(base64.StdEncoding.EncodeToString(ephemeralPk)), []byte(base64.StdEncoding.EncodeToString(ciphertext)),
+		approve(t, provisioner, provisionerKey, registry.SecretStatement("apiKey", ciphertext))})
+
+	delegationPk, key, err := ecccrypto.NewReEncryptionKey(delegatorSk, ecccrypto.MarshalSgxPk(&delegateeSk.PublicKey))
+	if err != nil {
+		t.Fatalf("Can not create re-encryption key: %s", err)
+	}
+	rkArgs := [][]byte{[]byte("putReEncryptionKey"), []byte("ecc"), []byte(delegatorPkHash), []byte(delegateePkHash), []byte(mrEnclave), []byte(delegateeMrEnclave),
+		[]byte(base64.StdEncoding.EncodeToString(delegationPk)), []byte(base64.StdEncoding.EncodeToString(key))}
+
+	// re-encryption keys require an approved delegation
+	if res := stub.MockInvoke("2", rkArgs); res.Status == shim.OK {
+		t.Fatalf("putReEncryptionKey should fail without delegation approval")
+	}
+	statement := registry.DelegationStatement("mychannel", "ecc", mrEnclave, delegateeMrEnclave)
+	approveArgs := func(creator []byte, key *ecdsa.PrivateKey, statement []byte) [][]byte {
+		return [][]byte{[]byte("approveDelegation"), []byte("ecc"), []byte(mrEnclave), []byte(delegateeMrEnclave), approve(t, creator, key, statement)}
+	}
+	if res := stub.MockInvoke("2", approveArgs(admin, adminKey, statement)); res.Status == shim.OK {
+		t.Fatalf("approveDelegation should be restricted to admins")
+	}
+	stub.Creator = admin
+	// the admin signs the delegation of the chaincode on the channel for the enclave to verify
+	for _, other := range [][]byte{
+		registry.DelegationStatement("mychannel", "ecc", delegateeMrEnclave, mrEnclave),
+		registry.DelegationStatement("otherchannel", "ecc", mrEnclave, delegateeMrEnclave),
+		registry.DelegationStatement("mychannel", "other", mrEnclave, delegateeMrEnclave),
+	} {
+		if res := stub.MockInvoke("2", approveArgs(admin, adminKey, other)); res.Status == shim.OK {
+			t.Fatalf("approveDelegation should fail with an approval of another delegation")
+		}
+	}
+	th.CheckInvoke(t, stub, approveArgs(admin, adminKey, statement))
+
+	// one of two MSPs is no quorum
+	if res := stub.MockInvoke("2", rkArgs); res.Status == shim.OK {
+		t.Fatalf("putReEncryptionKey should fail without a quorum of approvals")
+	}
+	stub.Creator = admin2
+	th.CheckInvoke(t, stub, approveArgs(admin2, admin2Key, statement))
+	th.CheckInvoke(t, stub, rkArgs)
+
+	// the delegator must be registered for the chaincode of the delegation
+	otherArgs := append([][]byte{rkArgs[0], []byte("other")}, rkArgs[2:]...)
+	if res := stub.MockInvoke("2", otherArgs); res.Status == shim.OK {
+		t.Fatalf("putReEncryptionKey should fail for an enclave of another chaincode")
+	}
+
+	res := stub.MockInvoke("3", [][]byte{[]byte("getDelegation"), []byte("ecc"), []byte(mrEnclave), []byte(delegateeMrEnclave)})
+	if res.Status != shim.OK {
+		t.Fatalf("getDelegation failed: %s", res.Message)
+	}
+	delegation := &registry.DelegationApproval{}
+	if err := json.Unmarshal(res.Payload, delegation); err != nil {
+		t.Fatalf("Can not parse delegation: %s", err)
+	}
+	if err := registry.VerifyQuorum(delegation.Approvals, statement, []string{"Org1MSP", "Org2MSP"}); err != nil {
+		t.Fatalf("Stored delegation approvals do not verify: %s", err)
+	}
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("delegateSecret"), []byte(delegatorPkHash), []byte(delegateePkHash), []byte("apiKey")})
+	res = stub.MockInvoke("3", [][]byte{[]byte("getDelegatedSecret"), []byte(delegateePkHash), []byte("apiKey")})
+	if res.Status != shim.OK {
+		t.Fatalf("getDelegatedSecret failed: %s", res.Message)
+	}
+	delegated := &registry.DelegatedSecret{}
+	if err := json.Unmarshal(res.Payload, delegated); err != nil {
+		t.Fatalf("Can not parse delegated secret: %s", err)
+	}
+
+	// only the delegatee can decrypt
+	plaintext, err := ecccrypto.DecryptReEncrypted(delegateeSk, delegated.DelegationPk, delegated.EphemeralPk, delegated.Ciphertext)
+	if err != nil {
+		t.Fatalf("Can not decrypt delegated secret: %s", err)
+	}
+	if string(plaintext) != "apiKey" {
+		t.Fatalf("Expected apiKey but got %s", plaintext)
+	}
+
+	// once revoked, ercc no longer re-encrypts
+	th.CheckInvoke(t, stub, [][]byte{[]byte("revokeDelegation"), []byte("ecc"), []byte(mrEnclave), []byte(delegateeMrEnclave)})
+	if res := stub.MockInvoke("4", [][]byte{[]byte("reEncrypt"), []byte(delegatorPkHash), []byte(delegateePkHash),
+		[]byte(base64.StdEncoding.EncodeToString(ephemeralPk))}); res.Status == shim.OK {
+		t.Fatalf("reEncrypt should fail after the delegation is revoked")
+	}
+}
+
+func TestEnclaveRegistry_Escrow(t *testing.T) {
+	ercc := NewTestErcc()
+	ercc.ledger = &mockLedger{height: 5, msps: []string{"Org1MSP", "Org2MSP", "Org3MSP"}}
+	stub := shim.NewMockStub("ercc", ercc)
+	stub.ChannelID = "mychannel"
+	admin, adminKey := th.CreateCreatorWithAttrsAndKey(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	admin2, admin2Key := th.CreateCreatorWithAttrsAndKey(t, "Org2MSP", "admin", map[string]string{adminAttribute: "true"})
+	admin3 := th.CreateCreatorWithAttrs(t, "Org3MSP", "admin", map[string]string{adminAttribute: "true"})
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+
+	escrowingSk, _, err := ecccrypto.GenKeyPair()
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	escrowingPk, _ := x509.MarshalPKIXPublicKey(&escrowingSk.PublicKey)
+	escrowingPkHash := registry.EnclavePkHash(escrowingPk)
+	escrowingReport, _ := json.Marshal(&attestation.IASAttestationReport{EnclavePk: escrowingPk})
+	recoveringPkHash := base64.StdEncoding.EncodeToString(append(make([]byte, 31), 0x01))
+	stub.MockTransactionStart("1")
+	stub.PutState(escrowingPkHash, escrowingReport)
+	stub.PutState(recoveringPkHash, []byte("{}"))
+	stub.MockTransactionEnd("1")
+
+	policy := &registry.EscrowPolicy{Threshold: 2}
+	for _, mspID := range []string{"Org1MSP", "Org2MSP", "Org3MSP"} {
+		sk, _, err := ecccrypto.GenKeyPair()
+		if err != nil {
+			t.Fatalf("Can not create key: %s", err)
+		}
+		pk, _ := x509.MarshalPKIXPublicKey(&sk.PublicKey)
+		policy.Parties = append(policy.Parties, registry.EscrowParty{MSPID: mspID, Pk: pk})
+	}
+	policyAsBytes, _ := json.Marshal(policy)
+	partyPks, err := policy.PartyPks()
+	if err != nil {
+		t.Fatalf("Can not get party pks: %s", err)
+	}
+	statement := registry.EscrowStatement("mychannel", "ecc", policy.Threshold, policy.PartyMSPIDs(), partyPks)
+	approval := approve(t, admin, adminKey, statement)
+
+	// escrow policies are set by admins, who sign them for the enclaves to verify
+	if res := stub.MockInvoke("2", [][]byte{[]byte("setEscrowPolicy"), []byte("ecc"), policyAsBytes, approval}); res.Status == shim.OK {
+		t.Fatalf("setEscrowPolicy should be restricted to admins")
+	}
+	stub.Creator = admin
+	for _, other := range [][]byte{
+		registry.EscrowStatement("mychannel", "ecc", 3, policy.PartyMSPIDs(), partyPks),
+		registry.EscrowStatement("mychannel", "ecc", policy.Threshold, []string{"Org1MSP", "Org2MSP", "Org4MSP"}, partyPks),
+		registry.EscrowStatement("otherchannel", "ecc", policy.Threshold, policy.PartyMSPIDs(), partyPks),
+		registry.EscrowStatement("mychannel", "other", policy.Threshold, policy.PartyMSPIDs(), partyPks),
+	} {
+		if res := stub.MockInvoke("2", [][]byte{[]byte("setEscrowPolicy"), []byte("ecc"), policyAsBytes, approve(t, admin, adminKey, other)}); res.Status == shim.OK {
+			t.Fatalf("setEscrowPolicy should fail with the approval of another policy")
+		}
+	}
+
+	// no single org may recover the state key, and recovery parties must be orgs of the channel
+	singleOrg := &registry.EscrowPolicy{Threshold: 1, Parties: policy.Parties[:1]}
+	singleOrgAsBytes, _ := json.Marshal(singleOrg)
+	singleOrgPks, _ := singleOrg.PartyPks()
+	if res := stub.MockInvoke("2", [][]byte{[]byte("setEscrowPolicy"), []byte("ecc"), singleOrgAsBytes,
+		approve(t, admin, adminKey, registry.EscrowStatement("mychannel", "ecc", 1, singleOrg.PartyMSPIDs(), singleOrgPks))}); res.Status == shim.OK {
+		t.Fatalf("setEscrowPolicy should fail for a policy a single org can recover under")
+	}
+	outside := &registry.EscrowPolicy{Threshold: 2, Parties: []registry.EscrowParty{policy.Parties[0], {MSPID: "Org4MSP", Pk: policy.Parties[1].Pk}}}
+	outsideAsBytes, _ := json.Marshal(outside)
+	outsideStatement, _ := outside.Statement("mychannel", "ecc")
+	if res := stub.MockInvoke("2", [][]byte{[]byte("setEscrowPolicy"), []byte("ecc"), outsideAsBytes, approve(t, admin, adminKey, outsideStatement)}); res.Status == shim.OK {
+		t.Fatalf("setEscrowPolicy should fail for recovery parties outside the channel")
+	}
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setEscrowPolicy"), []byte("ecc"), policyAsBytes, approval})
+
+	newShare := func(mspID string) registry.EscrowShare {
+		sk, _, err := ecccrypto.GenKeyPair()
+		if err != nil {
+			t.Fatalf("Can not create key: %s", err)
+		}
+		ciphertext := make([]byte, registry.EscrowShareCiphertextSize)
+		rand.Read(ciphertext)
+		return registry.EscrowShare{MSPID: mspID, EphemeralPk: ecccrypto.MarshalSgxPk(&sk.PublicKey), Ciphertext: ciphertext}
+	}
+	// the escrowing enclave signs the check value under the policy
+	sign := func(signer *ecdsa.PrivateKey, check []byte) []byte {
+		digest := sha256.Sum256(registry.EscrowMessage(statement, check))
+		r, s, err := ecdsa.Sign(rand.Reader, signer, digest[:])
+		if err != nil {
+			t.Fatalf("Can not sign escrow: %s", err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+	escrow := &registry.Escrow{Threshold: 2, Check: []byte("check")}
+	for _, party := range policy.Parties {
+		escrow.Shares = append(escrow.Shares, newShare(party.MSPID))
+	}
+	escrow.Signature = sign(adminKey, escrow.Check)
+	escrowAsBytes, _ := json.Marshal(escrow)
+	if res := stub.MockInvoke("3", [][]byte{[]byte("putEscrow"), []byte("ecc"), []byte(escrowingPkHash), escrowAsBytes}); res.Status == shim.OK {
+		t.Fatalf("putEscrow should fail without the signature of the escrowing enclave")
+	}
+	escrow.Signature = sign(escrowingSk, escrow.Check)
+	escrowAsBytes, _ = json.Marshal(escrow)
+
+	// one of three MSPs is no quorum
+	if res := stub.MockInvoke("3", [][]byte{[]byte("putEscrow"), []byte("ecc"), []byte(escrowingPkHash), escrowAsBytes}); res.Status == shim.OK {
+		t.Fatalf("putEscrow should fail without a quorum of approvals of the policy")
+	}
+	stub.Creator = admin2
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setEscrowPolicy"), []byte("ecc"), policyAsBytes, approve(t, admin2, admin2Key, statement)})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("putEscrow"), []byte("ecc"), []byte(escrowingPkHash), escrowAsBytes})
+	stub.Creator = admin
+
+	// escrows must follow the policy and can not replace the escrowed key
+	lowThreshold := *escrow
+	lowThreshold.Threshold = 1
+	lowThresholdAsBytes, _ := json.Marshal(&lowThreshold)
+	if res := stub.MockInvoke("3", [][]byte{[]byte("putEscrow"), []byte("ecc"), []byte(escrowingPkHash), lowThresholdAsBytes}); res.Status == shim.OK {
+		t.Fatalf("putEscrow should fail for an escrow not following the policy")
+	}
+	otherKey := *escrow
+	otherKey.Check = []byte("other")
+	otherKey.Signature = sign(escrowingSk, otherKey.Check)
+	otherKeyAsBytes, _ := json.Marshal(&otherKey)
+	if res := stub.MockInvoke("3", [][]byte{[]byte("putEscrow"), []byte("ecc"), []byte(escrowingPkHash), otherKeyAsBytes}); res.Status == shim.OK {
+		t.Fatalf("putEscrow should fail for another state key")
+	}
+
+	submitArgs := func(share registry.EscrowShare) [][]byte {
+		return [][]byte{[]byte("submitRecoveryShare"), []byte("ecc"), []byte(recoveringPkHash),
+			[]byte(base64.StdEncoding.EncodeToString(share.EphemeralPk)), []byte(base64.StdEncoding.EncodeToString(share.Ciphertext))}
+	}
+	th.CheckInvoke(t, stub, submitArgs(newShare("Org1MSP")))
+	if res := stub.MockInvoke("4", [][]byte{[]byte("getRecovery"), []byte("ecc"), []byte(recoveringPkHash)}); res.Status == shim.OK {
+		t.Fatalf("getRecovery should fail below the threshold")
+	}
+
+	// only recovery parties submit shares
+	stub.Creator = th.CreateCreatorWithAttrs(t, "Org4MSP", "admin", map[string]string{adminAttribute: "true"})
+	if res := stub.MockInvoke("5", submitArgs(newShare("Org4MSP"))); res.Status == shim.OK {
+		t.Fatalf("submitRecoveryShare should be restricted to recovery parties")
+	}
+	stub.Creator = admin2
+	th.CheckInvoke(t, stub, submitArgs(newShare("Org2MSP")))
+	stub.Creator = admin3
+	th.CheckInvoke(t, stub, submitArgs(newShare("Org3MSP")))
+
+	res := stub.MockInvoke("6", [][]byte{[]byte("getRecovery"), []byte("ecc"), []byte(recoveringPkHash)})
+	if res.Status != shim.OK {
+		t.Fatalf("getRecovery failed: %s", res.Message)
+	}
+	recovery := &registry.Recovery{}
+	if err := json.Unmarshal(res.Payload, recovery); err != nil {
+		t.Fatalf("Can not parse recovery: %s", err)
+	}
+	if len(recovery.Shares) != 3 || !bytes.Equal(recovery.Check, escrow.Check) {
+		t.Fatalf("Unexpected recovery: %v", recovery)
+	}
+
+	// the recovering enclave verifies the escrow against the approved policy
+	if recovery.EscrowedBy != escrowingPkHash || recovery.Threshold != policy.Threshold {
+		t.Fatalf("Unexpected recovery: %v", recovery)
+	}
+	recoveryStatement := registry.EscrowStatement("mychannel", "ecc", recovery.Threshold, recovery.PartyMSPIDs, recovery.PartyPks)
+	if err := registry.VerifyQuorum(recovery.Approvals, recoveryStatement, []string{"Org1MSP", "Org2MSP", "Org3MSP"}); err != nil {
+		t.Fatalf("Escrow policy approvals do not verify: %s", err)
+	}
+	if err := registry.VerifyEscrow(escrowingPk, recoveryStatement, recovery.Check, recovery.Signature); err != nil {
+		t.Fatalf("Escrow signature does not verify: %s", err)
+	}
+}
+
+// mockLscc serves chaincode definitions like lscc getccdata
+type mockLscc struct {
+	definitions map[string]*chaincodeData
+}
+
+func (l *mockLscc) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (l *mockLscc) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 3 || args[0] != "getccdata" {
+		return shim.Error("Unexpected lscc invocation")
+	}
+	data, ok := l.definitions[args[2]]
+	if !ok {
+		return shim.Error("could not find chaincode with name '" + args[2] + "'")
+	}
+	dataAsBytes, _ := proto.Marshal(data)
+	return shim.Success(dataAsBytes)
+}
+
+func TestEnclaveRegistry_ChaincodeDefinition(t *testing.T) {
+	ercc := NewTestErcc()
+	ercc.lifecycle = &lsccLifecycle{}
+	stub := shim.NewMockStub("ercc", ercc)
+	lscc := &mockLscc{definitions: map[string]*chaincodeData{"ecc": {Name: "ecc", Version: "1.0"}}}
+	stub.MockPeerChaincode(lsccName, shim.NewMockStub(lsccName, lscc))
+	certPem, keyPem := createIASClientCert(t)
+
+	// Init
+	th.CheckInit(t, stub, [][]byte{})
+	allowDebugEnclaves(t, stub)
+
+	// nonexistent chaincode
+	if res := stub.MockInvoke("1", [][]byte{[]byte("registerBoundEnclave"), []byte(enclavePK), []byte(quote), []byte("other"), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("registerBoundEnclave should fail for undefined chaincode")
+	}
+	// mismatched version
+	if res := stub.MockInvoke("2", [][]byte{[]byte("registerBoundEnclave"), []byte(enclavePK), []byte(quote), []byte("ecc:0.9"), certPem, keyPem}); res.Status == shim.OK {
+		t.Fatalf("registerBoundEnclave should fail for another chaincode version")
+	}
+
+	th.CheckInvoke(t, stub, [][]byte{[]byte("registerBoundEnclave"), []byte(enclavePK), []byte(quote), []byte("ecc:1.0"), certPem, keyPem})
+
+	res := stub.MockInvoke("3", [][]byte{[]byte("getEnclaveByPk"), []byte(enclavePK)})
+	if res.Status != shim.OK {
+		t.Fatalf("getEnclaveByPk failed: %s", res.Message)
+	}
+	record := &registry.EnclaveRecord{}
+	if err := json.Unmarshal(res.Payload, record); err != nil {
+		t.Fatalf("Can not unmarshal enclave record: %s", err)
+	}
+	if record.Binding == nil || record.Binding.ChaincodeID != "ecc" || record.Binding.ChaincodeVersion != "1.0" {
+		t.Fatalf("Unexpected binding: %+v", record.Binding)
+	}
+}
+
+// allowDebugEnclaves sets an attestation policy accepting debug enclaves such as the one of the test quote
+func allowDebugEnclaves(t *testing.T, stub *shim.MockStub) {
+	creator := stub.Creator
+	stub.Creator = th.CreateCreatorWithAttrs(t, "Org1MSP", "admin", map[string]string{adminAttribute: "true"})
+	policy, _ := json.Marshal(&registry.AttestationPolicy{AllowDebugEnclaves: true})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("setAttestationPolicy"), policy})
+	stub.Creator = creator
+}
@@ -0,0 +1,428 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	ecccrypto "github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// setEscrowPolicy -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setEscrowPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeID
+	// 1: policy (json encoded registry.EscrowPolicy)
+	// 2: approval (json encoded registry.Approval of the registry.EscrowStatement, signed by the admin)
+	//
+	// Each admin adds the approval of its MSP; enclaves escrow under the policy once a quorum of channel MSPs approved
+	// it. Setting another policy discards the approvals of the previous one.
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id, escrow policy and approval")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policy := &registry.EscrowPolicy{}
+	if err := json.Unmarshal([]byte(args[1]), policy); err != nil {
+		return shim.Error("Can not parse escrow policy: " + err.Error())
+	}
+	if err := policy.Validate(); err != nil {
+		return shim.Error("Invalid escrow policy: " + err.Error())
+	}
+
+	statement, err := policy.Statement(stub.GetChannelID(), args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	approval, msps, err := ercc.checkChannelApproval(stub, args[2], statement)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// recovery parties take part through admins of their org, which must be an org of the channel
+	members := make(map[string]bool)
+	for _, mspID := range msps {
+		members[mspID] = true
+	}
+	for _, party := range policy.Parties {
+		if !members[party.MSPID] {
+			return shim.Error("Recovery party " + party.MSPID + " is not an application MSP of the channel")
+		}
+	}
+
+	// approvals of the same policy accumulate
+	policy.Approvals = nil
+	key, err := stub.CreateCompositeKey(registry.EscrowPolicyObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if existingAsBytes != nil {
+		existing := &registry.EscrowPolicy{}
+		if err := json.Unmarshal(existingAsBytes, existing); err != nil {
+			return shim.Error(err.Error())
+		}
+		if existingStatement, err := existing.Statement(stub.GetChannelID(), args[0]); err == nil && bytes.Equal(existingStatement, statement) {
+			policy.Approvals = existing.Approvals
+		}
+	}
+	policy.Approvals = registry.AddApproval(policy.Approvals, approval)
+
+	policyAsBytes, err := registry.MarshalCanonical(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, policyAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getEscrowPolicy -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getEscrowPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "chaincodeID"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id")
+	}
+
+	policy, err := getEscrowPolicy(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policyAsBytes, err := registry.MarshalCanonical(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(policyAsBytes)
+}
+
+// ============================================================
+// putEscrow -
+// ============================================================
+func (ercc *EnclaveRegistryCC) putEscrow(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeID
+	// 1: enclavePkHashBase64 (escrowing enclave)
+	// 2: escrow (json encoded registry.Escrow)
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id, enclave pk hash and escrow")
+	}
+	chaincodeID := args[0]
+	enclavePkHashBase64 := args[1]
+
+	policy, err := getEscrowPolicy(stub, chaincodeID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	report, err := getAttestationReport(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	escrow := &registry.Escrow{}
+	if err := json.Unmarshal([]byte(args[2]), escrow); err != nil {
+		return shim.Error("Can not parse escrow: " + err.Error())
+	}
+	if escrow.Threshold != policy.Threshold || len(escrow.Shares) != len(policy.Parties) {
+		return shim.Error("Escrow does not follow the escrow policy")
+	}
+	for i, share := range escrow.Shares {
+		if share.MSPID != policy.Parties[i].MSPID {
+			return shim.Error("Escrow does not follow the escrow policy")
+		}
+		if err := checkEscrowShare(share); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// the enclave signs the check value under the approved policy, which the recovering enclave verifies
+	statement, err := policy.Statement(stub.GetChannelID(), chaincodeID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	msps, err := ercc.channelMSPs(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := registry.VerifyQuorum(policy.Approvals, statement, msps); err != nil {
+		return shim.Error("Escrow policy not approved: " + err.Error())
+	}
+	escrow.PartyMSPIDs = policy.PartyMSPIDs()
+	escrow.PartyPks, err = policy.PartyPks()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	escrow.Approvals = policy.Approvals
+	if err := registry.VerifyEscrow(report.EnclavePk, statement, escrow.Check, escrow.Signature); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the state key of a chaincode never changes; a re-escrow, e.g., after the policy changed, must be of the
+	// same key, so an escrow can not be replaced by one of another key
+	previous, err := getEscrow(stub, chaincodeID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if previous != nil && !consttime.Equal(previous.Check, escrow.Check) {
+		return shim.Error("Escrow is not of the escrowed state key")
+	}
+
+	escrow.EnclavePkHash = enclavePkHashBase64
+	escrow.EscrowedAt, err = getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	escrowAsBytes, err := registry.MarshalCanonical(escrow)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := stub.CreateCompositeKey(registry.EscrowObjectType, []string{chaincodeID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, escrowAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getEscrow -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getEscrow(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "chaincodeID"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id")
+	}
+
+	escrow, err := getEscrow(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if escrow == nil {
+		return shim.Error("No escrow for " + args[0])
+	}
+	escrowAsBytes, err := registry.MarshalCanonical(escrow)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(escrowAsBytes)
+}
+
+// ============================================================
+// submitRecoveryShare -
+// ============================================================
+func (ercc *EnclaveRegistryCC) submitRecoveryShare(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeID
+	// 1: enclavePkHashBase64 (recovering enclave)
+	// 2: ephemeralPkBase64
+	// 3: ciphertextBase64 (share re-encrypted to the recovering enclave, see crypto.ReEncryptEscrowShare)
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id, enclave pk hash, ephemeral pk and ciphertext")
+	}
+	chaincodeID := args[0]
+	enclavePkHashBase64 := args[1]
+
+	// recovery parties take part through admins of their org
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy, err := getEscrowPolicy(stub, chaincodeID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if policy.Party(mspID) == nil {
+		return shim.Error(mspID + " is not a recovery party of " + chaincodeID)
+	}
+	if escrow, err := getEscrow(stub, chaincodeID); err != nil {
+		return shim.Error(err.Error())
+	} else if escrow == nil {
+		return shim.Error("No escrow for " + chaincodeID)
+	}
+	if _, err := getAttestationReport(stub, enclavePkHashBase64); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	share := registry.EscrowShare{MSPID: mspID}
+	share.EphemeralPk, err = base64.StdEncoding.DecodeString(args[2])
+	if err != nil {
+		return shim.Error("Can not parse ephemeralPkBase64: " + err.Error())
+	}
+	share.Ciphertext, err = base64.StdEncoding.DecodeString(args[3])
+	if err != nil {
+		return shim.Error("Can not parse ciphertextBase64: " + err.Error())
+	}
+	if err := checkEscrowShare(share); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	shareAsBytes, err := registry.MarshalCanonical(&share)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := stub.CreateCompositeKey(registry.RecoveryShareObjectType, []string{chaincodeID, enclavePkHashBase64, mspID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, shareAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getRecovery -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getRecovery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0              1
+	// "chaincodeID", "enclavePkHashBase64" (recovering enclave)
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id and enclave pk hash")
+	}
+	chaincodeID := args[0]
+	enclavePkHashBase64 := args[1]
+
+	policy, err := getEscrowPolicy(stub, chaincodeID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	escrow, err := getEscrow(stub, chaincodeID)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if escrow == nil {
+		return shim.Error("No escrow for " + chaincodeID)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(registry.RecoveryShareObjectType, []string{chaincodeID, enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	recovery := &registry.Recovery{
+		EnclavePkHash: enclavePkHashBase64,
+		Check:         escrow.Check,
+		EscrowedBy:    escrow.EnclavePkHash,
+		Threshold:     escrow.Threshold,
+		PartyMSPIDs:   escrow.PartyMSPIDs,
+		PartyPks:      escrow.PartyPks,
+		Approvals:     escrow.Approvals,
+		Signature:     escrow.Signature,
+	}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		share := registry.EscrowShare{}
+		if err := json.Unmarshal(kv.Value, &share); err != nil {
+			return shim.Error(err.Error())
+		}
+		// shares of parties removed from the policy do not count
+		if policy.Party(share.MSPID) != nil {
+			recovery.Shares = append(recovery.Shares, share)
+		}
+	}
+	if len(recovery.Shares) < escrow.Threshold {
+		return shim.Error("Recovery needs " + strconv.Itoa(escrow.Threshold) + " shares, got " + strconv.Itoa(len(recovery.Shares)))
+	}
+
+	recoveryAsBytes, err := registry.MarshalCanonical(recovery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(recoveryAsBytes)
+}
+
+// getEscrowPolicy returns the escrow policy of a chaincode
+func getEscrowPolicy(stub shim.ChaincodeStubInterface, chaincodeID string) (*registry.EscrowPolicy, error) {
+	key, err := stub.CreateCompositeKey(registry.EscrowPolicyObjectType, []string{chaincodeID})
+	if err != nil {
+		return nil, err
+	}
+	policyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get escrow policy for " + chaincodeID)
+	} else if policyAsBytes == nil {
+		return nil, errors.New("No escrow policy for " + chaincodeID)
+	}
+
+	policy := &registry.EscrowPolicy{}
+	if err := json.Unmarshal(policyAsBytes, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// getEscrow returns the escrow of the state key of a chaincode or nil if there is none
+func getEscrow(stub shim.ChaincodeStubInterface, chaincodeID string) (*registry.Escrow, error) {
+	key, err := stub.CreateCompositeKey(registry.EscrowObjectType, []string{chaincodeID})
+	if err != nil {
+		return nil, err
+	}
+	escrowAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get escrow for " + chaincodeID)
+	} else if escrowAsBytes == nil {
+		return nil, nil
+	}
+
+	escrow := &registry.Escrow{}
+	if err := json.Unmarshal(escrowAsBytes, escrow); err != nil {
+		return nil, err
+	}
+	return escrow, nil
+}
+
+// checkEscrowShare returns an error if the ephemeral pk or the ciphertext of a share are malformed
+func checkEscrowShare(share registry.EscrowShare) error {
+	if _, err := ecccrypto.EnclavePk2ECDSAPK(share.EphemeralPk); err != nil {
+		return errors.New("Invalid ephemeral pk of " + share.MSPID + ": " + err.Error())
+	}
+	if len(share.Ciphertext) != registry.EscrowShareCiphertextSize {
+		return errors.New("Invalid share ciphertext of " + share.MSPID)
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// setForeignChannels -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setForeignChannels(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: max age (seconds) of imports, 0 for unlimited
+	// 1..n: channel ids enclaves may be imported from
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting max age and channel ids")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	maxAge, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || maxAge < 0 {
+		return shim.Error("Invalid max age: " + args[0])
+	}
+	channels := &registry.ForeignChannels{ChannelIDs: args[1:], MaxAgeSeconds: maxAge}
+	if channels.Allows(stub.GetChannelID()) {
+		return shim.Error("Can not import enclaves of this channel")
+	}
+
+	channelsAsBytes, err := registry.MarshalCanonical(channels)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.ForeignChannelsObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, channelsAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// enclaves imported from channels no longer listed fail verification but are kept
+	return shim.Success(channelsAsBytes)
+}
+
+// ============================================================
+// getForeignChannels -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getForeignChannels(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	channels, err := getForeignChannels(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	channelsAsBytes, err := registry.MarshalCanonical(channels)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(channelsAsBytes)
+}
+
+// ============================================================
+// importForeignEnclave -
+// ============================================================
+func (ercc *EnclaveRegistryCC) importForeignEnclave(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: channel id the enclave is registered on
+	// 1: enclave record (json encoded registry.EnclaveRecord) as returned by getEnclaveByPk on that channel
+	// submitted by relayers; the record is verified with its attestation evidence, not trusted
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting channel id and enclave record")
+	}
+	channelID := args[0]
+
+	channels, err := getForeignChannels(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !channels.Allows(channelID) {
+		return shim.Error("Enclaves of channel " + channelID + " can not be imported")
+	}
+
+	foreign := &registry.ForeignEnclave{ChannelID: channelID}
+	if err := json.Unmarshal([]byte(args[1]), &foreign.Record); err != nil {
+		return shim.Error("Can not parse enclave record: " + err.Error())
+	}
+	record := &foreign.Record
+	if err := registry.CheckForeignRecord(channelID, record); err != nil {
+		return shim.Error("Invalid enclave record: " + err.Error())
+	}
+
+	// the same checks as for registrations on this channel, except for those of the registration transaction
+	if _, err := ercc.verifyAttestationReport(stub, record.AttestationReport); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := attestation.ParseReportBody(record.AttestationReport.IASReportBody); err != nil {
+		return shim.Error(err.Error())
+	}
+	isValid, err := ercc.ra.CheckReportData(record.AttestationReport.EnclavePk, record.Binding, record.AttestationReport)
+	if err != nil {
+		return shim.Error("Error while checking enclave PK: " + err.Error())
+	}
+	if !isValid {
+		return shim.Error("Enclave PK does not match attestation report!")
+	}
+
+	existing, err := getForeignEnclave(stub, channelID, record.EnclavePkHash)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil && existing.IsRevoked() && !foreign.IsRevoked() {
+		// relayers must not resurrect enclaves with older snapshots
+		return shim.Error("Enclave has been revoked on channel " + channelID)
+	}
+	if !foreign.IsRevoked() {
+		// revocations are imported regardless of the policies of this channel
+		if err := checkAttestationPolicy(stub, record.AttestationReport); err != nil {
+			return shim.Error("Attestation policy violated: " + err.Error())
+		}
+		if err := checkPseManifest(stub, record.AttestationReport); err != nil {
+			return shim.Error("PSE manifest not accepted: " + err.Error())
+		}
+		if _, err := checkAlgorithms(stub, record.AttestationReport.EnclavePk); err != nil {
+			return shim.Error("Enclave key not accepted: " + err.Error())
+		}
+	}
+
+	foreign.ImportedBy, err = cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Can not get client msp id: " + err.Error())
+	}
+	foreign.ImportedAt, err = getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	foreignAsBytes, err := registry.MarshalCanonical(foreign)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := stub.CreateCompositeKey(registry.ForeignEnclaveObjectType, []string{channelID, record.EnclavePkHash})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, foreignAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(foreignAsBytes)
+}
+
+// ============================================================
+// getForeignEnclave -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getForeignEnclave(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: channel id
+	// 1: enclave pk hash (base64)
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting channel id and enclave pk hash")
+	}
+
+	foreign, err := getForeignEnclave(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if foreign == nil {
+		return shim.Error("No enclave imported from channel " + args[0])
+	}
+
+	foreignAsBytes, err := registry.MarshalCanonical(foreign)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(foreignAsBytes)
+}
+
+// ============================================================
+// verifyForeignEnclave -
+// ============================================================
+func (ercc *EnclaveRegistryCC) verifyForeignEnclave(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: channel id the enclave is registered on
+	// 1: enclave pk (base64)
+	// called by chaincodes of this channel that accept results of enclaves of another channel
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting channel id and enclave pk")
+	}
+	channelID := args[0]
+
+	enclavePk, err := base64.StdEncoding.DecodeString(args[1])
+	if err != nil {
+		return shim.Error("Can not parse enclavePkBase64: " + err.Error())
+	}
+
+	foreign, err := checkForeignEnclave(stub, channelID, registry.EnclavePkHash(enclavePk))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	foreignAsBytes, err := registry.MarshalCanonical(foreign)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(foreignAsBytes)
+}
+
+// ============================================================
+// removeForeignEnclave -
+// ============================================================
+func (ercc *EnclaveRegistryCC) removeForeignEnclave(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: channel id
+	// 1: enclave pk hash (base64)
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting channel id and enclave pk hash")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.ForeignEnclaveObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.DelState(key); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkForeignEnclave returns an enclave imported from channelID if it may be trusted at the time of the transaction
+func checkForeignEnclave(stub shim.ChaincodeStubInterface, channelID, enclavePkHashBase64 string) (*registry.ForeignEnclave, error) {
+	channels, err := getForeignChannels(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !channels.Allows(channelID) {
+		return nil, errors.New("Enclaves of channel " + channelID + " are not trusted")
+	}
+
+	foreign, err := getForeignEnclave(stub, channelID, enclavePkHashBase64)
+	if err != nil {
+		return nil, err
+	} else if foreign == nil {
+		return nil, errors.New("No enclave imported from channel " + channelID)
+	}
+	if foreign.IsRevoked() {
+		return nil, errors.New("Enclave has been revoked on channel " + channelID)
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !channels.IsFresh(foreign.ImportedAt, txTime) {
+		return nil, errors.New("Import of enclave from channel " + channelID + " is outdated")
+	}
+	// the attestation policy may have changed, or the registration expired, since the import
+	if err := checkAttestationPolicy(stub, foreign.Record.AttestationReport); err != nil {
+		return nil, errors.New("Attestation policy violated: " + err.Error())
+	}
+	return foreign, nil
+}
+
+// getForeignChannels returns the channels enclaves may be imported from; none if not set
+func getForeignChannels(stub shim.ChaincodeStubInterface) (*registry.ForeignChannels, error) {
+	key, err := stub.CreateCompositeKey(registry.ForeignChannelsObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+
+	channelsAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get foreign channels")
+	}
+
+	channels := &registry.ForeignChannels{}
+	if channelsAsBytes == nil {
+		return channels, nil
+	}
+	if err := json.Unmarshal(channelsAsBytes, channels); err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// getForeignEnclave returns an enclave imported from channelID or nil if there is none
+func getForeignEnclave(stub shim.ChaincodeStubInterface, channelID, enclavePkHashBase64 string) (*registry.ForeignEnclave, error) {
+	key, err := stub.CreateCompositeKey(registry.ForeignEnclaveObjectType, []string{channelID, enclavePkHashBase64})
+	if err != nil {
+		return nil, err
+	}
+
+	foreignAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if foreignAsBytes == nil {
+		return nil, nil
+	}
+
+	foreign := &registry.ForeignEnclave{}
+	if err := json.Unmarshal(foreignAsBytes, foreign); err != nil {
+		return nil, err
+	}
+	return foreign, nil
+}
@@ -0,0 +1,315 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// rotateEnclaveKey -
+// ============================================================
+func (ercc *EnclaveRegistryCC) rotateEnclaveKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclaveID
+	// 1: newEnclavePkHashBase64 (registered before via registerEnclave)
+	// 2: authorization kind, "signature" or "approval"
+	// 3: signatureBase64 of the active key of the identity over the new key (see registry.RotationMessage), or
+	//    the approval (JSON) of an ercc admin (see registry.RotationStatement)
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave id, new enclave pk hash, authorization kind and authorization")
+	}
+	enclaveID := args[0]
+	newPkHashBase64 := args[1]
+
+	identity, err := getEnclaveIdentity(stub, enclaveID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if identity.ActivePkHash == newPkHashBase64 {
+		return shim.Error("Key is already active")
+	}
+
+	report, err := getAttestationReport(stub, newPkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// registration of the new key created an identity on its own; it must not be used already
+	newIdentityID, err := getEnclaveIDByPkHash(stub, newPkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newIdentity, err := getEnclaveIdentity(stub, newIdentityID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(newIdentity.Keys) != 1 {
+		return shim.Error("Key is bound to another enclave identity: " + newIdentityID)
+	}
+
+	// the new key must come from the same enclave code on the same platform. Unlinkable quotes only identify the
+	// EPID group of the platform, which many platforms share, so they can not tell the platforms apart.
+	mrEnclave, platformID, err := identityAttributes(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !consttime.EqualString(mrEnclave, identity.MrEnclave) || platformID != identity.PlatformID {
+		return shim.Error("New key does not belong to the same enclave code and platform")
+	}
+	linkable, err := hasEpidPseudonym(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if !linkable {
+		return shim.Error("Platform of the new key is only identified by its EPID group id")
+	}
+
+	if err := ercc.checkRotation(stub, identity, newPkHashBase64, report, args[2], args[3]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// retire the old key; the new key takes over
+	retiredKey, err := stub.CreateCompositeKey(registry.RetiredObjectType, []string{identity.ActivePkHash})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(retiredKey, []byte(newPkHashBase64)); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	identity.ActivePkHash = newPkHashBase64
+	identity.Keys = append(identity.Keys, registry.IdentityKey{PkHash: newPkHashBase64, ActivatedAt: txTime})
+	if err := putEnclaveIdentity(stub, identity); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// drop the identity created by the registration of the new key
+	newIdentityKey, err := stub.CreateCompositeKey(registry.IdentityObjectType, []string{newIdentityID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.DelState(newIdentityKey); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := putEnclaveIDByPkHash(stub, newPkHashBase64, enclaveID); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := moveChaincodeEnclave(stub, newIdentityID, enclaveID, newPkHashBase64); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	identityAsBytes, err := registry.MarshalCanonical(identity)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(identityAsBytes)
+}
+
+// checkRotation checks that either the active key of the identity signed the new key, of the given report, or an ercc
+// admin approved the rotation
+func (ercc *EnclaveRegistryCC) checkRotation(stub shim.ChaincodeStubInterface, identity *registry.EnclaveIdentity, newPkHashBase64 string, report attestation.IASAttestationReport, kind, authorization string) error {
+	switch kind {
+	case "signature":
+		activeReport, err := getAttestationReport(stub, identity.ActivePkHash)
+		if err != nil {
+			return err
+		}
+		signature, err := base64.StdEncoding.DecodeString(authorization)
+		if err != nil {
+			return errors.New("Can not decode rotation signature: " + err.Error())
+		}
+		if err := registry.VerifyRotation(activeReport.EnclavePk, report.EnclavePk, signature); err != nil {
+			return errors.New("Rotation is not signed by the active key: " + err.Error())
+		}
+		return nil
+	case "approval":
+		if err := checkAdmin(stub); err != nil {
+			return err
+		}
+		_, err := checkApproval(stub, authorization, registry.RotationStatement(stub.GetChannelID(), identity.EnclaveID, newPkHashBase64))
+		return err
+	default:
+		return errors.New("Unknown rotation authorization: " + kind)
+	}
+}
+
+// ============================================================
+// getEnclaveIdentity -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getEnclaveIdentity(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "enclaveID"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave id")
+	}
+
+	identity, err := getEnclaveIdentity(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	identityAsBytes, err := registry.MarshalCanonical(identity)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(identityAsBytes)
+}
+
+// ============================================================
+// getEnclaveID -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getEnclaveID(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "enclavePkHashBase64"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash")
+	}
+
+	enclaveID, err := getEnclaveIDByPkHash(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(enclaveID))
+}
+
+// createEnclaveIdentity creates the stable identity for a newly registered enclave key.
+// The pk hash serves as instance nonce as every enclave instance generates a fresh key.
+func createEnclaveIdentity(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, report attestation.IASAttestationReport) (string, error) {
+	mrEnclave, platformID, err := identityAttributes(report)
+	if err != nil {
+		return "", err
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return "", err
+	}
+
+	identity := &registry.EnclaveIdentity{
+		EnclaveID:    registry.NewEnclaveID(mrEnclave, platformID, enclavePkHashBase64),
+		MrEnclave:    mrEnclave,
+		PlatformID:   platformID,
+		ActivePkHash: enclavePkHashBase64,
+		Keys:         []registry.IdentityKey{{PkHash: enclavePkHashBase64, ActivatedAt: txTime}},
+	}
+
+	if err := putEnclaveIdentity(stub, identity); err != nil {
+		return "", err
+	}
+	if err := putEnclaveIDByPkHash(stub, enclavePkHashBase64, identity.EnclaveID); err != nil {
+		return "", err
+	}
+	return identity.EnclaveID, nil
+}
+
+// identityAttributes returns mrenclave and platform id (base64) of the enclave that produced the report
+func identityAttributes(report attestation.IASAttestationReport) (string, string, error) {
+	quote, err := attestation.QuoteFromAttestionReport(report)
+	if err != nil {
+		return "", "", errors.New("Can not parse quote: " + err.Error())
+	}
+
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return "", "", errors.New("Can not parse report body: " + err.Error())
+	}
+
+	return registry.MrEnclave(quote), registry.PlatformID(quote, reportBody), nil
+}
+
+// hasEpidPseudonym returns whether IAS identified the platform that produced the report by its EPID pseudonym, i.e.,
+// whether the platform id of the enclave is specific to the platform, see registry.PlatformID
+func hasEpidPseudonym(report attestation.IASAttestationReport) (bool, error) {
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return false, errors.New("Can not parse report body: " + err.Error())
+	}
+	return reportBody.EpidPseudonym != "", nil
+}
+
+func getEnclaveIdentity(stub shim.ChaincodeStubInterface, enclaveID string) (*registry.EnclaveIdentity, error) {
+	key, err := stub.CreateCompositeKey(registry.IdentityObjectType, []string{enclaveID})
+	if err != nil {
+		return nil, err
+	}
+
+	identityAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get enclave identity " + enclaveID)
+	} else if identityAsBytes == nil {
+		return nil, errors.New("Enclave identity does not exist: " + enclaveID)
+	}
+
+	identity := &registry.EnclaveIdentity{}
+	if err := json.Unmarshal(identityAsBytes, identity); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func putEnclaveIdentity(stub shim.ChaincodeStubInterface, identity *registry.EnclaveIdentity) error {
+	key, err := stub.CreateCompositeKey(registry.IdentityObjectType, []string{identity.EnclaveID})
+	if err != nil {
+		return err
+	}
+
+	identityAsBytes, err := registry.MarshalCanonical(identity)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, identityAsBytes)
+}
+
+func getEnclaveIDByPkHash(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) (string, error) {
+	key, err := stub.CreateCompositeKey(registry.IdentityByPkObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return "", err
+	}
+
+	enclaveID, err := stub.GetState(key)
+	if err != nil {
+		return "", errors.New("Failed to get enclave id for " + enclavePkHashBase64)
+	} else if enclaveID == nil {
+		return "", errors.New("No enclave identity for " + enclavePkHashBase64)
+	}
+	return string(enclaveID), nil
+}
+
+func putEnclaveIDByPkHash(stub shim.ChaincodeStubInterface, enclavePkHashBase64, enclaveID string) error {
+	key, err := stub.CreateCompositeKey(registry.IdentityByPkObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte(enclaveID))
+}
@@ -0,0 +1,88 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// lsccName is the lifecycle system chaincode that holds the chaincode definitions of a channel
+const lsccName = "lscc"
+
+// ChaincodeDefinition is the definition of a chaincode instantiated on a channel
+type ChaincodeDefinition struct {
+	Name    string
+	Version string
+}
+
+// ChaincodeLifecycle looks up the chaincode definitions of the channel of a transaction
+type ChaincodeLifecycle interface {
+	// GetChaincodeDefinition returns an error if the chaincode is not defined on the channel
+	GetChaincodeDefinition(stub shim.ChaincodeStubInterface, chaincodeID string) (*ChaincodeDefinition, error)
+}
+
+// lsccLifecycle queries lscc
+type lsccLifecycle struct{}
+
+// chaincodeData holds the fields of ccprovider.ChaincodeData, as returned by lscc, that ercc needs; other fields
+// are skipped when unmarshalling
+type chaincodeData struct {
+	Name    string `protobuf:"bytes,1,opt,name=name"`
+	Version string `protobuf:"bytes,2,opt,name=version"`
+}
+
+func (cd *chaincodeData) Reset()         { *cd = chaincodeData{} }
+func (cd *chaincodeData) String() string { return proto.CompactTextString(cd) }
+func (*chaincodeData) ProtoMessage()     {}
+
+// GetChaincodeDefinition returns the definition of the chaincode instantiated on the channel of the transaction
+func (l *lsccLifecycle) GetChaincodeDefinition(stub shim.ChaincodeStubInterface, chaincodeID string) (*ChaincodeDefinition, error) {
+	channelID := stub.GetChannelID()
+	resp := stub.InvokeChaincode(lsccName, [][]byte{[]byte("getccdata"), []byte(channelID), []byte(chaincodeID)}, channelID)
+	if resp.Status != shim.OK {
+		return nil, fmt.Errorf("Chaincode %s is not defined on channel %s: %s", chaincodeID, channelID, resp.Message)
+	}
+	data := &chaincodeData{}
+	if err := proto.Unmarshal(resp.Payload, data); err != nil {
+		return nil, fmt.Errorf("Can not parse definition of chaincode %s: %s", chaincodeID, err)
+	}
+	return &ChaincodeDefinition{Name: data.Name, Version: data.Version}, nil
+}
+
+// checkChaincodeDefinition verifies that the chaincode a quote is bound to is defined on the channel and, if the
+// quote binds the version, that the version is the one defined
+func (ercc *EnclaveRegistryCC) checkChaincodeDefinition(stub shim.ChaincodeStubInterface, binding *attestation.ReportDataBinding) error {
+	if ercc.lifecycle == nil || binding == nil || binding.ChaincodeID == "" {
+		return nil
+	}
+	definition, err := ercc.lifecycle.GetChaincodeDefinition(stub, binding.ChaincodeID)
+	if err != nil {
+		return err
+	}
+	if definition.Name != binding.ChaincodeID {
+		return fmt.Errorf("Chaincode definition of %s names %s", binding.ChaincodeID, definition.Name)
+	}
+	if binding.ChaincodeVersion != "" && binding.ChaincodeVersion != definition.Version {
+		return fmt.Errorf("Enclave is bound to version %s of chaincode %s but version %s is defined", binding.ChaincodeVersion, binding.ChaincodeID, definition.Version)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// maxPageSize limits the number of enclaves returned by a single listEnclaves query
+const maxPageSize = 100
+
+// ============================================================
+// listEnclaves -
+// ============================================================
+func (ercc *EnclaveRegistryCC) listEnclaves(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0           1
+	// "pageSize", "bookmark"
+	// query only; fabric does not allow paginated queries in update transactions
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting page size and optional bookmark")
+	}
+
+	pageSize, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil || pageSize <= 0 || pageSize > maxPageSize {
+		return shim.Error("Invalid page size: " + args[0])
+	}
+
+	var bookmark string
+	if len(args) == 2 {
+		bookmark = args[1]
+	}
+
+	// registrations are stored under simple keys
+	resultsIterator, metadata, err := stub.GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page := &registry.EnclavePage{Enclaves: []registry.EnclaveRecord{}}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if registry.IsCompositeKey(kv.Key) {
+			continue
+		}
+
+		reportAsBytes, err := registry.DecompressEvidence(kv.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		record := registry.EnclaveRecord{EnclavePkHash: kv.Key}
+		if err := json.Unmarshal(reportAsBytes, &record.AttestationReport); err != nil {
+			return shim.Error(err.Error())
+		}
+		page.Enclaves = append(page.Enclaves, record)
+	}
+
+	page.Bookmark = metadata.Bookmark
+	page.FetchedRecordsCount = metadata.FetchedRecordsCount
+
+	pageAsBytes, err := registry.MarshalCanonical(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pageAsBytes)
+}
+
+// ============================================================
+// getEnclaveByPk -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getEnclaveByPk(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "enclavePkBase64"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk")
+	}
+
+	enclavePk, err := base64.StdEncoding.DecodeString(args[0])
+	if err != nil {
+		return shim.Error("Can not parse enclavePkBase64: " + err.Error())
+	}
+
+	// registrations are keyed by the pk hash, thus, the lookup needs a single read per record
+	enclavePkHashBase64 := registry.EnclavePkHash(enclavePk)
+	report, err := getAttestationReport(stub, enclavePkHashBase64)
+	if err != nil {
+		if tombstone, _ := getTombstone(stub, enclavePkHashBase64); tombstone != nil {
+			return shim.Error("Enclave registration has been pruned: " + tombstone.Reason)
+		}
+		return shim.Error(err.Error())
+	}
+
+	record := &registry.EnclaveRecord{EnclavePkHash: enclavePkHashBase64, AttestationReport: report}
+
+	statusKey, err := stub.CreateCompositeKey(registry.StatusObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	statusAsBytes, err := stub.GetState(statusKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if statusAsBytes != nil {
+		record.Status = &registry.EnclaveStatus{}
+		if err := json.Unmarshal(statusAsBytes, record.Status); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	retiredKey, err := stub.CreateCompositeKey(registry.RetiredObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	successor, err := stub.GetState(retiredKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	record.SuccessorPkHash = string(successor)
+
+	// registrations before enclave identities were introduced have none
+	enclaveIDKey, err := stub.CreateCompositeKey(registry.IdentityByPkObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	enclaveID, err := stub.GetState(enclaveIDKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	record.EnclaveID = string(enclaveID)
+
+	record.RegistrarSignature, err = getRegistrarSignature(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	bindingKey, err := stub.CreateCompositeKey(registry.BindingObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	bindingAsBytes, err := stub.GetState(bindingKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if bindingAsBytes != nil {
+		record.Binding = &attestation.ReportDataBinding{}
+		if err := json.Unmarshal(bindingAsBytes, record.Binding); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	record.ExpiresAt, err = registrationExpiresAt(stub, report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	record.Algorithms, err = getAlgorithms(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	recordAsBytes, err := registry.MarshalCanonical(record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(recordAsBytes)
+}
@@ -0,0 +1,85 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// getEnclavesByPlatform -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getEnclavesByPlatform(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "epidPseudonym"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting EPID pseudonym")
+	}
+
+	platform := &registry.PlatformEnclaves{EpidPseudonym: args[0], EnclavePkHashes: []string{}}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(registry.PlatformObjectType, []string{platform.EpidPseudonym})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		platform.EnclavePkHashes = append(platform.EnclavePkHashes, attributes[1])
+	}
+
+	platformAsBytes, err := registry.MarshalCanonical(platform)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(platformAsBytes)
+}
+
+// indexPlatform records the registration under the EPID pseudonym of the report. Unlinkable quotes do not
+// carry a pseudonym and are not indexed.
+func indexPlatform(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, report attestation.IASAttestationReport) error {
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return errors.New("Can not parse report body: " + err.Error())
+	}
+	if reportBody.EpidPseudonym == "" {
+		return nil
+	}
+
+	key, err := stub.CreateCompositeKey(registry.PlatformObjectType, []string{reportBody.EpidPseudonym, enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte{0x00})
+}
@@ -0,0 +1,186 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// setAttestationPolicy -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setAttestationPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: policy (json encoded registry.AttestationPolicy; Version is ignored)
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting attestation policy")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policy := &registry.AttestationPolicy{}
+	if err := json.Unmarshal([]byte(args[0]), policy); err != nil {
+		return shim.Error("Can not parse attestation policy: " + err.Error())
+	}
+	if err := policy.Validate(); err != nil {
+		return shim.Error("Invalid attestation policy: " + err.Error())
+	}
+
+	oldPolicy, err := getAttestationPolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy.Version = 1
+	if oldPolicy != nil {
+		policy.Version = oldPolicy.Version + 1
+	}
+
+	policyAsBytes, err := registry.MarshalCanonical(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.AttestationPolicyObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, policyAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the policy applies to all subsequent registrations; the event lets auditors track every change
+	if err := setAttestationPolicyChangedEvent(stub, oldPolicy, policy); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(policyAsBytes)
+}
+
+// setAttestationPolicyChangedEvent records the digests of the old and new policy
+func setAttestationPolicyChangedEvent(stub shim.ChaincodeStubInterface, oldPolicy, policy *registry.AttestationPolicy) error {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return err
+	}
+	change := &registry.AttestationPolicyChange{NewVersion: policy.Version, MspID: mspID}
+	if change.NewDigest, err = policy.Digest(); err != nil {
+		return err
+	}
+	if oldPolicy != nil {
+		change.OldVersion = oldPolicy.Version
+		if change.OldDigest, err = oldPolicy.Digest(); err != nil {
+			return err
+		}
+	}
+
+	changeAsBytes, err := registry.MarshalCanonical(change)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(registry.AttestationPolicyChangedEventName, changeAsBytes)
+}
+
+// ============================================================
+// getAttestationPolicy -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getAttestationPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	policy, err := getAttestationPolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if policy == nil {
+		return shim.Error("No attestation policy set")
+	}
+
+	policyAsBytes, err := registry.MarshalCanonical(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(policyAsBytes)
+}
+
+// checkAttestationPolicy verifies the attestation report of a registration against the attestation policy.
+// Without policy all reports but those of debug enclaves are accepted.
+func checkAttestationPolicy(stub shim.ChaincodeStubInterface, report attestation.IASAttestationReport) error {
+	policy, err := getAttestationPolicy(stub)
+	if err != nil {
+		return err
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	quote, err := registry.CheckReport(policy, report, txTime)
+	if err != nil {
+		return err
+	}
+	if policy != nil && quote.IsDebug() {
+		logger.Warningf("Registering DEBUG enclave with mrenclave %s as attestation policy version %d allows debug enclaves; its memory is not protected",
+			registry.MrEnclave(quote), policy.Version)
+	}
+	return nil
+}
+
+// registrationExpiresAt returns the time (unix seconds) at which the registration with the given report expires
+// under the attestation policy, or 0 if it does not expire
+func registrationExpiresAt(stub shim.ChaincodeStubInterface, report attestation.IASAttestationReport) (int64, error) {
+	policy, err := getAttestationPolicy(stub)
+	if err != nil {
+		return 0, err
+	} else if policy == nil {
+		return 0, nil
+	}
+
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return 0, errors.New("Can not parse report body: " + err.Error())
+	}
+	return policy.ExpiresAt(reportBody)
+}
+
+// getAttestationPolicy returns the attestation policy or nil if there is none
+func getAttestationPolicy(stub shim.ChaincodeStubInterface) (*registry.AttestationPolicy, error) {
+	key, err := stub.CreateCompositeKey(registry.AttestationPolicyObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+
+	policyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get attestation policy")
+	} else if policyAsBytes == nil {
+		return nil, nil
+	}
+
+	policy := &registry.AttestationPolicy{}
+	if err := json.Unmarshal(policyAsBytes, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
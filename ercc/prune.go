@@ -0,0 +1,352 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// defaultPruneLimit bounds the number of registrations one pruneRegistry transaction removes, keeping its
+// write set small
+const defaultPruneLimit = 100
+
+// records keyed by the enclave pk hash only that are removed along with a pruned registration
+var prunedObjectTypes = []string{
+	registry.QuoteObjectType,
+	registry.StatusObjectType,
+	registry.RetiredObjectType,
+	registry.IdentityByPkObjectType,
+	registry.PendingObjectType,
+	registry.RegistrarSignatureObjectType,
+	registry.BindingObjectType,
+	registry.CollateralObjectType,
+	registry.AlgorithmsObjectType,
+	registry.RegistrantObjectType,
+	registry.HandoverObjectType,
+}
+
+// ============================================================
+// pruneRegistry -
+// ============================================================
+func (ercc *EnclaveRegistryCC) pruneRegistry(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: retention window in seconds
+	// 1: maximum number of registrations to prune (optional)
+	// meant to be triggered periodically by an admin, e.g., by a cron job invoking ercc
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting retention window and optional limit")
+	}
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	retention, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || retention < 0 {
+		return shim.Error("Invalid retention window: " + args[0])
+	}
+	limit := defaultPruneLimit
+	if len(args) == 2 {
+		if limit, err = strconv.Atoi(args[1]); err != nil || limit <= 0 {
+			return shim.Error("Invalid limit: " + args[1])
+		}
+	}
+
+	now, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// registrations are stored under simple keys
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	tombstones := []registry.Tombstone{}
+	for resultsIterator.HasNext() && len(tombstones) < limit {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if registry.IsCompositeKey(kv.Key) {
+			continue
+		}
+
+		reason, err := pruneReason(stub, kv.Key, now-retention)
+		if err != nil {
+			return shim.Error("Can not check " + kv.Key + ": " + err.Error())
+		}
+		if reason != "" {
+			tombstones = append(tombstones, registry.Tombstone{EnclavePkHash: kv.Key, Reason: reason, PrunedAt: now, TxID: stub.GetTxID()})
+		}
+	}
+
+	for i := range tombstones {
+		if err := pruneEnclave(stub, &tombstones[i]); err != nil {
+			return shim.Error("Can not prune " + tombstones[i].EnclavePkHash + ": " + err.Error())
+		}
+	}
+	// expired replay cache entries count against the limit, too
+	if _, err := pruneReplayCache(stub, now, limit-len(tombstones)); err != nil {
+		return shim.Error("Can not prune replay cache: " + err.Error())
+	}
+
+	tombstonesAsBytes, err := registry.MarshalCanonical(tombstones)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(tombstones) > 0 {
+		if err := stub.SetEvent(registry.RegistryPrunedEventName, tombstonesAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(tombstonesAsBytes)
+}
+
+// ============================================================
+// getTombstone -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getTombstone(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "enclavePkHashBase64"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash")
+	}
+
+	tombstone, err := getTombstone(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if tombstone == nil {
+		return shim.Error("Enclave has not been pruned: " + args[0])
+	}
+
+	tombstoneAsBytes, err := registry.MarshalCanonical(tombstone)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(tombstoneAsBytes)
+}
+
+// pruneReason returns why a registration may be pruned, or an empty string if it must be kept. Registrations are
+// pruned if they expired or got revoked before cutoff.
+func pruneReason(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, cutoff int64) (string, error) {
+	statusKey, err := stub.CreateCompositeKey(registry.StatusObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return "", err
+	}
+	statusAsBytes, err := stub.GetState(statusKey)
+	if err != nil {
+		return "", err
+	} else if statusAsBytes != nil {
+		status := &registry.EnclaveStatus{}
+		if err := json.Unmarshal(statusAsBytes, status); err != nil {
+			return "", err
+		}
+		// status records written before RevokedAt was introduced carry the time of their last change only
+		revokedAt := status.RevokedAt
+		if revokedAt == 0 {
+			revokedAt = status.CheckedAt
+		}
+		if status.IsRevoked() && revokedAt <= cutoff {
+			return registry.PruneReasonRevoked, nil
+		}
+	}
+
+	report, err := getAttestationReport(stub, enclavePkHashBase64)
+	if err != nil {
+		return "", err
+	}
+	expiresAt, err := registrationExpiresAt(stub, report)
+	if err != nil {
+		return "", err
+	}
+	if expiresAt != 0 && expiresAt <= cutoff {
+		return registry.PruneReasonExpired, nil
+	}
+	return "", nil
+}
+
+// pruneEnclave removes a registration and all records referring to it, and leaves the tombstone in its place
+func pruneEnclave(stub shim.ChaincodeStubInterface, tombstone *registry.Tombstone) error {
+	enclavePkHashBase64 := tombstone.EnclavePkHash
+	report, err := getAttestationReport(stub, enclavePkHashBase64)
+	if err != nil {
+		return err
+	}
+
+	// registrations before enclave identities were introduced have none
+	if enclaveID, err := getEnclaveIDByPkHash(stub, enclavePkHashBase64); err == nil {
+		tombstone.EnclaveID = enclaveID
+		if err := pruneEnclaveIdentity(stub, enclaveID, enclavePkHashBase64); err != nil {
+			return err
+		}
+	}
+
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return errors.New("Can not parse report body: " + err.Error())
+	}
+	if reportBody.EpidPseudonym != "" {
+		key, err := stub.CreateCompositeKey(registry.PlatformObjectType, []string{reportBody.EpidPseudonym, enclavePkHashBase64})
+		if err != nil {
+			return err
+		}
+		if err := stub.DelState(key); err != nil {
+			return err
+		}
+	}
+
+	if err := deleteByPartialKey(stub, registry.SecretObjectType, enclavePkHashBase64); err != nil {
+		return err
+	}
+	if err := deleteByPartialKey(stub, registry.ReEncryptionKeyObjectType, enclavePkHashBase64); err != nil {
+		return err
+	}
+	if err := deleteByPartialKey(stub, registry.DelegatedSecretObjectType, enclavePkHashBase64); err != nil {
+		return err
+	}
+	for _, objectType := range prunedObjectTypes {
+		key, err := stub.CreateCompositeKey(objectType, []string{enclavePkHashBase64})
+		if err != nil {
+			return err
+		}
+		if err := stub.DelState(key); err != nil {
+			return err
+		}
+	}
+	if err := stub.DelState(enclavePkHashBase64); err != nil {
+		return err
+	}
+
+	tombstoneKey, err := stub.CreateCompositeKey(registry.TombstoneObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	tombstoneAsBytes, err := registry.MarshalCanonical(tombstone)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(tombstoneKey, tombstoneAsBytes)
+}
+
+// pruneEnclaveIdentity removes the chaincode registration of the pruned key and the enclave identity if the key
+// is its active key. Identities whose active key was rotated stay.
+func pruneEnclaveIdentity(stub shim.ChaincodeStubInterface, enclaveID, enclavePkHashBase64 string) error {
+	chaincodeID := ""
+	bindingKey, err := stub.CreateCompositeKey(registry.BindingObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	bindingAsBytes, err := stub.GetState(bindingKey)
+	if err != nil {
+		return err
+	} else if bindingAsBytes != nil {
+		binding := &attestation.ReportDataBinding{}
+		if err := json.Unmarshal(bindingAsBytes, binding); err != nil {
+			return err
+		}
+		chaincodeID = binding.ChaincodeID
+	}
+
+	chaincodeEnclaveKey, err := stub.CreateCompositeKey(registry.ChaincodeEnclaveObjectType, []string{chaincodeID, enclaveID})
+	if err != nil {
+		return err
+	}
+	entryAsBytes, err := stub.GetState(chaincodeEnclaveKey)
+	if err != nil {
+		return err
+	} else if entryAsBytes != nil {
+		entry := &registry.ChaincodeEnclave{}
+		if err := json.Unmarshal(entryAsBytes, entry); err != nil {
+			return err
+		}
+		if entry.EnclavePkHash == enclavePkHashBase64 {
+			if err := stub.DelState(chaincodeEnclaveKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	identity, err := getEnclaveIdentity(stub, enclaveID)
+	if err != nil {
+		return err
+	}
+	if identity.ActivePkHash != enclavePkHashBase64 {
+		return nil
+	}
+	identityKey, err := stub.CreateCompositeKey(registry.IdentityObjectType, []string{enclaveID})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(identityKey)
+}
+
+// deleteByPartialKey deletes all records of objectType whose composite key starts with attribute
+func deleteByPartialKey(stub shim.ChaincodeStubInterface, objectType, attribute string) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{attribute})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	var keys []string
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, kv.Key)
+	}
+	for _, key := range keys {
+		if err := stub.DelState(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getTombstone returns the tombstone of a pruned registration or nil if the registration was not pruned
+func getTombstone(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) (*registry.Tombstone, error) {
+	key, err := stub.CreateCompositeKey(registry.TombstoneObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return nil, err
+	}
+
+	tombstoneAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if tombstoneAsBytes == nil {
+		return nil, nil
+	}
+
+	tombstone := &registry.Tombstone{}
+	if err := json.Unmarshal(tombstoneAsBytes, tombstone); err != nil {
+		return nil, err
+	}
+	return tombstone, nil
+}
@@ -0,0 +1,94 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// setPsePolicy -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setPsePolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: required (true|false)
+	// 1: allowOutOfDate (true|false)
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting required and allowOutOfDate")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	required, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return shim.Error("Can not parse required: " + err.Error())
+	}
+	allowOutOfDate, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return shim.Error("Can not parse allowOutOfDate: " + err.Error())
+	}
+
+	policyAsBytes, err := registry.MarshalCanonical(&registry.PsePolicy{Required: required, AllowOutOfDate: allowOutOfDate})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.PsePolicyObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, policyAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkPseManifest verifies the PSE manifest status of an attestation report against the PSE policy
+func checkPseManifest(stub shim.ChaincodeStubInterface, report attestation.IASAttestationReport) error {
+	policy := &registry.PsePolicy{}
+
+	key, err := stub.CreateCompositeKey(registry.PsePolicyObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	policyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	} else if policyAsBytes != nil {
+		if err := json.Unmarshal(policyAsBytes, policy); err != nil {
+			return err
+		}
+	}
+
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return errors.New("Can not parse report body: " + err.Error())
+	}
+
+	return policy.CheckPseManifest(reportBody)
+}
@@ -0,0 +1,206 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// adminAttribute is the certificate attribute (e.g., issued by the fabric ca) that marks ercc admins.
+// Admins manage quotas and are not subject to them.
+const adminAttribute = "ercc.admin"
+
+// ============================================================
+// setRegistrationQuota -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setRegistrationQuota(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: maxEnclaves per org (0 = unlimited)
+	// 1: maxPerWindow per org (0 = unlimited)
+	// 2: window (seconds)
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting max enclaves, max per window and window")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var limits [3]int64
+	for i, arg := range args {
+		limit, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || limit < 0 {
+			return shim.Error("Invalid quota value: " + arg)
+		}
+		limits[i] = limit
+	}
+
+	quotaAsBytes, err := registry.MarshalCanonical(&registry.RegistrationQuota{
+		MaxEnclaves:   int(limits[0]),
+		MaxPerWindow:  int(limits[1]),
+		WindowSeconds: limits[2],
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.QuotaObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, quotaAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getRegistrationUsage -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getRegistrationUsage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "mspID"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting msp id")
+	}
+
+	usage, err := getRegistrationUsage(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	usageAsBytes, err := registry.MarshalCanonical(usage)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(usageAsBytes)
+}
+
+// ============================================================
+// resetRegistrationUsage -
+// ============================================================
+func (ercc *EnclaveRegistryCC) resetRegistrationUsage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "mspID"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting msp id")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.UsageObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.DelState(key); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// chargeRegistration counts a registration against the quota of the submitting org.
+// Admins are not subject to the quota.
+func chargeRegistration(stub shim.ChaincodeStubInterface) error {
+	key, err := stub.CreateCompositeKey(registry.QuotaObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	quotaAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	} else if quotaAsBytes == nil {
+		return nil
+	}
+
+	quota := &registry.RegistrationQuota{}
+	if err := json.Unmarshal(quotaAsBytes, quota); err != nil {
+		return err
+	}
+
+	if checkAdmin(stub) == nil {
+		return nil
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return errors.New("Can not get client msp id: " + err.Error())
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	usage, err := getRegistrationUsage(stub, mspID)
+	if err != nil {
+		return err
+	}
+	if err := usage.Register(quota, txTime); err != nil {
+		return err
+	}
+
+	usageAsBytes, err := registry.MarshalCanonical(usage)
+	if err != nil {
+		return err
+	}
+	usageKey, err := stub.CreateCompositeKey(registry.UsageObjectType, []string{mspID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(usageKey, usageAsBytes)
+}
+
+func getRegistrationUsage(stub shim.ChaincodeStubInterface, mspID string) (*registry.RegistrationUsage, error) {
+	key, err := stub.CreateCompositeKey(registry.UsageObjectType, []string{mspID})
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &registry.RegistrationUsage{MspID: mspID}
+	usageAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if usageAsBytes == nil {
+		return usage, nil
+	}
+
+	if err := json.Unmarshal(usageAsBytes, usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// checkAdmin returns an error if the submitting client is not an ercc admin
+func checkAdmin(stub shim.ChaincodeStubInterface) error {
+	if err := cid.AssertAttributeValue(stub, adminAttribute, "true"); err != nil {
+		return errors.New("Client is not an ercc admin: " + err.Error())
+	}
+	return nil
+}
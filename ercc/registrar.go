@@ -0,0 +1,147 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// signRegistration -
+// ============================================================
+func (ercc *EnclaveRegistryCC) signRegistration(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkHashBase64
+	// 1: signatureBase64 (ECDSA over registry.ReportDigest of the stored report, signed with the key of the submitting identity)
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash and signature")
+	}
+	enclavePkHashBase64 := args[0]
+
+	signature, err := base64.StdEncoding.DecodeString(args[1])
+	if err != nil {
+		return shim.Error("Can not parse signatureBase64: " + err.Error())
+	}
+
+	existing, err := getRegistrarSignature(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if existing != nil {
+		return shim.Error("Registration already signed by " + existing.MspID)
+	}
+
+	report, err := getAttestationReport(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	digest, err := registry.ReportDigest(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	identity, err := cid.New(stub)
+	if err != nil {
+		return shim.Error("Can not get client identity: " + err.Error())
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return shim.Error("Can not get client msp id: " + err.Error())
+	}
+	cert, err := identity.GetX509Certificate()
+	if err != nil {
+		return shim.Error("Can not get client certificate: " + err.Error())
+	}
+
+	registrarSignature := &registry.RegistrarSignature{
+		MspID:       mspID,
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		Digest:      digest,
+		Signature:   signature,
+	}
+	if err := registrarSignature.Verify(report); err != nil {
+		return shim.Error("Invalid registrar signature: " + err.Error())
+	}
+
+	signatureAsBytes, err := registry.MarshalCanonical(registrarSignature)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.RegistrarSignatureObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, signatureAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getRegistrarSignature -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getRegistrarSignature(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "enclavePkHashBase64"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash")
+	}
+
+	registrarSignature, err := getRegistrarSignature(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if registrarSignature == nil {
+		return shim.Error("Registration not signed: " + args[0])
+	}
+
+	signatureAsBytes, err := registry.MarshalCanonical(registrarSignature)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(signatureAsBytes)
+}
+
+func getRegistrarSignature(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) (*registry.RegistrarSignature, error) {
+	key, err := stub.CreateCompositeKey(registry.RegistrarSignatureObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return nil, err
+	}
+
+	signatureAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get registrar signature for " + enclavePkHashBase64)
+	} else if signatureAsBytes == nil {
+		return nil, nil
+	}
+
+	registrarSignature := &registry.RegistrarSignature{}
+	if err := json.Unmarshal(signatureAsBytes, registrarSignature); err != nil {
+		return nil, err
+	}
+	return registrarSignature, nil
+}
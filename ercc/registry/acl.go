@@ -0,0 +1,129 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Roles of an invoking identity. Peer, client, orderer and admin are the NodeOU roles of fabric MSPs, i.e., the
+// identity's certificate carries the role as organizational unit. Admin also matches ercc admins, i.e., identities
+// with the ercc.admin attribute.
+const (
+	RoleMember  = "member"
+	RoleAdmin   = "admin"
+	RolePeer    = "peer"
+	RoleClient  = "client"
+	RoleOrderer = "orderer"
+)
+
+var roles = map[string]bool{RoleMember: true, RoleAdmin: true, RolePeer: true, RoleClient: true, RoleOrderer: true}
+
+// FunctionACL restricts who may invoke an ercc function. An identity is allowed if it matches any of the principals.
+type FunctionACL struct {
+	Principals []ACLPrincipal `json:"Principals"`
+}
+
+// ACLPrincipal matches identities by MSP, role and organizational unit, and transactions by a policy of the channel
+// configuration. Empty fields match any identity.
+type ACLPrincipal struct {
+	MspID string `json:"MspID,omitempty"`
+	// Role is one of RoleMember, RoleAdmin, RolePeer, RoleClient and RoleOrderer
+	Role string `json:"Role,omitempty"`
+	OU   string `json:"OU,omitempty"`
+	// Policy is the absolute name of a channel policy the transaction must satisfy, e.g., /Channel/Application/Writers
+	Policy string `json:"Policy,omitempty"`
+}
+
+// PolicyChecker evaluates the invoking transaction against policies of the channel configuration
+type PolicyChecker interface {
+	SatisfiesPolicy(policy string) bool
+}
+
+// ACLIdentity is what an ACL is evaluated against
+type ACLIdentity struct {
+	MspID string
+	OUs   []string
+	// IsAdmin is set for ercc admins
+	IsAdmin bool
+	// Policies evaluates channel policies; principals with a policy never match if it is nil
+	Policies PolicyChecker
+}
+
+// Validate returns an error if the ACL is malformed. An ACL without principals is rejected rather than locking out
+// everybody; remove the ACL instead.
+func (acl *FunctionACL) Validate() error {
+	if len(acl.Principals) == 0 {
+		return fmt.Errorf("no principals")
+	}
+	for _, principal := range acl.Principals {
+		if principal.Role != "" && !roles[principal.Role] {
+			return fmt.Errorf("unknown role %s", principal.Role)
+		}
+		if principal.Policy != "" && !strings.HasPrefix(principal.Policy, "/Channel/") {
+			return fmt.Errorf("policy %s is not an absolute channel policy name", principal.Policy)
+		}
+	}
+	return nil
+}
+
+// Allows returns true if the identity matches a principal of the ACL
+func (acl *FunctionACL) Allows(identity ACLIdentity) bool {
+	for _, principal := range acl.Principals {
+		if principal.Matches(identity) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if the identity matches all fields set in the principal. The channel policy, which is the
+// most expensive to evaluate, is checked last.
+func (p *ACLPrincipal) Matches(identity ACLIdentity) bool {
+	if p.MspID != "" && p.MspID != identity.MspID {
+		return false
+	}
+	if p.OU != "" && !hasOU(identity, p.OU) {
+		return false
+	}
+	switch p.Role {
+	case "", RoleMember:
+	case RoleAdmin:
+		if !identity.IsAdmin && !hasOU(identity, RoleAdmin) {
+			return false
+		}
+	default:
+		if !hasOU(identity, p.Role) {
+			return false
+		}
+	}
+	if p.Policy != "" {
+		return identity.Policies != nil && identity.Policies.SatisfiesPolicy(p.Policy)
+	}
+	return true
+}
+
+// hasOU compares case-insensitively as NodeOU identifiers are configured per MSP
+func hasOU(identity ACLIdentity, ou string) bool {
+	for _, o := range identity.OUs {
+		if strings.EqualFold(o, ou) {
+			return true
+		}
+	}
+	return false
+}
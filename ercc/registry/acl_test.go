@@ -0,0 +1,95 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"testing"
+)
+
+func TestFunctionACL_Allows(t *testing.T) {
+	acl := &FunctionACL{Principals: []ACLPrincipal{
+		{Role: RoleAdmin},
+		{MspID: "Org1MSP", Role: RolePeer},
+	}}
+	if err := acl.Validate(); err != nil {
+		t.Fatalf("Valid ACL rejected: %s", err)
+	}
+
+	cases := []struct {
+		identity ACLIdentity
+		allowed  bool
+	}{
+		{ACLIdentity{MspID: "Org2MSP", IsAdmin: true}, true},
+		{ACLIdentity{MspID: "Org2MSP", OUs: []string{"Admin"}}, true},
+		{ACLIdentity{MspID: "Org1MSP", OUs: []string{"peer"}}, true},
+		{ACLIdentity{MspID: "Org2MSP", OUs: []string{"peer"}}, false},
+		{ACLIdentity{MspID: "Org1MSP", OUs: []string{"client"}}, false},
+		{ACLIdentity{MspID: "Org1MSP"}, false},
+	}
+	for _, c := range cases {
+		if acl.Allows(c.identity) != c.allowed {
+			t.Errorf("Allows(%+v) should be %v", c.identity, c.allowed)
+		}
+	}
+
+	anyone := &FunctionACL{Principals: []ACLPrincipal{{Role: RoleMember}}}
+	if !anyone.Allows(ACLIdentity{MspID: "Org3MSP"}) {
+		t.Errorf("Member role should allow any identity")
+	}
+}
+
+func TestFunctionACL_Validate(t *testing.T) {
+	if err := (&FunctionACL{}).Validate(); err == nil {
+		t.Errorf("ACL without principals should be rejected")
+	}
+	if err := (&FunctionACL{Principals: []ACLPrincipal{{Role: "auditor"}}}).Validate(); err == nil {
+		t.Errorf("Unknown role should be rejected")
+	}
+}
+
+// satisfiedPolicies satisfies the listed policies only
+type satisfiedPolicies []string
+
+func (s satisfiedPolicies) SatisfiesPolicy(policy string) bool {
+	for _, p := range s {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFunctionACL_Policy(t *testing.T) {
+	acl := &FunctionACL{Principals: []ACLPrincipal{{Policy: "/Channel/Application/Writers"}}}
+	if err := acl.Validate(); err != nil {
+		t.Fatalf("Valid ACL rejected: %s", err)
+	}
+
+	if !acl.Allows(ACLIdentity{MspID: "Org1MSP", Policies: satisfiedPolicies{"/Channel/Application/Writers"}}) {
+		t.Errorf("Satisfied policy should allow")
+	}
+	if acl.Allows(ACLIdentity{MspID: "Org1MSP", Policies: satisfiedPolicies{"/Channel/Application/Readers"}}) {
+		t.Errorf("Unsatisfied policy should deny")
+	}
+	if acl.Allows(ACLIdentity{MspID: "Org1MSP"}) {
+		t.Errorf("Policy should deny without policy checker")
+	}
+
+	if err := (&FunctionACL{Principals: []ACLPrincipal{{Policy: "Writers"}}}).Validate(); err == nil {
+		t.Errorf("Relative policy name should be rejected")
+	}
+}
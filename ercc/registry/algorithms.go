@@ -0,0 +1,75 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"fmt"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+)
+
+// AlgorithmPolicy lists the algorithms of enclave keys a channel accepts, in order of preference. Without a
+// policy, all supported algorithms are accepted in the order of algorithm.Supported.
+type AlgorithmPolicy struct {
+	Accepted []algorithm.Algorithms `json:"Accepted"`
+}
+
+// DefaultAlgorithmPolicy returns the policy of channels that did not set one
+func DefaultAlgorithmPolicy() *AlgorithmPolicy {
+	return &AlgorithmPolicy{Accepted: append([]algorithm.Algorithms{}, algorithm.Supported...)}
+}
+
+// Validate returns an error if the policy accepts nothing, unsupported or duplicate algorithms
+func (p *AlgorithmPolicy) Validate() error {
+	if len(p.Accepted) == 0 {
+		return fmt.Errorf("no algorithms accepted")
+	}
+	seen := make(map[algorithm.Algorithms]bool)
+	for _, a := range p.Accepted {
+		if err := a.Check(); err != nil {
+			return err
+		}
+		if seen[a] {
+			return fmt.Errorf("duplicate algorithms %s", a)
+		}
+		seen[a] = true
+	}
+	return nil
+}
+
+// Accepts returns an error if the policy does not accept the algorithms a
+func (p *AlgorithmPolicy) Accepts(a algorithm.Algorithms) error {
+	for _, accepted := range p.Accepted {
+		if a == accepted {
+			return nil
+		}
+	}
+	return fmt.Errorf("algorithms %s not accepted", a)
+}
+
+// Negotiate returns the most preferred accepted algorithms among those offered, e.g., by the platform of an enclave
+// before it creates its key
+func (p *AlgorithmPolicy) Negotiate(offered []algorithm.Algorithms) (algorithm.Algorithms, error) {
+	for _, accepted := range p.Accepted {
+		for _, a := range offered {
+			if a == accepted {
+				return a, nil
+			}
+		}
+	}
+	return algorithm.Algorithms{}, fmt.Errorf("none of the offered algorithms %v is accepted", offered)
+}
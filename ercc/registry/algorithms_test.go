@@ -0,0 +1,60 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+)
+
+func TestAlgorithmPolicy(t *testing.T) {
+	policy := &AlgorithmPolicy{Accepted: []algorithm.Algorithms{algorithm.Default}}
+
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Policy should be valid: %s", err)
+	}
+	p384 := algorithm.Algorithms{Signature: "ECDSA-P384", Encryption: "ECDH-P384"}
+	for _, invalid := range []*AlgorithmPolicy{
+		{},
+		{Accepted: []algorithm.Algorithms{algorithm.Default, algorithm.Default}},
+		// the enclave creates P-256 keys only
+		{Accepted: []algorithm.Algorithms{p384}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("Policy %v should be invalid", invalid.Accepted)
+		}
+	}
+
+	if err := policy.Accepts(algorithm.Default); err != nil {
+		t.Fatalf("%s should be accepted: %s", algorithm.Default, err)
+	}
+	if err := policy.Accepts(p384); err == nil {
+		t.Fatalf("%s should not be accepted", p384)
+	}
+
+	a, err := policy.Negotiate([]algorithm.Algorithms{p384, algorithm.Default})
+	if err != nil || a != algorithm.Default {
+		t.Fatalf("Expected %s but got %s (%v)", algorithm.Default, a, err)
+	}
+	if _, err := policy.Negotiate([]algorithm.Algorithms{p384}); err == nil {
+		t.Fatalf("Negotiation should fail without common algorithms")
+	}
+	if accepted := DefaultAlgorithmPolicy().Accepted; len(accepted) != 1 || accepted[0] != algorithm.Default {
+		t.Fatalf("Default policy should accept %s only: %v", algorithm.Default, accepted)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Approval is a statement signed with the certificate key of a channel member, e.g., the approval of an upgrade
+// by an ercc admin. ercc checks that the creator of the transaction signed the statement. Enclaves do not trust
+// the peer to relay what ercc approved; they verify the approval themselves against the MSP roots they obtain
+// from tlcc and the attributes of the certificate.
+type Approval struct {
+	MspID string `json:"MspID"`
+	// Certificate (PEM) of the signer
+	Certificate []byte `json:"Certificate"`
+	// Signature is r || s (32 bytes each, big endian) over the sha256 digest of the statement, the format
+	// enclaves verify
+	Signature []byte `json:"Signature"`
+}
+
+// statement prefixes; the prefix separates the kinds of statements so that a signature can not be reused for
+// another kind
+const (
+	secretStatement     = "fpc.secret"
+	upgradeStatement    = "fpc.upgrade"
+	delegationStatement = "fpc.delegation"
+	escrowStatement     = "fpc.escrow"
+	escrowCheck         = "fpc.escrow.check"
+	rotationStatement   = "fpc.rotation"
+)
+
+// SecretStatement is the statement a provisioner signs when provisioning a secret to an enclave. The ciphertext is
+// authenticated with the key shared with the enclave, thus, binding its digest authenticates the secret, also
+// once its ephemeral public key is re-encrypted for a delegatee.
+func SecretStatement(secretName string, ciphertext []byte) []byte {
+	digest := sha256.Sum256(ciphertext)
+	return statement(secretStatement, secretName, base64.StdEncoding.EncodeToString(digest[:]))
+}
+
+// UpgradeStatement is the statement ercc admins sign when approving the enclave binary successor to take over the
+// state key of enclaves of chaincode chaincodeID on channel channelID running mrEnclave (both base64). The enclaves
+// on either side of a handover verify it, see VerifyQuorum.
+func UpgradeStatement(channelID, chaincodeID, mrEnclave, successor string) []byte {
+	return statement(upgradeStatement, channelID, chaincodeID, mrEnclave, successor)
+}
+
+// DelegationStatement is the statement ercc admins sign when approving enclaves of chaincode chaincodeID on channel
+// channelID running mrEnclave to delegate to enclaves running delegatee (both base64). The delegator enclave verifies
+// it before creating a re-encryption key, see VerifyQuorum.
+func DelegationStatement(channelID, chaincodeID, mrEnclave, delegatee string) []byte {
+	return statement(delegationStatement, channelID, chaincodeID, mrEnclave, delegatee)
+}
+
+// RotationStatement is the statement an ercc admin signs when approving the rotation of the identity enclaveID on
+// channel channelID to the enclave key with hash newPkHash (base64), in lieu of a signature of the active key of the
+// identity, see RotationMessage
+func RotationStatement(channelID, enclaveID, newPkHash string) []byte {
+	return statement(rotationStatement, channelID, enclaveID, newPkHash)
+}
+
+// statement joins the fields of a statement, each terminated by a newline
+func statement(kind string, fields ...string) []byte {
+	s := kind + "\n"
+	for _, field := range fields {
+		s += field + "\n"
+	}
+	return []byte(s)
+}
+
+// Verify checks that the signature covers the statement and was created with the P-256 key of the certificate.
+// Note that the certificate itself must be validated against the MSP of the signer.
+func (a *Approval) Verify(statement []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(a.Certificate)
+	if block == nil {
+		return nil, errors.New("approval certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, errors.New("approval key is not a P-256 key")
+	}
+
+	if !verifyRawSignature(pub, statement, a.Signature) {
+		return nil, errors.New("invalid approval signature")
+	}
+	return cert, nil
+}
+
+// ApprovalQuorum returns how many distinct MSPs of a channel with channelMSPs application MSPs must approve a
+// governance statement, e.g., an upgrade: a majority, so that no single org of a channel of several decides alone
+func ApprovalQuorum(channelMSPs int) int {
+	return channelMSPs/2 + 1
+}
+
+// VerifyQuorum checks that approvals of distinct MSPs of the channel, at least ApprovalQuorum of them, signed the
+// statement. Note that the certificates must be validated against the MSPs of the signers; ercc does so when it
+// accepts an approval from its signer, enclaves against the MSP roots from tlcc.
+func VerifyQuorum(approvals []*Approval, statement []byte, channelMSPs []string) error {
+	members := make(map[string]bool)
+	for _, mspID := range channelMSPs {
+		members[mspID] = true
+	}
+
+	approved := make(map[string]bool)
+	for _, approval := range approvals {
+		if !members[approval.MspID] {
+			return fmt.Errorf("%s is not an MSP of the channel", approval.MspID)
+		}
+		if approved[approval.MspID] {
+			return fmt.Errorf("%s approved more than once", approval.MspID)
+		}
+		if _, err := approval.Verify(statement); err != nil {
+			return fmt.Errorf("approval of %s: %s", approval.MspID, err)
+		}
+		approved[approval.MspID] = true
+	}
+
+	if quorum := ApprovalQuorum(len(members)); len(approved) < quorum {
+		return fmt.Errorf("approved by %d of %d required MSPs", len(approved), quorum)
+	}
+	return nil
+}
+
+// AddApproval returns approvals with approval added, replacing an earlier approval of the same MSP
+func AddApproval(approvals []*Approval, approval *Approval) []*Approval {
+	added := []*Approval{}
+	for _, a := range approvals {
+		if a.MspID != approval.MspID {
+			added = append(added, a)
+		}
+	}
+	return append(added, approval)
+}
+
+// verifyRawSignature checks a signature r || s (32 bytes each, big endian) over the sha256 digest of message, the
+// format enclaves sign and verify
+func verifyRawSignature(pub *ecdsa.PublicKey, message, signature []byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	digest := sha256.Sum256(message)
+	return r.Sign() == 1 && s.Sign() == 1 && ecdsa.Verify(pub, digest[:], r, s)
+}
@@ -0,0 +1,145 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestApproval_Verify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "provisioner"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Can not create certificate: %s", err)
+	}
+
+	statement := SecretStatement("apiKey", []byte("ciphertext"))
+	digest := sha256.Sum256(statement)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Can not sign statement: %s", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	approval := &Approval{
+		MspID:       "Org1MSP",
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Signature:   signature,
+	}
+	cert, err := approval.Verify(statement)
+	if err != nil {
+		t.Fatalf("Approval should be valid: %s", err)
+	}
+	if cert.Subject.CommonName != "provisioner" {
+		t.Fatalf("Unexpected signer %s", cert.Subject.CommonName)
+	}
+
+	// the signature covers the secret it approves
+	if _, err := approval.Verify(SecretStatement("otherKey", []byte("ciphertext"))); err == nil {
+		t.Fatalf("Approval of another secret should be invalid")
+	}
+	if _, err := approval.Verify(SecretStatement("apiKey", []byte("otherCiphertext"))); err == nil {
+		t.Fatalf("Approval of another ciphertext should be invalid")
+	}
+	approval.Signature = signature[:63]
+	if _, err := approval.Verify(statement); err == nil {
+		t.Fatalf("Truncated signature should be invalid")
+	}
+}
+
+// signApproval returns an approval of mspID over statement by a fresh self-signed certificate
+func signApproval(t *testing.T, mspID string, statement []byte) *Approval {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "admin"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Can not create certificate: %s", err)
+	}
+	digest := sha256.Sum256(statement)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Can not sign statement: %s", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return &Approval{
+		MspID:       mspID,
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Signature:   signature,
+	}
+}
+
+func TestVerifyQuorum(t *testing.T) {
+	msps := []string{"Org1MSP", "Org2MSP", "Org3MSP"}
+	statement := UpgradeStatement("mychannel", "ecc", "mr", "successor")
+	org1 := signApproval(t, "Org1MSP", statement)
+	org2 := signApproval(t, "Org2MSP", statement)
+
+	if err := VerifyQuorum([]*Approval{org1, org2}, statement, msps); err != nil {
+		t.Fatalf("Two of three MSPs should be a quorum: %s", err)
+	}
+	if err := VerifyQuorum([]*Approval{org1}, statement, msps); err == nil {
+		t.Fatalf("One of three MSPs should not be a quorum")
+	}
+	if err := VerifyQuorum([]*Approval{org1, signApproval(t, "Org1MSP", statement)}, statement, msps); err == nil {
+		t.Fatalf("Approvals of the same MSP should count once")
+	}
+	if err := VerifyQuorum([]*Approval{org1, signApproval(t, "Org4MSP", statement)}, statement, msps); err == nil {
+		t.Fatalf("Approvals of MSPs outside the channel should be rejected")
+	}
+
+	// the statement binds channel and chaincode
+	if err := VerifyQuorum([]*Approval{org1, org2}, UpgradeStatement("otherchannel", "ecc", "mr", "successor"), msps); err == nil {
+		t.Fatalf("Approvals for another channel should be invalid")
+	}
+	if err := VerifyQuorum([]*Approval{org1, org2}, UpgradeStatement("mychannel", "other", "mr", "successor"), msps); err == nil {
+		t.Fatalf("Approvals for another chaincode should be invalid")
+	}
+
+	if approvals := AddApproval([]*Approval{org1, org2}, signApproval(t, "Org1MSP", statement)); len(approvals) != 2 {
+		t.Fatalf("Approval of Org1MSP should replace the earlier one, got %d approvals", len(approvals))
+	}
+}
@@ -0,0 +1,46 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"testing"
+)
+
+func TestMarshalCanonical(t *testing.T) {
+	type a struct {
+		Name  string `json:"Name"`
+		Count int64  `json:"Count"`
+	}
+	type b struct {
+		Count int64  `json:"Count"`
+		Name  string `json:"Name"`
+	}
+
+	aAsBytes, err := MarshalCanonical(&a{Name: "<ecc>", Count: 9007199254740993})
+	if err != nil {
+		t.Fatalf("MarshalCanonical failed: %s", err)
+	}
+	bAsBytes, err := MarshalCanonical(&b{Name: "<ecc>", Count: 9007199254740993})
+	if err != nil {
+		t.Fatalf("MarshalCanonical failed: %s", err)
+	}
+
+	expected := `{"Count":9007199254740993,"Name":"<ecc>"}`
+	if string(aAsBytes) != expected || string(bAsBytes) != expected {
+		t.Fatalf("Expected %s but got %s and %s", expected, aAsBytes, bAsBytes)
+	}
+}
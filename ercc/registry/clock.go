@@ -0,0 +1,36 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+// LedgerClock is the time of the channel as committed by ercc. The time of a transaction is chosen by its
+// client; validation plugins of chaincodes whose endorsers do not check it, such as the ecc vscc, must not rely on
+// it. They use the ledger clock instead, which only advances through ercc transactions whose endorsers compare
+// the transaction time with their clocks.
+type LedgerClock struct {
+	// Time is the latest ercc transaction time (unix seconds) committed to the clock
+	Time int64 `json:"Time"`
+	// Height is the block height at which the clock was advanced to Time
+	Height uint64 `json:"Height"`
+}
+
+// Advance returns the clock advanced to the given time and block height; the clock never goes back
+func (c *LedgerClock) Advance(now int64, height uint64) *LedgerClock {
+	if c != nil && (now < c.Time || height < c.Height) {
+		return c
+	}
+	return &LedgerClock{Time: now, Height: height}
+}
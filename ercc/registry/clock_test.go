@@ -0,0 +1,41 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"testing"
+)
+
+func TestLedgerClock_Advance(t *testing.T) {
+	var clock *LedgerClock
+	clock = clock.Advance(100, 5)
+	if clock.Time != 100 || clock.Height != 5 {
+		t.Fatalf("Unexpected clock %+v", clock)
+	}
+
+	// the clock never goes back
+	if c := clock.Advance(90, 6); c.Time != 100 || c.Height != 5 {
+		t.Fatalf("Clock went back in time: %+v", c)
+	}
+	if c := clock.Advance(110, 4); c.Time != 100 || c.Height != 5 {
+		t.Fatalf("Clock went back in height: %+v", c)
+	}
+
+	if c := clock.Advance(110, 6); c.Time != 110 || c.Height != 6 {
+		t.Fatalf("Unexpected clock %+v", c)
+	}
+}
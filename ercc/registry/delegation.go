@@ -0,0 +1,57 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+// DelegationApproval allows enclaves of chaincode ChaincodeID running MrEnclave to delegate the decryption of data
+// encrypted to them to enclaves running Delegatee, via proxy re-encryption
+type DelegationApproval struct {
+	ChaincodeID string `json:"ChaincodeID"`
+	MrEnclave   string `json:"MrEnclave"`
+	Delegatee   string `json:"Delegatee"`
+	// ApprovedAt is the transaction time (unix seconds) of the latest approval
+	ApprovedAt int64 `json:"ApprovedAt"`
+	// Approvals of the admins of the channel MSPs over the DelegationStatement, see VerifyQuorum
+	Approvals []*Approval `json:"Approvals"`
+}
+
+// ReEncryptionKey is created by a delegator enclave for a delegatee enclave, see crypto.NewReEncryptionKey. It lets
+// ercc re-encrypt data encrypted to the delegator for the delegatee without learning the data.
+type ReEncryptionKey struct {
+	// ChaincodeID of the delegator enclave, whose delegation approval the key refers to
+	ChaincodeID     string `json:"ChaincodeID"`
+	DelegatorPkHash string `json:"DelegatorPkHash"`
+	DelegateePkHash string `json:"DelegateePkHash"`
+	// mrenclaves of both enclaves, checked against their attestation reports when the key is stored
+	MrEnclave          string `json:"MrEnclave"`
+	DelegateeMrEnclave string `json:"DelegateeMrEnclave"`
+	// DelegationPk (sgx format) lets the delegatee derive the delegation scalar
+	DelegationPk []byte `json:"DelegationPk"`
+	// Key (big endian) is applied to ephemeral public keys of ciphertexts encrypted to the delegator
+	Key []byte `json:"Key"`
+}
+
+// DelegatedSecret is a secret provisioned to a delegator enclave and re-encrypted for a delegatee enclave. The
+// ciphertext is the one provisioned to the delegator; only the ephemeral public key is re-encrypted. Ephemeral
+// public keys re-encrypted on request come without ciphertext and approval.
+type DelegatedSecret struct {
+	DelegatorPkHash string `json:"DelegatorPkHash"`
+	DelegationPk    []byte `json:"DelegationPk"`
+	EphemeralPk     []byte `json:"EphemeralPk"`
+	Ciphertext      []byte `json:"Ciphertext,omitempty"`
+	// Approval of the provisioner of the secret, see ProvisionedSecret
+	Approval *Approval `json:"Approval,omitempty"`
+}
@@ -0,0 +1,22 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Package registry defines the records ercc keeps in its state, e.g., EnclaveRecord and AttestationPolicy, along
+// with the rules evaluated on them, so that clients decode and check registry data exactly as ercc does.
+//
+// Its exported API is stable within a major release; see package client for the compatibility rules. The JSON
+// encoding of the records is part of the API, as records are stored on the ledger.
+package registry
@@ -0,0 +1,199 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxEscrowParties bounds the recovery parties of an escrow; shares are indexed by a single non-zero byte
+	MaxEscrowParties = 255
+	// EscrowShareCiphertextSize is the size of an encrypted share of a state key: iv, mac, index and key
+	EscrowShareCiphertextSize = 12 + 16 + 1 + 16
+)
+
+// EscrowParty is a recovery party of an escrow, identified by the MSP ID of its org
+type EscrowParty struct {
+	MSPID string `json:"MSPID"`
+	// Pk is the P-256 public key (DER-encoded PKIX) the share of the party is encrypted to
+	Pk []byte `json:"Pk"`
+}
+
+// EscrowPolicy designates the recovery parties of the state key of a chaincode and how many of them must take
+// part in a recovery
+type EscrowPolicy struct {
+	Threshold int           `json:"Threshold"`
+	Parties   []EscrowParty `json:"Parties"`
+	// Approvals of the admins of the channel MSPs over the EscrowStatement of the policy, which the enclaves verify,
+	// see VerifyQuorum
+	Approvals []*Approval `json:"Approvals"`
+}
+
+// MinEscrowThreshold is the least number of recovery parties, each of another MSP, that must take part in a
+// recovery, so that no single org can recover a state key
+const MinEscrowThreshold = 2
+
+// Validate returns an error if the threshold can not be met, a single org could recover the state key, or the
+// parties are malformed
+func (p *EscrowPolicy) Validate() error {
+	if len(p.Parties) < MinEscrowThreshold || len(p.Parties) > MaxEscrowParties {
+		return fmt.Errorf("expected %d to %d recovery parties, got %d", MinEscrowThreshold, MaxEscrowParties, len(p.Parties))
+	}
+	if p.Threshold < MinEscrowThreshold || p.Threshold > len(p.Parties) {
+		return fmt.Errorf("threshold %d out of range [%d, %d]", p.Threshold, MinEscrowThreshold, len(p.Parties))
+	}
+
+	// distinct MSPs and a threshold of at least two keep any single MSP from recovering the key
+	seen := make(map[string]bool)
+	for _, party := range p.Parties {
+		if party.MSPID == "" || strings.Contains(party.MSPID, "\n") || seen[party.MSPID] {
+			return fmt.Errorf("recovery parties must have distinct MSP IDs")
+		}
+		seen[party.MSPID] = true
+
+		// enclaves encrypt shares with P-256 keys only
+		if _, err := parseP256Pk(party.Pk); err != nil {
+			return fmt.Errorf("invalid pk of %s: %s", party.MSPID, err)
+		}
+	}
+	return nil
+}
+
+// PartyMSPIDs returns the MSP IDs of the recovery parties in the order of the policy
+func (p *EscrowPolicy) PartyMSPIDs() []string {
+	mspIDs := make([]string, len(p.Parties))
+	for i, party := range p.Parties {
+		mspIDs[i] = party.MSPID
+	}
+	return mspIDs
+}
+
+// PartyPks returns the pks of the recovery parties in sgx format (x || y, big endian) in the order of the policy
+func (p *EscrowPolicy) PartyPks() ([][]byte, error) {
+	pks := make([][]byte, len(p.Parties))
+	for i, party := range p.Parties {
+		pub, err := parseP256Pk(party.Pk)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pk of %s: %s", party.MSPID, err)
+		}
+		pks[i] = sgxPk(pub)
+	}
+	return pks, nil
+}
+
+// EscrowStatement is the statement ercc admins sign when setting the escrow policy of chaincode chaincodeID on
+// channel channelID: the threshold, the digest over the MSP IDs of the recovery parties (each newline terminated)
+// and the digest over their pks (sgx format), both in the order of the policy. The escrowing enclave only splits the
+// state key for a policy approved by a quorum of the channel MSPs, see VerifyQuorum.
+func EscrowStatement(channelID, chaincodeID string, threshold int, partyMSPIDs []string, partyPks [][]byte) []byte {
+	mspIDs := sha256.New()
+	for _, mspID := range partyMSPIDs {
+		mspIDs.Write([]byte(mspID + "\n"))
+	}
+	pks := sha256.New()
+	for _, pk := range partyPks {
+		pks.Write(pk)
+	}
+	return statement(escrowStatement, channelID, chaincodeID, strconv.Itoa(threshold),
+		base64.StdEncoding.EncodeToString(mspIDs.Sum(nil)), base64.StdEncoding.EncodeToString(pks.Sum(nil)))
+}
+
+// Statement returns the EscrowStatement of the policy for chaincode chaincodeID on channel channelID
+func (p *EscrowPolicy) Statement(channelID, chaincodeID string) ([]byte, error) {
+	partyPks, err := p.PartyPks()
+	if err != nil {
+		return nil, err
+	}
+	return EscrowStatement(channelID, chaincodeID, p.Threshold, p.PartyMSPIDs(), partyPks), nil
+}
+
+// EscrowMessage returns what the escrowing enclave signs along with an escrow: the digest of the policy statement and
+// the check value of the state key. The recovering enclave only accepts a state key matching a signed check value.
+func EscrowMessage(policyStatement, check []byte) []byte {
+	digest := sha256.Sum256(policyStatement)
+	return statement(escrowCheck, base64.StdEncoding.EncodeToString(digest[:]), base64.StdEncoding.EncodeToString(check))
+}
+
+// VerifyEscrow checks the signature (r || s, big endian) of the enclave with enclavePk (DER-encoded PKIX P-256 key)
+// over an escrow under the policy statement, see EscrowMessage
+func VerifyEscrow(enclavePk, policyStatement, check, signature []byte) error {
+	pub, err := parseP256Pk(enclavePk)
+	if err != nil {
+		return err
+	}
+	if !verifyRawSignature(pub, EscrowMessage(policyStatement, check), signature) {
+		return errors.New("invalid escrow signature")
+	}
+	return nil
+}
+
+// Party returns the recovery party with the given MSP ID or nil
+func (p *EscrowPolicy) Party(mspID string) *EscrowParty {
+	for i := range p.Parties {
+		if p.Parties[i].MSPID == mspID {
+			return &p.Parties[i]
+		}
+	}
+	return nil
+}
+
+// EscrowShare is a share of a state key encrypted to a recovery party or, during recovery, re-encrypted by the
+// party to the recovering enclave. The encryption key is derived via ECDH from the ephemeral key (sgx format).
+type EscrowShare struct {
+	MSPID       string `json:"MSPID"`
+	EphemeralPk []byte `json:"EphemeralPk"`
+	Ciphertext  []byte `json:"Ciphertext"`
+}
+
+// Escrow holds the shares of the state key of a chaincode, one per party of the escrow policy in its order
+type Escrow struct {
+	EnclavePkHash string `json:"EnclavePkHash"`
+	Threshold     int    `json:"Threshold"`
+	// Check identifies the state key, i.e., HMAC-SHA256 under the key; a recovered key must match it
+	Check  []byte        `json:"Check"`
+	Shares []EscrowShare `json:"Shares"`
+	// EscrowedAt is the transaction time (unix seconds) of the escrow
+	EscrowedAt int64 `json:"EscrowedAt"`
+	// PartyMSPIDs, PartyPks (sgx format) and Approvals are those of the escrow policy the state key was escrowed
+	// under
+	PartyMSPIDs []string    `json:"PartyMSPIDs"`
+	PartyPks    [][]byte    `json:"PartyPks"`
+	Approvals   []*Approval `json:"Approvals"`
+	// Signature of the escrowing enclave over the EscrowMessage
+	Signature []byte `json:"Signature"`
+}
+
+// Recovery holds the shares recovery parties re-encrypted to an enclave and what the enclave verifies them with:
+// the check value of the escrow signed by the escrowing enclave (EscrowedBy) and the escrow policy it was signed
+// under
+type Recovery struct {
+	EnclavePkHash string        `json:"EnclavePkHash"`
+	Check         []byte        `json:"Check"`
+	Shares        []EscrowShare `json:"Shares"`
+	EscrowedBy    string        `json:"EscrowedBy"`
+	Threshold     int           `json:"Threshold"`
+	PartyMSPIDs   []string      `json:"PartyMSPIDs"`
+	PartyPks      [][]byte      `json:"PartyPks"`
+	Approvals     []*Approval   `json:"Approvals"`
+	Signature     []byte        `json:"Signature"`
+}
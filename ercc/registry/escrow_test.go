@@ -0,0 +1,115 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+)
+
+func TestEscrowPolicy_Validate(t *testing.T) {
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not create key: %s", err)
+	}
+	pk, _ := x509.MarshalPKIXPublicKey(&sk.PublicKey)
+	parties := []EscrowParty{{MSPID: "Org1MSP", Pk: pk}, {MSPID: "Org2MSP", Pk: pk}}
+
+	if err := (&EscrowPolicy{Threshold: 2, Parties: parties}).Validate(); err != nil {
+		t.Fatalf("Valid policy should be accepted: %s", err)
+	}
+	if err := (&EscrowPolicy{Threshold: 3, Parties: parties}).Validate(); err == nil {
+		t.Fatalf("Threshold above the number of parties should be rejected")
+	}
+	if err := (&EscrowPolicy{Threshold: 0, Parties: parties}).Validate(); err == nil {
+		t.Fatalf("Zero threshold should be rejected")
+	}
+	// no single org may recover the state key
+	if err := (&EscrowPolicy{Threshold: 1, Parties: parties}).Validate(); err == nil {
+		t.Fatalf("Threshold of one should be rejected")
+	}
+	if err := (&EscrowPolicy{Threshold: 1, Parties: parties[:1]}).Validate(); err == nil {
+		t.Fatalf("Single recovery party should be rejected")
+	}
+	if err := (&EscrowPolicy{Threshold: 2, Parties: []EscrowParty{parties[0], parties[0]}}).Validate(); err == nil {
+		t.Fatalf("Parties of the same MSP should be rejected")
+	}
+	if err := (&EscrowPolicy{Threshold: 2, Parties: []EscrowParty{parties[0], {MSPID: "Org2MSP\nOrg3MSP", Pk: pk}}}).Validate(); err == nil {
+		t.Fatalf("MSP IDs with newlines should be rejected")
+	}
+	if err := (&EscrowPolicy{Threshold: 2, Parties: []EscrowParty{parties[0], {MSPID: "Org2MSP", Pk: []byte("pk")}}}).Validate(); err == nil {
+		t.Fatalf("Invalid pk should be rejected")
+	}
+	if p := (&EscrowPolicy{Threshold: 2, Parties: parties}).Party("Org2MSP"); p == nil || p.MSPID != "Org2MSP" {
+		t.Fatalf("Expected party Org2MSP")
+	}
+}
+
+func TestVerifyEscrow(t *testing.T) {
+	sk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherSk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pk, _ := x509.MarshalPKIXPublicKey(&sk.PublicKey)
+	otherPk, _ := x509.MarshalPKIXPublicKey(&otherSk.PublicKey)
+
+	policy := &EscrowPolicy{Threshold: 2, Parties: []EscrowParty{{MSPID: "Org1MSP", Pk: otherPk}, {MSPID: "Org2MSP", Pk: pk}}}
+	partyPks, err := policy.PartyPks()
+	if err != nil {
+		t.Fatalf("Can not get party pks: %s", err)
+	}
+	if len(partyPks) != 2 || len(partyPks[0]) != 64 {
+		t.Fatalf("Expected two party pks in sgx format")
+	}
+	statement, err := policy.Statement("mychannel", "ecc")
+	if err != nil {
+		t.Fatalf("Can not get policy statement: %s", err)
+	}
+	check := []byte("check")
+
+	digest := sha256.Sum256(EscrowMessage(statement, check))
+	r, s, err := ecdsa.Sign(rand.Reader, sk, digest[:])
+	if err != nil {
+		t.Fatalf("Can not sign escrow: %s", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	if err := VerifyEscrow(pk, statement, check, signature); err != nil {
+		t.Fatalf("Escrow should be valid: %s", err)
+	}
+	// the signature binds the policy and the state key
+	for _, other := range [][]byte{
+		EscrowStatement("mychannel", "ecc", 1, policy.PartyMSPIDs(), partyPks),
+		EscrowStatement("mychannel", "ecc", 2, []string{"Org1MSP", "Org3MSP"}, partyPks),
+		EscrowStatement("otherchannel", "ecc", 2, policy.PartyMSPIDs(), partyPks),
+		EscrowStatement("mychannel", "other", 2, policy.PartyMSPIDs(), partyPks),
+	} {
+		if err := VerifyEscrow(pk, other, check, signature); err == nil {
+			t.Fatalf("Escrow under another policy should be invalid")
+		}
+	}
+	if err := VerifyEscrow(pk, statement, []byte("other"), signature); err == nil {
+		t.Fatalf("Escrow of another key should be invalid")
+	}
+	if err := VerifyEscrow(otherPk, statement, check, signature); err == nil {
+		t.Fatalf("Escrow signed by another enclave should be invalid")
+	}
+}
@@ -0,0 +1,76 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// MaxEvidenceSize is the maximum size of uncompressed attestation evidence (attestation report incl.
+// certificate chain, quote) stored in the registry
+const MaxEvidenceSize = 64 * 1024
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// CompressEvidence compresses attestation evidence before it is put to state.
+// Note that all endorsers must produce the same output, thus, no header fields (name, mod time) are set.
+func CompressEvidence(evidence []byte) ([]byte, error) {
+	if len(evidence) > MaxEvidenceSize {
+		return nil, fmt.Errorf("evidence of %d bytes exceeds maximum size of %d bytes", len(evidence), MaxEvidenceSize)
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(evidence); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecompressEvidence returns the evidence as stored by CompressEvidence. Evidence stored uncompressed
+// (i.e., registered by earlier versions) is returned as is.
+func DecompressEvidence(stored []byte) ([]byte, error) {
+	if !bytes.HasPrefix(stored, gzipMagic) {
+		return stored, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// guard against decompression bombs
+	evidence, err := ioutil.ReadAll(io.LimitReader(r, MaxEvidenceSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(evidence) > MaxEvidenceSize {
+		return nil, fmt.Errorf("evidence exceeds maximum size of %d bytes", MaxEvidenceSize)
+	}
+	return evidence, nil
+}
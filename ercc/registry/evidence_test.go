@@ -0,0 +1,51 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressEvidence(t *testing.T) {
+	evidence := bytes.Repeat([]byte(`{"isvEnclaveQuoteStatus":"OK"}`), 100)
+
+	compressed, err := CompressEvidence(evidence)
+	if err != nil {
+		t.Fatalf("CompressEvidence failed: %s", err)
+	}
+	if len(compressed) >= len(evidence) {
+		t.Fatalf("Evidence not compressed")
+	}
+
+	decompressed, err := DecompressEvidence(compressed)
+	if err != nil {
+		t.Fatalf("DecompressEvidence failed: %s", err)
+	}
+	if !bytes.Equal(decompressed, evidence) {
+		t.Fatalf("Decompressed evidence does not match")
+	}
+
+	// uncompressed evidence is returned as is
+	if plain, err := DecompressEvidence(evidence); err != nil || !bytes.Equal(plain, evidence) {
+		t.Fatalf("Uncompressed evidence not returned as is")
+	}
+
+	if _, err := CompressEvidence(make([]byte, MaxEvidenceSize+1)); err == nil {
+		t.Fatalf("CompressEvidence should reject oversized evidence")
+	}
+}
@@ -0,0 +1,80 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"fmt"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+)
+
+// ForeignChannels lists the channels whose enclaves may be imported into this channel
+type ForeignChannels struct {
+	ChannelIDs []string `json:"ChannelIDs"`
+	// MaxAgeSeconds is how long an import is trusted without being refreshed; zero means forever
+	MaxAgeSeconds int64 `json:"MaxAgeSeconds,omitempty"`
+}
+
+// Allows returns true if enclaves of the given channel may be imported
+func (c *ForeignChannels) Allows(channelID string) bool {
+	return contains(c.ChannelIDs, channelID)
+}
+
+// IsFresh returns false if an import at importedAt is too old at unix time now
+func (c *ForeignChannels) IsFresh(importedAt, now int64) bool {
+	return c.MaxAgeSeconds == 0 || now-importedAt <= c.MaxAgeSeconds
+}
+
+// ForeignEnclave is an enclave registered on another channel and imported into this one. Record is the registration
+// on the source channel as returned by its getEnclaveByPk.
+type ForeignEnclave struct {
+	ChannelID string        `json:"ChannelID"`
+	Record    EnclaveRecord `json:"Record"`
+	// ImportedBy is the msp id of the org that imported the enclave
+	ImportedBy string `json:"ImportedBy"`
+	// ImportedAt is the unix time (seconds) of the import
+	ImportedAt int64 `json:"ImportedAt"`
+}
+
+// IsRevoked returns true if the source channel retired or revoked the enclave
+func (f *ForeignEnclave) IsRevoked() bool {
+	return f.Record.SuccessorPkHash != "" || (f.Record.Status != nil && f.Record.Status.IsRevoked())
+}
+
+// CheckForeignRecord checks the parts of a registration record of channel channelID that do not depend on the
+// attestation evidence: the record must belong to its key, a bound quote must be bound to channelID, the algorithms
+// must be those of the key and a registrar signature, if any, must cover the attestation report. The evidence itself
+// is verified by the importing ercc.
+func CheckForeignRecord(channelID string, record *EnclaveRecord) error {
+	enclavePk := record.AttestationReport.EnclavePk
+	if !consttime.EqualString(record.EnclavePkHash, EnclavePkHash(enclavePk)) {
+		return fmt.Errorf("record does not belong to the enclave pk")
+	}
+	if record.Binding != nil && record.Binding.ChannelID != channelID {
+		return fmt.Errorf("quote is bound to channel %s", record.Binding.ChannelID)
+	}
+	if err := algorithm.CheckKey(record.Algorithms, enclavePk); err != nil {
+		return err
+	}
+	if record.RegistrarSignature != nil {
+		if err := record.RegistrarSignature.Verify(record.AttestationReport); err != nil {
+			return fmt.Errorf("invalid registrar signature: %s", err)
+		}
+	}
+	return nil
+}
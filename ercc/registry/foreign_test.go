@@ -0,0 +1,83 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+func TestCheckForeignRecord(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	enclavePk, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	valid := func() *EnclaveRecord {
+		return &EnclaveRecord{
+			EnclavePkHash:     EnclavePkHash(enclavePk),
+			AttestationReport: attestation.IASAttestationReport{EnclavePk: enclavePk},
+			Binding:           &attestation.ReportDataBinding{ChannelID: "ch2", ChaincodeID: "cc"},
+		}
+	}
+
+	if err := CheckForeignRecord("ch2", valid()); err != nil {
+		t.Fatalf("Record should be accepted: %s", err)
+	}
+
+	otherChannel := valid()
+	if err := CheckForeignRecord("ch3", otherChannel); err == nil {
+		t.Fatalf("Record bound to another channel should be rejected")
+	}
+	otherKey := valid()
+	otherKey.EnclavePkHash = EnclavePkHash([]byte("other"))
+	if err := CheckForeignRecord("ch2", otherKey); err == nil {
+		t.Fatalf("Record of another key should be rejected")
+	}
+	otherAlgorithms := valid()
+	otherAlgorithms.Algorithms = &algorithm.Algorithms{Signature: "Ed25519", Encryption: "X25519"}
+	if err := CheckForeignRecord("ch2", otherAlgorithms); err == nil {
+		t.Fatalf("Record with algorithms not matching the key should be rejected")
+	}
+}
+
+func TestForeignEnclave_IsRevoked(t *testing.T) {
+	foreign := &ForeignEnclave{ChannelID: "ch2"}
+	if foreign.IsRevoked() {
+		t.Fatalf("Enclave without status should not be revoked")
+	}
+	foreign.Record.Status = &EnclaveStatus{QuoteStatus: QuoteStatusOK, RevokedBy: []string{"INTEL-SA-00161"}}
+	if !foreign.IsRevoked() {
+		t.Fatalf("Enclave revoked by an advisory should be revoked")
+	}
+	foreign.Record.Status = nil
+	foreign.Record.SuccessorPkHash = "successor"
+	if !foreign.IsRevoked() {
+		t.Fatalf("Retired enclave should be revoked")
+	}
+
+	channels := &ForeignChannels{ChannelIDs: []string{"ch2"}, MaxAgeSeconds: 60}
+	if !channels.Allows("ch2") || channels.Allows("ch3") {
+		t.Fatalf("Only listed channels should be allowed")
+	}
+	if !channels.IsFresh(100, 160) || channels.IsFresh(100, 161) {
+		t.Fatalf("Imports should expire after MaxAgeSeconds")
+	}
+}
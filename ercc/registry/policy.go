@@ -0,0 +1,277 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+// iasTimestampLayout is the format of the timestamp in IAS report bodies (UTC without time zone)
+const iasTimestampLayout = "2006-01-02T15:04:05.999999999"
+
+// maxReportClockSkew is the tolerance (seconds) for report timestamps ahead of the transaction time
+const maxReportClockSkew = 300
+
+// AttestationPolicy is the attestation policy of a channel. It is stored in the registry so that all orgs
+// evaluate registrations against the same agreed policy rather than against peer-local configuration.
+type AttestationPolicy struct {
+	// Version is increased by every update of the policy
+	Version int64 `json:"Version"`
+	// AllowedQuoteStatuses lists the accepted IAS quote statuses; if empty, only OK is accepted
+	AllowedQuoteStatuses []string `json:"AllowedQuoteStatuses,omitempty"`
+	// MaxReportAge is the maximal age (seconds) of an attestation report at registration (0 = unlimited)
+	MaxReportAge int64 `json:"MaxReportAge"`
+	// MrEnclaves lists the accepted measurements (base64). If both MrEnclaves and Signers are empty, any enclave
+	// is accepted; otherwise an enclave must match one of either.
+	MrEnclaves []string `json:"MrEnclaves,omitempty"`
+	// Signers lists the accepted enclave signers, so that rebuilds of a chaincode by the same vendor are accepted
+	// without a policy update
+	Signers []SignerPolicy `json:"Signers,omitempty"`
+	// RegistrationValidity is the time (seconds) a registration stays valid, counted from the timestamp of its
+	// attestation report (0 = unlimited)
+	RegistrationValidity int64 `json:"RegistrationValidity,omitempty"`
+	// ConfigIDs lists the accepted config ids (base64, 64 bytes) of enclaves using Key Separation & Sharing; if
+	// empty, any config is accepted. Enclaves without KSS have the all-zero config id.
+	ConfigIDs []string `json:"ConfigIDs,omitempty"`
+	// MinConfigSvn is the minimum config SVN of enclaves using Key Separation & Sharing
+	MinConfigSvn uint16 `json:"MinConfigSvn,omitempty"`
+	// ReplayCacheTTL is the time (seconds) the REPORT_DATA of a registered quote is remembered to reject replays
+	// of the quote (0 = forever)
+	ReplayCacheTTL int64 `json:"ReplayCacheTTL,omitempty"`
+	// SubmissionTimeout is the time (seconds) after which a quote submission that was not finalized is stale; stale
+	// submissions can not be finalized and may be replaced by any org (0 = one day)
+	SubmissionTimeout int64 `json:"SubmissionTimeout,omitempty"`
+	// AllowDebugEnclaves accepts enclaves launched in debug mode, whose memory the host can inspect. Debug
+	// enclaves are rejected without a policy and by default; enable for development networks only.
+	AllowDebugEnclaves bool `json:"AllowDebugEnclaves,omitempty"`
+}
+
+// SignerPolicy accepts enclaves signed by the key with hash MrSigner (base64) for product IsvProdID with an ISV SVN
+// of at least MinIsvSvn. Vendors using Key Separation & Sharing can be restricted further to an extended product
+// id and a product family.
+type SignerPolicy struct {
+	MrSigner  string `json:"MrSigner"`
+	IsvProdID uint16 `json:"IsvProdID"`
+	MinIsvSvn uint16 `json:"MinIsvSvn"`
+	// IsvExtProdID is the required ISV extended product id (base64, 16 bytes); if empty, any is accepted
+	IsvExtProdID string `json:"IsvExtProdID,omitempty"`
+	// IsvFamilyID is the required ISV family id (base64, 16 bytes); if empty, any is accepted
+	IsvFamilyID string `json:"IsvFamilyID,omitempty"`
+}
+
+// Matches returns true if the enclave that produced quote is accepted by the signer policy
+func (p *SignerPolicy) Matches(quote attestation.EnclaveQuote) bool {
+	if !consttime.EqualString(MrSigner(quote), p.MrSigner) || IsvProdID(quote) != p.IsvProdID || IsvSvn(quote) < p.MinIsvSvn {
+		return false
+	}
+	if p.IsvExtProdID != "" && IsvExtProdID(quote) != p.IsvExtProdID {
+		return false
+	}
+	return p.IsvFamilyID == "" || IsvFamilyID(quote) == p.IsvFamilyID
+}
+
+// validate returns an error if the signer policy is malformed
+func (p *SignerPolicy) validate() error {
+	if m, err := base64.StdEncoding.DecodeString(p.MrSigner); err != nil || len(m) != 32 {
+		return fmt.Errorf("invalid mrsigner %s", p.MrSigner)
+	}
+	if id, err := base64.StdEncoding.DecodeString(p.IsvExtProdID); err != nil || (len(id) != 0 && len(id) != 16) {
+		return fmt.Errorf("invalid isv ext prod id %s", p.IsvExtProdID)
+	}
+	if id, err := base64.StdEncoding.DecodeString(p.IsvFamilyID); err != nil || (len(id) != 0 && len(id) != 16) {
+		return fmt.Errorf("invalid isv family id %s", p.IsvFamilyID)
+	}
+	return nil
+}
+
+// AttestationPolicyChangedEventName is the name of the chaincode event ercc emits when the attestation policy is updated
+const AttestationPolicyChangedEventName = "attestationPolicyChanged"
+
+// AttestationPolicyChange is the payload of an attestation policy changed event. It records the digests of the
+// old and new policy for audits; the old digest is empty if there was no policy before.
+type AttestationPolicyChange struct {
+	OldVersion int64  `json:"OldVersion"`
+	OldDigest  string `json:"OldDigest,omitempty"`
+	NewVersion int64  `json:"NewVersion"`
+	NewDigest  string `json:"NewDigest"`
+	// MspID of the admin who updated the policy
+	MspID string `json:"MspID"`
+}
+
+// Digest returns the digest (base64) of the canonical encoding of the policy
+func (p *AttestationPolicy) Digest() (string, error) {
+	policyAsBytes, err := MarshalCanonical(p)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(policyAsBytes)
+	return base64.StdEncoding.EncodeToString(h[:]), nil
+}
+
+// Validate returns an error if the policy is malformed
+func (p *AttestationPolicy) Validate() error {
+	if p.MaxReportAge < 0 {
+		return fmt.Errorf("invalid max report age %d", p.MaxReportAge)
+	}
+	if p.RegistrationValidity < 0 {
+		return fmt.Errorf("invalid registration validity %d", p.RegistrationValidity)
+	}
+	if p.ReplayCacheTTL < 0 {
+		return fmt.Errorf("invalid replay cache ttl %d", p.ReplayCacheTTL)
+	}
+	for _, status := range p.AllowedQuoteStatuses {
+		if status == "" {
+			return fmt.Errorf("empty quote status")
+		}
+	}
+	for _, mrEnclave := range p.MrEnclaves {
+		m, err := base64.StdEncoding.DecodeString(mrEnclave)
+		if err != nil || len(m) != 32 {
+			return fmt.Errorf("invalid mrenclave %s", mrEnclave)
+		}
+	}
+	for _, configID := range p.ConfigIDs {
+		id, err := base64.StdEncoding.DecodeString(configID)
+		if err != nil || len(id) != 64 {
+			return fmt.Errorf("invalid config id %s", configID)
+		}
+	}
+	for i := range p.Signers {
+		if err := p.Signers[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check returns an error if the attestation report body of a registration at time now (unix seconds)
+// violates the policy
+func (p *AttestationPolicy) Check(quote attestation.EnclaveQuote, reportBody attestation.IASReportBody, now int64) error {
+	allowedStatuses := p.AllowedQuoteStatuses
+	if len(allowedStatuses) == 0 {
+		allowedStatuses = []string{QuoteStatusOK}
+	}
+	if !contains(allowedStatuses, reportBody.IsvEnclaveQuoteStatus) {
+		return fmt.Errorf("quote status %s not allowed", reportBody.IsvEnclaveQuoteStatus)
+	}
+
+	if p.MaxReportAge > 0 || p.RegistrationValidity > 0 {
+		reportTime, err := ReportTime(reportBody)
+		if err != nil {
+			return err
+		}
+		if reportTime > now+maxReportClockSkew {
+			return fmt.Errorf("attestation report from %s is from the future", reportBody.Timestamp)
+		}
+		if p.MaxReportAge > 0 && now-reportTime > p.MaxReportAge {
+			return fmt.Errorf("attestation report from %s is too old", reportBody.Timestamp)
+		}
+		if p.RegistrationValidity > 0 && now >= reportTime+p.RegistrationValidity {
+			return fmt.Errorf("attestation report from %s is expired", reportBody.Timestamp)
+		}
+	}
+
+	if err := CheckDebugEnclave(quote, p); err != nil {
+		return err
+	}
+	if !p.acceptsEnclave(quote) {
+		return fmt.Errorf("enclave with mrenclave %s, mrsigner %s, product id %d (extended %s, family %s) and isv svn %d not allowed",
+			MrEnclave(quote), MrSigner(quote), IsvProdID(quote), IsvExtProdID(quote), IsvFamilyID(quote), IsvSvn(quote))
+	}
+	if len(p.ConfigIDs) > 0 && !contains(p.ConfigIDs, ConfigID(quote)) {
+		return fmt.Errorf("config id %s not allowed", ConfigID(quote))
+	}
+	if quote.ConfigSvn() < p.MinConfigSvn {
+		return fmt.Errorf("config svn %d below %d", quote.ConfigSvn(), p.MinConfigSvn)
+	}
+	return nil
+}
+
+// CheckReport returns the quote of an attestation report of a registration at time now (unix seconds) and an error
+// if the report violates the policy p, which may be nil. Without policy all reports but those of debug enclaves are
+// accepted. ercc and its vscc both evaluate registrations with it, so that they accept the same enclaves.
+func CheckReport(p *AttestationPolicy, report attestation.IASAttestationReport, now int64) (attestation.EnclaveQuote, error) {
+	quote, err := attestation.QuoteFromAttestionReport(report)
+	if err != nil {
+		return quote, fmt.Errorf("can not parse quote: %s", err)
+	}
+	if p == nil {
+		return quote, CheckDebugEnclave(quote, nil)
+	}
+
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return quote, fmt.Errorf("can not parse report body: %s", err)
+	}
+	return quote, p.Check(quote, reportBody, now)
+}
+
+// CheckDebugEnclave returns an error if the quote comes from a debug enclave and the policy, which may be nil, does
+// not allow debug enclaves
+func CheckDebugEnclave(quote attestation.EnclaveQuote, p *AttestationPolicy) error {
+	if quote.IsDebug() && (p == nil || !p.AllowDebugEnclaves) {
+		return fmt.Errorf("enclave with mrenclave %s runs in debug mode", MrEnclave(quote))
+	}
+	return nil
+}
+
+// acceptsEnclave returns true if the enclave that produced quote matches a listed mrenclave or signer, or if the
+// policy lists neither
+func (p *AttestationPolicy) acceptsEnclave(quote attestation.EnclaveQuote) bool {
+	if len(p.MrEnclaves) == 0 && len(p.Signers) == 0 {
+		return true
+	}
+	if contains(p.MrEnclaves, MrEnclave(quote)) {
+		return true
+	}
+	for _, signer := range p.Signers {
+		if signer.Matches(quote) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpiresAt returns the time (unix seconds) at which a registration with the given attestation report body
+// expires, or 0 if registrations do not expire
+func (p *AttestationPolicy) ExpiresAt(reportBody attestation.IASReportBody) (int64, error) {
+	if p.RegistrationValidity == 0 {
+		return 0, nil
+	}
+	reportTime, err := ReportTime(reportBody)
+	if err != nil {
+		return 0, err
+	}
+	return reportTime + p.RegistrationValidity, nil
+}
+
+// ReportTime returns the time (unix seconds) at which IAS issued a report. As the timestamp is part of the signed
+// report body, it is the same for all endorsers and can not be manipulated, unlike the clock of a peer.
+func ReportTime(reportBody attestation.IASReportBody) (int64, error) {
+	reportTime, err := time.Parse(iasTimestampLayout, reportBody.Timestamp)
+	if err != nil {
+		return 0, fmt.Errorf("invalid report timestamp %s", reportBody.Timestamp)
+	}
+	return reportTime.Unix(), nil
+}
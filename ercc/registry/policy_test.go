@@ -0,0 +1,214 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+func TestAttestationPolicy_Check(t *testing.T) {
+	quote := attestation.EnclaveQuote{}
+	quote.MrEnclave[0] = 1
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	reportBody := attestation.IASReportBody{
+		IsvEnclaveQuoteStatus: QuoteStatusGroupOutOfDate,
+		Timestamp:             now.Add(-time.Hour).Format(iasTimestampLayout),
+	}
+
+	if err := (&AttestationPolicy{}).Check(quote, reportBody, now.Unix()); err == nil {
+		t.Fatalf("Default policy should only accept quote status OK")
+	}
+
+	policy := &AttestationPolicy{
+		AllowedQuoteStatuses: []string{QuoteStatusOK, QuoteStatusGroupOutOfDate},
+		MaxReportAge:         7200,
+		MrEnclaves:           []string{MrEnclave(quote)},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Policy should be valid: %s", err)
+	}
+	if err := policy.Check(quote, reportBody, now.Unix()); err != nil {
+		t.Fatalf("Report should be accepted: %s", err)
+	}
+	if err := policy.Check(quote, reportBody, now.Add(2*time.Hour).Unix()); err == nil {
+		t.Fatalf("Report too old should be rejected")
+	}
+
+	quote.MrEnclave[0] = 2
+	if err := policy.Check(quote, reportBody, now.Unix()); err == nil {
+		t.Fatalf("Unlisted mrenclave should be rejected")
+	}
+
+	policy.MrEnclaves = []string{base64.StdEncoding.EncodeToString([]byte("short"))}
+	if err := policy.Validate(); err == nil {
+		t.Fatalf("Policy with invalid mrenclave should be invalid")
+	}
+}
+
+func TestAttestationPolicy_CheckSigner(t *testing.T) {
+	quote := attestation.EnclaveQuote{}
+	quote.MrEnclave[0] = 1
+	quote.MrSigner[0] = 2
+	binary.LittleEndian.PutUint16(quote.ISVProdID[:], 3)
+	binary.LittleEndian.PutUint16(quote.ISVSVN[:], 4)
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	reportBody := attestation.IASReportBody{IsvEnclaveQuoteStatus: QuoteStatusOK}
+
+	policy := &AttestationPolicy{
+		MrEnclaves: []string{base64.StdEncoding.EncodeToString(make([]byte, 32))},
+		Signers:    []SignerPolicy{{MrSigner: MrSigner(quote), IsvProdID: 3, MinIsvSvn: 4}},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Policy should be valid: %s", err)
+	}
+	// a rebuild with another mrenclave is accepted by its signer
+	if err := policy.Check(quote, reportBody, now.Unix()); err != nil {
+		t.Fatalf("Enclave of an accepted signer should be accepted: %s", err)
+	}
+
+	binary.LittleEndian.PutUint16(quote.ISVSVN[:], 3)
+	if err := policy.Check(quote, reportBody, now.Unix()); err == nil {
+		t.Fatalf("Enclave below the min isv svn should be rejected")
+	}
+	binary.LittleEndian.PutUint16(quote.ISVSVN[:], 5)
+	binary.LittleEndian.PutUint16(quote.ISVProdID[:], 1)
+	if err := policy.Check(quote, reportBody, now.Unix()); err == nil {
+		t.Fatalf("Enclave of another product should be rejected")
+	}
+	binary.LittleEndian.PutUint16(quote.ISVProdID[:], 3)
+	quote.MrSigner[0] = 3
+	if err := policy.Check(quote, reportBody, now.Unix()); err == nil {
+		t.Fatalf("Enclave of another signer should be rejected")
+	}
+
+	// with KSS, the vendor key authorizes one product family only
+	binary.LittleEndian.PutUint16(quote.ISVSVN[:], 4)
+	quote.MrSigner[0] = 2
+	quote.Reserved4[44] = 7
+	family := quote.IsvFamilyID()
+	policy.Signers[0].IsvFamilyID = base64.StdEncoding.EncodeToString(family[:])
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Policy should be valid: %s", err)
+	}
+	if err := policy.Check(quote, reportBody, now.Unix()); err != nil {
+		t.Fatalf("Enclave of the accepted family should be accepted: %s", err)
+	}
+	quote.Reserved4[44] = 8
+	if err := policy.Check(quote, reportBody, now.Unix()); err == nil {
+		t.Fatalf("Enclave of another family should be rejected")
+	}
+	quote.Reserved4[44] = 7
+	policy.Signers[0].IsvExtProdID = base64.StdEncoding.EncodeToString([]byte{1})
+	if err := policy.Validate(); err == nil {
+		t.Fatalf("Policy with invalid isv ext prod id should be invalid")
+	}
+	extProdID := quote.IsvExtProdID()
+	extProdID[0] = 1
+	policy.Signers[0].IsvExtProdID = base64.StdEncoding.EncodeToString(extProdID[:])
+	if err := policy.Check(quote, reportBody, now.Unix()); err == nil {
+		t.Fatalf("Enclave of another extended product should be rejected")
+	}
+
+	policy.Signers[0].MrSigner = "not base64"
+	if err := policy.Validate(); err == nil {
+		t.Fatalf("Policy with invalid mrsigner should be invalid")
+	}
+}
+
+func TestAttestationPolicy_ExpiresAt(t *testing.T) {
+	quote := attestation.EnclaveQuote{}
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	reportTime := now.Add(-time.Hour)
+	reportBody := attestation.IASReportBody{
+		IsvEnclaveQuoteStatus: QuoteStatusOK,
+		Timestamp:             reportTime.Format(iasTimestampLayout),
+	}
+
+	if expiresAt, err := (&AttestationPolicy{}).ExpiresAt(reportBody); err != nil || expiresAt != 0 {
+		t.Fatalf("Registrations should not expire by default")
+	}
+
+	// validity counts from the IAS timestamp, not from the registration
+	policy := &AttestationPolicy{RegistrationValidity: 7200}
+	expiresAt, err := policy.ExpiresAt(reportBody)
+	if err != nil {
+		t.Fatalf("ExpiresAt failed: %s", err)
+	}
+	if expiresAt != reportTime.Add(2*time.Hour).Unix() {
+		t.Fatalf("Unexpected expiry %d", expiresAt)
+	}
+	if err := policy.Check(quote, reportBody, now.Unix()); err != nil {
+		t.Fatalf("Report should be accepted: %s", err)
+	}
+	if err := policy.Check(quote, reportBody, now.Add(time.Hour).Unix()); err == nil {
+		t.Fatalf("Expired report should be rejected")
+	}
+
+	future := reportBody
+	future.Timestamp = now.Add(time.Hour).Format(iasTimestampLayout)
+	if err := policy.Check(quote, future, now.Unix()); err == nil {
+		t.Fatalf("Report from the future should be rejected")
+	}
+
+	missing := reportBody
+	missing.Timestamp = ""
+	if err := policy.Check(quote, missing, now.Unix()); err == nil {
+		t.Fatalf("Report without timestamp should be rejected")
+	}
+
+	policy.RegistrationValidity = -1
+	if err := policy.Validate(); err == nil {
+		t.Fatalf("Policy with negative validity should be invalid")
+	}
+}
+
+func TestAttestationPolicy_CheckConfig(t *testing.T) {
+	quote := attestation.EnclaveQuote{}
+	quote.Attributes[0] = attestation.FlagKSS
+	quote.Reserved3[32] = 1
+	binary.LittleEndian.PutUint16(quote.Reserved4[:2], 2)
+	reportBody := attestation.IASReportBody{IsvEnclaveQuoteStatus: QuoteStatusOK}
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC).Unix()
+
+	policy := &AttestationPolicy{ConfigIDs: []string{ConfigID(quote)}, MinConfigSvn: 2}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Policy should be valid: %s", err)
+	}
+	if err := policy.Check(quote, reportBody, now); err != nil {
+		t.Fatalf("Enclave with accepted config should be accepted: %s", err)
+	}
+
+	binary.LittleEndian.PutUint16(quote.Reserved4[:2], 1)
+	if err := policy.Check(quote, reportBody, now); err == nil {
+		t.Fatalf("Enclave below the min config svn should be rejected")
+	}
+	binary.LittleEndian.PutUint16(quote.Reserved4[:2], 2)
+	quote.Reserved3[32] = 2
+	if err := policy.Check(quote, reportBody, now); err == nil {
+		t.Fatalf("Enclave with another config should be rejected")
+	}
+
+	policy.ConfigIDs = []string{base64.StdEncoding.EncodeToString(make([]byte, 32))}
+	if err := policy.Validate(); err == nil {
+		t.Fatalf("Policy with invalid config id should be invalid")
+	}
+}
@@ -0,0 +1,70 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+// PSE manifest status values reported by IAS
+const (
+	PseManifestStatusOK                = "OK"
+	PseManifestStatusUnknown           = "UNKNOWN"
+	PseManifestStatusInvalid           = "INVALID"
+	PseManifestStatusOutOfDate         = "OUT_OF_DATE"
+	PseManifestStatusRevoked           = "REVOKED"
+	PseManifestStatusRLVersionMismatch = "RL_VERSION_MISMATCH"
+)
+
+// PsePolicy states whether enclaves must prove a trusted platform service enclave (PSE), e.g., because the
+// chaincode relies on trusted time or monotonic counters
+type PsePolicy struct {
+	Required bool `json:"Required"`
+	// AllowOutOfDate accepts PSE manifests that are out of date
+	AllowOutOfDate bool `json:"AllowOutOfDate"`
+}
+
+// CheckPseManifest returns an error if the PSE manifest status of the report body violates the policy.
+// Reports without PSE manifest are accepted if PSE is not required; a reported manifest must be valid anyway.
+func (p *PsePolicy) CheckPseManifest(reportBody attestation.IASReportBody) error {
+	if reportBody.PseManifestStatus == "" {
+		if p.Required {
+			return fmt.Errorf("PSE manifest required but not reported")
+		}
+		return nil
+	}
+
+	switch reportBody.PseManifestStatus {
+	case PseManifestStatusOK:
+	case PseManifestStatusOutOfDate:
+		if !p.AllowOutOfDate {
+			return fmt.Errorf("PSE manifest is out of date")
+		}
+	default:
+		return fmt.Errorf("PSE manifest status %s", reportBody.PseManifestStatus)
+	}
+
+	// IAS reports the SHA-256 hash of the submitted manifest in hex
+	hash, err := hex.DecodeString(reportBody.PseManifestHash)
+	if err != nil || len(hash) != 32 {
+		return fmt.Errorf("invalid PSE manifest hash %s", reportBody.PseManifestHash)
+	}
+	return nil
+}
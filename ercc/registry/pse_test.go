@@ -0,0 +1,49 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+func TestPsePolicy_CheckPseManifest(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	required := &PsePolicy{Required: true}
+	optional := &PsePolicy{}
+
+	if err := optional.CheckPseManifest(attestation.IASReportBody{}); err != nil {
+		t.Fatalf("Missing manifest should be accepted if not required: %s", err)
+	}
+	if err := required.CheckPseManifest(attestation.IASReportBody{}); err == nil {
+		t.Fatalf("Missing manifest should be rejected if required")
+	}
+	if err := required.CheckPseManifest(attestation.IASReportBody{PseManifestStatus: PseManifestStatusOK, PseManifestHash: hash}); err != nil {
+		t.Fatalf("Valid manifest should be accepted: %s", err)
+	}
+	if err := optional.CheckPseManifest(attestation.IASReportBody{PseManifestStatus: PseManifestStatusRevoked, PseManifestHash: hash}); err == nil {
+		t.Fatalf("Revoked manifest should be rejected")
+	}
+	if err := required.CheckPseManifest(attestation.IASReportBody{PseManifestStatus: PseManifestStatusOutOfDate, PseManifestHash: hash}); err == nil {
+		t.Fatalf("Out of date manifest should be rejected")
+	}
+	if err := required.CheckPseManifest(attestation.IASReportBody{PseManifestStatus: PseManifestStatusOK, PseManifestHash: "xyz"}); err == nil {
+		t.Fatalf("Invalid manifest hash should be rejected")
+	}
+}
@@ -0,0 +1,537 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+// Object types of the composite keys under which ercc stores auxiliary records next to the attestation reports
+const (
+	RetiredObjectType   = "retired"
+	MinIsvSvnObjectType = "minIsvSvn"
+	QuoteObjectType     = "quote"
+	StatusObjectType    = "status"
+	AdvisoryObjectType  = "advisory"
+	// enclave identities and the index from enclave pk hash to enclave id
+	IdentityObjectType     = "identity"
+	IdentityByPkObjectType = "identityByPk"
+	// multi-party registration; enclaves with a pending registration must not endorse
+	QuorumObjectType  = "registrationQuorum"
+	PendingObjectType = "pending"
+	// per-org registration quota and usage
+	QuotaObjectType = "registrationQuota"
+	UsageObjectType = "registrationUsage"
+	// signature of the registrar over the stored attestation report
+	RegistrarSignatureObjectType = "registrarSignature"
+	// context a quote is bound to, see attestation.ReportDataFormat
+	BindingObjectType = "binding"
+	// requirements on platform services (PSE) of registered enclaves
+	PsePolicyObjectType = "psePolicy"
+	// registrations by EPID pseudonym, i.e., by physical platform
+	PlatformObjectType = "platform"
+	// attestation policy agreed on by the channel members
+	AttestationPolicyObjectType = "attestationPolicy"
+	// quotes of two-phase registrations waiting for their attestation report, and the evidence verifiers attached
+	SubmissionObjectType         = "quoteSubmission"
+	SubmissionEvidenceObjectType = "submissionEvidence"
+	// registrations by chaincode id and enclave id; the chaincode id is empty for quotes not bound to a chaincode
+	ChaincodeEnclaveObjectType = "chaincodeEnclave"
+	// what remains of registrations removed by pruneRegistry
+	TombstoneObjectType = "tombstone"
+	// verification collateral of registrations and the CRLs bundled into it
+	CollateralObjectType = "collateral"
+	CRLObjectType        = "crl"
+	// REPORT_DATA values of registered quotes, rejecting replays of a quote
+	ReplayObjectType = "usedReportData"
+	// latest Merkle commitment over the state of a chaincode
+	StateCommitmentObjectType = "stateCommitment"
+	// org that submitted a registration
+	RegistrantObjectType = "registrant"
+	// access control lists of ercc functions
+	ACLObjectType = "functionACL"
+	// keys attestation evidence is verified with, effective from a block height
+	TrustedRootObjectType = "trustedRoot"
+	// proxy re-encryption: approvals by mrenclave, re-encryption keys by delegator and delegatee enclave and the
+	// secrets re-encrypted for delegatee enclaves
+	DelegationObjectType      = "delegation"
+	ReEncryptionKeyObjectType = "reEncryptionKey"
+	DelegatedSecretObjectType = "delegatedSecret"
+
+	// threshold escrow of state keys: policies and escrows by chaincode id, recovery shares by chaincode id,
+	// recovering enclave and party
+	EscrowPolicyObjectType  = "escrowPolicy"
+	EscrowObjectType        = "escrow"
+	RecoveryShareObjectType = "recoveryShare"
+
+	// algorithms of registered enclave keys and the algorithms accepted by the channel
+	AlgorithmsObjectType      = "algorithms"
+	AlgorithmPolicyObjectType = "algorithmPolicy"
+
+	// attestation transcript of the channel by sequence number, and the transcripts of other channels imported
+	// into this one, e.g., an audit channel, by channel id and sequence number
+	TranscriptConfigObjectType       = "transcriptConfig"
+	TranscriptObjectType             = "transcript"
+	TranscriptHeadObjectType         = "transcriptHead"
+	ImportedTranscriptObjectType     = "importedTranscript"
+	ImportedTranscriptHeadObjectType = "importedTranscriptHead"
+
+	// channels enclaves may be imported from, and the imported enclaves by source channel and enclave pk hash
+	ForeignChannelsObjectType = "foreignChannels"
+	ForeignEnclaveObjectType  = "foreignEnclave"
+
+	// time of the channel committed by ercc, see LedgerClock
+	LedgerClockObjectType = "ledgerClock"
+
+	// secrets provisioned to enclaves, keys of client applications, and the upgrade approvals and state handovers
+	// between enclave binaries
+	SecretObjectType    = "secret"
+	ClientKeyObjectType = "clientKey"
+	UpgradeObjectType   = "upgrade"
+	HandoverObjectType  = "handover"
+)
+
+// Quote status values reported by IAS
+const (
+	QuoteStatusOK                  = "OK"
+	QuoteStatusSignatureInvalid    = "SIGNATURE_INVALID"
+	QuoteStatusGroupRevoked        = "GROUP_REVOKED"
+	QuoteStatusSignatureRevoked    = "SIGNATURE_REVOKED"
+	QuoteStatusKeyRevoked          = "KEY_REVOKED"
+	QuoteStatusGroupOutOfDate      = "GROUP_OUT_OF_DATE"
+	QuoteStatusConfigurationNeeded = "CONFIGURATION_NEEDED"
+)
+
+const compositeKeyNamespace = "\x00"
+
+// CompositeKey builds a composite key the same way as shim.CreateCompositeKey does. This allows components
+// without access to the shim, such as validation plugins, to look up ercc records.
+func CompositeKey(objectType string, attributes ...string) string {
+	key := compositeKeyNamespace + objectType + compositeKeyNamespace
+	for _, att := range attributes {
+		key += att + compositeKeyNamespace
+	}
+	return key
+}
+
+// IsCompositeKey returns true if the key is a composite key, that is, not the key of an attestation report
+func IsCompositeKey(key string) bool {
+	return strings.HasPrefix(key, compositeKeyNamespace)
+}
+
+// SplitCompositeKey returns the object type and the attributes of a composite key built by CompositeKey
+func SplitCompositeKey(key string) (string, []string, error) {
+	if !IsCompositeKey(key) || !strings.HasSuffix(key, compositeKeyNamespace) || len(key) < 2 {
+		return "", nil, fmt.Errorf("not a composite key: %q", key)
+	}
+	components := strings.Split(key[1:len(key)-1], compositeKeyNamespace)
+	return components[0], components[1:], nil
+}
+
+// MinIsvSvnPolicy defines the minimum ISV SVN enclaves of a chaincode must run. Enclaves with a lower
+// ISV SVN stop endorsing once the policy is enforced, that is, after the grace period.
+type MinIsvSvnPolicy struct {
+	ChaincodeName string `json:"ChaincodeName"`
+	MinIsvSvn     uint16 `json:"MinIsvSvn"`
+	// EnforcedFrom is the ledger time (unix seconds, see LedgerClock) at which the grace period ends
+	EnforcedFrom int64 `json:"EnforcedFrom"`
+}
+
+// IsEndorsing returns false if an enclave with the given ISV SVN must not endorse at the given ledger time
+func (p *MinIsvSvnPolicy) IsEndorsing(isvSvn uint16, now int64) bool {
+	if now < p.EnforcedFrom {
+		return true
+	}
+	return isvSvn >= p.MinIsvSvn
+}
+
+// EnclaveRecord is a registered enclave as listed by ercc
+type EnclaveRecord struct {
+	EnclavePkHash     string                           `json:"EnclavePkHash"`
+	AttestationReport attestation.IASAttestationReport `json:"AttestationReport"`
+	// Status of the last re-validation, if any
+	Status *EnclaveStatus `json:"Status,omitempty"`
+	// SuccessorPkHash is set if the enclave got retired by an upgrade
+	SuccessorPkHash string `json:"SuccessorPkHash,omitempty"`
+	// EnclaveID is the stable identity the key belongs to
+	EnclaveID string `json:"EnclaveID,omitempty"`
+	// RegistrarSignature over the attestation report, if the registrar signed it
+	RegistrarSignature *RegistrarSignature `json:"RegistrarSignature,omitempty"`
+	// Binding of the quote, if the enclave was registered with a bound quote
+	Binding *attestation.ReportDataBinding `json:"Binding,omitempty"`
+	// ExpiresAt is the unix time (seconds) at which the registration expires under the attestation policy, if any
+	ExpiresAt int64 `json:"ExpiresAt,omitempty"`
+	// Algorithms of the enclave key; registrations without are algorithm.Default
+	Algorithms *algorithm.Algorithms `json:"Algorithms,omitempty"`
+}
+
+// EnclavePkHash returns the key under which ercc stores the registration of the enclave
+// with the given public key (DER-encoded PKIX)
+func EnclavePkHash(enclavePk []byte) string {
+	h := sha256.Sum256(enclavePk)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// EnclavePage is a page of registered enclaves. Bookmark is empty if there are no more enclaves.
+type EnclavePage struct {
+	Enclaves            []EnclaveRecord `json:"Enclaves"`
+	Bookmark            string          `json:"Bookmark"`
+	FetchedRecordsCount int32           `json:"FetchedRecordsCount"`
+}
+
+// RegistrationQuorum is the number of orgs that must independently verify the attestation evidence of an
+// enclave before it becomes active
+type RegistrationQuorum struct {
+	RequiredOrgs int `json:"RequiredOrgs"`
+}
+
+// PendingRegistration is a registration waiting for confirmations of other orgs
+type PendingRegistration struct {
+	EnclavePkHash string `json:"EnclavePkHash"`
+	// ConfirmedBy lists the msp ids of the orgs that verified the attestation evidence, including the registrar
+	ConfirmedBy []string `json:"ConfirmedBy"`
+}
+
+// IsConfirmedBy returns true if the org with the given msp id confirmed the registration
+func (p *PendingRegistration) IsConfirmedBy(mspID string) bool {
+	return contains(p.ConfirmedBy, mspID)
+}
+
+// QuoteSubmission is the first phase of a two-phase registration: a quote waiting for its attestation report
+type QuoteSubmission struct {
+	EnclavePk []byte `json:"EnclavePk"`
+	Quote     []byte `json:"Quote"`
+	// Binding of the quote, if bound; it binds the submitting transaction
+	Binding *attestation.ReportDataBinding `json:"Binding,omitempty"`
+	// TxID of the submitting transaction
+	TxID        string `json:"TxID"`
+	SubmittedBy string `json:"SubmittedBy"`
+	// SubmittedAt is the unix time (seconds) of the submission
+	SubmittedAt int64 `json:"SubmittedAt"`
+}
+
+// IsStale returns true if the submission was not finalized within timeout seconds
+func (s *QuoteSubmission) IsStale(now, timeout int64) bool {
+	return now > s.SubmittedAt+timeout
+}
+
+// SubmissionEvidence is an attestation report for a submitted quote, attached by a verifier before finalization
+type SubmissionEvidence struct {
+	// TxID of the submission the evidence belongs to
+	SubmissionTxID    string                           `json:"SubmissionTxID"`
+	VerifiedBy        string                           `json:"VerifiedBy"`
+	AttachedAt        int64                            `json:"AttachedAt"`
+	AttestationReport attestation.IASAttestationReport `json:"AttestationReport"`
+}
+
+// RegistrationQuota limits the registrations of each org. Zero values mean unlimited.
+type RegistrationQuota struct {
+	// MaxEnclaves is the total number of registrations per org
+	MaxEnclaves int `json:"MaxEnclaves"`
+	// MaxPerWindow is the number of registrations per org within WindowSeconds
+	MaxPerWindow  int   `json:"MaxPerWindow"`
+	WindowSeconds int64 `json:"WindowSeconds"`
+}
+
+// RegistrationUsage counts the registrations of an org
+type RegistrationUsage struct {
+	MspID       string `json:"MspID"`
+	Total       int    `json:"Total"`
+	WindowStart int64  `json:"WindowStart"`
+	InWindow    int    `json:"InWindow"`
+}
+
+// Register counts a registration at time now (unix seconds) or returns an error if it exceeds the quota
+func (u *RegistrationUsage) Register(quota *RegistrationQuota, now int64) error {
+	if quota.MaxEnclaves > 0 && u.Total >= quota.MaxEnclaves {
+		return fmt.Errorf("registration quota of %d enclaves exceeded for %s", quota.MaxEnclaves, u.MspID)
+	}
+
+	// fixed window rate limit
+	if quota.WindowSeconds > 0 && now-u.WindowStart >= quota.WindowSeconds {
+		u.WindowStart = now
+		u.InWindow = 0
+	}
+	if quota.MaxPerWindow > 0 && u.InWindow >= quota.MaxPerWindow {
+		return fmt.Errorf("registration rate of %d per %ds exceeded for %s", quota.MaxPerWindow, quota.WindowSeconds, u.MspID)
+	}
+
+	u.Total++
+	u.InWindow++
+	return nil
+}
+
+// EnclaveIdentity is the stable identity of an enclave instance. Key rotation, renewal and audit history
+// refer to the enclave id rather than to the currently active key.
+type EnclaveIdentity struct {
+	EnclaveID    string        `json:"EnclaveID"`
+	MrEnclave    string        `json:"MrEnclave"`
+	PlatformID   string        `json:"PlatformID"`
+	ActivePkHash string        `json:"ActivePkHash"`
+	Keys         []IdentityKey `json:"Keys"`
+}
+
+// IdentityKey is a key an enclave identity used over time
+type IdentityKey struct {
+	PkHash string `json:"PkHash"`
+	// ActivatedAt is the unix time (seconds) at which the key became active
+	ActivatedAt int64 `json:"ActivatedAt"`
+}
+
+// NewEnclaveID derives the enclave id from mrenclave, platform id and an instance nonce
+func NewEnclaveID(mrEnclave, platformID, instanceNonce string) string {
+	h := sha256.New()
+	for _, s := range []string{mrEnclave, platformID, instanceNonce} {
+		// length prefix to avoid ambiguous concatenations
+		binary.Write(h, binary.BigEndian, uint32(len(s)))
+		h.Write([]byte(s))
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// MrEnclave returns the mrenclave (base64) of the enclave that produced the quote
+func MrEnclave(quote attestation.EnclaveQuote) string {
+	return base64.StdEncoding.EncodeToString(quote.MrEnclave[:])
+}
+
+// PlatformID returns an identifier (base64) of the platform that produced the quote. This is the EPID
+// pseudonym if IAS returned one (linkable quotes), otherwise the EPID group id.
+func PlatformID(quote attestation.EnclaveQuote, reportBody attestation.IASReportBody) string {
+	if reportBody.EpidPseudonym != "" {
+		return reportBody.EpidPseudonym
+	}
+	return base64.StdEncoding.EncodeToString(quote.EPIDGroupID[:])
+}
+
+// IsvSvn returns the ISV SVN of the enclave that produced the quote
+func IsvSvn(quote attestation.EnclaveQuote) uint16 {
+	return binary.LittleEndian.Uint16(quote.ISVSVN[:])
+}
+
+// MrSigner returns the mrsigner (base64), i.e., the hash of the signing key, of the enclave that produced the quote
+func MrSigner(quote attestation.EnclaveQuote) string {
+	return base64.StdEncoding.EncodeToString(quote.MrSigner[:])
+}
+
+// IsvProdID returns the ISV product id of the enclave that produced the quote
+func IsvProdID(quote attestation.EnclaveQuote) uint16 {
+	return binary.LittleEndian.Uint16(quote.ISVProdID[:])
+}
+
+// IsvExtProdID returns the ISV extended product id (base64) of the enclave that produced the quote
+func IsvExtProdID(quote attestation.EnclaveQuote) string {
+	id := quote.IsvExtProdID()
+	return base64.StdEncoding.EncodeToString(id[:])
+}
+
+// IsvFamilyID returns the ISV family id (base64) of the enclave that produced the quote
+func IsvFamilyID(quote attestation.EnclaveQuote) string {
+	id := quote.IsvFamilyID()
+	return base64.StdEncoding.EncodeToString(id[:])
+}
+
+// ConfigID returns the config id (base64) the enclave that produced the quote was launched with
+func ConfigID(quote attestation.EnclaveQuote) string {
+	id := quote.ConfigID()
+	return base64.StdEncoding.EncodeToString(id[:])
+}
+
+// EnclaveStatus is the platform status of a registered enclave as reported by IAS at the last re-validation
+type EnclaveStatus struct {
+	QuoteStatus string `json:"QuoteStatus"`
+	// AdvisoryIDs lists the Intel security advisories affecting the platform
+	AdvisoryIDs []string `json:"AdvisoryIDs,omitempty"`
+	// FlaggedBy and RevokedBy list the advisories that flagged or revoked the enclave
+	FlaggedBy []string `json:"FlaggedBy,omitempty"`
+	RevokedBy []string `json:"RevokedBy,omitempty"`
+	// CheckedAt is the unix time (seconds) of the re-validation
+	CheckedAt int64 `json:"CheckedAt"`
+	// RevokedAt is the unix time (seconds) at which ercc first found the enclave revoked
+	RevokedAt int64 `json:"RevokedAt,omitempty"`
+}
+
+// IsRevoked returns true if the platform of the enclave can no longer be trusted
+func (s *EnclaveStatus) IsRevoked() bool {
+	return s.IsQuoteRevoked() || len(s.RevokedBy) > 0
+}
+
+// IsQuoteRevoked returns true if IAS reported the quote status as revoked
+func (s *EnclaveStatus) IsQuoteRevoked() bool {
+	switch s.QuoteStatus {
+	case QuoteStatusSignatureInvalid, QuoteStatusGroupRevoked, QuoteStatusSignatureRevoked, QuoteStatusKeyRevoked:
+		return true
+	}
+	return false
+}
+
+// Apply flags or revokes the enclave if its platform is affected by the advisory.
+// Returns true if the status changed.
+func (s *EnclaveStatus) Apply(advisory *Advisory) bool {
+	if !contains(s.AdvisoryIDs, advisory.ID) {
+		return false
+	}
+
+	switch advisory.Action {
+	case AdvisoryActionFlag:
+		if contains(s.FlaggedBy, advisory.ID) {
+			return false
+		}
+		s.FlaggedBy = append(s.FlaggedBy, advisory.ID)
+	case AdvisoryActionRevoke:
+		if contains(s.RevokedBy, advisory.ID) {
+			return false
+		}
+		s.RevokedBy = append(s.RevokedBy, advisory.ID)
+	default:
+		return false
+	}
+	return true
+}
+
+// Actions taken on enclaves affected by an advisory
+const (
+	AdvisoryActionFlag   = "flag"
+	AdvisoryActionRevoke = "revoke"
+)
+
+// Advisory is an Intel security advisory as ingested by governance into ercc
+type Advisory struct {
+	ID          string `json:"ID"`
+	Action      string `json:"Action"`
+	Description string `json:"Description"`
+}
+
+// TrustChangedEventName is the name of the chaincode event ercc emits when enclaves get flagged or revoked
+const TrustChangedEventName = "trustChanged"
+
+// TrustChange is the payload entry of a trust changed event and tells operators why an enclave lost trust
+type TrustChange struct {
+	EnclavePkHash string `json:"EnclavePkHash"`
+	AdvisoryID    string `json:"AdvisoryID"`
+	Action        string `json:"Action"`
+	Description   string `json:"Description"`
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PlatformEnclaves lists the enclaves registered from one physical platform as identified by the EPID pseudonym
+// of linkable quotes
+type PlatformEnclaves struct {
+	EpidPseudonym   string   `json:"EpidPseudonym"`
+	EnclavePkHashes []string `json:"EnclavePkHashes"`
+}
+
+// ChaincodeEnclave is the registration of an enclave for a chaincode
+type ChaincodeEnclave struct {
+	ChaincodeID   string `json:"ChaincodeID"`
+	EnclaveID     string `json:"EnclaveID"`
+	EnclavePkHash string `json:"EnclavePkHash"`
+}
+
+// ChaincodeEnclaves lists the enclaves registered for a chaincode
+type ChaincodeEnclaves struct {
+	ChaincodeID string             `json:"ChaincodeID"`
+	Enclaves    []ChaincodeEnclave `json:"Enclaves"`
+}
+
+// Reasons for pruning a registration
+const (
+	PruneReasonExpired = "expired"
+	PruneReasonRevoked = "revoked"
+)
+
+// RegistryPrunedEventName is the name of the chaincode event ercc emits when it prunes registrations
+const RegistryPrunedEventName = "registryPruned"
+
+// Tombstone replaces a registration removed by pruneRegistry. It is also the payload entry of a registry
+// pruned event.
+type Tombstone struct {
+	EnclavePkHash string `json:"EnclavePkHash"`
+	EnclaveID     string `json:"EnclaveID,omitempty"`
+	Reason        string `json:"Reason"`
+	// PrunedAt is the unix time (seconds) of the pruning transaction
+	PrunedAt int64  `json:"PrunedAt"`
+	TxID     string `json:"TxID"`
+}
+
+// ReplayEntry records the registration that used the REPORT_DATA of a quote. While the entry is kept, a quote
+// with the same REPORT_DATA is accepted for the renewal of this registration only.
+type ReplayEntry struct {
+	EnclavePkHash string `json:"EnclavePkHash"`
+	TxID          string `json:"TxID"`
+	// UsedAt is the unix time (seconds) of the registration
+	UsedAt int64 `json:"UsedAt"`
+	// ExpiresAt is the unix time (seconds) from which the entry may be pruned, or 0 if it is kept forever
+	ExpiresAt int64 `json:"ExpiresAt,omitempty"`
+}
+
+// IsExpired returns true if the entry expired at unix time now
+func (e *ReplayEntry) IsExpired(now int64) bool {
+	return e.ExpiresAt != 0 && now >= e.ExpiresAt
+}
+
+// StateCommitment is the Merkle root over the key/value space of a chaincode at the time of publication, see
+// crypto.MerkleTree. Values are the ciphertexts as stored on the ledger.
+type StateCommitment struct {
+	ChaincodeName string `json:"ChaincodeName"`
+	// Root is the base64 encoded Merkle root
+	Root string `json:"Root"`
+	// Size is the number of keys
+	Size int `json:"Size"`
+	// Sequence counts the commitments published for the chaincode, starting at 1
+	Sequence uint64 `json:"Sequence"`
+	TxID     string `json:"TxID"`
+	// CommittedAt is the unix time (seconds) of the publication
+	CommittedAt int64 `json:"CommittedAt"`
+}
+
+// RegistryStats aggregates the registrations in ercc, see getRegistryStats. Every registration is counted in exactly
+// one of Active, Pending, Expired, Revoked and Retired.
+type RegistryStats struct {
+	Total  int `json:"Total"`
+	Active int `json:"Active"`
+	// Pending counts registrations frozen until a registration quorum confirmed them
+	Pending int `json:"Pending"`
+	Expired int `json:"Expired"`
+	Revoked int `json:"Revoked"`
+	// Retired counts registrations replaced by an upgrade
+	Retired int `json:"Retired"`
+	// PerOrg counts registrations by the MSP id of the submitting org. Registrations made before the registrant was
+	// recorded are counted in Unattributed.
+	PerOrg       map[string]int `json:"PerOrg"`
+	Unattributed int            `json:"Unattributed,omitempty"`
+	// OldestAttestation is the unix time (seconds) of the oldest attestation report of an active enclave and
+	// OldestAttestationAge its age at the time of the query; both are 0 if there is no active enclave
+	OldestAttestation    int64 `json:"OldestAttestation,omitempty"`
+	OldestAttestationAge int64 `json:"OldestAttestationAge,omitempty"`
+	// ComputedAt is the unix time (seconds) of the query
+	ComputedAt int64 `json:"ComputedAt"`
+}
@@ -0,0 +1,115 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"testing"
+)
+
+func TestCompositeKey(t *testing.T) {
+	if key := CompositeKey(RetiredObjectType, "pkHash"); key != "\x00retired\x00pkHash\x00" {
+		t.Fatalf("Unexpected composite key %q", key)
+	}
+}
+
+func TestSplitCompositeKey(t *testing.T) {
+	objectType, attributes, err := SplitCompositeKey(CompositeKey(SecretObjectType, "pkHash", "apiKey"))
+	if err != nil {
+		t.Fatalf("Can not split composite key: %s", err)
+	}
+	if objectType != SecretObjectType || len(attributes) != 2 || attributes[0] != "pkHash" || attributes[1] != "apiKey" {
+		t.Fatalf("Unexpected object type %s and attributes %v", objectType, attributes)
+	}
+	if _, _, err := SplitCompositeKey("pkHash"); err == nil {
+		t.Fatalf("Splitting a simple key should fail")
+	}
+}
+
+func TestEnclavePkHash(t *testing.T) {
+	// sha256 of the empty string
+	if h := EnclavePkHash([]byte{}); h != "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=" {
+		t.Fatalf("Unexpected pk hash %s", h)
+	}
+}
+
+func TestMinIsvSvnPolicy_IsEndorsing(t *testing.T) {
+	policy := &MinIsvSvnPolicy{ChaincodeName: "ecc", MinIsvSvn: 2, EnforcedFrom: 100}
+
+	// grace period
+	if !policy.IsEndorsing(1, 99) {
+		t.Fatalf("Enclave should endorse during grace period")
+	}
+
+	if policy.IsEndorsing(1, 100) {
+		t.Fatalf("Enclave below minimum isv svn should not endorse")
+	}
+
+	if !policy.IsEndorsing(2, 100) {
+		t.Fatalf("Enclave with minimum isv svn should endorse")
+	}
+}
+
+func TestEnclaveStatus_IsRevoked(t *testing.T) {
+	if (&EnclaveStatus{QuoteStatus: QuoteStatusGroupOutOfDate}).IsRevoked() {
+		t.Fatalf("Out of date platform should not be revoked")
+	}
+	if !(&EnclaveStatus{QuoteStatus: QuoteStatusGroupRevoked}).IsRevoked() {
+		t.Fatalf("Revoked platform should be revoked")
+	}
+}
+
+func TestEnclaveStatus_Apply(t *testing.T) {
+	status := &EnclaveStatus{QuoteStatus: QuoteStatusGroupOutOfDate, AdvisoryIDs: []string{"INTEL-SA-00161"}}
+
+	if status.Apply(&Advisory{ID: "INTEL-SA-00233", Action: AdvisoryActionRevoke}) {
+		t.Fatalf("Unrelated advisory should not change status")
+	}
+
+	if !status.Apply(&Advisory{ID: "INTEL-SA-00161", Action: AdvisoryActionFlag}) || status.IsRevoked() {
+		t.Fatalf("Advisory should flag enclave only")
+	}
+
+	if !status.Apply(&Advisory{ID: "INTEL-SA-00161", Action: AdvisoryActionRevoke}) || !status.IsRevoked() {
+		t.Fatalf("Advisory should revoke enclave")
+	}
+
+	if status.Apply(&Advisory{ID: "INTEL-SA-00161", Action: AdvisoryActionRevoke}) {
+		t.Fatalf("Applying advisory twice should not change status")
+	}
+}
+
+func TestRegistrationUsage_Register(t *testing.T) {
+	quota := &RegistrationQuota{MaxEnclaves: 3, MaxPerWindow: 2, WindowSeconds: 60}
+	usage := &RegistrationUsage{MspID: "Org1MSP"}
+
+	for i := 0; i < 2; i++ {
+		if err := usage.Register(quota, 100); err != nil {
+			t.Fatalf("Registration %d should be admitted: %s", i, err)
+		}
+	}
+	if err := usage.Register(quota, 159); err == nil {
+		t.Fatalf("Registration should exceed rate limit")
+	}
+
+	// next window
+	if err := usage.Register(quota, 160); err != nil {
+		t.Fatalf("Registration should be admitted in new window: %s", err)
+	}
+	if err := usage.Register(quota, 300); err == nil {
+		t.Fatalf("Registration should exceed total quota")
+	}
+}
@@ -0,0 +1,128 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+// TrustedRoot is a key attestation evidence is verified with, e.g., the IAS report signing key, added by the admins
+// of ercc. Roots take effect at a block height and may be retired at a later one, so that a vendor rotating its
+// keys does not require a new ercc binary and all peers switch at the same point of the ledger.
+type TrustedRoot struct {
+	// ID is the hex-encoded SHA256 hash of the DER-encoded public key, see TrustedRootID
+	ID string `json:"ID"`
+	// Vendor names the attestation service the root belongs to, e.g., intel
+	Vendor string `json:"Vendor"`
+	// PEM is the public key or a certificate of the public key
+	PEM string `json:"PEM"`
+	// EffectiveFrom is the first block height the root is trusted at
+	EffectiveFrom uint64 `json:"EffectiveFrom"`
+	// RetiredFrom is the first block height the root is no longer trusted at; 0 if not retired
+	RetiredFrom uint64 `json:"RetiredFrom,omitempty"`
+}
+
+// PublicKey returns the public key of the root, which is given either as PUBLIC KEY or as CERTIFICATE
+func (r *TrustedRoot) PublicKey() (interface{}, error) {
+	block, _ := pem.Decode([]byte(r.PEM))
+	if block == nil {
+		return nil, fmt.Errorf("No PEM block in trusted root")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("Unexpected PEM block %s in trusted root", block.Type)
+	}
+}
+
+// TrustedRootID returns the ID of the root with the given public key
+func TrustedRootID(publicKey interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Validate returns an error if the root has no vendor, no valid key, an ID not matching the key or is retired
+// before it takes effect
+func (r *TrustedRoot) Validate() error {
+	if r.Vendor == "" {
+		return fmt.Errorf("Trusted root has no vendor")
+	}
+	publicKey, err := r.PublicKey()
+	if err != nil {
+		return fmt.Errorf("Invalid trusted root: %s", err)
+	}
+	id, err := TrustedRootID(publicKey)
+	if err != nil {
+		return fmt.Errorf("Invalid trusted root: %s", err)
+	}
+	if r.ID != id {
+		return fmt.Errorf("Trusted root ID %s does not match its key", r.ID)
+	}
+	if r.RetiredFrom != 0 && r.RetiredFrom <= r.EffectiveFrom {
+		return fmt.Errorf("Trusted root retired at block %d before it takes effect at block %d", r.RetiredFrom, r.EffectiveFrom)
+	}
+	return nil
+}
+
+// EffectiveAt returns true if the root is trusted at the given block height
+func (r *TrustedRoot) EffectiveAt(height uint64) bool {
+	return height >= r.EffectiveFrom && (r.RetiredFrom == 0 || height < r.RetiredFrom)
+}
+
+// VerifyReport verifies report with the roots effective at the given block height and returns the key that
+// verified it. Both ercc, at the height of the ledger when endorsing, and the ercc vscc, at the height of the block
+// being validated, use it, so that a registration is only committed if a root was trusted at its block.
+func VerifyReport(verifier attestation.Verifier, roots []TrustedRoot, height uint64, report attestation.IASAttestationReport) (interface{}, error) {
+	var lastErr error
+	for _, root := range roots {
+		if !root.EffectiveAt(height) {
+			continue
+		}
+		publicKey, err := root.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		isValid, err := verifier.VerifyAttestionReport(publicKey, report)
+		if err != nil {
+			lastErr = err
+		} else if isValid {
+			return publicKey, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, errors.New("Error while attestation report verification: " + lastErr.Error())
+	}
+	return nil, fmt.Errorf("Attestation report is not valid with the trusted roots at block %d", height)
+}
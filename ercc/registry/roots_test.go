@@ -0,0 +1,61 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+func TestTrustedRoot(t *testing.T) {
+	root := &TrustedRoot{Vendor: "intel", PEM: attestation.IntelPubPEM, EffectiveFrom: 10}
+	if err := root.Validate(); err == nil {
+		t.Fatalf("Root without ID should be invalid")
+	}
+
+	publicKey, err := root.PublicKey()
+	if err != nil {
+		t.Fatalf("Can not parse root: %s", err)
+	}
+	root.ID, err = TrustedRootID(publicKey)
+	if err != nil {
+		t.Fatalf("Can not compute root ID: %s", err)
+	}
+	if err := root.Validate(); err != nil {
+		t.Fatalf("Root should be valid: %s", err)
+	}
+
+	if root.EffectiveAt(9) || !root.EffectiveAt(10) || !root.EffectiveAt(1000) {
+		t.Fatalf("Root should be effective from block 10")
+	}
+	root.RetiredFrom = 20
+	if err := root.Validate(); err != nil {
+		t.Fatalf("Retired root should be valid: %s", err)
+	}
+	if !root.EffectiveAt(19) || root.EffectiveAt(20) {
+		t.Fatalf("Root should be retired from block 20")
+	}
+	root.RetiredFrom = 10
+	if err := root.Validate(); err == nil {
+		t.Fatalf("Root retired before it takes effect should be invalid")
+	}
+
+	if _, err := (&TrustedRoot{Vendor: "intel", PEM: "not a key"}).PublicKey(); err == nil {
+		t.Fatalf("Root without PEM should be invalid")
+	}
+}
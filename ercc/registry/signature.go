@@ -0,0 +1,95 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+)
+
+// RegistrarSignature is the signature of the registrar over the digest of a stored attestation report.
+// It allows readers to detect tampering with the evidence independent of the integrity of the state.
+type RegistrarSignature struct {
+	MspID string `json:"MspID"`
+	// Certificate (PEM) of the registrar identity
+	Certificate []byte `json:"Certificate"`
+	// Digest is the ReportDigest of the attestation report
+	Digest []byte `json:"Digest"`
+	// Signature is an ASN.1 encoded ECDSA signature over Digest
+	Signature []byte `json:"Signature"`
+}
+
+// ecdsaSignature is the ASN.1 encoding of an ECDSA signature. It is decoded here rather than with ecc/crypto to keep
+// the registry free of chaincode dependencies.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// ReportDigest returns the sha256 digest over the canonical encoding of an attestation report
+func ReportDigest(report attestation.IASAttestationReport) ([]byte, error) {
+	reportAsBytes, err := MarshalCanonical(report)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(reportAsBytes)
+	return digest[:], nil
+}
+
+// Verify checks that the signature covers the given attestation report and was created with the key
+// of the certificate. Note that the certificate itself must be validated against the MSP of the registrar.
+func (s *RegistrarSignature) Verify(report attestation.IASAttestationReport) error {
+	digest, err := ReportDigest(report)
+	if err != nil {
+		return err
+	}
+	if !consttime.Equal(digest, s.Digest) {
+		return errors.New("attestation report does not match signed digest")
+	}
+
+	block, _ := pem.Decode(s.Certificate)
+	if block == nil {
+		return errors.New("registrar certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("registrar key is not ecdsa key")
+	}
+
+	var sig ecdsaSignature
+	if rest, err := asn1.Unmarshal(s.Signature, &sig); err != nil || len(rest) != 0 {
+		return errors.New("registrar signature is not ASN.1 encoded")
+	}
+	if sig.R == nil || sig.S == nil || sig.R.Sign() != 1 || sig.S.Sign() != 1 {
+		return errors.New("invalid registrar signature")
+	}
+	if !ecdsa.Verify(pub, s.Digest, sig.R, sig.S) {
+		return errors.New("invalid registrar signature")
+	}
+	return nil
+}
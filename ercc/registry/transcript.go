@@ -0,0 +1,108 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+)
+
+// Kinds of attestation transcript entries
+const (
+	TranscriptRegistration = "registration"
+	TranscriptRenewal      = "renewal"
+	TranscriptRevocation   = "revocation"
+)
+
+// TranscriptConfig enables the attestation transcript of a channel. Entries are stored in the public state of ercc
+// unless Collection names a private data collection, e.g., one only the auditors are members of.
+type TranscriptConfig struct {
+	Enabled    bool   `json:"Enabled"`
+	Collection string `json:"Collection,omitempty"`
+}
+
+// TranscriptEntry records a registration, renewal or revocation of an enclave. Entries of a channel form a hash
+// chain: every entry names the hash of its predecessor, so an auditor holding the head detects any entry altered,
+// dropped or inserted.
+type TranscriptEntry struct {
+	ChannelID     string `json:"ChannelID"`
+	Seq           uint64 `json:"Seq"`
+	Kind          string `json:"Kind"`
+	EnclavePkHash string `json:"EnclavePkHash"`
+	EnclaveID     string `json:"EnclaveID,omitempty"`
+	// EvidenceDigest is the SHA-256 (base64) of the stored attestation report of registrations and renewals
+	EvidenceDigest string `json:"EvidenceDigest,omitempty"`
+	// AdvisoryID and Description tell why an enclave got revoked
+	AdvisoryID  string `json:"AdvisoryID,omitempty"`
+	Description string `json:"Description,omitempty"`
+	TxID        string `json:"TxID"`
+	// Timestamp is the unix time (seconds) of the transaction
+	Timestamp int64 `json:"Timestamp"`
+	// PrevHash is the hash of the previous entry, empty for the first entry
+	PrevHash string `json:"PrevHash,omitempty"`
+}
+
+// Hash returns the SHA-256 (base64) of the canonical encoding of the entry
+func (e *TranscriptEntry) Hash() (string, error) {
+	entryAsBytes, err := MarshalCanonical(e)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(entryAsBytes)
+	return base64.StdEncoding.EncodeToString(h[:]), nil
+}
+
+// TranscriptHead is the end of the transcript of a channel: Seq is the number of entries, Hash the hash of the
+// last entry
+type TranscriptHead struct {
+	ChannelID string `json:"ChannelID"`
+	Seq       uint64 `json:"Seq"`
+	Hash      string `json:"Hash,omitempty"`
+}
+
+// Append returns the head of the transcript extended by e, or an error if e does not directly follow the head
+func (h *TranscriptHead) Append(e *TranscriptEntry) (*TranscriptHead, error) {
+	if e.ChannelID != h.ChannelID {
+		return nil, fmt.Errorf("entry of channel %s does not belong to the transcript of channel %s", e.ChannelID, h.ChannelID)
+	}
+	if e.Seq != h.Seq {
+		return nil, fmt.Errorf("expected entry %d but got %d", h.Seq, e.Seq)
+	}
+	if !consttime.EqualString(e.PrevHash, h.Hash) {
+		return nil, fmt.Errorf("entry %d does not follow the previous entry", e.Seq)
+	}
+	hash, err := e.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return &TranscriptHead{ChannelID: h.ChannelID, Seq: h.Seq + 1, Hash: hash}, nil
+}
+
+// TranscriptPage is a page of transcript entries along with the current head
+type TranscriptPage struct {
+	Entries []TranscriptEntry `json:"Entries"`
+	Head    TranscriptHead    `json:"Head"`
+}
+
+// TranscriptKey returns the composite key attribute of the entry with the given sequence number; it is padded such
+// that keys sort by sequence number
+func TranscriptKey(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
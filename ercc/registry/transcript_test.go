@@ -0,0 +1,57 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"testing"
+)
+
+func TestTranscriptHead_Append(t *testing.T) {
+	head := &TranscriptHead{ChannelID: "ch"}
+	first := &TranscriptEntry{ChannelID: "ch", Seq: 0, Kind: TranscriptRegistration, EnclavePkHash: "pk1"}
+	head, err := head.Append(first)
+	if err != nil {
+		t.Fatalf("First entry should be appended: %s", err)
+	}
+	if hash, _ := first.Hash(); head.Seq != 1 || head.Hash != hash {
+		t.Fatalf("Unexpected head %+v", head)
+	}
+
+	second := &TranscriptEntry{ChannelID: "ch", Seq: 1, Kind: TranscriptRevocation, EnclavePkHash: "pk1", PrevHash: head.Hash}
+	for _, invalid := range []TranscriptEntry{
+		{ChannelID: "other", Seq: 1, PrevHash: head.Hash},
+		{ChannelID: "ch", Seq: 2, PrevHash: head.Hash},
+		{ChannelID: "ch", Seq: 1, PrevHash: "forged"},
+	} {
+		if _, err := head.Append(&invalid); err == nil {
+			t.Fatalf("Entry %+v should not be appended", invalid)
+		}
+	}
+	if _, err := head.Append(second); err != nil {
+		t.Fatalf("Second entry should be appended: %s", err)
+	}
+
+	// any change to an entry changes its hash and thus breaks the chain
+	oldHash, _ := first.Hash()
+	first.Description = "rewritten"
+	if newHash, _ := first.Hash(); newHash == oldHash {
+		t.Fatalf("Hash should cover all fields")
+	}
+	if TranscriptKey(2) >= TranscriptKey(10) {
+		t.Fatalf("Keys should sort by sequence number")
+	}
+}
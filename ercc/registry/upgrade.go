@@ -0,0 +1,127 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"errors"
+)
+
+// HandoverMessage returns what a retiring enclave signs when handing over its state key to a successor: the
+// ephemeral pk and the ciphertext of the state key, followed by the pk of the successor, all pks in sgx format
+// (x || y, big endian). The signature binds the state key to both enclaves, so a peer can neither substitute the
+// key nor pass it to another enclave.
+func HandoverMessage(ephemeralPk, ciphertext, successorPk []byte) []byte {
+	message := append([]byte{}, ephemeralPk...)
+	message = append(message, ciphertext...)
+	return append(message, successorPk...)
+}
+
+// VerifyHandover checks the signature (r || s, big endian) of the enclave with predecessorPk over the handover of
+// its state key to the enclave with successorPk, both DER-encoded PKIX P-256 keys, see HandoverMessage
+func VerifyHandover(predecessorPk, successorPk, ephemeralPk, ciphertext, signature []byte) error {
+	predecessor, err := parseP256Pk(predecessorPk)
+	if err != nil {
+		return err
+	}
+	successor, err := parseP256Pk(successorPk)
+	if err != nil {
+		return err
+	}
+
+	if !verifyRawSignature(predecessor, HandoverMessage(ephemeralPk, ciphertext, sgxPk(successor)), signature) {
+		return errors.New("invalid handover signature")
+	}
+	return nil
+}
+
+// rotationMessage prefixes the new key in RotationMessage, separating it from handover messages, which start with a
+// random ephemeral pk
+const rotationMessage = "fpc.rotation.key\n"
+
+// RotationMessage returns what the active key of an enclave identity signs to endorse a new key of the same enclave
+// as its successor: the new pk in sgx format (x || y, big endian), prefixed to separate it from other messages
+func RotationMessage(newPk []byte) []byte {
+	return append([]byte(rotationMessage), newPk...)
+}
+
+// VerifyRotation checks the signature (r || s, big endian) of the enclave with activePk over the rotation to the
+// enclave with newPk, both DER-encoded PKIX P-256 keys, see RotationMessage
+func VerifyRotation(activePk, newPk, signature []byte) error {
+	active, err := parseP256Pk(activePk)
+	if err != nil {
+		return err
+	}
+	successor, err := parseP256Pk(newPk)
+	if err != nil {
+		return err
+	}
+
+	if !verifyRawSignature(active, RotationMessage(sgxPk(successor)), signature) {
+		return errors.New("invalid rotation signature")
+	}
+	return nil
+}
+
+// renewalMessage prefixes the transaction id and quote in RenewalMessage
+const renewalMessage = "fpc.renewal.quote\n"
+
+// RenewalMessage returns what an enclave signs to renew its registration with fresh evidence: the id of the renewal
+// transaction and the new quote, prefixed to separate it from other messages. The transaction id keeps the
+// signature from being replayed to roll the registration back to earlier evidence.
+func RenewalMessage(txID string, quote []byte) []byte {
+	message := append([]byte(renewalMessage), txID...)
+	message = append(message, '\n')
+	return append(message, quote...)
+}
+
+// VerifyRenewal checks the signature (r || s, big endian) of the enclave with the DER-encoded PKIX P-256 key
+// enclavePk over the renewal of its registration with quote in transaction txID, see RenewalMessage
+func VerifyRenewal(enclavePk []byte, txID string, quote, signature []byte) error {
+	pub, err := parseP256Pk(enclavePk)
+	if err != nil {
+		return err
+	}
+
+	if !verifyRawSignature(pub, RenewalMessage(txID, quote), signature) {
+		return errors.New("invalid renewal signature")
+	}
+	return nil
+}
+
+// parseP256Pk parses a DER-encoded PKIX P-256 key
+func parseP256Pk(der []byte) (*ecdsa.PublicKey, error) {
+	pk, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := pk.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, errors.New("enclave key is not a P-256 key")
+	}
+	return pub, nil
+}
+
+// sgxPk returns the coordinates of a P-256 key as enclaves use them, i.e., x || y, 32 bytes each, big endian
+func sgxPk(pub *ecdsa.PublicKey) []byte {
+	pk := make([]byte, 64)
+	pub.X.FillBytes(pk[:32])
+	pub.Y.FillBytes(pk[32:])
+	return pk
+}
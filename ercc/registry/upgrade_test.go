@@ -0,0 +1,118 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+)
+
+func TestVerifyHandover(t *testing.T) {
+	predecessorSk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	successorSk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	predecessorPk, _ := x509.MarshalPKIXPublicKey(&predecessorSk.PublicKey)
+	successorPk, _ := x509.MarshalPKIXPublicKey(&successorSk.PublicKey)
+
+	successorSgxPk := make([]byte, 64)
+	successorSk.X.FillBytes(successorSgxPk[:32])
+	successorSk.Y.FillBytes(successorSgxPk[32:])
+	ephemeralPk, ciphertext := make([]byte, 64), []byte("ciphertext")
+	digest := sha256.Sum256(HandoverMessage(ephemeralPk, ciphertext, successorSgxPk))
+	r, s, err := ecdsa.Sign(rand.Reader, predecessorSk, digest[:])
+	if err != nil {
+		t.Fatalf("Can not sign handover: %s", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	if err := VerifyHandover(predecessorPk, successorPk, ephemeralPk, ciphertext, signature); err != nil {
+		t.Fatalf("Handover should be valid: %s", err)
+	}
+	// the signature binds the successor and the state key
+	if err := VerifyHandover(predecessorPk, predecessorPk, ephemeralPk, ciphertext, signature); err == nil {
+		t.Fatalf("Handover to another enclave should be invalid")
+	}
+	if err := VerifyHandover(predecessorPk, successorPk, ephemeralPk, []byte("other"), signature); err == nil {
+		t.Fatalf("Handover of another key should be invalid")
+	}
+	if err := VerifyHandover(successorPk, successorPk, ephemeralPk, ciphertext, signature); err == nil {
+		t.Fatalf("Handover signed by another enclave should be invalid")
+	}
+}
+
+func TestVerifyRotation(t *testing.T) {
+	activeSk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newSk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	activePk, _ := x509.MarshalPKIXPublicKey(&activeSk.PublicKey)
+	newPk, _ := x509.MarshalPKIXPublicKey(&newSk.PublicKey)
+
+	digest := sha256.Sum256(RotationMessage(sgxPk(&newSk.PublicKey)))
+	r, s, err := ecdsa.Sign(rand.Reader, activeSk, digest[:])
+	if err != nil {
+		t.Fatalf("Can not sign rotation: %s", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	if err := VerifyRotation(activePk, newPk, signature); err != nil {
+		t.Fatalf("Rotation should be valid: %s", err)
+	}
+	// the signature binds the new key and must come from the active key
+	if err := VerifyRotation(activePk, activePk, signature); err == nil {
+		t.Fatalf("Rotation to another key should be invalid")
+	}
+	if err := VerifyRotation(newPk, newPk, signature); err == nil {
+		t.Fatalf("Rotation signed by another enclave should be invalid")
+	}
+}
+
+func TestVerifyRenewal(t *testing.T) {
+	sk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherSk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pk, _ := x509.MarshalPKIXPublicKey(&sk.PublicKey)
+	otherPk, _ := x509.MarshalPKIXPublicKey(&otherSk.PublicKey)
+	quote := []byte("quote")
+
+	digest := sha256.Sum256(RenewalMessage("tx1", quote))
+	r, s, err := ecdsa.Sign(rand.Reader, sk, digest[:])
+	if err != nil {
+		t.Fatalf("Can not sign renewal: %s", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	if err := VerifyRenewal(pk, "tx1", quote, signature); err != nil {
+		t.Fatalf("Renewal should be valid: %s", err)
+	}
+	// the signature binds the transaction and the quote and must come from the renewed key
+	if err := VerifyRenewal(pk, "tx2", quote, signature); err == nil {
+		t.Fatalf("Renewal in another transaction should be invalid")
+	}
+	if err := VerifyRenewal(pk, "tx1", []byte("other quote"), signature); err == nil {
+		t.Fatalf("Renewal with another quote should be invalid")
+	}
+	if err := VerifyRenewal(otherPk, "tx1", quote, signature); err == nil {
+		t.Fatalf("Renewal signed by another enclave should be invalid")
+	}
+}
@@ -0,0 +1,326 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// renewEnclave -
+// ============================================================
+func (ercc *EnclaveRegistryCC) renewEnclave(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkBase64 (registered before)
+	// 1: quoteBase64
+	// 2: attestationReport (json encoded attestation.IASAttestationReport; if empty, ercc requests it from IAS)
+	// 3: chaincodeID[:version] (if not empty, the quote binds this transaction, channel and chaincode)
+	// 4: signatureBase64 (of the enclave over registry.RenewalMessage of this transaction and the quote)
+	// 5: certPem
+	// 6: keyPem
+	// if certPem and keyPem not available as argument we try to read them from decorator
+	if len(args) < 5 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk, quote, attestation report, chaincode id and signature")
+	}
+
+	enclavePkAsBytes, err := base64.StdEncoding.DecodeString(args[0])
+	if err != nil {
+		return shim.Error("Can not parse enclavePkBase64: " + err.Error())
+	}
+	quoteAsBytes, err := decodeQuote(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// only the enclave itself may replace its evidence
+	signature, err := base64.StdEncoding.DecodeString(args[4])
+	if err != nil {
+		return shim.Error("Can not decode renewal signature: " + err.Error())
+	}
+	if err := registry.VerifyRenewal(enclavePkAsBytes, stub.GetTxID(), quoteAsBytes, signature); err != nil {
+		return shim.Error("Renewal is not signed by the enclave: " + err.Error())
+	}
+
+	attestationReport := attestation.IASAttestationReport{}
+	if args[2] == "" {
+		cert, err := ercc.getIASClientCert(stub, args[5:])
+		if err != nil {
+			return shim.Error("Can not load client cert: " + err.Error())
+		}
+		attestationReport, err = ercc.ias.RequestAttestationReport(cert, quoteAsBytes)
+		if err != nil {
+			return shim.Error("Error while retrieving attestation report: " + err.Error())
+		}
+	} else {
+		if attestationReport, err = attestation.ParseAttestationReport([]byte(args[2])); err != nil {
+			return shim.Error("Can not parse attestation report: " + err.Error())
+		}
+		if !attestation.ReportContainsQuote(attestationReport, quoteAsBytes) {
+			return shim.Error("Attestation report does not contain quote")
+		}
+	}
+
+	var binding *attestation.ReportDataBinding
+	if args[3] != "" {
+		binding = attestation.NewReportDataBinding(stub.GetTxID(), stub.GetChannelID(), args[3])
+	}
+
+	return ercc.registerReport(stub, enclavePkAsBytes, quoteAsBytes, attestationReport, binding, true)
+}
+
+// ============================================================
+// getChaincodeEnclaves -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getChaincodeEnclaves(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "chaincodeID" (empty for enclaves registered with quotes not bound to a chaincode)
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id")
+	}
+
+	chaincode := &registry.ChaincodeEnclaves{ChaincodeID: args[0], Enclaves: []registry.ChaincodeEnclave{}}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(registry.ChaincodeEnclaveObjectType, []string{chaincode.ChaincodeID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		entry := registry.ChaincodeEnclave{}
+		if err := json.Unmarshal(kv.Value, &entry); err != nil {
+			return shim.Error(err.Error())
+		}
+		chaincode.Enclaves = append(chaincode.Enclaves, entry)
+	}
+
+	chaincodeAsBytes, err := registry.MarshalCanonical(chaincode)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(chaincodeAsBytes)
+}
+
+// checkRegistrationCollision returns an error if the enclave is registered already, unless this is a renewal,
+// if a renewal refers to an enclave that is not registered, or if the registration of the enclave was pruned
+func checkRegistrationCollision(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, renewal bool) error {
+	reportAsBytes, err := stub.GetState(enclavePkHashBase64)
+	if err != nil {
+		return errors.New("Failed to get state for " + enclavePkHashBase64)
+	}
+	if reportAsBytes != nil && !renewal {
+		return errors.New("Enclave already registered: " + enclavePkHashBase64 + "; use renewEnclave to replace its evidence")
+	}
+	if reportAsBytes == nil && renewal {
+		return errors.New("EnclavePK does not exist: " + enclavePkHashBase64)
+	}
+
+	// keys of pruned registrations expired or got revoked and must not come back
+	tombstone, err := getTombstone(stub, enclavePkHashBase64)
+	if err != nil {
+		return err
+	} else if tombstone != nil {
+		return errors.New("Enclave registration has been pruned: " + tombstone.Reason)
+	}
+	return nil
+}
+
+// getIdenticalRegistration returns the enclave id of an existing registration of the enclave if it was made with
+// the same quote for the same binding target, and an empty id otherwise. Registering identical evidence again is
+// a no-op, thus, deployment scripts can register without checking the registry first.
+func getIdenticalRegistration(stub shim.ChaincodeStubInterface, enclavePkAsBytes, quoteAsBytes []byte, binding *attestation.ReportDataBinding) (string, error) {
+	enclavePkHashBase64 := registry.EnclavePkHash(enclavePkAsBytes)
+	reportAsBytes, err := stub.GetState(enclavePkHashBase64)
+	if err != nil {
+		return "", errors.New("Failed to get state for " + enclavePkHashBase64)
+	} else if reportAsBytes == nil {
+		return "", nil
+	}
+
+	quoteKey, err := stub.CreateCompositeKey(registry.QuoteObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return "", err
+	}
+	storedQuote, err := stub.GetState(quoteKey)
+	if err != nil {
+		return "", errors.New("Failed to get quote for " + enclavePkHashBase64)
+	} else if storedQuote == nil {
+		return "", nil
+	}
+	storedQuote, err = registry.DecompressEvidence(storedQuote)
+	if err != nil {
+		return "", errors.New("Can not decompress quote: " + err.Error())
+	}
+	if !consttime.Equal(storedQuote, quoteAsBytes) {
+		return "", nil
+	}
+
+	// the nonce of a bound quote refers to the transaction that registered it; compare the target only
+	bindingKey, err := stub.CreateCompositeKey(registry.BindingObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return "", err
+	}
+	bindingAsBytes, err := stub.GetState(bindingKey)
+	if err != nil {
+		return "", err
+	}
+	if (bindingAsBytes == nil) != (binding == nil) {
+		return "", nil
+	}
+	if binding != nil {
+		storedBinding := &attestation.ReportDataBinding{}
+		if err := json.Unmarshal(bindingAsBytes, storedBinding); err != nil {
+			return "", err
+		}
+		if storedBinding.ChannelID != binding.ChannelID || storedBinding.ChaincodeID != binding.ChaincodeID ||
+			storedBinding.ChaincodeVersion != binding.ChaincodeVersion {
+			return "", nil
+		}
+	}
+
+	return getEnclaveIDByPkHash(stub, enclavePkHashBase64)
+}
+
+// putChaincodeEnclave stores the registration of an enclave for a chaincode. An existing registration of the
+// enclave for the chaincode is only replaced on renewal.
+func putChaincodeEnclave(stub shim.ChaincodeStubInterface, chaincodeID, enclaveID, enclavePkHashBase64 string, renewal bool) error {
+	key, err := stub.CreateCompositeKey(registry.ChaincodeEnclaveObjectType, []string{chaincodeID, enclaveID})
+	if err != nil {
+		return err
+	}
+
+	if !renewal {
+		existing, err := stub.GetState(key)
+		if err != nil {
+			return err
+		} else if existing != nil {
+			return errors.New("Enclave " + enclaveID + " already registered for chaincode " + chaincodeID)
+		}
+	}
+
+	entryAsBytes, err := registry.MarshalCanonical(&registry.ChaincodeEnclave{
+		ChaincodeID:   chaincodeID,
+		EnclaveID:     enclaveID,
+		EnclavePkHash: enclavePkHashBase64,
+	})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, entryAsBytes)
+}
+
+// delChaincodeEnclave removes the registration of an enclave for a chaincode
+func delChaincodeEnclave(stub shim.ChaincodeStubInterface, chaincodeID, enclaveID string) error {
+	key, err := stub.CreateCompositeKey(registry.ChaincodeEnclaveObjectType, []string{chaincodeID, enclaveID})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// getBoundChaincodeID returns the chaincode the quote of an enclave is bound to, and an empty id if it is not bound
+func getBoundChaincodeID(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) (string, error) {
+	bindingKey, err := stub.CreateCompositeKey(registry.BindingObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return "", err
+	}
+	bindingAsBytes, err := stub.GetState(bindingKey)
+	if err != nil {
+		return "", err
+	} else if bindingAsBytes == nil {
+		return "", nil
+	}
+
+	binding := &attestation.ReportDataBinding{}
+	if err := json.Unmarshal(bindingAsBytes, binding); err != nil {
+		return "", err
+	}
+	return binding.ChaincodeID, nil
+}
+
+// moveChaincodeEnclave moves the chaincode registration of a key from the identity created by its registration
+// to the identity it got rotated into
+func moveChaincodeEnclave(stub shim.ChaincodeStubInterface, fromEnclaveID, toEnclaveID, enclavePkHashBase64 string) error {
+	chaincodeID, err := getBoundChaincodeID(stub, enclavePkHashBase64)
+	if err != nil {
+		return err
+	}
+
+	if err := delChaincodeEnclave(stub, chaincodeID, fromEnclaveID); err != nil {
+		return err
+	}
+	return putChaincodeEnclave(stub, chaincodeID, toEnclaveID, enclavePkHashBase64, true)
+}
+
+// getRenewedEnclaveID returns the id of the enclave identity whose active key is renewed. The renewed
+// evidence must come from the same enclave code and platform.
+func getRenewedEnclaveID(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, report attestation.IASAttestationReport) (string, error) {
+	enclaveID, err := getEnclaveIDByPkHash(stub, enclavePkHashBase64)
+	if err != nil {
+		return "", err
+	}
+	identity, err := getEnclaveIdentity(stub, enclaveID)
+	if err != nil {
+		return "", err
+	}
+	if identity.ActivePkHash != enclavePkHashBase64 {
+		return "", errors.New("Only the active key of an enclave can be renewed")
+	}
+
+	mrEnclave, platformID, err := identityAttributes(report)
+	if err != nil {
+		return "", err
+	}
+	if !consttime.EqualString(mrEnclave, identity.MrEnclave) || platformID != identity.PlatformID {
+		return "", errors.New("Renewed evidence does not belong to the same enclave code and platform")
+	}
+	return enclaveID, nil
+}
+
+// dropEvidenceRecords removes the records that refer to the previous evidence of a renewed enclave
+func dropEvidenceRecords(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, unbound bool) error {
+	objectTypes := []string{registry.RegistrarSignatureObjectType}
+	if unbound {
+		objectTypes = append(objectTypes, registry.BindingObjectType)
+	}
+
+	for _, objectType := range objectTypes {
+		key, err := stub.CreateCompositeKey(objectType, []string{enclavePkHashBase64})
+		if err != nil {
+			return err
+		}
+		if err := stub.DelState(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// checkReplay rejects a quote whose REPORT_DATA was used by another registration, or by a registration of the same
+// enclave unless this is a renewal, and records the REPORT_DATA as used by this registration. IAS verifies a quote
+// as often as it is submitted, thus, without the replay cache, one quote could back several registrations. The
+// REPORT_DATA is computed from the enclave pk and binding, which the verified report was checked to match.
+func checkReplay(stub shim.ChaincodeStubInterface, enclavePkAsBytes []byte, binding *attestation.ReportDataBinding, renewal bool) error {
+	reportData, err := attestation.ComputeReportData(enclavePkAsBytes, binding)
+	if err != nil {
+		return err
+	}
+	enclavePkHashBase64 := registry.EnclavePkHash(enclavePkAsBytes)
+	reportDataHash := sha256.Sum256(reportData[:])
+	key, err := stub.CreateCompositeKey(registry.ReplayObjectType, []string{base64.StdEncoding.EncodeToString(reportDataHash[:])})
+	if err != nil {
+		return err
+	}
+
+	now, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	entry, err := getReplayEntry(stub, key)
+	if err != nil {
+		return err
+	}
+	if entry != nil && !entry.IsExpired(now) && !(renewal && entry.EnclavePkHash == enclavePkHashBase64) {
+		return errors.New("Quote replayed; its report data was used by the registration of " + entry.EnclavePkHash + " in transaction " + entry.TxID)
+	}
+
+	policy, err := getAttestationPolicy(stub)
+	if err != nil {
+		return err
+	}
+	entry = &registry.ReplayEntry{EnclavePkHash: enclavePkHashBase64, TxID: stub.GetTxID(), UsedAt: now}
+	if policy != nil && policy.ReplayCacheTTL > 0 {
+		entry.ExpiresAt = now + policy.ReplayCacheTTL
+	}
+	entryAsBytes, err := registry.MarshalCanonical(entry)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, entryAsBytes)
+}
+
+// pruneReplayCache removes up to limit replay cache entries expired at unix time now and returns their number
+func pruneReplayCache(stub shim.ChaincodeStubInterface, now int64, limit int) (int, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(registry.ReplayObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	var keys []string
+	for resultsIterator.HasNext() && len(keys) < limit {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		entry := &registry.ReplayEntry{}
+		if err := json.Unmarshal(kv.Value, entry); err != nil {
+			return 0, err
+		}
+		if entry.IsExpired(now) {
+			keys = append(keys, kv.Key)
+		}
+	}
+	for _, key := range keys {
+		if err := stub.DelState(key); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// getReplayEntry returns the replay cache entry stored under key, nil if there is none
+func getReplayEntry(stub shim.ChaincodeStubInterface, key string) (*registry.ReplayEntry, error) {
+	entryAsBytes, err := stub.GetState(key)
+	if err != nil || entryAsBytes == nil {
+		return nil, err
+	}
+	entry := &registry.ReplayEntry{}
+	if err := json.Unmarshal(entryAsBytes, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
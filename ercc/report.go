@@ -0,0 +1,116 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// registerEnclaveWithReport -
+// ============================================================
+func (ercc *EnclaveRegistryCC) registerEnclaveWithReport(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkBase64
+	// 1: quoteBase64
+	// 2: attestationReport (json encoded attestation.IASAttestationReport as received from IAS by the client)
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk, quote and attestation report to register")
+	}
+
+	// quote binds the enclave pk only
+	return ercc.registerWithReport(stub, args[0], args[1], args[2], nil)
+}
+
+// ============================================================
+// registerBoundEnclaveWithReport -
+// ============================================================
+func (ercc *EnclaveRegistryCC) registerBoundEnclaveWithReport(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkBase64
+	// 1: quoteBase64
+	// 2: attestationReport (json encoded attestation.IASAttestationReport as received from IAS by the client)
+	// 3: chaincodeID[:version]
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk, quote, attestation report and chaincode id to register")
+	}
+
+	// quote binds the enclave pk to this transaction, channel and chaincode; the client computes the
+	// transaction id before it requests the quote
+	binding := attestation.NewReportDataBinding(stub.GetTxID(), stub.GetChannelID(), args[3])
+	return ercc.registerWithReport(stub, args[0], args[1], args[2], binding)
+}
+
+// registerWithReport registers an enclave with an attestation report the client obtained from IAS. Unlike
+// register, this does not contact IAS and thus endorsing peers reach the same result.
+func (ercc *EnclaveRegistryCC) registerWithReport(stub shim.ChaincodeStubInterface, enclavePkBase64, quoteBase64, reportJSON string, binding *attestation.ReportDataBinding) pb.Response {
+	enclavePkAsBytes, err := base64.StdEncoding.DecodeString(enclavePkBase64)
+	if err != nil {
+		return shim.Error("Can not parse enclavePkBase64: " + err.Error())
+	}
+
+	quoteAsBytes, err := decodeQuote(quoteBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	attestationReport, err := attestation.ParseAttestationReport([]byte(reportJSON))
+	if err != nil {
+		return shim.Error("Can not parse attestation report: " + err.Error())
+	}
+
+	// the quote is stored for re-validation; make sure it is the one the report was issued for
+	if !attestation.ReportContainsQuote(attestationReport, quoteAsBytes) {
+		return shim.Error("Attestation report does not contain quote")
+	}
+
+	// registering identical evidence again returns the existing registration
+	if enclaveID, err := getIdenticalRegistration(stub, enclavePkAsBytes, quoteAsBytes, binding); err != nil {
+		return shim.Error(err.Error())
+	} else if enclaveID != "" {
+		return shim.Success([]byte(enclaveID))
+	}
+
+	if err := chargeRegistration(stub); err != nil {
+		return shim.Error("Registration rejected: " + err.Error())
+	}
+
+	return ercc.registerReport(stub, enclavePkAsBytes, quoteAsBytes, attestationReport, binding, false)
+}
+
+// decodeQuote decodes a quote and rejects unsupported quote formats before the quote is sent to IAS or stored
+func decodeQuote(quoteBase64 string) ([]byte, error) {
+	if len(quoteBase64) > base64.StdEncoding.EncodedLen(attestation.MaxQuoteSize) {
+		return nil, errors.New("Invalid quote: " + attestation.ErrQuoteTooLarge.Error())
+	}
+	quoteAsBytes, err := base64.StdEncoding.DecodeString(quoteBase64)
+	if err != nil {
+		return nil, errors.New("Can not parse quoteBase64: " + err.Error())
+	}
+	if _, err := attestation.ParseQuote(quoteAsBytes); err != nil {
+		return nil, errors.New("Invalid quote: " + err.Error())
+	}
+	return quoteAsBytes, nil
+}
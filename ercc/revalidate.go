@@ -0,0 +1,304 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// revalidateEnclave -
+// ============================================================
+func (ercc *EnclaveRegistryCC) revalidateEnclave(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkHashBase64
+	// 1: certPem
+	// 2: keyPem
+	// if certPem and keyPem not available as argument we try to read them from decorator
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash")
+	}
+
+	cert, err := ercc.getIASClientCert(stub, args[1:])
+	if err != nil {
+		return shim.Error("Can not load client cert: " + err.Error())
+	}
+
+	status, changes, err := ercc.revalidate(stub, cert, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := setTrustChangedEvent(stub, changes); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := transcribeRevocations(stub, changes); err != nil {
+		return shim.Error("Can not append to transcript: " + err.Error())
+	}
+
+	statusAsBytes, err := registry.MarshalCanonical(status)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(statusAsBytes)
+}
+
+// ============================================================
+// revalidateEnclaves -
+// ============================================================
+func (ercc *EnclaveRegistryCC) revalidateEnclaves(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: certPem
+	// 1: keyPem
+	// if certPem and keyPem not available as argument we try to read them from decorator
+	// meant to be triggered periodically, e.g., by a cron job invoking ercc
+	cert, err := ercc.getIASClientCert(stub, args)
+	if err != nil {
+		return shim.Error("Can not load client cert: " + err.Error())
+	}
+
+	// registrations are stored under simple keys
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	statusMap := make(map[string]*registry.EnclaveStatus)
+	var changes []registry.TrustChange
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if registry.IsCompositeKey(kv.Key) {
+			continue
+		}
+
+		status, enclaveChanges, err := ercc.revalidate(stub, cert, kv.Key)
+		if err != nil {
+			logger.Errorf("ercc: Re-validation of %s failed: %s", kv.Key, err)
+			continue
+		}
+		statusMap[kv.Key] = status
+		changes = append(changes, enclaveChanges...)
+	}
+
+	if err := setTrustChangedEvent(stub, changes); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := transcribeRevocations(stub, changes); err != nil {
+		return shim.Error("Can not append to transcript: " + err.Error())
+	}
+
+	statusMapAsBytes, err := registry.MarshalCanonical(statusMap)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(statusMapAsBytes)
+}
+
+// ============================================================
+// getEnclaveStatus -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getEnclaveStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "enclavePkHashBase64"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash")
+	}
+
+	key, err := stub.CreateCompositeKey(registry.StatusObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	statusAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get status for " + args[0])
+	} else if statusAsBytes == nil {
+		return shim.Error("Enclave has not been re-validated: " + args[0])
+	}
+
+	return shim.Success(statusAsBytes)
+}
+
+// revalidate re-submits the stored quote of an enclave to IAS and updates the enclave status if it changed.
+// Returns the status and the trust changes caused by advisories or a revoked quote status.
+func (ercc *EnclaveRegistryCC) revalidate(stub shim.ChaincodeStubInterface, cert tls.Certificate, enclavePkHashBase64 string) (*registry.EnclaveStatus, []registry.TrustChange, error) {
+	quoteKey, err := stub.CreateCompositeKey(registry.QuoteObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return nil, nil, err
+	}
+	quoteAsBytes, err := stub.GetState(quoteKey)
+	if err != nil {
+		return nil, nil, errors.New("Failed to get quote for " + enclavePkHashBase64)
+	} else if quoteAsBytes == nil {
+		return nil, nil, errors.New("No quote stored for " + enclavePkHashBase64)
+	}
+	quoteAsBytes, err = registry.DecompressEvidence(quoteAsBytes)
+	if err != nil {
+		return nil, nil, errors.New("Can not decompress quote: " + err.Error())
+	}
+
+	attestationReport, err := ercc.ias.RequestAttestationReport(cert, quoteAsBytes)
+	if err != nil {
+		return nil, nil, errors.New("Error while retrieving attestation report: " + err.Error())
+	}
+
+	if _, err := ercc.verifyAttestationReport(stub, attestationReport); err != nil {
+		return nil, nil, err
+	}
+
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(attestationReport.IASReportBody, &reportBody); err != nil {
+		return nil, nil, errors.New("Can not parse report body: " + err.Error())
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status := &registry.EnclaveStatus{
+		QuoteStatus: reportBody.IsvEnclaveQuoteStatus,
+		AdvisoryIDs: reportBody.AdvisoryIDs,
+		CheckedAt:   txTime,
+	}
+
+	// apply ingested advisories
+	for _, advisoryID := range status.AdvisoryIDs {
+		advisory, err := getAdvisory(stub, advisoryID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if advisory != nil {
+			status.Apply(advisory)
+		}
+	}
+
+	statusKey, err := stub.CreateCompositeKey(registry.StatusObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oldStatus := &registry.EnclaveStatus{}
+	oldStatusAsBytes, err := stub.GetState(statusKey)
+	if err != nil {
+		return nil, nil, err
+	} else if oldStatusAsBytes != nil {
+		if err := json.Unmarshal(oldStatusAsBytes, oldStatus); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// pruneRegistry measures the retention of revoked enclaves from the time they were first found revoked
+	if status.IsRevoked() {
+		status.RevokedAt = oldStatus.RevokedAt
+		if status.RevokedAt == 0 {
+			status.RevokedAt = txTime
+		}
+	}
+
+	// only write if the status changed to keep the write set small
+	if oldStatusAsBytes != nil && sameStatus(oldStatus, status) {
+		return oldStatus, nil, nil
+	}
+
+	if oldStatus.QuoteStatus != "" && oldStatus.QuoteStatus != status.QuoteStatus {
+		logger.Warningf("ercc: Status of %s changed from %s to %s", enclavePkHashBase64, oldStatus.QuoteStatus, status.QuoteStatus)
+	}
+
+	statusAsBytes, err := registry.MarshalCanonical(status)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := stub.PutState(statusKey, statusAsBytes); err != nil {
+		return nil, nil, err
+	}
+
+	return status, trustChanges(stub, enclavePkHashBase64, oldStatus, status), nil
+}
+
+// sameStatus returns true if both status are equal apart from the time they were checked
+func sameStatus(a, b *registry.EnclaveStatus) bool {
+	return a.QuoteStatus == b.QuoteStatus &&
+		reflect.DeepEqual(a.AdvisoryIDs, b.AdvisoryIDs) &&
+		reflect.DeepEqual(a.FlaggedBy, b.FlaggedBy) &&
+		reflect.DeepEqual(a.RevokedBy, b.RevokedBy)
+}
+
+// trustChanges returns the trust changes between the old and the new status of an enclave
+func trustChanges(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, oldStatus, status *registry.EnclaveStatus) []registry.TrustChange {
+	var changes []registry.TrustChange
+
+	if status.IsQuoteRevoked() && !oldStatus.IsQuoteRevoked() {
+		changes = append(changes, registry.TrustChange{
+			EnclavePkHash: enclavePkHashBase64,
+			Action:        registry.AdvisoryActionRevoke,
+			Description:   "IAS reported quote status " + status.QuoteStatus,
+		})
+	}
+
+	// advisories which flagged or revoked the enclave with this re-validation
+	var advisoryIDs []string
+	for _, advisoryID := range status.FlaggedBy {
+		if !containsString(oldStatus.FlaggedBy, advisoryID) {
+			advisoryIDs = append(advisoryIDs, advisoryID)
+		}
+	}
+	for _, advisoryID := range status.RevokedBy {
+		if !containsString(oldStatus.RevokedBy, advisoryID) {
+			advisoryIDs = append(advisoryIDs, advisoryID)
+		}
+	}
+
+	for _, advisoryID := range advisoryIDs {
+		advisory, err := getAdvisory(stub, advisoryID)
+		if err != nil || advisory == nil {
+			continue
+		}
+		changes = append(changes, registry.TrustChange{
+			EnclavePkHash: enclavePkHashBase64,
+			AdvisoryID:    advisory.ID,
+			Action:        advisory.Action,
+			Description:   advisory.Description,
+		})
+	}
+
+	return changes
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// provisionerAttribute is the certificate attribute of clients allowed to provision secrets; enclaves check it as well
+const provisionerAttribute = "ercc.provisioner"
+
+// ProvisionedSecret is an application secret encrypted to the public key of a registered enclave.
+// The encryption key is derived via ECDH from an ephemeral key of the provider and the enclave key,
+// thus, only the enclave can recover the secret. The approval of the provisioner over the registry.SecretStatement
+// lets the enclave authenticate the secret.
+type ProvisionedSecret struct {
+	EphemeralPk []byte             `json:"EphemeralPk"`
+	Ciphertext  []byte             `json:"Ciphertext"`
+	Approval    *registry.Approval `json:"Approval"`
+}
+
+// ============================================================
+// provisionSecret -
+// ============================================================
+func (ercc *EnclaveRegistryCC) provisionSecret(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkHashBase64
+	// 1: secretName
+	// 2: ephemeralPkBase64
+	// 3: ciphertextBase64
+	// 4: approval (json encoded registry.Approval of the registry.SecretStatement, signed by the client)
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash, secret name, ephemeral pk, ciphertext and approval")
+	}
+
+	if err := cid.AssertAttributeValue(stub, provisionerAttribute, "true"); err != nil {
+		return shim.Error("Client is not a provisioner: " + err.Error())
+	}
+
+	enclavePkHashBase64 := args[0]
+	secretName := args[1]
+	if secretName == "" {
+		return shim.Error("Secret name must not be empty")
+	}
+
+	ephemeralPk, err := base64.StdEncoding.DecodeString(args[2])
+	if err != nil {
+		return shim.Error("Can not parse ephemeralPkBase64: " + err.Error())
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(args[3])
+	if err != nil {
+		return shim.Error("Can not parse ciphertextBase64: " + err.Error())
+	}
+
+	// secrets are only delivered to enclaves whose registration is already committed
+	attestationReport, err := stub.GetState(enclavePkHashBase64)
+	if err != nil {
+		return shim.Error("Failed to get state for " + enclavePkHashBase64)
+	} else if attestationReport == nil {
+		return shim.Error("EnclavePK does not exist: " + enclavePkHashBase64)
+	}
+
+	approval, err := checkApproval(stub, args[4], registry.SecretStatement(secretName, ciphertext))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	secretAsBytes, err := registry.MarshalCanonical(&ProvisionedSecret{EphemeralPk: ephemeralPk, Ciphertext: ciphertext, Approval: approval})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.SecretObjectType, []string{enclavePkHashBase64, secretName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := stub.PutState(key, secretAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getSecret -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getSecret(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0                       1
+	// "enclavePkHashBase64", "secretName"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash and secret name")
+	}
+
+	key, err := stub.CreateCompositeKey(registry.SecretObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	secretAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get secret " + args[1])
+	} else if secretAsBytes == nil {
+		return shim.Error("Secret does not exist: " + args[1])
+	}
+
+	return shim.Success(secretAsBytes)
+}
@@ -0,0 +1,196 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// getRegistryStats -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getRegistryStats(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args: none
+	// scans the whole registry; meant for dashboards and governance reports rather than for every transaction
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting none")
+	}
+
+	now, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy, err := getAttestationPolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// registrations are stored under simple keys
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	stats := &registry.RegistryStats{PerOrg: map[string]int{}, ComputedAt: now}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if registry.IsCompositeKey(kv.Key) {
+			continue
+		}
+
+		if err := countRegistration(stub, stats, policy, kv.Key, kv.Value); err != nil {
+			return shim.Error("Can not count " + kv.Key + ": " + err.Error())
+		}
+	}
+	if stats.OldestAttestation != 0 {
+		stats.OldestAttestationAge = now - stats.OldestAttestation
+	}
+
+	statsAsBytes, err := registry.MarshalCanonical(stats)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(statsAsBytes)
+}
+
+// countRegistration adds the registration stored under enclavePkHashBase64 to stats. A revoked registration counts
+// as revoked even if it also expired or got retired.
+func countRegistration(stub shim.ChaincodeStubInterface, stats *registry.RegistryStats, policy *registry.AttestationPolicy, enclavePkHashBase64 string, compressedReport []byte) error {
+	reportAsBytes, err := registry.DecompressEvidence(compressedReport)
+	if err != nil {
+		return err
+	}
+	report := attestation.IASAttestationReport{}
+	if err := json.Unmarshal(reportAsBytes, &report); err != nil {
+		return err
+	}
+	reportBody := attestation.IASReportBody{}
+	if err := json.Unmarshal(report.IASReportBody, &reportBody); err != nil {
+		return errors.New("Can not parse report body: " + err.Error())
+	}
+	reportTime, err := registry.ReportTime(reportBody)
+	if err != nil {
+		return err
+	}
+
+	stats.Total++
+	registrant, err := getRegistrant(stub, enclavePkHashBase64)
+	if err != nil {
+		return err
+	} else if registrant == "" {
+		stats.Unattributed++
+	} else {
+		stats.PerOrg[registrant]++
+	}
+
+	statusKey, err := stub.CreateCompositeKey(registry.StatusObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	statusAsBytes, err := stub.GetState(statusKey)
+	if err != nil {
+		return err
+	} else if statusAsBytes != nil {
+		status := &registry.EnclaveStatus{}
+		if err := json.Unmarshal(statusAsBytes, status); err != nil {
+			return err
+		}
+		if status.IsRevoked() {
+			stats.Revoked++
+			return nil
+		}
+	}
+
+	retiredKey, err := stub.CreateCompositeKey(registry.RetiredObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	successor, err := stub.GetState(retiredKey)
+	if err != nil {
+		return err
+	} else if successor != nil {
+		stats.Retired++
+		return nil
+	}
+
+	if policy != nil {
+		expiresAt, err := policy.ExpiresAt(reportBody)
+		if err != nil {
+			return err
+		}
+		if expiresAt != 0 && stats.ComputedAt >= expiresAt {
+			stats.Expired++
+			return nil
+		}
+	}
+
+	pending, err := getPendingRegistration(stub, enclavePkHashBase64)
+	if err != nil {
+		return err
+	} else if pending != nil {
+		stats.Pending++
+		return nil
+	}
+
+	stats.Active++
+	if stats.OldestAttestation == 0 || reportTime < stats.OldestAttestation {
+		stats.OldestAttestation = reportTime
+	}
+	return nil
+}
+
+// putRegistrant records the org submitting the registration of an enclave. A creator without MSP id, as with the
+// mock stub of unit tests, leaves the registration unattributed.
+func putRegistrant(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) error {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil
+	}
+
+	key, err := stub.CreateCompositeKey(registry.RegistrantObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte(mspID))
+}
+
+// getRegistrant returns the MSP id of the org that submitted the registration of an enclave, or an empty string if
+// it was not recorded
+func getRegistrant(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) (string, error) {
+	key, err := stub.CreateCompositeKey(registry.RegistrantObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return "", err
+	}
+	mspID, err := stub.GetState(key)
+	if err != nil {
+		return "", err
+	}
+	return string(mspID), nil
+}
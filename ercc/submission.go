@@ -0,0 +1,409 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// defaultSubmissionTimeout is the time (seconds) after which a quote submission is stale unless the attestation
+// policy sets a SubmissionTimeout
+const defaultSubmissionTimeout = 24 * 60 * 60
+
+// ============================================================
+// submitQuote -
+// ============================================================
+func (ercc *EnclaveRegistryCC) submitQuote(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkBase64
+	// 1: quoteBase64
+	// 2: chaincodeID[:version] (optional; if set, the quote binds this transaction, channel and chaincode)
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk, quote and optional chaincode id")
+	}
+
+	enclavePkAsBytes, err := base64.StdEncoding.DecodeString(args[0])
+	if err != nil {
+		return shim.Error("Can not parse enclavePkBase64: " + err.Error())
+	}
+	quoteAsBytes, err := decodeQuote(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	enclavePkHashBase64 := registry.EnclavePkHash(enclavePkAsBytes)
+
+	var binding *attestation.ReportDataBinding
+	if len(args) == 3 {
+		binding = attestation.NewReportDataBinding(stub.GetTxID(), stub.GetChannelID(), args[2])
+	}
+	// the quote must be of the enclave with this key, otherwise anybody could block the key with a foreign quote
+	isValid, err := ercc.ra.CheckQuoteReportData(enclavePkAsBytes, binding, quoteAsBytes)
+	if err != nil {
+		return shim.Error("Error while checking enclave PK: " + err.Error())
+	}
+	if !isValid {
+		return shim.Error("Enclave PK does not match quote!")
+	}
+
+	if reportAsBytes, err := stub.GetState(enclavePkHashBase64); err != nil {
+		return shim.Error(err.Error())
+	} else if reportAsBytes != nil {
+		return shim.Error("Enclave already registered: " + enclavePkHashBase64)
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Can not get client msp id: " + err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the submitting org may replace its submission, e.g., with a fresh quote; other orgs only once it is stale
+	existing, err := getQuoteSubmission(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		timeout, err := getSubmissionTimeout(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if existing.SubmittedBy != mspID && !existing.IsStale(txTime, timeout) {
+			return shim.Error("Quote already submitted for " + enclavePkHashBase64)
+		}
+		if err := dropSubmissionEvidence(stub, enclavePkHashBase64); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// the quota is charged for every submission, replacements included
+	if err := chargeRegistration(stub); err != nil {
+		return shim.Error("Registration rejected: " + err.Error())
+	}
+
+	submission := &registry.QuoteSubmission{
+		EnclavePk:   enclavePkAsBytes,
+		Quote:       quoteAsBytes,
+		Binding:     binding,
+		TxID:        stub.GetTxID(),
+		SubmittedBy: mspID,
+		SubmittedAt: txTime,
+	}
+
+	submissionAsBytes, err := registry.MarshalCanonical(submission)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := stub.CreateCompositeKey(registry.SubmissionObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, submissionAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(enclavePkHashBase64))
+}
+
+// ============================================================
+// finalizeRegistration -
+// ============================================================
+func (ercc *EnclaveRegistryCC) finalizeRegistration(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkHashBase64
+	// 1: attestationReport (json encoded attestation.IASAttestationReport; if empty, the evidence attached by
+	//    verifiers is used or, without evidence, ercc requests it from IAS)
+	// 2: certPem
+	// 3: keyPem
+	// if certPem and keyPem not available as argument we try to read them from decorator
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash and attestation report")
+	}
+	enclavePkHashBase64 := args[0]
+
+	submission, err := getQuoteSubmission(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if submission == nil {
+		return shim.Error("No quote submitted for " + enclavePkHashBase64)
+	}
+	if err := checkSubmissionFresh(stub, submission); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	evidence, err := getSubmissionEvidence(stub, enclavePkHashBase64, submission)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	attestationReport := attestation.IASAttestationReport{}
+	if args[1] == "" && len(evidence) > 0 {
+		attestationReport = evidence[0].AttestationReport
+	} else if args[1] == "" {
+		cert, err := ercc.getIASClientCert(stub, args[2:])
+		if err != nil {
+			return shim.Error("Can not load client cert: " + err.Error())
+		}
+		// on failure the submission remains, so the registration can be finalized later
+		attestationReport, err = ercc.ias.RequestAttestationReport(cert, submission.Quote)
+		if err != nil {
+			return shim.Error("Error while retrieving attestation report: " + err.Error())
+		}
+	} else {
+		if attestationReport, err = attestation.ParseAttestationReport([]byte(args[1])); err != nil {
+			return shim.Error("Can not parse attestation report: " + err.Error())
+		}
+		if !attestation.ReportContainsQuote(attestationReport, submission.Quote) {
+			return shim.Error("Attestation report does not contain quote")
+		}
+	}
+
+	key, err := stub.CreateCompositeKey(registry.SubmissionObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.DelState(key); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := dropSubmissionEvidence(stub, enclavePkHashBase64); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	res := ercc.registerReport(stub, submission.EnclavePk, submission.Quote, attestationReport, submission.Binding, false)
+	if res.Status != shim.OK {
+		return res
+	}
+
+	// verifiers that attached evidence confirmed the registration already
+	var verifiers []string
+	for _, e := range evidence {
+		verifiers = append(verifiers, e.VerifiedBy)
+	}
+	if err := confirmPendingRegistration(stub, enclavePkHashBase64, verifiers); err != nil {
+		return shim.Error("Can not confirm registration: " + err.Error())
+	}
+
+	return res
+}
+
+// ============================================================
+// attachEvidence -
+// ============================================================
+func (ercc *EnclaveRegistryCC) attachEvidence(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enclavePkHashBase64
+	// 1: attestationReport (json encoded attestation.IASAttestationReport)
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash and attestation report")
+	}
+	enclavePkHashBase64 := args[0]
+
+	submission, err := getQuoteSubmission(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if submission == nil {
+		return shim.Error("No quote submitted for " + enclavePkHashBase64)
+	}
+	if err := checkSubmissionFresh(stub, submission); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the verifier vouches for the report, thus, it is checked as at registration
+	attestationReport, err := attestation.ParseAttestationReport([]byte(args[1]))
+	if err != nil {
+		return shim.Error("Can not parse attestation report: " + err.Error())
+	}
+	if !attestation.ReportContainsQuote(attestationReport, submission.Quote) {
+		return shim.Error("Attestation report does not contain quote")
+	}
+	if _, err := ercc.verifyAttestationReport(stub, attestationReport); err != nil {
+		return shim.Error(err.Error())
+	}
+	isValid, err := ercc.ra.CheckReportData(submission.EnclavePk, submission.Binding, attestationReport)
+	if err != nil {
+		return shim.Error("Error while checking enclave PK: " + err.Error())
+	}
+	if !isValid {
+		return shim.Error("Enclave PK does not match attestation report!")
+	}
+	if err := checkAttestationPolicy(stub, attestationReport); err != nil {
+		return shim.Error("Attestation policy violated: " + err.Error())
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Can not get client msp id: " + err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// one report per org; attaching again replaces the report of the org
+	evidenceAsBytes, err := registry.MarshalCanonical(&registry.SubmissionEvidence{
+		SubmissionTxID:    submission.TxID,
+		VerifiedBy:        mspID,
+		AttachedAt:        txTime,
+		AttestationReport: attestationReport,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := stub.CreateCompositeKey(registry.SubmissionEvidenceObjectType, []string{enclavePkHashBase64, mspID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, evidenceAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getQuoteSubmission -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getQuoteSubmission(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "enclavePkHashBase64"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash")
+	}
+
+	submission, err := getQuoteSubmission(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if submission == nil {
+		return shim.Error("No quote submitted for " + args[0])
+	}
+
+	submissionAsBytes, err := registry.MarshalCanonical(submission)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(submissionAsBytes)
+}
+
+func getQuoteSubmission(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) (*registry.QuoteSubmission, error) {
+	key, err := stub.CreateCompositeKey(registry.SubmissionObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return nil, err
+	}
+
+	submissionAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if submissionAsBytes == nil {
+		return nil, nil
+	}
+
+	submission := &registry.QuoteSubmission{}
+	if err := json.Unmarshal(submissionAsBytes, submission); err != nil {
+		return nil, errors.New("Can not unmarshal quote submission: " + err.Error())
+	}
+	return submission, nil
+}
+
+// getSubmissionEvidence returns the evidence attached to the given submission, ordered by verifier msp id
+func getSubmissionEvidence(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string, submission *registry.QuoteSubmission) ([]*registry.SubmissionEvidence, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(registry.SubmissionEvidenceObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var evidence []*registry.SubmissionEvidence
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		e := &registry.SubmissionEvidence{}
+		if err := json.Unmarshal(kv.Value, e); err != nil {
+			return nil, errors.New("Can not unmarshal submission evidence: " + err.Error())
+		}
+		// evidence of a replaced submission does not apply
+		if e.SubmissionTxID == submission.TxID {
+			evidence = append(evidence, e)
+		}
+	}
+	return evidence, nil
+}
+
+// dropSubmissionEvidence removes all evidence attached to the submission of the given enclave
+func dropSubmissionEvidence(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(registry.SubmissionEvidenceObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	var keys []string
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, kv.Key)
+	}
+	for _, key := range keys {
+		if err := stub.DelState(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSubmissionFresh returns an error if the submission is stale; stale quotes are replaced instead of finalized
+func checkSubmissionFresh(stub shim.ChaincodeStubInterface, submission *registry.QuoteSubmission) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	timeout, err := getSubmissionTimeout(stub)
+	if err != nil {
+		return err
+	}
+	if submission.IsStale(txTime, timeout) {
+		return errors.New("Quote submission is stale, submit the quote again")
+	}
+	return nil
+}
+
+// getSubmissionTimeout returns the submission timeout of the attestation policy or, if not set, the default
+func getSubmissionTimeout(stub shim.ChaincodeStubInterface) (int64, error) {
+	policy, err := getAttestationPolicy(stub)
+	if err != nil {
+		return 0, err
+	}
+	if policy != nil && policy.SubmissionTimeout > 0 {
+		return policy.SubmissionTimeout, nil
+	}
+	return defaultSubmissionTimeout, nil
+}
@@ -0,0 +1,204 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// setMinIsvSvn -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setMinIsvSvn(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeName
+	// 1: minIsvSvn
+	// 2: gracePeriod (seconds)
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name, min isv svn and grace period")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	chaincodeName := args[0]
+	minIsvSvn, err := strconv.ParseUint(args[1], 10, 16)
+	if err != nil {
+		return shim.Error("Can not parse minIsvSvn: " + err.Error())
+	}
+	gracePeriod, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || gracePeriod < 0 {
+		return shim.Error("Invalid grace period: " + args[2])
+	}
+
+	// grace period starts with this transaction; the ecc vscc enforces it with the ledger clock
+	clock, err := ercc.advanceClock(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policyAsBytes, err := registry.MarshalCanonical(&registry.MinIsvSvnPolicy{
+		ChaincodeName: chaincodeName,
+		MinIsvSvn:     uint16(minIsvSvn),
+		EnforcedFrom:  clock.Time + gracePeriod,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.MinIsvSvnObjectType, []string{chaincodeName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := stub.PutState(key, policyAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(policyAsBytes)
+}
+
+// ============================================================
+// getMinIsvSvn -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getMinIsvSvn(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "chaincodeName"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode name")
+	}
+
+	key, err := stub.CreateCompositeKey(registry.MinIsvSvnObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get min isv svn for " + args[0])
+	} else if policyAsBytes == nil {
+		return shim.Error("No min isv svn set for " + args[0])
+	}
+
+	return shim.Success(policyAsBytes)
+}
+
+// ============================================================
+// isEndorsing -
+// ============================================================
+func (ercc *EnclaveRegistryCC) isEndorsing(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0                       1
+	// "enclavePkHashBase64", "chaincodeName"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting enclave pk hash and chaincode name")
+	}
+
+	endorsing, err := isEndorsing(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(strconv.FormatBool(endorsing)))
+}
+
+// isEndorsing returns true if the registered enclave may endorse transactions of the given chaincode
+func isEndorsing(stub shim.ChaincodeStubInterface, enclavePkHashBase64, chaincodeName string) (bool, error) {
+	report, err := getAttestationReport(stub, enclavePkHashBase64)
+	if err != nil {
+		return false, err
+	}
+
+	retiredKey, err := stub.CreateCompositeKey(registry.RetiredObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return false, err
+	}
+	retired, err := stub.GetState(retiredKey)
+	if err != nil {
+		return false, err
+	} else if retired != nil {
+		return false, nil
+	}
+
+	pending, err := getPendingRegistration(stub, enclavePkHashBase64)
+	if err != nil {
+		return false, err
+	} else if pending != nil {
+		return false, nil
+	}
+
+	statusKey, err := stub.CreateCompositeKey(registry.StatusObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return false, err
+	}
+	statusAsBytes, err := stub.GetState(statusKey)
+	if err != nil {
+		return false, err
+	} else if statusAsBytes != nil {
+		status := &registry.EnclaveStatus{}
+		if err := json.Unmarshal(statusAsBytes, status); err != nil {
+			return false, err
+		}
+		if status.IsRevoked() {
+			return false, nil
+		}
+	}
+
+	// like the ecc vscc, decide expiry and grace periods with the ledger clock
+	ledgerTime, err := getLedgerTime(stub)
+	if err != nil {
+		return false, err
+	}
+
+	// registrations expire relative to the IAS timestamp of their report
+	expiresAt, err := registrationExpiresAt(stub, report)
+	if err != nil {
+		return false, err
+	} else if expiresAt != 0 && ledgerTime >= expiresAt {
+		return false, nil
+	}
+
+	policyKey, err := stub.CreateCompositeKey(registry.MinIsvSvnObjectType, []string{chaincodeName})
+	if err != nil {
+		return false, err
+	}
+	policyAsBytes, err := stub.GetState(policyKey)
+	if err != nil {
+		return false, err
+	} else if policyAsBytes == nil {
+		return true, nil
+	}
+
+	policy := &registry.MinIsvSvnPolicy{}
+	if err := json.Unmarshal(policyAsBytes, policy); err != nil {
+		return false, err
+	}
+
+	quote, err := attestation.QuoteFromAttestionReport(report)
+	if err != nil {
+		return false, err
+	}
+
+	return policy.IsEndorsing(registry.IsvSvn(quote), ledgerTime), nil
+}
@@ -0,0 +1,83 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// MaxClockSkewEnv names the environment variable overriding the tolerated difference (seconds) between the
+// timestamp of a transaction and the clock of this endorser; a negative value disables the check
+const MaxClockSkewEnv = "ERCC_MAX_CLOCK_SKEW"
+
+// defaultMaxClockSkew is the tolerated clock skew (seconds) unless configured otherwise
+const defaultMaxClockSkew = 300
+
+// TimeSource provides the time of a transaction (unix seconds) for freshness and expiry checks. Chaincode must
+// not use the local clock as endorsers would produce different results; all endorsers and validators must
+// obtain the same time for a transaction.
+type TimeSource interface {
+	TxTime(stub shim.ChaincodeStubInterface) (int64, error)
+}
+
+// txTimestampSource uses the transaction timestamp, which the ercc vscc also uses at validation. The timestamp
+// is chosen by the client; endorsers refuse transactions whose timestamp deviates from their clock by more
+// than maxSkew, so clients can not backdate transactions to pass freshness checks.
+type txTimestampSource struct {
+	clock   func() time.Time
+	maxSkew int64
+}
+
+func newTxTimestampSource() *txTimestampSource {
+	maxSkew := int64(defaultMaxClockSkew)
+	if value := os.Getenv(MaxClockSkewEnv); value != "" {
+		skew, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			panic("Invalid " + MaxClockSkewEnv + ": " + err.Error())
+		}
+		maxSkew = skew
+	}
+	return &txTimestampSource{clock: time.Now, maxSkew: maxSkew}
+}
+
+// TxTime returns the transaction timestamp if it is close to the clock of this endorser
+func (s *txTimestampSource) TxTime(stub shim.ChaincodeStubInterface) (int64, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, err
+	}
+
+	if s.maxSkew >= 0 {
+		skew := txTimestamp.Seconds - s.clock().Unix()
+		if skew > s.maxSkew || -skew > s.maxSkew {
+			return 0, fmt.Errorf("Transaction timestamp deviates by %ds from the endorser clock", skew)
+		}
+	}
+	return txTimestamp.Seconds, nil
+}
+
+// timeSource provides the time for all freshness and expiry checks of ercc
+var timeSource TimeSource = newTxTimestampSource()
+
+func getTxTime(stub shim.ChaincodeStubInterface) (int64, error) {
+	return timeSource.TxTime(stub)
+}
@@ -0,0 +1,448 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================
+// setTranscriptConfig -
+// ============================================================
+func (ercc *EnclaveRegistryCC) setTranscriptConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: enabled (true|false)
+	// 1: collection (optional); private data collection to store entries in instead of the public state
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting enabled and optional collection")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enabled, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return shim.Error("Can not parse enabled: " + err.Error())
+	}
+	config := &registry.TranscriptConfig{Enabled: enabled}
+	if len(args) == 2 {
+		config.Collection = args[1]
+	}
+
+	// entries already written stay where they are, thus, moving the transcript would break the chain
+	head, err := getTranscriptHead(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	oldConfig, err := getTranscriptConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if head.Seq > 0 && oldConfig.Collection != config.Collection {
+		return shim.Error("The collection of a non-empty transcript can not change")
+	}
+
+	configAsBytes, err := registry.MarshalCanonical(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := stub.CreateCompositeKey(registry.TranscriptConfigObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, configAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(configAsBytes)
+}
+
+// ============================================================
+// getTranscriptHead -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getTranscriptHead(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	head, err := getTranscriptHead(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	headAsBytes, err := registry.MarshalCanonical(head)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(headAsBytes)
+}
+
+// ============================================================
+// getTranscript -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getTranscript(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: sequence number of the first entry
+	// 1: maximum number of entries (optional)
+	// entries in a private data collection are only returned by peers that are members of the collection
+	fromSeq, limit, err := parseTranscriptRange(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	config, err := getTranscriptConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	head, err := getTranscriptHead(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	page, err := readTranscript(stub, registry.TranscriptObjectType, nil, config.Collection, head, fromSeq, limit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pageAsBytes, err := registry.MarshalCanonical(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pageAsBytes)
+}
+
+// ============================================================
+// importTranscript -
+// ============================================================
+func (ercc *EnclaveRegistryCC) importTranscript(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: entries (json encoded []registry.TranscriptEntry) of another channel, continuing its imported transcript
+	// meant for the ercc of an audit channel; relayers copy the transcripts of application channels
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting transcript entries")
+	}
+
+	var entries []registry.TranscriptEntry
+	if err := json.Unmarshal([]byte(args[0]), &entries); err != nil {
+		return shim.Error("Can not parse transcript entries: " + err.Error())
+	}
+	if len(entries) == 0 {
+		return shim.Error("No transcript entries to import")
+	}
+	channelID := entries[0].ChannelID
+	if channelID == stub.GetChannelID() {
+		return shim.Error("Can not import the transcript of this channel")
+	}
+
+	head, err := getImportedTranscriptHead(stub, channelID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i := range entries {
+		// the entries must continue the hash chain of what has been imported before
+		head, err = head.Append(&entries[i])
+		if err != nil {
+			return shim.Error("Can not import transcript: " + err.Error())
+		}
+		if err := putTranscriptEntry(stub, registry.ImportedTranscriptObjectType, []string{channelID}, "", &entries[i]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	headAsBytes, err := registry.MarshalCanonical(head)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	headKey, err := stub.CreateCompositeKey(registry.ImportedTranscriptHeadObjectType, []string{channelID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(headKey, headAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(headAsBytes)
+}
+
+// ============================================================
+// getImportedTranscriptHead -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getImportedTranscriptHead(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: channel id
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting channel id")
+	}
+
+	head, err := getImportedTranscriptHead(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	headAsBytes, err := registry.MarshalCanonical(head)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(headAsBytes)
+}
+
+// ============================================================
+// getImportedTranscript -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getImportedTranscript(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: channel id
+	// 1: sequence number of the first entry
+	// 2: maximum number of entries (optional)
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting channel id, first entry and optional limit")
+	}
+
+	fromSeq, limit, err := parseTranscriptRange(args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	head, err := getImportedTranscriptHead(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	page, err := readTranscript(stub, registry.ImportedTranscriptObjectType, []string{args[0]}, "", head, fromSeq, limit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pageAsBytes, err := registry.MarshalCanonical(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pageAsBytes)
+}
+
+// parseTranscriptRange parses the first entry and the optional limit of a transcript query
+func parseTranscriptRange(args []string) (uint64, uint64, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return 0, 0, errors.New("Incorrect number of arguments. Expecting first entry and optional limit")
+	}
+
+	fromSeq, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("Can not parse first entry: " + err.Error())
+	}
+	limit := uint64(maxPageSize)
+	if len(args) == 2 {
+		limit, err = strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return 0, 0, errors.New("Can not parse limit: " + err.Error())
+		}
+		if limit == 0 || limit > maxPageSize {
+			limit = maxPageSize
+		}
+	}
+	return fromSeq, limit, nil
+}
+
+// readTranscript returns up to limit entries of a transcript with the given head, starting at fromSeq
+func readTranscript(stub shim.ChaincodeStubInterface, objectType string, attributes []string, collection string, head *registry.TranscriptHead, fromSeq, limit uint64) (*registry.TranscriptPage, error) {
+	page := &registry.TranscriptPage{Entries: []registry.TranscriptEntry{}, Head: *head}
+	for seq := fromSeq; seq < head.Seq && uint64(len(page.Entries)) < limit; seq++ {
+		key, err := stub.CreateCompositeKey(objectType, append(attributes, registry.TranscriptKey(seq)))
+		if err != nil {
+			return nil, err
+		}
+
+		var entryAsBytes []byte
+		if collection != "" {
+			entryAsBytes, err = stub.GetPrivateData(collection, key)
+		} else {
+			entryAsBytes, err = stub.GetState(key)
+		}
+		if err != nil {
+			return nil, err
+		} else if entryAsBytes == nil {
+			return nil, errors.New("Transcript entry " + strconv.FormatUint(seq, 10) + " not found")
+		}
+
+		entry := registry.TranscriptEntry{}
+		if err := json.Unmarshal(entryAsBytes, &entry); err != nil {
+			return nil, err
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	return page, nil
+}
+
+// appendTranscript adds entries to the transcript of the channel if enabled. All entries of a transaction must be
+// appended with a single call, as the transaction does not read its own writes of the head.
+func appendTranscript(stub shim.ChaincodeStubInterface, entries ...*registry.TranscriptEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	config, err := getTranscriptConfig(stub)
+	if err != nil {
+		return err
+	} else if !config.Enabled {
+		return nil
+	}
+	head, err := getTranscriptHead(stub)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entry.ChannelID = stub.GetChannelID()
+		entry.Seq = head.Seq
+		entry.TxID = stub.GetTxID()
+		entry.Timestamp = txTime
+		entry.PrevHash = head.Hash
+
+		head, err = head.Append(entry)
+		if err != nil {
+			return err
+		}
+		if err := putTranscriptEntry(stub, registry.TranscriptObjectType, nil, config.Collection, entry); err != nil {
+			return err
+		}
+	}
+
+	// the head is public even if the entries are not, so every member can check what auditors are shown
+	headAsBytes, err := registry.MarshalCanonical(head)
+	if err != nil {
+		return err
+	}
+	headKey, err := stub.CreateCompositeKey(registry.TranscriptHeadObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(headKey, headAsBytes)
+}
+
+// putTranscriptEntry stores an entry in the public state or, if collection is set, in that collection
+func putTranscriptEntry(stub shim.ChaincodeStubInterface, objectType string, attributes []string, collection string, entry *registry.TranscriptEntry) error {
+	entryAsBytes, err := registry.MarshalCanonical(entry)
+	if err != nil {
+		return err
+	}
+	key, err := stub.CreateCompositeKey(objectType, append(attributes, registry.TranscriptKey(entry.Seq)))
+	if err != nil {
+		return err
+	}
+	if collection != "" {
+		return stub.PutPrivateData(collection, key, entryAsBytes)
+	}
+	return stub.PutState(key, entryAsBytes)
+}
+
+// transcribeRegistration appends the registration or renewal of an enclave to the transcript
+func transcribeRegistration(stub shim.ChaincodeStubInterface, enclavePkHashBase64, enclaveID string, attestationReportAsBytes []byte, renewal bool) error {
+	kind := registry.TranscriptRegistration
+	if renewal {
+		kind = registry.TranscriptRenewal
+	}
+	digest := sha256.Sum256(attestationReportAsBytes)
+	return appendTranscript(stub, &registry.TranscriptEntry{
+		Kind:           kind,
+		EnclavePkHash:  enclavePkHashBase64,
+		EnclaveID:      enclaveID,
+		EvidenceDigest: base64.StdEncoding.EncodeToString(digest[:]),
+	})
+}
+
+// transcribeRevocations appends the revocations among trust changes to the transcript; flagged enclaves stay trusted
+func transcribeRevocations(stub shim.ChaincodeStubInterface, changes []registry.TrustChange) error {
+	var entries []*registry.TranscriptEntry
+	for _, change := range changes {
+		if change.Action != registry.AdvisoryActionRevoke {
+			continue
+		}
+		entries = append(entries, &registry.TranscriptEntry{
+			Kind:          registry.TranscriptRevocation,
+			EnclavePkHash: change.EnclavePkHash,
+			AdvisoryID:    change.AdvisoryID,
+			Description:   change.Description,
+		})
+	}
+	return appendTranscript(stub, entries...)
+}
+
+// getTranscriptConfig returns the transcript configuration of the channel; the transcript is disabled by default
+func getTranscriptConfig(stub shim.ChaincodeStubInterface) (*registry.TranscriptConfig, error) {
+	config := &registry.TranscriptConfig{}
+
+	key, err := stub.CreateCompositeKey(registry.TranscriptConfigObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	configAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if configAsBytes != nil {
+		if err := json.Unmarshal(configAsBytes, config); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// getTranscriptHead returns the head of the transcript of the channel
+func getTranscriptHead(stub shim.ChaincodeStubInterface) (*registry.TranscriptHead, error) {
+	key, err := stub.CreateCompositeKey(registry.TranscriptHeadObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	return loadTranscriptHead(stub, key, stub.GetChannelID())
+}
+
+// getImportedTranscriptHead returns the head of the transcript imported from another channel
+func getImportedTranscriptHead(stub shim.ChaincodeStubInterface, channelID string) (*registry.TranscriptHead, error) {
+	key, err := stub.CreateCompositeKey(registry.ImportedTranscriptHeadObjectType, []string{channelID})
+	if err != nil {
+		return nil, err
+	}
+	return loadTranscriptHead(stub, key, channelID)
+}
+
+// loadTranscriptHead returns the head stored under key, or the head of an empty transcript of channelID
+func loadTranscriptHead(stub shim.ChaincodeStubInterface, key, channelID string) (*registry.TranscriptHead, error) {
+	headAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if headAsBytes == nil {
+		return &registry.TranscriptHead{ChannelID: channelID}, nil
+	}
+
+	head := &registry.TranscriptHead{}
+	if err := json.Unmarshal(headAsBytes, head); err != nil {
+		return nil, err
+	}
+	return head, nil
+}
@@ -0,0 +1,254 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ChannelLedger reports the height and the members of the ledger of the channel of a transaction
+type ChannelLedger interface {
+	// BlockHeight returns the number of the next block
+	BlockHeight(stub shim.ChaincodeStubInterface) (uint64, error)
+	// MSPs returns the ids of the application MSPs of the channel
+	MSPs(stub shim.ChaincodeStubInterface) ([]string, error)
+}
+
+// tlccLedger asks tlcc, which as system chaincode has access to the ledger of the channel
+type tlccLedger struct{}
+
+// BlockHeight invokes GET_BLOCK_HEIGHT of tlcc
+func (l *tlccLedger) BlockHeight(stub shim.ChaincodeStubInterface) (uint64, error) {
+	resp := stub.InvokeChaincode(tlccName, [][]byte{[]byte("GET_BLOCK_HEIGHT")}, stub.GetChannelID())
+	if resp.Status != shim.OK {
+		return 0, errors.New(resp.Message)
+	}
+	return strconv.ParseUint(string(resp.Payload), 10, 64)
+}
+
+// MSPs invokes GET_CHANNEL_MSPS of tlcc. This is the same channel config the enclaves get from tlcc to verify
+// approvals, i.e., "channel_id\nmsp1\nmsp2\n"
+func (l *tlccLedger) MSPs(stub shim.ChaincodeStubInterface) ([]string, error) {
+	resp := stub.InvokeChaincode(tlccName, [][]byte{[]byte("GET_CHANNEL_MSPS")}, stub.GetChannelID())
+	if resp.Status != shim.OK {
+		return nil, errors.New(resp.Message)
+	}
+
+	channelMSPs := struct {
+		Config string `json:"Config"`
+	}{}
+	if err := json.Unmarshal(resp.Payload, &channelMSPs); err != nil {
+		return nil, errors.New("Can not parse channel msps: " + err.Error())
+	}
+	config, err := base64.StdEncoding.DecodeString(channelMSPs.Config)
+	if err != nil {
+		return nil, errors.New("Can not parse channel config: " + err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(config), "\n"), "\n")
+	if lines[0] != stub.GetChannelID() {
+		return nil, errors.New("Channel config of tlcc is for channel " + lines[0])
+	}
+	return lines[1:], nil
+}
+
+// ============================================================
+// addTrustedRoot -
+// ============================================================
+func (ercc *EnclaveRegistryCC) addTrustedRoot(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: vendor, e.g., intel
+	// 1: rootPem (PUBLIC KEY or CERTIFICATE)
+	// 2: effectiveFrom (block height)
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting vendor, root and effectiveFrom")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	effectiveFrom, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return shim.Error("Can not parse effectiveFrom: " + err.Error())
+	}
+	root := registry.TrustedRoot{Vendor: args[0], PEM: args[1], EffectiveFrom: effectiveFrom}
+	publicKey, err := root.PublicKey()
+	if err != nil {
+		return shim.Error("Invalid trusted root: " + err.Error())
+	}
+	if root.ID, err = registry.TrustedRootID(publicKey); err != nil {
+		return shim.Error("Invalid trusted root: " + err.Error())
+	}
+	if err := root.Validate(); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	roots, err := getTrustedRoots(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, r := range roots {
+		if r.ID == root.ID {
+			return shim.Error("Trusted root " + root.ID + " already exists")
+		}
+	}
+	if err := putTrustedRoots(stub, append(roots, root)); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(root.ID))
+}
+
+// ============================================================
+// retireTrustedRoot -
+// ============================================================
+func (ercc *EnclaveRegistryCC) retireTrustedRoot(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: root id
+	// 1: retiredFrom (block height)
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting root id and retiredFrom")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	retiredFrom, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return shim.Error("Can not parse retiredFrom: " + err.Error())
+	}
+
+	roots, err := getTrustedRoots(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i := range roots {
+		if roots[i].ID != args[0] {
+			continue
+		}
+		if roots[i].RetiredFrom != 0 {
+			return shim.Error("Trusted root " + args[0] + " is already retired")
+		}
+		roots[i].RetiredFrom = retiredFrom
+		if err := roots[i].Validate(); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := putTrustedRoots(stub, roots); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+
+	return shim.Error("No trusted root " + args[0])
+}
+
+// ============================================================
+// getTrustedRoots -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getTrustedRoots(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	roots, err := getTrustedRoots(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if roots == nil {
+		roots = []registry.TrustedRoot{}
+	}
+
+	rootsAsBytes, err := registry.MarshalCanonical(roots)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(rootsAsBytes)
+}
+
+// getTrustedRoots returns the governed roots, including retired ones, in the order they were added
+func getTrustedRoots(stub shim.ChaincodeStubInterface) ([]registry.TrustedRoot, error) {
+	key, err := stub.CreateCompositeKey(registry.TrustedRootObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	rootsAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if rootsAsBytes == nil {
+		return nil, nil
+	}
+
+	var roots []registry.TrustedRoot
+	if err := json.Unmarshal(rootsAsBytes, &roots); err != nil {
+		return nil, err
+	}
+	return roots, nil
+}
+
+// putTrustedRoots stores all roots under one key, so the ercc vscc reads them with a single lookup
+func putTrustedRoots(stub shim.ChaincodeStubInterface, roots []registry.TrustedRoot) error {
+	rootsAsBytes, err := registry.MarshalCanonical(roots)
+	if err != nil {
+		return err
+	}
+	key, err := stub.CreateCompositeKey(registry.TrustedRootObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, rootsAsBytes)
+}
+
+// verifyAttestationReport verifies report with the trusted roots effective at the current height of the ledger, or
+// with the built-in verification key as long as no roots are governed, and returns the key that verified it
+func (ercc *EnclaveRegistryCC) verifyAttestationReport(stub shim.ChaincodeStubInterface, report attestation.IASAttestationReport) (interface{}, error) {
+	roots, err := getTrustedRoots(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(roots) == 0 {
+		verificationPK, err := ercc.ias.GetIntelVerificationKey()
+		if err != nil {
+			return nil, errors.New("Can not parse verifiaction key: " + err.Error())
+		}
+		isValid, err := ercc.ra.VerifyAttestionReport(verificationPK, report)
+		if err != nil {
+			return nil, errors.New("Error while attestation report verification: " + err.Error())
+		}
+		if !isValid {
+			return nil, errors.New("Attestation report is not valid")
+		}
+		return verificationPK, nil
+	}
+
+	if ercc.ledger == nil {
+		return nil, errors.New("Block height not available to select trusted roots")
+	}
+	height, err := ercc.ledger.BlockHeight(stub)
+	if err != nil {
+		return nil, errors.New("Can not get block height: " + err.Error())
+	}
+	return registry.VerifyReport(ercc.ra, roots, height, report)
+}
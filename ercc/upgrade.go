@@ -0,0 +1,364 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// UpgradeApproval is the governance entry approving an enclave binary (mrenclave) of a chaincode as successor of
+// another one. The approvals of the admins of a quorum of channel MSPs over the registry.UpgradeStatement let the
+// enclaves verify the upgrade themselves.
+type UpgradeApproval struct {
+	ChaincodeID string               `json:"ChaincodeID"`
+	MrEnclave   string               `json:"MrEnclave"`
+	Successor   string               `json:"Successor"`
+	Approvals   []*registry.Approval `json:"Approvals"`
+}
+
+// StateHandover contains the state encryption key of a retired enclave encrypted to its successor.
+// The encryption key is derived via ECDH from an ephemeral key of the old enclave and the successor key.
+// The old enclave signs the handover (see registry.HandoverMessage); the successor verifies the signature and the
+// upgrade approval before importing the key.
+type StateHandover struct {
+	PredecessorPkHash string               `json:"PredecessorPkHash"`
+	EphemeralPk       []byte               `json:"EphemeralPk"`
+	Ciphertext        []byte               `json:"Ciphertext"`
+	Signature         []byte               `json:"Signature"`
+	Approvals         []*registry.Approval `json:"Approvals"`
+}
+
+// ============================================================
+// approveUpgrade -
+// ============================================================
+func (ercc *EnclaveRegistryCC) approveUpgrade(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeID
+	// 1: mrEnclaveBase64
+	// 2: successorMrEnclaveBase64
+	// 3: approval (json encoded registry.Approval of the registry.UpgradeStatement, signed by the admin)
+	//
+	// Each admin adds the approval of its MSP; the upgrade takes effect once a quorum of channel MSPs approved it
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id, mrenclave, successor mrenclave and approval")
+	}
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	chaincodeID := args[0]
+	if chaincodeID == "" {
+		return shim.Error("Chaincode id must not be empty")
+	}
+
+	for _, mrEnclaveBase64 := range args[1:3] {
+		mrEnclave, err := base64.StdEncoding.DecodeString(mrEnclaveBase64)
+		if err != nil {
+			return shim.Error("Can not parse mrEnclaveBase64: " + err.Error())
+		}
+		if len(mrEnclave) != 32 {
+			return shim.Error("Invalid mrenclave size")
+		}
+	}
+
+	if args[1] == args[2] {
+		return shim.Error("Successor must differ from current mrenclave")
+	}
+
+	statement := registry.UpgradeStatement(stub.GetChannelID(), chaincodeID, args[1], args[2])
+	approval, _, err := ercc.checkChannelApproval(stub, args[3], statement)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(registry.UpgradeObjectType, []string{chaincodeID, args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// approvals of another successor do not count
+	upgrade := &UpgradeApproval{ChaincodeID: chaincodeID, MrEnclave: args[1], Successor: args[2]}
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if existingAsBytes != nil {
+		existing := &UpgradeApproval{}
+		if err := json.Unmarshal(existingAsBytes, existing); err != nil {
+			return shim.Error(err.Error())
+		}
+		if existing.Successor == upgrade.Successor {
+			upgrade.Approvals = existing.Approvals
+		}
+	}
+	upgrade.Approvals = registry.AddApproval(upgrade.Approvals, approval)
+
+	approvalAsBytes, err := registry.MarshalCanonical(upgrade)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := stub.PutState(key, approvalAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getUpgrade -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getUpgrade(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0               1
+	// "chaincodeID", "mrEnclaveBase64"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id and mrenclave")
+	}
+
+	key, err := stub.CreateCompositeKey(registry.UpgradeObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	approvalAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get upgrade approval for " + args[1])
+	} else if approvalAsBytes == nil {
+		return shim.Error("No upgrade approved for " + args[1])
+	}
+
+	return shim.Success(approvalAsBytes)
+}
+
+// ============================================================
+// handoverState -
+// ============================================================
+func (ercc *EnclaveRegistryCC) handoverState(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// args:
+	// 0: chaincodeID
+	// 1: enclavePkHashBase64 (old enclave)
+	// 2: successorPkHashBase64 (new enclave)
+	// 3: mrEnclaveBase64 (old enclave)
+	// 4: ephemeralPkBase64
+	// 5: ciphertextBase64
+	// 6: signatureBase64 (of the old enclave over the handover, see registry.HandoverMessage)
+	if len(args) != 7 {
+		return shim.Error("Incorrect number of arguments. Expecting chaincode id, enclave pk hash, successor pk hash, mrenclave, ephemeral pk, ciphertext and signature")
+	}
+
+	chaincodeID := args[0]
+	enclavePkHashBase64 := args[1]
+	successorPkHashBase64 := args[2]
+	mrEnclaveBase64 := args[3]
+
+	ephemeralPk, err := base64.StdEncoding.DecodeString(args[4])
+	if err != nil {
+		return shim.Error("Can not parse ephemeralPkBase64: " + err.Error())
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(args[5])
+	if err != nil {
+		return shim.Error("Can not parse ciphertextBase64: " + err.Error())
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(args[6])
+	if err != nil {
+		return shim.Error("Can not parse signatureBase64: " + err.Error())
+	}
+
+	// a retired enclave hands over its state only once
+	retiredKey, err := stub.CreateCompositeKey(registry.RetiredObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	retired, err := stub.GetState(retiredKey)
+	if err != nil {
+		return shim.Error("Failed to get state for " + enclavePkHashBase64)
+	} else if retired != nil {
+		return shim.Error("Enclave already retired: " + enclavePkHashBase64)
+	}
+
+	report, err := getAttestationReport(stub, enclavePkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	successorReport, err := getAttestationReport(stub, successorPkHashBase64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// both enclaves must be registered for the chaincode of the upgrade
+	for _, pkHash := range []string{enclavePkHashBase64, successorPkHashBase64} {
+		if err := checkBinding(stub, pkHash, chaincodeID); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// only the old enclave hands over its state and retires itself
+	if err := registry.VerifyHandover(report.EnclavePk, successorReport.EnclavePk, ephemeralPk, ciphertext, signature); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// check the old enclave runs the binary the approval refers to
+	isValid, err := ercc.ra.CheckMrEnclave(mrEnclaveBase64, report)
+	if err != nil {
+		return shim.Error("Error while checking mrenclave: " + err.Error())
+	}
+	if !isValid {
+		return shim.Error("Mrenclave does not match attestation report of enclave")
+	}
+
+	// get approved successor
+	upgradeKey, err := stub.CreateCompositeKey(registry.UpgradeObjectType, []string{chaincodeID, mrEnclaveBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	approvalAsBytes, err := stub.GetState(upgradeKey)
+	if err != nil {
+		return shim.Error("Failed to get upgrade approval for " + mrEnclaveBase64)
+	} else if approvalAsBytes == nil {
+		return shim.Error("No upgrade approved for " + mrEnclaveBase64)
+	}
+	approval := &UpgradeApproval{}
+	if err := json.Unmarshal(approvalAsBytes, approval); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// check a quorum of the channel MSPs approved the upgrade
+	msps, err := ercc.channelMSPs(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	statement := registry.UpgradeStatement(stub.GetChannelID(), chaincodeID, mrEnclaveBase64, approval.Successor)
+	if err := registry.VerifyQuorum(approval.Approvals, statement, msps); err != nil {
+		return shim.Error("Upgrade not approved: " + err.Error())
+	}
+
+	// check the new enclave runs the approved successor binary
+	isValid, err = ercc.ra.CheckMrEnclave(approval.Successor, successorReport)
+	if err != nil {
+		return shim.Error("Error while checking successor mrenclave: " + err.Error())
+	}
+	if !isValid {
+		return shim.Error("Successor is not an approved upgrade")
+	}
+
+	handoverAsBytes, err := registry.MarshalCanonical(&StateHandover{
+		PredecessorPkHash: enclavePkHashBase64,
+		EphemeralPk:       ephemeralPk,
+		Ciphertext:        ciphertext,
+		Signature:         signature,
+		Approvals:         approval.Approvals,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	handoverKey, err := stub.CreateCompositeKey(registry.HandoverObjectType, []string{successorPkHashBase64})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(handoverKey, handoverAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// retire old registration; remember the successor
+	if err := stub.PutState(retiredKey, []byte(successorPkHashBase64)); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================
+// getStateHandover -
+// ============================================================
+func (ercc *EnclaveRegistryCC) getStateHandover(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "successorPkHashBase64"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting successor pk hash")
+	}
+
+	key, err := stub.CreateCompositeKey(registry.HandoverObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	handoverAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get state handover for " + args[0])
+	} else if handoverAsBytes == nil {
+		return shim.Error("State handover does not exist: " + args[0])
+	}
+
+	return shim.Success(handoverAsBytes)
+}
+
+// checkBinding checks that the enclave, if its quote binds channel and chaincode, is bound to chaincodeID on the
+// channel of the transaction
+func checkBinding(stub shim.ChaincodeStubInterface, enclavePkHashBase64, chaincodeID string) error {
+	bindingKey, err := stub.CreateCompositeKey(registry.BindingObjectType, []string{enclavePkHashBase64})
+	if err != nil {
+		return err
+	}
+	bindingAsBytes, err := stub.GetState(bindingKey)
+	if err != nil {
+		return err
+	} else if bindingAsBytes == nil {
+		return nil
+	}
+
+	binding := &attestation.ReportDataBinding{}
+	if err := json.Unmarshal(bindingAsBytes, binding); err != nil {
+		return err
+	}
+	if binding.ChannelID != stub.GetChannelID() || binding.ChaincodeID != chaincodeID {
+		return errors.New("Enclave " + enclavePkHashBase64 + " is not registered for chaincode " + chaincodeID)
+	}
+	return nil
+}
+
+// getAttestationReport returns the attestation report registered for the given enclave pk hash
+func getAttestationReport(stub shim.ChaincodeStubInterface, enclavePkHashBase64 string) (attestation.IASAttestationReport, error) {
+	var report attestation.IASAttestationReport
+
+	reportAsBytes, err := stub.GetState(enclavePkHashBase64)
+	if err != nil {
+		return report, errors.New("Failed to get state for " + enclavePkHashBase64)
+	} else if reportAsBytes == nil {
+		return report, errors.New("EnclavePK does not exist: " + enclavePkHashBase64)
+	}
+
+	reportAsBytes, err = registry.DecompressEvidence(reportAsBytes)
+	if err != nil {
+		return report, errors.New("Can not decompress attestation report: " + err.Error())
+	}
+
+	if err := json.Unmarshal(reportAsBytes, &report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
@@ -29,11 +29,15 @@ import (
 	"github.com/hyperledger/fabric/common/flogging"
 	. "github.com/hyperledger/fabric/core/handlers/validation/api/state"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
 	"github.com/hyperledger/fabric/protos/peer"
 	"github.com/hyperledger/fabric/protos/utils"
 	"github.com/pkg/errors"
 
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/algorithm"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto/consttime"
 	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
 	//"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
 	sgxutil "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
 )
@@ -56,8 +60,8 @@ type VSCCERCC struct {
 }
 
 // Validate validates the given envelope corresponding to a transaction with an endorsement
-// policy as given in its serialized form
-func (vscc *VSCCERCC) Validate(envelopeBytes []byte, policyBytes []byte) commonerrors.TxValidationError {
+// policy as given in its serialized form; blockNum is the number of the block of the transaction
+func (vscc *VSCCERCC) Validate(envelopeBytes []byte, policyBytes []byte, blockNum uint64) commonerrors.TxValidationError {
 	// get the envelope...
 	env, err := utils.GetEnvelopeFromBlock(envelopeBytes)
 	if err != nil {
@@ -72,6 +76,13 @@ func (vscc *VSCCERCC) Validate(envelopeBytes []byte, policyBytes []byte) commone
 		return policyErr(err)
 	}
 
+	// ...and the channel header for the binding of quotes...
+	chdr, err := utils.UnmarshalChannelHeader(payl.Header.ChannelHeader)
+	if err != nil {
+		logger.Errorf("ERCC-VSCC error: UnmarshalChannelHeader failed, err %s", err)
+		return policyErr(err)
+	}
+
 	// ...and the transaction...
 	tx, err := utils.GetTransaction(payl.Data)
 	if err != nil {
@@ -100,7 +111,7 @@ func (vscc *VSCCERCC) Validate(envelopeBytes []byte, policyBytes []byte) commone
 			return policyErr(err)
 		}
 
-		err = vscc.checkAttestation(ccAction)
+		err = vscc.checkAttestation(ccAction, chdr.TxId, chdr.ChannelId, chdr.Timestamp.GetSeconds(), blockNum)
 		if err != nil {
 			logger.Errorf("VSCC error: checkAttestation failed, err %s", err)
 			return policyErr(err)
@@ -109,7 +120,7 @@ func (vscc *VSCCERCC) Validate(envelopeBytes []byte, policyBytes []byte) commone
 	return nil
 }
 
-func (t *VSCCERCC) checkAttestation(respPayload *peer.ChaincodeAction) error {
+func (t *VSCCERCC) checkAttestation(respPayload *peer.ChaincodeAction, txID, channelID string, txTime int64, blockNum uint64) error {
 	logger.Debug("checkEnclaveEndorsement starts")
 
 	var err error
@@ -127,48 +138,101 @@ func (t *VSCCERCC) checkAttestation(respPayload *peer.ChaincodeAction) error {
 			continue
 		}
 
-		writes := ns.KvRwSet.Writes
-		if len(writes) != 1 {
-			return errors.New("Expected one write")
+		write, compositeWrites, err := splitWrites(ns.KvRwSet)
+		if err != nil {
+			return err
+		}
+		if write == nil {
+			continue
 		}
-		write := writes[0]
 
 		logger.Debugf("checkEnclaveEndorsement info: validating key %s", write.Key)
 
+		// attestation evidence is stored compressed
+		attestationReportAsBytes, err := registry.DecompressEvidence(write.Value)
+		if err != nil {
+			return fmt.Errorf("Decompression of attestation report failed, err %s", err)
+		}
+
 		attestationReport := attestation.IASAttestationReport{}
-		err = json.Unmarshal(write.Value, &attestationReport)
+		err = json.Unmarshal(attestationReportAsBytes, &attestationReport)
 		if err != nil {
 			return fmt.Errorf("txRWSet.Unmarshal failed, err %s", err)
 		}
 
-		// transform INTEL pk to DER format
-		block, _ := pem.Decode([]byte(attestation.IntelPubPEM))
-
-		// transform sig-pk from attestation report to DER format
-		verificationPK, err := x509.ParsePKIXPublicKey(block.Bytes)
+		channelState, err := t.sf.FetchState()
 		if err != nil {
-			return fmt.Errorf("x509.ParsePKIXPublicKey failed, err: %s", err)
+			return fmt.Errorf("Fetch channel state failed, err %s", err)
 		}
+		defer channelState.Done()
+
+		state := &state{channelState}
 
-		// verify attestation report
-		isValid, err := t.ra.VerifyAttestionReport(verificationPK, attestationReport)
+		// roots governed in the registry take effect at a block height; until there are any, the INTEL pk is used
+		rootsAsBytes, err := state.GetState("ercc", registry.CompositeKey(registry.TrustedRootObjectType))
 		if err != nil {
-			return fmt.Errorf("VerifyAttestionReport failed, err %s", err)
+			return fmt.Errorf("Fetch trusted roots failed, err %s", err)
 		}
-		if !isValid {
-			return errors.New("Attestation report is not valid")
+		if rootsAsBytes == nil {
+			// transform INTEL pk to DER format
+			block, _ := pem.Decode([]byte(attestation.IntelPubPEM))
+
+			// transform sig-pk from attestation report to DER format
+			verificationPK, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("x509.ParsePKIXPublicKey failed, err: %s", err)
+			}
+
+			// verify attestation report
+			isValid, err := t.ra.VerifyAttestionReport(verificationPK, attestationReport)
+			if err != nil {
+				return fmt.Errorf("VerifyAttestionReport failed, err %s", err)
+			}
+			if !isValid {
+				return errors.New("Attestation report is not valid")
+			}
+		} else {
+			var roots []registry.TrustedRoot
+			if err := json.Unmarshal(rootsAsBytes, &roots); err != nil {
+				return fmt.Errorf("Unmarshalling of trusted roots failed, err %s", err)
+			}
+			if _, err := registry.VerifyReport(t.ra, roots, blockNum, attestationReport); err != nil {
+				return fmt.Errorf("VerifyAttestionReport failed, err %s", err)
+			}
 		}
 		logger.Debugf("Attestation valid!")
 
 		// verify write.Key
 		enclavePkHash := sha256.Sum256(attestationReport.EnclavePk)
-		if write.Key != base64.StdEncoding.EncodeToString(enclavePkHash[:]) {
+		if !consttime.EqualString(write.Key, base64.StdEncoding.EncodeToString(enclavePkHash[:])) {
 			return errors.New("Error: write.Key does not match enclave public key hash from attestation")
 		}
 		logger.Debugf("write.Key correct!")
 
+		// quotes registered with registerBoundEnclave also bind this transaction, channel and chaincode
+		var binding *attestation.ReportDataBinding
+		if bindingAsBytes, ok := compositeWrites[registry.CompositeKey(registry.BindingObjectType, write.Key)]; ok {
+			binding = &attestation.ReportDataBinding{}
+			if err := json.Unmarshal(bindingAsBytes, binding); err != nil {
+				return fmt.Errorf("Unmarshalling of binding failed, err %s", err)
+			}
+			if binding.ChannelID != channelID {
+				return errors.New("Binding does not match channel")
+			}
+			if !consttime.Equal(binding.Nonce, attestation.TxNonce(txID)) {
+				// quotes submitted with submitQuote bind the submitting transaction
+				submissionTxID, err := getSubmissionTxID(state, write.Key)
+				if err != nil {
+					return err
+				}
+				if submissionTxID == "" || !consttime.Equal(binding.Nonce, attestation.TxNonce(submissionTxID)) {
+					return errors.New("Binding does not match transaction")
+				}
+			}
+		}
+
 		// verify that pk attestation report matches the one in the quote
-		isValid, err = t.ra.CheckEnclavePkHash(attestationReport.EnclavePk, attestationReport)
+		isValid, err := t.ra.CheckReportData(attestationReport.EnclavePk, binding, attestationReport)
 		if err != nil {
 			return fmt.Errorf("Error while checking enclave PK: %s", err)
 		}
@@ -177,39 +241,209 @@ func (t *VSCCERCC) checkAttestation(respPayload *peer.ChaincodeAction) error {
 		}
 		logger.Debugf("Enclave PK matches attestation report!")
 
-		channelState, err := t.sf.FetchState()
-		if err != nil {
-			return fmt.Errorf("Fetch channel state failed, err %s", err)
+		// recorded algorithms must be those of the enclave key
+		if algorithmsAsBytes, ok := compositeWrites[registry.CompositeKey(registry.AlgorithmsObjectType, write.Key)]; ok {
+			algorithms := &algorithm.Algorithms{}
+			if err := json.Unmarshal(algorithmsAsBytes, algorithms); err != nil {
+				return fmt.Errorf("Unmarshalling of algorithms failed, err %s", err)
+			}
+			if err := algorithm.CheckKey(algorithms, attestationReport.EnclavePk); err != nil {
+				return fmt.Errorf("Algorithms do not match enclave key, err %s", err)
+			}
 		}
-		defer channelState.Done()
 
-		state := &state{channelState}
-		// get mrenclave from ledger
-		// FIXME: remove hardcoding of those strings
-		mrenclave, err := state.GetState("ecc", sgxutil.MrEnclaveStateKey)
+		// all peers evaluate the registration against the attestation policy agreed on in the registry, as ercc does
+		policyAsBytes, err := state.GetState("ercc", registry.CompositeKey(registry.AttestationPolicyObjectType))
 		if err != nil {
-			return errors.New("mrenclave does not exist")
+			return fmt.Errorf("Fetch attestation policy failed, err %s", err)
 		}
-		if mrenclave == nil {
-			return errors.New("mrenclave is empty")
+		var policy *registry.AttestationPolicy
+		if policyAsBytes != nil {
+			policy = &registry.AttestationPolicy{}
+			if err := json.Unmarshal(policyAsBytes, policy); err != nil {
+				return fmt.Errorf("Unmarshalling of attestation policy failed, err %s", err)
+			}
 		}
-		logger.Debugf("mrenclave from ecc: %s", mrenclave)
-
-		// check mrenclave
-		matches, err := t.ra.CheckMrEnclave(string(mrenclave), attestationReport)
+		quote, err := registry.CheckReport(policy, attestationReport, txTime)
 		if err != nil {
-			return fmt.Errorf("Error while attestation report verification: %s", err)
+			return fmt.Errorf("Attestation policy violated: %s", err)
+		}
+		if policy != nil {
+			if quote.IsDebug() {
+				logger.Warningf("Accepting DEBUG enclave with mrenclave %s as attestation policy version %d allows debug enclaves",
+					registry.MrEnclave(quote), policy.Version)
+			}
+			logger.Debugf("Attestation report satisfies attestation policy version %d", policy.Version)
+		}
+
+		// an enclave bound to a chaincode must run the mrenclave the chaincode pinned, if any
+		if binding != nil && binding.ChaincodeID != "" {
+			if err := t.checkPinnedMrEnclave(txRWSet, state, binding.ChaincodeID, attestationReport); err != nil {
+				return err
+			}
 		}
-		if !matches {
-			logger.Errorf("Expected MRENCLAVE: %s", string(mrenclave))
-			return errors.New("Attestation report does not match MRENCLAVE!")
+	}
+
+	return nil
+}
+
+// checkPinnedMrEnclave checks the attestation report against the mrenclave pinned in the namespace of chaincode
+// chaincodeID. The chaincode pins it when it sets up its enclave, i.e., usually in the very transaction that
+// registers the enclave, thus, a write of the transaction takes precedence over the committed state. Chaincodes that
+// pinned none rely on the attestation policy alone.
+func (t *VSCCERCC) checkPinnedMrEnclave(txRWSet *rwsetutil.TxRwSet, state *state, chaincodeID string, report attestation.IASAttestationReport) error {
+	mrenclave, ok := writtenValue(txRWSet, chaincodeID, sgxutil.MrEnclaveStateKey)
+	if !ok {
+		var err error
+		if mrenclave, err = state.GetState(chaincodeID, sgxutil.MrEnclaveStateKey); err != nil {
+			return fmt.Errorf("Fetch mrenclave of chaincode %s failed, err %s", chaincodeID, err)
 		}
-		logger.Debugf("mrenclave matches attestation report!")
 	}
+	if mrenclave == nil {
+		return nil
+	}
+	logger.Debugf("mrenclave from %s: %s", chaincodeID, mrenclave)
 
+	matches, err := t.ra.CheckMrEnclave(string(mrenclave), report)
+	if err != nil {
+		return fmt.Errorf("Error while attestation report verification: %s", err)
+	}
+	if !matches {
+		logger.Errorf("Expected MRENCLAVE: %s", string(mrenclave))
+		return fmt.Errorf("Attestation report does not match MRENCLAVE of chaincode %s", chaincodeID)
+	}
+	logger.Debugf("mrenclave matches attestation report!")
 	return nil
 }
 
+// writtenValue returns the value the transaction writes to key in namespace, and whether it writes the key
+func writtenValue(txRWSet *rwsetutil.TxRwSet, namespace, key string) ([]byte, bool) {
+	for _, ns := range txRWSet.NsRwSets {
+		if ns.NameSpace != namespace {
+			continue
+		}
+		for _, w := range ns.KvRwSet.Writes {
+			if w.Key == key {
+				if w.IsDelete {
+					return nil, true
+				}
+				return w.Value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// objectTypes are the types of the registry entries ercc stores under composite keys; writes to any other composite
+// key are rejected
+var objectTypes = map[string]bool{
+	registry.RetiredObjectType:                true,
+	registry.MinIsvSvnObjectType:              true,
+	registry.QuoteObjectType:                  true,
+	registry.StatusObjectType:                 true,
+	registry.AdvisoryObjectType:               true,
+	registry.IdentityObjectType:               true,
+	registry.IdentityByPkObjectType:           true,
+	registry.QuorumObjectType:                 true,
+	registry.PendingObjectType:                true,
+	registry.QuotaObjectType:                  true,
+	registry.UsageObjectType:                  true,
+	registry.RegistrarSignatureObjectType:     true,
+	registry.BindingObjectType:                true,
+	registry.PsePolicyObjectType:              true,
+	registry.PlatformObjectType:               true,
+	registry.AttestationPolicyObjectType:      true,
+	registry.SubmissionObjectType:             true,
+	registry.SubmissionEvidenceObjectType:     true,
+	registry.ChaincodeEnclaveObjectType:       true,
+	registry.TombstoneObjectType:              true,
+	registry.CollateralObjectType:             true,
+	registry.CRLObjectType:                    true,
+	registry.ReplayObjectType:                 true,
+	registry.StateCommitmentObjectType:        true,
+	registry.RegistrantObjectType:             true,
+	registry.ACLObjectType:                    true,
+	registry.TrustedRootObjectType:            true,
+	registry.DelegationObjectType:             true,
+	registry.ReEncryptionKeyObjectType:        true,
+	registry.DelegatedSecretObjectType:        true,
+	registry.EscrowPolicyObjectType:           true,
+	registry.EscrowObjectType:                 true,
+	registry.RecoveryShareObjectType:          true,
+	registry.AlgorithmsObjectType:             true,
+	registry.AlgorithmPolicyObjectType:        true,
+	registry.TranscriptConfigObjectType:       true,
+	registry.TranscriptObjectType:             true,
+	registry.TranscriptHeadObjectType:         true,
+	registry.ImportedTranscriptObjectType:     true,
+	registry.ImportedTranscriptHeadObjectType: true,
+	registry.ForeignChannelsObjectType:        true,
+	registry.ForeignEnclaveObjectType:         true,
+	registry.LedgerClockObjectType:            true,
+	registry.SecretObjectType:                 true,
+	registry.ClientKeyObjectType:              true,
+	registry.UpgradeObjectType:                true,
+	registry.HandoverObjectType:               true,
+}
+
+// splitWrites returns the registration written by the transaction, if any, and the writes of registry entries
+// stored under composite keys (e.g., provisioned secrets), which do not carry attestation evidence and are only
+// subject to the default vscc. A transaction registers at most one enclave. Registrations are only deleted when
+// pruned, which leaves a tombstone in their place; one transaction may prune several.
+func splitWrites(kvRwSet *kvrwset.KVRWSet) (*kvrwset.KVWrite, map[string][]byte, error) {
+	var writes []*kvrwset.KVWrite
+	var deletes []*kvrwset.KVWrite
+	compositeWrites := make(map[string][]byte)
+	for _, w := range kvRwSet.Writes {
+		if registry.IsCompositeKey(w.Key) {
+			objectType, _, err := registry.SplitCompositeKey(w.Key)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !objectTypes[objectType] {
+				return nil, nil, fmt.Errorf("Write to composite key of unknown object type %q", objectType)
+			}
+			compositeWrites[w.Key] = w.Value
+		} else if w.IsDelete {
+			deletes = append(deletes, w)
+		} else {
+			writes = append(writes, w)
+		}
+	}
+
+	for _, d := range deletes {
+		tombstone, ok := compositeWrites[registry.CompositeKey(registry.TombstoneObjectType, d.Key)]
+		if !ok || tombstone == nil {
+			return nil, nil, fmt.Errorf("Registration %s deleted without tombstone", d.Key)
+		}
+	}
+
+	if len(writes) == 0 {
+		return nil, compositeWrites, nil
+	}
+	if len(writes) != 1 {
+		return nil, nil, errors.New("Expected one write")
+	}
+	return writes[0], compositeWrites, nil
+}
+
+// getSubmissionTxID returns the id of the transaction that submitted the quote of a two-phase registration,
+// or the empty string if there is none
+func getSubmissionTxID(state *state, enclavePkHash string) (string, error) {
+	submissionAsBytes, err := state.GetState("ercc", registry.CompositeKey(registry.SubmissionObjectType, enclavePkHash))
+	if err != nil {
+		return "", fmt.Errorf("Fetch quote submission failed, err %s", err)
+	} else if submissionAsBytes == nil {
+		return "", nil
+	}
+
+	submission := &registry.QuoteSubmission{}
+	if err := json.Unmarshal(submissionAsBytes, submission); err != nil {
+		return "", fmt.Errorf("Unmarshalling of quote submission failed, err %s", err)
+	}
+	return submission.TxID, nil
+}
+
 type state struct {
 	State
 }
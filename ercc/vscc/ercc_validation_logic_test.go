@@ -0,0 +1,124 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/registry"
+	sgxutil "github.com/hyperledger-labs/fabric-secure-chaincode/utils"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+)
+
+// pruneWrites returns the writes of pruneRegistry removing the registration of enclavePkHash
+func pruneWrites(enclavePkHash string) []*kvrwset.KVWrite {
+	return []*kvrwset.KVWrite{
+		{Key: registry.CompositeKey(registry.StatusObjectType, enclavePkHash), IsDelete: true},
+		{Key: registry.CompositeKey(registry.BindingObjectType, enclavePkHash), IsDelete: true},
+		{Key: enclavePkHash, IsDelete: true},
+		{Key: registry.CompositeKey(registry.TombstoneObjectType, enclavePkHash), Value: []byte(`{"EnclavePkHash":"` + enclavePkHash + `"}`)},
+	}
+}
+
+func TestSplitWrites_Prune(t *testing.T) {
+	kvRwSet := &kvrwset.KVRWSet{}
+	kvRwSet.Writes = append(kvRwSet.Writes, pruneWrites("pkHash1")...)
+	kvRwSet.Writes = append(kvRwSet.Writes, pruneWrites("pkHash2")...)
+
+	write, compositeWrites, err := splitWrites(kvRwSet)
+	if err != nil {
+		t.Fatalf("Prune write set should be accepted: %s", err)
+	}
+	if write != nil {
+		t.Fatalf("Prune write set registers no enclave but got %s", write.Key)
+	}
+	if len(compositeWrites) != 6 {
+		t.Fatalf("Expected 6 composite writes but got %d", len(compositeWrites))
+	}
+}
+
+func TestSplitWrites_DeleteWithoutTombstone(t *testing.T) {
+	kvRwSet := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "pkHash1", IsDelete: true}}}
+	if _, _, err := splitWrites(kvRwSet); err == nil {
+		t.Fatalf("Deleting a registration without tombstone should fail")
+	}
+
+	// the tombstone must belong to the deleted registration
+	kvRwSet.Writes = append(kvRwSet.Writes, &kvrwset.KVWrite{Key: registry.CompositeKey(registry.TombstoneObjectType, "pkHash2"), Value: []byte("{}")})
+	if _, _, err := splitWrites(kvRwSet); err == nil {
+		t.Fatalf("Deleting a registration with the tombstone of another one should fail")
+	}
+}
+
+func TestSplitWrites_Registration(t *testing.T) {
+	bindingKey := registry.CompositeKey(registry.BindingObjectType, "pkHash1")
+	kvRwSet := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{
+		{Key: "pkHash1", Value: []byte("evidence")},
+		{Key: bindingKey, Value: []byte("{}")},
+	}}
+
+	write, compositeWrites, err := splitWrites(kvRwSet)
+	if err != nil {
+		t.Fatalf("Registration should be accepted: %s", err)
+	}
+	if write == nil || write.Key != "pkHash1" {
+		t.Fatalf("Expected registration of pkHash1 but got %v", write)
+	}
+	if _, ok := compositeWrites[bindingKey]; !ok {
+		t.Fatalf("Binding missing in composite writes")
+	}
+
+	// one registration per transaction
+	kvRwSet.Writes = append(kvRwSet.Writes, &kvrwset.KVWrite{Key: "pkHash2", Value: []byte("evidence")})
+	if _, _, err := splitWrites(kvRwSet); err == nil {
+		t.Fatalf("Two registrations in one transaction should fail")
+	}
+}
+
+func TestSplitWrites_UnknownObjectType(t *testing.T) {
+	kvRwSet := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{
+		{Key: registry.CompositeKey(registry.SecretObjectType, "pkHash1", "apiKey"), Value: []byte("{}")},
+	}}
+	if _, _, err := splitWrites(kvRwSet); err != nil {
+		t.Fatalf("Write of a provisioned secret should be accepted: %s", err)
+	}
+
+	kvRwSet.Writes = append(kvRwSet.Writes, &kvrwset.KVWrite{Key: registry.CompositeKey("unknown", "pkHash1"), Value: []byte("{}")})
+	if _, _, err := splitWrites(kvRwSet); err == nil {
+		t.Fatalf("Write to a composite key of an unknown object type should fail")
+	}
+}
+
+func TestWrittenValue(t *testing.T) {
+	txRWSet := &rwsetutil.TxRwSet{NsRwSets: []*rwsetutil.NsRwSet{
+		{NameSpace: "ercc", KvRwSet: &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "pkHash1", Value: []byte("evidence")}}}},
+		{NameSpace: "mycc", KvRwSet: &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: sgxutil.MrEnclaveStateKey, Value: []byte("mrenclave")}}}},
+	}}
+
+	// the mrenclave pinned by the bound chaincode in the registering transaction
+	if value, ok := writtenValue(txRWSet, "mycc", sgxutil.MrEnclaveStateKey); !ok || string(value) != "mrenclave" {
+		t.Fatalf("Expected mrenclave written by mycc but got %q", value)
+	}
+	// not by another chaincode
+	if _, ok := writtenValue(txRWSet, "othercc", sgxutil.MrEnclaveStateKey); ok {
+		t.Fatalf("othercc writes no mrenclave")
+	}
+	if _, ok := writtenValue(txRWSet, "ercc", sgxutil.MrEnclaveStateKey); ok {
+		t.Fatalf("ercc writes no mrenclave")
+	}
+}
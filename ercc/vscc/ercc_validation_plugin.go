@@ -47,7 +47,7 @@ type ERCCValidation struct {
 
 //go:generate mockery -dir . -name TransactionValidator -case underscore -output mocks/
 type TransactionValidator interface {
-	Validate(txData []byte, policy []byte) commonerrors.TxValidationError
+	Validate(txData []byte, policy []byte, blockNum uint64) commonerrors.TxValidationError
 }
 
 func (v *ERCCValidation) Validate(block *common.Block, namespace string, txPosition int, actionPosition int, contextData ...validation.ContextDatum) error {
@@ -77,7 +77,7 @@ func (v *ERCCValidation) Validate(block *common.Block, namespace string, txPosit
 	}
 
 	// do ercc-vscc
-	err = v.ERCCTxValidator.Validate(block.Data.Data[txPosition], serializedPolicy.Bytes())
+	err = v.ERCCTxValidator.Validate(block.Data.Data[txPosition], serializedPolicy.Bytes(), block.Header.Number)
 	logger.Debugf("block %d, namespace: %s, tx %d validation results is: %v", block.Header.Number, namespace, txPosition, err)
 	return convertErrorTypeOrPanic(err)
 
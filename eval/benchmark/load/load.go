@@ -0,0 +1,125 @@
+// Package load generates load on an operation with an executor and summarizes throughput and latencies. It is
+// used to drive the secure path, e.g., report verification and argument encryption, with many concurrent
+// clients to catch performance regressions that single-threaded benchmarks do not show.
+package load
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/eval/benchmark/executor"
+)
+
+// Operation is invoked once per invocation; i is the number of the invocation
+type Operation func(i int) error
+
+// Summary of a load run
+type Summary struct {
+	Name        string
+	Workers     uint16
+	Invocations int
+	Failed      int
+	Duration    time.Duration
+	// latencies of all invocations in ascending order
+	latencies []time.Duration
+	// first error seen, if any
+	firstErr error
+}
+
+type task struct {
+	i         int
+	operation Operation
+	callback  func(latency time.Duration, err error)
+}
+
+func (t *task) Invoke() {
+	start := time.Now()
+	err := t.operation(t.i)
+	t.callback(time.Since(start), err)
+}
+
+// Run invokes operation the given number of times with the given number of concurrent workers
+func Run(name string, workers uint16, invocations int, operation Operation) (*Summary, error) {
+	e := executor.NewConcurrent(name, workers)
+	e.Start()
+	defer e.Stop(true)
+
+	summary := &Summary{Name: name, Workers: workers, Invocations: invocations}
+	summary.latencies = make([]time.Duration, 0, invocations)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	callback := func(latency time.Duration, err error) {
+		defer wg.Done()
+		mutex.Lock()
+		defer mutex.Unlock()
+		summary.latencies = append(summary.latencies, latency)
+		if err != nil {
+			summary.Failed++
+			if summary.firstErr == nil {
+				summary.firstErr = err
+			}
+		}
+	}
+
+	wg.Add(invocations)
+	startTime := time.Now()
+	for i := 0; i < invocations; i++ {
+		if err := e.Submit(&task{i: i, operation: operation, callback: callback}); err != nil {
+			return nil, fmt.Errorf("error submitting task: %s", err)
+		}
+	}
+	wg.Wait()
+	summary.Duration = time.Since(startTime)
+
+	sort.Slice(summary.latencies, func(i, j int) bool { return summary.latencies[i] < summary.latencies[j] })
+	return summary, nil
+}
+
+// Rate returns the invocations per second
+func (s *Summary) Rate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Invocations) / s.Duration.Seconds()
+}
+
+// Percentile returns the latency below which p percent of the invocations completed
+func (s *Summary) Percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(s.latencies))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s.latencies) {
+		i = len(s.latencies) - 1
+	}
+	return s.latencies[i]
+}
+
+// Err returns the first error of a failed invocation, if any
+func (s *Summary) Err() error {
+	return s.firstErr
+}
+
+// Print writes the summary in the format of the executor tests
+func (s *Summary) Print(w io.Writer) {
+	fmt.Fprintf(w, "*** ---------- Summary: %s ----------\n", s.Name)
+	fmt.Fprintf(w, "***   - Workers:         %d\n", s.Workers)
+	fmt.Fprintf(w, "***   - Invocations:     %d\n", s.Invocations)
+	fmt.Fprintf(w, "***   - Successfull:     %d\n", s.Invocations-s.Failed)
+	fmt.Fprintf(w, "***   - Duration:        %s\n", s.Duration)
+	fmt.Fprintf(w, "***   - Rate:            %2.2f/s\n", s.Rate())
+	fmt.Fprintf(w, "***   - Latency p50:     %s\n", s.Percentile(50))
+	fmt.Fprintf(w, "***   - Latency p99:     %s\n", s.Percentile(99))
+	fmt.Fprintf(w, "***   - Latency max:     %s\n", s.Percentile(100))
+	if s.firstErr != nil {
+		fmt.Fprintf(w, "***   - First error:     %s\n", s.firstErr)
+	}
+	fmt.Fprintf(w, "*** ------------------------------\n")
+}
@@ -0,0 +1,54 @@
+package load
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	var mutex sync.Mutex
+	seen := make(map[int]bool)
+
+	summary, err := Run("test", 4, 100, func(i int) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		seen[i] = true
+		if i%10 == 0 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run returned error: %s", err)
+	}
+	if len(seen) != 100 {
+		t.Fatalf("Expected 100 distinct invocations but got %d", len(seen))
+	}
+	if summary.Failed != 10 || summary.Err() == nil {
+		t.Fatalf("Expected 10 failed invocations but got %d (%v)", summary.Failed, summary.Err())
+	}
+	if summary.Percentile(50) > summary.Percentile(99) || summary.Percentile(99) > summary.Percentile(100) {
+		t.Fatalf("Percentiles out of order")
+	}
+}
+
+func TestSummary_Percentile(t *testing.T) {
+	summary := &Summary{}
+	for i := 1; i <= 100; i++ {
+		summary.latencies = append(summary.latencies, time.Duration(i)*time.Millisecond)
+	}
+	if p := summary.Percentile(50); p != 50*time.Millisecond {
+		t.Fatalf("Expected p50 of 50ms but got %s", p)
+	}
+	if p := summary.Percentile(99); p != 99*time.Millisecond {
+		t.Fatalf("Expected p99 of 99ms but got %s", p)
+	}
+	if p := summary.Percentile(100); p != 100*time.Millisecond {
+		t.Fatalf("Expected max of 100ms but got %s", p)
+	}
+	if p := (&Summary{}).Percentile(50); p != 0 {
+		t.Fatalf("Expected 0 without invocations but got %s", p)
+	}
+}
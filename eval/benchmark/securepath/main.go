@@ -0,0 +1,148 @@
+// Command securepath generates load on the attestation and crypto hot paths of fabric secure chaincode, i.e.,
+// attestation report verification, quote parsing and argument encryption, and prints throughput and latencies.
+// ECALL round-trips require an enclave and are measured by the benchmarks in ecc/enclave.
+//
+//	go run ./eval/benchmark/securepath -workload verify -workers 8 -n 10000
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ecc/crypto"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/ercc/attestation/mock"
+	"github.com/hyperledger-labs/fabric-secure-chaincode/eval/benchmark/load"
+)
+
+// workloads create the operation of a workload
+var workloads = map[string]func() (load.Operation, error){
+	"verify":  verifyWorkload,
+	"quote":   quoteWorkload,
+	"encrypt": encryptWorkload,
+}
+
+func main() {
+	workload := flag.String("workload", "all", "workload to run: all, "+strings.Join(workloadNames(), ", "))
+	workers := flag.Uint("workers", 8, "number of concurrent workers")
+	invocations := flag.Int("n", 10000, "number of invocations per workload")
+	flag.Parse()
+
+	names := []string{*workload}
+	if *workload == "all" {
+		names = workloadNames()
+	}
+
+	failed := false
+	for _, name := range names {
+		newOperation, ok := workloads[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown workload %s\n", name)
+			os.Exit(2)
+		}
+		operation, err := newOperation()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can not set up workload %s: %s\n", name, err)
+			os.Exit(1)
+		}
+		summary, err := load.Run(name, uint16(*workers), *invocations, operation)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Workload %s failed: %s\n", name, err)
+			os.Exit(1)
+		}
+		summary.Print(os.Stdout)
+		failed = failed || summary.Failed > 0
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func workloadNames() []string {
+	var names []string
+	for name := range workloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// signedReport returns an attestation report of the mock enclave signed by a SigningIAS
+func signedReport() (attestation.IASAttestationReport, interface{}, []byte, *attestation.ReportDataBinding, error) {
+	ias, err := mock.NewSigningIAS()
+	if err != nil {
+		return attestation.IASAttestationReport{}, nil, nil, nil, err
+	}
+	_, pkBytes, err := mock.EnclaveKey()
+	if err != nil {
+		return attestation.IASAttestationReport{}, nil, nil, nil, err
+	}
+	binding := &attestation.ReportDataBinding{Nonce: attestation.TxNonce("tx1"), ChannelID: "mychannel", ChaincodeID: "ecc"}
+	quote, err := mock.NewQuote(pkBytes, binding, mock.MrEnclave, 1)
+	if err != nil {
+		return attestation.IASAttestationReport{}, nil, nil, nil, err
+	}
+	report, err := ias.RequestAttestationReport(tls.Certificate{}, quote)
+	if err != nil {
+		return attestation.IASAttestationReport{}, nil, nil, nil, err
+	}
+	verificationPK, err := ias.GetIntelVerificationKey()
+	if err != nil {
+		return attestation.IASAttestationReport{}, nil, nil, nil, err
+	}
+	return report, verificationPK, pkBytes, binding, nil
+}
+
+// verifyWorkload verifies an attestation report as ercc does on registration
+func verifyWorkload() (load.Operation, error) {
+	report, verificationPK, pkBytes, binding, err := signedReport()
+	if err != nil {
+		return nil, err
+	}
+	verifier := &attestation.VerifierImpl{}
+	return func(int) error {
+		if ok, err := verifier.VerifyAttestionReport(verificationPK, report); !ok {
+			return fmt.Errorf("report not valid: %v", err)
+		}
+		if ok, err := verifier.CheckReportData(pkBytes, binding, report); !ok {
+			return fmt.Errorf("report data does not match: %v", err)
+		}
+		return nil
+	}, nil
+}
+
+// quoteWorkload parses a quote and the quote reported by IAS
+func quoteWorkload() (load.Operation, error) {
+	report, _, pkBytes, binding, err := signedReport()
+	if err != nil {
+		return nil, err
+	}
+	quote, err := mock.NewQuote(pkBytes, binding, mock.MrEnclave, 1)
+	if err != nil {
+		return nil, err
+	}
+	return func(int) error {
+		if _, err := attestation.ParseQuote(quote); err != nil {
+			return err
+		}
+		_, err := attestation.QuoteFromAttestionReport(report)
+		return err
+	}, nil
+}
+
+// encryptWorkload encrypts transaction arguments for the enclave as the client does
+func encryptWorkload() (load.Operation, error) {
+	_, pkBytes, err := mock.EnclaveKey()
+	if err != nil {
+		return nil, err
+	}
+	return func(i int) error {
+		args := fmt.Sprintf(`["submit","MyAuction123","Bob","%d"]`, i)
+		_, _, err := crypto.EncryptForEnclave([]byte(args), pkBytes)
+		return err
+	}, nil
+}
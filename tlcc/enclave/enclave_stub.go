@@ -17,6 +17,7 @@
 package enclave
 
 import (
+	"fmt"
 	"unsafe"
 
 	"github.com/hyperledger/fabric/common/flogging"
@@ -37,6 +38,8 @@ const PUB_KEY_SIZE = 64
 const REPORT_SIZE = 432
 const TARGET_INFO_SIZE = 512
 const CMAC_SIZE = 16
+const MAX_MSP_ROOTS_SIZE = 16384
+const NONCE_SIZE = 32
 
 var logger = flogging.MustGetLogger("tl-enclave")
 
@@ -58,6 +61,13 @@ type Stub interface {
 	NextBlock(blockBytes []byte) error
 	// verifies state and returns cmac
 	GetStateMetadata(key string, nonce []byte, isRangeQuery bool) ([]byte, error)
+	// returns the CA certificates of an MSP of the channel as PEM bundle and their cmac
+	GetMSPRoots(mspID string, nonce []byte) ([]byte, []byte, error)
+	// returns the channel id and the MSP IDs of the application orgs of the channel, each terminated by a newline,
+	// and their cmac
+	GetChannelMSPs(nonce []byte) ([]byte, []byte, error)
+	// returns block height and ledger time (seconds since the epoch) and their cmac
+	GetLedgerTime(nonce []byte) (uint64, int64, []byte, error)
 	// Destroys enclave
 	Destroy() error
 }
@@ -168,6 +178,84 @@ func (e *StubImpl) GetStateMetadata(key string, nonce []byte, isRangeQuery bool)
 	return C.GoBytes(cmacPtr, C.int(CMAC_SIZE)), nil
 }
 
+func (e *StubImpl) GetMSPRoots(mspID string, nonce []byte) ([]byte, []byte, error) {
+	// msp id
+	mspIDc := C.CString(mspID)
+	defer C.free(unsafe.Pointer(mspIDc))
+
+	// nonce
+	noncePtr := C.CBytes(nonce)
+	defer C.free(noncePtr)
+
+	// roots
+	rootsPtr := C.malloc(MAX_MSP_ROOTS_SIZE)
+	defer C.free(rootsPtr)
+	rootsLen := C.uint32_t(0)
+
+	// cmac
+	cmac := make([]byte, CMAC_SIZE)
+	cmacPtr := C.CBytes(cmac)
+	defer C.free(cmacPtr)
+
+	ret := C.tlcc_get_msp_roots(e.eid, mspIDc,
+		(*C.uint8_t)(noncePtr),
+		(*C.uint8_t)(rootsPtr), MAX_MSP_ROOTS_SIZE, &rootsLen,
+		(*C.cmac_t)(cmacPtr))
+	if ret != 0 {
+		return nil, nil, fmt.Errorf("can not get roots of MSP %s: %d", mspID, ret)
+	}
+	return C.GoBytes(rootsPtr, C.int(rootsLen)), C.GoBytes(cmacPtr, C.int(CMAC_SIZE)), nil
+}
+
+func (e *StubImpl) GetChannelMSPs(nonce []byte) ([]byte, []byte, error) {
+	// the enclave reads a nonce of NONCE_SIZE bytes
+	if len(nonce) != NONCE_SIZE {
+		nonce = make([]byte, NONCE_SIZE)
+	}
+	noncePtr := C.CBytes(nonce)
+	defer C.free(noncePtr)
+
+	// config
+	configPtr := C.malloc(MAX_MSP_ROOTS_SIZE)
+	defer C.free(configPtr)
+	configLen := C.uint32_t(0)
+
+	// cmac
+	cmac := make([]byte, CMAC_SIZE)
+	cmacPtr := C.CBytes(cmac)
+	defer C.free(cmacPtr)
+
+	ret := C.tlcc_get_channel_msps(e.eid,
+		(*C.uint8_t)(noncePtr),
+		(*C.uint8_t)(configPtr), MAX_MSP_ROOTS_SIZE, &configLen,
+		(*C.cmac_t)(cmacPtr))
+	if ret != 0 {
+		return nil, nil, fmt.Errorf("can not get channel MSPs: %d", ret)
+	}
+	return C.GoBytes(configPtr, C.int(configLen)), C.GoBytes(cmacPtr, C.int(CMAC_SIZE)), nil
+}
+
+func (e *StubImpl) GetLedgerTime(nonce []byte) (uint64, int64, []byte, error) {
+	if len(nonce) != NONCE_SIZE {
+		return 0, 0, nil, fmt.Errorf("nonce must be %d bytes", NONCE_SIZE)
+	}
+	noncePtr := C.CBytes(nonce)
+	defer C.free(noncePtr)
+
+	// cmac
+	cmac := make([]byte, CMAC_SIZE)
+	cmacPtr := C.CBytes(cmac)
+	defer C.free(cmacPtr)
+
+	height := C.uint64_t(0)
+	ledgerTime := C.int64_t(0)
+	ret := C.tlcc_get_ledger_time(e.eid, (*C.uint8_t)(noncePtr), &height, &ledgerTime, (*C.cmac_t)(cmacPtr))
+	if ret != 0 {
+		return 0, 0, nil, fmt.Errorf("can not get ledger time: %d", ret)
+	}
+	return uint64(height), int64(ledgerTime), C.GoBytes(cmacPtr, C.int(CMAC_SIZE)), nil
+}
+
 // Create starts a new enclave instance
 func (e *StubImpl) Create(enclaveLibFile string) error {
 	var eid C.enclave_id_t
@@ -29,6 +29,11 @@ func (m *MockStub) GetLocalAttestationReport(targetInfo []byte) ([]byte, []byte,
 	return []byte{}, []byte{}, nil
 }
 
+// returns block height and ledger time and their cmac
+func (m *MockStub) GetLedgerTime(nonce []byte) (uint64, int64, []byte, error) {
+	return 0, 0, []byte{}, nil
+}
+
 // Creates an enclave from a given enclave lib file
 func (m *MockStub) Create(enclaveLibFile string) error {
 	return nil
@@ -49,6 +54,16 @@ func (m *MockStub) GetStateMetadata(key string, nonce []byte, isRangeQuery bool)
 	return []byte{}, nil
 }
 
+// returns the CA certificates of an MSP of the channel and their cmac
+func (m *MockStub) GetMSPRoots(mspID string, nonce []byte) ([]byte, []byte, error) {
+	return []byte{}, []byte{}, nil
+}
+
+// returns the channel id and the MSP IDs of the application orgs of the channel and their cmac
+func (m *MockStub) GetChannelMSPs(nonce []byte) ([]byte, []byte, error) {
+	return []byte("mychannel\nOrg1MSP\n"), []byte{}, nil
+}
+
 // Destroys enclave
 func (m *MockStub) Destroy() error {
 	return nil
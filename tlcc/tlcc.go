@@ -28,6 +28,7 @@ import (
 	"github.com/hyperledger/fabric/core/peer"
 	"github.com/hyperledger/fabric/protos/common"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
 
 	"github.com/hyperledger-labs/fabric-secure-chaincode/tlcc/enclave"
 )
@@ -58,6 +59,16 @@ func (t *TrustedLedgerCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return t.getStateMetadata(stub)
 	} else if function == "JOIN_CHANNEL" {
 		return t.joinChannel(stub)
+	} else if function == "CHECK_POLICY" {
+		return t.checkPolicy(stub)
+	} else if function == "GET_BLOCK_HEIGHT" {
+		return t.getBlockHeight(stub)
+	} else if function == "GET_MSP_ROOTS" {
+		return t.getMSPRoots(stub)
+	} else if function == "GET_CHANNEL_MSPS" {
+		return t.getChannelMSPs(stub)
+	} else if function == "GET_LEDGER_TIME" {
+		return t.getLedgerTime(stub)
 	}
 
 	jsonResp := "{\"Error\":\" Received unknown function invocation: " + function + "\"}"
@@ -136,6 +147,144 @@ func (t *TrustedLedgerCC) joinChannel(stub shim.ChaincodeStubInterface) pb.Respo
 	return shim.Success([]byte("Channel joined"))
 }
 
+// checkPolicy evaluates the signed proposal of the transaction against a policy of the channel configuration, e.g.,
+// /Channel/Application/Writers, the same way as the resource ACLs of the peer. Other chaincodes, such as ercc, call
+// it to reuse the policies of the channel.
+func (t *TrustedLedgerCC) checkPolicy(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting policy name")
+	}
+	policyName := args[1]
+
+	policyManager := peer.GetPolicyManager(stub.GetChannelID())
+	if policyManager == nil {
+		return shim.Error(fmt.Sprintf("No policies for channel %s", stub.GetChannelID()))
+	}
+	policy, ok := policyManager.GetPolicy(policyName)
+	if !ok {
+		return shim.Error(fmt.Sprintf("Unknown policy %s", policyName))
+	}
+
+	signedProposal, err := stub.GetSignedProposal()
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can not get signed proposal: %s", err))
+	}
+	proposal, err := utils.GetProposal(signedProposal.ProposalBytes)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can not parse proposal: %s", err))
+	}
+	header, err := utils.GetHeader(proposal.Header)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can not parse proposal header: %s", err))
+	}
+	signatureHeader, err := utils.GetSignatureHeader(header.SignatureHeader)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can not parse signature header: %s", err))
+	}
+
+	signedData := []*common.SignedData{{
+		Data:      signedProposal.ProposalBytes,
+		Identity:  signatureHeader.Creator,
+		Signature: signedProposal.Signature,
+	}}
+	if err := policy.Evaluate(signedData); err != nil {
+		return shim.Error(fmt.Sprintf("Policy %s not satisfied: %s", policyName, err))
+	}
+	return shim.Success(nil)
+}
+
+// getBlockHeight returns the height of the ledger of the channel, i.e., the number of the next block, as decimal
+// string. ercc uses it to select the trusted roots effective at the block a registration will be committed in.
+func (t *TrustedLedgerCC) getBlockHeight(stub shim.ChaincodeStubInterface) pb.Response {
+	channelName := stub.GetChannelID()
+
+	ledger := peer.GetLedger(channelName)
+	if ledger == nil {
+		return shim.Error(fmt.Sprintf("Cannot open %s ledger", channelName))
+	}
+	info, err := ledger.GetBlockchainInfo()
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can not get blockchain info: %s", err))
+	}
+	return shim.Success([]byte(strconv.FormatUint(info.Height, 10)))
+}
+
+// getMSPRoots returns the CA certificates of an MSP of the channel as PEM bundle, as of the latest config block
+// the enclave has validated, along with a cmac the chaincode enclave verifies them with. ecc enclaves validate
+// the certificates of clients against them.
+func (t *TrustedLedgerCC) getMSPRoots(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting MSP ID and nonce")
+	}
+	nonce, err := base64.StdEncoding.DecodeString(args[2])
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can not parse nonce %s", err))
+	}
+
+	roots, cmac, err := t.enclave.GetMSPRoots(args[1], nonce)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("GetMSPRoots returns error: %s", err))
+	}
+
+	rootsBase64 := base64.StdEncoding.EncodeToString(roots)
+	cmacBase64 := base64.StdEncoding.EncodeToString(cmac)
+	jsonResp := "{\"Roots\":\"" + rootsBase64 + "\", \"CMAC\": \"" + cmacBase64 + "\"}"
+	return shim.Success([]byte(jsonResp))
+}
+
+// getChannelMSPs returns the channel id followed by the MSP IDs of the application orgs of the channel, each
+// terminated by a newline, as of the latest config block the enclave has validated, along with a cmac the chaincode
+// enclave verifies them with. ecc enclaves and ercc require governance approvals of a quorum of these MSPs. The
+// nonce is optional, ercc does not verify the cmac.
+func (t *TrustedLedgerCC) getChannelMSPs(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting nonce")
+	}
+	var nonce []byte
+	if len(args) == 2 {
+		var err error
+		if nonce, err = base64.StdEncoding.DecodeString(args[1]); err != nil {
+			return shim.Error(fmt.Sprintf("Can not parse nonce %s", err))
+		}
+	}
+
+	config, cmac, err := t.enclave.GetChannelMSPs(nonce)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("GetChannelMSPs returns error: %s", err))
+	}
+
+	configBase64 := base64.StdEncoding.EncodeToString(config)
+	cmacBase64 := base64.StdEncoding.EncodeToString(cmac)
+	jsonResp := "{\"Config\":\"" + configBase64 + "\", \"CMAC\": \"" + cmacBase64 + "\"}"
+	return shim.Success([]byte(jsonResp))
+}
+
+// getLedgerTime returns the block height and the ledger time, i.e., the median of the transaction timestamps of
+// the latest block the enclave has validated (never decreasing), along with a cmac over them and the nonce of the
+// chaincode enclave. ecc enclaves use it as trusted time.
+func (t *TrustedLedgerCC) getLedgerTime(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting nonce")
+	}
+	nonce, err := base64.StdEncoding.DecodeString(args[1])
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can not parse nonce %s", err))
+	}
+
+	height, ledgerTime, cmac, err := t.enclave.GetLedgerTime(nonce)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("GetLedgerTime returns error: %s", err))
+	}
+
+	cmacBase64 := base64.StdEncoding.EncodeToString(cmac)
+	jsonResp := fmt.Sprintf("{\"Height\": %d, \"Time\": %d, \"CMAC\": \"%s\"}", height, ledgerTime, cmacBase64)
+	return shim.Success([]byte(jsonResp))
+}
+
 // helper to read all blocks from the ledger and pass them to the enclave
 func (t *TrustedLedgerCC) readBlocks(iter ledger.ResultsIterator) {
 	for {
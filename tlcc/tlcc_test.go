@@ -18,8 +18,10 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -96,6 +98,106 @@ func TestTrustedLedgerCC_GetStateCMAC(t *testing.T) {
 	fmt.Println("CMAC: " + string(res.Payload))
 }
 
+func TestTrustedLedgerCC_GetMSPRoots(t *testing.T) {
+	tlcc := createTlcc()
+	stub := shim.NewMockStub("tlcc", tlcc)
+	stub.ChannelID = "mychannel"
+
+	setupTestLedger("mychannel")
+	th.CheckInit(t, stub, [][]byte{})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("JOIN_CHANNEL"), []byte("mychannel")})
+
+	nonce := []byte(base64.StdEncoding.EncodeToString([]byte("moin")))
+
+	args := [][]byte{[]byte("GET_MSP_ROOTS"), []byte("Org1MSP"), nonce}
+	res := stub.MockInvoke("1", args)
+	if res.Status != shim.OK {
+		fmt.Println("Invoke", args, "failed", string(res.Message))
+		t.FailNow()
+	}
+
+	var resp struct {
+		Roots string
+		CMAC  string
+	}
+	if err := json.Unmarshal(res.Payload, &resp); err != nil {
+		t.Fatalf("Invalid response %s: %s", res.Payload, err)
+	}
+
+	// nonce is missing
+	res = stub.MockInvoke("1", [][]byte{[]byte("GET_MSP_ROOTS"), []byte("Org1MSP")})
+	if res.Status == shim.OK {
+		t.Fatalf("GET_MSP_ROOTS without nonce should fail")
+	}
+}
+
+func TestTrustedLedgerCC_GetChannelMSPs(t *testing.T) {
+	tlcc := createTlcc()
+	stub := shim.NewMockStub("tlcc", tlcc)
+	stub.ChannelID = "mychannel"
+
+	setupTestLedger("mychannel")
+	th.CheckInit(t, stub, [][]byte{})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("JOIN_CHANNEL"), []byte("mychannel")})
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("GET_CHANNEL_MSPS")})
+	if res.Status != shim.OK {
+		t.Fatalf("GET_CHANNEL_MSPS failed: %s", res.Message)
+	}
+
+	var resp struct {
+		Config string
+		CMAC   string
+	}
+	if err := json.Unmarshal(res.Payload, &resp); err != nil {
+		t.Fatalf("Invalid response %s: %s", res.Payload, err)
+	}
+	config, err := base64.StdEncoding.DecodeString(resp.Config)
+	if err != nil || !strings.HasPrefix(string(config), "mychannel\n") {
+		t.Fatalf("Expected the channel id first, got %q", config)
+	}
+
+	// the nonce must be base64
+	res = stub.MockInvoke("1", [][]byte{[]byte("GET_CHANNEL_MSPS"), []byte("!")})
+	if res.Status == shim.OK {
+		t.Fatalf("GET_CHANNEL_MSPS with invalid nonce should fail")
+	}
+}
+
+func TestTrustedLedgerCC_GetLedgerTime(t *testing.T) {
+	tlcc := createTlcc()
+	stub := shim.NewMockStub("tlcc", tlcc)
+	stub.ChannelID = "mychannel"
+
+	setupTestLedger("mychannel")
+	th.CheckInit(t, stub, [][]byte{})
+	th.CheckInvoke(t, stub, [][]byte{[]byte("JOIN_CHANNEL"), []byte("mychannel")})
+
+	nonce := []byte(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+
+	args := [][]byte{[]byte("GET_LEDGER_TIME"), nonce}
+	res := stub.MockInvoke("1", args)
+	if res.Status != shim.OK {
+		fmt.Println("Invoke", args, "failed", string(res.Message))
+		t.FailNow()
+	}
+
+	var resp struct {
+		Height uint64
+		Time   int64
+		CMAC   string
+	}
+	if err := json.Unmarshal(res.Payload, &resp); err != nil {
+		t.Fatalf("Invalid response %s: %s", res.Payload, err)
+	}
+
+	// nonce is missing
+	res = stub.MockInvoke("1", [][]byte{[]byte("GET_LEDGER_TIME")})
+	if res.Status == shim.OK {
+		t.Fatalf("GET_LEDGER_TIME without nonce should fail")
+	}
+}
+
 func TestLoadPlugin(t *testing.T) {
 	th.CheckLoadPlugin(t, "tlcc.so")
 }
@@ -105,6 +105,117 @@ int ecall_get_multi_state_metadata(
     return SGX_SUCCESS;
 }
 
+// MSP roots are authenticated under a key that can not start a valid UTF-8 ledger key, so their
+// cmac never collides with the cmac of a state
+#define MSP_ROOTS_CMAC_PREFIX "\xff" "msp."
+
+int ecall_get_msp_roots(const char *msp_id, uint8_t *nonce, uint8_t *roots, uint32_t max_roots_len,
+    uint32_t *roots_len, sgx_cmac_128bit_tag_t *cmac)
+{
+    std::string bundle;
+    int ret = ledger_get_msp_roots(msp_id, bundle);
+    if (ret != LEDGER_SUCCESS) {
+        return ret;
+    }
+    if (bundle.size() > max_roots_len) {
+        LOG_ERROR("Roots of %s exceed output buffer", msp_id);
+        return LEDGER_ERROR_OUT_BUFFER_TOO_SMALL;
+    }
+    memcpy(roots, bundle.c_str(), bundle.size());
+    *roots_len = bundle.size();
+
+    sgx_sha256_hash_t roots_hash = {0};
+    sgx_sha256_msg((const uint8_t *)bundle.c_str(), bundle.size(), &roots_hash);
+
+    // hash( prefix || msp_id || nonce || roots_hash )
+    std::string kkey = std::string(MSP_ROOTS_CMAC_PREFIX) + msp_id;
+    sgx_cmac_state_handle_t cmac_handle;
+    sgx_cmac128_init(&session_key, &cmac_handle);
+    sgx_cmac128_update((const uint8_t *)kkey.c_str(), kkey.size(), cmac_handle);
+    // TODO use the nonce
+    /* sgx_cmac128_update(nonce, 32, cmac_handle); */
+    sgx_cmac128_update(roots_hash, sizeof(sgx_sha256_hash_t), cmac_handle);
+    sgx_cmac128_final(cmac_handle, cmac);
+    sgx_cmac128_close(cmac_handle);
+
+    return SGX_SUCCESS;
+}
+
+// the channel config is authenticated under a key that can not start a valid UTF-8 ledger key, see
+// MSP_ROOTS_CMAC_PREFIX
+#define CHANNEL_MSPS_CMAC_KEY "\xff" "channel"
+
+// returns the channel id followed by the MSP IDs of the application orgs of the channel, each
+// terminated by a newline; chaincode enclaves require approvals of a quorum of them
+int ecall_get_channel_msps(uint8_t *nonce, uint8_t *config, uint32_t max_config_len,
+    uint32_t *config_len, sgx_cmac_128bit_tag_t *cmac)
+{
+    std::string channel_id;
+    std::vector<std::string> msps;
+    int ret = ledger_get_channel_msps(channel_id, msps);
+    if (ret != LEDGER_SUCCESS) {
+        return ret;
+    }
+    std::string out = channel_id + "\n";
+    for (auto &msp : msps) {
+        out += msp + "\n";
+    }
+    if (out.size() > max_config_len) {
+        LOG_ERROR("Channel MSPs exceed output buffer");
+        return LEDGER_ERROR_OUT_BUFFER_TOO_SMALL;
+    }
+    memcpy(config, out.c_str(), out.size());
+    *config_len = out.size();
+
+    sgx_sha256_hash_t config_hash = {0};
+    sgx_sha256_msg((const uint8_t *)out.c_str(), out.size(), &config_hash);
+
+    // hash( key || config_hash ), like the MSP roots
+    sgx_cmac_state_handle_t cmac_handle;
+    sgx_cmac128_init(&session_key, &cmac_handle);
+    sgx_cmac128_update(
+        (const uint8_t *)CHANNEL_MSPS_CMAC_KEY, strlen(CHANNEL_MSPS_CMAC_KEY), cmac_handle);
+    // TODO use the nonce
+    /* sgx_cmac128_update(nonce, 32, cmac_handle); */
+    sgx_cmac128_update(config_hash, sizeof(sgx_sha256_hash_t), cmac_handle);
+    sgx_cmac128_final(cmac_handle, cmac);
+    sgx_cmac128_close(cmac_handle);
+
+    return SGX_SUCCESS;
+}
+
+// the ledger time is authenticated under a key that can not start a valid UTF-8 ledger key, see
+// MSP_ROOTS_CMAC_PREFIX
+#define LEDGER_TIME_CMAC_KEY "\xff" "time"
+
+int ecall_get_ledger_time(uint8_t *nonce, uint64_t *height, int64_t *time, sgx_cmac_128bit_tag_t *cmac)
+{
+    int ret = ledger_get_time(height, time);
+    if (ret != LEDGER_SUCCESS) {
+        return ret;
+    }
+
+    // unlike state, the time must be fresh, so the cmac covers the nonce of the chaincode enclave:
+    // hash( key || hash( nonce || height || time ) )
+    sgx_sha256_hash_t time_hash = {0};
+    sgx_sha_state_handle_t sha_handle;
+    sgx_sha256_init(&sha_handle);
+    sgx_sha256_update(nonce, 32, sha_handle);
+    sgx_sha256_update((const uint8_t *)height, sizeof(*height), sha_handle);
+    sgx_sha256_update((const uint8_t *)time, sizeof(*time), sha_handle);
+    sgx_sha256_get_hash(sha_handle, &time_hash);
+    sgx_sha256_close(sha_handle);
+
+    sgx_cmac_state_handle_t cmac_handle;
+    sgx_cmac128_init(&session_key, &cmac_handle);
+    sgx_cmac128_update((const uint8_t *)LEDGER_TIME_CMAC_KEY, strlen(LEDGER_TIME_CMAC_KEY), cmac_handle);
+    sgx_cmac128_update(time_hash, sizeof(sgx_sha256_hash_t), cmac_handle);
+    sgx_cmac128_final(cmac_handle, cmac);
+    sgx_cmac128_close(cmac_handle);
+
+    return SGX_SUCCESS;
+}
+
 int ecall_print_state()
 {
     return print_state();
@@ -15,6 +15,8 @@
  */
 
 #include "ledger.h"
+#include <algorithm>
+#include <vector>
 
 // proto
 #include <pb_decode.h>
@@ -25,6 +27,8 @@
 #include "ledger/rwset/rwset.pb.h"
 #include "msp/identities.pb.h"
 #include "msp/msp_config.pb.h"
+#include "orderer/configuration.pb.h"
+#include "orderer/etcdraft/configuration.pb.h"
 #include "peer/proposal.pb.h"
 #include "peer/proposal_response.pb.h"
 #include "peer/transaction.pb.h"
@@ -46,11 +50,27 @@ static X509_STORE* root_certs_orderer = NULL;
 // root cert store for application orgs
 static X509_STORE* root_certs_apps = NULL;
 
+// CA certificates (PEM) of each MSP of the channel by MSP ID, as of the latest config block
+static std::map<std::string, std::string> msp_roots;
+// id of the channel and MSP IDs of its application orgs, as of the latest config block
+static std::string channel_id;
+static std::set<std::string> application_msps;
+
 static kvs_t state;      // blockchain state
 static spinlock_t lock;  // state lock
 
 static uint32_t sequence_number = -1;  // sequence number counter
 
+// ledger time in seconds since the epoch: the median of the timestamps of the valid transactions of a block,
+// never decreasing from one block to the next. Blocks carry no time of their own; transaction timestamps are
+// set by clients, the median bounds the influence of a minority of clients with wrong clocks.
+static int64_t ledger_time = 0;
+
+// ordering service of the channel as defined by the ConsensusType of the orderer group; the type determines
+// how many orderer signatures a block needs, see required_block_signatures
+static std::string consensus_type = "solo";
+static uint32_t consenter_count = 0;
+
 int init_ledger()
 {
     LOG_DEBUG("Ledger: ########## init ledger  ##########");
@@ -97,7 +117,11 @@ int parse_block(uint8_t* block_data, uint32_t block_data_len)
     pb_bytes_array_t* metadata_bytes =
         block.metadata.metadata[common_BlockMetadataIndex_SIGNATURES];
 
-    // decode metadata
+    // distinct orderer identities with a valid signature over the block
+    std::set<std::string> signers;
+
+    // decode metadata; the value is opaque and only covered by the signatures. with kafka and solo it is empty,
+    // with raft and BFT orderers it carries the consenter metadata (fabric 2.x: OrdererBlockMetadata)
     common_Metadata metadata = common_Metadata_init_zero;
     decode_pb(metadata, common_Metadata_fields, metadata_bytes->bytes, metadata_bytes->size);
     {
@@ -140,6 +164,9 @@ int parse_block(uint8_t* block_data, uint32_t block_data_len)
                 LOG_ERROR("Ledger: Block signature valudation failed");
             } else {
                 LOG_DEBUG("Ledger: \t\\-> Valid block cert");
+                // a consenter counts once, no matter how often it signed
+                signers.insert(std::string(identity.mspid) +
+                               std::string((const char*)identity.id_bytes->bytes, identity.id_bytes->size));
             }
 
             pb_release(msp_SerializedIdentity_fields, &identity);
@@ -149,6 +176,22 @@ int parse_block(uint8_t* block_data, uint32_t block_data_len)
     pb_release(common_Metadata_fields, &metadata);
     free(header_DER);
 
+    // the genesis block is not signed; the consensus type is known once its config has been parsed
+    if (block_sequence_number > 0) {
+        int required = required_block_signatures();
+        if (required < 0) {
+            LOG_ERROR("Ledger: Unsupported consensus type: %s", consensus_type.c_str());
+            pb_release(common_Block_fields, &block);
+            return LEDGER_UNSUPPORTED_CONSENSUS;
+        }
+        if (signers.size() < (size_t)required) {
+            LOG_ERROR("Ledger: Block %d has %d valid orderer signatures, %s requires %d",
+                block_sequence_number, (int)signers.size(), consensus_type.c_str(), required);
+            pb_release(common_Block_fields, &block);
+            return LEDGER_INVALID_BLOCK_SIGNATURES;
+        }
+    }
+
     // prepare tx filter
     pb_bytes_array_t* tx_filter_pb =
         block.metadata.metadata[common_BlockMetadataIndex_TRANSACTIONS_FILTER];
@@ -159,6 +202,8 @@ int parse_block(uint8_t* block_data, uint32_t block_data_len)
 
     // prepare updates/write set for this block
     kvs_t updates;
+    // timestamps of the valid transactions
+    std::vector<int64_t> timestamps;
 
     // go through all envelopes/transactions (block.data)
     for (uint64_t i = 0; i < block.data.data_count; i++) {
@@ -244,12 +289,19 @@ int parse_block(uint8_t* block_data, uint32_t block_data_len)
             free(tx_id);
         }
 
+        if (chdr.has_timestamp) {
+            timestamps.push_back(chdr.timestamp.seconds);
+        }
+
         spin_lock(&lock);
         switch (chdr.type) {
             case common_HeaderType_CONFIG:
                 if (block.header.number == 0) {
                     // note that we currently do not support config updates
                     // (genesis only)
+                    if (chdr.channel_id != NULL) {
+                        channel_id = chdr.channel_id;
+                    }
                     parse_config(payload.data->bytes, payload.data->size);
                 }
                 break;
@@ -272,6 +324,16 @@ int parse_block(uint8_t* block_data, uint32_t block_data_len)
 
     pb_release(common_Block_fields, &block);
 
+    if (!timestamps.empty()) {
+        std::sort(timestamps.begin(), timestamps.end());
+        int64_t block_time = timestamps[timestamps.size() / 2];
+        spin_lock(&lock);
+        if (block_time > ledger_time) {
+            ledger_time = block_time;
+        }
+        spin_unlock(&lock);
+    }
+
     // commit updates/writeset
     commit_state_updates(&updates, block_sequence_number);
 
@@ -300,6 +362,10 @@ int parse_config(uint8_t* config_data, uint32_t config_data_len)
     common_ConfigEnvelope config_envelope = common_ConfigEnvelope_init_zero;
     decode_pb(config_envelope, common_ConfigEnvelope_fields, config_data, config_data_len);
 
+    // the config envelope carries the full channel config, so the MSPs it lists replace all known ones
+    std::map<std::string, std::string> roots;
+    std::set<std::string> apps;
+
     LOG_DEBUG("Ledger: ConfigEnv.config.ChannelGroup.Groups:");
     for (int i = 0; i < config_envelope.config.channel_group.groups_count; i++) {
         char* group = config_envelope.config.channel_group.groups[i].key;
@@ -316,12 +382,30 @@ int parse_config(uint8_t* config_data, uint32_t config_data_len)
             LOG_ERROR("Ledger: Unknown channel group: %s", group);
         }
 
+        // consensus type of the ordering service
+        if (strcmp(group, "Orderer") == 0) {
+            for (int v = 0; v < groups->values_count; v++) {
+                if (strcmp(groups->values[v].key, "ConsensusType") == 0) {
+                    common_ConfigValue* value = &groups->values[v].value;
+                    int ret = parse_consensus_type(value->value->bytes, value->value->size);
+                    if (ret != LEDGER_SUCCESS) {
+                        pb_release(common_ConfigEnvelope_fields, &config_envelope);
+                        return ret;
+                    }
+                }
+            }
+        }
+
         // go through
         for (int j = 0; j < groups->groups_count; j++) {
             common_ConfigGroup* orgs = &groups->groups[j].value;
             LOG_DEBUG("Ledger: \t\tOrg: %s", groups->groups[j].key);
 
             for (int h = 0; h < orgs->values_count; h++) {
+                // orgs have further values, e.g., the orderer endpoints of raft orderer orgs
+                if (strcmp(orgs->values[h].key, "MSP") != 0) {
+                    continue;
+                }
                 common_ConfigValue* msp = &orgs->values[h].value;
                 msp_MSPConfig msp_config = msp_MSPConfig_init_zero;
                 decode_pb(msp_config, msp_MSPConfig_fields, msp->value->bytes, msp->value->size);
@@ -331,6 +415,9 @@ int parse_config(uint8_t* config_data, uint32_t config_data_len)
                 decode_pb(fabric_msp_config, msp_FabricMSPConfig_fields, msp_config.config->bytes,
                     msp_config.config->size);
                 LOG_DEBUG("Ledger: \t\t\tMSP Config: %s", fabric_msp_config.name);
+                if (root_certs == root_certs_apps) {
+                    apps.insert(fabric_msp_config.name);
+                }
 
                 LOG_DEBUG("Ledger: \t\t\t\\-> Root certs: %d", fabric_msp_config.root_certs_count);
                 for (int r = 0; r < fabric_msp_config.root_certs_count; r++) {
@@ -340,6 +427,21 @@ int parse_config(uint8_t* config_data, uint32_t config_data_len)
                     }
                 }
 
+                // CA certificates of the msp, served to chaincode enclaves to validate clients
+                std::string& bundle = roots[fabric_msp_config.name];
+                for (int r = 0; r < fabric_msp_config.root_certs_count; r++) {
+                    bundle.append((const char*)fabric_msp_config.root_certs[r]->bytes,
+                        fabric_msp_config.root_certs[r]->size);
+                    bundle.append("\n");
+                }
+                LOG_DEBUG("Ledger: \t\t\t\\-> Intermediate certs: %d",
+                    fabric_msp_config.intermediate_certs_count);
+                for (int r = 0; r < fabric_msp_config.intermediate_certs_count; r++) {
+                    bundle.append((const char*)fabric_msp_config.intermediate_certs[r]->bytes,
+                        fabric_msp_config.intermediate_certs[r]->size);
+                    bundle.append("\n");
+                }
+
                 LOG_DEBUG("Ledger: \t\t\t\\-> Admin certs: %d", fabric_msp_config.admins_count);
                 for (int r = 0; r < fabric_msp_config.admins_count; r++) {
                     if (validate_cert(fabric_msp_config.admins[r]->bytes,
@@ -355,9 +457,53 @@ int parse_config(uint8_t* config_data, uint32_t config_data_len)
     }
     pb_release(common_ConfigEnvelope_fields, &config_envelope);
 
+    // parse_block holds the lock
+    msp_roots.swap(roots);
+    application_msps.swap(apps);
+
     return LEDGER_SUCCESS;
 }
 
+int parse_consensus_type(uint8_t* value, uint32_t value_len)
+{
+    orderer_ConsensusType type = orderer_ConsensusType_init_zero;
+    decode_pb(type, orderer_ConsensusType_fields, value, value_len);
+    consensus_type = type.type;
+    consenter_count = 0;
+
+    if (consensus_type == "etcdraft" && type.metadata != NULL) {
+        etcdraft_ConfigMetadata raft_metadata = etcdraft_ConfigMetadata_init_zero;
+        decode_pb(raft_metadata, etcdraft_ConfigMetadata_fields, type.metadata->bytes,
+            type.metadata->size);
+        consenter_count = raft_metadata.consenters_count;
+        pb_release(etcdraft_ConfigMetadata_fields, &raft_metadata);
+    }
+    LOG_DEBUG("Ledger: \t\\-> Consensus type: %s, consenters: %d", consensus_type.c_str(),
+        consenter_count);
+
+    pb_release(orderer_ConsensusType_fields, &type);
+    return LEDGER_SUCCESS;
+}
+
+// required_block_signatures returns the number of distinct orderers that must sign a block, or -1 if the
+// consensus type is not supported
+int required_block_signatures()
+{
+    // crash fault tolerant orderers, including the raft leader, sign blocks alone
+    if (consensus_type == "solo" || consensus_type == "kafka" || consensus_type == "etcdraft") {
+        return 1;
+    }
+
+    // BFT orderers sign with a quorum of ceil((n + f + 1) / 2) consenters, f = (n - 1) / 3. The consenter
+    // set and the signature format of BFT blocks (identifier headers) are defined by fabric 3 protos, which
+    // are not part of the fabric 1.4 protos this enclave is built with; until then BFT channels are rejected.
+    if (consensus_type == "BFT" && consenter_count > 0) {
+        uint32_t f = (consenter_count - 1) / 3;
+        return (consenter_count + f + 2) / 2;
+    }
+    return -1;
+}
+
 int parse_endorser_transaction(
     uint8_t* tx_data, uint32_t tx_data_len, kvs_t* updates, version_t* tx_version)
 {
@@ -787,3 +933,41 @@ int ledger_get_multi_state_hash(const char* comp_key, uint8_t* out_hash)
     SHA256_Final(out_hash, &sha256);
     return LEDGER_SUCCESS;
 }
+
+int ledger_get_msp_roots(const char* msp_id, std::string& roots)
+{
+    spin_lock(&lock);
+    auto iter = msp_roots.find(msp_id);
+    if (iter == msp_roots.end()) {
+        spin_unlock(&lock);
+        LOG_DEBUG("Ledger: MSP %s not found!", msp_id);
+        return LEDGER_NOT_FOUND;
+    }
+    roots = iter->second;
+    spin_unlock(&lock);
+    return LEDGER_SUCCESS;
+}
+
+int ledger_get_channel_msps(std::string& id, std::vector<std::string>& msps)
+{
+    spin_lock(&lock);
+    if (channel_id.empty()) {
+        spin_unlock(&lock);
+        LOG_DEBUG("Ledger: No channel config yet!");
+        return LEDGER_NOT_FOUND;
+    }
+    id = channel_id;
+    msps.assign(application_msps.begin(), application_msps.end());
+    spin_unlock(&lock);
+    return LEDGER_SUCCESS;
+}
+
+int ledger_get_time(uint64_t* height, int64_t* time)
+{
+    spin_lock(&lock);
+    // the number of blocks validated so far
+    *height = (uint32_t)(sequence_number + 1);
+    *time = ledger_time;
+    spin_unlock(&lock);
+    return LEDGER_SUCCESS;
+}
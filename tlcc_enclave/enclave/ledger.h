@@ -22,6 +22,7 @@
 #include <map>
 #include <set>
 #include <string>
+#include <vector>
 
 #include "sgx_spinlock.h"
 
@@ -31,6 +32,8 @@
 #define LEDGER_ERROR_CRYPTO -5
 #define LEDGER_NOT_FOUND -9
 #define LEDGER_ERROR_OUT_BUFFER_TOO_SMALL -10
+#define LEDGER_INVALID_BLOCK_SIGNATURES -11
+#define LEDGER_UNSUPPORTED_CONSENSUS -12
 
 #define LEDGER_VERIFICATION_FAILED 0
 #define LEDGER_VERIFICATION_SUCCESS 1
@@ -92,6 +95,8 @@ int has_version_conflict(const std::string &key, kvs_t *state, version_t *v);
 
 int parse_block(uint8_t *block_data, uint32_t block_data_len);
 int parse_config(uint8_t *config_data, uint32_t config_data_len);
+int parse_consensus_type(uint8_t *value, uint32_t value_len);
+int required_block_signatures();
 int parse_endorser_transaction(
     uint8_t *tx_data, uint32_t tx_data_len, kvs_t *updates, version_t *version);
 int commit_state_updates(kvs_t *updates, const uint32_t block_sequence_number);
@@ -104,5 +109,11 @@ int free_ledger();
 int ledger_get_state_hash(const char *key, uint8_t *hash);
 int ledger_get_multi_state_hash(const char *comp_key, uint8_t *hash);
 int ledger_verify_state(const char *key, uint8_t *hash, uint32_t hash_len);
+// CA certificates (PEM bundle) of an MSP of the channel, roots and intermediates
+int ledger_get_msp_roots(const char *msp_id, std::string &roots);
+// id of the channel and MSP IDs of its application orgs, in order
+int ledger_get_channel_msps(std::string &channel_id, std::vector<std::string> &msps);
+// block height and ledger time (seconds since the epoch) as of the latest validated block
+int ledger_get_time(uint64_t *height, int64_t *time);
 
 #endif
@@ -16,7 +16,7 @@ mkdir -p $BUILD_DIR
 # compile google protos (timestamp)
 $(protoc "$PROTOC_OPTS" --proto_path="protos" --nanopb_out=$BUILD_DIR protos/google/protobuf/*.proto)
 
-declare -a arr=("common" "ledger" "msp" "peer" "token")
+declare -a arr=("common" "ledger" "msp" "orderer" "peer" "token")
 
 ## now loop through the above array
 for i in "${arr[@]}"
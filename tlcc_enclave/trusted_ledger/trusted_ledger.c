@@ -65,7 +65,7 @@ int tlcc_get_target_info(enclave_id_t eid, target_info_t *target_info) {
 int tlcc_get_local_attestation_report(
     enclave_id_t eid, target_info_t *target_info, report_t *report, ec256_public_t *pubkey) {
     int enclave_ret = -1;
-    int ret = ecall_create_report(eid, &enclave_ret, (sgx_target_info_t *)target_info,
+    int ret = ecall_create_report(eid, &enclave_ret, (sgx_target_info_t *)target_info, NULL,
         (sgx_report_t *)report, (uint8_t *)pubkey);
     if (ret != SGX_SUCCESS) {
         PERR("Lib: ERROR - ecall_create_report: %d", ret);
@@ -137,6 +137,44 @@ int tlcc_get_multi_state_metadata(
     return SGX_SUCCESS;
 }
 
+int tlcc_get_msp_roots(enclave_id_t eid, const char *msp_id, uint8_t *nonce, uint8_t *roots,
+    uint32_t max_roots_len, uint32_t *roots_len, cmac_t *cmac) {
+    int enclave_ret = -1;
+    int ret = ecall_get_msp_roots(
+        eid, (int *)&enclave_ret, msp_id, nonce, roots, max_roots_len, roots_len, cmac);
+    if (ret != SGX_SUCCESS) {
+        PERR("Lib: Error: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int tlcc_get_channel_msps(enclave_id_t eid, uint8_t *nonce, uint8_t *config,
+    uint32_t max_config_len, uint32_t *config_len, cmac_t *cmac) {
+    int enclave_ret = -1;
+    int ret = ecall_get_channel_msps(
+        eid, (int *)&enclave_ret, nonce, config, max_config_len, config_len, cmac);
+    if (ret != SGX_SUCCESS) {
+        PERR("Lib: Error: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
+int tlcc_get_ledger_time(
+    enclave_id_t eid, uint8_t *nonce, uint64_t *height, int64_t *time, cmac_t *cmac) {
+    int enclave_ret = -1;
+    int ret = ecall_get_ledger_time(eid, (int *)&enclave_ret, nonce, height, time, cmac);
+    if (ret != SGX_SUCCESS) {
+        PERR("Lib: Error: %d", ret);
+        return ret;
+    }
+
+    return enclave_ret;
+}
+
 // this is only for debugging
 int tlcc_print_state(enclave_id_t eid) {
     int enclave_ret = -1;
@@ -32,6 +32,20 @@ int tlcc_get_state_metadata(enclave_id_t eid, const char *key, uint8_t *nonce, c
 int tlcc_get_multi_state_metadata(
     enclave_id_t eid, const char *comp_key, uint8_t *nonce, cmac_t *cmac);
 
+// returns the CA certificates of an MSP of the channel as PEM bundle
+int tlcc_get_msp_roots(enclave_id_t eid, const char *msp_id, uint8_t *nonce, uint8_t *roots,
+    uint32_t max_roots_len, uint32_t *roots_len, cmac_t *cmac);
+
+// returns the channel id and the MSP IDs of the application orgs of the channel, each terminated by
+// a newline
+int tlcc_get_channel_msps(enclave_id_t eid, uint8_t *nonce, uint8_t *config,
+    uint32_t max_config_len, uint32_t *config_len, cmac_t *cmac);
+
+// returns the block height and the ledger time (seconds since the epoch), authenticated along with
+// the nonce
+int tlcc_get_ledger_time(
+    enclave_id_t eid, uint8_t *nonce, uint64_t *height, int64_t *time, cmac_t *cmac);
+
 #ifdef __cplusplus
 }
 #endif /* __cplusplus */
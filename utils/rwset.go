@@ -0,0 +1,82 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+)
+
+// SignedRWSet reproduces the read and write set as signed by the enclave from the rwset of the chaincode
+// namespace. Keys are in sgx format and sorted as the enclave uses sorted maps; the write set alternates
+// keys and values.
+func SignedRWSet(rwset *kvrwset.KVRWSet) (readset, writeset [][]byte) {
+	// normal reads
+	var readKeys []string
+	for _, r := range rwset.Reads {
+		readKeys = append(readKeys, TransformToSGX(r.Key, SEP))
+	}
+
+	// range query reads
+	for _, rqi := range rwset.RangeQueriesInfo {
+		for _, qr := range rqi.GetRawReads().KvReads {
+			readKeys = append(readKeys, TransformToSGX(qr.Key, SEP))
+		}
+	}
+
+	// writes
+	var writeKeys []string
+	writesetMap := make(map[string][]byte)
+	for _, w := range rwset.Writes {
+		k := TransformToSGX(w.Key, SEP)
+		writeKeys = append(writeKeys, k)
+		writesetMap[k] = w.Value
+	}
+
+	sort.Strings(readKeys)
+	sort.Strings(writeKeys)
+
+	for _, k := range readKeys {
+		readset = append(readset, []byte(k))
+	}
+	for _, k := range writeKeys {
+		writeset = append(writeset, []byte(k))
+		writeset = append(writeset, writesetMap[k])
+	}
+	return readset, writeset
+}
+
+// RWSetDigest computes the digest the enclave signs over its read and write set (as returned by SignedRWSet):
+// SHA-256(#reads || keys || #writes || keys and values) with all integers 32 bit big endian and all items
+// length-prefixed
+func RWSetDigest(readset, writeset [][]byte) []byte {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, uint32(len(readset)))
+	for _, r := range readset {
+		binary.Write(h, binary.BigEndian, uint32(len(r)))
+		h.Write(r)
+	}
+	binary.Write(h, binary.BigEndian, uint32(len(writeset)/2))
+	for _, w := range writeset {
+		binary.Write(h, binary.BigEndian, uint32(len(w)))
+		h.Write(w)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,44 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package utils
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+)
+
+func TestSignedRWSet(t *testing.T) {
+	rwset := &kvrwset.KVRWSet{
+		Reads:  []*kvrwset.KVRead{{Key: "b"}, {Key: "\x00a\x00"}},
+		Writes: []*kvrwset.KVWrite{{Key: "k", Value: []byte("v1")}},
+	}
+
+	readset, writeset := SignedRWSet(rwset)
+	if len(readset) != 2 || string(readset[0]) != ".a." || string(readset[1]) != "b" {
+		t.Fatalf("Unexpected readset %q", readset)
+	}
+	if len(writeset) != 2 || string(writeset[0]) != "k" || string(writeset[1]) != "v1" {
+		t.Fatalf("Unexpected writeset %q", writeset)
+	}
+
+	// known answer shared with the enclave
+	if d := base64.StdEncoding.EncodeToString(RWSetDigest(readset, writeset)); d != "iE+T21hJviDBSH0fRYS80EqMTTGgo1YPu4mcmcHnegU=" {
+		t.Fatalf("Unexpected rwset digest %s", d)
+	}
+}
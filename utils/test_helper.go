@@ -17,11 +17,23 @@
 package utils
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"plugin"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
 )
 
 func CheckLoadPlugin(t *testing.T, path string) {
@@ -104,3 +116,66 @@ func CheckStateNotNull(t *testing.T, stub *shim.MockStub, name string) {
 		t.Fatalf("State value for  %s is null", name)
 	}
 }
+
+// CreateCreator returns a serialized identity with a fresh self-signed certificate
+func CreateCreator(t *testing.T, mspID, commonName string) []byte {
+	return CreateCreatorWithAttrs(t, mspID, commonName, nil)
+}
+
+// CreateCreatorWithAttrs returns a serialized identity whose certificate carries the given attributes
+// the same way as certificates issued by the fabric ca
+func CreateCreatorWithAttrs(t *testing.T, mspID, commonName string, attrs map[string]string) []byte {
+	creator, _ := createCreator(t, mspID, commonName, attrs, nil)
+	return creator
+}
+
+// CreateCreatorWithOUs returns a serialized identity whose certificate carries the given organizational units, e.g.,
+// the NodeOU role of the identity
+func CreateCreatorWithOUs(t *testing.T, mspID, commonName string, ous ...string) []byte {
+	creator, _ := createCreator(t, mspID, commonName, nil, ous)
+	return creator
+}
+
+// CreateCreatorWithKey returns a serialized identity and its private key, e.g., to sign as the identity
+func CreateCreatorWithKey(t *testing.T, mspID, commonName string) ([]byte, *ecdsa.PrivateKey) {
+	return createCreator(t, mspID, commonName, nil, nil)
+}
+
+// CreateCreatorWithAttrsAndKey is like CreateCreatorWithAttrs but also returns the private key of the identity
+func CreateCreatorWithAttrsAndKey(t *testing.T, mspID, commonName string, attrs map[string]string) ([]byte, *ecdsa.PrivateKey) {
+	return createCreator(t, mspID, commonName, attrs, nil)
+}
+
+func createCreator(t *testing.T, mspID, commonName string, attrs map[string]string, ous []string) ([]byte, *ecdsa.PrivateKey) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{mspID}, OrganizationalUnit: ous},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if attrs != nil {
+		attrsAsBytes, err := json.Marshal(map[string]interface{}{"attrs": attrs})
+		if err != nil {
+			t.Fatalf("Can not marshal attributes: %s", err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: attrsAsBytes}}
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("Can not create certificate: %s", err)
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+	})
+	if err != nil {
+		t.Fatalf("Can not marshal identity: %s", err)
+	}
+	return creator, priv
+}
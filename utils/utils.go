@@ -17,6 +17,7 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"strings"
@@ -31,6 +32,58 @@ type Response struct {
 	ResponseData []byte `json:"ResponseData"`
 	Signature    []byte `json:"Signature"`
 	PublicKey    []byte `json:"PublicKey"`
+	// Proofs are additional evidence of correct execution, e.g., a zk-SNARK, attached next to the enclave
+	// signature. They are not covered by the signature; each proof must bind args and result by itself.
+	Proofs []Proof `json:"Proofs,omitempty"`
+	// Capabilities are the optional protocol features of the enclave, e.g., "zlib" compression; only set in
+	// responses to getEnclavePk
+	Capabilities []string `json:"Capabilities,omitempty"`
+}
+
+// ErrorResponse is the result of a failed invocation. The peer learns the generic Code only; Details is the error,
+// {"code": <code>, "message": <message>}, encrypted with the key of the request if the enclave could decrypt it
+type ErrorResponse struct {
+	Code    int    `json:"Code"`
+	Details []byte `json:"Details,omitempty"`
+}
+
+// codes of failed invocations, see errors.h of the enclave
+const (
+	ErrorInternal = 1 + iota
+	ErrorInvalidRequest
+	ErrorUnauthenticated
+	ErrorReplayed
+	ErrorAccessDenied
+	ErrorLimitExceeded
+	ErrorChaincode
+)
+
+// ErrorResponsePrefix precedes the json encoded ErrorResponse in the message of a failed invocation
+const ErrorResponsePrefix = "ecc: Invocation failed: "
+
+func (e *ErrorResponse) Error() string {
+	encoded, _ := json.Marshal(e)
+	return ErrorResponsePrefix + string(encoded)
+}
+
+// ParseErrorResponse finds the ErrorResponse in the message of a failed invocation, which clients may get wrapped
+// into further context, e.g., by the fabric sdk
+func ParseErrorResponse(message string) (*ErrorResponse, bool) {
+	i := strings.Index(message, ErrorResponsePrefix)
+	if i < 0 {
+		return nil, false
+	}
+	response := &ErrorResponse{}
+	if err := json.NewDecoder(strings.NewReader(message[i+len(ErrorResponsePrefix):])).Decode(response); err != nil {
+		return nil, false
+	}
+	return response, true
+}
+
+// Proof is a verifiable-computation proof of the given type attached to a response
+type Proof struct {
+	Type string `json:"Type"`
+	Data []byte `json:"Data"`
 }
 
 const SEP = "."
@@ -0,0 +1,33 @@
+/*
+* Copyright IBM Corp. 2018 All Rights Reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package utils
+
+import "testing"
+
+func TestParseErrorResponse(t *testing.T) {
+	response := &ErrorResponse{Code: ErrorAccessDenied, Details: []byte("details")}
+	parsed, ok := ParseErrorResponse("rpc error: " + response.Error() + " (status 500)")
+	if !ok || parsed.Code != ErrorAccessDenied || string(parsed.Details) != "details" {
+		t.Fatalf("Error response not parsed: %v", parsed)
+	}
+	if _, ok := ParseErrorResponse("enclave unavailable"); ok {
+		t.Fatalf("Other errors should not parse")
+	}
+	if _, ok := ParseErrorResponse(ErrorResponsePrefix + "{truncated"); ok {
+		t.Fatalf("Malformed error responses should not parse")
+	}
+}